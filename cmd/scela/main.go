@@ -0,0 +1,439 @@
+// Command scela inspects and maintains Scéla message stores.
+//
+// Usage:
+//
+//	scela store stats -file messages.json
+//	scela store stats -sqlite messages.db -table messages -json
+//	scela store stats -jsonl messages.jsonl
+//	scela store stats -wal wal/
+//	scela store compact -file messages.json -keep-last 100 -dry-run
+//	scela store vacuum -sqlite messages.db -older-than 720h
+//	scela store vacuum -wal wal/ -older-than 720h
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/toutaio/toutago-scela-bus/pkg/scela"
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// run executes the command line and returns the process exit code.
+func run(args []string, stdout, stderr io.Writer) int {
+	if len(args) < 2 || args[0] != "store" {
+		usage(stderr)
+		return 2
+	}
+
+	switch args[1] {
+	case "stats":
+		return storeStats(args[2:], stdout, stderr)
+	case "compact":
+		return storeCompact(args[2:], stdout, stderr)
+	case "vacuum":
+		return storeVacuum(args[2:], stdout, stderr)
+	default:
+		usage(stderr)
+		return 2
+	}
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "usage: scela store <command> [flags]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "commands:")
+	fmt.Fprintln(w, "  stats    report message counts, size and time range of a store")
+	fmt.Fprintln(w, "  compact  remove the messages a retention policy does not keep")
+	fmt.Fprintln(w, "  vacuum   delete old messages and reclaim the space of removed ones")
+}
+
+// storeFlags holds the flags shared by all store subcommands.
+type storeFlags struct {
+	file   string
+	sqlite string
+	jsonl  string
+	wal    string
+	table  string
+}
+
+func (f *storeFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.file, "file", "", "path of a FileStore JSON file")
+	fs.StringVar(&f.sqlite, "sqlite", "", "path of a SQLite database used by SQLStore")
+	fs.StringVar(&f.jsonl, "jsonl", "", "path of a JSONLStore JSON Lines file")
+	fs.StringVar(&f.wal, "wal", "", "directory of a WALStore write-ahead log")
+	fs.StringVar(&f.table, "table", "scela_messages", "SQLStore table name")
+}
+
+// open opens the store selected by the flags. The store must exist: the
+// stores would otherwise create an empty one at a mistyped path. The
+// returned cleanup function closes the store and any database connection.
+func (f *storeFlags) open() (scela.MessageStore, func(), error) {
+	selected := 0
+	for _, path := range []string{f.file, f.sqlite, f.jsonl, f.wal} {
+		if path != "" {
+			selected++
+		}
+	}
+	switch selected {
+	case 0:
+		return nil, nil, fmt.Errorf("one of -file, -sqlite, -jsonl or -wal is required")
+	case 1:
+	default:
+		return nil, nil, fmt.Errorf("-file, -sqlite, -jsonl and -wal are mutually exclusive")
+	}
+
+	if _, err := os.Stat(f.path()); err != nil {
+		return nil, nil, err
+	}
+
+	switch {
+	case f.file != "":
+		store := scela.NewFileStore(f.file)
+		return store, func() { _ = store.Close() }, nil
+	case f.jsonl != "":
+		store, err := scela.NewJSONLStore(f.jsonl)
+		if err != nil {
+			return nil, nil, err
+		}
+		return store, func() { _ = store.Close() }, nil
+	case f.wal != "":
+		store, err := scela.NewWALStore(f.wal)
+		if err != nil {
+			return nil, nil, err
+		}
+		return store, func() { _ = store.Close() }, nil
+	default:
+		db, err := sql.Open("sqlite3", f.sqlite)
+		if err != nil {
+			return nil, nil, err
+		}
+		store, err := scela.NewSQLStore(scela.SQLStoreConfig{DB: db, TableName: f.table})
+		if err != nil {
+			_ = db.Close()
+			return nil, nil, err
+		}
+		return store, func() { _ = store.Close(); _ = db.Close() }, nil
+	}
+}
+
+// path returns the file, or directory, holding the store selected by the
+// flags.
+func (f *storeFlags) path() string {
+	switch {
+	case f.file != "":
+		return f.file
+	case f.jsonl != "":
+		return f.jsonl
+	case f.wal != "":
+		return f.wal
+	default:
+		return f.sqlite
+	}
+}
+
+// size returns the size of the file holding the store, or of the segment
+// files of a write-ahead log, or -1 if it cannot be read.
+func (f *storeFlags) size() int64 {
+	info, err := os.Stat(f.path())
+	if err != nil {
+		return -1
+	}
+	if !info.IsDir() {
+		return info.Size()
+	}
+
+	entries, err := os.ReadDir(f.path())
+	if err != nil {
+		return -1
+	}
+	var size int64
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			return -1
+		}
+		if info.Mode().IsRegular() {
+			size += info.Size()
+		}
+	}
+	return size
+}
+
+func storeStats(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("store stats", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+
+	var sf storeFlags
+	sf.register(fs)
+	asJSON := fs.Bool("json", false, "print statistics as JSON")
+
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	store, cleanup, err := sf.open()
+	if err != nil {
+		fmt.Fprintf(stderr, "scela: %v\n", err)
+		return 1
+	}
+	defer cleanup()
+
+	ss, ok := store.(scela.StoreStats)
+	if !ok {
+		fmt.Fprintln(stderr, "scela: store does not report statistics")
+		return 1
+	}
+
+	stats, err := ss.Stats(context.Background())
+	if err != nil {
+		fmt.Fprintf(stderr, "scela: %v\n", err)
+		return 1
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(stats); err != nil {
+			fmt.Fprintf(stderr, "scela: %v\n", err)
+			return 1
+		}
+		return 0
+	}
+
+	printStats(stdout, stats)
+	return 0
+}
+
+func printStats(w io.Writer, stats scela.StoreStatistics) {
+	fmt.Fprintf(w, "messages: %d\n", stats.MessageCount)
+	fmt.Fprintf(w, "size:     %d bytes\n", stats.SizeBytes)
+	if stats.MessageCount > 0 {
+		fmt.Fprintf(w, "oldest:   %s\n", stats.Oldest.Format(time.RFC3339))
+		fmt.Fprintf(w, "newest:   %s\n", stats.Newest.Format(time.RFC3339))
+	}
+
+	if len(stats.TopicCounts) == 0 {
+		return
+	}
+
+	topics := make([]string, 0, len(stats.TopicCounts))
+	for topic := range stats.TopicCounts {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+
+	fmt.Fprintln(w, "topics:")
+	for _, topic := range topics {
+		fmt.Fprintf(w, "  %-30s %d\n", topic, stats.TopicCounts[topic])
+	}
+}
+
+func storeCompact(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("store compact", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+
+	var sf storeFlags
+	sf.register(fs)
+	keepLast := fs.Int("keep-last", 0, "keep the last `n` messages of each topic")
+	keepWithin := fs.Duration("keep-within", 0, "keep the messages published within this `duration`")
+	keepLatest := fs.String("keep-latest", "", "keep the last message of each value of this metadata `key`")
+	dryRun := fs.Bool("dry-run", false, "report what would be removed without changing the store")
+
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	var policies []scela.RetentionPolicy
+	if *keepLast > 0 {
+		policies = append(policies, scela.KeepLastPerTopic(*keepLast))
+	}
+	if *keepWithin > 0 {
+		policies = append(policies, scela.KeepWithin(*keepWithin))
+	}
+	if *keepLatest != "" {
+		policies = append(policies, scela.KeepLatestPerKey(scela.MetadataKey(*keepLatest)))
+	}
+	if len(policies) == 0 {
+		fmt.Fprintln(stderr, "scela: one of -keep-last, -keep-within or -keep-latest is required")
+		return 2
+	}
+	policy := scela.CombineRetention(policies...)
+
+	store, cleanup, err := sf.open()
+	if err != nil {
+		fmt.Fprintf(stderr, "scela: %v\n", err)
+		return 1
+	}
+	defer cleanup()
+
+	rs, ok := store.(scela.RewritableStore)
+	if !ok {
+		fmt.Fprintln(stderr, "scela: store does not support compaction")
+		return 1
+	}
+
+	ctx := context.Background()
+	fmt.Fprintln(stdout, "loading messages...")
+	if *dryRun {
+		msgs, err := store.Load(ctx)
+		if err != nil {
+			fmt.Fprintf(stderr, "scela: %v\n", err)
+			return 1
+		}
+		printCompaction(stdout, msgs, policy.Retain(msgs, time.Now()), true)
+		return 0
+	}
+
+	before := sf.size()
+	err = rs.Rewrite(ctx, func(msgs []scela.Message) ([]scela.Message, error) {
+		kept := policy.Retain(msgs, time.Now())
+		printCompaction(stdout, msgs, kept, false)
+		fmt.Fprintln(stdout, "rewriting store...")
+		return kept, nil
+	})
+	if err != nil {
+		fmt.Fprintf(stderr, "scela: %v\n", err)
+		return 1
+	}
+	printSize(stdout, before, sf.size())
+	return 0
+}
+
+// printCompaction reports the messages of msgs a compaction removes, those
+// not in kept, per topic.
+func printCompaction(w io.Writer, msgs, kept []scela.Message, dryRun bool) {
+	removed := make(map[string]int)
+	for _, msg := range msgs {
+		removed[msg.Topic()]++
+	}
+	for _, msg := range kept {
+		removed[msg.Topic()]--
+	}
+
+	topics := make([]string, 0, len(removed))
+	for topic, n := range removed {
+		if n > 0 {
+			topics = append(topics, topic)
+		}
+	}
+	sort.Strings(topics)
+
+	verb := "removing"
+	if dryRun {
+		verb = "would remove"
+	}
+	fmt.Fprintf(w, "%s %d of %d messages\n", verb, len(msgs)-len(kept), len(msgs))
+	for _, topic := range topics {
+		fmt.Fprintf(w, "  %-30s %d\n", topic, removed[topic])
+	}
+}
+
+func storeVacuum(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("store vacuum", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+
+	var sf storeFlags
+	sf.register(fs)
+	olderThan := fs.Duration("older-than", 0, "first delete the messages published more than this `duration` ago")
+	dryRun := fs.Bool("dry-run", false, "report what would be deleted without changing the store")
+
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	store, cleanup, err := sf.open()
+	if err != nil {
+		fmt.Fprintf(stderr, "scela: %v\n", err)
+		return 1
+	}
+	defer cleanup()
+
+	ctx := context.Background()
+	before := sf.size()
+
+	if *olderThan > 0 {
+		cutoff := time.Now().Add(-*olderThan)
+		if *dryRun {
+			msgs, err := store.Load(ctx)
+			if err != nil {
+				fmt.Fprintf(stderr, "scela: %v\n", err)
+				return 1
+			}
+			printCompaction(stdout, msgs, scela.KeepSince(cutoff).Retain(msgs, time.Now()), true)
+		} else {
+			fmt.Fprintf(stdout, "deleting messages published before %s...\n", cutoff.Format(time.RFC3339))
+			if err := clearBefore(ctx, store, cutoff); err != nil {
+				fmt.Fprintf(stderr, "scela: %v\n", err)
+				return 1
+			}
+		}
+	}
+
+	vacuum, ok := vacuumer(store)
+	switch {
+	case !ok:
+		fmt.Fprintln(stdout, "store is rewritten on every write, nothing to vacuum")
+	case *dryRun:
+		fmt.Fprintf(stdout, "would vacuum %s (%d bytes)\n", sf.path(), before)
+		return 0
+	default:
+		fmt.Fprintln(stdout, "vacuuming...")
+		if err := vacuum(ctx); err != nil {
+			fmt.Fprintf(stderr, "scela: %v\n", err)
+			return 1
+		}
+	}
+
+	if !*dryRun {
+		printSize(stdout, before, sf.size())
+	}
+	return 0
+}
+
+// vacuumer returns the operation reclaiming the space of the messages
+// removed from store: a VACUUM of a VacuumableStore, a compaction of a
+// JSONLStore or a checkpoint of a WALStore. It reports false for stores
+// rewritten on every write.
+func vacuumer(store scela.MessageStore) (func(context.Context) error, bool) {
+	switch s := store.(type) {
+	case scela.VacuumableStore:
+		return s.Vacuum, true
+	case *scela.JSONLStore:
+		return s.Compact, true
+	case *scela.WALStore:
+		return s.Checkpoint, true
+	default:
+		return nil, false
+	}
+}
+
+// clearBefore deletes the messages of store published before cutoff, with a
+// single DELETE on stores that support it.
+func clearBefore(ctx context.Context, store scela.MessageStore, cutoff time.Time) error {
+	if qs, ok := store.(scela.QueryableStore); ok {
+		return qs.ClearBefore(ctx, cutoff)
+	}
+	_, err := scela.CompactStore(ctx, store, scela.KeepSince(cutoff))
+	return err
+}
+
+// printSize reports the size of the store file before and after a change.
+func printSize(w io.Writer, before, after int64) {
+	if before < 0 || after < 0 {
+		return
+	}
+	fmt.Fprintf(w, "size: %d -> %d bytes\n", before, after)
+}
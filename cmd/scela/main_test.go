@@ -0,0 +1,275 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/toutaio/toutago-scela-bus/pkg/scela"
+)
+
+func TestStoreStats(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "messages.json")
+	store := scela.NewFileStore(path)
+	ctx := context.Background()
+	store.Store(ctx, scela.NewMessage("orders.created", 1))
+	store.Store(ctx, scela.NewMessage("orders.created", 2))
+	store.Store(ctx, scela.NewMessage("users.created", 3))
+
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"store", "stats", "-file", path}, &stdout, &stderr); code != 0 {
+		t.Fatalf("run() = %d, stderr: %s", code, stderr.String())
+	}
+
+	out := stdout.String()
+	if !strings.Contains(out, "messages: 3") {
+		t.Errorf("Expected message count in output, got:\n%s", out)
+	}
+	if !strings.Contains(out, "orders.created") || !strings.Contains(out, "users.created") {
+		t.Errorf("Expected per-topic counts in output, got:\n%s", out)
+	}
+}
+
+func TestStoreStatsJSON(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "stats.db")
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	if _, err := scela.NewSQLStore(scela.SQLStoreConfig{DB: db, TableName: "scela_messages"}); err != nil {
+		t.Fatalf("Failed to create SQL store: %v", err)
+	}
+	db.Close()
+
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"store", "stats", "-sqlite", path, "-json"}, &stdout, &stderr); code != 0 {
+		t.Fatalf("run() = %d, stderr: %s", code, stderr.String())
+	}
+
+	var stats scela.StoreStatistics
+	if err := json.Unmarshal(stdout.Bytes(), &stats); err != nil {
+		t.Fatalf("Invalid JSON output: %v", err)
+	}
+	if stats.MessageCount != 0 {
+		t.Errorf("Expected empty store, got %d messages", stats.MessageCount)
+	}
+}
+
+func TestStoreStatsAppendOnlyStores(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.Background()
+
+	jsonl, err := scela.NewJSONLStore(filepath.Join(dir, "messages.jsonl"))
+	if err != nil {
+		t.Fatalf("Failed to create JSONL store: %v", err)
+	}
+	wal, err := scela.NewWALStore(filepath.Join(dir, "wal"))
+	if err != nil {
+		t.Fatalf("Failed to create WAL store: %v", err)
+	}
+	for _, store := range []scela.MessageStore{jsonl, wal} {
+		store.Store(ctx, scela.NewMessage("orders.created", 1))
+		store.Store(ctx, scela.NewMessage("users.created", 2))
+	}
+	jsonl.Close()
+	wal.Close()
+
+	for _, flag := range []string{"-jsonl", "-wal"} {
+		path := filepath.Join(dir, "messages.jsonl")
+		if flag == "-wal" {
+			path = filepath.Join(dir, "wal")
+		}
+
+		var stdout, stderr bytes.Buffer
+		if code := run([]string{"store", "stats", flag, path}, &stdout, &stderr); code != 0 {
+			t.Fatalf("run(%s) = %d, stderr: %s", flag, code, stderr.String())
+		}
+		if out := stdout.String(); !strings.Contains(out, "messages: 2") || !strings.Contains(out, "users.created") {
+			t.Errorf("Expected the %s store statistics, got:\n%s", flag, out)
+		}
+	}
+}
+
+func TestStoreMissingPath(t *testing.T) {
+	dir := t.TempDir()
+
+	for _, flag := range []string{"-file", "-sqlite", "-jsonl", "-wal"} {
+		path := filepath.Join(dir, "missing")
+
+		var stdout, stderr bytes.Buffer
+		if code := run([]string{"store", "stats", flag, path}, &stdout, &stderr); code != 1 {
+			t.Errorf("run(%s) on a missing store = %d, want 1", flag, code)
+		}
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("Expected %s not to create a store, stat: %v", flag, err)
+		}
+	}
+}
+
+func TestStoreCompact(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "messages.json")
+	store := scela.NewFileStore(path)
+	ctx := context.Background()
+	for i := 0; i < 3; i++ {
+		store.Store(ctx, scela.NewMessage("orders.created", i))
+	}
+	store.Store(ctx, scela.NewMessage("users.created", 3))
+
+	var stdout, stderr bytes.Buffer
+	args := []string{"store", "compact", "-file", path, "-keep-last", "1"}
+	if code := run(append(args, "-dry-run"), &stdout, &stderr); code != 0 {
+		t.Fatalf("run() = %d, stderr: %s", code, stderr.String())
+	}
+	if out := stdout.String(); !strings.Contains(out, "would remove 2 of 4 messages") || !strings.Contains(out, "orders.created") {
+		t.Errorf("Expected the removals reported, got:\n%s", out)
+	}
+	if msgs, _ := scela.NewFileStore(path).Load(ctx); len(msgs) != 4 {
+		t.Fatalf("Expected a dry run to keep 4 messages, got %d", len(msgs))
+	}
+
+	stdout.Reset()
+	if code := run(args, &stdout, &stderr); code != 0 {
+		t.Fatalf("run() = %d, stderr: %s", code, stderr.String())
+	}
+	if out := stdout.String(); !strings.Contains(out, "removing 2 of 4 messages") {
+		t.Errorf("Expected the removals reported, got:\n%s", out)
+	}
+	if msgs, _ := scela.NewFileStore(path).Load(ctx); len(msgs) != 2 {
+		t.Errorf("Expected 2 messages left, got %d", len(msgs))
+	}
+
+	if code := run([]string{"store", "compact", "-file", path}, &stdout, &stderr); code != 2 {
+		t.Errorf("run() without a policy = %d, want 2", code)
+	}
+}
+
+func TestStoreVacuum(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "messages.db")
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	store, err := scela.NewSQLStore(scela.SQLStoreConfig{DB: db, TableName: "scela_messages"})
+	if err != nil {
+		t.Fatalf("Failed to create SQL store: %v", err)
+	}
+	ctx := context.Background()
+	for i := 0; i < 100; i++ {
+		msg := scela.NewMessage("orders.created", strings.Repeat("x", 1024))
+		if i < 90 {
+			msg = &agedMessage{Message: msg, age: 48 * time.Hour}
+		}
+		store.Store(ctx, msg)
+	}
+	db.Close()
+
+	var stdout, stderr bytes.Buffer
+	args := []string{"store", "vacuum", "-sqlite", path, "-older-than", "24h"}
+	if code := run(append(args, "-dry-run"), &stdout, &stderr); code != 0 {
+		t.Fatalf("run() = %d, stderr: %s", code, stderr.String())
+	}
+	if out := stdout.String(); !strings.Contains(out, "would remove 90 of 100 messages") || !strings.Contains(out, "would vacuum") {
+		t.Errorf("Expected the deletions reported, got:\n%s", out)
+	}
+
+	stdout.Reset()
+	if code := run(args, &stdout, &stderr); code != 0 {
+		t.Fatalf("run() = %d, stderr: %s", code, stderr.String())
+	}
+	if out := stdout.String(); !strings.Contains(out, "vacuuming...") || !strings.Contains(out, "size:") {
+		t.Errorf("Expected the vacuum reported, got:\n%s", out)
+	}
+
+	stdout.Reset()
+	if code := run([]string{"store", "stats", "-sqlite", path}, &stdout, &stderr); code != 0 {
+		t.Fatalf("run() = %d, stderr: %s", code, stderr.String())
+	}
+	if out := stdout.String(); !strings.Contains(out, "messages: 10") {
+		t.Errorf("Expected 10 messages left, got:\n%s", out)
+	}
+}
+
+func TestStoreVacuumAppendOnlyStores(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.Background()
+
+	jsonl, err := scela.NewJSONLStore(filepath.Join(dir, "messages.jsonl"))
+	if err != nil {
+		t.Fatalf("Failed to create JSONL store: %v", err)
+	}
+	wal, err := scela.NewWALStore(filepath.Join(dir, "wal"))
+	if err != nil {
+		t.Fatalf("Failed to create WAL store: %v", err)
+	}
+	for _, store := range []scela.MessageStore{jsonl, wal} {
+		for i := 0; i < 100; i++ {
+			msg := scela.NewMessage("orders.created", strings.Repeat("x", 1024))
+			if i < 90 {
+				msg = &agedMessage{Message: msg, age: 48 * time.Hour}
+			}
+			store.Store(ctx, msg)
+		}
+	}
+	jsonl.Close()
+	wal.Close()
+
+	for _, flag := range []string{"-jsonl", "-wal"} {
+		path := filepath.Join(dir, "messages.jsonl")
+		if flag == "-wal" {
+			path = filepath.Join(dir, "wal")
+		}
+
+		var stdout, stderr bytes.Buffer
+		if code := run([]string{"store", "vacuum", flag, path, "-older-than", "24h"}, &stdout, &stderr); code != 0 {
+			t.Fatalf("run(%s) = %d, stderr: %s", flag, code, stderr.String())
+		}
+		var before, after int64
+		for _, line := range strings.Split(stdout.String(), "\n") {
+			if strings.HasPrefix(line, "size:") {
+				fmt.Sscanf(line, "size: %d -> %d bytes", &before, &after)
+			}
+		}
+		if !strings.Contains(stdout.String(), "vacuuming...") || after <= 0 || after >= before {
+			t.Errorf("Expected the %s store vacuumed, got:\n%s", flag, stdout.String())
+		}
+
+		stdout.Reset()
+		if code := run([]string{"store", "compact", flag, path, "-keep-last", "1"}, &stdout, &stderr); code != 0 {
+			t.Fatalf("run(%s) = %d, stderr: %s", flag, code, stderr.String())
+		}
+		if out := stdout.String(); !strings.Contains(out, "removing 9 of 10 messages") {
+			t.Errorf("Expected the %s store compacted, got:\n%s", flag, out)
+		}
+	}
+}
+
+// agedMessage is a message published age ago.
+type agedMessage struct {
+	scela.Message
+	age time.Duration
+}
+
+func (m *agedMessage) Timestamp() time.Time {
+	return m.Message.Timestamp().Add(-m.age)
+}
+
+func TestUsageErrors(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+
+	if code := run(nil, &stdout, &stderr); code != 2 {
+		t.Errorf("run() without arguments = %d, want 2", code)
+	}
+	if code := run([]string{"store", "stats"}, &stdout, &stderr); code != 1 {
+		t.Errorf("run() without a store = %d, want 1", code)
+	}
+	if code := run([]string{"store", "stats", "-file", "a.json", "-wal", "wal"}, &stdout, &stderr); code != 1 {
+		t.Errorf("run() with two stores = %d, want 1", code)
+	}
+}
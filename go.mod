@@ -2,4 +2,10 @@ module github.com/toutaio/toutago-scela-bus
 
 go 1.22.9
 
-require github.com/mattn/go-sqlite3 v1.14.33
+require (
+	github.com/go-sql-driver/mysql v1.8.1
+	github.com/lib/pq v1.10.9
+	github.com/mattn/go-sqlite3 v1.14.33
+)
+
+require filippo.io/edwards25519 v1.1.0 // indirect
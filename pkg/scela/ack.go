@@ -0,0 +1,422 @@
+package scela
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// DefaultVisibilityTimeout is the time a handler has to acknowledge a
+// message under WithManualAck when no timeout is given.
+const DefaultVisibilityTimeout = 30 * time.Second
+
+var (
+	// ErrNotAcknowledged is the error a message is retried with when it was
+	// not acknowledged within its visibility timeout, and reported for
+	// messages still unacknowledged when the bus closes.
+	ErrNotAcknowledged = errors.New("message not acknowledged")
+	// ErrNacked is the error a message is retried with when it is nacked
+	// without an error.
+	ErrNacked = errors.New("message nacked")
+	// ErrAlreadyAcknowledged is returned by Ack and Nack once the message
+	// was acked, nacked or timed out.
+	ErrAlreadyAcknowledged = errors.New("message already acknowledged")
+)
+
+// Acker acknowledges a message delivered to a subscription created with
+// WithManualAck. Its methods are safe to call from any goroutine.
+type Acker interface {
+	// Ack reports the message as processed.
+	Ack() error
+	// Nack reports the message as failed with err, sending it through the
+	// retry policy and, once exhausted, to the dead letter handler.
+	Nack(err error) error
+	// Extend pushes the visibility deadline of the message to at least d
+	// from now, for work that is still making progress. Calling it
+	// periodically keeps slow work from being retried, while work that
+	// hangs stops extending and times out.
+	Extend(d time.Duration) error
+}
+
+// ackerContextKey is the context key under which the Acker of the message
+// being handled is stored.
+type ackerContextKey struct{}
+
+// AckerFromContext returns the Acker of the message being handled. It
+// reports false unless the subscription was created with WithManualAck.
+func AckerFromContext(ctx context.Context) (Acker, bool) {
+	a, ok := ctx.Value(ackerContextKey{}).(*acker)
+	return a, ok
+}
+
+// WithManualAck makes the subscription acknowledge messages explicitly,
+// for at-least-once delivery of work that outlives the handler call. The
+// handler gets an Acker from AckerFromContext:
+//
+//   - returning an error, or calling Nack, retries the message;
+//   - calling Ack, before or after returning, completes it;
+//   - a message neither acked nor nacked within visibilityTimeout
+//     (DefaultVisibilityTimeout if zero or less) of the handler returning
+//     is retried with ErrNotAcknowledged, by another member for queue
+//     groups (see WithQueueGroup);
+//   - calling Extend, during or after the handler call, pushes that
+//     deadline back.
+//
+// Messages published with a partition key wait for their acknowledgement
+// before the next message with their key is delivered. Messages still
+// unacknowledged when the bus closes are reported to observers with
+// ErrNotAcknowledged.
+func WithManualAck(visibilityTimeout time.Duration) SubscriptionOption {
+	return func(cfg *subscriptionConfig) {
+		if visibilityTimeout <= 0 {
+			visibilityTimeout = DefaultVisibilityTimeout
+		}
+		cfg.manualAck = true
+		cfg.visibilityTimeout = visibilityTimeout
+	}
+}
+
+// ackState is the state of an acknowledgement.
+type ackState int
+
+const (
+	ackOpen ackState = iota
+	ackAcked
+	ackNacked
+)
+
+// acker implements Acker for one delivery.
+type acker struct {
+	mu    sync.Mutex
+	state ackState
+	err   error
+	timer *time.Timer
+	// deadline is when an armed delivery times out, or the deadline set by
+	// Extend before it is armed.
+	deadline time.Time
+
+	// done is closed once the delivery is acked or nacked.
+	done chan struct{}
+
+	// onNack is called, outside the lock, when the delivery is nacked or
+	// times out after being armed, and onAck when it is acked.
+	onNack func(err error)
+	onAck  func()
+}
+
+// newAcker creates a pending acknowledgement.
+func newAcker() *acker {
+	return &acker{done: make(chan struct{})}
+}
+
+// Ack implements Acker.
+func (a *acker) Ack() error {
+	a.mu.Lock()
+	if a.state != ackOpen {
+		a.mu.Unlock()
+		return ErrAlreadyAcknowledged
+	}
+	a.settleLocked(ackAcked, nil)
+	onAck := a.onAck
+	a.mu.Unlock()
+
+	if onAck != nil {
+		onAck()
+	}
+	return nil
+}
+
+// Nack implements Acker.
+func (a *acker) Nack(err error) error {
+	if err == nil {
+		err = ErrNacked
+	}
+	return a.fail(err)
+}
+
+// Extend implements Acker.
+func (a *acker) Extend(d time.Duration) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.state != ackOpen {
+		return ErrAlreadyAcknowledged
+	}
+	if deadline := time.Now().Add(d); deadline.After(a.deadline) {
+		a.deadline = deadline
+	}
+	return nil
+}
+
+// extendDeadlineLocked starts the visibility timeout, keeping a later
+// deadline set by Extend. Must be called with the lock held.
+func (a *acker) extendDeadlineLocked(timeout time.Duration) {
+	if deadline := time.Now().Add(timeout); deadline.After(a.deadline) {
+		a.deadline = deadline
+	}
+}
+
+// remainingLocked returns the time left before the deadline, or zero once
+// it has passed. Must be called with the lock held.
+func (a *acker) remainingLocked() time.Duration {
+	if d := time.Until(a.deadline); d > 0 {
+		return d
+	}
+	return 0
+}
+
+// expire nacks the delivery with ErrNotAcknowledged if its deadline has
+// passed, and otherwise rearms the timer for the rest of it.
+func (a *acker) expire() {
+	a.mu.Lock()
+	if a.state != ackOpen {
+		a.mu.Unlock()
+		return
+	}
+	if remaining := a.remainingLocked(); remaining > 0 {
+		// Extended since the timer was set
+		a.timer.Reset(remaining)
+		a.mu.Unlock()
+		return
+	}
+	a.mu.Unlock()
+
+	_ = a.fail(ErrNotAcknowledged)
+}
+
+// fail nacks the delivery with err and runs the armed callback.
+func (a *acker) fail(err error) error {
+	a.mu.Lock()
+	if a.state != ackOpen {
+		a.mu.Unlock()
+		return ErrAlreadyAcknowledged
+	}
+	a.settleLocked(ackNacked, err)
+	onNack := a.onNack
+	a.mu.Unlock()
+
+	if onNack != nil {
+		onNack(err)
+	}
+	return nil
+}
+
+// settleLocked records the outcome. Must be called with the lock held.
+func (a *acker) settleLocked(state ackState, err error) {
+	a.state, a.err = state, err
+	if a.timer != nil {
+		a.timer.Stop()
+	}
+	close(a.done)
+}
+
+// handlerReturned records the result of the handler call. It returns the
+// handler error, the error of a Nack made during the call, or an
+// *ackPending if the message is not acknowledged yet.
+func (a *acker) handlerReturned(err error) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if err != nil {
+		if a.state == ackOpen {
+			a.settleLocked(ackNacked, err)
+		}
+		return err
+	}
+	switch a.state {
+	case ackAcked:
+		return nil
+	case ackNacked:
+		return a.err
+	default:
+		return &ackPending{acker: a}
+	}
+}
+
+// arm calls onNack if the delivery is nacked, or not acknowledged within
+// timeout, and onAck if it is acked.
+func (a *acker) arm(timeout time.Duration, onNack func(err error), onAck func()) {
+	a.mu.Lock()
+	if a.state != ackOpen {
+		// Settled since the handler returned
+		state, err := a.state, a.err
+		a.mu.Unlock()
+		if state == ackNacked {
+			onNack(err)
+		} else {
+			onAck()
+		}
+		return
+	}
+	a.onNack, a.onAck = onNack, onAck
+	a.extendDeadlineLocked(timeout)
+	a.timer = time.AfterFunc(a.remainingLocked(), a.expire)
+	a.mu.Unlock()
+}
+
+// wait blocks until the delivery is settled or timeout passes. It returns
+// nil once acked, and the error to retry with otherwise. It reports stopped
+// if it gave up because stop was closed.
+func (a *acker) wait(timeout time.Duration, stop <-chan struct{}) (stopped bool, err error) {
+	a.mu.Lock()
+	a.extendDeadlineLocked(timeout)
+	timer := time.NewTimer(a.remainingLocked())
+	a.mu.Unlock()
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-a.done:
+		case <-timer.C:
+			a.mu.Lock()
+			remaining := a.remainingLocked()
+			a.mu.Unlock()
+			if remaining > 0 {
+				// Extended since the timer was set
+				timer.Reset(remaining)
+				continue
+			}
+			_ = a.fail(ErrNotAcknowledged)
+		case <-stop:
+			stopped = a.cancel()
+		}
+		break
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return stopped, a.err
+}
+
+// cancel abandons the delivery without running the armed callback. It
+// reports whether the delivery was still pending.
+func (a *acker) cancel() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.state != ackOpen {
+		return false
+	}
+	a.settleLocked(ackNacked, ErrNotAcknowledged)
+	return true
+}
+
+// ackPending is returned by subscription.handle when a handler returned
+// without acknowledging its message.
+type ackPending struct {
+	acker *acker
+}
+
+// Error implements the error interface.
+func (p *ackPending) Error() string {
+	return "acknowledgement pending"
+}
+
+// pendingAck is a delivery waiting for its acknowledgement.
+type pendingAck struct {
+	sub   *subscription
+	acker *acker
+}
+
+// ackTracker keeps the armed acknowledgements of a bus so that Close can
+// abandon them.
+type ackTracker struct {
+	mu      sync.Mutex
+	closed  bool
+	pending map[*acker]Message
+
+	// retries counts the retries of nacked messages in progress.
+	retries sync.WaitGroup
+}
+
+// newAckTracker creates an empty tracker.
+func newAckTracker() *ackTracker {
+	return &ackTracker{pending: make(map[*acker]Message)}
+}
+
+// awaitAcks arranges for the deliveries of env still waiting for their
+// acknowledgement to be retried if they are nacked or time out. Keyed
+// messages are waited for, so that the next message with their key is not
+// delivered before.
+func (b *bus) awaitAcks(env *Envelope, pending []pendingAck) {
+	for _, p := range pending {
+		retry := &Envelope{
+			msg:      env.msg,
+			retries:  env.retries,
+			priority: env.priority,
+			sub:      p.sub,
+			ctx:      env.ctx,
+			lane:     env.lane,
+			delivery: env.delivery,
+		}
+		timeout := p.sub.config.visibilityTimeout
+
+		if env.lane != nil {
+			stopped, err := p.acker.wait(timeout, b.laneStop)
+			if stopped {
+				b.observers.NotifyMessageProcessed(context.Background(), env.msg, ErrNotAcknowledged)
+			} else if err != nil {
+				retry.err = err
+				b.handleError(retry)
+			} else {
+				retry.delivery.finish(retry.msg)
+			}
+			continue
+		}
+		b.armAck(p.acker, timeout, retry)
+	}
+}
+
+// armAck retries env when a is nacked or times out.
+func (b *bus) armAck(a *acker, timeout time.Duration, env *Envelope) {
+	t := b.acks
+	t.mu.Lock()
+	if t.closed {
+		t.mu.Unlock()
+		if a.cancel() {
+			b.observers.NotifyMessageProcessed(context.Background(), env.msg, ErrNotAcknowledged)
+		}
+		return
+	}
+	t.pending[a] = env.msg
+	t.mu.Unlock()
+
+	a.arm(timeout, func(err error) {
+		t.mu.Lock()
+		if t.closed {
+			t.mu.Unlock()
+			return
+		}
+		delete(t.pending, a)
+		t.retries.Add(1)
+		t.mu.Unlock()
+		defer t.retries.Done()
+
+		env.err = err
+		b.handleError(env)
+	}, func() {
+		t.mu.Lock()
+		delete(t.pending, a)
+		t.mu.Unlock()
+		env.delivery.finish(env.msg)
+	})
+}
+
+// closeAcks abandons the deliveries still waiting for their acknowledgement
+// and waits for the retries of nacked ones to be queued.
+func (b *bus) closeAcks() {
+	t := b.acks
+	t.mu.Lock()
+	t.closed = true
+	pending := t.pending
+	t.pending = nil
+	t.mu.Unlock()
+
+	for a, msg := range pending {
+		if a.cancel() {
+			b.observers.NotifyMessageProcessed(context.Background(), msg, ErrNotAcknowledged)
+		}
+	}
+	t.retries.Wait()
+}
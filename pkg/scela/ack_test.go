@@ -0,0 +1,286 @@
+package scela
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestManualAck_AckAfterReturn(t *testing.T) {
+	b := New()
+	defer b.Close()
+
+	acks := make(chan Acker, 1)
+	var calls atomic.Int32
+	_, _ = b.SubscribeWithOptions("jobs", HandlerFunc(func(ctx context.Context, msg Message) error {
+		calls.Add(1)
+		a, ok := AckerFromContext(ctx)
+		if !ok {
+			t.Error("expected an Acker in the handler context")
+		}
+		acks <- a
+		return nil
+	}), WithManualAck(50*time.Millisecond))
+
+	_ = b.Publish(context.Background(), "jobs", "work")
+	a := <-acks
+	if err := a.Ack(); err != nil {
+		t.Fatalf("Ack() error = %v", err)
+	}
+	if err := a.Ack(); !errors.Is(err, ErrAlreadyAcknowledged) {
+		t.Errorf("expected ErrAlreadyAcknowledged on a second Ack, got %v", err)
+	}
+	if err := a.Nack(nil); !errors.Is(err, ErrAlreadyAcknowledged) {
+		t.Errorf("expected ErrAlreadyAcknowledged on Nack after Ack, got %v", err)
+	}
+
+	// Past the visibility timeout the message is not redelivered
+	time.Sleep(100 * time.Millisecond)
+	if calls.Load() != 1 {
+		t.Errorf("expected 1 delivery, got %d", calls.Load())
+	}
+}
+
+func TestManualAck_NackRetriesThenDeadLetters(t *testing.T) {
+	dead := make(chan Message, 1)
+	b := New(
+		WithMaxRetries(3),
+		WithDeadLetterHandler(HandlerFunc(func(ctx context.Context, msg Message) error {
+			dead <- msg
+			return nil
+		})),
+	)
+	defer b.Close()
+
+	var calls atomic.Int32
+	nackErr := errors.New("downstream unavailable")
+	_, _ = b.SubscribeWithOptions("jobs", HandlerFunc(func(ctx context.Context, msg Message) error {
+		calls.Add(1)
+		a, _ := AckerFromContext(ctx)
+		go func() { _ = a.Nack(nackErr) }()
+		return nil
+	}), WithManualAck(time.Second))
+
+	_ = b.Publish(context.Background(), "jobs", "work")
+
+	select {
+	case msg := <-dead:
+		if msg.Payload() != "work" {
+			t.Errorf("unexpected dead letter %v", msg.Payload())
+		}
+	case <-time.After(time.Second):
+		t.Fatal("message not dead-lettered")
+	}
+	if calls.Load() != 3 {
+		t.Errorf("expected 3 deliveries, got %d", calls.Load())
+	}
+}
+
+func TestManualAck_TimeoutRedelivers(t *testing.T) {
+	obs := &retryRecorder{}
+	b := New(WithMaxRetries(5), WithObserver(obs))
+	defer b.Close()
+
+	var calls atomic.Int32
+	_, _ = b.SubscribeWithOptions("jobs", HandlerFunc(func(ctx context.Context, msg Message) error {
+		// Only the second delivery is acknowledged
+		if calls.Add(1) == 2 {
+			a, _ := AckerFromContext(ctx)
+			return a.Ack()
+		}
+		return nil
+	}), WithManualAck(20*time.Millisecond))
+
+	_ = b.Publish(context.Background(), "jobs", "work")
+	waitFor(t, func() bool { return calls.Load() == 2 })
+
+	if err := obs.lastErr(); !errors.Is(err, ErrNotAcknowledged) {
+		t.Errorf("expected a retry with ErrNotAcknowledged, got %v", err)
+	}
+}
+
+func TestManualAck_ExtendKeepsSlowWork(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		keyed bool
+	}{{"unkeyed", false}, {"keyed", true}} {
+		keyed := tt.keyed
+		t.Run(tt.name, func(t *testing.T) {
+			b := New(WithMaxRetries(5), WithPartitions(1))
+			defer b.Close()
+
+			var calls atomic.Int32
+			acked := make(chan error, 1)
+			_, _ = b.SubscribeWithOptions("jobs", HandlerFunc(func(ctx context.Context, msg Message) error {
+				calls.Add(1)
+				a, _ := AckerFromContext(ctx)
+				go func() {
+					// Work for several visibility timeouts, extending meanwhile
+					for i := 0; i < 10; i++ {
+						time.Sleep(10 * time.Millisecond)
+						_ = a.Extend(30 * time.Millisecond)
+					}
+					acked <- a.Ack()
+				}()
+				return nil
+			}), WithManualAck(30*time.Millisecond))
+
+			if keyed {
+				_ = PublishWithKey(context.Background(), b, "jobs", "k", "work")
+			} else {
+				_ = b.Publish(context.Background(), "jobs", "work")
+			}
+			if err := <-acked; err != nil {
+				t.Fatalf("Ack() error = %v", err)
+			}
+			if calls.Load() != 1 {
+				t.Errorf("expected 1 delivery of extended work, got %d", calls.Load())
+			}
+		})
+	}
+}
+
+func TestManualAck_ExtendedHangTimesOut(t *testing.T) {
+	obs := &retryRecorder{}
+	b := New(WithMaxRetries(5), WithObserver(obs))
+	defer b.Close()
+
+	var calls atomic.Int32
+	start := time.Now()
+	_, _ = b.SubscribeWithOptions("jobs", HandlerFunc(func(ctx context.Context, msg Message) error {
+		a, _ := AckerFromContext(ctx)
+		if calls.Add(1) == 2 {
+			return a.Ack()
+		}
+		// Extends once, then hangs
+		return a.Extend(60 * time.Millisecond)
+	}), WithManualAck(10*time.Millisecond))
+
+	_ = b.Publish(context.Background(), "jobs", "work")
+	waitFor(t, func() bool { return calls.Load() == 2 })
+
+	if elapsed := time.Since(start); elapsed < 60*time.Millisecond {
+		t.Errorf("redelivered after %v, before the extended deadline", elapsed)
+	}
+	if err := obs.lastErr(); !errors.Is(err, ErrNotAcknowledged) {
+		t.Errorf("expected a retry with ErrNotAcknowledged, got %v", err)
+	}
+}
+
+func TestManualAck_ExtendAfterAck(t *testing.T) {
+	a := newAcker()
+	_ = a.Ack()
+	if err := a.Extend(time.Second); !errors.Is(err, ErrAlreadyAcknowledged) {
+		t.Errorf("expected ErrAlreadyAcknowledged, got %v", err)
+	}
+}
+
+func TestManualAck_HandlerError(t *testing.T) {
+	b := New(WithMaxRetries(2))
+	defer b.Close()
+
+	var calls atomic.Int32
+	_, _ = b.SubscribeWithOptions("jobs", HandlerFunc(func(ctx context.Context, msg Message) error {
+		if calls.Add(1) == 1 {
+			return errors.New("handler error")
+		}
+		a, _ := AckerFromContext(ctx)
+		return a.Ack()
+	}), WithManualAck(time.Second))
+
+	_ = b.Publish(context.Background(), "jobs", "work")
+	waitFor(t, func() bool { return calls.Load() == 2 })
+}
+
+func TestManualAck_NotEnabled(t *testing.T) {
+	b := New()
+	defer b.Close()
+
+	_, _ = b.Subscribe("jobs", HandlerFunc(func(ctx context.Context, msg Message) error {
+		if _, ok := AckerFromContext(ctx); ok {
+			t.Error("expected no Acker without WithManualAck")
+		}
+		return nil
+	}))
+
+	if err := b.PublishSync(context.Background(), "jobs", "work"); err != nil {
+		t.Fatalf("PublishSync() error = %v", err)
+	}
+}
+
+func TestManualAck_KeyedWaitsForAck(t *testing.T) {
+	b := New(WithPartitions(1))
+	defer b.Close()
+
+	var mu sync.Mutex
+	var events []string
+	acks := make(chan Acker, 2)
+	_, _ = b.SubscribeWithOptions("jobs", HandlerFunc(func(ctx context.Context, msg Message) error {
+		mu.Lock()
+		events = append(events, "start "+msg.Payload().(string))
+		mu.Unlock()
+		a, _ := AckerFromContext(ctx)
+		acks <- a
+		return nil
+	}), WithManualAck(time.Second))
+
+	ctx := context.Background()
+	_ = PublishWithKey(ctx, b, "jobs", "k", "first")
+	_ = PublishWithKey(ctx, b, "jobs", "k", "second")
+
+	first := <-acks
+	time.Sleep(20 * time.Millisecond)
+	mu.Lock()
+	if len(events) != 1 {
+		t.Errorf("expected the second message to wait for the first ack, got %v", events)
+	}
+	mu.Unlock()
+
+	_ = first.Ack()
+	second := <-acks
+	_ = second.Ack()
+}
+
+func TestManualAck_CloseReportsUnacked(t *testing.T) {
+	obs := &failureObserver{}
+	b := New(WithObserver(obs))
+
+	delivered := make(chan struct{})
+	_, _ = b.SubscribeWithOptions("jobs", HandlerFunc(func(ctx context.Context, msg Message) error {
+		close(delivered)
+		return nil
+	}), WithManualAck(time.Minute))
+
+	_ = b.Publish(context.Background(), "jobs", "work")
+	<-delivered
+	// Let the worker arm the acknowledgement
+	time.Sleep(10 * time.Millisecond)
+	_ = b.Close()
+
+	if err := obs.errorFor("work"); !errors.Is(err, ErrNotAcknowledged) {
+		t.Errorf("expected ErrNotAcknowledged for the unacked message, got %v", err)
+	}
+}
+
+// retryRecorder records the error of the last retry.
+type retryRecorder struct {
+	countingObserver
+	err error
+}
+
+func (o *retryRecorder) OnRetry(ctx context.Context, msg Message, attempt int, err error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.err = err
+}
+
+func (o *retryRecorder) OnDeadLetter(ctx context.Context, msg Message, err error) {}
+
+func (o *retryRecorder) lastErr() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.err
+}
@@ -0,0 +1,72 @@
+package scela
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// KeyFunc extracts the affinity key of a message.
+type KeyFunc func(msg Message) string
+
+// MetadataKey returns a KeyFunc that uses the string form of a metadata
+// value as the key. Messages without the metadata field get an empty key.
+func MetadataKey(field string) KeyFunc {
+	return func(msg Message) string {
+		v, ok := msg.Metadata()[field]
+		if !ok || v == nil {
+			return ""
+		}
+		return fmt.Sprint(v)
+	}
+}
+
+// AffinityHandler dispatches messages to a pool of handler replicas so that
+// messages with the same key always reach the same replica. Each replica
+// handles one message at a time, so replicas can keep per-key state in
+// memory (counters, session aggregates) without locking.
+type AffinityHandler struct {
+	key      KeyFunc
+	replicas []Handler
+	locks    []sync.Mutex
+}
+
+// NewAffinityHandler creates an affinity handler over replicas, routing by
+// the key returned by key.
+func NewAffinityHandler(key KeyFunc, replicas ...Handler) *AffinityHandler {
+	return &AffinityHandler{
+		key:      key,
+		replicas: replicas,
+		locks:    make([]sync.Mutex, len(replicas)),
+	}
+}
+
+// NewAffinityPool creates an affinity handler with n replicas built by
+// factory, which receives the replica index.
+func NewAffinityPool(n int, key KeyFunc, factory func(replica int) Handler) *AffinityHandler {
+	replicas := make([]Handler, n)
+	for i := range replicas {
+		replicas[i] = factory(i)
+	}
+	return NewAffinityHandler(key, replicas...)
+}
+
+// Replica returns the index of the replica that handles key.
+func (h *AffinityHandler) Replica(key string) int {
+	if len(h.replicas) == 0 {
+		return -1
+	}
+	return keyIndex(key, len(h.replicas))
+}
+
+// Handle implements Handler.
+func (h *AffinityHandler) Handle(ctx context.Context, msg Message) error {
+	i := h.Replica(h.key(msg))
+	if i < 0 {
+		return fmt.Errorf("affinity handler has no replicas")
+	}
+
+	h.locks[i].Lock()
+	defer h.locks[i].Unlock()
+	return h.replicas[i].Handle(ctx, msg)
+}
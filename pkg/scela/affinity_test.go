@@ -0,0 +1,98 @@
+package scela
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type sessionEvent struct {
+	Session string
+}
+
+func sessionKey(msg Message) string {
+	return msg.Payload().(sessionEvent).Session
+}
+
+// sessionCounter is a replica keeping unsynchronized per-key state.
+type sessionCounter struct {
+	replica int
+	counts  map[string]int
+	owners  *sync.Map // session -> replica index
+	errors  *atomic.Int32
+}
+
+func (c *sessionCounter) Handle(ctx context.Context, msg Message) error {
+	key := sessionKey(msg)
+	c.counts[key]++
+	if prev, loaded := c.owners.LoadOrStore(key, c.replica); loaded && prev != c.replica {
+		c.errors.Add(1)
+	}
+	return nil
+}
+
+func TestAffinityHandler_SameKeySameReplica(t *testing.T) {
+	owners := &sync.Map{}
+	var errors atomic.Int32
+	replicas := make([]*sessionCounter, 4)
+	pool := NewAffinityPool(len(replicas), sessionKey, func(i int) Handler {
+		replicas[i] = &sessionCounter{replica: i, counts: make(map[string]int), owners: owners, errors: &errors}
+		return replicas[i]
+	})
+
+	bus := New(WithWorkers(8))
+	_, _ = bus.Subscribe("session.event", pool)
+
+	ctx := context.Background()
+	for i := 0; i < 200; i++ {
+		_ = bus.Publish(ctx, "session.event", sessionEvent{Session: fmt.Sprintf("s%d", i%10)})
+	}
+	time.Sleep(100 * time.Millisecond)
+	_ = bus.Close()
+
+	if n := errors.Load(); n != 0 {
+		t.Errorf("Expected each session on a single replica, got %d violations", n)
+	}
+
+	total := 0
+	for _, r := range replicas {
+		for key, n := range r.counts {
+			if n != 20 {
+				t.Errorf("Session %s counted %d times on replica %d, want 20", key, n, r.replica)
+			}
+			total += n
+		}
+	}
+	if total != 200 {
+		t.Errorf("Expected 200 messages handled, got %d", total)
+	}
+}
+
+func TestAffinityHandler_Replica(t *testing.T) {
+	noop := HandlerFunc(func(ctx context.Context, msg Message) error { return nil })
+	h := NewAffinityHandler(MetadataKey("user"), noop, noop, noop)
+
+	if h.Replica("alice") != h.Replica("alice") {
+		t.Error("Expected stable replica for the same key")
+	}
+
+	empty := NewAffinityHandler(MetadataKey("user"))
+	if err := empty.Handle(context.Background(), NewMessage("t", nil)); err == nil {
+		t.Error("Expected error without replicas")
+	}
+}
+
+func TestMetadataKey(t *testing.T) {
+	msg := NewMessage("t", nil)
+	msg.Metadata()["user"] = 42
+
+	if key := MetadataKey("user")(msg); key != "42" {
+		t.Errorf("MetadataKey() = %q, want 42", key)
+	}
+	if key := MetadataKey("missing")(msg); key != "" {
+		t.Errorf("MetadataKey() for missing field = %q, want empty", key)
+	}
+}
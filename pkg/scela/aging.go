@@ -0,0 +1,188 @@
+package scela
+
+import (
+	"sync"
+	"time"
+)
+
+// WithPriorityAging promotes queued messages one priority level for every
+// threshold they wait, up to PriorityUrgent, so that low-priority messages
+// are delivered within a bounded time under sustained high-priority load,
+// whatever the priority weights. A promoted message competes with the
+// messages of its new level, oldest first. Stats still report it at the
+// level it was queued with. It has no effect on a bus with a Scheduler.
+// Zero, the default, disables aging.
+func WithPriorityAging(threshold time.Duration) Option {
+	return func(b *bus) {
+		b.agingThreshold = threshold
+	}
+}
+
+// agingState is the state shared by the readers of a priorityQueue with
+// aging. Each level has a head slot holding its oldest message once a
+// reader has taken it from the channel to look at its age, so that the
+// message keeps its place in front of the level.
+type agingState struct {
+	threshold time.Duration
+
+	mu    sync.Mutex
+	heads [priorityLevels]*Envelope
+	// wake is closed when a reader leaves messages in the head slots, to
+	// wake up the readers waiting on the channels.
+	wake chan struct{}
+}
+
+// newAgingState creates the aging state of a queue.
+func newAgingState(threshold time.Duration) *agingState {
+	return &agingState{
+		threshold: threshold,
+		wake:      make(chan struct{}),
+	}
+}
+
+// effective returns the priority of env, queued at level, after aging.
+func (a *agingState) effective(level Priority, env *Envelope, now time.Time) Priority {
+	promoted := int(level) + int(now.Sub(env.queuedAt)/a.threshold)
+	return clampPriority(Priority(promoted))
+}
+
+// pick removes and returns the head to dispatch: the oldest head aged to
+// preferred, or else the head with the highest priority after aging. It
+// returns nil if the head slots are empty. Must be called with the lock
+// held.
+func (a *agingState) pick(preferred Priority, now time.Time) *Envelope {
+	match, fallback := -1, -1
+	var fallbackPriority Priority
+	for i, env := range a.heads {
+		if env == nil {
+			continue
+		}
+		p := a.effective(Priority(i), env, now)
+		if p == preferred && (match < 0 || env.queuedAt.Before(a.heads[match].queuedAt)) {
+			match = i
+		}
+		if fallback < 0 || p > fallbackPriority ||
+			(p == fallbackPriority && env.queuedAt.Before(a.heads[fallback].queuedAt)) {
+			fallback, fallbackPriority = i, p
+		}
+	}
+
+	i := match
+	if i < 0 {
+		i = fallback
+	}
+	if i < 0 {
+		return nil
+	}
+	env := a.heads[i]
+	a.heads[i] = nil
+	return env
+}
+
+// held returns the number of messages in the head slots, per level.
+func (a *agingState) held() [priorityLevels]int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var n [priorityLevels]int
+	for i, env := range a.heads {
+		if env != nil {
+			n[i] = 1
+		}
+	}
+	return n
+}
+
+// agingReader takes messages from a priorityQueue with aging for one
+// worker. Levels found closed are set to nil.
+type agingReader struct {
+	aging    *agingState
+	schedule []Priority
+	pos      int
+	levels   [priorityLevels]chan *Envelope
+}
+
+// next returns the next message, waiting for one if the queue is empty. It
+// returns false once the queue is closed and drained.
+func (r *agingReader) next() (*Envelope, bool) {
+	a := r.aging
+	for {
+		preferred := r.schedule[r.pos]
+		r.pos = (r.pos + 1) % len(r.schedule)
+
+		a.mu.Lock()
+		r.fillHeads()
+		env := a.pick(preferred, time.Now())
+		if env != nil {
+			for _, head := range a.heads {
+				if head != nil {
+					close(a.wake)
+					a.wake = make(chan struct{})
+					break
+				}
+			}
+		}
+		wake := a.wake
+		a.mu.Unlock()
+
+		if env != nil {
+			return env, true
+		}
+		if r.drained() {
+			return nil, false
+		}
+
+		// The queue is empty: wait for the first message on any level, or
+		// for another reader to leave messages in the head slots
+		var (
+			ok bool
+			p  Priority
+		)
+		select {
+		case env, ok = <-r.levels[PriorityUrgent]:
+			p = PriorityUrgent
+		case env, ok = <-r.levels[PriorityHigh]:
+			p = PriorityHigh
+		case env, ok = <-r.levels[PriorityNormal]:
+			p = PriorityNormal
+		case env, ok = <-r.levels[PriorityLow]:
+			p = PriorityLow
+		case <-wake:
+			continue
+		}
+		if ok {
+			return env, true
+		}
+		r.levels[p] = nil
+	}
+}
+
+// fillHeads moves the oldest message of each level without a head into its
+// head slot. Must be called with the aging lock held.
+func (r *agingReader) fillHeads() {
+	for i, ch := range r.levels {
+		if ch == nil || r.aging.heads[i] != nil {
+			continue
+		}
+		select {
+		case env, ok := <-ch:
+			if !ok {
+				r.levels[i] = nil
+				continue
+			}
+			r.aging.heads[i] = env
+		default:
+		}
+	}
+}
+
+// drained reports whether all levels are closed. The head slots must be
+// empty.
+func (r *agingReader) drained() bool {
+	for _, ch := range r.levels {
+		if ch != nil {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,102 @@
+package scela
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPriorityQueue_Aging(t *testing.T) {
+	q := newPriorityQueue(100, defaultPriorityWeights)
+	q.aging = newAgingState(10 * time.Millisecond)
+	ctx := context.Background()
+
+	old := &Envelope{priority: PriorityLow}
+	_, _ = q.push(ctx, old, OverflowBlock)
+	time.Sleep(35 * time.Millisecond)
+	for i := 0; i < 20; i++ {
+		_, _ = q.push(ctx, &Envelope{priority: PriorityUrgent}, OverflowBlock)
+	}
+
+	// The low-priority message waited long enough to compete as urgent, and
+	// is older than the urgent ones
+	r := q.reader(0)
+	if env, _ := r.next(); env != old {
+		t.Fatalf("expected the aged message first, got priority %v", env.priority)
+	}
+
+	if q.len() != 20 || q.depths()[PriorityUrgent] != 20 {
+		t.Errorf("unexpected len %d and depths %v", q.len(), q.depths())
+	}
+	q.close()
+	n := 0
+	for {
+		if _, ok := r.next(); !ok {
+			break
+		}
+		n++
+	}
+	if n != 20 {
+		t.Errorf("expected 20 messages drained, got %d", n)
+	}
+}
+
+func TestPriorityQueue_AgingKeepsOrderWithinLevel(t *testing.T) {
+	q := newPriorityQueue(10, defaultPriorityWeights)
+	q.aging = newAgingState(time.Hour)
+	ctx := context.Background()
+
+	var pushed []*Envelope
+	for _, p := range []Priority{PriorityLow, PriorityHigh, PriorityLow, PriorityHigh} {
+		env := &Envelope{priority: p}
+		pushed = append(pushed, env)
+		_, _ = q.push(ctx, env, OverflowBlock)
+	}
+
+	// Without aged messages the weighted order applies
+	r := q.reader(0)
+	want := []*Envelope{pushed[1], pushed[3], pushed[0], pushed[2]}
+	for i, w := range want {
+		if env, _ := r.next(); env != w {
+			t.Fatalf("message %d has priority %v, want %v", i, env.priority, w.priority)
+		}
+	}
+}
+
+func TestBus_PriorityAging(t *testing.T) {
+	bus := New(WithWorkers(1), WithPriorityAging(10*time.Millisecond), WithStartPaused())
+	defer bus.Close()
+
+	var mu sync.Mutex
+	var order []interface{}
+	done := make(chan struct{})
+	_, _ = bus.Subscribe("work", HandlerFunc(func(ctx context.Context, msg Message) error {
+		mu.Lock()
+		defer mu.Unlock()
+		order = append(order, msg.Payload())
+		if len(order) == 11 {
+			close(done)
+		}
+		return nil
+	}))
+
+	ctx := context.Background()
+	_ = bus.PublishWithPriority(ctx, "work", "low", PriorityLow)
+	time.Sleep(35 * time.Millisecond)
+	for i := 0; i < 10; i++ {
+		_ = bus.PublishWithPriority(ctx, "work", i, PriorityUrgent)
+	}
+	if err := Start(bus); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("messages not delivered")
+	}
+	if order[0] != "low" {
+		t.Errorf("expected the aged message first, got %v", order)
+	}
+}
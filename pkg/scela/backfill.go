@@ -0,0 +1,160 @@
+package scela
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// backfillHandler holds live messages while a backfill is in progress and
+// delivers them directly afterwards. A held delivery only completes once
+// its message is handled, so the bus sees its outcome: a failure is retried
+// or dead-lettered, and the message is not marked delivered before.
+type backfillHandler struct {
+	handler Handler
+
+	mu       sync.Mutex
+	live     bool
+	err      error
+	buffered []bufferedMessage
+
+	// seen holds the IDs of the messages handled by the last backfill, which
+	// may still be queued on the bus when it goes live.
+	seen map[string]bool
+}
+
+// bufferedMessage is a live message received during backfill, whose
+// delivery waits for the result of handling it on done.
+type bufferedMessage struct {
+	ctx  context.Context
+	msg  Message
+	done chan error
+}
+
+// Handle implements Handler.
+func (h *backfillHandler) Handle(ctx context.Context, msg Message) error {
+	h.mu.Lock()
+	if h.err != nil {
+		h.mu.Unlock()
+		return h.err
+	}
+	if !h.live {
+		done := make(chan error, 1)
+		h.buffered = append(h.buffered, bufferedMessage{ctx: ctx, msg: msg, done: done})
+		h.mu.Unlock()
+		return <-done
+	}
+	if h.seen[msg.ID()] {
+		delete(h.seen, msg.ID())
+		h.mu.Unlock()
+		return nil
+	}
+	h.mu.Unlock()
+
+	return h.handler.Handle(ctx, msg)
+}
+
+// buffer switches back to holding live messages until goLive.
+func (h *backfillHandler) buffer() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.live = false
+}
+
+// goLive delivers the held messages not already seen during backfill and
+// switches to direct delivery, still skipping the seen messages. Live
+// messages arriving meanwhile wait, so ordering is preserved.
+func (h *backfillHandler) goLive(seen map[string]bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	buffered := h.buffered
+	h.buffered = nil
+	h.live = true
+	h.seen = seen
+
+	for _, b := range buffered {
+		if seen[b.msg.ID()] {
+			delete(seen, b.msg.ID())
+			b.done <- nil
+			continue
+		}
+		b.done <- h.handler.Handle(b.ctx, b.msg)
+	}
+}
+
+// abort fails the held deliveries, and any later one, with err once the
+// backfill failed.
+func (h *backfillHandler) abort(err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.err = err
+	for _, b := range h.buffered {
+		b.done <- err
+	}
+	h.buffered = nil
+}
+
+// SubscribeWithBackfill subscribes handler to pattern after first delivering
+// the stored messages matching pattern with a timestamp at or after since,
+// in store order. Live messages published during the backfill are held and
+// delivered afterwards, skipping those already backfilled, so the handler
+// sees every message once with no gap between history and live delivery.
+// Their deliveries complete once they are handled, so the bus retries or
+// dead-letters them like any other.
+//
+// Backfilled messages bypass the bus middleware and retries. If the handler
+// fails during backfill, the subscription is removed and the error returned.
+// Deduplication relies on message IDs, so the store must preserve them.
+func (pb *PersistentBus) SubscribeWithBackfill(pattern string, handler Handler, since time.Time) (Subscription, error) {
+	bh := &backfillHandler{handler: handler}
+
+	// Subscribe before loading so nothing published meanwhile is missed
+	sub, err := pb.Bus.Subscribe(pattern, bh)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := context.Background()
+	seen := make(map[string]bool)
+	if err := pb.backfill(ctx, pattern, since, handler, seen); err != nil {
+		bh.abort(err)
+		_ = sub.Unsubscribe()
+		return nil, err
+	}
+	bh.goLive(seen)
+
+	return sub, nil
+}
+
+// backfill delivers the matching stored messages, recording their IDs in
+// seen.
+func (pb *PersistentBus) backfill(
+	ctx context.Context, pattern string, since time.Time, handler Handler, seen map[string]bool,
+) error {
+	var messages []Message
+	var err error
+	if qs, ok := pb.store.(QueryableStore); ok {
+		messages, err = qs.LoadAfter(ctx, since.Add(-time.Nanosecond))
+	} else {
+		messages, err = pb.store.Load(ctx)
+	}
+	if err != nil {
+		return pb.reportStoreError(ctx, "load", nil, err)
+	}
+
+	matcher := newPatternMatcher()
+
+	for _, msg := range messages {
+		if msg.Timestamp().Before(since) || !matcher.Match(pattern, msg.Topic()) {
+			continue
+		}
+		if err := handler.Handle(ContextWithMessage(ctx, msg), msg); err != nil {
+			return fmt.Errorf("backfill failed at message %s: %w", msg.ID(), err)
+		}
+		seen[msg.ID()] = true
+	}
+	return nil
+}
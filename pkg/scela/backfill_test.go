@@ -0,0 +1,156 @@
+package scela
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPersistentBus_SubscribeWithBackfill(t *testing.T) {
+	pb := NewPersistentBus(New(WithWorkers(1)), NewInMemoryStore(100))
+	defer pb.Close()
+	ctx := context.Background()
+
+	_ = pb.Publish(ctx, "orders.created", 0)
+	time.Sleep(5 * time.Millisecond)
+	since := time.Now()
+	for i := 1; i <= 3; i++ {
+		_ = pb.Publish(ctx, "orders.created", i)
+	}
+	_ = pb.Publish(ctx, "users.created", -1)
+
+	var mu sync.Mutex
+	var got []int
+	started := make(chan struct{})
+	var once sync.Once
+
+	handler := HandlerFunc(func(ctx context.Context, msg Message) error {
+		once.Do(func() {
+			close(started)
+			// Slow first delivery so live messages arrive during backfill
+			time.Sleep(20 * time.Millisecond)
+		})
+		mu.Lock()
+		got = append(got, msg.Payload().(int))
+		mu.Unlock()
+		return nil
+	})
+
+	go func() {
+		<-started
+		for i := 4; i <= 6; i++ {
+			_ = pb.Publish(ctx, "orders.created", i)
+		}
+	}()
+
+	sub, err := pb.SubscribeWithBackfill("orders.*", handler, since)
+	if err != nil {
+		t.Fatalf("SubscribeWithBackfill() error = %v", err)
+	}
+	defer sub.Unsubscribe()
+
+	_ = pb.Publish(ctx, "orders.created", 7)
+	time.Sleep(50 * time.Millisecond)
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []int{1, 2, 3, 4, 5, 6, 7}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Delivered %v, want %v", got, want)
+	}
+}
+
+func TestPersistentBus_SubscribeWithBackfillHandlerError(t *testing.T) {
+	bus := New()
+	pb := NewPersistentBus(bus, NewInMemoryStore(100))
+	defer pb.Close()
+
+	_ = pb.Publish(context.Background(), "test", "data")
+
+	boom := errors.New("boom")
+	_, err := pb.SubscribeWithBackfill("test", HandlerFunc(func(ctx context.Context, msg Message) error {
+		return boom
+	}), time.Time{})
+	if !errors.Is(err, boom) {
+		t.Errorf("Expected handler error, got %v", err)
+	}
+
+	if subs, _ := InspectSubscriptions(bus); len(subs) != 0 {
+		t.Errorf("Expected failed backfill to remove the subscription, got %d", len(subs))
+	}
+}
+
+func TestPersistentBus_SubscribeWithBackfillRetriesHeldMessages(t *testing.T) {
+	store := NewInMemoryStore(100)
+	pb := NewPersistentBus(New(WithMaxRetries(3)), store)
+	defer pb.Close()
+	ctx := context.Background()
+
+	_ = store.Store(ctx, NewMessage("orders.created", "stored"))
+
+	started := make(chan struct{})
+	var attempts atomic.Int32
+	var pendingWhileHandled atomic.Bool
+	handler := HandlerFunc(func(ctx context.Context, msg Message) error {
+		if msg.Payload() == "stored" {
+			close(started)
+			// Slow backfill so the live message is held
+			time.Sleep(20 * time.Millisecond)
+			return nil
+		}
+		if attempts.Add(1) == 1 {
+			for _, p := range pendingPayloads(t, store) {
+				if p == "live" {
+					pendingWhileHandled.Store(true)
+				}
+			}
+			return errors.New("read model unavailable")
+		}
+		return nil
+	})
+
+	go func() {
+		<-started
+		_ = pb.Publish(ctx, "orders.created", "live")
+	}()
+
+	sub, err := pb.SubscribeWithBackfill("orders.*", handler, time.Time{})
+	if err != nil {
+		t.Fatalf("SubscribeWithBackfill() error = %v", err)
+	}
+	defer sub.Unsubscribe()
+
+	waitFor(t, func() bool { return attempts.Load() == 2 })
+	if !pendingWhileHandled.Load() {
+		t.Error("expected the held message to stay pending until handled")
+	}
+	waitFor(t, func() bool { return len(pendingPayloads(t, store)) == 1 })
+}
+
+func TestPersistentBus_PublishDeliversStoredMessage(t *testing.T) {
+	store := NewInMemoryStore(10)
+	pb := NewPersistentBus(New(), store)
+	defer pb.Close()
+
+	delivered := make(chan string, 1)
+	_, _ = pb.Subscribe("test", HandlerFunc(func(ctx context.Context, msg Message) error {
+		delivered <- msg.ID()
+		return nil
+	}))
+
+	_ = pb.Publish(context.Background(), "test", "data")
+
+	stored, _ := store.Load(context.Background())
+	select {
+	case id := <-delivered:
+		if id != stored[0].ID() {
+			t.Errorf("Delivered message %s, stored %s", id, stored[0].ID())
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Message not delivered")
+	}
+}
@@ -0,0 +1,355 @@
+package scela
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// ErrBridgeClosed is returned by Sink and Source implementations once they
+// have been closed.
+var ErrBridgeClosed = errors.New("bridge is closed")
+
+// Sink delivers bus messages to an external system.
+//
+// Implementations must preserve the order of messages sent from a single
+// goroutine, reconnect transparently after losing the connection, and return
+// ErrBridgeClosed from Send once closed. The scelatest package provides
+// TestBridge to check these guarantees.
+type Sink interface {
+	// Send writes msg to the external system. A nil error means the external
+	// system has accepted the message.
+	Send(ctx context.Context, msg Message) error
+
+	// Close releases the connection. It is safe to call more than once.
+	Close() error
+}
+
+// Source receives messages from an external system with at-least-once
+// semantics: a delivery that is not acknowledged, whether it was nacked or the
+// connection was lost, is received again later.
+//
+// Implementations must return messages in the order they were sent, and
+// Receive must return ErrBridgeClosed once the source is closed, including
+// calls blocked when Close is called.
+type Source interface {
+	// Receive blocks until a message is available, ctx is done or the source
+	// is closed.
+	Receive(ctx context.Context) (Delivery, error)
+
+	// Close releases the connection. It is safe to call more than once.
+	Close() error
+}
+
+// Delivery is a message received from a Source.
+type Delivery interface {
+	// Message returns the received message.
+	Message() Message
+
+	// Ack confirms the message was processed so it is not delivered again.
+	Ack() error
+
+	// Nack rejects the message so it is delivered again.
+	Nack() error
+}
+
+// ForwardTo subscribes to pattern on b and sends every matching message to
+// sink, recording the HopBridgeOut hop. A failed send is returned to the
+// bus, so the usual retry and dead-letter handling applies.
+func ForwardTo(b Subscriber, pattern string, sink Sink, opts ...SubscriptionOption) (Subscription, error) {
+	return b.SubscribeWithOptions(pattern, HandlerFunc(func(ctx context.Context, msg Message) error {
+		if err := sink.Send(ctx, AddHop(msg, HopBridgeOut)); err != nil {
+			return fmt.Errorf("failed to forward message %s: %w", msg.ID(), err)
+		}
+		return nil
+	}), opts...)
+}
+
+// ConsumeFrom receives messages from source and publishes them on b until ctx
+// is done or the source is closed. Each delivery is acknowledged once it has
+// been published; if publishing fails the delivery is nacked and the error
+// returned. The message ID and metadata are kept, with the HopBridgeIn hop
+// recorded, when b supports publishing existing messages; messages b already
+// published are acknowledged without being published again when it
+// deduplicates them (see WithBridgeDedupe). Gaps in the sequence numbers of
+// the received messages are reported to the bus observers implementing
+// GapObserver.
+func ConsumeFrom(ctx context.Context, source Source, b Bus) error {
+	return consumeFrom(ctx, source, b, nil)
+}
+
+// consumeFrom implements ConsumeFrom. Deliveries for which skip returns true
+// are acknowledged without being published.
+func consumeFrom(ctx context.Context, source Source, b Bus, skip func(Message) bool) error {
+	gaps := NewGapDetector()
+	for {
+		d, err := source.Receive(ctx)
+		if err != nil {
+			if errors.Is(err, ErrBridgeClosed) {
+				return nil
+			}
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return fmt.Errorf("failed to receive message: %w", err)
+		}
+
+		if skip == nil || !skip(d.Message()) {
+			reportGap(ctx, b, gaps, d.Message())
+			if err := publishExisting(ctx, b, AddHop(d.Message(), HopBridgeIn)); err != nil {
+				// Leave the message with the source for the next consumer
+				_ = d.Nack()
+				return fmt.Errorf("failed to publish message %s: %w", d.Message().ID(), err)
+			}
+		}
+		if err := d.Ack(); err != nil {
+			return fmt.Errorf("failed to ack message %s: %w", d.Message().ID(), err)
+		}
+	}
+}
+
+// PublishMessage publishes msg on b as is, keeping its ID, metadata,
+// timestamp and priority, for adapters turning messages received from
+// outside the process into bus messages. Buses that cannot publish existing
+// messages publish its topic and payload as a new message.
+func PublishMessage(ctx context.Context, b Bus, msg Message) error {
+	return publishExisting(ctx, b, msg)
+}
+
+// publishExisting publishes msg as is when b supports it, and otherwise
+// publishes its topic and payload as a new message. Messages b already
+// published are dropped when it deduplicates them, see WithBridgeDedupe.
+func publishExisting(ctx context.Context, b Bus, msg Message) error {
+	d, dedupe := bridgeDeduperOf(b)
+	if dedupe && !d.claimBridged(msg.ID()) {
+		return nil
+	}
+
+	var err error
+	if mp, ok := b.(messagePublisher); ok {
+		err = mp.publishMessages(ctx, []Message{msg}, false)
+	} else {
+		err = b.Publish(ctx, msg.Topic(), msg.Payload())
+	}
+	if err != nil && dedupe {
+		// Let the source deliver it again
+		d.releaseBridged(msg.ID())
+	}
+	return err
+}
+
+// bridgeEchoWindow is the number of recent message IDs a bridge remembers in
+// each direction to recognize its own messages coming back.
+const bridgeEchoWindow = 4096
+
+// Bridge mirrors messages between a bus and an external system in both
+// directions, such as a broker shared by several services.
+type Bridge interface {
+	// Attach starts mirroring between b and the external system, until ctx
+	// is done or the bridge is closed. A bridge can be attached once.
+	Attach(ctx context.Context, b Bus) error
+
+	// Close stops mirroring and closes the connection. It returns the error
+	// that stopped the consumption of the external system early, if any. It
+	// is safe to call more than once.
+	Close() error
+}
+
+// NewBridge returns a Bridge built on a Sink and a Source connected to the
+// same external system: the messages published on the bus that match
+// patterns are forwarded to sink as with ForwardTo, and the messages
+// received from source are published on the bus as with ConsumeFrom.
+//
+// The bridge does not send back what it received: messages it published
+// on the bus are not forwarded, and messages it forwarded are skipped when
+// the external system delivers them back to source. Both are recognized by
+// their ID among the latest few thousand, so the external system must keep
+// message IDs.
+func NewBridge(sink Sink, source Source, patterns ...string) Bridge {
+	return &mirror{
+		sink:     sink,
+		source:   source,
+		patterns: patterns,
+		sent:     newRecentIDs(bridgeEchoWindow),
+		received: newRecentIDs(bridgeEchoWindow),
+		done:     make(chan struct{}),
+	}
+}
+
+// mirror is the Bridge returned by NewBridge.
+type mirror struct {
+	sink     Sink
+	source   Source
+	patterns []string
+	sent     *recentIDs
+	received *recentIDs
+
+	mu       sync.Mutex
+	attached bool
+	closed   bool
+	subs     []Subscription
+	cancel   context.CancelFunc
+	done     chan struct{}
+	err      error
+}
+
+// Attach implements Bridge.
+func (m *mirror) Attach(ctx context.Context, b Bus) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	switch {
+	case m.closed:
+		return ErrBridgeClosed
+	case m.attached:
+		return errors.New("bridge is already attached")
+	}
+
+	forward := HandlerFunc(func(ctx context.Context, msg Message) error {
+		if m.received.contains(msg.ID()) {
+			return nil
+		}
+		m.sent.add(msg.ID())
+		if err := m.sink.Send(ctx, AddHop(msg, HopBridgeOut)); err != nil {
+			return fmt.Errorf("failed to forward message %s: %w", msg.ID(), err)
+		}
+		return nil
+	})
+	for _, pattern := range m.patterns {
+		sub, err := b.Subscribe(pattern, forward)
+		if err != nil {
+			for _, sub := range m.subs {
+				_ = sub.Unsubscribe()
+			}
+			m.subs = nil
+			return fmt.Errorf("failed to forward %q: %w", pattern, err)
+		}
+		m.subs = append(m.subs, sub)
+	}
+
+	ctx, m.cancel = context.WithCancel(ctx)
+	m.attached = true
+	go func() {
+		defer close(m.done)
+		err := consumeFrom(ctx, m.source, b, func(msg Message) bool {
+			if m.sent.contains(msg.ID()) {
+				return true
+			}
+			m.received.add(msg.ID())
+			return false
+		})
+		if err != nil && !errors.Is(err, context.Canceled) {
+			m.mu.Lock()
+			m.err = err
+			m.mu.Unlock()
+		}
+	}()
+	return nil
+}
+
+// Close implements Bridge.
+func (m *mirror) Close() error {
+	m.mu.Lock()
+	if m.closed {
+		err := m.err
+		m.mu.Unlock()
+		return err
+	}
+	m.closed = true
+	subs, attached := m.subs, m.attached
+	m.subs = nil
+	m.mu.Unlock()
+
+	for _, sub := range subs {
+		_ = sub.Unsubscribe()
+	}
+	sourceErr := m.source.Close()
+	sinkErr := m.sink.Close()
+	if attached {
+		m.cancel()
+		<-m.done
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.err == nil {
+		m.err = errors.Join(sourceErr, sinkErr)
+	}
+	return m.err
+}
+
+// recentIDs is a set of message IDs remembering only the latest ones.
+type recentIDs struct {
+	mu    sync.Mutex
+	ids   map[string]struct{}
+	order []string
+	next  int
+}
+
+// newRecentIDs creates a set remembering up to size IDs.
+func newRecentIDs(size int) *recentIDs {
+	return &recentIDs{
+		ids:   make(map[string]struct{}, size),
+		order: make([]string, size),
+	}
+}
+
+// add records id, forgetting the oldest ID when the set is full.
+func (r *recentIDs) add(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.ids[id]; ok {
+		return
+	}
+	r.addLocked(id)
+}
+
+// addLocked records id, which is not recorded yet. Must be called with the
+// lock held.
+func (r *recentIDs) addLocked(id string) {
+	if old := r.order[r.next]; old != "" {
+		delete(r.ids, old)
+	}
+	r.order[r.next] = id
+	r.ids[id] = struct{}{}
+	r.next = (r.next + 1) % len(r.order)
+}
+
+// addNew records id and reports true, unless it is already recorded.
+func (r *recentIDs) addNew(id string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.ids[id]; ok {
+		return false
+	}
+	r.addLocked(id)
+	return true
+}
+
+// remove forgets id.
+func (r *recentIDs) remove(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.ids[id]; !ok {
+		return
+	}
+	delete(r.ids, id)
+	for i, recorded := range r.order {
+		if recorded == id {
+			r.order[i] = ""
+		}
+	}
+}
+
+// contains reports whether id is among the recorded IDs.
+func (r *recentIDs) contains(id string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	_, ok := r.ids[id]
+	return ok
+}
@@ -0,0 +1,237 @@
+package scela
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// stubSink records sent messages or fails every send.
+type stubSink struct {
+	sent []Message
+	err  error
+}
+
+func (s *stubSink) Send(ctx context.Context, msg Message) error {
+	if s.err != nil {
+		return s.err
+	}
+	s.sent = append(s.sent, msg)
+	return nil
+}
+
+func (s *stubSink) Close() error { return nil }
+
+// stubSource hands out queued messages, then reports it is closed.
+type stubSource struct {
+	msgs   []Message
+	acked  []string
+	nacked []string
+}
+
+func (s *stubSource) Receive(ctx context.Context) (Delivery, error) {
+	if len(s.msgs) == 0 {
+		return nil, ErrBridgeClosed
+	}
+	msg := s.msgs[0]
+	s.msgs = s.msgs[1:]
+	return &stubDelivery{source: s, msg: msg}, nil
+}
+
+func (s *stubSource) Close() error { return nil }
+
+type stubDelivery struct {
+	source *stubSource
+	msg    Message
+}
+
+func (d *stubDelivery) Message() Message { return d.msg }
+
+func (d *stubDelivery) Ack() error {
+	d.source.acked = append(d.source.acked, d.msg.ID())
+	return nil
+}
+
+func (d *stubDelivery) Nack() error {
+	d.source.nacked = append(d.source.nacked, d.msg.ID())
+	return nil
+}
+
+func TestForwardTo(t *testing.T) {
+	bus := New()
+	defer bus.Close()
+
+	sink := &stubSink{}
+	if _, err := ForwardTo(bus, "order.*", sink); err != nil {
+		t.Fatalf("ForwardTo: %v", err)
+	}
+
+	_ = bus.PublishSync(context.Background(), "order.created", 1)
+	_ = bus.PublishSync(context.Background(), "user.created", 2)
+
+	if len(sink.sent) != 1 || sink.sent[0].Topic() != "order.created" {
+		t.Fatalf("expected only order.created to be forwarded, got %d messages", len(sink.sent))
+	}
+}
+
+func TestForwardTo_SendErrorReachesBus(t *testing.T) {
+	bus := New(WithMaxRetries(0))
+	defer bus.Close()
+
+	sendErr := errors.New("broker down")
+	if _, err := ForwardTo(bus, "order.*", &stubSink{err: sendErr}); err != nil {
+		t.Fatalf("ForwardTo: %v", err)
+	}
+
+	err := bus.PublishSync(context.Background(), "order.created", 1)
+	if !errors.Is(err, sendErr) {
+		t.Errorf("expected send error from PublishSync, got %v", err)
+	}
+}
+
+func TestConsumeFrom_KeepsMessageIdentity(t *testing.T) {
+	bus := New()
+	defer bus.Close()
+
+	received := make(chan Message, 1)
+	_, _ = bus.Subscribe("order.created", HandlerFunc(func(ctx context.Context, msg Message) error {
+		received <- msg
+		return nil
+	}))
+
+	sent := NewMessage("order.created", "o-1")
+	sent.Metadata()["tenant"] = "acme"
+	source := &stubSource{msgs: []Message{sent}}
+
+	if err := ConsumeFrom(context.Background(), source, bus); err != nil {
+		t.Fatalf("ConsumeFrom: %v", err)
+	}
+
+	got := <-received
+	if got.ID() != sent.ID() || got.Metadata()["tenant"] != "acme" {
+		t.Errorf("expected the original message, got ID %s metadata %v", got.ID(), got.Metadata())
+	}
+	if len(source.acked) != 1 || source.acked[0] != sent.ID() {
+		t.Errorf("expected the delivery to be acked, got %v", source.acked)
+	}
+}
+
+func TestConsumeFrom_NacksWhenPublishFails(t *testing.T) {
+	bus := New()
+	_ = bus.Close()
+
+	sent := NewMessage("order.created", "o-1")
+	source := &stubSource{msgs: []Message{sent}}
+
+	if err := ConsumeFrom(context.Background(), source, bus); err == nil {
+		t.Fatal("expected an error publishing to a closed bus")
+	}
+	if len(source.nacked) != 1 || len(source.acked) != 0 {
+		t.Errorf("expected the delivery to be nacked, acked %v nacked %v", source.acked, source.nacked)
+	}
+}
+
+// memBroker is an external system delivering every message sent to it to
+// all its sources, including those of the sender.
+type memBroker struct {
+	mu      sync.Mutex
+	sources []*memSource
+	sends   int
+}
+
+func (m *memBroker) bridge(patterns ...string) Bridge {
+	source := &memSource{msgs: make(chan Message, 64), done: make(chan struct{})}
+	m.mu.Lock()
+	m.sources = append(m.sources, source)
+	m.mu.Unlock()
+	return NewBridge(&memSink{broker: m}, source, patterns...)
+}
+
+type memSink struct{ broker *memBroker }
+
+func (s *memSink) Send(ctx context.Context, msg Message) error {
+	s.broker.mu.Lock()
+	defer s.broker.mu.Unlock()
+	s.broker.sends++
+	for _, source := range s.broker.sources {
+		source.msgs <- msg
+	}
+	return nil
+}
+
+func (s *memSink) Close() error { return nil }
+
+type memSource struct {
+	msgs      chan Message
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func (s *memSource) Receive(ctx context.Context) (Delivery, error) {
+	select {
+	case msg := <-s.msgs:
+		return &stubDelivery{source: &stubSource{}, msg: msg}, nil
+	case <-s.done:
+		return nil, ErrBridgeClosed
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (s *memSource) Close() error {
+	s.closeOnce.Do(func() { close(s.done) })
+	return nil
+}
+
+func TestNewBridge_MirrorsWithoutEchoes(t *testing.T) {
+	broker := &memBroker{}
+	busA, busB := New(), New()
+	defer busA.Close()
+	defer busB.Close()
+
+	var countA, countB atomic.Int32
+	_, _ = busA.Subscribe("order.*", HandlerFunc(func(ctx context.Context, msg Message) error {
+		countA.Add(1)
+		return nil
+	}))
+	_, _ = busB.Subscribe("order.*", HandlerFunc(func(ctx context.Context, msg Message) error {
+		countB.Add(1)
+		return nil
+	}))
+
+	bridgeA, bridgeB := broker.bridge("order.*"), broker.bridge("order.*")
+	if err := bridgeA.Attach(context.Background(), busA); err != nil {
+		t.Fatalf("Attach: %v", err)
+	}
+	if err := bridgeB.Attach(context.Background(), busB); err != nil {
+		t.Fatalf("Attach: %v", err)
+	}
+
+	_ = busA.Publish(context.Background(), "order.created", 1)
+	_ = busB.Publish(context.Background(), "order.shipped", 2)
+	time.Sleep(100 * time.Millisecond)
+
+	if countA.Load() != 2 || countB.Load() != 2 {
+		t.Errorf("expected each bus to see both messages once, got %d and %d", countA.Load(), countB.Load())
+	}
+	broker.mu.Lock()
+	sends := broker.sends
+	broker.mu.Unlock()
+	if sends != 2 {
+		t.Errorf("expected 2 sends to the broker, got %d", sends)
+	}
+
+	if err := bridgeA.Close(); err != nil {
+		t.Errorf("Close: %v", err)
+	}
+	if err := bridgeA.Close(); err != nil {
+		t.Errorf("second Close: %v", err)
+	}
+	if err := bridgeA.Attach(context.Background(), busA); !errors.Is(err, ErrBridgeClosed) {
+		t.Errorf("Attach after Close returned %v, want ErrBridgeClosed", err)
+	}
+	_ = bridgeB.Close()
+}
@@ -4,6 +4,8 @@ import (
 	"context"
 	"fmt"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // bus is the default implementation of the Bus interface.
@@ -11,20 +13,130 @@ type bus struct {
 	registry   *subscriptionRegistry
 	middleware []Middleware
 	workers    int
-	queue      chan *envelope
+	queue      dispatchQueue
+	queueSize  int
+	overflow   OverflowPolicy
+	weights    map[Priority]int
+	scheduler  Scheduler
+	pauses     *pauseRegistry
+	acks       *ackTracker
+	retries    *retryTracker
+
+	// wheel holds messages published for later delivery, see PublishAt.
+	wheel *timerWheel
+
+	// startPaused defers starting the workers until Start.
+	startPaused bool
+
+	// lanes deliver keyed messages, one partition worker each.
+	partitions int
+	lanes      []*partitionLane
+	laneStop   chan struct{}
+
+	// urgent tracks urgent synchronous deliveries, which hold the partition
+	// workers when urgentPause is set, see WithUrgentPause.
+	urgent      *urgentGate
+	urgentPause bool
+
 	wg         sync.WaitGroup
 	mu         sync.RWMutex
 	closed     bool
+	started    bool
 	maxRetries int
+	backoff    Backoff
 	dlqHandler Handler
 	observers  *observerRegistry
+	inherit    bool
+
+	// defaultMetadata is added to every message published on the bus.
+	defaultMetadata map[string]interface{}
+
+	// interceptors run on every message created for a publish.
+	interceptors []PublishInterceptor
+
+	// propagate carries the publisher's deadline and the values under
+	// propagateKeys to handlers of async messages.
+	propagate     bool
+	propagateKeys []interface{}
+
+	// latency records end-to-end latency when WithLatencyTracking is set.
+	latency *latencyTracker
+
+	// replyTopics counts the open reply topics of Request and ScatterGather.
+	replyTopics atomic.Int64
+
+	// finalPriority is the priority a message is escalated to before its
+	// last retry attempt, when escalateFinal is set.
+	finalPriority Priority
+	escalateFinal bool
+
+	// ttl is the default time to live of published messages, and
+	// expirationHandler gets the messages skipped because they expired.
+	ttl               time.Duration
+	expirationHandler Handler
+
+	// recoverPanics turns handler panics into errors, see WithPanicHandler.
+	recoverPanics bool
+	panicHandler  PanicHandler
+
+	// sequences numbers published messages, see WithSequenceNumbers.
+	sequences *topicSequences
+
+	// published remembers the IDs of the latest published messages, see
+	// WithBridgeDedupe.
+	published *recentIDs
+
+	// maxPayloadSize limits the size of published payloads, see
+	// WithMaxPayloadSize.
+	maxPayloadSize int64
+
+	// schemas validates published payloads, see WithSchemaValidation.
+	schemas *SchemaRegistry
+
+	// topics checks the types of published payloads, see WithTopicRegistry.
+	topics *TopicRegistry
+
+	// agingThreshold is how long a queued message waits before it is
+	// promoted, see WithPriorityAging.
+	agingThreshold time.Duration
 }
 
-// envelope wraps a message for internal processing.
-type envelope struct {
+// Envelope is a queued message with its delivery state, as handed to a
+// Scheduler.
+type Envelope struct {
 	msg      Message
 	retries  int
 	priority Priority
+
+	// sub restricts delivery to a single subscription when retrying a
+	// handler that failed; nil delivers to all matching subscriptions.
+	sub *subscription
+
+	// err is the error of the last failed attempt.
+	err error
+
+	// ctx holds the parts of the publisher's context restored for handlers.
+	ctx *capturedContext
+
+	// lane is the partition lane delivering a keyed message.
+	lane *partitionLane
+
+	// delivery tracks the deliveries of the message still in progress, for
+	// wrappers that want to know when it was delivered.
+	delivery *deliveryState
+
+	// dequeued is called once the message left the queue, for wrappers
+	// that count queued messages.
+	dequeued func(Message)
+
+	// queuedAt is when the message was queued, for priority aging.
+	queuedAt time.Time
+}
+
+// deliveryFailure records a subscription whose handler failed.
+type deliveryFailure struct {
+	sub *subscription
+	err error
 }
 
 // Option is a functional option for configuring the bus.
@@ -48,6 +160,17 @@ func WithMaxRetries(n int) Option {
 	}
 }
 
+// WithRetryBackoff delays retries of failed messages according to backoff
+// (see ConstantBackoff, ExponentialBackoff and WithJitter). Delayed retries
+// do not occupy a worker while waiting. By default retries are immediate.
+// Retries still waiting when the bus closes are reported to observers with
+// ErrRetryAbandoned.
+func WithRetryBackoff(backoff Backoff) Option {
+	return func(b *bus) {
+		b.backoff = backoff
+	}
+}
+
 // WithDeadLetterHandler sets a handler for messages that exceed max retries.
 func WithDeadLetterHandler(handler Handler) Option {
 	return func(b *bus) {
@@ -55,114 +178,401 @@ func WithDeadLetterHandler(handler Handler) Option {
 	}
 }
 
+// WithFinalRetryPriority escalates a failing message to at least the given
+// priority before its final retry attempt, so messages about to be dead-lettered
+// are not stuck behind a backlog. Messages already at a higher priority keep it.
+func WithFinalRetryPriority(priority Priority) Option {
+	return func(b *bus) {
+		b.finalPriority = priority
+		b.escalateFinal = true
+	}
+}
+
+// WithDuplicateSubscriptions sets how the bus treats the same handler
+// subscribing to the same pattern more than once. Handlers are identified by
+// their SubscriptionKey (see WithHandlerKey) or, for pointer handlers, by
+// identity.
+func WithDuplicateSubscriptions(policy DuplicatePolicy) Option {
+	return func(b *bus) {
+		b.registry.duplicates = policy
+	}
+}
+
+// WithMaxSubscriptions limits the total number of subscriptions. Subscribe
+// returns ErrSubscriptionLimit once the limit is reached. Zero means unlimited.
+func WithMaxSubscriptions(n int) Option {
+	return func(b *bus) {
+		if n >= 0 {
+			b.registry.maxSubscriptions = n
+		}
+	}
+}
+
+// WithMaxWildcardSubscriptions limits the number of subscriptions using
+// wildcard patterns, which are the most expensive to match. Subscribe returns
+// ErrSubscriptionLimit once the limit is reached. Zero means unlimited.
+func WithMaxWildcardSubscriptions(n int) Option {
+	return func(b *bus) {
+		if n >= 0 {
+			b.registry.maxWildcards = n
+		}
+	}
+}
+
+// WithSubscriptionStacks records the call stack that created each
+// subscription, reported in SubscriptionInfo.Stack to help locate leaked
+// subscriptions. Capturing stacks adds overhead to Subscribe.
+func WithSubscriptionStacks(enabled bool) Option {
+	return func(b *bus) {
+		b.registry.captureStacks = enabled
+	}
+}
+
+// WithDefaultMetadata attaches metadata (service name, environment, version)
+// to every message published on the bus. Metadata already set on a message,
+// such as causation IDs, is not overwritten. Calling it more than once merges
+// the entries.
+func WithDefaultMetadata(metadata map[string]interface{}) Option {
+	return func(b *bus) {
+		if b.defaultMetadata == nil {
+			b.defaultMetadata = make(map[string]interface{}, len(metadata))
+		}
+		for k, v := range metadata {
+			b.defaultMetadata[k] = v
+		}
+	}
+}
+
+// PublishInterceptor is called for every message the bus creates for a
+// publish, before it is persisted or delivered, so it can add metadata such
+// as trace context. Wrappers such as AuditableBus and PersistentBus create
+// their messages through the bus, so interceptors apply to them as well.
+type PublishInterceptor func(ctx context.Context, msg Message)
+
+// WithPublishInterceptor adds interceptors run, in order, on every message
+// published on the bus.
+func WithPublishInterceptor(interceptors ...PublishInterceptor) Option {
+	return func(b *bus) {
+		b.interceptors = append(b.interceptors, interceptors...)
+	}
+}
+
+// WithTopicInheritance enables hierarchical topic delivery. When enabled,
+// publishing "orders.eu.created" also delivers to subscribers of the parent
+// topics "orders.eu" and "orders" without requiring wildcard patterns.
+func WithTopicInheritance(enabled bool) Option {
+	return func(b *bus) {
+		b.inherit = enabled
+	}
+}
+
 // New creates a new message bus with the given options.
 func New(opts ...Option) Bus {
 	b := &bus{
 		registry:   newSubscriptionRegistry(),
 		middleware: make([]Middleware, 0),
-		workers:    10,                         // Default number of workers
-		queue:      make(chan *envelope, 1000), // Buffered channel
+		workers:    10, // Default number of workers
+		queueSize:  defaultQueueSize,
+		weights:    defaultPriorityWeights,
 		maxRetries: 3,
 		observers:  newObserverRegistry(),
+		pauses:     newPauseRegistry(),
+		acks:       newAckTracker(),
+		retries:    newRetryTracker(),
 	}
+	b.wheel = newTimerWheel(wheelTick, wheelSlots, b.fireScheduled)
 
 	// Apply options
 	for _, opt := range opts {
 		opt(b)
 	}
 
-	// Start worker pool
-	for i := 0; i < b.workers; i++ {
-		b.wg.Add(1)
-		go b.worker()
+	// One buffered queue per priority level, unless a scheduler orders them
+	if b.scheduler != nil {
+		b.queue = newSchedulerQueue(b.scheduler, b.queueSize)
+	} else {
+		q := newPriorityQueue(b.queueSize, b.weights)
+		if b.agingThreshold > 0 {
+			q.aging = newAgingState(b.agingThreshold)
+		}
+		b.queue = q
+	}
+
+	// One lane per partition worker
+	if b.partitions == 0 {
+		b.partitions = b.workers
+	}
+	b.laneStop = make(chan struct{})
+	b.urgent = newUrgentGate()
+	b.lanes = make([]*partitionLane, b.partitions)
+	for i := range b.lanes {
+		b.lanes[i] = newPartitionLane(b.queueSize, b.laneStop)
+	}
+
+	if !b.startPaused {
+		b.startWorkers()
 	}
 
 	return b
 }
 
-// worker processes messages from the queue.
-func (b *bus) worker() {
+// startWorkers starts the worker pool and the partition workers.
+func (b *bus) startWorkers() {
+	b.started = true
+	for i := 0; i < b.workers; i++ {
+		b.wg.Add(1)
+		go b.worker(b.queue.reader(i))
+	}
+	for _, l := range b.lanes {
+		b.wg.Add(1)
+		go b.partitionWorker(l)
+	}
+}
+
+// worker processes messages from the queue until it is closed and drained.
+func (b *bus) worker(r envelopeSource) {
 	defer b.wg.Done()
 
-	for env := range b.queue {
+	for {
+		env, ok := r.next()
+		if !ok {
+			return
+		}
 		b.processMessage(env)
 	}
 }
 
-// processMessage processes a single message envelope.
-func (b *bus) processMessage(env *envelope) {
-	ctx := context.Background()
+// processMessage processes a single queued message.
+func (b *bus) processMessage(env *Envelope) {
+	env.leaveQueue()
 
-	handlers := b.registry.GetHandlers(env.msg.Topic())
-	if len(handlers) == 0 {
+	// Messages past their delivery deadline are dropped, retries included
+	if env.ctx.expired() {
+		b.observers.NotifyDrop(context.Background(), env.msg, context.DeadlineExceeded)
+		return
+	}
+	if IsExpired(env.msg, time.Now()) {
+		b.expire(env.msg)
+		return
+	}
+	// Messages on paused topics wait for ResumeTopic
+	if b.pauses.hold(env) {
+		return
+	}
+	ctx, cancel := env.ctx.restore(context.Background())
+	defer cancel()
+	if env.lane != nil {
+		ctx = context.WithValue(ctx, laneContextKey{}, env.lane)
+	}
+
+	var subs []*subscription
+	if env.sub != nil {
+		env.sub = b.claimant(env)
+		subs = []*subscription{env.sub}
+	} else {
+		subs = b.subscriptionsFor(env.msg.Topic())
+	}
+	if len(subs) == 0 {
 		return
 	}
 
+	// Handle the message
+	failed, pending, err := b.deliver(ctx, env.msg, subs)
+
+	b.latency.record(env.msg)
+
+	// Notify observers
+	b.observers.NotifyMessageProcessed(ctx, env.msg, err)
+
+	env.delivery.fork(len(pending))
+	b.awaitAcks(env, pending)
+
+	if err == nil {
+		env.delivery.finish(env.msg)
+		return
+	}
+
+	// Retry only the subscriptions that failed, each with its own policy.
+	// Errors raised by middleware retry the whole message.
+	if env.sub != nil || len(failed) == 0 {
+		env.err = err
+		b.handleError(env)
+		return
+	}
+	env.delivery.fork(len(failed) - 1)
+	for _, f := range failed {
+		b.handleError(&Envelope{
+			msg:      env.msg,
+			retries:  env.retries,
+			priority: env.priority,
+			sub:      f.sub,
+			err:      f.err,
+			ctx:      env.ctx,
+			lane:     env.lane,
+			delivery: env.delivery,
+		})
+	}
+}
+
+// deliver runs msg through the middleware chain and all subscription
+// handlers, returning the subscriptions whose handler failed and those
+// waiting for an acknowledgement (see WithManualAck). The handler context
+// carries msg so that messages published by handlers record it as their
+// cause, and the bus so that handlers can Respond.
+func (b *bus) deliver(ctx context.Context, msg Message, subs []*subscription) ([]deliveryFailure, []pendingAck, error) {
+	var failed []deliveryFailure
+	var pending []pendingAck
+
 	// Apply middleware
 	finalHandler := b.wrapWithMiddleware(HandlerFunc(func(ctx context.Context, msg Message) error {
+		// Middleware may call the handler more than once; keep the last run
+		failed = failed[:0]
+		pending = pending[:0]
+
 		// Execute all matching handlers
 		var lastErr error
-		for _, h := range handlers {
-			if err := h.Handle(ctx, msg); err != nil {
+		for _, sub := range subs {
+			err := b.protect(ctx, msg, sub.handle)
+			if p, ok := err.(*ackPending); ok {
+				pending = append(pending, pendingAck{sub: sub, acker: p.acker})
+				continue
+			}
+			if err != nil {
+				failed = append(failed, deliveryFailure{sub: sub, err: err})
 				lastErr = err
 			}
 		}
 		return lastErr
 	}))
 
-	// Handle the message
-	err := finalHandler.Handle(ctx, env.msg)
+	err := b.protect(ContextWithMessage(contextWithBus(ctx, b), msg), msg, finalHandler.Handle)
+	return failed, pending, err
+}
 
-	// Notify observers
-	b.observers.NotifyMessageProcessed(ctx, env.msg, err)
+// subscriptionsFor returns the subscriptions that should receive a message
+// on topic, one per queue group.
+func (b *bus) subscriptionsFor(topic string) []*subscription {
+	return b.registry.pickQueueMembers(b.matchingSubscriptions(topic))
+}
 
-	if err != nil {
-		b.handleError(env)
+// matchingSubscriptions returns every subscription matching topic.
+func (b *bus) matchingSubscriptions(topic string) []*subscription {
+	if b.inherit {
+		return b.registry.GetSubscriptionsWithAncestors(topic)
 	}
+	return b.registry.GetSubscriptions(topic)
 }
 
-// handleError handles a message processing error with retry logic.
-func (b *bus) handleError(env *envelope) {
+// handleError handles a message processing error with retry logic. Retries
+// of a single subscription follow its policy where it overrides the bus.
+func (b *bus) handleError(env *Envelope) {
 	env.retries++
 
-	if env.retries < b.maxRetries {
+	maxRetries, backoff, dlqHandler, dlqTopic := b.maxRetries, b.backoff, b.dlqHandler, ""
+	if env.sub != nil {
+		cfg := env.sub.config
+		if cfg.hasMaxRetries {
+			maxRetries = cfg.maxRetries
+		}
+		if cfg.backoff != nil {
+			backoff = cfg.backoff
+		}
+		if cfg.dlqHandler != nil {
+			dlqHandler = cfg.dlqHandler
+		}
+		// Never route a dead letter back to its own topic
+		if cfg.dlqTopic != "" && cfg.dlqTopic != env.msg.Topic() {
+			dlqTopic = cfg.dlqTopic
+		}
+	}
+
+	// Retrying cannot fix permanent failures
+	if env.retries < maxRetries && !IsPermanent(env.err) {
+		var delay time.Duration
+		if backoff != nil {
+			delay = backoff(env.retries)
+		}
+		b.observers.NotifyRetry(context.Background(), env.msg, env.retries, delay, env.err)
+
+		// Escalate before the last attempt; retries keep their priority otherwise
+		if b.escalateFinal && env.retries == maxRetries-1 && env.priority < b.finalPriority {
+			env.priority = b.finalPriority
+		}
+
 		// Retry the message
-		b.queue <- env
+		// Keyed messages are retried by their partition worker, in order
+		if env.lane != nil {
+			env.lane.retry(env, delay)
+			return
+		}
+		b.scheduleRetry(env, delay)
 		return
 	}
 
 	// Max retries exceeded, send to DLQ
-	if b.dlqHandler != nil {
-		ctx := context.Background()
-		_ = b.dlqHandler.Handle(ctx, env.msg)
+	ctx := context.Background()
+	b.observers.NotifyDeadLetter(ctx, env.msg, env.retries, env.err)
+	env.delivery.finish(env.msg)
+	dead := deadLetterMessage(env)
+	if dlqTopic != "" {
+		_ = b.publishDeadLetter(ctx, dlqTopic, dead)
+		return
+	}
+	if dlqHandler != nil {
+		_ = dlqHandler.Handle(ctx, dead)
 	}
 }
 
+// publishDeadLetter publishes a dead-lettered message to topic, recording
+// the original message as its cause. Once Close was called, the queue no
+// longer accepts messages and the dead letter is delivered right away, so
+// that it is not lost.
+func (b *bus) publishDeadLetter(ctx context.Context, topic string, dead Message) error {
+	msg := b.newMessage(ContextWithMessage(ctx, dead), topic, dead.Payload(), MessagePriority(dead))
+	delete(msg.Metadata(), MetadataExpiresAt)
+	for k, v := range dead.Metadata() {
+		if _, exists := msg.Metadata()[k]; !exists {
+			msg.Metadata()[k] = v
+		}
+	}
+
+	err := b.publishMessages(ctx, []Message{msg}, false)
+	if err == nil || !b.isClosing() {
+		return err
+	}
+	b.observers.NotifyPublish(ctx, topic, msg)
+	b.processMessage(&Envelope{
+		msg:      msg,
+		priority: MessagePriority(msg),
+		ctx:      b.captureContext(ctx),
+	})
+	return nil
+}
+
 // Publish publishes a message asynchronously.
 func (b *bus) Publish(ctx context.Context, topic string, payload interface{}) error {
 	b.mu.RLock()
 	defer b.mu.RUnlock()
 
 	if b.closed {
-		return fmt.Errorf("bus is closed")
+		return ErrBusClosed
+	}
+	if err := b.checkPayload(topic, payload); err != nil {
+		return err
 	}
 
-	msg := NewMessage(topic, payload)
+	msg := b.newMessage(ctx, topic, payload, PriorityNormal)
 
 	// Notify observers
 	b.observers.NotifyPublish(ctx, topic, msg)
 
-	env := &envelope{
+	env := &Envelope{
 		msg:      msg,
 		priority: PriorityNormal,
+		ctx:      b.captureContext(ctx),
 	}
 
-	select {
-	case b.queue <- env:
-		return nil
-	case <-ctx.Done():
-		return ctx.Err()
-	}
+	return b.enqueue(ctx, env)
 }
 
 // PublishSync publishes a message synchronously, waiting for all handlers to complete.
@@ -171,38 +581,61 @@ func (b *bus) PublishSync(ctx context.Context, topic string, payload interface{}
 	defer b.mu.RUnlock()
 
 	if b.closed {
-		return fmt.Errorf("bus is closed")
+		return ErrBusClosed
+	}
+	if !b.started {
+		return fmt.Errorf("cannot deliver %s synchronously: %w", topic, ErrNotStarted)
+	}
+	if b.pauses.isPaused(topic) {
+		return fmt.Errorf("cannot deliver %s synchronously: %w", topic, ErrTopicPaused)
+	}
+	if err := b.checkPayload(topic, payload); err != nil {
+		return err
 	}
 
-	msg := NewMessage(topic, payload)
+	priority := PriorityNormal
+	urgent := isUrgent(ctx)
+	if urgent {
+		priority = PriorityUrgent
+	}
+	msg := b.newMessage(ctx, topic, payload, priority)
 
 	// Notify observers
 	b.observers.NotifyPublish(ctx, topic, msg)
 
-	handlers := b.registry.GetHandlers(topic)
+	subs := b.subscriptionsFor(topic)
 
-	if len(handlers) == 0 {
+	if len(subs) == 0 {
 		return nil
 	}
+	if IsExpired(msg, time.Now()) {
+		b.expire(msg)
+		return fmt.Errorf("cannot deliver %s synchronously: %w", topic, ErrMessageExpired)
+	}
 
-	// Apply middleware
-	finalHandler := b.wrapWithMiddleware(HandlerFunc(func(ctx context.Context, msg Message) error {
-		// Execute all matching handlers synchronously
-		var lastErr error
-		for _, h := range handlers {
-			if err := h.Handle(ctx, msg); err != nil {
-				lastErr = err
-			}
-		}
-		return lastErr
-	}))
+	captured := b.captureContext(ctx)
+	if captured.expired() {
+		return context.DeadlineExceeded
+	}
+	ctx, cancel := captured.restore(ctx)
+	defer cancel()
 
-	err := finalHandler.Handle(ctx, msg)
+	deliver := func(ctx context.Context) error {
+		_, pending, err := b.deliver(ctx, msg, subs)
+		b.latency.record(msg)
 
-	// Notify observers
-	b.observers.NotifyMessageProcessed(ctx, msg, err)
+		// Notify observers
+		b.observers.NotifyMessageProcessed(ctx, msg, err)
 
-	return err
+		// Unacknowledged messages are retried asynchronously
+		b.awaitAcks(&Envelope{msg: msg, priority: priority, ctx: captured}, pending)
+
+		return err
+	}
+	if urgent {
+		return b.deliverUrgent(ctx, msg, deliver)
+	}
+	return deliver(ctx)
 }
 
 // PublishWithPriority publishes a message asynchronously with the specified priority.
@@ -211,48 +644,197 @@ func (b *bus) PublishWithPriority(ctx context.Context, topic string, payload int
 	defer b.mu.RUnlock()
 
 	if b.closed {
-		return fmt.Errorf("bus is closed")
+		return ErrBusClosed
 	}
 
 	// Check context before proceeding
 	if err := ctx.Err(); err != nil {
 		return err
 	}
+	if err := b.checkPayload(topic, payload); err != nil {
+		return err
+	}
 
-	msg := NewMessage(topic, payload)
+	msg := b.newMessage(ctx, topic, payload, priority)
 
 	// Notify observers
 	b.observers.NotifyPublish(ctx, topic, msg)
 
-	env := &envelope{
+	env := &Envelope{
 		msg:      msg,
 		priority: priority,
+		ctx:      b.captureContext(ctx),
 	}
 
-	select {
-	case b.queue <- env:
+	return b.enqueue(ctx, env)
+}
+
+// PublishBatch publishes several messages asynchronously. The bus lock is
+// acquired once for the whole batch and observers are notified once.
+func (b *bus) PublishBatch(ctx context.Context, batch []TopicPayload) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	if b.closed {
+		return ErrBusClosed
+	}
+
+	if len(batch) == 0 {
 		return nil
-	case <-ctx.Done():
-		return ctx.Err()
 	}
+	for _, entry := range batch {
+		if err := b.checkPayload(entry.Topic, entry.Payload); err != nil {
+			return err
+		}
+	}
+
+	messages := make([]Message, len(batch))
+	for i, entry := range batch {
+		messages[i] = b.newMessage(ctx, entry.Topic, entry.Payload, PriorityNormal)
+	}
+
+	// Notify observers
+	b.observers.NotifyPublishBatch(ctx, messages)
+
+	captured := b.captureContext(ctx)
+	for i, msg := range messages {
+		env := &Envelope{
+			msg:      msg,
+			priority: PriorityNormal,
+			ctx:      captured,
+		}
+
+		if err := b.enqueue(ctx, env); err != nil {
+			return fmt.Errorf("batch interrupted after %d of %d messages: %w", i, len(messages), err)
+		}
+	}
+
+	return nil
+}
+
+// newMessage creates a message published on the bus, carrying its cause from
+// ctx and the default metadata, and runs the publish interceptors.
+func (b *bus) newMessage(ctx context.Context, topic string, payload interface{}, priority Priority) Message {
+	msg := newCausedMessage(ctx, topic, payload, priority)
+	stampExpiry(ctx, msg, b.ttl)
+
+	metadata := msg.Metadata()
+	for k, v := range b.defaultMetadata {
+		if _, exists := metadata[k]; !exists {
+			metadata[k] = v
+		}
+	}
+	if b.sequences != nil {
+		b.sequences.stamp(msg)
+	}
+	for _, intercept := range b.interceptors {
+		intercept(ctx, msg)
+	}
+	b.recordPublished(msg)
+
+	return msg
+}
+
+// publishMessages enqueues already built messages, so wrappers that persist
+// a message deliver the same message (and ID) they stored. Observers are
+// notified as by PublishBatch when batch is set, and as by Publish otherwise.
+func (b *bus) publishMessages(ctx context.Context, msgs []Message, batch bool) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	if b.closed {
+		return ErrBusClosed
+	}
+
+	// Notify observers
+	if batch {
+		b.observers.NotifyPublishBatch(ctx, msgs)
+	} else {
+		for _, msg := range msgs {
+			b.observers.NotifyPublish(ctx, msg.Topic(), msg)
+		}
+	}
+
+	captured := b.captureContext(ctx)
+	delivered := deliveredHook(ctx)
+	for _, msg := range msgs {
+		b.recordPublished(msg)
+		env := &Envelope{
+			msg:      msg,
+			priority: MessagePriority(msg),
+			ctx:      captured,
+			delivery: newDeliveryState(delivered),
+		}
+
+		if err := b.enqueue(ctx, env); err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
 
 // Subscribe subscribes a handler to a topic pattern.
 func (b *bus) Subscribe(pattern string, handler Handler) (Subscription, error) {
+	return b.SubscribeWithOptions(pattern, handler)
+}
+
+// SubscribeWithOptions subscribes a handler to a topic pattern with
+// per-subscription options.
+func (b *bus) SubscribeWithOptions(pattern string, handler Handler, opts ...SubscriptionOption) (Subscription, error) {
 	b.mu.RLock()
 	defer b.mu.RUnlock()
 
 	if b.closed {
-		return nil, fmt.Errorf("bus is closed")
+		return nil, ErrBusClosed
+	}
+
+	var cfg subscriptionConfig
+	for _, opt := range opts {
+		opt(&cfg)
 	}
 
-	sub, err := b.registry.Add(pattern, handler, b)
+	sub, err := b.registry.AddWithConfig(pattern, handler, b, cfg)
 	if err == nil {
-		b.observers.NotifySubscribe(pattern)
+		b.observers.NotifySubscribe(cfg.owner, pattern)
 	}
 	return sub, err
 }
 
+// subscribeAll implements bulkSubscriber.
+func (b *bus) subscribeAll(set SubscriptionSet) ([]Subscription, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	if b.closed {
+		return nil, ErrBusClosed
+	}
+
+	specs := make([]addSpec, len(set))
+	for i, spec := range set {
+		specs[i] = addSpec{pattern: spec.Pattern, handler: spec.Handler}
+		for _, opt := range spec.Options {
+			opt(&specs[i].config)
+		}
+	}
+
+	added, err := b.registry.AddAll(specs, b)
+	if err != nil {
+		return nil, err
+	}
+	subs := make([]Subscription, len(added))
+	for i, sub := range added {
+		b.observers.NotifySubscribe(specs[i].config.owner, sub.pattern)
+		subs[i] = sub
+	}
+	return subs, nil
+}
+
+// Subscriptions implements SubscriptionInspector.
+func (b *bus) Subscriptions() []SubscriptionInfo {
+	return b.registry.List()
+}
+
 // unsubscribe removes a subscription by ID.
 func (b *bus) unsubscribe(id string) error {
 	// Get pattern before removing
@@ -262,11 +844,16 @@ func (b *bus) unsubscribe(id string) error {
 
 	err := b.registry.Remove(id)
 	if err == nil && exists {
-		b.observers.NotifyUnsubscribe(sub.pattern)
+		b.observers.NotifyUnsubscribe(sub.config.owner, sub.pattern)
 	}
 	return err
 }
 
+// notifyStoreError forwards a persistence failure to the bus observers.
+func (b *bus) notifyStoreError(ctx context.Context, err *StoreError) {
+	b.observers.NotifyStoreError(ctx, err)
+}
+
 // Use adds middleware to the bus.
 func (b *bus) Use(middleware ...Middleware) {
 	b.mu.Lock()
@@ -283,22 +870,52 @@ func (b *bus) wrapWithMiddleware(handler Handler) Handler {
 	return handler
 }
 
-// Close gracefully shuts down the bus.
+// Close gracefully shuts down the bus. Everything it reports happens before
+// it returns, in this order:
+//
+//  1. Publishing stops; publishes and subscribes fail from now on.
+//  2. Messages waiting for their acknowledgement are reported to observers
+//     with ErrNotAcknowledged, retries not queued yet with
+//     ErrRetryAbandoned, and scheduled messages not due yet with ErrNotDue.
+//  3. The workers deliver the queued messages. Their immediate retries on
+//     partition lanes still run, other retries are abandoned, and dead
+//     letters reach their handler or dead letter topic.
+//  4. Messages held by paused topics are reported with ErrTopicPaused.
+//  5. Subscriptions are removed and observers get OnClose.
 func (b *bus) Close() error {
 	b.mu.Lock()
 	if b.closed {
 		b.mu.Unlock()
-		return fmt.Errorf("bus already closed")
+		return ErrBusClosed
 	}
 	b.closed = true
 	b.mu.Unlock()
 
-	// Close the queue to signal workers to stop
-	close(b.queue)
+	// Abandon deliveries waiting for their acknowledgement and retries not
+	// queued yet, so that nothing is queued once the queues are closed
+	b.closeAcks()
+	b.closeRetries()
+
+	// Scheduled messages not due yet are reported
+	b.closeScheduled()
+
+	// Close the queues to signal workers to stop
+	b.queue.close()
+	b.closeLanes()
 
 	// Wait for all workers to finish
 	b.wg.Wait()
 
+	// Messages buffered by a bus that was never started are dropped
+	if !b.started {
+		b.dropQueued()
+	}
+
+	// Messages still held by paused topics are dropped
+	for _, env := range b.pauses.drain() {
+		b.observers.NotifyDrop(context.Background(), env.msg, ErrTopicPaused)
+	}
+
 	// Clear all subscriptions
 	b.registry.Clear()
 
@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -261,3 +262,194 @@ func TestBus_RaceConditions(t *testing.T) {
 	<-done
 	// If we reach here without race detector errors, test passes
 }
+
+type pointerHandler struct {
+	calls int32
+}
+
+func (h *pointerHandler) Handle(ctx context.Context, msg Message) error {
+	atomic.AddInt32(&h.calls, 1)
+	return nil
+}
+
+func TestBus_DuplicateSubscriptions(t *testing.T) {
+	noop := HandlerFunc(func(ctx context.Context, msg Message) error { return nil })
+
+	t.Run("allow by default", func(t *testing.T) {
+		bus := New()
+		defer bus.Close()
+
+		h := &pointerHandler{}
+		bus.Subscribe("test", h)
+		bus.Subscribe("test", h)
+		bus.PublishSync(context.Background(), "test", nil)
+
+		if got := atomic.LoadInt32(&h.calls); got != 2 {
+			t.Errorf("Expected 2 deliveries, got %d", got)
+		}
+	})
+
+	t.Run("reject", func(t *testing.T) {
+		bus := New(WithDuplicateSubscriptions(DuplicateReject))
+		defer bus.Close()
+
+		h := &pointerHandler{}
+		if _, err := bus.Subscribe("test", h); err != nil {
+			t.Fatalf("Subscribe() error = %v", err)
+		}
+		if _, err := bus.Subscribe("test", h); !errors.Is(err, ErrDuplicateSubscription) {
+			t.Errorf("Expected ErrDuplicateSubscription, got %v", err)
+		}
+		if _, err := bus.Subscribe("other", h); err != nil {
+			t.Errorf("Subscribe() to another pattern error = %v", err)
+		}
+
+		if _, err := bus.Subscribe("test", WithHandlerKey("audit", noop)); err != nil {
+			t.Fatalf("Subscribe() keyed error = %v", err)
+		}
+		if _, err := bus.Subscribe("test", WithHandlerKey("audit", noop)); !errors.Is(err, ErrDuplicateSubscription) {
+			t.Errorf("Expected ErrDuplicateSubscription for same key, got %v", err)
+		}
+
+		// Plain functions have no identity and are never considered duplicates
+		if _, err := bus.Subscribe("test", noop); err != nil {
+			t.Errorf("Subscribe() func error = %v", err)
+		}
+		if _, err := bus.Subscribe("test", noop); err != nil {
+			t.Errorf("Subscribe() func again error = %v", err)
+		}
+	})
+
+	t.Run("consolidate", func(t *testing.T) {
+		bus := New(WithDuplicateSubscriptions(DuplicateConsolidate))
+		defer bus.Close()
+
+		h := &pointerHandler{}
+		first, _ := bus.Subscribe("test", h)
+		second, err := bus.Subscribe("test", h)
+		if err != nil {
+			t.Fatalf("Subscribe() error = %v", err)
+		}
+		if first != second {
+			t.Error("Expected duplicate subscription to return the existing subscription")
+		}
+
+		bus.PublishSync(context.Background(), "test", nil)
+		if got := atomic.LoadInt32(&h.calls); got != 1 {
+			t.Errorf("Expected 1 delivery, got %d", got)
+		}
+	})
+}
+
+func TestBus_SubscriptionLimits(t *testing.T) {
+	bus := New(WithMaxSubscriptions(3), WithMaxWildcardSubscriptions(1))
+	defer bus.Close()
+
+	noop := HandlerFunc(func(ctx context.Context, msg Message) error { return nil })
+
+	if _, err := bus.Subscribe("user.*", noop); err != nil {
+		t.Fatalf("Subscribe() error = %v", err)
+	}
+	if _, err := bus.Subscribe("*.created", noop); !errors.Is(err, ErrSubscriptionLimit) {
+		t.Errorf("Expected ErrSubscriptionLimit for second wildcard, got %v", err)
+	}
+
+	sub, err := bus.Subscribe("user.created", noop)
+	if err != nil {
+		t.Fatalf("Subscribe() error = %v", err)
+	}
+	if _, err := bus.Subscribe("user.updated", noop); err != nil {
+		t.Fatalf("Subscribe() error = %v", err)
+	}
+	if _, err := bus.Subscribe("user.deleted", noop); !errors.Is(err, ErrSubscriptionLimit) {
+		t.Errorf("Expected ErrSubscriptionLimit over total limit, got %v", err)
+	}
+
+	// Unsubscribing frees capacity
+	if err := sub.Unsubscribe(); err != nil {
+		t.Fatalf("Unsubscribe() error = %v", err)
+	}
+	if _, err := bus.Subscribe("user.deleted", noop); err != nil {
+		t.Errorf("Subscribe() after Unsubscribe error = %v", err)
+	}
+}
+
+func TestSubscription_UnsubscribeAndWait(t *testing.T) {
+	bus := New()
+	defer bus.Close()
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	var finished atomic.Bool
+
+	sub, _ := bus.Subscribe("slow", HandlerFunc(func(ctx context.Context, msg Message) error {
+		close(started)
+		<-release
+		finished.Store(true)
+		return nil
+	}))
+
+	_ = bus.Publish(context.Background(), "slow", nil)
+	<-started
+
+	// Times out while the handler is still running
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := sub.UnsubscribeAndWait(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected deadline exceeded, got %v", err)
+	}
+
+	if finished.Load() {
+		t.Error("Expected handler to still be running")
+	}
+	close(release)
+
+	if err := sub.UnsubscribeAndWait(context.Background()); err == nil {
+		t.Error("Expected error unsubscribing twice")
+	}
+}
+
+func TestSubscription_UnsubscribeAndWaitCompletes(t *testing.T) {
+	bus := New()
+	defer bus.Close()
+
+	started := make(chan struct{})
+	var finished atomic.Bool
+
+	sub, _ := bus.Subscribe("slow", HandlerFunc(func(ctx context.Context, msg Message) error {
+		close(started)
+		time.Sleep(30 * time.Millisecond)
+		finished.Store(true)
+		return nil
+	}))
+
+	_ = bus.Publish(context.Background(), "slow", nil)
+	<-started
+
+	if err := sub.UnsubscribeAndWait(context.Background()); err != nil {
+		t.Fatalf("UnsubscribeAndWait() error = %v", err)
+	}
+	if !finished.Load() {
+		t.Error("Expected UnsubscribeAndWait to return after the handler finished")
+	}
+}
+
+func TestSubscription_NoDeliveryAfterUnsubscribe(t *testing.T) {
+	b := New().(*bus)
+	defer b.Close()
+
+	var calls atomic.Int32
+	sub, _ := b.Subscribe("test", HandlerFunc(func(ctx context.Context, msg Message) error {
+		calls.Add(1)
+		return nil
+	}))
+
+	// A worker that matched the subscription before it was removed
+	subs := b.subscriptionsFor("test")
+	_ = sub.Unsubscribe()
+	_, _, _ = b.deliver(context.Background(), NewMessage("test", nil), subs)
+
+	if calls.Load() != 0 {
+		t.Error("Expected no delivery after unsubscribe")
+	}
+}
@@ -2,6 +2,7 @@ package scela
 
 import (
 	"context"
+	"errors"
 	"sync"
 	"sync/atomic"
 	"testing"
@@ -355,3 +356,246 @@ func BenchmarkBus_PublishAsync(b *testing.B) {
 		bus.Publish(ctx, "bench.topic", "payload")
 	}
 }
+
+func TestBus_TopicInheritance(t *testing.T) {
+	bus := New(WithTopicInheritance(true))
+	defer bus.Close()
+
+	var mu sync.Mutex
+	received := make(map[string]int)
+	subscribe := func(pattern string) {
+		_, err := bus.Subscribe(pattern, HandlerFunc(func(ctx context.Context, msg Message) error {
+			mu.Lock()
+			received[pattern]++
+			mu.Unlock()
+			return nil
+		}))
+		if err != nil {
+			t.Fatalf("Subscribe(%s) error = %v", pattern, err)
+		}
+	}
+
+	subscribe("orders")
+	subscribe("orders.eu")
+	subscribe("orders.eu.created")
+	subscribe("orders.us")
+	subscribe("orders.*")
+
+	if err := bus.PublishSync(context.Background(), "orders.eu.created", nil); err != nil {
+		t.Fatalf("PublishSync() error = %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	want := map[string]int{"orders": 1, "orders.eu": 1, "orders.eu.created": 1, "orders.*": 1}
+	for pattern, count := range want {
+		if received[pattern] != count {
+			t.Errorf("Expected %d deliveries to %q, got %d", count, pattern, received[pattern])
+		}
+	}
+	if received["orders.us"] != 0 {
+		t.Errorf("Expected no deliveries to sibling topic, got %d", received["orders.us"])
+	}
+}
+
+func TestBus_TopicInheritanceDisabled(t *testing.T) {
+	bus := New()
+	defer bus.Close()
+
+	var received int32
+	_, err := bus.Subscribe("orders", HandlerFunc(func(ctx context.Context, msg Message) error {
+		atomic.AddInt32(&received, 1)
+		return nil
+	}))
+	if err != nil {
+		t.Fatalf("Subscribe() error = %v", err)
+	}
+
+	if err := bus.PublishSync(context.Background(), "orders.eu.created", nil); err != nil {
+		t.Fatalf("PublishSync() error = %v", err)
+	}
+
+	if got := atomic.LoadInt32(&received); got != 0 {
+		t.Errorf("Expected no parent delivery without inheritance, got %d", got)
+	}
+}
+
+type countingObserver struct {
+	mu        sync.Mutex
+	publishes int
+	batches   int
+}
+
+func (o *countingObserver) OnPublish(ctx context.Context, topic string, msg Message) {
+	o.mu.Lock()
+	o.publishes++
+	o.mu.Unlock()
+}
+
+func (o *countingObserver) OnSubscribe(pattern string)                                     {}
+func (o *countingObserver) OnUnsubscribe(pattern string)                                   {}
+func (o *countingObserver) OnMessageProcessed(ctx context.Context, msg Message, err error) {}
+func (o *countingObserver) OnClose()                                                       {}
+
+type countingBatchObserver struct {
+	countingObserver
+}
+
+func (o *countingBatchObserver) OnPublishBatch(ctx context.Context, msgs []Message) {
+	o.mu.Lock()
+	o.batches++
+	o.mu.Unlock()
+}
+
+func TestBus_PublishBatch(t *testing.T) {
+	plain := &countingObserver{}
+	batched := &countingBatchObserver{}
+	bus := New(WithObserver(plain), WithObserver(batched))
+	defer bus.Close()
+
+	var wg sync.WaitGroup
+	var received int32
+	_, err := bus.Subscribe("batch.*", HandlerFunc(func(ctx context.Context, msg Message) error {
+		atomic.AddInt32(&received, 1)
+		wg.Done()
+		return nil
+	}))
+	if err != nil {
+		t.Fatalf("Subscribe() error = %v", err)
+	}
+
+	batch := []TopicPayload{
+		{Topic: "batch.a", Payload: 1},
+		{Topic: "batch.b", Payload: 2},
+		{Topic: "batch.c", Payload: 3},
+	}
+	wg.Add(len(batch))
+
+	if err := bus.PublishBatch(context.Background(), batch); err != nil {
+		t.Fatalf("PublishBatch() error = %v", err)
+	}
+	wg.Wait()
+
+	if got := atomic.LoadInt32(&received); got != 3 {
+		t.Errorf("Expected 3 messages received, got %d", got)
+	}
+	if plain.publishes != 3 {
+		t.Errorf("Expected 3 OnPublish calls for plain observer, got %d", plain.publishes)
+	}
+	if batched.batches != 1 || batched.publishes != 0 {
+		t.Errorf("Expected 1 batch notification and no OnPublish, got %d and %d", batched.batches, batched.publishes)
+	}
+}
+
+func TestBus_PublishBatchClosed(t *testing.T) {
+	bus := New()
+	bus.Close()
+
+	err := bus.PublishBatch(context.Background(), []TopicPayload{{Topic: "test"}})
+	if err == nil {
+		t.Error("Expected error when publishing batch to closed bus")
+	}
+}
+
+func TestBus_PublishBatchContextCanceled(t *testing.T) {
+	bus := New(WithWorkers(1))
+	defer bus.Close()
+
+	block := make(chan struct{})
+	defer close(block)
+	_, _ = bus.Subscribe("test", HandlerFunc(func(ctx context.Context, msg Message) error {
+		<-block
+		return nil
+	}))
+
+	batch := make([]TopicPayload, 1100)
+	for i := range batch {
+		batch[i] = TopicPayload{Topic: "test", Payload: i}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	err := bus.PublishBatch(ctx, batch)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected context.DeadlineExceeded, got %v", err)
+	}
+}
+
+func TestBus_DefaultMetadata(t *testing.T) {
+	bus := New(
+		WithDefaultMetadata(map[string]interface{}{"service": "billing", "env": "test"}),
+		WithDefaultMetadata(map[string]interface{}{"version": "1.2.0"}),
+	)
+	defer bus.Close()
+
+	received := make(chan Message, 2)
+	_, _ = bus.Subscribe("invoice.*", HandlerFunc(func(ctx context.Context, msg Message) error {
+		received <- msg
+		if msg.Topic() == "invoice.created" {
+			return bus.PublishSync(ctx, "invoice.sent", nil)
+		}
+		return nil
+	}))
+
+	if err := bus.PublishSync(context.Background(), "invoice.created", nil); err != nil {
+		t.Fatalf("PublishSync() error = %v", err)
+	}
+
+	created, sent := <-received, <-received
+	for _, msg := range []Message{created, sent} {
+		md := msg.Metadata()
+		if md["service"] != "billing" || md["env"] != "test" || md["version"] != "1.2.0" {
+			t.Errorf("Message %s missing default metadata: %v", msg.Topic(), md)
+		}
+	}
+	if CausationID(sent) != created.ID() {
+		t.Error("Expected default metadata not to replace causation metadata")
+	}
+}
+
+func TestPersistentBus_DefaultMetadata(t *testing.T) {
+	store := NewInMemoryStore(10)
+	pb := NewPersistentBus(New(WithDefaultMetadata(map[string]interface{}{"service": "billing"})), store)
+	defer pb.Close()
+
+	_ = pb.Publish(context.Background(), "invoice.created", nil)
+
+	stored, _ := store.Load(context.Background())
+	if len(stored) != 1 || stored[0].Metadata()["service"] != "billing" {
+		t.Errorf("Expected stored message to carry default metadata, got %v", stored)
+	}
+}
+
+func TestBus_PublishInterceptor(t *testing.T) {
+	type requestKey struct{}
+
+	inner := New(WithPublishInterceptor(func(ctx context.Context, msg Message) {
+		if id, ok := ctx.Value(requestKey{}).(string); ok {
+			msg.Metadata()["request"] = id
+		}
+	}))
+	store := NewInMemoryStore(10)
+	bus := NewPersistentBus(inner, store)
+	defer bus.Close()
+
+	received := make(chan Message, 1)
+	_, _ = bus.Subscribe("test", HandlerFunc(func(ctx context.Context, msg Message) error {
+		received <- msg
+		return nil
+	}))
+
+	ctx := context.WithValue(context.Background(), requestKey{}, "req-1")
+	if err := bus.Publish(ctx, "test", nil); err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+
+	if msg := <-received; msg.Metadata()["request"] != "req-1" {
+		t.Errorf("Expected intercepted metadata on the delivered message, got %v", msg.Metadata())
+	}
+	stored, _ := store.Load(context.Background())
+	if len(stored) != 1 || stored[0].Metadata()["request"] != "req-1" {
+		t.Errorf("Expected intercepted metadata on the stored message")
+	}
+}
@@ -0,0 +1,80 @@
+package scela
+
+import "context"
+
+// Metadata keys used for causation tracking.
+const (
+	// MetadataCausationID holds the ID of the message whose handler
+	// published this message.
+	MetadataCausationID = "causation_id"
+	// MetadataCorrelationID holds the ID of the message that started the
+	// chain this message belongs to.
+	MetadataCorrelationID = "correlation_id"
+)
+
+// messageContextKey is the context key under which the message being
+// handled is stored.
+type messageContextKey struct{}
+
+// ContextWithMessage returns a context carrying msg as the message being
+// handled. The bus does this for every handler invocation; messages published
+// with the returned context record msg as their cause.
+func ContextWithMessage(ctx context.Context, msg Message) context.Context {
+	return context.WithValue(ctx, messageContextKey{}, msg)
+}
+
+// MessageFromContext returns the message being handled, if any.
+func MessageFromContext(ctx context.Context) (Message, bool) {
+	msg, ok := ctx.Value(messageContextKey{}).(Message)
+	return msg, ok
+}
+
+// CausationID returns the ID of the message that caused msg, or "" if msg
+// was not published from a handler.
+func CausationID(msg Message) string {
+	id, _ := msg.Metadata()[MetadataCausationID].(string)
+	return id
+}
+
+// CorrelationID returns the ID of the first message in msg's causal chain.
+// A message that was not caused by another message is its own correlation root.
+func CorrelationID(msg Message) string {
+	if id, ok := msg.Metadata()[MetadataCorrelationID].(string); ok {
+		return id
+	}
+	return msg.ID()
+}
+
+// newCausedMessage creates a message. When ctx carries the message being
+// handled, it is recorded as the cause of the new message; when ctx carries
+// a caller identity or request/reply routing, the message is stamped with it.
+func newCausedMessage(ctx context.Context, topic string, payload interface{}, priority Priority) Message {
+	msg := NewMessageWithPriority(topic, payload, priority)
+
+	if parent, ok := MessageFromContext(ctx); ok {
+		msg.Metadata()[MetadataCausationID] = parent.ID()
+		msg.Metadata()[MetadataCorrelationID] = CorrelationID(parent)
+	}
+	if identity, ok := IdentityFromContext(ctx); ok {
+		msg.Metadata()[MetadataIdentity] = identity
+	}
+	stampReplyRoute(ctx, msg)
+	stampPartitionKey(ctx, msg)
+	stampDeliveryTime(ctx, msg)
+
+	return msg
+}
+
+// messageFactory is implemented by buses that enrich the messages they
+// create, so wrappers building messages themselves get the same result.
+type messageFactory interface {
+	newMessage(ctx context.Context, topic string, payload interface{}, priority Priority) Message
+}
+
+// newBusMessage creates a message as bus would for a publish.
+func newBusMessage(ctx context.Context, bus Bus, topic string, payload interface{}, priority Priority) Message {
+	if f, ok := bus.(messageFactory); ok {
+		return f.newMessage(ctx, topic, payload, priority)
+	}
+	return newCausedMessage(ctx, topic, payload, priority)
+}
@@ -0,0 +1,150 @@
+package scela
+
+import (
+	"context"
+	"testing"
+)
+
+func TestCausation_HandlerPublishes(t *testing.T) {
+	bus := New()
+	defer bus.Close()
+
+	var root, child, grandchild Message
+
+	bus.Subscribe("order.placed", HandlerFunc(func(ctx context.Context, msg Message) error {
+		root = msg
+		return bus.PublishSync(ctx, "payment.requested", nil)
+	}))
+	bus.Subscribe("payment.requested", HandlerFunc(func(ctx context.Context, msg Message) error {
+		child = msg
+		return bus.PublishSync(ctx, "payment.completed", nil)
+	}))
+	bus.Subscribe("payment.completed", HandlerFunc(func(ctx context.Context, msg Message) error {
+		grandchild = msg
+		return nil
+	}))
+
+	if err := bus.PublishSync(context.Background(), "order.placed", nil); err != nil {
+		t.Fatalf("PublishSync() error = %v", err)
+	}
+
+	if CausationID(root) != "" {
+		t.Errorf("Expected root message without causation ID, got %q", CausationID(root))
+	}
+	if CausationID(child) != root.ID() {
+		t.Errorf("Child causation ID = %q, want %q", CausationID(child), root.ID())
+	}
+	if CausationID(grandchild) != child.ID() {
+		t.Errorf("Grandchild causation ID = %q, want %q", CausationID(grandchild), child.ID())
+	}
+	if CorrelationID(grandchild) != root.ID() || CorrelationID(root) != root.ID() {
+		t.Errorf("Expected chain to be correlated with root %q", root.ID())
+	}
+}
+
+func TestCausation_AsyncPublish(t *testing.T) {
+	bus := New()
+	defer bus.Close()
+
+	caused := make(chan Message, 1)
+	var parentID string
+
+	bus.Subscribe("child", HandlerFunc(func(ctx context.Context, msg Message) error {
+		caused <- msg
+		return nil
+	}))
+
+	ctx := ContextWithMessage(context.Background(), NewMessage("parent", nil))
+	parent, _ := MessageFromContext(ctx)
+	parentID = parent.ID()
+
+	if err := bus.Publish(ctx, "child", nil); err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+
+	if msg := <-caused; CausationID(msg) != parentID {
+		t.Errorf("Causation ID = %q, want %q", CausationID(msg), parentID)
+	}
+}
+
+func TestMessageHistory_CausalTree(t *testing.T) {
+	history := NewMessageHistory(100)
+	bus := New()
+	defer bus.Close()
+	bus.Use(HistoryMiddleware(history))
+
+	bus.Subscribe("order.placed", HandlerFunc(func(ctx context.Context, msg Message) error {
+		_ = bus.PublishSync(ctx, "stock.reserved", nil)
+		return bus.PublishSync(ctx, "payment.requested", nil)
+	}))
+	bus.Subscribe("payment.requested", HandlerFunc(func(ctx context.Context, msg Message) error {
+		return bus.PublishSync(ctx, "payment.completed", nil)
+	}))
+	bus.Subscribe("*.*", HandlerFunc(func(ctx context.Context, msg Message) error {
+		return nil
+	}))
+
+	if err := bus.PublishSync(context.Background(), "order.placed", nil); err != nil {
+		t.Fatalf("PublishSync() error = %v", err)
+	}
+
+	roots := history.GetByTopic("order.placed")
+	if len(roots) == 0 {
+		t.Fatal("Root message not recorded")
+	}
+
+	tree := history.GetCausalTree(roots[0].Message.ID())
+	if tree == nil {
+		t.Fatal("GetCausalTree() returned nil")
+	}
+	if len(tree.Children) != 2 {
+		t.Fatalf("Expected 2 direct children, got %d", len(tree.Children))
+	}
+
+	var payment *CausalNode
+	for _, c := range tree.Children {
+		if c.Message.Topic() == "payment.requested" {
+			payment = c
+		}
+	}
+	if payment == nil || len(payment.Children) != 1 || payment.Children[0].Message.Topic() != "payment.completed" {
+		t.Errorf("Expected payment.requested -> payment.completed, got %+v", payment)
+	}
+
+	if got := history.GetCausedBy(roots[0].Message.ID()); len(got) != 2 {
+		t.Errorf("GetCausedBy() returned %d messages, want 2", len(got))
+	}
+	if history.GetCausalTree("unknown") != nil {
+		t.Error("Expected nil tree for unknown message")
+	}
+}
+
+func TestMessageHistory_CausalTreeThroughAuditableBus(t *testing.T) {
+	history := NewMessageHistory(100)
+	bus := New()
+	defer bus.Close()
+	ab := NewAuditableBus(bus, history)
+
+	done := make(chan struct{})
+	ab.Subscribe("order.placed", HandlerFunc(func(ctx context.Context, msg Message) error {
+		return ab.Publish(ctx, "payment.requested", nil)
+	}))
+	ab.Subscribe("payment.requested", HandlerFunc(func(ctx context.Context, msg Message) error {
+		close(done)
+		return nil
+	}))
+
+	if err := ab.Publish(context.Background(), "order.placed", nil); err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+	<-done
+
+	roots := history.GetByTopic("order.placed")
+	if len(roots) != 1 {
+		t.Fatalf("Expected the root message recorded once, got %d", len(roots))
+	}
+	caused := history.GetCausedBy(roots[0].Message.ID())
+	if len(caused) != 1 || caused[0].Topic() != "payment.requested" {
+		t.Errorf("GetCausedBy() = %v, want the payment request", caused)
+	}
+}
@@ -0,0 +1,211 @@
+// Package chaos provides fault-injection middleware for testing how code
+// built on scela copes with slow handlers, failures, panics and duplicate
+// deliveries.
+//
+// Faults are injected at random with the configured probabilities:
+//
+//	injector := chaos.New(
+//		chaos.WithErrors(0.1),
+//		chaos.WithDuplicates(0.05),
+//		chaos.WithDelay(0.2, 10*time.Millisecond, 100*time.Millisecond),
+//	)
+//	bus.Use(injector.Middleware())
+//
+// Injected panics are raised as is; install a recovering middleware in
+// front of the chaos middleware when testing with WithPanics.
+package chaos
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/toutaio/toutago-scela-bus/pkg/scela"
+)
+
+// ErrInjected is returned by deliveries failed by WithErrors.
+var ErrInjected = errors.New("chaos: injected failure")
+
+// Option configures an Injector.
+type Option func(*Injector)
+
+// WithDelay delays deliveries with the given probability by a random
+// duration between min and max.
+func WithDelay(probability float64, min, max time.Duration) Option {
+	return func(i *Injector) {
+		i.delayProbability = probability
+		i.minDelay = min
+		i.maxDelay = max
+	}
+}
+
+// WithErrors fails deliveries with the given probability, before the handler
+// runs, returning ErrInjected.
+func WithErrors(probability float64) Option {
+	return func(i *Injector) {
+		i.errorProbability = probability
+	}
+}
+
+// WithPanics makes deliveries panic with the given probability, before the
+// handler runs.
+func WithPanics(probability float64) Option {
+	return func(i *Injector) {
+		i.panicProbability = probability
+	}
+}
+
+// WithDuplicates delivers messages twice with the given probability. The
+// duplicate is only delivered if the first delivery succeeded.
+func WithDuplicates(probability float64) Option {
+	return func(i *Injector) {
+		i.duplicateProbability = probability
+	}
+}
+
+// WithFilter restricts faults to messages accepted by filter. Other messages
+// pass through untouched.
+func WithFilter(filter scela.Filter) Option {
+	return func(i *Injector) {
+		i.filter = filter
+	}
+}
+
+// WithSeed seeds the random source so that a run can be reproduced.
+func WithSeed(seed int64) Option {
+	return func(i *Injector) {
+		i.rand = rand.New(rand.NewSource(seed)) // #nosec G404 -- fault injection does not need a secure source
+	}
+}
+
+// Stats counts the faults injected so far.
+type Stats struct {
+	Delays     int64
+	Errors     int64
+	Panics     int64
+	Duplicates int64
+}
+
+// Injector injects faults into message deliveries.
+type Injector struct {
+	delayProbability     float64
+	minDelay, maxDelay   time.Duration
+	errorProbability     float64
+	panicProbability     float64
+	duplicateProbability float64
+	filter               scela.Filter
+
+	mu   sync.Mutex
+	rand *rand.Rand
+
+	disabled   atomic.Bool
+	delays     atomic.Int64
+	errors     atomic.Int64
+	panics     atomic.Int64
+	duplicates atomic.Int64
+}
+
+// New creates an injector. Without options it injects no faults.
+func New(opts ...Option) *Injector {
+	i := &Injector{
+		rand: rand.New(rand.NewSource(time.Now().UnixNano())), // #nosec G404 -- fault injection does not need a secure source
+	}
+	for _, opt := range opts {
+		opt(i)
+	}
+	return i
+}
+
+// Middleware returns a middleware injecting faults with a new Injector.
+func Middleware(opts ...Option) scela.Middleware {
+	return New(opts...).Middleware()
+}
+
+// Middleware returns a middleware injecting the configured faults.
+func (i *Injector) Middleware() scela.Middleware {
+	return func(next scela.Handler) scela.Handler {
+		return scela.HandlerFunc(func(ctx context.Context, msg scela.Message) error {
+			if i.disabled.Load() || (i.filter != nil && !i.filter(msg)) {
+				return next.Handle(ctx, msg)
+			}
+
+			if i.roll(i.delayProbability) {
+				i.delays.Add(1)
+				if err := sleep(ctx, i.delay()); err != nil {
+					return err
+				}
+			}
+			if i.roll(i.panicProbability) {
+				i.panics.Add(1)
+				panic("chaos: injected panic handling message " + msg.ID())
+			}
+			if i.roll(i.errorProbability) {
+				i.errors.Add(1)
+				return ErrInjected
+			}
+
+			if err := next.Handle(ctx, msg); err != nil {
+				return err
+			}
+			if i.roll(i.duplicateProbability) {
+				i.duplicates.Add(1)
+				return next.Handle(ctx, msg)
+			}
+			return nil
+		})
+	}
+}
+
+// SetEnabled turns fault injection on or off, for example to let a system
+// recover at the end of a test.
+func (i *Injector) SetEnabled(enabled bool) {
+	i.disabled.Store(!enabled)
+}
+
+// Stats returns the number of faults injected so far.
+func (i *Injector) Stats() Stats {
+	return Stats{
+		Delays:     i.delays.Load(),
+		Errors:     i.errors.Load(),
+		Panics:     i.panics.Load(),
+		Duplicates: i.duplicates.Load(),
+	}
+}
+
+// roll reports whether a fault with the given probability occurs.
+func (i *Injector) roll(probability float64) bool {
+	if probability <= 0 {
+		return false
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.rand.Float64() < probability
+}
+
+// delay returns a random duration between the configured bounds.
+func (i *Injector) delay() time.Duration {
+	if i.maxDelay <= i.minDelay {
+		return i.minDelay
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.minDelay + time.Duration(i.rand.Int63n(int64(i.maxDelay-i.minDelay)+1))
+}
+
+// sleep waits for d or until ctx is done.
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
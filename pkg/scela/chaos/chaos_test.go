@@ -0,0 +1,154 @@
+package chaos
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/toutaio/toutago-scela-bus/pkg/scela"
+)
+
+// countingHandler counts handler calls.
+func countingHandler(calls *int) scela.Handler {
+	return scela.HandlerFunc(func(ctx context.Context, msg scela.Message) error {
+		*calls++
+		return nil
+	})
+}
+
+func TestInjector_NoFaultsByDefault(t *testing.T) {
+	var calls int
+	h := New().Middleware()(countingHandler(&calls))
+
+	for i := 0; i < 100; i++ {
+		if err := h.Handle(context.Background(), scela.NewMessage("test", i)); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if calls != 100 {
+		t.Errorf("expected 100 calls, got %d", calls)
+	}
+	if New().Stats() != (Stats{}) {
+		t.Error("expected no faults recorded")
+	}
+}
+
+func TestInjector_Errors(t *testing.T) {
+	var calls int
+	injector := New(WithErrors(1))
+	h := injector.Middleware()(countingHandler(&calls))
+
+	err := h.Handle(context.Background(), scela.NewMessage("test", nil))
+	if !errors.Is(err, ErrInjected) {
+		t.Errorf("expected ErrInjected, got %v", err)
+	}
+	if calls != 0 {
+		t.Errorf("expected the handler not to run, got %d calls", calls)
+	}
+	if injector.Stats().Errors != 1 {
+		t.Errorf("expected 1 injected error, got %+v", injector.Stats())
+	}
+}
+
+func TestInjector_Panics(t *testing.T) {
+	var calls int
+	h := Middleware(WithPanics(1))(countingHandler(&calls))
+
+	defer func() {
+		if recover() == nil {
+			t.Error("expected an injected panic")
+		}
+	}()
+	_ = h.Handle(context.Background(), scela.NewMessage("test", nil))
+}
+
+func TestInjector_Duplicates(t *testing.T) {
+	var calls int
+	injector := New(WithDuplicates(1))
+	h := injector.Middleware()(countingHandler(&calls))
+
+	_ = h.Handle(context.Background(), scela.NewMessage("test", nil))
+	if calls != 2 {
+		t.Errorf("expected a duplicate delivery, got %d calls", calls)
+	}
+	if injector.Stats().Duplicates != 1 {
+		t.Errorf("expected 1 duplicate, got %+v", injector.Stats())
+	}
+}
+
+func TestInjector_Delay(t *testing.T) {
+	var calls int
+	h := Middleware(WithDelay(1, 20*time.Millisecond, 30*time.Millisecond))(countingHandler(&calls))
+
+	start := time.Now()
+	_ = h.Handle(context.Background(), scela.NewMessage("test", nil))
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond {
+		t.Errorf("expected a delay of at least 20ms, got %v", elapsed)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := h.Handle(ctx, scela.NewMessage("test", nil)); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected the delay to stop with the context, got %v", err)
+	}
+}
+
+func TestInjector_FilterAndDisable(t *testing.T) {
+	var calls int
+	injector := New(WithErrors(1), WithFilter(scela.TopicFilter("order.created")))
+	h := injector.Middleware()(countingHandler(&calls))
+
+	if err := h.Handle(context.Background(), scela.NewMessage("user.created", nil)); err != nil {
+		t.Errorf("expected filtered out topic to pass, got %v", err)
+	}
+
+	injector.SetEnabled(false)
+	if err := h.Handle(context.Background(), scela.NewMessage("order.created", nil)); err != nil {
+		t.Errorf("expected disabled injector to pass, got %v", err)
+	}
+	injector.SetEnabled(true)
+	if err := h.Handle(context.Background(), scela.NewMessage("order.created", nil)); !errors.Is(err, ErrInjected) {
+		t.Errorf("expected ErrInjected once re-enabled, got %v", err)
+	}
+}
+
+func TestInjector_SeedIsReproducible(t *testing.T) {
+	run := func() []bool {
+		h := Middleware(WithErrors(0.5), WithSeed(42))(scela.HandlerFunc(func(ctx context.Context, msg scela.Message) error {
+			return nil
+		}))
+		var failed []bool
+		for i := 0; i < 20; i++ {
+			failed = append(failed, h.Handle(context.Background(), scela.NewMessage("test", i)) != nil)
+		}
+		return failed
+	}
+
+	a, b := run(), run()
+	for i := range a {
+		if a[i] != b[i] {
+			t.Fatalf("runs with the same seed differ at %d", i)
+		}
+	}
+}
+
+func TestInjector_RetriesRecoverFromErrors(t *testing.T) {
+	bus := scela.New(scela.WithMaxRetries(50))
+	defer bus.Close()
+
+	bus.Use(Middleware(WithErrors(0.5), WithSeed(1)))
+
+	done := make(chan struct{})
+	_, _ = bus.Subscribe("test", scela.HandlerFunc(func(ctx context.Context, msg scela.Message) error {
+		close(done)
+		return nil
+	}))
+	_ = bus.Publish(context.Background(), "test", nil)
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("message was not delivered despite retries")
+	}
+}
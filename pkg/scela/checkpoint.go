@@ -0,0 +1,166 @@
+package scela
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Checkpoint records the last stored message a projection applied.
+type Checkpoint struct {
+	MessageID string
+	// Position is the position of the message in store order, counted from
+	// 0. It lets a QueryableStore load the messages after it directly, and
+	// is checked against MessageID, so a stale position only costs a full
+	// load.
+	Position int
+	// Timestamp is the timestamp of the message, used to carry on when the
+	// message itself was removed from the store.
+	Timestamp time.Time
+}
+
+// CheckpointStore keeps the checkpoints of projections by name.
+type CheckpointStore interface {
+	// LoadCheckpoint returns the checkpoint of name. It reports false if
+	// there is none.
+	LoadCheckpoint(ctx context.Context, name string) (Checkpoint, bool, error)
+
+	// SaveCheckpoint sets the checkpoint of name.
+	SaveCheckpoint(ctx context.Context, name string, cp Checkpoint) error
+
+	// DeleteCheckpoint removes the checkpoint of name, if any.
+	DeleteCheckpoint(ctx context.Context, name string) error
+}
+
+// InMemoryCheckpointStore keeps checkpoints in memory, for projections
+// rebuilt at every start and for tests.
+type InMemoryCheckpointStore struct {
+	mu          sync.RWMutex
+	checkpoints map[string]Checkpoint
+}
+
+// NewInMemoryCheckpointStore creates an empty in-memory checkpoint store.
+func NewInMemoryCheckpointStore() *InMemoryCheckpointStore {
+	return &InMemoryCheckpointStore{checkpoints: make(map[string]Checkpoint)}
+}
+
+// LoadCheckpoint implements CheckpointStore.
+func (s *InMemoryCheckpointStore) LoadCheckpoint(ctx context.Context, name string) (Checkpoint, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	cp, ok := s.checkpoints[name]
+	return cp, ok, nil
+}
+
+// SaveCheckpoint implements CheckpointStore.
+func (s *InMemoryCheckpointStore) SaveCheckpoint(ctx context.Context, name string, cp Checkpoint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.checkpoints[name] = cp
+	return nil
+}
+
+// DeleteCheckpoint implements CheckpointStore.
+func (s *InMemoryCheckpointStore) DeleteCheckpoint(ctx context.Context, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.checkpoints, name)
+	return nil
+}
+
+// SQLCheckpointStoreConfig configures a SQLCheckpointStore.
+type SQLCheckpointStoreConfig struct {
+	DB *sql.DB
+	// TableName defaults to scela_checkpoints.
+	TableName string
+	// Dialect defaults to DialectSQLite.
+	Dialect Dialect
+}
+
+// SQLCheckpointStore keeps checkpoints in a SQL table, which can live in
+// the database of the read models so that a checkpoint is saved in the
+// same place as the changes it covers.
+type SQLCheckpointStore struct {
+	db        *sql.DB
+	tableName string
+	dialect   Dialect
+}
+
+// NewSQLCheckpointStore creates a checkpoint store, creating its table if
+// it does not exist.
+func NewSQLCheckpointStore(config SQLCheckpointStoreConfig) (*SQLCheckpointStore, error) {
+	if config.DB == nil {
+		return nil, fmt.Errorf("database connection is required")
+	}
+	if config.TableName == "" {
+		config.TableName = "scela_checkpoints"
+	}
+	if !validTableName.MatchString(config.TableName) {
+		return nil, fmt.Errorf(
+			"invalid table name: must contain only letters, numbers, and underscores, " +
+				"and start with a letter or underscore",
+		)
+	}
+	if config.Dialect == "" {
+		config.Dialect = DialectSQLite
+	}
+	if err := config.Dialect.validate(); err != nil {
+		return nil, err
+	}
+
+	s := &SQLCheckpointStore{db: config.DB, tableName: config.TableName, dialect: config.Dialect}
+
+	d := s.dialect
+	// #nosec G201 -- tableName is validated above, types are constants
+	query := fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS %s (
+			name %s PRIMARY KEY,
+			message_id %s NOT NULL,
+			position %s NOT NULL,
+			timestamp %s NOT NULL
+		)
+	`, s.tableName, d.keyType(), d.keyType(), d.integerType(), d.timestampType())
+	if _, err := s.db.Exec(query); err != nil {
+		return nil, fmt.Errorf("failed to create table: %w", err)
+	}
+	return s, nil
+}
+
+// LoadCheckpoint implements CheckpointStore.
+func (s *SQLCheckpointStore) LoadCheckpoint(ctx context.Context, name string) (Checkpoint, bool, error) {
+	// #nosec G201 -- tableName is validated in NewSQLCheckpointStore
+	query := fmt.Sprintf("SELECT message_id, position, timestamp FROM %s WHERE name = ?", s.tableName)
+
+	var cp Checkpoint
+	err := s.db.QueryRowContext(ctx, s.dialect.rebind(query), name).Scan(&cp.MessageID, &cp.Position, &cp.Timestamp)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Checkpoint{}, false, nil
+	}
+	if err != nil {
+		return Checkpoint{}, false, fmt.Errorf("failed to load checkpoint %s: %w", name, err)
+	}
+	return cp, true, nil
+}
+
+// SaveCheckpoint implements CheckpointStore.
+func (s *SQLCheckpointStore) SaveCheckpoint(ctx context.Context, name string, cp Checkpoint) error {
+	query := s.dialect.upsert(s.tableName, "name",
+		[]string{"name", "message_id", "position", "timestamp"}, []string{"message_id", "position", "timestamp"})
+	if _, err := s.db.ExecContext(ctx, query, name, cp.MessageID, cp.Position, cp.Timestamp); err != nil {
+		return fmt.Errorf("failed to save checkpoint %s: %w", name, err)
+	}
+	return nil
+}
+
+// DeleteCheckpoint implements CheckpointStore.
+func (s *SQLCheckpointStore) DeleteCheckpoint(ctx context.Context, name string) error {
+	// #nosec G201 -- tableName is validated in NewSQLCheckpointStore
+	query := fmt.Sprintf("DELETE FROM %s WHERE name = ?", s.tableName)
+	if _, err := s.db.ExecContext(ctx, s.dialect.rebind(query), name); err != nil {
+		return fmt.Errorf("failed to delete checkpoint %s: %w", name, err)
+	}
+	return nil
+}
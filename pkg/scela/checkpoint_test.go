@@ -0,0 +1,88 @@
+package scela
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestSQLCheckpointStore(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	if _, err := NewSQLCheckpointStore(SQLCheckpointStoreConfig{DB: db, TableName: "bad name"}); err == nil {
+		t.Error("expected an invalid table name rejected")
+	}
+	checkpoints, err := NewSQLCheckpointStore(SQLCheckpointStoreConfig{DB: db})
+	if err != nil {
+		t.Fatalf("NewSQLCheckpointStore: %v", err)
+	}
+	ctx := context.Background()
+
+	if _, ok, err := checkpoints.LoadCheckpoint(ctx, "orders"); ok || err != nil {
+		t.Fatalf("expected no checkpoint, got %v, %v", ok, err)
+	}
+
+	at := time.Date(2026, 3, 1, 12, 0, 0, 500, time.UTC)
+	for i, id := range []string{"m-1", "m-2"} {
+		if err := checkpoints.SaveCheckpoint(ctx, "orders", Checkpoint{MessageID: id, Position: i, Timestamp: at}); err != nil {
+			t.Fatalf("SaveCheckpoint: %v", err)
+		}
+	}
+	cp, ok, err := checkpoints.LoadCheckpoint(ctx, "orders")
+	if err != nil || !ok || cp.MessageID != "m-2" || cp.Position != 1 || !cp.Timestamp.Equal(at) {
+		t.Fatalf("LoadCheckpoint() = %+v, %v, %v", cp, ok, err)
+	}
+
+	if err := checkpoints.DeleteCheckpoint(ctx, "orders"); err != nil {
+		t.Fatalf("DeleteCheckpoint: %v", err)
+	}
+	if _, ok, _ := checkpoints.LoadCheckpoint(ctx, "orders"); ok {
+		t.Error("expected the checkpoint deleted")
+	}
+}
+
+func TestProjector_SQLCheckpointsSurviveRestart(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	store, err := NewSQLStore(SQLStoreConfig{DB: db})
+	if err != nil {
+		t.Fatalf("NewSQLStore: %v", err)
+	}
+	checkpoints, err := NewSQLCheckpointStore(SQLCheckpointStoreConfig{DB: db})
+	if err != nil {
+		t.Fatalf("NewSQLCheckpointStore: %v", err)
+	}
+	ctx := context.Background()
+
+	count := func() (int, error) {
+		var n int
+		projection := projectionFuncs{apply: func(msg Message) { n++ }}
+		_, err := NewProjector("counter", projection, store, WithCheckpoints(checkpoints)).CatchUp(ctx)
+		return n, err
+	}
+
+	for i := 0; i < 3; i++ {
+		_ = store.Store(ctx, NewMessage("order.placed", i))
+	}
+	if n, err := count(); err != nil || n != 3 {
+		t.Fatalf("first run applied %d, %v", n, err)
+	}
+	_ = store.Store(ctx, NewMessage("order.placed", 3))
+	if n, err := count(); err != nil || n != 1 {
+		t.Errorf("expected the restarted projector to apply only the new message, got %d, %v", n, err)
+	}
+}
+
+// projectionFuncs adapts a function to the Projection interface.
+type projectionFuncs struct {
+	apply func(msg Message)
+}
+
+func (p projectionFuncs) Apply(ctx context.Context, msg Message) error {
+	p.apply(msg)
+	return nil
+}
+
+func (p projectionFuncs) Reset(ctx context.Context) error { return nil }
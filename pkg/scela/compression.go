@@ -0,0 +1,257 @@
+package scela
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"strings"
+	"sync/atomic"
+)
+
+// Compressor compresses stored records independently of the payload serializer.
+type Compressor interface {
+	// Name identifies the compression algorithm in stored records.
+	Name() string
+
+	// Compress compresses data.
+	Compress(data []byte) ([]byte, error)
+
+	// Decompress reverses Compress.
+	Decompress(data []byte) ([]byte, error)
+}
+
+// GzipCompressor compresses records with gzip.
+type GzipCompressor struct {
+	level int
+}
+
+// NewGzipCompressor creates a gzip compressor with the given compression level.
+// Invalid levels fall back to gzip.DefaultCompression.
+func NewGzipCompressor(level int) *GzipCompressor {
+	if level < gzip.HuffmanOnly || level > gzip.BestCompression {
+		level = gzip.DefaultCompression
+	}
+	return &GzipCompressor{level: level}
+}
+
+// Name implements Compressor.
+func (c *GzipCompressor) Name() string {
+	return "gzip"
+}
+
+// Compress implements Compressor.
+func (c *GzipCompressor) Compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := gzip.NewWriterLevel(&buf, c.level)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Decompress implements Compressor.
+func (c *GzipCompressor) Decompress(data []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = r.Close() }()
+	return io.ReadAll(r)
+}
+
+// CompressionSerializer is a Serializer compressing the data of another
+// above a size threshold, for payloads too large to store as they are.
+// Compressed data starts with a header naming the compressor, which the
+// serialized data of JSON and protobuf never starts with, so data written
+// before compression was enabled remains readable.
+//
+// Unlike store-level compression (see WithFileCompression), it applies
+// wherever the serializer is used, such as with SerializableMessage.
+type CompressionSerializer struct {
+	serializer  Serializer
+	compression *recordCompressor
+}
+
+// NewCompressionSerializer creates a serializer compressing the data of
+// serializer, JSON if nil, with compressor when it reaches threshold bytes.
+func NewCompressionSerializer(serializer Serializer, compressor Compressor, threshold int) *CompressionSerializer {
+	if serializer == nil {
+		serializer = NewJSONSerializer()
+	}
+	compression := newRecordCompressor(compressor, threshold)
+	compression.prefix = serializedPrefix
+	return &CompressionSerializer{serializer: serializer, compression: compression}
+}
+
+// Serialize implements Serializer.
+func (c *CompressionSerializer) Serialize(payload interface{}) ([]byte, error) {
+	data, err := c.serializer.Serialize(payload)
+	if err != nil {
+		return nil, err
+	}
+	stored, _, err := c.compression.encode(data)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(stored), nil
+}
+
+// Deserialize implements Serializer.
+func (c *CompressionSerializer) Deserialize(data []byte, target interface{}) error {
+	data, err := c.compression.decode(string(data))
+	if err != nil {
+		return err
+	}
+	return c.serializer.Deserialize(data, target)
+}
+
+// Binary implements BinarySerializer, reporting whether the wrapped
+// serializer is binary. Compressed data is text.
+func (c *CompressionSerializer) Binary() bool {
+	bs, ok := c.serializer.(BinarySerializer)
+	return ok && bs.Binary()
+}
+
+// CompressionStats returns compression statistics for the data serialized
+// so far.
+func (c *CompressionSerializer) CompressionStats() CompressionStats {
+	return c.compression.stats()
+}
+
+// CompressionStats reports how effective store-level compression has been.
+type CompressionStats struct {
+	// Records is the number of records written.
+	Records int64
+	// CompressedRecords is the number of records that exceeded the threshold
+	// and were stored compressed.
+	CompressedRecords int64
+	// BytesIn is the total size of records before compression.
+	BytesIn int64
+	// BytesOut is the total size of records as written.
+	BytesOut int64
+}
+
+// Ratio returns BytesOut / BytesIn, or 1 when nothing was written.
+func (s CompressionStats) Ratio() float64 {
+	if s.BytesIn == 0 {
+		return 1
+	}
+	return float64(s.BytesOut) / float64(s.BytesIn)
+}
+
+// compressedPrefix marks a record that was compressed by a store. The full
+// form is "scz1:<compressor>:<base64 data>".
+const compressedPrefix = "scz1:"
+
+// serializedPrefix marks payload data compressed by a CompressionSerializer,
+// in the same form as compressedPrefix. The prefixes differ so that stores
+// do not take such payloads for records they compressed themselves.
+const serializedPrefix = "scs1:"
+
+// recordCompressor applies a Compressor to records above a size threshold
+// and tracks compression statistics.
+type recordCompressor struct {
+	compressor Compressor
+	prefix     string
+	threshold  int
+
+	records           atomic.Int64
+	compressedRecords atomic.Int64
+	bytesIn           atomic.Int64
+	bytesOut          atomic.Int64
+}
+
+// newRecordCompressor creates a record compressor. A nil compressor disables
+// compression but still tracks record sizes.
+func newRecordCompressor(compressor Compressor, threshold int) *recordCompressor {
+	if threshold < 0 {
+		threshold = 0
+	}
+	return &recordCompressor{
+		compressor: compressor,
+		prefix:     compressedPrefix,
+		threshold:  threshold,
+	}
+}
+
+// encode returns the stored form of data and whether it was compressed.
+// Records below the threshold, or that would not shrink, are stored as-is.
+func (rc *recordCompressor) encode(data []byte) (string, bool, error) {
+	rc.records.Add(1)
+	rc.bytesIn.Add(int64(len(data)))
+
+	if rc.compressor == nil || len(data) < rc.threshold {
+		rc.bytesOut.Add(int64(len(data)))
+		return string(data), false, nil
+	}
+
+	compressed, err := rc.compressor.Compress(data)
+	if err != nil {
+		return "", false, fmt.Errorf("failed to compress record: %w", err)
+	}
+
+	encoded := rc.prefix + rc.compressor.Name() + ":" + base64.StdEncoding.EncodeToString(compressed)
+	if len(encoded) >= len(data) {
+		// Compression did not pay off for this record
+		rc.bytesOut.Add(int64(len(data)))
+		return string(data), false, nil
+	}
+
+	rc.compressedRecords.Add(1)
+	rc.bytesOut.Add(int64(len(encoded)))
+	return encoded, true, nil
+}
+
+// decode reverses encode. Records without the compression prefix are
+// returned unchanged, so stores can read data written before compression
+// was enabled.
+func (rc *recordCompressor) decode(stored string) ([]byte, error) {
+	if !strings.HasPrefix(stored, rc.prefix) {
+		return []byte(stored), nil
+	}
+
+	name, encoded, ok := strings.Cut(stored[len(rc.prefix):], ":")
+	if !ok {
+		return nil, fmt.Errorf("malformed compressed record")
+	}
+	if rc.compressor == nil || rc.compressor.Name() != name {
+		return nil, fmt.Errorf("record compressed with %q but no matching compressor is configured", name)
+	}
+
+	compressed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode compressed record: %w", err)
+	}
+
+	data, err := rc.compressor.Decompress(compressed)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress record: %w", err)
+	}
+	return data, nil
+}
+
+// reset clears the compression statistics.
+func (rc *recordCompressor) reset() {
+	rc.records.Store(0)
+	rc.compressedRecords.Store(0)
+	rc.bytesIn.Store(0)
+	rc.bytesOut.Store(0)
+}
+
+// stats returns a snapshot of the compression statistics.
+func (rc *recordCompressor) stats() CompressionStats {
+	return CompressionStats{
+		Records:           rc.records.Load(),
+		CompressedRecords: rc.compressedRecords.Load(),
+		BytesIn:           rc.bytesIn.Load(),
+		BytesOut:          rc.bytesOut.Load(),
+	}
+}
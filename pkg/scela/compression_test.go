@@ -0,0 +1,249 @@
+package scela
+
+import (
+	"compress/gzip"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGzipCompressor_RoundTrip(t *testing.T) {
+	c := NewGzipCompressor(-42)
+	data := []byte(strings.Repeat("scela ", 100))
+
+	compressed, err := c.Compress(data)
+	if err != nil {
+		t.Fatalf("Compress() error = %v", err)
+	}
+	if len(compressed) >= len(data) {
+		t.Errorf("Expected compressed size < %d, got %d", len(data), len(compressed))
+	}
+
+	decompressed, err := c.Decompress(compressed)
+	if err != nil {
+		t.Fatalf("Decompress() error = %v", err)
+	}
+	if string(decompressed) != string(data) {
+		t.Error("Decompress() did not restore original data")
+	}
+}
+
+func TestRecordCompressor_Threshold(t *testing.T) {
+	rc := newRecordCompressor(NewGzipCompressor(gzip.DefaultCompression), 64)
+
+	small, compressed, err := rc.encode([]byte(`"small"`))
+	if err != nil {
+		t.Fatalf("encode() error = %v", err)
+	}
+	if compressed || small != `"small"` {
+		t.Errorf("Expected record below threshold to be stored as-is, got %q", small)
+	}
+
+	large := []byte(`"` + strings.Repeat("a", 500) + `"`)
+	stored, compressed, err := rc.encode(large)
+	if err != nil {
+		t.Fatalf("encode() error = %v", err)
+	}
+	if !compressed || !strings.HasPrefix(stored, compressedPrefix+"gzip:") {
+		t.Errorf("Expected record above threshold to be compressed, got %q", stored)
+	}
+
+	decoded, err := rc.decode(stored)
+	if err != nil {
+		t.Fatalf("decode() error = %v", err)
+	}
+	if string(decoded) != string(large) {
+		t.Error("decode() did not restore original record")
+	}
+
+	stats := rc.stats()
+	if stats.Records != 2 || stats.CompressedRecords != 1 {
+		t.Errorf("Expected 2 records with 1 compressed, got %+v", stats)
+	}
+	if stats.Ratio() >= 1 {
+		t.Errorf("Expected compression ratio < 1, got %f", stats.Ratio())
+	}
+}
+
+func TestRecordCompressor_SkipsIneffectiveCompression(t *testing.T) {
+	rc := newRecordCompressor(NewGzipCompressor(gzip.NoCompression), 0)
+
+	stored, compressed, err := rc.encode([]byte("short record"))
+	if err != nil {
+		t.Fatalf("encode() error = %v", err)
+	}
+	if compressed || stored != "short record" {
+		t.Errorf("Expected record to be stored as-is when compression does not help, got %q", stored)
+	}
+}
+
+func TestRecordCompressor_MissingCompressor(t *testing.T) {
+	data := []byte(strings.Repeat("data", 100))
+	stored, _, _ := newRecordCompressor(NewGzipCompressor(gzip.DefaultCompression), 0).encode(data)
+
+	if _, err := newRecordCompressor(nil, 0).decode(stored); err == nil {
+		t.Error("Expected error decoding compressed record without a compressor")
+	}
+}
+
+func TestFileStore_Compression(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "compressed.json")
+	store := NewFileStore(path, WithFileCompression(NewGzipCompressor(gzip.DefaultCompression), 32))
+	ctx := context.Background()
+
+	payload := map[string]interface{}{"body": strings.Repeat("lorem ipsum ", 50)}
+	if err := store.Store(ctx, NewMessage("large", payload)); err != nil {
+		t.Fatalf("Store() error = %v", err)
+	}
+	if err := store.Store(ctx, NewMessage("small", "x")); err != nil {
+		t.Fatalf("Store() error = %v", err)
+	}
+
+	raw, _ := os.ReadFile(path)
+	if strings.Contains(string(raw), "lorem ipsum") {
+		t.Error("Expected large payload to be compressed on disk")
+	}
+
+	messages, err := store.Load(ctx)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if len(messages) != 2 {
+		t.Fatalf("Expected 2 messages, got %d", len(messages))
+	}
+
+	body := messages[0].Payload().(map[string]interface{})["body"]
+	if body != payload["body"] {
+		t.Error("Compressed payload was not restored")
+	}
+	if messages[1].Payload() != "x" {
+		t.Errorf("Expected small payload %q, got %v", "x", messages[1].Payload())
+	}
+
+	stats := store.CompressionStats()
+	if stats.Records != 2 || stats.CompressedRecords != 1 {
+		t.Errorf("Expected 2 records with 1 compressed, got %+v", stats)
+	}
+}
+
+func TestSQLStore_Compression(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	store, err := NewSQLStore(SQLStoreConfig{
+		DB:                   db,
+		Compressor:           NewGzipCompressor(gzip.DefaultCompression),
+		CompressionThreshold: 32,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create SQL store: %v", err)
+	}
+
+	ctx := context.Background()
+	payload := strings.Repeat("lorem ipsum ", 50)
+	if err := store.Store(ctx, NewMessage("large", payload)); err != nil {
+		t.Fatalf("Store() error = %v", err)
+	}
+
+	var stored string
+	if err := db.QueryRow("SELECT payload FROM scela_messages").Scan(&stored); err != nil {
+		t.Fatalf("Failed to read raw payload: %v", err)
+	}
+	if !strings.HasPrefix(stored, compressedPrefix) {
+		t.Errorf("Expected compressed payload in database, got %q", stored[:20])
+	}
+
+	messages, err := store.Load(ctx)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if len(messages) != 1 || messages[0].Payload() != payload {
+		t.Error("Compressed payload was not restored")
+	}
+
+	if ratio := store.CompressionStats().Ratio(); ratio >= 1 {
+		t.Errorf("Expected compression ratio < 1, got %f", ratio)
+	}
+}
+
+func TestCompressionSerializer(t *testing.T) {
+	s := NewCompressionSerializer(nil, NewGzipCompressor(gzip.DefaultCompression), 64)
+	payload := map[string]interface{}{"body": strings.Repeat("lorem ipsum ", 50)}
+
+	data, err := s.Serialize(payload)
+	if err != nil {
+		t.Fatalf("Serialize() error = %v", err)
+	}
+	if !strings.HasPrefix(string(data), serializedPrefix+"gzip:") {
+		t.Errorf("Expected compressed data with a header, got %.40q", data)
+	}
+	var got map[string]interface{}
+	if err := s.Deserialize(data, &got); err != nil || got["body"] != payload["body"] {
+		t.Errorf("Deserialize() = %v, %v", got, err)
+	}
+
+	small, _ := s.Serialize("x")
+	if string(small) != `"x"` {
+		t.Errorf("Expected small payloads stored as is, got %q", small)
+	}
+
+	// Data written before compression was enabled stays readable
+	var legacy string
+	if err := s.Deserialize([]byte(`"legacy"`), &legacy); err != nil || legacy != "legacy" {
+		t.Errorf("Deserialize() of uncompressed data = %q, %v", legacy, err)
+	}
+
+	if stats := s.CompressionStats(); stats.Records != 2 || stats.CompressedRecords != 1 {
+		t.Errorf("Expected 2 records with 1 compressed, got %+v", stats)
+	}
+	if s.Binary() || !NewCompressionSerializer(&binarySerializer{}, nil, 0).Binary() {
+		t.Error("Expected Binary() to follow the wrapped serializer")
+	}
+}
+
+func TestCompressionSerializer_Stores(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	serializer := NewCompressionSerializer(nil, NewGzipCompressor(gzip.DefaultCompression), 64)
+	sqlStore, err := NewSQLStore(SQLStoreConfig{DB: db, Serializer: serializer})
+	if err != nil {
+		t.Fatalf("NewSQLStore() error = %v", err)
+	}
+	stores := map[string]MessageStore{
+		"sql":  sqlStore,
+		"file": NewFileStore(filepath.Join(t.TempDir(), "messages.json"), WithFileSerializer(serializer)),
+	}
+
+	payload := map[string]interface{}{"body": strings.Repeat("lorem ipsum ", 50)}
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			if err := store.Store(ctx, NewMessage("large", payload)); err != nil {
+				t.Fatalf("Store() error = %v", err)
+			}
+			loaded, err := store.Load(ctx)
+			if err != nil || len(loaded) != 1 {
+				t.Fatalf("Load() = %v, %v", loaded, err)
+			}
+			if body := loaded[0].Payload().(map[string]interface{})["body"]; body != payload["body"] {
+				t.Error("Compressed payload was not restored")
+			}
+		})
+	}
+
+	// Rows written with plain JSON stay readable
+	if err := sqlStore.Clear(context.Background()); err != nil {
+		t.Fatalf("Clear() error = %v", err)
+	}
+	plain, _ := NewSQLStore(SQLStoreConfig{DB: db})
+	if err := plain.Store(context.Background(), NewMessage("small", "x")); err != nil {
+		t.Fatalf("Store() error = %v", err)
+	}
+	loaded, err := sqlStore.Load(context.Background())
+	if err != nil || len(loaded) != 1 || loaded[0].Payload() != "x" {
+		t.Errorf("Load() = %v, %v", loaded, err)
+	}
+}
@@ -0,0 +1,125 @@
+package scela
+
+import "time"
+
+// Config configures a bus created with NewWithConfig, for applications that
+// build the bus from configuration or in a dependency injection container
+// rather than with options. Zero fields keep the defaults of New.
+type Config struct {
+	// Workers is the number of worker goroutines, see WithWorkers.
+	Workers int
+	// Partitions is the number of partition workers, see WithPartitions.
+	Partitions int
+	// QueueSize is how many messages can wait at each priority level, see
+	// WithQueueSize.
+	QueueSize int
+	// OverflowPolicy is what publishing does when the queue is full, see
+	// WithOverflowPolicy.
+	OverflowPolicy OverflowPolicy
+
+	// MaxRetries is the number of retries of failed messages, see
+	// WithMaxRetries. Zero keeps the default of 3; a negative value
+	// disables retries.
+	MaxRetries int
+	// RetryBackoff is the delay between retries, see WithRetryBackoff.
+	RetryBackoff Backoff
+	// DeadLetterHandler gets the messages that failed every retry, see
+	// WithDeadLetterHandler.
+	DeadLetterHandler Handler
+
+	// RecoverPanics turns handler panics into errors, passing them to
+	// PanicHandler if set, see WithPanicHandler. Setting PanicHandler alone
+	// recovers panics too.
+	RecoverPanics bool
+	PanicHandler  PanicHandler
+
+	// TTL is the default time to live of published messages, see WithTTL.
+	TTL time.Duration
+	// MaxPayloadSize limits the size of published payloads, see
+	// WithMaxPayloadSize.
+	MaxPayloadSize int64
+	// MaxSubscriptions and MaxWildcardSubscriptions limit the number of
+	// subscriptions, see WithMaxSubscriptions.
+	MaxSubscriptions         int
+	MaxWildcardSubscriptions int
+
+	// DefaultMetadata is added to every published message, see
+	// WithDefaultMetadata.
+	DefaultMetadata map[string]interface{}
+	// SequenceNumbers numbers published messages, see WithSequenceNumbers.
+	SequenceNumbers bool
+	// StartPaused creates the bus without starting it, see
+	// WithStartPaused.
+	StartPaused bool
+
+	// Observers are notified of the bus events, see WithObserver.
+	Observers []Observer
+
+	// Options are applied after the fields above, for the settings Config
+	// does not cover.
+	Options []Option
+}
+
+// options returns the options equivalent to c, in the order NewWithConfig
+// applies them.
+func (c Config) options() []Option {
+	var opts []Option
+	if c.Workers > 0 {
+		opts = append(opts, WithWorkers(c.Workers))
+	}
+	if c.Partitions > 0 {
+		opts = append(opts, WithPartitions(c.Partitions))
+	}
+	if c.QueueSize > 0 {
+		opts = append(opts, WithQueueSize(c.QueueSize))
+	}
+	if c.OverflowPolicy != OverflowBlock {
+		opts = append(opts, WithOverflowPolicy(c.OverflowPolicy))
+	}
+	switch {
+	case c.MaxRetries > 0:
+		opts = append(opts, WithMaxRetries(c.MaxRetries))
+	case c.MaxRetries < 0:
+		opts = append(opts, WithMaxRetries(0))
+	}
+	if c.RetryBackoff != nil {
+		opts = append(opts, WithRetryBackoff(c.RetryBackoff))
+	}
+	if c.DeadLetterHandler != nil {
+		opts = append(opts, WithDeadLetterHandler(c.DeadLetterHandler))
+	}
+	if c.RecoverPanics || c.PanicHandler != nil {
+		opts = append(opts, WithPanicHandler(c.PanicHandler))
+	}
+	if c.TTL > 0 {
+		opts = append(opts, WithTTL(c.TTL))
+	}
+	if c.MaxPayloadSize > 0 {
+		opts = append(opts, WithMaxPayloadSize(c.MaxPayloadSize))
+	}
+	if c.MaxSubscriptions > 0 {
+		opts = append(opts, WithMaxSubscriptions(c.MaxSubscriptions))
+	}
+	if c.MaxWildcardSubscriptions > 0 {
+		opts = append(opts, WithMaxWildcardSubscriptions(c.MaxWildcardSubscriptions))
+	}
+	if len(c.DefaultMetadata) > 0 {
+		opts = append(opts, WithDefaultMetadata(c.DefaultMetadata))
+	}
+	if c.SequenceNumbers {
+		opts = append(opts, WithSequenceNumbers())
+	}
+	if c.StartPaused {
+		opts = append(opts, WithStartPaused())
+	}
+	for _, obs := range c.Observers {
+		opts = append(opts, WithObserver(obs))
+	}
+	return append(opts, c.Options...)
+}
+
+// NewWithConfig creates a bus configured by cfg. It is equivalent to New
+// with the options matching the fields of cfg.
+func NewWithConfig(cfg Config) Bus {
+	return New(cfg.options()...)
+}
@@ -0,0 +1,99 @@
+package scela
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestNewWithConfig(t *testing.T) {
+	obs := &failureObserver{}
+	b := NewWithConfig(Config{
+		Workers:          2,
+		QueueSize:        5,
+		OverflowPolicy:   OverflowErrorFast,
+		MaxRetries:       -1,
+		TTL:              time.Minute,
+		MaxSubscriptions: 1,
+		StartPaused:      true,
+		Observers:        []Observer{obs},
+		Options:          []Option{WithWorkers(3)},
+	})
+	defer b.Close()
+
+	impl := b.(*bus)
+	if impl.workers != 3 || impl.queueSize != 5 || impl.overflow != OverflowErrorFast {
+		t.Errorf("workers %d, queue size %d, overflow %v", impl.workers, impl.queueSize, impl.overflow)
+	}
+	if impl.maxRetries != 0 || impl.ttl != time.Minute || impl.started {
+		t.Errorf("max retries %d, ttl %v, started %v", impl.maxRetries, impl.ttl, impl.started)
+	}
+
+	_, _ = b.Subscribe("a", HandlerFunc(func(ctx context.Context, msg Message) error { return nil }))
+	if _, err := b.Subscribe("b", HandlerFunc(func(ctx context.Context, msg Message) error { return nil })); !errors.Is(err, ErrSubscriptionLimit) {
+		t.Errorf("expected ErrSubscriptionLimit, got %v", err)
+	}
+}
+
+func TestNewWithConfig_Defaults(t *testing.T) {
+	b := NewWithConfig(Config{}).(*bus)
+	defer b.Close()
+
+	if b.workers != 10 || b.maxRetries != 3 || b.queueSize != defaultQueueSize || !b.started {
+		t.Errorf("unexpected defaults: workers %d, max retries %d, queue size %d", b.workers, b.maxRetries, b.queueSize)
+	}
+}
+
+// notifier depends on the publishing side of the bus only.
+type notifier struct {
+	publisher Publisher
+}
+
+func (n notifier) notify(ctx context.Context, user string) error {
+	return n.publisher.Publish(ctx, "user.notified", user)
+}
+
+// recordingPublisher is a Publisher mock.
+type recordingPublisher struct {
+	topics []string
+}
+
+func (p *recordingPublisher) Publish(ctx context.Context, topic string, payload interface{}) error {
+	p.topics = append(p.topics, topic)
+	return nil
+}
+
+func (p *recordingPublisher) PublishSync(ctx context.Context, topic string, payload interface{}) error {
+	return p.Publish(ctx, topic, payload)
+}
+
+func (p *recordingPublisher) PublishWithPriority(ctx context.Context, topic string, payload interface{}, priority Priority) error {
+	return p.Publish(ctx, topic, payload)
+}
+
+func (p *recordingPublisher) PublishBatch(ctx context.Context, batch []TopicPayload) error {
+	for _, tp := range batch {
+		_ = p.Publish(ctx, tp.Topic, tp.Payload)
+	}
+	return nil
+}
+
+func TestPublisher_Narrow(t *testing.T) {
+	mock := &recordingPublisher{}
+	if err := (notifier{publisher: mock}).notify(context.Background(), "u-1"); err != nil {
+		t.Fatalf("notify() error = %v", err)
+	}
+	if err := PublishTyped(context.Background(), mock, "user.created", "u-1"); err != nil {
+		t.Fatalf("PublishTyped() error = %v", err)
+	}
+	if len(mock.topics) != 2 {
+		t.Errorf("expected 2 publishes, got %v", mock.topics)
+	}
+
+	// A bus is both
+	b := New()
+	defer b.Close()
+	var _ Publisher = b
+	var _ Subscriber = b
+}
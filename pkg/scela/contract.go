@@ -0,0 +1,105 @@
+package scela
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ErrContractViolation matches the ContractError returned for a message that
+// breaks the contract of its topic.
+var ErrContractViolation = errors.New("contract violation")
+
+// Contract is what the messages on the topics matching Pattern must satisfy
+// to leave the process through a bridge, so that a payload the external
+// system cannot take is caught at the boundary rather than by its
+// consumers.
+type Contract struct {
+	// Pattern selects the topics the contract applies to.
+	Pattern string
+	// Serializer must be able to encode the payload. It defaults to JSON,
+	// the encoding of the bridges of this module.
+	Serializer Serializer
+	// Validate, if set, checks the encoded payload, for example against
+	// the schema the external consumers expect.
+	Validate func(data []byte) error
+}
+
+// ContractError reports a message a bridge refused to export. It matches
+// ErrContractViolation and the error of the serializer or validation, and
+// is permanent (see Permanent), so the message is dead-lettered without
+// retries.
+type ContractError struct {
+	MessageID string
+	Topic     string
+	// Pattern is the pattern of the broken contract.
+	Pattern string
+	Err     error
+}
+
+// Error implements the error interface.
+func (e *ContractError) Error() string {
+	return fmt.Sprintf("message %s on %s breaks the contract of %s: %v", e.MessageID, e.Topic, e.Pattern, e.Err)
+}
+
+// Unwrap returns the cause, ErrContractViolation and the permanent marker.
+func (e *ContractError) Unwrap() []error {
+	return []error{e.Err, ErrContractViolation, errPermanent}
+}
+
+// EnforceContracts returns a Sink checking every message against the first
+// of contracts matching its topic before sending it to sink. A message that
+// breaks it is not sent, and Send returns a ContractError; with ForwardTo,
+// the error goes to the bus, which dead-letters the message. Messages on
+// topics no contract covers are sent unchecked.
+func EnforceContracts(sink Sink, contracts ...Contract) Sink {
+	return &contractSink{
+		sink:      sink,
+		contracts: contracts,
+		matcher:   newPatternMatcher(),
+	}
+}
+
+// contractSink is the Sink returned by EnforceContracts.
+type contractSink struct {
+	sink      Sink
+	contracts []Contract
+	matcher   *patternMatcher
+}
+
+// Send implements Sink.
+func (s *contractSink) Send(ctx context.Context, msg Message) error {
+	for _, c := range s.contracts {
+		if !s.matcher.Match(c.Pattern, msg.Topic()) {
+			continue
+		}
+		if err := c.check(msg); err != nil {
+			return &ContractError{MessageID: msg.ID(), Topic: msg.Topic(), Pattern: c.Pattern, Err: err}
+		}
+		break
+	}
+	return s.sink.Send(ctx, msg)
+}
+
+// Close implements Sink.
+func (s *contractSink) Close() error {
+	return s.sink.Close()
+}
+
+// check returns why msg breaks c, if it does.
+func (c Contract) check(msg Message) error {
+	serializer := c.Serializer
+	if serializer == nil {
+		serializer = NewJSONSerializer()
+	}
+	data, err := serializer.Serialize(msg.Payload())
+	if err != nil {
+		return fmt.Errorf("cannot encode payload: %w", err)
+	}
+	if c.Validate != nil {
+		if err := c.Validate(data); err != nil {
+			return fmt.Errorf("invalid payload: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package scela
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestEnforceContracts(t *testing.T) {
+	sink := &stubSink{}
+	errNoID := errors.New("missing id")
+	enforced := EnforceContracts(sink,
+		Contract{Pattern: "order.*", Validate: func(data []byte) error {
+			if string(data) == "{}" {
+				return errNoID
+			}
+			return nil
+		}},
+		Contract{Pattern: "#"},
+	)
+	ctx := context.Background()
+
+	if err := enforced.Send(ctx, NewMessage("order.created", map[string]string{"id": "o-1"})); err != nil {
+		t.Fatalf("Send of a valid message: %v", err)
+	}
+
+	err := enforced.Send(ctx, NewMessage("order.created", map[string]string{}))
+	var cerr *ContractError
+	if !errors.As(err, &cerr) || cerr.Pattern != "order.*" || !errors.Is(err, errNoID) {
+		t.Fatalf("expected a ContractError wrapping the validation error, got %v", err)
+	}
+	if !errors.Is(err, ErrContractViolation) || !IsPermanent(err) {
+		t.Errorf("expected a permanent contract violation, got %v", err)
+	}
+
+	// The catch-all contract requires JSON
+	if err := enforced.Send(ctx, NewMessage("user.created", make(chan int))); !errors.Is(err, ErrContractViolation) {
+		t.Errorf("expected an unencodable payload to be rejected, got %v", err)
+	}
+
+	if len(sink.sent) != 1 {
+		t.Errorf("expected only the valid message to be sent, got %d", len(sink.sent))
+	}
+}
+
+func TestEnforceContracts_DeadLettersAtTheBoundary(t *testing.T) {
+	dead := make(chan Message, 2)
+	bus := New(WithMaxRetries(3), WithDeadLetterHandler(HandlerFunc(func(ctx context.Context, msg Message) error {
+		dead <- msg
+		return nil
+	})))
+	defer bus.Close()
+
+	sink := &stubSink{}
+	if _, err := ForwardTo(bus, "order.*", EnforceContracts(sink, Contract{Pattern: "order.*"})); err != nil {
+		t.Fatalf("ForwardTo: %v", err)
+	}
+
+	err := bus.PublishSync(context.Background(), "order.created", func() {})
+	if !errors.Is(err, ErrContractViolation) {
+		t.Errorf("expected the contract violation from PublishSync, got %v", err)
+	}
+
+	_ = bus.Publish(context.Background(), "order.created", func() {})
+	select {
+	case msg := <-dead:
+		if msg.Metadata()[MetadataDeadLetterAttempts] != 1 {
+			t.Errorf("expected no retries, got %v attempts", msg.Metadata()[MetadataDeadLetterAttempts])
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the message to be dead-lettered")
+	}
+}
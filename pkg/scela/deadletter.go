@@ -0,0 +1,175 @@
+package scela
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Metadata keys added to dead-lettered messages.
+const (
+	// MetadataDeadLetterReason holds the error of the last failed attempt.
+	MetadataDeadLetterReason = "dead_letter_reason"
+	// MetadataDeadLetterAttempts holds the number of failed attempts.
+	MetadataDeadLetterAttempts = "dead_letter_attempts"
+	// MetadataDeadLetterSubscription holds the name, or the pattern if
+	// unnamed, of the subscription whose handler failed.
+	MetadataDeadLetterSubscription = "dead_letter_subscription"
+	// MetadataOriginalTopic holds the topic the message was published on.
+	MetadataOriginalTopic = "original_topic"
+)
+
+// ErrDeadLetterNotFound is returned by DeadLetterQueue.Requeue for an ID
+// the queue does not hold.
+var ErrDeadLetterNotFound = errors.New("dead letter not found")
+
+// deadLetterMessage returns a copy of the envelope message annotated with
+// the reason it was dead-lettered. The ID, topic, payload, timestamp and
+// priority are preserved.
+func deadLetterMessage(env *Envelope) Message {
+	msg := env.msg
+
+	metadata := make(map[string]interface{}, len(msg.Metadata())+4)
+	for k, v := range msg.Metadata() {
+		metadata[k] = v
+	}
+	// Dead letters are kept until handled, however short the message lived
+	delete(metadata, MetadataExpiresAt)
+	metadata[MetadataOriginalTopic] = msg.Topic()
+	metadata[MetadataDeadLetterAttempts] = env.retries
+	if env.err != nil {
+		metadata[MetadataDeadLetterReason] = env.err.Error()
+	}
+	if env.sub != nil {
+		name := env.sub.name
+		if name == "" {
+			name = env.sub.pattern
+		}
+		metadata[MetadataDeadLetterSubscription] = name
+	}
+	metadata[MetadataHops] = appendHop(metadata[MetadataHops], HopDeadLetter, time.Now())
+
+	return &message{
+		id:        msg.ID(),
+		topic:     msg.Topic(),
+		payload:   msg.Payload(),
+		metadata:  metadata,
+		timestamp: msg.Timestamp(),
+		priority:  MessagePriority(msg),
+	}
+}
+
+// StoreDeadLetters returns a handler that writes messages to store, for use
+// as a bus or subscription dead letter handler.
+func StoreDeadLetters(store MessageStore) Handler {
+	return HandlerFunc(func(ctx context.Context, msg Message) error {
+		if err := store.Store(ctx, msg); err != nil {
+			return fmt.Errorf("failed to store dead letter %s: %w", msg.ID(), err)
+		}
+		return nil
+	})
+}
+
+// WithSubscriptionDeadLetterTopic publishes messages whose retries are
+// exhausted on the subscription to topic (for example "dlq.email-sender")
+// on the same bus, instead of the bus dead letter handler. The published
+// message keeps the original payload and metadata, adds the dead letter
+// metadata and records the failed message as its cause.
+func WithSubscriptionDeadLetterTopic(topic string) SubscriptionOption {
+	return func(c *subscriptionConfig) {
+		c.dlqTopic = topic
+		c.dlqHandler = nil
+	}
+}
+
+// WithSubscriptionDeadLetterStore writes messages whose retries are
+// exhausted on the subscription to store, instead of the bus dead letter
+// handler.
+func WithSubscriptionDeadLetterStore(store MessageStore) SubscriptionOption {
+	return WithSubscriptionDeadLetter(StoreDeadLetters(store))
+}
+
+// DeadLetterQueue durably stores dead-lettered messages in a MessageStore so
+// they can be inspected and requeued. It is a Handler, for use with
+// WithDeadLetterHandler or WithSubscriptionDeadLetter.
+type DeadLetterQueue struct {
+	store MessageStore
+	bus   Bus
+}
+
+// NewDeadLetterQueue creates a dead letter queue writing to store. Requeued
+// messages are published on bus.
+func NewDeadLetterQueue(store MessageStore, bus Bus) *DeadLetterQueue {
+	return &DeadLetterQueue{
+		store: store,
+		bus:   bus,
+	}
+}
+
+// Handle implements Handler by storing the dead-lettered message.
+func (q *DeadLetterQueue) Handle(ctx context.Context, msg Message) error {
+	if err := q.store.Store(ctx, msg); err != nil {
+		return fmt.Errorf("failed to store dead letter %s: %w", msg.ID(), err)
+	}
+	return nil
+}
+
+// List returns the dead-lettered messages, oldest first.
+func (q *DeadLetterQueue) List(ctx context.Context) ([]Message, error) {
+	messages, err := q.store.Load(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load dead letters: %w", err)
+	}
+	return messages, nil
+}
+
+// Requeue publishes the dead-lettered message with the given ID to its
+// original topic and removes it from the queue. The store must implement
+// RewritableStore. The message is published before it is removed, so a
+// failed removal may lead to a duplicate but never to a lost message.
+func (q *DeadLetterQueue) Requeue(ctx context.Context, id string) error {
+	rs, ok := q.store.(RewritableStore)
+	if !ok {
+		return fmt.Errorf("dead letter store does not support removing messages")
+	}
+
+	messages, err := q.List(ctx)
+	if err != nil {
+		return err
+	}
+
+	var dead Message
+	for _, msg := range messages {
+		if msg.ID() == id {
+			dead = msg
+			break
+		}
+	}
+	if dead == nil {
+		return fmt.Errorf("%w: %s", ErrDeadLetterNotFound, id)
+	}
+
+	topic := dead.Topic()
+	if original, ok := dead.Metadata()[MetadataOriginalTopic].(string); ok && original != "" {
+		topic = original
+	}
+
+	if err := q.bus.Publish(ContextWithMessage(ctx, dead), topic, dead.Payload()); err != nil {
+		return fmt.Errorf("failed to requeue dead letter %s: %w", id, err)
+	}
+
+	err = rs.Rewrite(ctx, func(messages []Message) ([]Message, error) {
+		kept := make([]Message, 0, len(messages))
+		for _, msg := range messages {
+			if msg.ID() != id {
+				kept = append(kept, msg)
+			}
+		}
+		return kept, nil
+	})
+	if err != nil {
+		return fmt.Errorf("dead letter %s requeued but not removed: %w", id, err)
+	}
+	return nil
+}
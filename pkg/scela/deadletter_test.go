@@ -0,0 +1,175 @@
+package scela
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSubscription_DeadLetterTopic(t *testing.T) {
+	bus := New(WithMaxRetries(2))
+	defer bus.Close()
+
+	original := make(chan Message, 1)
+	_, _ = bus.SubscribeWithOptions("email.send", HandlerFunc(func(ctx context.Context, msg Message) error {
+		select {
+		case original <- msg:
+		default:
+		}
+		return errors.New("smtp down")
+	}), WithSubscriptionName("email-sender"), WithSubscriptionDeadLetterTopic("dlq.email-sender"))
+
+	dead := make(chan Message, 1)
+	_, _ = bus.Subscribe("dlq.email-sender", HandlerFunc(func(ctx context.Context, msg Message) error {
+		dead <- msg
+		return nil
+	}))
+
+	_ = bus.Publish(context.Background(), "email.send", "hello")
+
+	var msg Message
+	select {
+	case msg = <-dead:
+	case <-time.After(time.Second):
+		t.Fatal("Dead letter was not published")
+	}
+	sent := <-original
+
+	md := msg.Metadata()
+	if msg.Payload() != "hello" || md[MetadataOriginalTopic] != "email.send" {
+		t.Errorf("Unexpected dead letter %v with metadata %v", msg.Payload(), md)
+	}
+	if md[MetadataDeadLetterReason] != "smtp down" || md[MetadataDeadLetterAttempts] != 2 {
+		t.Errorf("Expected reason and attempts in metadata, got %v", md)
+	}
+	if md[MetadataDeadLetterSubscription] != "email-sender" {
+		t.Errorf("Expected subscription name in metadata, got %v", md[MetadataDeadLetterSubscription])
+	}
+	if CausationID(msg) != sent.ID() {
+		t.Error("Expected dead letter to record the failed message as its cause")
+	}
+}
+
+func TestSubscription_DeadLetterStore(t *testing.T) {
+	store := NewInMemoryStore(10)
+	bus := New(WithMaxRetries(1))
+	defer bus.Close()
+
+	_, _ = bus.SubscribeWithOptions("report.build", HandlerFunc(func(ctx context.Context, msg Message) error {
+		return errors.New("out of memory")
+	}), WithSubscriptionDeadLetterStore(store))
+
+	_ = bus.Publish(context.Background(), "report.build", 42)
+
+	deadline := time.Now().Add(time.Second)
+	stored, _ := store.Load(context.Background())
+	for len(stored) == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+		stored, _ = store.Load(context.Background())
+	}
+
+	if len(stored) != 1 {
+		t.Fatalf("Expected 1 stored dead letter, got %d", len(stored))
+	}
+	if stored[0].Topic() != "report.build" || stored[0].Metadata()[MetadataDeadLetterSubscription] != "report.build" {
+		t.Errorf("Unexpected stored dead letter %s %v", stored[0].Topic(), stored[0].Metadata())
+	}
+}
+
+func TestSubscription_DeadLetterTopicLoop(t *testing.T) {
+	busDLQ := make(chan Message, 1)
+	bus := New(WithMaxRetries(1), WithDeadLetterHandler(HandlerFunc(func(ctx context.Context, msg Message) error {
+		busDLQ <- msg
+		return nil
+	})))
+	defer bus.Close()
+
+	// A failing subscriber on its own dead letter topic must not loop
+	_, _ = bus.SubscribeWithOptions("dlq.*", HandlerFunc(func(ctx context.Context, msg Message) error {
+		return errors.New("fail")
+	}), WithSubscriptionDeadLetterTopic("dlq.loop"))
+
+	_ = bus.Publish(context.Background(), "dlq.loop", nil)
+
+	select {
+	case <-busDLQ:
+	case <-time.After(time.Second):
+		t.Fatal("Expected fallback to the bus dead letter handler")
+	}
+}
+
+func TestDeadLetterQueue(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	store, err := NewSQLStore(SQLStoreConfig{DB: db, TableName: "dead_letters"})
+	if err != nil {
+		t.Fatalf("Failed to create SQL store: %v", err)
+	}
+
+	bus := New(WithMaxRetries(1))
+	defer bus.Close()
+	dlq := NewDeadLetterQueue(store, bus)
+
+	var healthy atomic.Bool
+	delivered := make(chan Message, 1)
+	_, _ = bus.SubscribeWithOptions("payment.capture", HandlerFunc(func(ctx context.Context, msg Message) error {
+		if !healthy.Load() {
+			return errors.New("gateway timeout")
+		}
+		delivered <- msg
+		return nil
+	}), WithSubscriptionDeadLetter(dlq))
+
+	_ = bus.Publish(context.Background(), "payment.capture", "order-1")
+
+	ctx := context.Background()
+	var dead []Message
+	deadline := time.Now().Add(time.Second)
+	for len(dead) == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+		dead, _ = dlq.List(ctx)
+	}
+	if len(dead) != 1 {
+		t.Fatalf("Expected 1 dead letter, got %d", len(dead))
+	}
+	if dead[0].Metadata()[MetadataDeadLetterReason] != "gateway timeout" {
+		t.Errorf("Expected failure reason to be stored, got %v", dead[0].Metadata())
+	}
+
+	healthy.Store(true)
+	if err := dlq.Requeue(ctx, dead[0].ID()); err != nil {
+		t.Fatalf("Requeue() error = %v", err)
+	}
+
+	select {
+	case msg := <-delivered:
+		if msg.Payload() != "order-1" || CausationID(msg) != dead[0].ID() {
+			t.Errorf("Unexpected requeued message %v caused by %s", msg.Payload(), CausationID(msg))
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Requeued message was not delivered")
+	}
+
+	if remaining, _ := dlq.List(ctx); len(remaining) != 0 {
+		t.Errorf("Expected dead letter to be removed, got %d", len(remaining))
+	}
+	if err := dlq.Requeue(ctx, dead[0].ID()); err == nil {
+		t.Error("Expected error requeueing a missing dead letter")
+	}
+}
+
+func TestDeadLetterQueue_RequeueUnsupported(t *testing.T) {
+	bus := New()
+	defer bus.Close()
+
+	inner := NewInMemoryStore(10)
+	dlq := NewDeadLetterQueue(&failingStore{inner: inner, failAfter: 10}, bus)
+	_ = dlq.Handle(context.Background(), NewMessage("test", nil))
+
+	if err := dlq.Requeue(context.Background(), "any"); err == nil {
+		t.Error("Expected error for store without rewrite support")
+	}
+}
@@ -0,0 +1,65 @@
+package scela
+
+// DefaultBridgeDedupeWindow is the number of message IDs WithBridgeDedupe
+// remembers when no window is given.
+const DefaultBridgeDedupeWindow = 10000
+
+// WithBridgeDedupe makes the bus drop the messages received from outside
+// the process, by ConsumeFrom, NewBridge or PublishMessage, that it has
+// already published: messages coming back through a loop of bridges
+// between federated buses, and messages an external broker delivers again.
+// They are recognized by their ID among the latest window IDs published
+// (DefaultBridgeDedupeWindow if zero or less), so the bridges must keep
+// message IDs, as those of this module do. A dropped message is
+// acknowledged to its source as if it was published.
+func WithBridgeDedupe(window int) Option {
+	return func(b *bus) {
+		if window <= 0 {
+			window = DefaultBridgeDedupeWindow
+		}
+		b.published = newRecentIDs(window)
+	}
+}
+
+// bridgeDeduper is implemented by buses that drop the messages they already
+// published.
+type bridgeDeduper interface {
+	// claimBridged reports whether the message with id was not published
+	// yet, and records it as published if so.
+	claimBridged(id string) bool
+	// releaseBridged forgets id, after publishing its message failed.
+	releaseBridged(id string)
+}
+
+// claimBridged implements bridgeDeduper.
+func (b *bus) claimBridged(id string) bool {
+	if b.published == nil {
+		return true
+	}
+	return b.published.addNew(id)
+}
+
+// releaseBridged implements bridgeDeduper.
+func (b *bus) releaseBridged(id string) {
+	if b.published != nil {
+		b.published.remove(id)
+	}
+}
+
+// recordPublished remembers the ID of msg for WithBridgeDedupe.
+func (b *bus) recordPublished(msg Message) {
+	if b.published != nil {
+		b.published.add(msg.ID())
+	}
+}
+
+// bridgeDeduperOf returns the bus of b, looking through the wrappers of
+// this package, that deduplicates bridged messages.
+func bridgeDeduperOf(b Bus) (bridgeDeduper, bool) {
+	for inner := b; inner != nil; inner = innerBus(inner) {
+		if d, ok := inner.(bridgeDeduper); ok {
+			return d, true
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,104 @@
+package scela
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWithBridgeDedupe_DropsRedeliveries(t *testing.T) {
+	b := New(WithBridgeDedupe(0))
+	defer b.Close()
+
+	var count atomic.Int32
+	_, _ = b.Subscribe("order.*", HandlerFunc(func(ctx context.Context, msg Message) error {
+		count.Add(1)
+		return nil
+	}))
+
+	msg := NewMessage("order.created", 1)
+	for i := 0; i < 3; i++ {
+		if err := PublishMessage(context.Background(), b, msg); err != nil {
+			t.Fatalf("PublishMessage: %v", err)
+		}
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	if count.Load() != 1 {
+		t.Errorf("expected the message once, got %d deliveries", count.Load())
+	}
+}
+
+func TestWithBridgeDedupe_DropsLoops(t *testing.T) {
+	b := New(WithBridgeDedupe(0))
+	defer b.Close()
+
+	published := make(chan Message, 1)
+	var count atomic.Int32
+	_, _ = b.Subscribe("order.*", HandlerFunc(func(ctx context.Context, msg Message) error {
+		count.Add(1)
+		return nil
+	}))
+	sub, _ := b.Subscribe("order.*", HandlerFunc(func(ctx context.Context, msg Message) error {
+		published <- msg
+		return nil
+	}))
+	_ = b.Publish(context.Background(), "order.created", 1)
+	msg := <-published
+	_ = sub.Unsubscribe()
+
+	// The message comes back from a federated bus
+	source := &memSource{msgs: make(chan Message, 1), done: make(chan struct{})}
+	source.msgs <- AddHop(msg, HopBridgeIn)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- ConsumeFrom(ctx, source, b) }()
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	<-done
+
+	if count.Load() != 1 {
+		t.Errorf("expected the looping message to be dropped, got %d deliveries", count.Load())
+	}
+}
+
+func TestWithBridgeDedupe_RetriesFailedPublish(t *testing.T) {
+	store := &failingStore{inner: NewInMemoryStore(10), failAfter: 0}
+	b := NewPersistentBus(New(WithBridgeDedupe(0)), store)
+	defer b.Close()
+
+	msg := NewMessage("order.created", 1)
+	if err := PublishMessage(context.Background(), b, msg); err == nil {
+		t.Fatal("expected the publish to fail")
+	}
+
+	store.failAfter = store.calls + 1
+	if err := PublishMessage(context.Background(), b, msg); err != nil {
+		t.Fatalf("expected the redelivery to be published, got %v", err)
+	}
+	stored, _ := store.inner.Load(context.Background())
+	if len(stored) != 1 || stored[0].ID() != msg.ID() {
+		t.Errorf("expected message %s stored with its ID, got %v", msg.ID(), stored)
+	}
+}
+
+func TestPublishMessage_WithoutDedupe(t *testing.T) {
+	b := New()
+	defer b.Close()
+
+	var count atomic.Int32
+	_, _ = b.Subscribe("order.*", HandlerFunc(func(ctx context.Context, msg Message) error {
+		count.Add(1)
+		return nil
+	}))
+
+	msg := NewMessage("order.created", 1)
+	_ = PublishMessage(context.Background(), b, msg)
+	_ = PublishMessage(context.Background(), b, msg)
+	time.Sleep(50 * time.Millisecond)
+
+	if count.Load() != 2 {
+		t.Errorf("expected both deliveries without dedupe, got %d", count.Load())
+	}
+}
@@ -0,0 +1,199 @@
+package scela
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// MetadataDeliverAt holds the time a message published with PublishAt or
+// PublishAfter is delivered at, formatted as RFC 3339 with nanoseconds.
+const MetadataDeliverAt = "deliver_at"
+
+// ErrNotDue is reported to observers for scheduled messages not due yet
+// when the bus closes.
+var ErrNotDue = errors.New("scheduled message not due before the bus closed")
+
+// deliverAtContextKey is the context key under which the delivery time for
+// the next published message is stored.
+type deliverAtContextKey struct{}
+
+// dueHookContextKey is the context key under which a wrapper stores a
+// function called once a scheduled message it published is queued.
+type dueHookContextKey struct{}
+
+// PublishAfter publishes payload on topic asynchronously, delivering it
+// once delay has passed.
+func PublishAfter(ctx context.Context, b Bus, topic string, payload interface{}, delay time.Duration) error {
+	return PublishAt(ctx, b, topic, payload, time.Now().Add(delay))
+}
+
+// PublishAt publishes payload on topic asynchronously, delivering it at the
+// given time, or right away if it has passed. Until then the message waits
+// outside the queue, so it does not count against the queue size. Delivery
+// happens no earlier than at, within about 10ms.
+//
+// The time is carried by the context, so wrappers such as PersistentBus
+// publish through to the bus. A PersistentBus created with WithScheduleStore
+// also keeps the message until it is queued, see RestoreScheduled.
+func PublishAt(ctx context.Context, b Bus, topic string, payload interface{}, at time.Time) error {
+	return b.Publish(ContextWithDeliveryTime(ctx, at), topic, payload)
+}
+
+// ContextWithDeliveryTime returns a context under which messages are
+// published for delivery at the given time, as by PublishAt. PublishSync
+// ignores it.
+func ContextWithDeliveryTime(ctx context.Context, at time.Time) context.Context {
+	return context.WithValue(ctx, deliverAtContextKey{}, at)
+}
+
+// DeliveryTime returns the time msg is scheduled for. It reports false if
+// msg was not published with a delivery time.
+func DeliveryTime(msg Message) (time.Time, bool) {
+	s, ok := msg.Metadata()[MetadataDeliverAt].(string)
+	if !ok {
+		return time.Time{}, false
+	}
+	at, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return at, true
+}
+
+// stampDeliveryTime records the delivery time carried by ctx on msg.
+func stampDeliveryTime(ctx context.Context, msg Message) {
+	if at, ok := ctx.Value(deliverAtContextKey{}).(time.Time); ok && !at.IsZero() {
+		msg.Metadata()[MetadataDeliverAt] = at.UTC().Format(time.RFC3339Nano)
+	}
+}
+
+// contextWithDueHook returns a context under which fn is called with each
+// published message once it is queued, after any hook already set.
+func contextWithDueHook(ctx context.Context, fn func(Message)) context.Context {
+	if prev := dueHook(ctx); prev != nil {
+		next := fn
+		fn = func(msg Message) {
+			prev(msg)
+			next(msg)
+		}
+	}
+	return context.WithValue(ctx, dueHookContextKey{}, fn)
+}
+
+// dueHook returns the function stored by contextWithDueHook, or nil.
+func dueHook(ctx context.Context) func(Message) {
+	fn, _ := ctx.Value(dueHookContextKey{}).(func(Message))
+	return fn
+}
+
+// schedule holds env in the timer wheel if it is due later. It reports
+// false if env can be queued right away.
+func (b *bus) schedule(ctx context.Context, env *Envelope) (bool, error) {
+	at, ok := DeliveryTime(env.msg)
+	if !ok || !at.After(time.Now()) {
+		return false, nil
+	}
+	if !b.wheel.add(&wheelEntry{env: env, at: at, due: dueHook(ctx)}) {
+		return true, ErrBusClosed
+	}
+	return true, nil
+}
+
+// fireScheduled queues a scheduled message once it is due, according to
+// the overflow policy.
+func (b *bus) fireScheduled(ctx context.Context, e *wheelEntry) {
+	err := b.queueEnvelope(ctx, e.env, e.due)
+	if err == nil {
+		return
+	}
+	// Stopped while waiting for room
+	if ctx.Err() != nil {
+		err = ErrNotDue
+	}
+	b.observers.NotifyDrop(context.Background(), e.env.msg, err)
+}
+
+// closeScheduled stops the timer wheel, reporting the messages not due yet.
+func (b *bus) closeScheduled() {
+	for _, e := range b.wheel.close() {
+		b.observers.NotifyDrop(context.Background(), e.env.msg, ErrNotDue)
+	}
+}
+
+// WithScheduleStore keeps the messages published with PublishAt or
+// PublishAfter in store until they are queued, so that they survive a
+// restart; call RestoreScheduled on startup to schedule them again.
+// Messages not due when the bus closes stay in the store.
+func WithScheduleStore(store RewritableStore) PersistentBusOption {
+	return func(pb *PersistentBus) {
+		pb.scheduleStore = store
+	}
+}
+
+// RestoreScheduled schedules again the messages kept by the store given to
+// WithScheduleStore, delivering the ones that came due meanwhile right
+// away. It returns the number of messages restored.
+func (pb *PersistentBus) RestoreScheduled(ctx context.Context) (int, error) {
+	if pb.scheduleStore == nil {
+		return 0, fmt.Errorf("persistent bus has no schedule store")
+	}
+	msgs, err := pb.scheduleStore.Load(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to load scheduled messages: %w", pb.reportStoreError(ctx, "load", nil, err))
+	}
+	if len(msgs) == 0 {
+		return 0, nil
+	}
+
+	mp, ok := pb.Bus.(messagePublisher)
+	if !ok {
+		return 0, fmt.Errorf("bus %T cannot restore scheduled messages", pb.Bus)
+	}
+	if err := mp.publishMessages(pb.deliveredContext(pb.scheduledContext(ctx)), msgs, false); err != nil {
+		return 0, err
+	}
+	return len(msgs), nil
+}
+
+// persistScheduled keeps the scheduled messages among msgs in the schedule
+// store. It returns the context to publish them with.
+func (pb *PersistentBus) persistScheduled(ctx context.Context, msgs []Message) (context.Context, error) {
+	if pb.scheduleStore == nil {
+		return ctx, nil
+	}
+	scheduled := false
+	for _, msg := range msgs {
+		if _, ok := DeliveryTime(msg); !ok {
+			continue
+		}
+		if err := pb.scheduleStore.Store(ctx, msg); err != nil {
+			return ctx, fmt.Errorf("failed to persist scheduled message: %w", pb.reportStoreError(ctx, "schedule", msg, err))
+		}
+		scheduled = true
+	}
+	if !scheduled {
+		return ctx, nil
+	}
+	return pb.scheduledContext(ctx), nil
+}
+
+// scheduledContext returns ctx under which scheduled messages are removed
+// from the schedule store once queued.
+func (pb *PersistentBus) scheduledContext(ctx context.Context) context.Context {
+	return contextWithDueHook(ctx, func(msg Message) {
+		err := pb.scheduleStore.Rewrite(context.Background(), func(msgs []Message) ([]Message, error) {
+			kept := msgs[:0]
+			for _, m := range msgs {
+				if m.ID() != msg.ID() {
+					kept = append(kept, m)
+				}
+			}
+			return kept, nil
+		})
+		if err != nil {
+			pb.reportStoreError(context.Background(), "unschedule", msg, err)
+		}
+	})
+}
@@ -0,0 +1,124 @@
+package scela
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestPublishAfter(t *testing.T) {
+	b := New()
+	defer b.Close()
+
+	delivered := make(chan time.Time, 1)
+	_, _ = b.Subscribe("reminders", HandlerFunc(func(ctx context.Context, msg Message) error {
+		delivered <- time.Now()
+		return nil
+	}))
+
+	start := time.Now()
+	if err := PublishAfter(context.Background(), b, "reminders", "ping", 50*time.Millisecond); err != nil {
+		t.Fatalf("PublishAfter() error = %v", err)
+	}
+	if stats, _ := StatsOf(b); stats.Scheduled != 1 {
+		t.Errorf("expected 1 scheduled message, got %d", stats.Scheduled)
+	}
+
+	select {
+	case at := <-delivered:
+		if at.Sub(start) < 50*time.Millisecond {
+			t.Errorf("delivered after %v, before the delay", at.Sub(start))
+		}
+	case <-time.After(time.Second):
+		t.Fatal("scheduled message not delivered")
+	}
+}
+
+func TestPublishAt_PastIsImmediate(t *testing.T) {
+	b := New()
+	defer b.Close()
+
+	delivered := make(chan Message, 1)
+	_, _ = b.Subscribe("reminders", HandlerFunc(func(ctx context.Context, msg Message) error {
+		delivered <- msg
+		return nil
+	}))
+
+	at := time.Now().Add(-time.Minute)
+	_ = PublishAt(context.Background(), b, "reminders", "ping", at)
+
+	select {
+	case msg := <-delivered:
+		got, ok := DeliveryTime(msg)
+		if !ok || !got.Equal(at) {
+			t.Errorf("DeliveryTime() = %v, %v, want %v", got, ok, at)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("message not delivered")
+	}
+}
+
+func TestPublishAt_CloseReportsNotDue(t *testing.T) {
+	obs := &failureObserver{}
+	b := New(WithObserver(obs))
+	_, _ = b.Subscribe("reminders", HandlerFunc(func(ctx context.Context, msg Message) error {
+		t.Error("message delivered before its time")
+		return nil
+	}))
+
+	_ = PublishAfter(context.Background(), b, "reminders", "later", time.Hour)
+	_ = b.Close()
+
+	if err := obs.errorFor("later"); !errors.Is(err, ErrNotDue) {
+		t.Errorf("expected ErrNotDue, got %v", err)
+	}
+}
+
+func TestPublishAt_ScheduleStoreSurvivesRestart(t *testing.T) {
+	schedules := NewInMemoryStore(0)
+
+	// First run: the message is not due before the bus closes
+	first := NewPersistentBus(New(), NewInMemoryStore(0), WithScheduleStore(schedules))
+	_ = PublishAfter(context.Background(), first, "reminders", "restart", 30*time.Millisecond)
+	_ = first.Close()
+
+	if n, _ := schedules.Count(context.Background()); n != 1 {
+		t.Fatalf("expected the scheduled message kept, got %d", n)
+	}
+
+	// Second run
+	second := NewPersistentBus(New(), NewInMemoryStore(0), WithScheduleStore(schedules))
+	defer second.Close()
+
+	delivered := make(chan Message, 1)
+	_, _ = second.Subscribe("reminders", HandlerFunc(func(ctx context.Context, msg Message) error {
+		delivered <- msg
+		return nil
+	}))
+	if n, err := second.RestoreScheduled(context.Background()); err != nil || n != 1 {
+		t.Fatalf("RestoreScheduled() = %d, %v", n, err)
+	}
+
+	select {
+	case msg := <-delivered:
+		if msg.Payload() != "restart" {
+			t.Errorf("unexpected payload %v", msg.Payload())
+		}
+	case <-time.After(time.Second):
+		t.Fatal("restored message not delivered")
+	}
+	waitFor(t, func() bool {
+		n, _ := schedules.Count(context.Background())
+		return n == 0
+	})
+}
+
+func TestRestoreScheduled_NoStore(t *testing.T) {
+	pb := NewPersistentBus(New(), NewInMemoryStore(0))
+	defer pb.Close()
+
+	if _, err := pb.RestoreScheduled(context.Background()); err == nil {
+		t.Error("expected an error without a schedule store")
+	}
+}
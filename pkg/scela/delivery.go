@@ -0,0 +1,92 @@
+package scela
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// DeliveryStore is implemented by stores that track which messages were
+// delivered. A PersistentBus over such a store marks each message it
+// published once delivered, and Replay only republishes the pending ones,
+// so that a restart does not process messages twice.
+//
+// A message is delivered once every subscription it was sent to handled it
+// successfully, acknowledging it if required (see WithManualAck), or once it
+// was dead-lettered. Messages dropped, expired, abandoned when the bus closed
+// or published while nothing subscribed to their topic stay pending.
+type DeliveryStore interface {
+	MessageStore
+
+	// MarkDelivered records that the messages with the given IDs were
+	// delivered. Unknown IDs are ignored.
+	MarkDelivered(ctx context.Context, ids ...string) error
+
+	// LoadPending loads the messages not marked delivered, in the order of
+	// Load.
+	LoadPending(ctx context.Context) ([]Message, error)
+}
+
+// deliveredHookContextKey is the context key under which a wrapper stores a
+// function called once a message it published was delivered.
+type deliveredHookContextKey struct{}
+
+// contextWithDeliveredHook returns a context under which fn is called with
+// each message published asynchronously once it was delivered.
+func contextWithDeliveredHook(ctx context.Context, fn func(Message)) context.Context {
+	return context.WithValue(ctx, deliveredHookContextKey{}, fn)
+}
+
+// deliveredHook returns the function stored by contextWithDeliveredHook, or
+// nil.
+func deliveredHook(ctx context.Context) func(Message) {
+	fn, _ := ctx.Value(deliveredHookContextKey{}).(func(Message))
+	return fn
+}
+
+// deliveryState counts the deliveries of a message still in progress: its
+// first delivery, the retries of the subscriptions that failed and the
+// deliveries waiting for an acknowledgement. A nil state tracks nothing.
+type deliveryState struct {
+	remaining atomic.Int32
+	done      func(Message)
+}
+
+// newDeliveryState returns a state calling done once the message was
+// delivered, or nil if done is nil.
+func newDeliveryState(done func(Message)) *deliveryState {
+	if done == nil {
+		return nil
+	}
+	d := &deliveryState{done: done}
+	d.remaining.Store(1)
+	return d
+}
+
+// fork records n more deliveries in progress.
+func (d *deliveryState) fork(n int) {
+	if d != nil && n > 0 {
+		d.remaining.Add(int32(n))
+	}
+}
+
+// finish records that a delivery of msg ended, calling done once none is
+// left.
+func (d *deliveryState) finish(msg Message) {
+	if d != nil && d.remaining.Add(-1) == 0 {
+		d.done(msg)
+	}
+}
+
+// deliveredContext returns ctx under which the messages published are
+// marked delivered in the store once delivered, if it is a DeliveryStore.
+func (pb *PersistentBus) deliveredContext(ctx context.Context) context.Context {
+	ds, ok := pb.store.(DeliveryStore)
+	if !ok {
+		return ctx
+	}
+	return contextWithDeliveredHook(ctx, func(msg Message) {
+		if err := ds.MarkDelivered(context.Background(), msg.ID()); err != nil {
+			pb.reportStoreError(context.Background(), "mark_delivered", msg, err)
+		}
+	})
+}
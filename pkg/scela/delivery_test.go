@@ -0,0 +1,253 @@
+package scela
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// pendingPayloads returns the payloads of the messages pending in store.
+func pendingPayloads(t *testing.T, store DeliveryStore) []interface{} {
+	t.Helper()
+	msgs, err := store.LoadPending(context.Background())
+	if err != nil {
+		t.Fatalf("LoadPending() error = %v", err)
+	}
+	payloads := make([]interface{}, len(msgs))
+	for i, msg := range msgs {
+		payloads[i] = msg.Payload()
+	}
+	return payloads
+}
+
+func TestDeliveryStore_Parity(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	sqlStore, err := NewSQLStore(SQLStoreConfig{DB: db})
+	if err != nil {
+		t.Fatalf("Failed to create SQL store: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "messages.json")
+	jsonlStore, err := NewJSONLStore(filepath.Join(t.TempDir(), "messages.jsonl"))
+	if err != nil {
+		t.Fatalf("Failed to open JSONL store: %v", err)
+	}
+	defer jsonlStore.Close()
+
+	stores := map[string]DeliveryStore{
+		"InMemoryStore": NewInMemoryStore(100),
+		"FileStore":     NewFileStore(path),
+		"SQLStore":      sqlStore,
+		"JSONLStore":    jsonlStore,
+	}
+
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			var msgs []Message
+			for _, payload := range []string{"a", "b", "c", "d"} {
+				msg := NewMessage("orders", payload)
+				msgs = append(msgs, msg)
+				_ = store.Store(ctx, msg)
+			}
+
+			if err := store.MarkDelivered(ctx, msgs[0].ID(), msgs[2].ID(), "unknown"); err != nil {
+				t.Fatalf("MarkDelivered() error = %v", err)
+			}
+			if got := fmt.Sprint(pendingPayloads(t, store)); got != "[b d]" {
+				t.Errorf("pending = %s, want [b d]", got)
+			}
+
+			// Rewriting keeps the state of the messages kept
+			rs := store.(RewritableStore)
+			err := rs.Rewrite(ctx, func(stored []Message) ([]Message, error) {
+				return stored[1:], nil
+			})
+			if err != nil {
+				t.Fatalf("Rewrite() error = %v", err)
+			}
+			if got := fmt.Sprint(pendingPayloads(t, store)); got != "[b d]" {
+				t.Errorf("pending after rewrite = %s, want [b d]", got)
+			}
+			if all, _ := store.Load(ctx); len(all) != 3 {
+				t.Errorf("expected Load to return delivered messages too, got %d", len(all))
+			}
+		})
+	}
+
+	// The state survives reopening the file
+	if got := fmt.Sprint(pendingPayloads(t, NewFileStore(path))); got != "[b d]" {
+		t.Errorf("pending after reopening = %s, want [b d]", got)
+	}
+}
+
+func TestInMemoryStore_ForgetsTrimmedDeliveries(t *testing.T) {
+	store := NewInMemoryStore(2)
+	ctx := context.Background()
+	first := NewMessage("orders", "a")
+	_ = store.Store(ctx, first)
+	_ = store.MarkDelivered(ctx, first.ID())
+	_ = store.Store(ctx, NewMessage("orders", "b"))
+	_ = store.Store(ctx, NewMessage("orders", "c"))
+
+	if len(store.delivered) != 0 {
+		t.Errorf("expected the trimmed message to be forgotten, got %v", store.delivered)
+	}
+}
+
+func TestPersistentBus_MarksDelivered(t *testing.T) {
+	store := NewInMemoryStore(0)
+	pb := NewPersistentBus(New(WithMaxRetries(2)), store)
+	defer pb.Close()
+
+	var failures atomic.Int32
+	_, _ = pb.Subscribe("orders", HandlerFunc(func(ctx context.Context, msg Message) error {
+		return nil
+	}))
+	_, _ = pb.Subscribe("orders", HandlerFunc(func(ctx context.Context, msg Message) error {
+		// Fails once, then succeeds on retry
+		if msg.Payload() == "retried" && failures.Add(1) == 1 {
+			return errors.New("temporary failure")
+		}
+		return nil
+	}))
+	_, _ = pb.Subscribe("poison", HandlerFunc(func(ctx context.Context, msg Message) error {
+		return errors.New("permanent failure")
+	}))
+
+	ctx := context.Background()
+	_ = pb.Publish(ctx, "orders", "ok")
+	_ = pb.Publish(ctx, "orders", "retried")
+	_ = pb.Publish(ctx, "poison", "dead-lettered")
+	_ = pb.PublishBatch(ctx, []TopicPayload{{Topic: "unheard", Payload: "no subscriber"}, {Topic: "orders", Payload: "batched"}})
+
+	waitFor(t, func() bool { return len(pendingPayloads(t, store)) == 1 })
+	if got := fmt.Sprint(pendingPayloads(t, store)); got != "[no subscriber]" {
+		t.Errorf("pending = %s, want [no subscriber]", got)
+	}
+}
+
+func TestPersistentBus_DeliveredOnceAcknowledged(t *testing.T) {
+	store := NewInMemoryStore(0)
+	pb := NewPersistentBus(New(), store)
+	defer pb.Close()
+
+	ackers := make(chan Acker, 1)
+	_, _ = pb.Subscribe("orders", HandlerFunc(func(ctx context.Context, msg Message) error {
+		return nil
+	}))
+	_, _ = pb.SubscribeWithOptions("orders", HandlerFunc(func(ctx context.Context, msg Message) error {
+		acker, _ := AckerFromContext(ctx)
+		ackers <- acker
+		return nil
+	}), WithManualAck(time.Minute))
+
+	_ = pb.Publish(context.Background(), "orders", "o-1")
+
+	acker := <-ackers
+	time.Sleep(20 * time.Millisecond)
+	if len(pendingPayloads(t, store)) != 1 {
+		t.Fatal("expected the message to stay pending until acknowledged")
+	}
+	_ = acker.Ack()
+	waitFor(t, func() bool { return len(pendingPayloads(t, store)) == 0 })
+}
+
+func TestReplay_ResumeTracked(t *testing.T) {
+	store := NewInMemoryStore(0)
+	ctx := context.Background()
+	for i := 0; i < 10; i++ {
+		_ = store.Store(ctx, NewMessage("orders", i))
+	}
+
+	pb := NewPersistentBus(New(), store)
+	defer pb.Close()
+	var mu sync.Mutex
+	delivered := make(map[interface{}]int)
+	_, _ = pb.Subscribe("orders", HandlerFunc(func(ctx context.Context, msg Message) error {
+		mu.Lock()
+		defer mu.Unlock()
+		delivered[msg.Payload()]++
+		return nil
+	}))
+
+	cancelCtx, cancel := context.WithCancel(ctx)
+	err := pb.Replay(cancelCtx, WithReplayProgress(func(p ReplayProgress) {
+		if p.Replayed == 4 {
+			cancel()
+		}
+	}))
+	var replayErr *ReplayError
+	if !errors.As(err, &replayErr) {
+		t.Fatalf("expected *ReplayError, got %v", err)
+	}
+	waitFor(t, func() bool { return len(pendingPayloads(t, store)) == 6 })
+
+	if err := pb.Replay(ctx, WithReplayStart(replayErr.Position)); err != nil {
+		t.Fatalf("resumed Replay() error = %v", err)
+	}
+	waitFor(t, func() bool { return len(pendingPayloads(t, store)) == 0 })
+
+	mu.Lock()
+	defer mu.Unlock()
+	for i := 0; i < 10; i++ {
+		if delivered[i] != 1 {
+			t.Errorf("expected message %d delivered once, got %d", i, delivered[i])
+		}
+	}
+}
+
+func TestReplay_SkipsDelivered(t *testing.T) {
+	store := NewInMemoryStore(0)
+	ctx := context.Background()
+
+	// Before the restart, only orders were handled
+	before := NewPersistentBus(New(), store)
+	var handled atomic.Int32
+	_, _ = before.Subscribe("orders", countingHandler(&handled))
+	_ = before.Publish(ctx, "orders", "o-1")
+	_ = before.Publish(ctx, "users", "u-1")
+	_ = before.Publish(ctx, "orders", "o-2")
+	waitFor(t, func() bool { return handled.Load() == 2 })
+	waitFor(t, func() bool { return len(pendingPayloads(t, store)) == 1 })
+	_ = before.Close()
+
+	after := NewPersistentBus(New(), store)
+	defer after.Close()
+	replayed := make(chan Message, 3)
+	_, _ = after.Subscribe("*", HandlerFunc(func(ctx context.Context, msg Message) error {
+		replayed <- msg
+		return nil
+	}))
+
+	var progress ReplayProgress
+	if err := after.Replay(ctx, WithReplayProgress(func(p ReplayProgress) { progress = p })); err != nil {
+		t.Fatalf("Replay() error = %v", err)
+	}
+	if progress.Total != 1 || progress.Replayed != 1 {
+		t.Errorf("unexpected progress %+v", progress)
+	}
+
+	msg := <-replayed
+	stored, _ := store.Load(ctx)
+	if msg.Payload() != "u-1" || msg.ID() != stored[1].ID() {
+		t.Errorf("expected the stored users message to be replayed, got %v", msg.Payload())
+	}
+	waitFor(t, func() bool { return len(pendingPayloads(t, store)) == 0 })
+
+	// Replaying again has nothing left to do unless asked to replay all
+	progress = ReplayProgress{Total: -1}
+	_ = after.Replay(ctx, WithReplayProgress(func(p ReplayProgress) { progress = p }))
+	if progress.Total != -1 {
+		t.Errorf("expected nothing to replay, got %+v", progress)
+	}
+	_ = after.Replay(ctx, WithReplayAll(), WithReplayProgress(func(p ReplayProgress) { progress = p }))
+	if progress.Replayed != 3 {
+		t.Errorf("expected all 3 messages replayed, got %+v", progress)
+	}
+}
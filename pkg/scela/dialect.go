@@ -0,0 +1,128 @@
+package scela
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Dialect adapts the SQL written by SQLStore to a database engine: its
+// placeholder style, column types and upsert syntax.
+type Dialect string
+
+const (
+	// DialectSQLite targets SQLite. It is the default.
+	DialectSQLite Dialect = "sqlite"
+	// DialectPostgres targets PostgreSQL, with $1 style placeholders.
+	DialectPostgres Dialect = "postgres"
+	// DialectMySQL targets MySQL and MariaDB. Open the database with
+	// parseTime=true so timestamps scan into time.Time.
+	DialectMySQL Dialect = "mysql"
+)
+
+// validate reports an error for dialects this package does not know.
+func (d Dialect) validate() error {
+	switch d {
+	case DialectSQLite, DialectPostgres, DialectMySQL:
+		return nil
+	}
+	return fmt.Errorf("unsupported SQL dialect %q", d)
+}
+
+// rebind rewrites the ? placeholders of query in the style of the dialect.
+// Queries written by this package never contain ? otherwise.
+func (d Dialect) rebind(query string) string {
+	if d != DialectPostgres {
+		return query
+	}
+
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteByte('$')
+			b.WriteString(strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// keyType is the type of the id column: MySQL cannot index TEXT.
+func (d Dialect) keyType() string {
+	if d == DialectMySQL {
+		return "VARCHAR(255)"
+	}
+	return "TEXT"
+}
+
+// textType is the type of the payload and metadata columns.
+func (d Dialect) textType() string {
+	if d == DialectMySQL {
+		return "LONGTEXT"
+	}
+	return "TEXT"
+}
+
+// timestampType is the type of time columns, keeping sub-second precision
+// and, where the engine has a choice, the time zone.
+func (d Dialect) timestampType() string {
+	switch d {
+	case DialectPostgres:
+		return "TIMESTAMPTZ"
+	case DialectMySQL:
+		return "DATETIME(6)"
+	}
+	return "TIMESTAMP"
+}
+
+// now is the default value of the created_at column, matching the
+// precision of timestampType as MySQL requires.
+func (d Dialect) now() string {
+	if d == DialectMySQL {
+		return "CURRENT_TIMESTAMP(6)"
+	}
+	return "CURRENT_TIMESTAMP"
+}
+
+// integerType is the type of the sequence column, holding int64 numbers.
+func (d Dialect) integerType() string {
+	if d == DialectSQLite {
+		return "INTEGER"
+	}
+	return "BIGINT"
+}
+
+// vacuum returns the statement reclaiming the space freed in table by
+// deleted rows.
+func (d Dialect) vacuum(table string) string {
+	switch d {
+	case DialectPostgres:
+		return "VACUUM " + table
+	case DialectMySQL:
+		return "OPTIMIZE TABLE " + table
+	}
+	return "VACUUM"
+}
+
+// upsert returns a statement inserting columns into table, or updating the
+// update columns of the row whose key already exists.
+func (d Dialect) upsert(table, key string, columns, update []string) string {
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(columns)), ", ")
+	insert := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", table, strings.Join(columns, ", "), placeholders)
+
+	set := make([]string, len(update))
+	if d == DialectMySQL {
+		for i, column := range update {
+			set[i] = fmt.Sprintf("%s = VALUES(%s)", column, column)
+		}
+		return d.rebind(insert + " ON DUPLICATE KEY UPDATE " + strings.Join(set, ", "))
+	}
+
+	for i, column := range update {
+		set[i] = fmt.Sprintf("%s = excluded.%s", column, column)
+	}
+	return d.rebind(fmt.Sprintf("%s ON CONFLICT (%s) DO UPDATE SET %s", insert, key, strings.Join(set, ", ")))
+}
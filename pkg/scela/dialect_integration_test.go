@@ -0,0 +1,17 @@
+//go:build integration
+
+package scela
+
+// The integration build links the PostgreSQL and MySQL drivers, so the
+// dialect tests run against the databases of SCELA_TEST_POSTGRES_DSN and
+// SCELA_TEST_MYSQL_DSN, and fail rather than skip when either is not set:
+//
+//	go test -tags integration -run Dialect ./pkg/scela
+import (
+	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/lib/pq"
+)
+
+func init() {
+	requireDialects = true
+}
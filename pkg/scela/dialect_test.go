@@ -0,0 +1,179 @@
+package scela
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestDialect_Rebind(t *testing.T) {
+	query := "SELECT id FROM t WHERE topic = ? LIMIT ? OFFSET ?"
+	for dialect, want := range map[Dialect]string{
+		DialectSQLite:   query,
+		DialectMySQL:    query,
+		DialectPostgres: "SELECT id FROM t WHERE topic = $1 LIMIT $2 OFFSET $3",
+	} {
+		if got := dialect.rebind(query); got != want {
+			t.Errorf("%s: rebind() = %q, want %q", dialect, got, want)
+		}
+	}
+}
+
+func TestDialect_Upsert(t *testing.T) {
+	columns := []string{"id", "topic", "outbox"}
+	for dialect, want := range map[Dialect]string{
+		DialectSQLite:   "INSERT INTO t (id, topic, outbox) VALUES (?, ?, ?) ON CONFLICT (id) DO UPDATE SET topic = excluded.topic",
+		DialectPostgres: "INSERT INTO t (id, topic, outbox) VALUES ($1, $2, $3) ON CONFLICT (id) DO UPDATE SET topic = excluded.topic",
+		DialectMySQL:    "INSERT INTO t (id, topic, outbox) VALUES (?, ?, ?) ON DUPLICATE KEY UPDATE topic = VALUES(topic)",
+	} {
+		if got := dialect.upsert("t", "id", columns, columns[1:2]); got != want {
+			t.Errorf("%s: upsert() = %q, want %q", dialect, got, want)
+		}
+	}
+}
+
+func TestDialect_Vacuum(t *testing.T) {
+	for dialect, want := range map[Dialect]string{
+		DialectSQLite:   "VACUUM",
+		DialectPostgres: "VACUUM t",
+		DialectMySQL:    "OPTIMIZE TABLE t",
+	} {
+		if got := dialect.vacuum("t"); got != want {
+			t.Errorf("%s: vacuum() = %q, want %q", dialect, got, want)
+		}
+	}
+}
+
+func TestNewSQLStore_UnknownDialect(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	if _, err := NewSQLStore(SQLStoreConfig{DB: db, Dialect: "oracle"}); err == nil {
+		t.Error("expected an unknown dialect to be rejected")
+	}
+}
+
+// requireDialects makes the dialect tests fail instead of skipping when a
+// database is not configured. The integration build tag sets it.
+var requireDialects bool
+
+// dialectDB opens the database the integration tests of dialect run
+// against. SQLite runs in memory; the other dialects run against the
+// database named by SCELA_TEST_POSTGRES_DSN or SCELA_TEST_MYSQL_DSN, with
+// a driver registered as "postgres" or "mysql" linked into the test binary,
+// as the integration build tag does.
+func dialectDB(t *testing.T, dialect Dialect) *sql.DB {
+	t.Helper()
+	if dialect == DialectSQLite {
+		db := setupTestDB(t)
+		db.SetMaxOpenConns(1)
+		return db
+	}
+
+	env := map[Dialect]string{
+		DialectPostgres: "SCELA_TEST_POSTGRES_DSN",
+		DialectMySQL:    "SCELA_TEST_MYSQL_DSN",
+	}[dialect]
+	skip := t.Skipf
+	if requireDialects {
+		skip = t.Fatalf
+	}
+	dsn := os.Getenv(env)
+	if dsn == "" {
+		skip("%s not set", env)
+	}
+	if !slices.Contains(sql.Drivers(), string(dialect)) {
+		skip("no %s driver registered", dialect)
+	}
+	db, err := sql.Open(string(dialect), dsn)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	return db
+}
+
+func TestSQLStoreDialects(t *testing.T) {
+	for _, dialect := range []Dialect{DialectSQLite, DialectPostgres, DialectMySQL} {
+		t.Run(string(dialect), func(t *testing.T) {
+			db := dialectDB(t, dialect)
+			defer db.Close()
+
+			table := fmt.Sprintf("scela_dialect_%d", time.Now().UnixNano())
+			defer func() { _, _ = db.Exec("DROP TABLE " + table) }()
+
+			store, err := NewSQLStore(SQLStoreConfig{DB: db, TableName: table, Dialect: dialect})
+			if err != nil {
+				t.Fatalf("Failed to create SQL store: %v", err)
+			}
+			defer store.Close()
+
+			ctx := context.Background()
+			base := time.Now().UTC().Truncate(time.Millisecond)
+			var msgs []Message
+			for i, topic := range []string{"orders", "users", "orders"} {
+				msg := NewMessage(topic, fmt.Sprint(i)).(*message)
+				msg.timestamp = base.Add(time.Duration(i) * time.Second)
+				msgs = append(msgs, msg)
+			}
+			if err := store.Store(ctx, msgs[0]); err != nil {
+				t.Fatalf("Store() error = %v", err)
+			}
+			if err := store.StoreBatch(ctx, msgs[1:]); err != nil {
+				t.Fatalf("StoreBatch() error = %v", err)
+			}
+			if err := store.Store(ctx, msgs[0]); err == nil {
+				t.Error("expected a duplicate ID to be rejected")
+			}
+
+			loaded, err := store.Load(ctx)
+			if err != nil || len(loaded) != 3 || loaded[2].ID() != msgs[2].ID() {
+				t.Fatalf("Load() = %d messages, %v", len(loaded), err)
+			}
+			if !loaded[1].Timestamp().Equal(msgs[1].Timestamp()) {
+				t.Errorf("timestamp = %v, want %v", loaded[1].Timestamp(), msgs[1].Timestamp())
+			}
+			if orders, _ := store.LoadByTopic(ctx, "orders"); len(orders) != 2 {
+				t.Errorf("LoadByTopic() = %d messages, want 2", len(orders))
+			}
+			if after, _ := store.LoadAfter(ctx, base); len(after) != 2 {
+				t.Errorf("LoadAfter() = %d messages, want 2", len(after))
+			}
+			if page, _ := store.LoadPage(ctx, 1, 1); len(page) != 1 || page[0].ID() != msgs[1].ID() {
+				t.Errorf("LoadPage() = %v", page)
+			}
+
+			if err := store.MarkDelivered(ctx, msgs[0].ID(), msgs[1].ID()); err != nil {
+				t.Fatalf("MarkDelivered() error = %v", err)
+			}
+			err = store.Rewrite(ctx, func(stored []Message) ([]Message, error) {
+				return stored[1:], nil
+			})
+			if err != nil {
+				t.Fatalf("Rewrite() error = %v", err)
+			}
+			if got := fmt.Sprint(pendingPayloads(t, store)); got != "[2]" {
+				t.Errorf("pending = %s, want [2]", got)
+			}
+
+			if err := store.ClearBefore(ctx, base.Add(1500*time.Millisecond)); err != nil {
+				t.Fatalf("ClearBefore() error = %v", err)
+			}
+			if n, _ := store.PurgeExpired(ctx, time.Now()); n != 0 {
+				t.Errorf("PurgeExpired() = %d, want 0", n)
+			}
+			if n, _ := store.Count(ctx); n != 1 {
+				t.Errorf("Count() = %d, want 1", n)
+			}
+			if stats, err := store.Stats(ctx); err != nil || stats.TopicCounts["orders"] != 1 {
+				t.Errorf("Stats() = %+v, %v", stats, err)
+			}
+			if err := store.Vacuum(ctx); err != nil {
+				t.Errorf("Vacuum() error = %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,481 @@
+package scela
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// Keys of the payload map written by EncryptedStore.
+const (
+	encryptedKeyIDField = "scela_key_id"
+	encryptedDataField  = "scela_ciphertext"
+)
+
+// KeyProvider encrypts and decrypts data with versioned keys. KeyRing is the
+// implementation of this package; others can delegate to a key management
+// service.
+type KeyProvider interface {
+	// Encrypt encrypts plaintext with the current key and returns the ID
+	// of the key together with the ciphertext.
+	Encrypt(plaintext []byte) (string, []byte, error)
+
+	// Decrypt decrypts ciphertext produced by Encrypt with the key
+	// identified by id.
+	Decrypt(id string, ciphertext []byte) ([]byte, error)
+}
+
+// KeyRing holds versioned AES keys. New data is always encrypted with the
+// current key, while older keys remain available for decryption so keys can
+// be rotated without losing access to existing records.
+type KeyRing struct {
+	mu      sync.RWMutex
+	keys    map[string]cipher.AEAD
+	current string
+}
+
+// NewKeyRing creates a key ring whose current key is key, identified by id.
+// Keys must be 16, 24 or 32 bytes long (AES-128, AES-192 or AES-256).
+func NewKeyRing(id string, key []byte) (*KeyRing, error) {
+	kr := &KeyRing{keys: make(map[string]cipher.AEAD)}
+	if err := kr.Rotate(id, key); err != nil {
+		return nil, err
+	}
+	return kr, nil
+}
+
+// AddKey registers an older key version used only for decryption.
+func (kr *KeyRing) AddKey(id string, key []byte) error {
+	aead, err := newAEAD(id, key)
+	if err != nil {
+		return err
+	}
+
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+
+	if _, exists := kr.keys[id]; exists {
+		return fmt.Errorf("key %q already registered", id)
+	}
+	kr.keys[id] = aead
+	return nil
+}
+
+// Rotate registers a new key and makes it the current key for encryption.
+// Previously registered keys remain available for decryption.
+func (kr *KeyRing) Rotate(id string, key []byte) error {
+	if err := kr.AddKey(id, key); err != nil {
+		return err
+	}
+
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+	kr.current = id
+	return nil
+}
+
+// CurrentKeyID returns the ID of the key used for new encryptions.
+func (kr *KeyRing) CurrentKeyID() string {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+	return kr.current
+}
+
+// Encrypt encrypts plaintext with the current key and returns the key ID
+// together with the nonce-prefixed ciphertext.
+func (kr *KeyRing) Encrypt(plaintext []byte) (string, []byte, error) {
+	kr.mu.RLock()
+	id := kr.current
+	aead := kr.keys[id]
+	kr.mu.RUnlock()
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	return id, aead.Seal(nonce, nonce, plaintext, []byte(id)), nil
+}
+
+// Decrypt decrypts ciphertext produced by Encrypt with the key identified by id.
+func (kr *KeyRing) Decrypt(id string, ciphertext []byte) ([]byte, error) {
+	kr.mu.RLock()
+	aead, ok := kr.keys[id]
+	kr.mu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("unknown encryption key %q", id)
+	}
+
+	if len(ciphertext) < aead.NonceSize() {
+		return nil, fmt.Errorf("ciphertext too short")
+	}
+
+	nonce, sealed := ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, sealed, []byte(id))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt with key %q: %w", id, err)
+	}
+	return plaintext, nil
+}
+
+// newAEAD creates an AES-GCM cipher for a key ring entry.
+func newAEAD(id string, key []byte) (cipher.AEAD, error) {
+	if id == "" {
+		return nil, fmt.Errorf("key ID cannot be empty")
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key %q: %w", id, err)
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// encryptedPrefix marks payload data encrypted by an EncryptedSerializer.
+// The full form is "sce1:<key ID>:<base64 ciphertext>".
+const encryptedPrefix = "sce1:"
+
+// EncryptedSerializer is a Serializer encrypting the data of another, so
+// that stores using it, such as SQLStore and FileStore (see
+// WithFileSerializer), keep payloads encrypted at rest and decrypt them on
+// Load and Replay. Metadata is not encrypted.
+//
+// Like EncryptedStore, each payload carries the ID of the key it was
+// encrypted with. After a key rotation, rewriting a RewritableStore with
+// the messages it holds re-encrypts them with the current key. Data written
+// before encryption was enabled remains readable.
+type EncryptedSerializer struct {
+	serializer Serializer
+	keys       KeyProvider
+}
+
+// NewEncryptedSerializer creates a serializer encrypting the data of
+// serializer, JSON if nil, with keys.
+func NewEncryptedSerializer(serializer Serializer, keys KeyProvider) *EncryptedSerializer {
+	if serializer == nil {
+		serializer = NewJSONSerializer()
+	}
+	return &EncryptedSerializer{serializer: serializer, keys: keys}
+}
+
+// Serialize implements Serializer.
+func (es *EncryptedSerializer) Serialize(payload interface{}) ([]byte, error) {
+	plaintext, err := es.serializer.Serialize(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	keyID, ciphertext, err := es.keys.Encrypt(plaintext)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(encryptedPrefix + keyID + ":" + base64.StdEncoding.EncodeToString(ciphertext)), nil
+}
+
+// Deserialize implements Serializer.
+func (es *EncryptedSerializer) Deserialize(data []byte, target interface{}) error {
+	if !bytes.HasPrefix(data, []byte(encryptedPrefix)) {
+		return es.serializer.Deserialize(data, target)
+	}
+
+	// Key IDs may contain colons, base64 data does not
+	data = data[len(encryptedPrefix):]
+	i := bytes.LastIndexByte(data, ':')
+	if i < 0 {
+		return fmt.Errorf("malformed encrypted payload")
+	}
+	keyID := string(data[:i])
+
+	ciphertext, err := base64.StdEncoding.DecodeString(string(data[i+1:]))
+	if err != nil {
+		return fmt.Errorf("failed to decode ciphertext: %w", err)
+	}
+	plaintext, err := es.keys.Decrypt(keyID, ciphertext)
+	if err != nil {
+		return err
+	}
+	return es.serializer.Deserialize(plaintext, target)
+}
+
+// EncryptedStore is a MessageStore decorator that encrypts message payloads
+// before they reach the underlying store. Each record carries the ID of the
+// key it was encrypted with, so reads keep working after key rotation.
+//
+// It implements the optional store interfaces, such as BatchStore,
+// DeliveryStore and QueryableStore, through those of the underlying store,
+// or by emulating them with its other methods where possible.
+type EncryptedStore struct {
+	store MessageStore
+	keys  *KeyRing
+}
+
+// NewEncryptedStore wraps store with payload encryption using keys.
+func NewEncryptedStore(store MessageStore, keys *KeyRing) *EncryptedStore {
+	return &EncryptedStore{
+		store: store,
+		keys:  keys,
+	}
+}
+
+// Store implements MessageStore.
+func (es *EncryptedStore) Store(ctx context.Context, msg Message) error {
+	encrypted, err := es.encrypt(msg)
+	if err != nil {
+		return err
+	}
+	return es.store.Store(ctx, encrypted)
+}
+
+// Load implements MessageStore.
+func (es *EncryptedStore) Load(ctx context.Context) ([]Message, error) {
+	return es.decryptAll(es.store.Load(ctx))
+}
+
+// Stats implements StoreStats by delegating to the underlying store.
+func (es *EncryptedStore) Stats(ctx context.Context) (StoreStatistics, error) {
+	return statsOf(ctx, es.store)
+}
+
+// Clear implements MessageStore.
+func (es *EncryptedStore) Clear(ctx context.Context) error {
+	return es.store.Clear(ctx)
+}
+
+// Close implements MessageStore.
+func (es *EncryptedStore) Close() error {
+	return es.store.Close()
+}
+
+// Flush implements StoreFlusher by flushing the underlying store.
+func (es *EncryptedStore) Flush(ctx context.Context) error {
+	return FlushStore(ctx, es.store)
+}
+
+// CloseContext implements StoreCloser by closing the underlying store with
+// CloseStore.
+func (es *EncryptedStore) CloseContext(ctx context.Context) error {
+	return CloseStore(ctx, es.store)
+}
+
+// StoreBatch implements BatchStore. The batch is stored atomically if the
+// underlying store is a BatchStore.
+func (es *EncryptedStore) StoreBatch(ctx context.Context, msgs []Message) error {
+	encrypted := make([]Message, len(msgs))
+	for i, msg := range msgs {
+		var err error
+		if encrypted[i], err = es.encrypt(msg); err != nil {
+			return err
+		}
+	}
+	return storeBatchOf(ctx, es.store, encrypted)
+}
+
+// Rewrite implements RewritableStore. fn receives the messages decrypted,
+// and the messages it adds or changes are encrypted with the current key.
+// The underlying store must implement RewritableStore.
+func (es *EncryptedStore) Rewrite(ctx context.Context, fn func([]Message) ([]Message, error)) error {
+	return rewriteOf(ctx, es.store, func(messages []Message) ([]Message, error) {
+		// Messages kept as they are keep their records
+		records := make(map[Message]Message, len(messages))
+		plain, err := es.decryptAll(append([]Message(nil), messages...), nil)
+		if err != nil {
+			return nil, err
+		}
+		for i, msg := range plain {
+			records[msg] = messages[i]
+		}
+
+		rewritten, err := fn(plain)
+		if err != nil {
+			return nil, err
+		}
+		for i, msg := range rewritten {
+			if record, ok := records[msg]; ok {
+				rewritten[i] = record
+			} else if rewritten[i], err = es.encrypt(msg); err != nil {
+				return nil, err
+			}
+		}
+		return rewritten, nil
+	})
+}
+
+// MarkDelivered implements DeliveryStore. It does nothing if the
+// underlying store does not track deliveries.
+func (es *EncryptedStore) MarkDelivered(ctx context.Context, ids ...string) error {
+	return markDeliveredOf(ctx, es.store, ids...)
+}
+
+// LoadPending implements DeliveryStore. Every message is pending if the
+// underlying store does not track deliveries.
+func (es *EncryptedStore) LoadPending(ctx context.Context) ([]Message, error) {
+	return es.decryptAll(loadPendingOf(ctx, es.store))
+}
+
+// LoadByTopic implements QueryableStore, filtering the loaded messages if
+// the underlying store is not a QueryableStore, as do the other queries.
+func (es *EncryptedStore) LoadByTopic(ctx context.Context, topic string) ([]Message, error) {
+	return es.decryptAll(loadByTopicOf(ctx, es.store, topic))
+}
+
+// LoadAfter implements QueryableStore.
+func (es *EncryptedStore) LoadAfter(ctx context.Context, after time.Time) ([]Message, error) {
+	return es.decryptAll(loadAfterOf(ctx, es.store, after))
+}
+
+// LoadPage implements QueryableStore.
+func (es *EncryptedStore) LoadPage(ctx context.Context, offset, limit int) ([]Message, error) {
+	return es.decryptAll(loadPageOf(ctx, es.store, offset, limit))
+}
+
+// Count implements QueryableStore.
+func (es *EncryptedStore) Count(ctx context.Context) (int, error) {
+	return countOf(ctx, es.store)
+}
+
+// ClearBefore implements QueryableStore. The underlying store must
+// implement QueryableStore or RewritableStore.
+func (es *EncryptedStore) ClearBefore(ctx context.Context, before time.Time) error {
+	return clearBeforeOf(ctx, es.store, before)
+}
+
+// PurgeExpired implements ExpiringStore. The underlying store must
+// implement ExpiringStore or RewritableStore.
+func (es *EncryptedStore) PurgeExpired(ctx context.Context, now time.Time) (int, error) {
+	return purgeExpiredOf(ctx, es.store, now)
+}
+
+// Vacuum implements VacuumableStore. The underlying store must implement
+// VacuumableStore.
+func (es *EncryptedStore) Vacuum(ctx context.Context) error {
+	return vacuumOf(ctx, es.store)
+}
+
+// ReEncrypt migrates every record not encrypted with the current key (including
+// unencrypted records) to the current key and returns the number of records
+// migrated. The underlying store must implement RewritableStore so the
+// migration is applied atomically.
+func (es *EncryptedStore) ReEncrypt(ctx context.Context) (int, error) {
+	rs, ok := es.store.(RewritableStore)
+	if !ok {
+		return 0, fmt.Errorf("store does not support rewriting")
+	}
+
+	current := es.keys.CurrentKeyID()
+	migrated := 0
+
+	err := rs.Rewrite(ctx, func(messages []Message) ([]Message, error) {
+		migrated = 0
+		result := make([]Message, 0, len(messages))
+		for _, msg := range messages {
+			if keyID, _, ok := encryptedFields(msg.Payload()); ok && keyID == current {
+				result = append(result, msg)
+				continue
+			}
+
+			plain, err := es.decrypt(msg)
+			if err != nil {
+				return nil, err
+			}
+			encrypted, err := es.encrypt(plain)
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, encrypted)
+			migrated++
+		}
+		return result, nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to re-encrypt store: %w", err)
+	}
+
+	return migrated, nil
+}
+
+// encrypt returns a copy of msg whose payload is encrypted.
+func (es *EncryptedStore) encrypt(msg Message) (Message, error) {
+	plaintext, err := json.Marshal(msg.Payload())
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode payload: %w", err)
+	}
+
+	keyID, ciphertext, err := es.keys.Encrypt(plaintext)
+	if err != nil {
+		return nil, err
+	}
+
+	return withPayload(msg, map[string]interface{}{
+		encryptedKeyIDField: keyID,
+		encryptedDataField:  base64.StdEncoding.EncodeToString(ciphertext),
+	}), nil
+}
+
+// decryptAll decrypts messages, loaded with err, in place.
+func (es *EncryptedStore) decryptAll(messages []Message, err error) ([]Message, error) {
+	if err != nil {
+		return nil, err
+	}
+	for i, msg := range messages {
+		if messages[i], err = es.decrypt(msg); err != nil {
+			return nil, err
+		}
+	}
+	return messages, nil
+}
+
+// decrypt returns a copy of msg with its payload decrypted. Messages that
+// were stored without encryption are returned unchanged.
+func (es *EncryptedStore) decrypt(msg Message) (Message, error) {
+	keyID, data, ok := encryptedFields(msg.Payload())
+	if !ok {
+		return msg, nil
+	}
+
+	ciphertext, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode ciphertext of message %s: %w", msg.ID(), err)
+	}
+
+	plaintext, err := es.keys.Decrypt(keyID, ciphertext)
+	if err != nil {
+		return nil, fmt.Errorf("message %s: %w", msg.ID(), err)
+	}
+
+	var payload interface{}
+	if err := json.Unmarshal(plaintext, &payload); err != nil {
+		return nil, fmt.Errorf("failed to decode payload of message %s: %w", msg.ID(), err)
+	}
+
+	return withPayload(msg, payload), nil
+}
+
+// encryptedFields extracts the key ID and ciphertext from an encrypted payload.
+func encryptedFields(payload interface{}) (string, string, bool) {
+	fields, ok := payload.(map[string]interface{})
+	if !ok || len(fields) != 2 {
+		return "", "", false
+	}
+
+	keyID, ok := fields[encryptedKeyIDField].(string)
+	if !ok {
+		return "", "", false
+	}
+
+	data, ok := fields[encryptedDataField].(string)
+	if !ok {
+		return "", "", false
+	}
+
+	return keyID, data, true
+}
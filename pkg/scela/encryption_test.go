@@ -0,0 +1,315 @@
+package scela
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testKey(b byte) []byte {
+	return bytes.Repeat([]byte{b}, 32)
+}
+
+func TestKeyRing_EncryptDecrypt(t *testing.T) {
+	kr, err := NewKeyRing("v1", testKey(1))
+	if err != nil {
+		t.Fatalf("NewKeyRing() error = %v", err)
+	}
+
+	id, ciphertext, err := kr.Encrypt([]byte("secret"))
+	if err != nil {
+		t.Fatalf("Encrypt() error = %v", err)
+	}
+	if id != "v1" {
+		t.Errorf("Encrypt() key ID = %q, want v1", id)
+	}
+
+	plaintext, err := kr.Decrypt(id, ciphertext)
+	if err != nil {
+		t.Fatalf("Decrypt() error = %v", err)
+	}
+	if string(plaintext) != "secret" {
+		t.Errorf("Decrypt() = %q, want secret", plaintext)
+	}
+
+	if _, err := kr.Decrypt("v2", ciphertext); err == nil {
+		t.Error("Expected error decrypting with unknown key")
+	}
+}
+
+func TestKeyRing_InvalidKey(t *testing.T) {
+	if _, err := NewKeyRing("v1", []byte("short")); err == nil {
+		t.Error("Expected error for invalid key length")
+	}
+	if _, err := NewKeyRing("", testKey(1)); err == nil {
+		t.Error("Expected error for empty key ID")
+	}
+
+	kr, _ := NewKeyRing("v1", testKey(1))
+	if err := kr.AddKey("v1", testKey(2)); err == nil {
+		t.Error("Expected error registering duplicate key ID")
+	}
+}
+
+func TestEncryptedStore_RoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "encrypted.json")
+	kr, _ := NewKeyRing("v1", testKey(1))
+	store := NewEncryptedStore(NewFileStore(path), kr)
+	ctx := context.Background()
+
+	if err := store.Store(ctx, NewMessage("user.created", map[string]interface{}{"email": "ada@example.com"})); err != nil {
+		t.Fatalf("Store() error = %v", err)
+	}
+
+	raw, _ := os.ReadFile(path)
+	if strings.Contains(string(raw), "ada@example.com") {
+		t.Error("Expected payload to be encrypted on disk")
+	}
+
+	messages, err := store.Load(ctx)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if len(messages) != 1 {
+		t.Fatalf("Expected 1 message, got %d", len(messages))
+	}
+
+	payload := messages[0].Payload().(map[string]interface{})
+	if payload["email"] != "ada@example.com" {
+		t.Errorf("Expected decrypted payload, got %v", payload)
+	}
+}
+
+func TestEncryptedStore_KeyRotation(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	sqlStore, err := NewSQLStore(SQLStoreConfig{DB: db})
+	if err != nil {
+		t.Fatalf("Failed to create SQL store: %v", err)
+	}
+
+	kr, _ := NewKeyRing("v1", testKey(1))
+	store := NewEncryptedStore(sqlStore, kr)
+	ctx := context.Background()
+
+	// Legacy plaintext record and a record under the old key
+	_ = sqlStore.Store(ctx, NewMessage("legacy", "plain"))
+	_ = store.Store(ctx, NewMessage("old", "first"))
+
+	if err := kr.Rotate("v2", testKey(2)); err != nil {
+		t.Fatalf("Rotate() error = %v", err)
+	}
+	_ = store.Store(ctx, NewMessage("new", "second"))
+
+	// Both key versions are readable after rotation
+	messages, err := store.Load(ctx)
+	if err != nil {
+		t.Fatalf("Load() after rotation error = %v", err)
+	}
+	if len(messages) != 3 {
+		t.Fatalf("Expected 3 messages, got %d", len(messages))
+	}
+
+	migrated, err := store.ReEncrypt(ctx)
+	if err != nil {
+		t.Fatalf("ReEncrypt() error = %v", err)
+	}
+	if migrated != 2 {
+		t.Errorf("Expected 2 migrated records, got %d", migrated)
+	}
+
+	raw, _ := sqlStore.Load(ctx)
+	for _, msg := range raw {
+		keyID, _, ok := encryptedFields(msg.Payload())
+		if !ok || keyID != "v2" {
+			t.Errorf("Expected message %s to be encrypted with v2, got %v", msg.Topic(), msg.Payload())
+		}
+	}
+
+	// The old key is no longer needed
+	onlyNew, _ := NewKeyRing("v2", testKey(2))
+	messages, err = NewEncryptedStore(sqlStore, onlyNew).Load(ctx)
+	if err != nil {
+		t.Fatalf("Load() with only the new key error = %v", err)
+	}
+	want := map[string]interface{}{"legacy": "plain", "old": "first", "new": "second"}
+	for _, msg := range messages {
+		if msg.Payload() != want[msg.Topic()] {
+			t.Errorf("Topic %s payload = %v, want %v", msg.Topic(), msg.Payload(), want[msg.Topic()])
+		}
+	}
+}
+
+func TestEncryptedStore_ReEncryptUnsupported(t *testing.T) {
+	kr, _ := NewKeyRing("v1", testKey(1))
+	store := NewEncryptedStore(&failingStore{inner: NewInMemoryStore(10)}, kr)
+
+	if _, err := store.ReEncrypt(context.Background()); err == nil {
+		t.Error("Expected error for store without rewrite support")
+	}
+}
+
+func TestEncryptedStore_ForwardsStoreInterfaces(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	sqlStore, err := NewSQLStore(SQLStoreConfig{DB: db})
+	if err != nil {
+		t.Fatalf("Failed to create SQL store: %v", err)
+	}
+	kr, _ := NewKeyRing("v1", testKey(1))
+	store := NewEncryptedStore(sqlStore, kr)
+	ctx := context.Background()
+
+	expired := NewMessage("orders", "expired")
+	expired.Metadata()[MetadataExpiresAt] = time.Now().Add(-time.Minute).UTC().Format(time.RFC3339Nano)
+	batch := []Message{NewMessage("orders", "o-1"), NewMessage("users", "u-1"), expired}
+	if err := store.StoreBatch(ctx, batch); err != nil {
+		t.Fatalf("StoreBatch() error = %v", err)
+	}
+	raw, _ := sqlStore.Load(ctx)
+	for _, msg := range raw {
+		if _, _, ok := encryptedFields(msg.Payload()); !ok {
+			t.Errorf("Expected message %s stored encrypted, got %v", msg.ID(), msg.Payload())
+		}
+	}
+
+	if err := store.MarkDelivered(ctx, batch[0].ID()); err != nil {
+		t.Fatalf("MarkDelivered() error = %v", err)
+	}
+	if pending, _ := store.LoadPending(ctx); len(pending) != 2 || pending[0].Payload() != "u-1" {
+		t.Errorf("LoadPending() = %v, want the undelivered messages decrypted", pending)
+	}
+	if orders, _ := store.LoadByTopic(ctx, "orders"); len(orders) != 2 || orders[0].Payload() != "o-1" {
+		t.Errorf("LoadByTopic() = %v, want the orders decrypted", orders)
+	}
+	if page, _ := store.LoadPage(ctx, 1, 1); len(page) != 1 || page[0].Payload() != "u-1" {
+		t.Errorf("LoadPage() = %v, want the second message decrypted", page)
+	}
+
+	if n, err := store.PurgeExpired(ctx, time.Now()); err != nil || n != 1 {
+		t.Errorf("PurgeExpired() = %d, %v, want 1 message purged", n, err)
+	}
+	err = store.Rewrite(ctx, func(msgs []Message) ([]Message, error) {
+		if msgs[0].Payload() != "o-1" {
+			return nil, fmt.Errorf("expected decrypted messages, got %v", msgs[0].Payload())
+		}
+		return append(msgs[1:], NewMessage("users", "u-2")), nil
+	})
+	if err != nil {
+		t.Fatalf("Rewrite() error = %v", err)
+	}
+	rewritten, _ := sqlStore.Load(ctx)
+	if len(rewritten) != 2 || rewritten[0].Payload().(map[string]interface{})[encryptedDataField] !=
+		raw[1].Payload().(map[string]interface{})[encryptedDataField] {
+		t.Errorf("Expected the kept message to keep its record, got %v", rewritten)
+	}
+	if _, _, ok := encryptedFields(rewritten[1].Payload()); !ok {
+		t.Errorf("Expected the added message encrypted, got %v", rewritten[1].Payload())
+	}
+	if n, _ := store.Count(ctx); n != 2 {
+		t.Errorf("Count() = %d, want 2", n)
+	}
+	if err := store.Vacuum(ctx); err != nil {
+		t.Errorf("Vacuum() error = %v", err)
+	}
+}
+
+func TestEncryptedStore_EmulatesMissingInterfaces(t *testing.T) {
+	kr, _ := NewKeyRing("v1", testKey(1))
+	store := NewEncryptedStore(&failingStore{inner: NewInMemoryStore(10), failAfter: 1}, kr)
+	ctx := context.Background()
+
+	err := store.StoreBatch(ctx, []Message{NewMessage("orders", "o-1"), NewMessage("orders", "o-2")})
+	if err == nil || !strings.Contains(err.Error(), "1 of 2 messages stored") {
+		t.Errorf("Expected the partial batch reported, got %v", err)
+	}
+	if orders, _ := store.LoadByTopic(ctx, "orders"); len(orders) != 1 || orders[0].Payload() != "o-1" {
+		t.Errorf("LoadByTopic() = %v, want the stored order decrypted", orders)
+	}
+	if pending, _ := store.LoadPending(ctx); len(pending) != 1 {
+		t.Errorf("Expected every message pending, got %v", pending)
+	}
+	if err := store.Vacuum(ctx); err == nil {
+		t.Error("Expected an error vacuuming a store without vacuum support")
+	}
+}
+
+func TestEncryptedSerializer_FileStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "encrypted.json")
+	kr, _ := NewKeyRing("v1", testKey(1))
+	store := NewFileStore(path, WithFileSerializer(NewEncryptedSerializer(nil, kr)))
+	ctx := context.Background()
+
+	if err := store.Store(ctx, NewMessage("users", map[string]interface{}{"email": "jane@example.com"})); err != nil {
+		t.Fatalf("Store() error = %v", err)
+	}
+
+	raw, _ := os.ReadFile(path)
+	if strings.Contains(string(raw), "jane@example.com") {
+		t.Error("Expected the payload encrypted on disk")
+	}
+
+	messages, err := store.Load(ctx)
+	if err != nil || len(messages) != 1 {
+		t.Fatalf("Load() = %v, %v", messages, err)
+	}
+	if email := messages[0].Payload().(map[string]interface{})["email"]; email != "jane@example.com" {
+		t.Errorf("Expected the payload decrypted, got %v", messages[0].Payload())
+	}
+
+	other, _ := NewKeyRing("v2", testKey(2))
+	if _, err := NewFileStore(path, WithFileSerializer(NewEncryptedSerializer(nil, other))).Load(ctx); err == nil {
+		t.Error("Expected an error loading without the key")
+	}
+}
+
+func TestEncryptedSerializer_KeyRotation(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	kr, _ := NewKeyRing("key:v1", testKey(1))
+	serializer := NewEncryptedSerializer(nil, kr)
+	store, err := NewSQLStore(SQLStoreConfig{DB: db, Serializer: serializer})
+	if err != nil {
+		t.Fatalf("Failed to create SQL store: %v", err)
+	}
+	ctx := context.Background()
+
+	// Legacy plaintext row and a row under the old key
+	plain, _ := NewSQLStore(SQLStoreConfig{DB: db})
+	_ = plain.Store(ctx, NewMessage("legacy", "plain"))
+	_ = store.Store(ctx, NewMessage("old", "first"))
+
+	if err := kr.Rotate("key:v2", testKey(2)); err != nil {
+		t.Fatalf("Rotate() error = %v", err)
+	}
+	_ = store.Store(ctx, NewMessage("new", "second"))
+
+	// Rewriting the store re-encrypts every row with the current key
+	if err := store.Rewrite(ctx, func(msgs []Message) ([]Message, error) { return msgs, nil }); err != nil {
+		t.Fatalf("Rewrite() error = %v", err)
+	}
+
+	onlyNew, _ := NewKeyRing("key:v2", testKey(2))
+	reopened, _ := NewSQLStore(SQLStoreConfig{DB: db, Serializer: NewEncryptedSerializer(nil, onlyNew)})
+	messages, err := reopened.Load(ctx)
+	if err != nil {
+		t.Fatalf("Load() with only the new key error = %v", err)
+	}
+	want := map[string]interface{}{"legacy": "plain", "old": "first", "new": "second"}
+	if len(messages) != len(want) {
+		t.Fatalf("Expected %d messages, got %d", len(want), len(messages))
+	}
+	for _, msg := range messages {
+		if msg.Payload() != want[msg.Topic()] {
+			t.Errorf("Topic %s payload = %v, want %v", msg.Topic(), msg.Payload(), want[msg.Topic()])
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package scela
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Errors returned by the bus and its stores. They are returned as is,
+// wrapped with more context, or matched by a typed error, so callers can
+// branch on them with errors.Is.
+var (
+	// ErrBusClosed is returned by the operations of a closed bus, Close
+	// included.
+	ErrBusClosed = errors.New("bus is closed")
+
+	// ErrAlreadyStarted is returned by Start when the bus already delivers
+	// messages.
+	ErrAlreadyStarted = errors.New("bus already started")
+
+	// ErrSubscriptionNotFound is returned when removing a subscription that
+	// is not registered.
+	ErrSubscriptionNotFound = errors.New("subscription not found")
+
+	// ErrInvalidPattern is returned when subscribing or pausing with an
+	// empty topic pattern.
+	ErrInvalidPattern = errors.New("invalid topic pattern")
+
+	// ErrNilHandler is returned when subscribing a nil handler.
+	ErrNilHandler = errors.New("handler cannot be nil")
+
+	// ErrPayloadTooLarge matches the PayloadTooLargeError returned when a
+	// payload exceeds the size set by WithMaxPayloadSize.
+	ErrPayloadTooLarge = errors.New("payload too large")
+
+	// ErrStoreClosed is returned by the operations of a closed store.
+	ErrStoreClosed = errors.New("store is closed")
+)
+
+// PayloadTooLargeError reports a payload whose JSON encoding exceeds the
+// size set by WithMaxPayloadSize. It matches ErrPayloadTooLarge.
+type PayloadTooLargeError struct {
+	Topic string
+	Size  int64
+	Limit int64
+}
+
+// Error implements the error interface.
+func (e *PayloadTooLargeError) Error() string {
+	return fmt.Sprintf("payload of %d bytes on %s exceeds the limit of %d bytes", e.Size, e.Topic, e.Limit)
+}
+
+// Is reports whether target is ErrPayloadTooLarge.
+func (e *PayloadTooLargeError) Is(target error) bool {
+	return target == ErrPayloadTooLarge
+}
+
+// WithMaxPayloadSize rejects publishes whose payload, encoded as JSON as
+// stores write it, is larger than n bytes, with a PayloadTooLargeError.
+// Payloads that cannot be encoded are not limited. Zero, the default,
+// does not limit the size.
+func WithMaxPayloadSize(n int64) Option {
+	return func(b *bus) {
+		if n > 0 {
+			b.maxPayloadSize = n
+		}
+	}
+}
+
+// checkPayload returns a PayloadTooLargeError if payload exceeds the size
+// limit of the bus, a TopicTypeError if it is not of the type of its topic,
+// or a SchemaError if it does not conform to the schemas of its topic.
+func (b *bus) checkPayload(topic string, payload interface{}) error {
+	if b.maxPayloadSize > 0 {
+		if size := payloadSize(payload); size > b.maxPayloadSize {
+			return &PayloadTooLargeError{Topic: topic, Size: size, Limit: b.maxPayloadSize}
+		}
+	}
+	if b.topics != nil {
+		if err := b.topics.Check(topic, payload); err != nil {
+			return err
+		}
+	}
+	if b.schemas != nil {
+		return b.schemas.Validate(topic, payload)
+	}
+	return nil
+}
+
+// payloadChecker is implemented by buses that limit the payloads they
+// accept, so wrappers persisting messages first reject them before.
+type payloadChecker interface {
+	checkPayload(topic string, payload interface{}) error
+}
+
+// checkBusPayload checks payload against the limits of bus, if any.
+func checkBusPayload(bus Bus, topic string, payload interface{}) error {
+	if c, ok := bus.(payloadChecker); ok {
+		return c.checkPayload(topic, payload)
+	}
+	return nil
+}
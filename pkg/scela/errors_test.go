@@ -0,0 +1,96 @@
+package scela
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestErrors_Sentinels(t *testing.T) {
+	bus := New(WithStartPaused())
+	ctx := context.Background()
+
+	if _, err := bus.Subscribe("", HandlerFunc(func(context.Context, Message) error { return nil })); !errors.Is(err, ErrInvalidPattern) {
+		t.Errorf("expected ErrInvalidPattern, got %v", err)
+	}
+	if _, err := bus.Subscribe("topic", nil); !errors.Is(err, ErrNilHandler) {
+		t.Errorf("expected ErrNilHandler, got %v", err)
+	}
+	sub, err := bus.Subscribe("topic", HandlerFunc(func(context.Context, Message) error { return nil }))
+	if err != nil {
+		t.Fatalf("Subscribe() error = %v", err)
+	}
+	if err := sub.Unsubscribe(); err != nil {
+		t.Fatalf("Unsubscribe() error = %v", err)
+	}
+	if err := sub.Unsubscribe(); !errors.Is(err, ErrSubscriptionNotFound) {
+		t.Errorf("expected ErrSubscriptionNotFound unsubscribing twice, got %v", err)
+	}
+	if err := Start(bus); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	if err := Start(bus); !errors.Is(err, ErrAlreadyStarted) {
+		t.Errorf("expected ErrAlreadyStarted, got %v", err)
+	}
+
+	if err := bus.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if err := bus.Close(); !errors.Is(err, ErrBusClosed) {
+		t.Errorf("expected ErrBusClosed closing twice, got %v", err)
+	}
+	if err := bus.Publish(ctx, "topic", 1); !errors.Is(err, ErrBusClosed) {
+		t.Errorf("expected ErrBusClosed, got %v", err)
+	}
+	if _, err := bus.Subscribe("topic", HandlerFunc(func(context.Context, Message) error { return nil })); !errors.Is(err, ErrBusClosed) {
+		t.Errorf("expected ErrBusClosed, got %v", err)
+	}
+}
+
+func TestWithMaxPayloadSize(t *testing.T) {
+	bus := New(WithMaxPayloadSize(8))
+	defer bus.Close()
+
+	ctx := context.Background()
+	if err := bus.PublishSync(ctx, "topic", "small"); err != nil {
+		t.Fatalf("expected a small payload accepted, got %v", err)
+	}
+
+	large := strings.Repeat("x", 16)
+	err := bus.Publish(ctx, "topic", large)
+	var tooLarge *PayloadTooLargeError
+	if !errors.As(err, &tooLarge) || tooLarge.Size != 18 || tooLarge.Limit != 8 {
+		t.Fatalf("expected a PayloadTooLargeError, got %v", err)
+	}
+	if !errors.Is(err, ErrPayloadTooLarge) {
+		t.Errorf("expected the error to match ErrPayloadTooLarge")
+	}
+	if err := bus.PublishWithPriority(ctx, "topic", large, PriorityHigh); !errors.Is(err, ErrPayloadTooLarge) {
+		t.Errorf("expected PublishWithPriority rejected, got %v", err)
+	}
+	if err := bus.PublishSync(ctx, "topic", large); !errors.Is(err, ErrPayloadTooLarge) {
+		t.Errorf("expected PublishSync rejected, got %v", err)
+	}
+	if err := bus.PublishBatch(ctx, []TopicPayload{{Topic: "topic", Payload: 1}, {Topic: "topic", Payload: large}}); !errors.Is(err, ErrPayloadTooLarge) {
+		t.Errorf("expected PublishBatch rejected, got %v", err)
+	}
+}
+
+func TestWithMaxPayloadSize_NotPersisted(t *testing.T) {
+	store := NewInMemoryStore(0)
+	pb := NewPersistentBus(New(WithMaxPayloadSize(8)), store)
+	defer pb.Close()
+
+	ctx := context.Background()
+	if err := pb.Publish(ctx, "topic", strings.Repeat("x", 16)); !errors.Is(err, ErrPayloadTooLarge) {
+		t.Fatalf("expected ErrPayloadTooLarge, got %v", err)
+	}
+	msgs, err := store.Load(ctx)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if len(msgs) != 0 {
+		t.Errorf("expected the rejected message not stored, got %d messages", len(msgs))
+	}
+}
@@ -0,0 +1,124 @@
+package scela
+
+import (
+	"context"
+	"time"
+)
+
+// BusEvent is an event reported to an EventObserver. It is one of
+// PublishEvent, DeliveryEvent, RetryEvent, DLQEvent, DropEvent or
+// LifecycleEvent. New kinds of events may be added, so type switches over
+// events should ignore the kinds they do not know.
+type BusEvent interface {
+	busEvent()
+}
+
+// PublishEvent reports a message published on the bus.
+type PublishEvent struct {
+	Topic   string
+	Message Message
+	// Batch is the number of messages published together by PublishBatch,
+	// or 0 for a message published on its own.
+	Batch int
+}
+
+// DeliveryEvent reports a message handled by its subscribers. Err is the
+// error of the delivery, nil if every subscriber succeeded.
+type DeliveryEvent struct {
+	Message Message
+	Err     error
+}
+
+// RetryEvent reports a failed message scheduled for another attempt.
+type RetryEvent struct {
+	Message Message
+	// Attempt is the number of the attempt that failed, from 1.
+	Attempt int
+	// Delay is the backoff before the next attempt.
+	Delay time.Duration
+	Err   error
+}
+
+// DLQEvent reports a message given up on after its retries, or at once for
+// permanent errors, and dead-lettered.
+type DLQEvent struct {
+	Message  Message
+	Attempts int
+	Err      error
+}
+
+// DropEvent reports a message that will not be delivered, for example
+// because the queue was full, it expired or the bus closed before it was
+// due. Reason is the error observers get in OnMessageProcessed, such as
+// ErrQueueFull or ErrMessageExpired.
+type DropEvent struct {
+	Message Message
+	Reason  error
+}
+
+// LifecycleKind is the kind of a LifecycleEvent.
+type LifecycleKind int
+
+const (
+	// LifecycleSubscribed reports a subscription added.
+	LifecycleSubscribed LifecycleKind = iota
+	// LifecycleUnsubscribed reports a subscription removed.
+	LifecycleUnsubscribed
+	// LifecycleClosed reports the bus closed. It is the last event.
+	LifecycleClosed
+)
+
+// String returns the name of the kind.
+func (k LifecycleKind) String() string {
+	switch k {
+	case LifecycleSubscribed:
+		return "subscribed"
+	case LifecycleUnsubscribed:
+		return "unsubscribed"
+	case LifecycleClosed:
+		return "closed"
+	default:
+		return "unknown"
+	}
+}
+
+// LifecycleEvent reports a change of the subscriptions or of the bus.
+type LifecycleEvent struct {
+	Kind LifecycleKind
+	// Pattern is the pattern of the subscription, empty when closing.
+	Pattern string
+	// Identity is the caller identity (see As) that made the subscription,
+	// if any.
+	Identity string
+}
+
+func (PublishEvent) busEvent()   {}
+func (DeliveryEvent) busEvent()  {}
+func (RetryEvent) busEvent()     {}
+func (DLQEvent) busEvent()       {}
+func (DropEvent) busEvent()      {}
+func (LifecycleEvent) busEvent() {}
+
+// EventObserver receives every bus event through a single method, as an
+// alternative to Observer and its optional extensions. Implementations
+// keep compiling as new kinds of events are added.
+type EventObserver interface {
+	OnEvent(ctx context.Context, event BusEvent)
+}
+
+// EventObserverFunc is a function adapter for the EventObserver interface.
+type EventObserverFunc func(ctx context.Context, event BusEvent)
+
+// OnEvent implements EventObserver.
+func (f EventObserverFunc) OnEvent(ctx context.Context, event BusEvent) {
+	f(ctx, event)
+}
+
+// WithEventObserver adds an event observer to the bus. Events are reported
+// synchronously, from the goroutine where they occur, so OnEvent must not
+// block.
+func WithEventObserver(observer EventObserver) Option {
+	return func(b *bus) {
+		b.observers.AddEvents(observer)
+	}
+}
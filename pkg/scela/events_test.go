@@ -0,0 +1,137 @@
+package scela
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// eventRecorder records the events of a bus.
+type eventRecorder struct {
+	mu     sync.Mutex
+	events []BusEvent
+}
+
+func (r *eventRecorder) OnEvent(ctx context.Context, event BusEvent) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, event)
+}
+
+func (r *eventRecorder) snapshot() []BusEvent {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]BusEvent(nil), r.events...)
+}
+
+func TestWithEventObserver(t *testing.T) {
+	rec := &eventRecorder{}
+	b := New(
+		WithEventObserver(rec),
+		WithMaxRetries(2),
+		WithRetryBackoff(func(attempt int) time.Duration { return time.Duration(attempt) * time.Millisecond }),
+	)
+
+	failure := errors.New("payment declined")
+	sub, _ := b.Subscribe("order.*", HandlerFunc(func(ctx context.Context, msg Message) error {
+		return failure
+	}))
+	ctx := context.Background()
+	_ = b.PublishBatch(ctx, []TopicPayload{{Topic: "order.placed"}, {Topic: "audit.logged"}})
+
+	waitFor(t, func() bool {
+		for _, event := range rec.snapshot() {
+			if _, ok := event.(DLQEvent); ok {
+				return true
+			}
+		}
+		return false
+	})
+	_ = sub.Unsubscribe()
+	_ = b.Close()
+
+	var kinds []string
+	for _, event := range rec.snapshot() {
+		switch e := event.(type) {
+		case PublishEvent:
+			if e.Batch != 2 {
+				t.Errorf("expected a batch of 2, got %d", e.Batch)
+			}
+			kinds = append(kinds, "publish "+e.Topic)
+		case DeliveryEvent:
+			if e.Message.Topic() == "order.placed" && !errors.Is(e.Err, failure) {
+				t.Errorf("expected the handler error, got %v", e.Err)
+			}
+			kinds = append(kinds, "delivery "+e.Message.Topic())
+		case RetryEvent:
+			if e.Attempt != 1 || e.Delay != time.Millisecond || !errors.Is(e.Err, failure) {
+				t.Errorf("unexpected retry event %+v", e)
+			}
+			kinds = append(kinds, "retry")
+		case DLQEvent:
+			if e.Attempts != 2 {
+				t.Errorf("expected 2 attempts, got %d", e.Attempts)
+			}
+			kinds = append(kinds, "dlq")
+		case LifecycleEvent:
+			kinds = append(kinds, e.Kind.String()+" "+e.Pattern)
+		default:
+			t.Errorf("unexpected event %T", event)
+		}
+	}
+
+	want := []string{
+		"subscribed order.*",
+		"publish order.placed", "publish audit.logged",
+		"delivery order.placed", "retry", "delivery order.placed", "dlq",
+		"unsubscribed order.*", "closed ",
+	}
+	if len(kinds) != len(want) {
+		t.Fatalf("expected %v, got %v", want, kinds)
+	}
+	for i := range want {
+		if kinds[i] != want[i] {
+			t.Fatalf("expected %v, got %v", want, kinds)
+		}
+	}
+}
+
+func TestWithEventObserver_Drops(t *testing.T) {
+	var drops []DropEvent
+	var processed []error
+	b := New(
+		WithStartPaused(),
+		WithObserver(&processedObserver{errs: &processed}),
+		WithEventObserver(EventObserverFunc(func(ctx context.Context, event BusEvent) {
+			if drop, ok := event.(DropEvent); ok {
+				drops = append(drops, drop)
+			}
+		})),
+	)
+	_, _ = b.Subscribe("jobs", HandlerFunc(func(ctx context.Context, msg Message) error { return nil }))
+	_ = b.Publish(context.Background(), "jobs", nil)
+	_ = b.Close()
+
+	if len(drops) != 1 || !errors.Is(drops[0].Reason, ErrNotStarted) {
+		t.Fatalf("expected the queued message dropped, got %+v", drops)
+	}
+	if len(processed) != 1 || !errors.Is(processed[0], ErrNotStarted) {
+		t.Errorf("expected observers still told the message was processed, got %v", processed)
+	}
+}
+
+// processedObserver records the errors of OnMessageProcessed.
+type processedObserver struct {
+	errs *[]error
+}
+
+func (o *processedObserver) OnPublish(ctx context.Context, topic string, msg Message) {}
+func (o *processedObserver) OnSubscribe(pattern string)                               {}
+func (o *processedObserver) OnUnsubscribe(pattern string)                             {}
+func (o *processedObserver) OnClose()                                                 {}
+
+func (o *processedObserver) OnMessageProcessed(ctx context.Context, msg Message, err error) {
+	*o.errs = append(*o.errs, err)
+}
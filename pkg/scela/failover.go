@@ -0,0 +1,308 @@
+package scela
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultFailoverThreshold is the number of consecutive primary
+	// failures after which writes go straight to the secondary store.
+	defaultFailoverThreshold = 3
+	// defaultFailoverProbe is how often a failover store tries to
+	// resynchronize its primary store.
+	defaultFailoverProbe = 5 * time.Second
+)
+
+// FailoverStore keeps durable publishing available while its primary store
+// is down. A write the primary fails is stored in the secondary store
+// instead, which buffers it until the primary recovers. After threshold
+// consecutive failures the primary is no longer tried for writes; every
+// probe interval, the buffered messages are copied to the primary, and once
+// they all are, writes go to the primary again.
+//
+// Load merges the buffered messages with those of the primary, so the
+// primary must be reachable to read.
+type FailoverStore struct {
+	primary   MessageStore
+	secondary RewritableStore
+	threshold int
+	probe     time.Duration
+	onError   StoreErrorHandler
+
+	// sync is held exclusively while resynchronizing, so that no write
+	// reaches the secondary store meanwhile.
+	sync sync.RWMutex
+
+	mu         sync.Mutex
+	failures   int
+	failedOver bool
+	buffered   int
+	closed     bool
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// FailoverStoreOption is a functional option for configuring a failover
+// store.
+type FailoverStoreOption func(*FailoverStore)
+
+// WithFailoverThreshold sets the number of consecutive primary failures
+// after which the primary is no longer tried for writes (default 3).
+func WithFailoverThreshold(n int) FailoverStoreOption {
+	return func(f *FailoverStore) {
+		if n > 0 {
+			f.threshold = n
+		}
+	}
+}
+
+// WithFailoverProbe sets how often the buffered messages are copied back to
+// the primary store (default 5s).
+func WithFailoverProbe(interval time.Duration) FailoverStoreOption {
+	return func(f *FailoverStore) {
+		if interval > 0 {
+			f.probe = interval
+		}
+	}
+}
+
+// WithFailoverErrorHandler registers a handler called with the primary
+// store failures absorbed by the secondary store.
+func WithFailoverErrorHandler(handler StoreErrorHandler) FailoverStoreOption {
+	return func(f *FailoverStore) {
+		f.onError = handler
+	}
+}
+
+// NewFailoverStore creates a store writing to primary and falling back to
+// secondary. Everything secondary holds is copied to the primary, including
+// messages buffered before a restart. Close stops resynchronizing.
+func NewFailoverStore(primary MessageStore, secondary RewritableStore, opts ...FailoverStoreOption) *FailoverStore {
+	f := &FailoverStore{
+		primary:   primary,
+		secondary: secondary,
+		threshold: defaultFailoverThreshold,
+		probe:     defaultFailoverProbe,
+		done:      make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(f)
+	}
+	if msgs, err := secondary.Load(context.Background()); err == nil {
+		f.buffered = len(msgs)
+	}
+
+	f.wg.Add(1)
+	go f.run()
+
+	return f
+}
+
+// run resynchronizes the primary store every probe interval until the
+// store is closed.
+func (f *FailoverStore) run() {
+	defer f.wg.Done()
+
+	ticker := time.NewTicker(f.probe)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if f.Buffered() > 0 {
+				_ = f.Resync(context.Background())
+			}
+		case <-f.done:
+			return
+		}
+	}
+}
+
+// FailedOver reports whether writes currently skip the primary store.
+func (f *FailoverStore) FailedOver() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.failedOver
+}
+
+// Buffered returns the number of messages waiting in the secondary store.
+func (f *FailoverStore) Buffered() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.buffered
+}
+
+// Store implements MessageStore.
+func (f *FailoverStore) Store(ctx context.Context, msg Message) error {
+	f.sync.RLock()
+	defer f.sync.RUnlock()
+
+	if !f.FailedOver() {
+		err := f.primary.Store(ctx, msg)
+		if err == nil {
+			f.mu.Lock()
+			f.failures = 0
+			f.mu.Unlock()
+			return nil
+		}
+		if f.onError != nil {
+			f.onError(ctx, &StoreError{Op: "store", Message: msg, Err: err})
+		}
+
+		f.mu.Lock()
+		f.failures++
+		if f.failures >= f.threshold {
+			f.failedOver = true
+		}
+		f.mu.Unlock()
+	}
+
+	if err := f.secondary.Store(ctx, msg); err != nil {
+		return fmt.Errorf("failed to buffer message in the secondary store: %w", err)
+	}
+	f.mu.Lock()
+	f.buffered++
+	f.mu.Unlock()
+	return nil
+}
+
+// Resync copies the buffered messages to the primary store, removing them
+// from the secondary one, and switches writes back to the primary once
+// every message was copied. It runs every probe interval while messages are
+// buffered.
+func (f *FailoverStore) Resync(ctx context.Context) error {
+	f.sync.Lock()
+	defer f.sync.Unlock()
+
+	buffered, err := f.secondary.Load(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to load buffered messages: %w", err)
+	}
+
+	synced, err := f.copyToPrimary(ctx, buffered)
+	if synced > 0 {
+		rerr := f.secondary.Rewrite(ctx, func(msgs []Message) ([]Message, error) {
+			return msgs[synced:], nil
+		})
+		if rerr != nil {
+			// Copied again on the next attempt
+			return errors.Join(err, fmt.Errorf("failed to remove resynchronized messages: %w", rerr))
+		}
+	}
+
+	f.mu.Lock()
+	f.buffered = len(buffered) - synced
+	if err == nil {
+		f.failures = 0
+		f.failedOver = false
+	}
+	f.mu.Unlock()
+
+	if err != nil {
+		return fmt.Errorf("failed to resynchronize the primary store (%d of %d messages copied): %w",
+			synced, len(buffered), err)
+	}
+	return nil
+}
+
+// copyToPrimary writes msgs to the primary store, in one batch if it
+// implements BatchStore, and returns how many were written.
+func (f *FailoverStore) copyToPrimary(ctx context.Context, msgs []Message) (int, error) {
+	if len(msgs) == 0 {
+		return 0, nil
+	}
+	if bs, ok := f.primary.(BatchStore); ok {
+		if err := bs.StoreBatch(ctx, msgs); err != nil {
+			return 0, err
+		}
+		return len(msgs), nil
+	}
+	for i, msg := range msgs {
+		if err := f.primary.Store(ctx, msg); err != nil {
+			return i, err
+		}
+	}
+	return len(msgs), nil
+}
+
+// Load implements MessageStore, merging the buffered messages with those of
+// the primary store, oldest first.
+func (f *FailoverStore) Load(ctx context.Context) ([]Message, error) {
+	f.sync.RLock()
+	defer f.sync.RUnlock()
+
+	msgs, err := f.primary.Load(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if f.Buffered() == 0 {
+		return msgs, nil
+	}
+
+	buffered, err := f.secondary.Load(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load buffered messages: %w", err)
+	}
+	msgs = append(msgs, buffered...)
+	sort.SliceStable(msgs, func(i, j int) bool {
+		return msgs[i].Timestamp().Before(msgs[j].Timestamp())
+	})
+	return msgs, nil
+}
+
+// Clear implements MessageStore, clearing both stores.
+func (f *FailoverStore) Clear(ctx context.Context) error {
+	f.sync.Lock()
+	defer f.sync.Unlock()
+
+	if err := f.primary.Clear(ctx); err != nil {
+		return err
+	}
+	if err := f.secondary.Clear(ctx); err != nil {
+		return fmt.Errorf("failed to clear buffered messages: %w", err)
+	}
+
+	f.mu.Lock()
+	f.buffered = 0
+	f.mu.Unlock()
+	return nil
+}
+
+// Close implements MessageStore. It stops resynchronizing and closes both
+// stores; buffered messages stay in the secondary store.
+func (f *FailoverStore) Close() error {
+	return f.closeWith(func(s MessageStore) error { return s.Close() })
+}
+
+// Flush implements StoreFlusher, flushing both stores with FlushStore.
+func (f *FailoverStore) Flush(ctx context.Context) error {
+	return errors.Join(FlushStore(ctx, f.primary), FlushStore(ctx, f.secondary))
+}
+
+// CloseContext implements StoreCloser. It closes like Close, closing both
+// stores with CloseStore.
+func (f *FailoverStore) CloseContext(ctx context.Context) error {
+	return f.closeWith(func(s MessageStore) error { return CloseStore(ctx, s) })
+}
+
+// closeWith stops resynchronizing and closes both stores with closeFn.
+func (f *FailoverStore) closeWith(closeFn func(MessageStore) error) error {
+	f.mu.Lock()
+	if f.closed {
+		f.mu.Unlock()
+		return ErrStoreClosed
+	}
+	f.closed = true
+	f.mu.Unlock()
+
+	close(f.done)
+	f.wg.Wait()
+
+	return errors.Join(closeFn(f.primary), closeFn(f.secondary))
+}
@@ -0,0 +1,181 @@
+package scela
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// outageStore fails writes and reads while down.
+type outageStore struct {
+	*InMemoryStore
+	down atomic.Bool
+}
+
+var errOutage = errors.New("database unavailable")
+
+func (s *outageStore) Store(ctx context.Context, msg Message) error {
+	if s.down.Load() {
+		return errOutage
+	}
+	return s.InMemoryStore.Store(ctx, msg)
+}
+
+func (s *outageStore) StoreBatch(ctx context.Context, msgs []Message) error {
+	if s.down.Load() {
+		return errOutage
+	}
+	return s.InMemoryStore.StoreBatch(ctx, msgs)
+}
+
+func (s *outageStore) Load(ctx context.Context) ([]Message, error) {
+	if s.down.Load() {
+		return nil, errOutage
+	}
+	return s.InMemoryStore.Load(ctx)
+}
+
+func TestFailoverStore_BuffersAndResyncs(t *testing.T) {
+	primary := &outageStore{InMemoryStore: NewInMemoryStore(0)}
+	secondary := NewInMemoryStore(0)
+	var absorbed atomic.Int32
+	store := NewFailoverStore(primary, secondary,
+		WithFailoverThreshold(2),
+		WithFailoverProbe(5*time.Millisecond),
+		WithFailoverErrorHandler(func(ctx context.Context, err *StoreError) {
+			absorbed.Add(1)
+		}),
+	)
+	defer store.Close()
+	ctx := context.Background()
+
+	_ = store.Store(ctx, NewMessage("orders", "before"))
+	primary.down.Store(true)
+	for _, p := range []string{"during-1", "during-2", "during-3"} {
+		if err := store.Store(ctx, NewMessage("orders", p)); err != nil {
+			t.Fatalf("Store(%s) error = %v", p, err)
+		}
+	}
+
+	if !store.FailedOver() {
+		t.Error("expected the store to fail over")
+	}
+	if store.Buffered() != 3 {
+		t.Errorf("expected 3 buffered messages, got %d", store.Buffered())
+	}
+	// The primary is no longer tried after the threshold
+	if absorbed.Load() != 2 {
+		t.Errorf("expected 2 primary failures, got %d", absorbed.Load())
+	}
+
+	primary.down.Store(false)
+	waitFor(t, func() bool { return !store.FailedOver() && store.Buffered() == 0 })
+
+	msgs, err := store.Load(ctx)
+	if err != nil || len(msgs) != 4 {
+		t.Fatalf("Load() = %d messages, %v, want 4", len(msgs), err)
+	}
+	if msgs[0].Payload() != "before" || msgs[3].Payload() != "during-3" {
+		t.Errorf("expected messages oldest first, got %v first and %v last", msgs[0].Payload(), msgs[3].Payload())
+	}
+	if n, _ := secondary.Count(ctx); n != 0 {
+		t.Errorf("expected the secondary store emptied, got %d messages", n)
+	}
+}
+
+func TestFailoverStore_ResetsFailures(t *testing.T) {
+	primary := &outageStore{InMemoryStore: NewInMemoryStore(0)}
+	store := NewFailoverStore(primary, NewInMemoryStore(0), WithFailoverThreshold(2), WithFailoverProbe(time.Hour))
+	defer store.Close()
+	ctx := context.Background()
+
+	// Isolated failures never reach the threshold
+	for i := 0; i < 3; i++ {
+		primary.down.Store(true)
+		_ = store.Store(ctx, NewMessage("orders", i))
+		primary.down.Store(false)
+		_ = store.Store(ctx, NewMessage("orders", i))
+	}
+	if store.FailedOver() {
+		t.Error("expected the store not to fail over")
+	}
+	if store.Buffered() != 3 {
+		t.Errorf("expected 3 buffered messages, got %d", store.Buffered())
+	}
+
+	msgs, err := store.Load(ctx)
+	if err != nil || len(msgs) != 6 {
+		t.Errorf("Load() = %d messages, %v, want 6", len(msgs), err)
+	}
+}
+
+func TestFailoverStore_ResyncFailure(t *testing.T) {
+	primary := &outageStore{InMemoryStore: NewInMemoryStore(0)}
+	store := NewFailoverStore(primary, NewInMemoryStore(0), WithFailoverThreshold(1), WithFailoverProbe(time.Hour))
+	defer store.Close()
+	ctx := context.Background()
+
+	primary.down.Store(true)
+	_ = store.Store(ctx, NewMessage("orders", "o-1"))
+
+	if err := store.Resync(ctx); !errors.Is(err, errOutage) {
+		t.Fatalf("expected the outage, got %v", err)
+	}
+	if !store.FailedOver() || store.Buffered() != 1 {
+		t.Errorf("expected the message to stay buffered")
+	}
+}
+
+func TestFailoverStore_RestoresBufferAfterRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "buffer.json")
+	primary := NewInMemoryStore(0)
+	ctx := context.Background()
+
+	// Left over by an earlier run
+	_ = NewFileStore(path).Store(ctx, NewMessage("orders", "o-1"))
+
+	store := NewFailoverStore(primary, NewFileStore(path), WithFailoverProbe(5*time.Millisecond))
+
+	waitFor(t, func() bool {
+		n, _ := primary.Count(ctx)
+		return n == 1
+	})
+	if err := store.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if err := store.Close(); err == nil {
+		t.Error("expected an error closing the store twice")
+	}
+}
+
+func TestFailoverStore_ConcurrentClose(t *testing.T) {
+	store := NewFailoverStore(NewInMemoryStore(0), NewInMemoryStore(0))
+
+	errs := make(chan error, 8)
+	var wg sync.WaitGroup
+	for i := 0; i < cap(errs); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- store.CloseContext(context.Background())
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	closed := 0
+	for err := range errs {
+		if err == nil {
+			closed++
+		} else if !errors.Is(err, ErrStoreClosed) {
+			t.Errorf("unexpected error %v", err)
+		}
+	}
+	if closed != 1 {
+		t.Errorf("expected the store closed once, got %d", closed)
+	}
+}
@@ -0,0 +1,80 @@
+package scela
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// GatherOption configures ScatterGather.
+type GatherOption func(*gatherConfig)
+
+// gatherConfig holds the settings of ScatterGather.
+type gatherConfig struct {
+	maxReplies int
+}
+
+// WithMaxReplies makes ScatterGather return as soon as n replies arrived,
+// for example when the number of responders is known. By default it waits
+// until ctx is done.
+func WithMaxReplies(n int) GatherOption {
+	return func(c *gatherConfig) {
+		if n > 0 {
+			c.maxReplies = n
+		}
+	}
+}
+
+// ScatterGather publishes payload on topic and collects the replies of every
+// handler calling Respond, in the order they arrived. It returns when ctx is
+// done, or once the number of replies set by WithMaxReplies arrived; ctx
+// must have a deadline unless that number is set. An error wrapping the
+// error of ctx is returned only if no reply arrived.
+//
+// Like Request, it uses an ephemeral reply topic, whose subscription is
+// removed when ScatterGather returns.
+func ScatterGather(ctx context.Context, b Bus, topic string, payload interface{}, opts ...GatherOption) ([]Message, error) {
+	var cfg gatherConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	var (
+		mu      sync.Mutex
+		replies []Message
+		done    = make(chan struct{})
+	)
+	inbox, err := openReplyInbox(b, func(msg Message) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if cfg.maxReplies > 0 && len(replies) >= cfg.maxReplies {
+			return
+		}
+		replies = append(replies, msg)
+		if len(replies) == cfg.maxReplies {
+			close(done)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer inbox.close()
+
+	if err := inbox.publish(ctx, topic, payload); err != nil {
+		return nil, err
+	}
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+	}
+	inbox.close()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(replies) == 0 {
+		return nil, fmt.Errorf("no reply to request on %s: %w", topic, ctx.Err())
+	}
+	return append([]Message(nil), replies...), nil
+}
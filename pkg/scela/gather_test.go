@@ -0,0 +1,58 @@
+package scela
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestScatterGather(t *testing.T) {
+	bus := New(WithWorkers(3))
+	defer bus.Close()
+
+	for _, region := range []string{"eu", "us", "ap"} {
+		region := region
+		_, _ = bus.Subscribe("price.quote", HandlerFunc(func(ctx context.Context, msg Message) error {
+			return Respond(ctx, region)
+		}))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	replies, err := ScatterGather(ctx, bus, "price.quote", "sku-1", WithMaxReplies(3))
+	if err != nil {
+		t.Fatalf("ScatterGather: %v", err)
+	}
+	seen := make(map[interface{}]bool)
+	for _, reply := range replies {
+		seen[reply.Payload()] = true
+	}
+	if len(replies) != 3 || !seen["eu"] || !seen["us"] || !seen["ap"] {
+		t.Errorf("expected a reply from every region, got %d: %v", len(replies), seen)
+	}
+
+	// Without a reply limit, the replies arrived before the deadline count
+	short, cancelShort := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancelShort()
+	if replies, err := ScatterGather(short, bus, "price.quote", "sku-1"); err != nil || len(replies) != 3 {
+		t.Errorf("expected 3 replies at the deadline, got %d, %v", len(replies), err)
+	}
+
+	if stats := bus.(StatsReporter).Stats(); stats.ReplyTopics != 0 || stats.Subscriptions != 3 {
+		t.Errorf("expected the reply topics removed, got %d reply topics and %d subscriptions", stats.ReplyTopics, stats.Subscriptions)
+	}
+}
+
+func TestScatterGather_NoReply(t *testing.T) {
+	bus := New()
+	defer bus.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	if _, err := ScatterGather(ctx, bus, "price.quote", nil); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected DeadlineExceeded, got %v", err)
+	}
+}
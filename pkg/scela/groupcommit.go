@@ -0,0 +1,148 @@
+package scela
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// defaultGroupCommitBatch is the largest group committed at once when no
+// limit is configured.
+const defaultGroupCommitBatch = 100
+
+// GroupCommitStats describes the work of a store using group commit.
+type GroupCommitStats struct {
+	// Commits is the number of groups committed.
+	Commits int64
+	// Messages is the number of messages committed in groups.
+	Messages int64
+	// Fallbacks is the number of groups that failed and were committed
+	// message by message instead.
+	Fallbacks int64
+}
+
+// groupCommitter coalesces concurrent writes into groups committed together,
+// so that many publishers share one transaction or fsync. Every caller
+// waits until its message is committed, so durability is unchanged; only
+// the cost is shared.
+//
+// The first writer of a group leads it: it waits for the window to pass or
+// the group to fill, then commits the group and hands the result to every
+// writer in it.
+type groupCommitter struct {
+	window   time.Duration
+	maxBatch int
+
+	// commit writes a group in one operation, and commitOne a single
+	// message when the group failed.
+	commit    func(ctx context.Context, msgs []Message) error
+	commitOne func(ctx context.Context, msg Message) error
+
+	mu      sync.Mutex
+	pending []*groupWrite
+	full    chan struct{}
+	stats   GroupCommitStats
+}
+
+// groupWrite is a message waiting in a group.
+type groupWrite struct {
+	msg  Message
+	done chan error
+}
+
+// newGroupCommitter creates a committer grouping the writes made within
+// window, at most maxBatch at a time (defaultGroupCommitBatch if zero or
+// less).
+func newGroupCommitter(
+	window time.Duration,
+	maxBatch int,
+	commit func(ctx context.Context, msgs []Message) error,
+	commitOne func(ctx context.Context, msg Message) error,
+) *groupCommitter {
+	if maxBatch <= 0 {
+		maxBatch = defaultGroupCommitBatch
+	}
+	return &groupCommitter{
+		window:    window,
+		maxBatch:  maxBatch,
+		commit:    commit,
+		commitOne: commitOne,
+	}
+}
+
+// write adds msg to the current group and returns once it is committed.
+func (g *groupCommitter) write(ctx context.Context, msg Message) error {
+	w := &groupWrite{msg: msg, done: make(chan error, 1)}
+
+	g.mu.Lock()
+	g.pending = append(g.pending, w)
+	leader := len(g.pending) == 1
+	if leader {
+		g.full = make(chan struct{})
+	} else if len(g.pending) == g.maxBatch {
+		close(g.full)
+	}
+	full := g.full
+	g.mu.Unlock()
+
+	if leader {
+		g.lead(ctx, full)
+	}
+	return <-w.done
+}
+
+// lead waits for the group to fill or the window to pass, then commits it.
+func (g *groupCommitter) lead(ctx context.Context, full chan struct{}) {
+	timer := time.NewTimer(g.window)
+	select {
+	case <-timer.C:
+	case <-full:
+		timer.Stop()
+	}
+
+	g.mu.Lock()
+	group := g.pending
+	g.pending = nil
+	g.mu.Unlock()
+
+	// The group outlives the leader's request; keep its values only
+	ctx = context.WithoutCancel(ctx)
+
+	msgs := make([]Message, len(group))
+	for i, w := range group {
+		msgs[i] = w.msg
+	}
+
+	if err := g.commit(ctx, msgs); err == nil {
+		g.record(len(group), false)
+		for _, w := range group {
+			w.done <- nil
+		}
+		return
+	}
+
+	// One bad message must not fail the others: commit them one by one
+	g.record(len(group), true)
+	for _, w := range group {
+		w.done <- g.commitOne(ctx, w.msg)
+	}
+}
+
+// record counts a committed group.
+func (g *groupCommitter) record(n int, fallback bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.stats.Commits++
+	g.stats.Messages += int64(n)
+	if fallback {
+		g.stats.Fallbacks++
+	}
+}
+
+// snapshot returns the statistics so far.
+func (g *groupCommitter) snapshot() GroupCommitStats {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.stats
+}
@@ -0,0 +1,185 @@
+package scela
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// storeConcurrently stores each message from its own goroutine and returns
+// the error of each call, indexed like msgs.
+func storeConcurrently(store MessageStore, msgs []Message) []error {
+	errs := make([]error, len(msgs))
+	var wg sync.WaitGroup
+	for i, msg := range msgs {
+		wg.Add(1)
+		go func(i int, msg Message) {
+			defer wg.Done()
+			errs[i] = store.Store(context.Background(), msg)
+		}(i, msg)
+	}
+	wg.Wait()
+	return errs
+}
+
+func TestGroupCommitter_Coalesces(t *testing.T) {
+	var (
+		mu     sync.Mutex
+		groups [][]Message
+	)
+	g := newGroupCommitter(20*time.Millisecond, 0,
+		func(ctx context.Context, msgs []Message) error {
+			mu.Lock()
+			defer mu.Unlock()
+			groups = append(groups, msgs)
+			return nil
+		},
+		func(ctx context.Context, msg Message) error {
+			t.Error("unexpected single commit")
+			return nil
+		},
+	)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err := g.write(context.Background(), NewMessage("t", i)); err != nil {
+				t.Errorf("write() error = %v", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	stats := g.snapshot()
+	if stats.Messages != 20 {
+		t.Errorf("expected 20 messages committed, got %d", stats.Messages)
+	}
+	if stats.Commits >= 20 {
+		t.Errorf("expected writes to be grouped, got %d commits", stats.Commits)
+	}
+	if int(stats.Commits) != len(groups) {
+		t.Errorf("stats report %d commits, made %d", stats.Commits, len(groups))
+	}
+}
+
+func TestGroupCommitter_FlushesFullGroup(t *testing.T) {
+	g := newGroupCommitter(time.Hour, 4,
+		func(ctx context.Context, msgs []Message) error { return nil },
+		func(ctx context.Context, msg Message) error { return nil },
+	)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		var wg sync.WaitGroup
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				_ = g.write(context.Background(), NewMessage("t", i))
+			}(i)
+		}
+		wg.Wait()
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("full group not committed before the window")
+	}
+	if stats := g.snapshot(); stats.Commits != 1 || stats.Messages != 4 {
+		t.Errorf("expected one group of 4, got %+v", stats)
+	}
+}
+
+func TestGroupCommitter_FailsOnlyBadWrite(t *testing.T) {
+	errBad := errors.New("bad message")
+	g := newGroupCommitter(20*time.Millisecond, 0,
+		func(ctx context.Context, msgs []Message) error {
+			for _, msg := range msgs {
+				if msg.Payload() == "bad" {
+					return errBad
+				}
+			}
+			return nil
+		},
+		func(ctx context.Context, msg Message) error {
+			if msg.Payload() == "bad" {
+				return errBad
+			}
+			return nil
+		},
+	)
+
+	payloads := []string{"a", "bad", "b", "c"}
+	errs := make([]error, len(payloads))
+	var wg sync.WaitGroup
+	for i, p := range payloads {
+		wg.Add(1)
+		go func(i int, p string) {
+			defer wg.Done()
+			errs[i] = g.write(context.Background(), NewMessage("t", p))
+		}(i, p)
+	}
+	wg.Wait()
+
+	for i, p := range payloads {
+		if p == "bad" {
+			if !errors.Is(errs[i], errBad) {
+				t.Errorf("expected the bad write to fail, got %v", errs[i])
+			}
+		} else if errs[i] != nil {
+			t.Errorf("write %q error = %v", p, errs[i])
+		}
+	}
+	if g.snapshot().Fallbacks == 0 {
+		t.Error("expected a fallback to single commits")
+	}
+}
+
+func TestSQLStore_GroupCommit(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	// Every connection to :memory: opens a different database
+	db.SetMaxOpenConns(1)
+
+	store, err := NewSQLStore(SQLStoreConfig{DB: db, GroupCommitWindow: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("NewSQLStore() error = %v", err)
+	}
+
+	msgs := make([]Message, 50)
+	for i := range msgs {
+		msgs[i] = NewMessage("orders", fmt.Sprintf("order-%d", i))
+	}
+	// A message stored twice fails alone
+	dup := msgs[0]
+	if err := store.Store(context.Background(), dup); err != nil {
+		t.Fatalf("Store() error = %v", err)
+	}
+
+	errs := storeConcurrently(store, msgs)
+	for i, err := range errs {
+		if i == 0 {
+			if err == nil {
+				t.Error("expected the duplicate message to fail")
+			}
+		} else if err != nil {
+			t.Errorf("Store(%d) error = %v", i, err)
+		}
+	}
+
+	n, err := store.Count(context.Background())
+	if err != nil || n != 50 {
+		t.Fatalf("Count() = %d, %v, want 50", n, err)
+	}
+	stats := store.GroupCommitStats()
+	if stats.Messages != 51 || stats.Commits >= 51 {
+		t.Errorf("expected grouped commits of 51 messages, got %+v", stats)
+	}
+}
@@ -118,6 +118,71 @@ func (h *MessageHistory) GetInTimeRange(start, end time.Time) []HistoryEntry {
 	return result
 }
 
+// CausalNode is a message in a causal tree together with the messages
+// published by its handlers.
+type CausalNode struct {
+	Message  Message
+	Children []*CausalNode
+}
+
+// GetCausedBy returns the distinct messages whose causation ID is messageID,
+// in the order they were first recorded.
+func (h *MessageHistory) GetCausedBy(messageID string) []Message {
+	_, children := h.causalIndex()
+	return children[messageID]
+}
+
+// GetCausalTree reconstructs the tree of messages caused, directly or
+// indirectly, by the message with the given ID. It returns nil if the
+// message is not in the history.
+func (h *MessageHistory) GetCausalTree(messageID string) *CausalNode {
+	messages, children := h.causalIndex()
+
+	root, ok := messages[messageID]
+	if !ok {
+		return nil
+	}
+
+	visited := make(map[string]bool)
+	var build func(msg Message) *CausalNode
+	build = func(msg Message) *CausalNode {
+		visited[msg.ID()] = true
+		node := &CausalNode{Message: msg}
+		for _, child := range children[msg.ID()] {
+			if !visited[child.ID()] {
+				node.Children = append(node.Children, build(child))
+			}
+		}
+		return node
+	}
+
+	return build(root)
+}
+
+// causalIndex returns the distinct recorded messages by ID and, for each
+// message ID, the messages it caused.
+func (h *MessageHistory) causalIndex() (map[string]Message, map[string][]Message) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	messages := make(map[string]Message)
+	children := make(map[string][]Message)
+
+	for _, entry := range h.entries {
+		msg := entry.Message
+		if _, seen := messages[msg.ID()]; seen {
+			continue
+		}
+		messages[msg.ID()] = msg
+
+		if parent := CausationID(msg); parent != "" {
+			children[parent] = append(children[parent], msg)
+		}
+	}
+
+	return messages, children
+}
+
 // Clear removes all history entries.
 func (h *MessageHistory) Clear() {
 	h.mu.Lock()
@@ -177,9 +242,11 @@ func NewAuditableBus(bus Bus, history *MessageHistory) *AuditableBus {
 	}
 }
 
-// Publish publishes a message and records it in the audit trail.
+// Publish publishes a message and records it in the audit trail. The
+// message recorded is the one delivered, so handlers publishing in turn
+// link their messages to it in the causal tree.
 func (ab *AuditableBus) Publish(ctx context.Context, topic string, payload interface{}) error {
-	msg := NewMessage(topic, payload)
+	msg := newBusMessage(ctx, ab.Bus, topic, payload, PriorityNormal)
 
 	// Record publication
 	ab.history.Record(HistoryEntry{
@@ -189,7 +256,14 @@ func (ab *AuditableBus) Publish(ctx context.Context, topic string, payload inter
 	})
 
 	// Publish
-	err := ab.Bus.Publish(ctx, topic, payload)
+	err := checkBusPayload(ab.Bus, topic, payload)
+	if err == nil {
+		if mp, ok := ab.Bus.(messagePublisher); ok {
+			err = mp.publishMessages(ctx, []Message{msg}, false)
+		} else {
+			err = ab.Bus.Publish(ctx, topic, payload)
+		}
+	}
 	if err != nil {
 		ab.history.Record(HistoryEntry{
 			Message:   msg,
@@ -0,0 +1,98 @@
+package scela
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// MetadataHops holds the components a message passed through, oldest
+// first, as a list of {"component", "at"} records.
+const MetadataHops = "hops"
+
+// Components recorded by the bus itself.
+const (
+	// HopBridgeOut is recorded when ForwardTo sends a message to a Sink.
+	HopBridgeOut = "bridge.out"
+	// HopBridgeIn is recorded when ConsumeFrom publishes a message received
+	// from a Source.
+	HopBridgeIn = "bridge.in"
+	// HopDeadLetter is recorded when a message is dead-lettered.
+	HopDeadLetter = "deadletter"
+)
+
+// Hop is a component a message passed through.
+type Hop struct {
+	Component string
+	At        time.Time
+}
+
+// AddHop returns a copy of msg recording that it passed through component
+// now. Custom routers, aggregators and republishers call it so that the
+// path of a message across a topology can be followed, see HopPath. The ID,
+// topic, payload, timestamp and priority are preserved; msg is unchanged.
+func AddHop(msg Message, component string) Message {
+	metadata := make(map[string]interface{}, len(msg.Metadata())+1)
+	for k, v := range msg.Metadata() {
+		metadata[k] = v
+	}
+	metadata[MetadataHops] = appendHop(metadata[MetadataHops], component, time.Now())
+
+	return &message{
+		id:        msg.ID(),
+		topic:     msg.Topic(),
+		payload:   msg.Payload(),
+		metadata:  metadata,
+		timestamp: msg.Timestamp(),
+		priority:  MessagePriority(msg),
+	}
+}
+
+// appendHop returns a new list of hop records made of hops and a record of
+// component at the given time. hops is not modified, as copies of a message
+// share it.
+func appendHop(hops interface{}, component string, at time.Time) []interface{} {
+	existing, _ := hops.([]interface{})
+	records := make([]interface{}, len(existing), len(existing)+1)
+	copy(records, existing)
+	return append(records, map[string]interface{}{
+		"component": component,
+		"at":        at.UTC().Format(time.RFC3339Nano),
+	})
+}
+
+// Hops returns the components msg passed through, oldest first. Malformed
+// records are skipped.
+func Hops(msg Message) []Hop {
+	records, _ := msg.Metadata()[MetadataHops].([]interface{})
+	hops := make([]Hop, 0, len(records))
+	for _, r := range records {
+		record, ok := r.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		component, _ := record["component"].(string)
+		s, _ := record["at"].(string)
+		at, err := time.Parse(time.RFC3339Nano, s)
+		if component == "" || err != nil {
+			continue
+		}
+		hops = append(hops, Hop{Component: component, At: at})
+	}
+	return hops
+}
+
+// HopPath renders the path of msg, with the time spent between hops, for
+// example "bridge.out -> bridge.in (+12ms) -> deadletter (+3.2s)". It
+// returns an empty string if msg recorded no hop.
+func HopPath(msg Message) string {
+	hops := Hops(msg)
+	parts := make([]string, len(hops))
+	for i, hop := range hops {
+		parts[i] = hop.Component
+		if i > 0 {
+			parts[i] += fmt.Sprintf(" (+%v)", hop.At.Sub(hops[i-1].At).Round(time.Millisecond))
+		}
+	}
+	return strings.Join(parts, " -> ")
+}
@@ -0,0 +1,106 @@
+package scela
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAddHop_CopiesMessage(t *testing.T) {
+	msg := NewMessageWithPriority("orders", "o-1", PriorityHigh)
+	first := AddHop(msg, "router")
+	second := AddHop(first, "aggregator")
+	sibling := AddHop(first, "archiver")
+
+	if len(Hops(msg)) != 0 {
+		t.Error("expected the original message unchanged")
+	}
+	if second.ID() != msg.ID() || MessagePriority(second) != PriorityHigh {
+		t.Error("expected the identity and priority to be kept")
+	}
+
+	path := func(m Message) []string {
+		var components []string
+		for _, hop := range Hops(m) {
+			components = append(components, hop.Component)
+		}
+		return components
+	}
+	if got := strings.Join(path(second), ","); got != "router,aggregator" {
+		t.Errorf("unexpected hops %s", got)
+	}
+	if got := strings.Join(path(sibling), ","); got != "router,archiver" {
+		t.Errorf("expected copies not to share hops, got %s", got)
+	}
+}
+
+func TestHopPath(t *testing.T) {
+	msg := NewMessage("orders", "o-1")
+	start := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	var hops interface{}
+	hops = appendHop(hops, HopBridgeOut, start)
+	hops = appendHop(hops, HopBridgeIn, start.Add(12*time.Millisecond))
+	hops = appendHop(hops, HopDeadLetter, start.Add(3212*time.Millisecond))
+	msg.Metadata()[MetadataHops] = hops
+
+	want := "bridge.out -> bridge.in (+12ms) -> deadletter (+3.2s)"
+	if got := HopPath(msg); got != want {
+		t.Errorf("HopPath() = %q, want %q", got, want)
+	}
+	if HopPath(NewMessage("orders", "o-2")) != "" {
+		t.Error("expected an empty path without hops")
+	}
+}
+
+func TestHops_SurviveStore(t *testing.T) {
+	store := NewFileStore(filepath.Join(t.TempDir(), "messages.json"))
+	ctx := context.Background()
+	_ = store.Store(ctx, AddHop(AddHop(NewMessage("orders", "o-1"), "a"), "b"))
+
+	msgs, err := store.Load(ctx)
+	if err != nil || len(msgs) != 1 {
+		t.Fatalf("Load() = %v, %v", msgs, err)
+	}
+	if hops := Hops(msgs[0]); len(hops) != 2 || hops[1].Component != "b" {
+		t.Errorf("unexpected hops after loading %+v", hops)
+	}
+}
+
+func TestHops_AcrossBridgeAndDeadLetter(t *testing.T) {
+	// Messages leave one bus through a sink and enter another from a source
+	sink := &stubSink{}
+	upstream := New()
+	defer upstream.Close()
+	_, _ = ForwardTo(upstream, "orders", sink)
+	_ = upstream.PublishSync(context.Background(), "orders", "o-1")
+	if len(sink.sent) != 1 {
+		t.Fatalf("expected 1 forwarded message, got %d", len(sink.sent))
+	}
+
+	dead := make(chan Message, 1)
+	downstream := New(WithMaxRetries(0), WithDeadLetterHandler(HandlerFunc(func(ctx context.Context, msg Message) error {
+		dead <- msg
+		return nil
+	})))
+	defer downstream.Close()
+	_, _ = downstream.Subscribe("orders", HandlerFunc(func(ctx context.Context, msg Message) error {
+		return errors.New("handler error")
+	}))
+
+	if err := ConsumeFrom(context.Background(), &stubSource{msgs: sink.sent}, downstream); err != nil {
+		t.Fatalf("ConsumeFrom() error = %v", err)
+	}
+
+	select {
+	case msg := <-dead:
+		path := HopPath(msg)
+		if !strings.HasPrefix(path, "bridge.out -> bridge.in (+") || !strings.Contains(path, "-> deadletter (+") {
+			t.Errorf("unexpected hop path %q", path)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("message not dead-lettered")
+	}
+}
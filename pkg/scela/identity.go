@@ -0,0 +1,105 @@
+package scela
+
+import "context"
+
+// MetadataIdentity holds the identity of the caller that published a
+// message through a bus returned by As.
+const MetadataIdentity = "identity"
+
+// identityContextKey is the context key under which the caller identity is
+// stored.
+type identityContextKey struct{}
+
+// ContextWithIdentity returns a context carrying the caller identity.
+// Messages published with it are stamped with MetadataIdentity.
+func ContextWithIdentity(ctx context.Context, identity string) context.Context {
+	return context.WithValue(ctx, identityContextKey{}, identity)
+}
+
+// IdentityFromContext returns the caller identity carried by ctx, if any.
+func IdentityFromContext(ctx context.Context) (string, bool) {
+	identity, ok := ctx.Value(identityContextKey{}).(string)
+	return identity, ok && identity != ""
+}
+
+// Identity returns the identity of the caller that published msg, or "".
+func Identity(msg Message) string {
+	identity, _ := msg.Metadata()[MetadataIdentity].(string)
+	return identity
+}
+
+// IdentityBus is a facade over a bus that attributes every publish and
+// subscribe to a caller identity, such as a module name.
+type IdentityBus struct {
+	bus      Bus
+	identity string
+}
+
+// As returns a facade over bus acting as identity. Messages published
+// through it carry the identity in MetadataIdentity and in the context seen
+// by observers; subscriptions record it as their owner (see
+// SubscriptionInfo.Owner and IdentityObserver), and messages published by
+// their handlers are attributed to it as well.
+func As(bus Bus, identity string) *IdentityBus {
+	return &IdentityBus{
+		bus:      bus,
+		identity: identity,
+	}
+}
+
+// Identity returns the caller identity of the facade.
+func (ib *IdentityBus) Identity() string {
+	return ib.identity
+}
+
+// Publish implements Bus.
+func (ib *IdentityBus) Publish(ctx context.Context, topic string, payload interface{}) error {
+	return ib.bus.Publish(ib.context(ctx), topic, payload)
+}
+
+// PublishSync implements Bus.
+func (ib *IdentityBus) PublishSync(ctx context.Context, topic string, payload interface{}) error {
+	return ib.bus.PublishSync(ib.context(ctx), topic, payload)
+}
+
+// PublishWithPriority implements Bus.
+func (ib *IdentityBus) PublishWithPriority(
+	ctx context.Context, topic string, payload interface{}, priority Priority,
+) error {
+	return ib.bus.PublishWithPriority(ib.context(ctx), topic, payload, priority)
+}
+
+// PublishBatch implements Bus.
+func (ib *IdentityBus) PublishBatch(ctx context.Context, batch []TopicPayload) error {
+	return ib.bus.PublishBatch(ib.context(ctx), batch)
+}
+
+// Subscribe implements Bus.
+func (ib *IdentityBus) Subscribe(pattern string, handler Handler) (Subscription, error) {
+	return ib.SubscribeWithOptions(pattern, handler)
+}
+
+// SubscribeWithOptions implements Bus.
+func (ib *IdentityBus) SubscribeWithOptions(
+	pattern string, handler Handler, opts ...SubscriptionOption,
+) (Subscription, error) {
+	opts = append(opts, func(c *subscriptionConfig) {
+		c.owner = ib.identity
+	})
+	return ib.bus.SubscribeWithOptions(pattern, handler, opts...)
+}
+
+// Use implements Bus.
+func (ib *IdentityBus) Use(middleware ...Middleware) {
+	ib.bus.Use(middleware...)
+}
+
+// Close closes the underlying bus.
+func (ib *IdentityBus) Close() error {
+	return ib.bus.Close()
+}
+
+// context returns ctx carrying the facade identity.
+func (ib *IdentityBus) context(ctx context.Context) context.Context {
+	return ContextWithIdentity(ctx, ib.identity)
+}
@@ -0,0 +1,85 @@
+package scela
+
+import (
+	"context"
+	"sync"
+	"testing"
+)
+
+// identityRecorder records observer events with their caller identity.
+type identityRecorder struct {
+	countingObserver
+	mu         sync.Mutex
+	publishers []string
+	subscribes []string
+	anonymous  int
+}
+
+func (r *identityRecorder) OnSubscribe(pattern string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.anonymous++
+}
+
+func (r *identityRecorder) OnPublish(ctx context.Context, topic string, msg Message) {
+	identity, _ := IdentityFromContext(ctx)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.publishers = append(r.publishers, identity+":"+Identity(msg))
+}
+
+func (r *identityRecorder) OnSubscribeAs(identity, pattern string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.subscribes = append(r.subscribes, identity+":"+pattern)
+}
+
+func (r *identityRecorder) OnUnsubscribeAs(identity, pattern string) {}
+
+func TestAs(t *testing.T) {
+	rec := &identityRecorder{}
+	bus := New(WithObserver(rec))
+	defer bus.Close()
+
+	billing := As(bus, "billing")
+	shipping := As(bus, "shipping")
+
+	received := make(chan Message, 2)
+	_, _ = shipping.Subscribe("invoice.paid", HandlerFunc(func(ctx context.Context, msg Message) error {
+		received <- msg
+		return bus.PublishSync(ctx, "parcel.ready", nil)
+	}))
+	_, _ = bus.Subscribe("parcel.ready", HandlerFunc(func(ctx context.Context, msg Message) error {
+		received <- msg
+		return nil
+	}))
+
+	if err := billing.PublishSync(context.Background(), "invoice.paid", nil); err != nil {
+		t.Fatalf("PublishSync() error = %v", err)
+	}
+
+	paid, ready := <-received, <-received
+	if Identity(paid) != "billing" {
+		t.Errorf("Expected invoice.paid from billing, got %q", Identity(paid))
+	}
+	if Identity(ready) != "shipping" {
+		t.Errorf("Expected handler publish attributed to shipping, got %q", Identity(ready))
+	}
+
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	if len(rec.publishers) != 2 || rec.publishers[0] != "billing:billing" {
+		t.Errorf("Unexpected observed publishers %v", rec.publishers)
+	}
+	if len(rec.subscribes) != 1 || rec.subscribes[0] != "shipping:invoice.paid" {
+		t.Errorf("Unexpected observed subscriptions %v", rec.subscribes)
+	}
+	if rec.anonymous != 1 {
+		t.Errorf("Expected anonymous subscription reported through OnSubscribe, got %d", rec.anonymous)
+	}
+
+	subs, _ := InspectSubscriptions(shipping)
+	if subs[0].Owner != "shipping" || subs[1].Owner != "" {
+		t.Errorf("Unexpected subscription owners %q and %q", subs[0].Owner, subs[1].Owner)
+	}
+}
@@ -37,8 +37,9 @@ func (f HandlerFunc) Handle(ctx context.Context, msg Message) error {
 	return f(ctx, msg)
 }
 
-// Bus is the message bus interface.
-type Bus interface {
+// Publisher is the publishing side of a bus, for code that only publishes
+// and for mocking it.
+type Publisher interface {
 	// Publish publishes a message asynchronously.
 	Publish(ctx context.Context, topic string, payload interface{}) error
 
@@ -48,9 +49,26 @@ type Bus interface {
 	// PublishWithPriority publishes a message asynchronously with the specified priority.
 	PublishWithPriority(ctx context.Context, topic string, payload interface{}, priority Priority) error
 
+	// PublishBatch publishes several messages asynchronously in one call.
+	PublishBatch(ctx context.Context, batch []TopicPayload) error
+}
+
+// Subscriber is the subscribing side of a bus, for code that only
+// subscribes and for mocking it.
+type Subscriber interface {
 	// Subscribe subscribes a handler to a topic pattern.
 	Subscribe(pattern string, handler Handler) (Subscription, error)
 
+	// SubscribeWithOptions subscribes a handler to a topic pattern with
+	// per-subscription options.
+	SubscribeWithOptions(pattern string, handler Handler, opts ...SubscriptionOption) (Subscription, error)
+}
+
+// Bus is the message bus interface.
+type Bus interface {
+	Publisher
+	Subscriber
+
 	// Use adds middleware to the bus.
 	Use(middleware ...Middleware)
 
@@ -58,13 +76,25 @@ type Bus interface {
 	Close() error
 }
 
+// TopicPayload is a single entry of a batch publish.
+type TopicPayload struct {
+	Topic   string
+	Payload interface{}
+}
+
 // Subscription represents a subscription to messages.
 type Subscription interface {
 	// Topic returns the subscription pattern.
 	Topic() string
 
-	// Unsubscribe removes the subscription.
+	// Unsubscribe removes the subscription. Invocations already in progress
+	// are not waited for.
 	Unsubscribe() error
+
+	// UnsubscribeAndWait removes the subscription and waits until in-flight
+	// handler invocations finish or ctx is done. Calling it from the
+	// subscription's own handler blocks until ctx is done.
+	UnsubscribeAndWait(ctx context.Context) error
 }
 
 // Middleware wraps handlers for cross-cutting concerns.
@@ -0,0 +1,589 @@
+package scela
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// JSONLStore persists messages to an append-only JSON Lines file. Unlike
+// FileStore, which rewrites its whole file on every write, storing a
+// message appends a single line, and delivery state is appended as
+// separate records. The messages are also held in memory, so loads do not
+// read the file.
+//
+// Removing messages, with Rewrite, PurgeExpired or ClearBefore, rewrites
+// the file without the removed messages and the delivery records, as
+// Compact does. Messages keep their ID, topic, metadata, timestamp and
+// priority across restarts; payloads are stored as JSON and come back as
+// generic JSON values, like with FileStore.
+type JSONLStore struct {
+	path            string
+	sync            bool
+	compactInterval time.Duration
+	compression     *recordCompressor
+	onError         StoreErrorHandler
+
+	mu        sync.Mutex
+	file      *os.File
+	size      int64
+	records   int
+	messages  []Message
+	delivered map[string]bool
+	closed    bool
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// JSONLStoreOption is a functional option for configuring a JSON Lines
+// store.
+type JSONLStoreOption func(*JSONLStore)
+
+// WithJSONLSync makes every write wait for the file to be synced to disk,
+// so that stored messages survive a power loss, at the cost of latency.
+func WithJSONLSync() JSONLStoreOption {
+	return func(s *JSONLStore) {
+		s.sync = true
+	}
+}
+
+// WithJSONLCompaction compacts the file every interval, if messages were
+// removed or delivered since the last compaction.
+func WithJSONLCompaction(interval time.Duration) JSONLStoreOption {
+	return func(s *JSONLStore) {
+		s.compactInterval = interval
+	}
+}
+
+// WithJSONLCompression compresses each record payload of at least threshold
+// bytes with the given compressor.
+func WithJSONLCompression(compressor Compressor, threshold int) JSONLStoreOption {
+	return func(s *JSONLStore) {
+		s.compression = newRecordCompressor(compressor, threshold)
+	}
+}
+
+// WithJSONLErrorHandler registers a handler called when a background
+// compaction fails.
+func WithJSONLErrorHandler(handler StoreErrorHandler) JSONLStoreOption {
+	return func(s *JSONLStore) {
+		s.onError = handler
+	}
+}
+
+// jsonlRecord is a line of a JSONLStore file: a message, or the delivery
+// of messages stored earlier when Op is "delivered".
+type jsonlRecord struct {
+	Op         string                 `json:"op,omitempty"`
+	ID         string                 `json:"id,omitempty"`
+	Topic      string                 `json:"topic,omitempty"`
+	Payload    json.RawMessage        `json:"payload,omitempty"`
+	Compressed bool                   `json:"compressed,omitempty"`
+	Metadata   map[string]interface{} `json:"metadata,omitempty"`
+	Timestamp  *time.Time             `json:"timestamp,omitempty"`
+	Priority   Priority               `json:"priority,omitempty"`
+	Delivered  bool                   `json:"delivered,omitempty"`
+	IDs        []string               `json:"ids,omitempty"`
+}
+
+// opDelivered marks delivery records.
+const opDelivered = "delivered"
+
+// NewJSONLStore opens the JSON Lines store at path, creating the file if
+// needed, and loads the messages it holds. A last line left incomplete by
+// a crash is discarded.
+func NewJSONLStore(path string, opts ...JSONLStoreOption) (*JSONLStore, error) {
+	s := &JSONLStore{
+		path:        path,
+		compression: newRecordCompressor(nil, 0),
+		delivered:   make(map[string]bool),
+		done:        make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	s.file = file
+
+	if s.compactInterval > 0 {
+		s.wg.Add(1)
+		go s.run()
+	}
+
+	return s, nil
+}
+
+// load reads the messages and delivery state of the file.
+func (s *JSONLStore) load() error {
+	file, err := os.OpenFile(s.path, os.O_RDWR, 0)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to open %s: %w", s.path, err)
+	}
+	defer func() { _ = file.Close() }()
+
+	reader := bufio.NewReader(file)
+	for line := 1; ; line++ {
+		data, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			if len(data) > 0 {
+				// The last write was interrupted: drop it
+				if err := file.Truncate(s.size); err != nil {
+					return fmt.Errorf("failed to discard incomplete record: %w", err)
+				}
+			}
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", s.path, err)
+		}
+
+		if len(bytes.TrimSpace(data)) == 0 {
+			s.size += int64(len(data))
+			continue
+		}
+
+		var rec jsonlRecord
+		if err := json.Unmarshal(data, &rec); err != nil {
+			return fmt.Errorf("invalid record on line %d of %s: %w", line, s.path, err)
+		}
+		if err := s.apply(rec); err != nil {
+			return fmt.Errorf("invalid record on line %d of %s: %w", line, s.path, err)
+		}
+		s.size += int64(len(data))
+		s.records++
+	}
+
+	return nil
+}
+
+// apply applies a record read from the file to the state in memory.
+func (s *JSONLStore) apply(rec jsonlRecord) error {
+	if rec.Op == opDelivered {
+		for _, id := range rec.IDs {
+			s.delivered[id] = true
+		}
+		return nil
+	}
+
+	payload, err := s.decodePayload(rec)
+	if err != nil {
+		return err
+	}
+	msg := &message{
+		id:       rec.ID,
+		topic:    rec.Topic,
+		payload:  payload,
+		metadata: rec.Metadata,
+		priority: rec.Priority,
+	}
+	if msg.metadata == nil {
+		msg.metadata = make(map[string]interface{})
+	}
+	if rec.Timestamp != nil {
+		msg.timestamp = *rec.Timestamp
+	}
+	if rec.Delivered {
+		s.delivered[msg.id] = true
+	}
+	s.messages = append(s.messages, msg)
+	return nil
+}
+
+// decodePayload returns the payload of a message record, decompressing it
+// if it was written compressed.
+func (s *JSONLStore) decodePayload(rec jsonlRecord) (interface{}, error) {
+	data := []byte(rec.Payload)
+	if rec.Compressed {
+		var stored string
+		if err := json.Unmarshal(rec.Payload, &stored); err != nil {
+			return nil, fmt.Errorf("invalid compressed payload: %w", err)
+		}
+		decoded, err := s.compression.decode(stored)
+		if err != nil {
+			return nil, err
+		}
+		data = decoded
+	}
+
+	var payload interface{}
+	if len(data) == 0 {
+		return nil, nil
+	}
+	if err := json.Unmarshal(data, &payload); err != nil {
+		return nil, fmt.Errorf("failed to decode payload: %w", err)
+	}
+	return payload, nil
+}
+
+// encode appends the line of msg to buf.
+func (s *JSONLStore) encode(buf *bytes.Buffer, msg Message) error {
+	data, err := json.Marshal(msg.Payload())
+	if err != nil {
+		return fmt.Errorf("failed to serialize payload: %w", err)
+	}
+	stored, compressed, err := s.compression.encode(data)
+	if err != nil {
+		return err
+	}
+	if compressed {
+		data, _ = json.Marshal(stored)
+	}
+
+	timestamp := msg.Timestamp()
+	rec := jsonlRecord{
+		ID:         msg.ID(),
+		Topic:      msg.Topic(),
+		Payload:    data,
+		Compressed: compressed,
+		Metadata:   msg.Metadata(),
+		Timestamp:  &timestamp,
+		Priority:   MessagePriority(msg),
+		Delivered:  s.delivered[msg.ID()],
+	}
+	return s.encodeRecord(buf, rec)
+}
+
+// encodeRecord appends rec as a line to buf.
+func (s *JSONLStore) encodeRecord(buf *bytes.Buffer, rec jsonlRecord) error {
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return fmt.Errorf("failed to serialize record: %w", err)
+	}
+	buf.Write(line)
+	buf.WriteByte('\n')
+	return nil
+}
+
+// appendLines writes n encoded records at the end of the file. A failed
+// write is cut off, so that later records start on a line of their own.
+// Must be called with the lock held.
+func (s *JSONLStore) appendLines(buf *bytes.Buffer, n int) error {
+	if s.closed {
+		return ErrStoreClosed
+	}
+
+	written, err := s.file.Write(buf.Bytes())
+	if err == nil && s.sync {
+		err = s.file.Sync()
+	}
+	if err != nil {
+		if written > 0 {
+			_ = s.file.Truncate(s.size)
+		}
+		return fmt.Errorf("failed to append to %s: %w", s.path, err)
+	}
+
+	s.size += int64(written)
+	s.records += n
+	return nil
+}
+
+// Store implements MessageStore. The message is appended as a single line.
+func (s *JSONLStore) Store(ctx context.Context, msg Message) error {
+	return s.StoreBatch(ctx, []Message{msg})
+}
+
+// StoreBatch implements BatchStore. The batch is appended with a single
+// write.
+func (s *JSONLStore) StoreBatch(ctx context.Context, msgs []Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var buf bytes.Buffer
+	for _, msg := range msgs {
+		if err := s.encode(&buf, msg); err != nil {
+			return err
+		}
+	}
+	if err := s.appendLines(&buf, len(msgs)); err != nil {
+		return err
+	}
+	s.messages = append(s.messages, msgs...)
+	return nil
+}
+
+// MarkDelivered implements DeliveryStore. The IDs are appended as a single
+// record.
+func (s *JSONLStore) MarkDelivered(ctx context.Context, ids ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Unknown and already delivered messages need no record
+	stored := make(map[string]bool, len(s.messages))
+	for _, msg := range s.messages {
+		stored[msg.ID()] = true
+	}
+	var marked []string
+	for _, id := range ids {
+		if stored[id] && !s.delivered[id] {
+			marked = append(marked, id)
+		}
+	}
+	if len(marked) == 0 {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	if err := s.encodeRecord(&buf, jsonlRecord{Op: opDelivered, IDs: marked}); err != nil {
+		return err
+	}
+	if err := s.appendLines(&buf, 1); err != nil {
+		return err
+	}
+	for _, id := range marked {
+		s.delivered[id] = true
+	}
+	return nil
+}
+
+// LoadPending implements DeliveryStore.
+func (s *JSONLStore) LoadPending(ctx context.Context) ([]Message, error) {
+	return s.query(func(msg Message) bool { return !s.delivered[msg.ID()] }), nil
+}
+
+// Load implements MessageStore.
+func (s *JSONLStore) Load(ctx context.Context) ([]Message, error) {
+	return s.query(nil), nil
+}
+
+// LoadByTopic implements QueryableStore.
+func (s *JSONLStore) LoadByTopic(ctx context.Context, topic string) ([]Message, error) {
+	return s.query(func(msg Message) bool { return msg.Topic() == topic }), nil
+}
+
+// LoadAfter implements QueryableStore.
+func (s *JSONLStore) LoadAfter(ctx context.Context, after time.Time) ([]Message, error) {
+	return s.query(func(msg Message) bool { return msg.Timestamp().After(after) }), nil
+}
+
+// LoadPage implements QueryableStore.
+func (s *JSONLStore) LoadPage(ctx context.Context, offset, limit int) ([]Message, error) {
+	if offset < 0 || limit < 0 {
+		return nil, fmt.Errorf("invalid page: offset %d, limit %d", offset, limit)
+	}
+
+	messages := s.query(nil)
+	if offset >= len(messages) {
+		return []Message{}, nil
+	}
+	messages = messages[offset:]
+	if limit < len(messages) {
+		messages = messages[:limit]
+	}
+	return messages, nil
+}
+
+// Count implements QueryableStore.
+func (s *JSONLStore) Count(ctx context.Context) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.messages), nil
+}
+
+// query returns the stored messages matching match, all if nil.
+func (s *JSONLStore) query(match func(Message) bool) []Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	result := make([]Message, 0, len(s.messages))
+	for _, msg := range s.messages {
+		if match == nil || match(msg) {
+			result = append(result, msg)
+		}
+	}
+	return result
+}
+
+// Rewrite implements RewritableStore. The file is compacted to the
+// rewritten messages.
+func (s *JSONLStore) Rewrite(ctx context.Context, fn func([]Message) ([]Message, error)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rewritten, err := fn(append([]Message(nil), s.messages...))
+	if err != nil {
+		return err
+	}
+	return s.compactTo(rewritten)
+}
+
+// ClearBefore implements QueryableStore.
+func (s *JSONLStore) ClearBefore(ctx context.Context, before time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	kept := make([]Message, 0, len(s.messages))
+	for _, msg := range s.messages {
+		if !msg.Timestamp().Before(before) {
+			kept = append(kept, msg)
+		}
+	}
+	if len(kept) == len(s.messages) {
+		return nil
+	}
+	return s.compactTo(kept)
+}
+
+// PurgeExpired implements ExpiringStore.
+func (s *JSONLStore) PurgeExpired(ctx context.Context, now time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	kept := purgeExpired(append([]Message(nil), s.messages...), now)
+	n := len(s.messages) - len(kept)
+	if n == 0 {
+		return 0, nil
+	}
+	if err := s.compactTo(kept); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// Clear implements MessageStore.
+func (s *JSONLStore) Clear(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.compactTo(nil)
+}
+
+// Compact rewrites the file with one line per stored message, dropping
+// delivery records and removed messages. It does nothing if the file holds
+// nothing else.
+func (s *JSONLStore) Compact(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.records == len(s.messages) {
+		return nil
+	}
+	return s.compactTo(s.messages)
+}
+
+// compactTo replaces the file with one holding messages, atomically. Must
+// be called with the lock held.
+func (s *JSONLStore) compactTo(messages []Message) error {
+	if s.closed {
+		return ErrStoreClosed
+	}
+
+	kept := make(map[string]bool, len(messages))
+	for _, msg := range messages {
+		kept[msg.ID()] = true
+	}
+	for id := range s.delivered {
+		if !kept[id] {
+			delete(s.delivered, id)
+		}
+	}
+
+	// Statistics describe the file as it is about to be written
+	s.compression.reset()
+
+	var buf bytes.Buffer
+	for _, msg := range messages {
+		if err := s.encode(&buf, msg); err != nil {
+			return err
+		}
+	}
+	if err := writeFileAtomic(s.path, buf.Bytes()); err != nil {
+		return fmt.Errorf("failed to compact %s: %w", s.path, err)
+	}
+
+	// The old handle still points at the replaced file
+	file, err := os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to reopen %s: %w", s.path, err)
+	}
+	_ = s.file.Close()
+	s.file = file
+	s.size = int64(buf.Len())
+	s.records = len(messages)
+	s.messages = append([]Message(nil), messages...)
+	return nil
+}
+
+// run compacts the file every interval until the store is closed.
+func (s *JSONLStore) run() {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(s.compactInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			ctx := context.Background()
+			if err := s.Compact(ctx); err != nil && s.onError != nil {
+				s.onError(ctx, &StoreError{Op: "compact", Err: err})
+			}
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// Stats implements StoreStats. SizeBytes is the size of the file.
+func (s *JSONLStore) Stats(ctx context.Context) (StoreStatistics, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats := computeStoreStatistics(s.messages)
+	stats.SizeBytes = s.size
+	return stats, nil
+}
+
+// CompressionStats returns compression statistics for the records written
+// since the file was last compacted.
+func (s *JSONLStore) CompressionStats() CompressionStats {
+	return s.compression.stats()
+}
+
+// Flush implements StoreFlusher. It syncs the file to disk, which
+// WithJSONLSync does on every write.
+func (s *JSONLStore) Flush(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return ErrStoreClosed
+	}
+	return s.file.Sync()
+}
+
+// Close implements MessageStore. It stops the background compaction and
+// closes the file.
+func (s *JSONLStore) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	s.mu.Unlock()
+
+	close(s.done)
+	s.wg.Wait()
+	return s.file.Close()
+}
@@ -0,0 +1,232 @@
+package scela
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// openJSONL opens the JSON Lines store at path, closing it at the end of
+// the test.
+func openJSONL(t *testing.T, path string, opts ...JSONLStoreOption) *JSONLStore {
+	t.Helper()
+	store, err := NewJSONLStore(path, opts...)
+	if err != nil {
+		t.Fatalf("NewJSONLStore() error = %v", err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+	return store
+}
+
+// fileLines returns the number of lines of the file at path.
+func fileLines(t *testing.T, path string) int {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+	return bytes.Count(data, []byte("\n"))
+}
+
+func TestJSONLStore_PreservesMessages(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "messages.jsonl")
+	store := openJSONL(t, path)
+	ctx := context.Background()
+
+	msg := NewMessageWithPriority("orders", map[string]interface{}{"id": "o-1"}, PriorityHigh).(*message)
+	msg.metadata["tenant"] = "acme"
+	msg.timestamp = time.Date(2024, 5, 1, 12, 30, 0, 123456789, time.UTC)
+	if err := store.Store(ctx, msg); err != nil {
+		t.Fatalf("Store() error = %v", err)
+	}
+	_ = store.Close()
+
+	msgs, _ := openJSONL(t, path).Load(ctx)
+	if len(msgs) != 1 {
+		t.Fatalf("expected 1 message after reopening, got %d", len(msgs))
+	}
+	got := msgs[0]
+	if got.ID() != msg.ID() || got.Topic() != "orders" || MessagePriority(got) != PriorityHigh {
+		t.Errorf("unexpected message %s on %s with priority %v", got.ID(), got.Topic(), MessagePriority(got))
+	}
+	if !got.Timestamp().Equal(msg.Timestamp()) {
+		t.Errorf("timestamp = %v, want %v", got.Timestamp(), msg.Timestamp())
+	}
+	if got.Metadata()["tenant"] != "acme" {
+		t.Errorf("metadata = %v", got.Metadata())
+	}
+	if fmt.Sprint(got.Payload()) != "map[id:o-1]" {
+		t.Errorf("payload = %v", got.Payload())
+	}
+}
+
+func TestJSONLStore_AppendsOnly(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "messages.jsonl")
+	store := openJSONL(t, path)
+	ctx := context.Background()
+
+	_ = store.Store(ctx, NewMessage("orders", 1))
+	before, _ := os.ReadFile(path)
+	_ = store.StoreBatch(ctx, []Message{NewMessage("orders", 2), NewMessage("orders", 3)})
+	after, _ := os.ReadFile(path)
+
+	if !bytes.HasPrefix(after, before) {
+		t.Error("expected storing to append to the file")
+	}
+	if n := fileLines(t, path); n != 3 {
+		t.Errorf("expected one line per message, got %d", n)
+	}
+	if stats, _ := store.Stats(ctx); stats.MessageCount != 3 || stats.SizeBytes != int64(len(after)) {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+func TestJSONLStore_CompactsDeliveryRecords(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "messages.jsonl")
+	store := openJSONL(t, path)
+	ctx := context.Background()
+
+	first, second := NewMessage("orders", "a"), NewMessage("orders", "b")
+	_ = store.StoreBatch(ctx, []Message{first, second})
+	_ = store.MarkDelivered(ctx, first.ID())
+	_ = store.MarkDelivered(ctx, first.ID(), "unknown")
+	if n := fileLines(t, path); n != 3 {
+		t.Fatalf("expected a single delivery record appended, got %d lines", n)
+	}
+
+	if err := store.Compact(ctx); err != nil {
+		t.Fatalf("Compact() error = %v", err)
+	}
+	if n := fileLines(t, path); n != 2 {
+		t.Errorf("expected one line per message after compacting, got %d", n)
+	}
+
+	// Writes after compacting go to the new file
+	_ = store.Store(ctx, NewMessage("orders", "c"))
+	_ = store.Close()
+	if got := fmt.Sprint(pendingPayloads(t, openJSONL(t, path))); got != "[b c]" {
+		t.Errorf("pending after reopening = %s, want [b c]", got)
+	}
+}
+
+func TestJSONLStore_CompactsInBackground(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "messages.jsonl")
+	store := openJSONL(t, path, WithJSONLCompaction(5*time.Millisecond), WithJSONLSync())
+	ctx := context.Background()
+
+	msg := NewMessage("orders", "a")
+	_ = store.Store(ctx, msg)
+	_ = store.MarkDelivered(ctx, msg.ID())
+
+	waitFor(t, func() bool {
+		store.mu.Lock()
+		defer store.mu.Unlock()
+		return store.records == 1
+	})
+}
+
+func TestJSONLStore_DiscardsIncompleteRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "messages.jsonl")
+	store := openJSONL(t, path)
+	ctx := context.Background()
+	_ = store.Store(ctx, NewMessage("orders", "a"))
+	_ = store.Close()
+
+	// A crash cut the last write short
+	f, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o600)
+	_, _ = f.WriteString(`{"id":"torn","topic":"or`)
+	_ = f.Close()
+
+	store = openJSONL(t, path)
+	_ = store.Store(ctx, NewMessage("orders", "b"))
+	_ = store.Close()
+
+	msgs, _ := openJSONL(t, path).Load(ctx)
+	if len(msgs) != 2 || msgs[1].Payload() != "b" {
+		t.Errorf("expected the incomplete record discarded, got %d messages", len(msgs))
+	}
+}
+
+func TestJSONLStore_RejectsCorruptFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "messages.jsonl")
+	_ = os.WriteFile(path, []byte("not json\n"), 0o600)
+
+	if _, err := NewJSONLStore(path); err == nil {
+		t.Error("expected a corrupt record to be reported")
+	}
+}
+
+func TestJSONLStore_RemovesMessages(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "messages.jsonl")
+	store := openJSONL(t, path)
+	ctx := context.Background()
+
+	old := NewMessage("orders", "old").(*message)
+	old.timestamp = time.Now().Add(-time.Hour)
+	expired := NewMessage("orders", "expired")
+	expired.Metadata()[MetadataExpiresAt] = time.Now().Add(-time.Minute).Format(time.RFC3339Nano)
+	_ = store.StoreBatch(ctx, []Message{old, expired, NewMessage("users", "u-1"), NewMessage("orders", "kept")})
+
+	if err := store.ClearBefore(ctx, time.Now().Add(-time.Minute)); err != nil {
+		t.Fatalf("ClearBefore() error = %v", err)
+	}
+	if n, err := store.PurgeExpired(ctx, time.Now()); n != 1 || err != nil {
+		t.Errorf("PurgeExpired() = %d, %v, want 1", n, err)
+	}
+	err := store.Rewrite(ctx, func(msgs []Message) ([]Message, error) {
+		return msgs[1:], nil
+	})
+	if err != nil {
+		t.Fatalf("Rewrite() error = %v", err)
+	}
+	if orders, _ := store.LoadByTopic(ctx, "orders"); len(orders) != 1 || orders[0].Payload() != "kept" {
+		t.Errorf("unexpected messages %v", orders)
+	}
+	if n := fileLines(t, path); n != 1 {
+		t.Errorf("expected the file rewritten, got %d lines", n)
+	}
+
+	if err := store.Clear(ctx); err != nil {
+		t.Fatalf("Clear() error = %v", err)
+	}
+	_ = store.Store(ctx, NewMessage("orders", "after clear"))
+	if n, _ := store.Count(ctx); n != 1 {
+		t.Errorf("Count() = %d, want 1", n)
+	}
+}
+
+func TestJSONLStore_Compression(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "messages.jsonl")
+	store := openJSONL(t, path, WithJSONLCompression(NewGzipCompressor(gzip.DefaultCompression), 64))
+	ctx := context.Background()
+
+	large := string(bytes.Repeat([]byte("x"), 1024))
+	_ = store.Store(ctx, NewMessage("orders", large))
+	_ = store.Store(ctx, NewMessage("orders", "small"))
+	if stats := store.CompressionStats(); stats.CompressedRecords != 1 {
+		t.Errorf("unexpected compression stats %+v", stats)
+	}
+	_ = store.Close()
+
+	msgs, _ := openJSONL(t, path, WithJSONLCompression(NewGzipCompressor(gzip.DefaultCompression), 64)).Load(ctx)
+	if len(msgs) != 2 || msgs[0].Payload() != large || msgs[1].Payload() != "small" {
+		t.Error("expected the payloads restored")
+	}
+}
+
+func TestJSONLStore_Closed(t *testing.T) {
+	store := openJSONL(t, filepath.Join(t.TempDir(), "messages.jsonl"))
+	_ = store.Close()
+
+	if err := store.Store(context.Background(), NewMessage("orders", 1)); err == nil {
+		t.Error("expected storing to a closed store to fail")
+	}
+	if err := store.Close(); err != nil {
+		t.Errorf("expected closing twice to succeed, got %v", err)
+	}
+}
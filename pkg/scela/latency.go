@@ -0,0 +1,156 @@
+package scela
+
+import (
+	"math"
+	"math/bits"
+	"sync"
+	"time"
+)
+
+// Histogram layout: values below latencySubBuckets nanoseconds get a bucket
+// each, larger values are split into powers of two with latencySubBuckets
+// linear sub-buckets each, bounding the relative error to about 6%. Since
+// durations are non-negative int64 values, 63 bits cover every input.
+const (
+	latencySubBits    = 4
+	latencySubBuckets = 1 << latencySubBits
+	latencyBuckets    = (63 - latencySubBits + 1) * latencySubBuckets
+)
+
+// LatencyHistogram records durations in a fixed amount of memory (about
+// 8KB) and reports percentiles with a relative error of about 6%. It is safe
+// for concurrent use.
+type LatencyHistogram struct {
+	mu     sync.Mutex
+	counts [latencyBuckets]uint64
+	count  uint64
+	sum    time.Duration
+	min    time.Duration
+	max    time.Duration
+}
+
+// NewLatencyHistogram creates an empty histogram.
+func NewLatencyHistogram() *LatencyHistogram {
+	return &LatencyHistogram{}
+}
+
+// Record adds a duration to the histogram. Negative durations count as zero.
+func (h *LatencyHistogram) Record(d time.Duration) {
+	if d < 0 {
+		d = 0
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.counts[latencyBucket(uint64(d))]++
+	if h.count == 0 || d < h.min {
+		h.min = d
+	}
+	if d > h.max {
+		h.max = d
+	}
+	h.count++
+	h.sum += d
+}
+
+// Percentile returns the duration below which the fraction q (0 to 1) of
+// the recorded durations fall, or zero if nothing was recorded.
+func (h *LatencyHistogram) Percentile(q float64) time.Duration {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.percentile(q)
+}
+
+// Snapshot returns a summary of the recorded durations.
+func (h *LatencyHistogram) Snapshot() LatencySnapshot {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.count == 0 {
+		return LatencySnapshot{}
+	}
+	return LatencySnapshot{
+		Count: h.count,
+		Min:   h.min,
+		Max:   h.max,
+		Mean:  h.sum / time.Duration(h.count),
+		P50:   h.percentile(0.50),
+		P90:   h.percentile(0.90),
+		P99:   h.percentile(0.99),
+		P999:  h.percentile(0.999),
+	}
+}
+
+// Reset discards all recorded durations.
+func (h *LatencyHistogram) Reset() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.counts = [latencyBuckets]uint64{}
+	h.count, h.sum, h.min, h.max = 0, 0, 0, 0
+}
+
+// percentile computes a percentile. Must be called with the lock held.
+func (h *LatencyHistogram) percentile(q float64) time.Duration {
+	if h.count == 0 {
+		return 0
+	}
+	if q <= 0 {
+		return h.min
+	}
+	if q >= 1 {
+		return h.max
+	}
+
+	rank := uint64(math.Ceil(q * float64(h.count)))
+	var seen uint64
+	for i, c := range h.counts {
+		seen += c
+		if seen >= rank {
+			// Report the middle of the bucket, within the observed range
+			d := time.Duration(latencyBucketMiddle(i))
+			if d < h.min {
+				d = h.min
+			}
+			if d > h.max {
+				d = h.max
+			}
+			return d
+		}
+	}
+	return h.max
+}
+
+// latencyBucket returns the bucket index for a value in nanoseconds.
+func latencyBucket(v uint64) int {
+	if v < latencySubBuckets {
+		return int(v)
+	}
+	exp := bits.Len64(v) - latencySubBits - 1
+	mantissa := (v >> uint(exp)) & (latencySubBuckets - 1)
+	return (exp+1)*latencySubBuckets + int(mantissa)
+}
+
+// latencyBucketMiddle returns the value in the middle of a bucket.
+func latencyBucketMiddle(i int) uint64 {
+	if i < latencySubBuckets {
+		return uint64(i)
+	}
+	exp := uint(i/latencySubBuckets - 1)
+	mantissa := uint64(i % latencySubBuckets)
+	lower := (latencySubBuckets + mantissa) << exp
+	return lower + (uint64(1)<<exp)/2
+}
+
+// LatencySnapshot summarizes a LatencyHistogram.
+type LatencySnapshot struct {
+	Count uint64
+	Min   time.Duration
+	Max   time.Duration
+	Mean  time.Duration
+	P50   time.Duration
+	P90   time.Duration
+	P99   time.Duration
+	P999  time.Duration
+}
@@ -0,0 +1,62 @@
+package scela
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestLatencyHistogram_Percentiles(t *testing.T) {
+	h := NewLatencyHistogram()
+	for i := 1; i <= 1000; i++ {
+		h.Record(time.Duration(i) * time.Millisecond)
+	}
+
+	snap := h.Snapshot()
+	if snap.Count != 1000 || snap.Min != time.Millisecond || snap.Max != time.Second {
+		t.Fatalf("unexpected snapshot %+v", snap)
+	}
+
+	within := func(name string, got, want time.Duration) {
+		t.Helper()
+		if diff := math.Abs(float64(got-want)) / float64(want); diff > 0.07 {
+			t.Errorf("%s = %v, want %v within 7%%", name, got, want)
+		}
+	}
+	within("P50", snap.P50, 500*time.Millisecond)
+	within("P90", snap.P90, 900*time.Millisecond)
+	within("P99", snap.P99, 990*time.Millisecond)
+	within("Mean", snap.Mean, 500*time.Millisecond)
+}
+
+func TestLatencyHistogram_Edges(t *testing.T) {
+	h := NewLatencyHistogram()
+	if h.Percentile(0.5) != 0 || h.Snapshot() != (LatencySnapshot{}) {
+		t.Error("expected an empty histogram to report zeros")
+	}
+
+	h.Record(-time.Second)
+	h.Record(time.Duration(math.MaxInt64))
+	if h.Percentile(0) != 0 || h.Percentile(1) != time.Duration(math.MaxInt64) {
+		t.Errorf("unexpected extremes %v, %v", h.Percentile(0), h.Percentile(1))
+	}
+
+	h.Reset()
+	if h.Snapshot().Count != 0 {
+		t.Error("expected Reset to discard samples")
+	}
+}
+
+func TestLatencyBucket_Monotonic(t *testing.T) {
+	prev := -1
+	for v := uint64(0); v < 1<<20; v += 7 {
+		b := latencyBucket(v)
+		if b < prev {
+			t.Fatalf("bucket of %d is %d, below previous %d", v, b, prev)
+		}
+		prev = b
+	}
+	if b := latencyBucket(math.MaxInt64); b >= latencyBuckets {
+		t.Fatalf("bucket %d out of range", b)
+	}
+}
@@ -0,0 +1,190 @@
+package scela
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// ErrHandlerClosed is returned by a LazyHandler for the messages it gets
+// once closed.
+var ErrHandlerClosed = errors.New("handler is closed")
+
+// HandlerFactory builds a handler, see Lazy.
+type HandlerFactory func(ctx context.Context) (Handler, error)
+
+// LazyOption configures a LazyHandler.
+type LazyOption func(*LazyHandler)
+
+// WithIdleTimeout tears the handler down once it has handled no message for
+// d, closing it if it implements io.Closer, to free what it holds until the
+// next message builds it again. By default the handler is kept until Close.
+func WithIdleTimeout(d time.Duration) LazyOption {
+	return func(l *LazyHandler) {
+		if d > 0 {
+			l.idleTimeout = d
+		}
+	}
+}
+
+// LazyHandler is a Handler building its handler on the first message it
+// receives, so that expensive handlers, such as those loading a model or
+// filling a large cache, cost nothing on topics that never get traffic.
+// It is safe for concurrent use: the handler is built once, and messages
+// arriving meanwhile wait for it.
+type LazyHandler struct {
+	factory     HandlerFactory
+	idleTimeout time.Duration
+
+	mu       sync.Mutex
+	handler  Handler
+	building chan struct{}
+	inFlight int
+	lastUsed time.Time
+	timer    *time.Timer
+	closed   bool
+}
+
+// Lazy returns a handler built by factory on its first message. If factory
+// fails, the message fails with its error, and the next message calls it
+// again.
+func Lazy(factory HandlerFactory, opts ...LazyOption) *LazyHandler {
+	l := &LazyHandler{factory: factory}
+	for _, opt := range opts {
+		opt(l)
+	}
+	return l
+}
+
+// Handle implements Handler.
+func (l *LazyHandler) Handle(ctx context.Context, msg Message) error {
+	handler, err := l.acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer l.release()
+	return handler.Handle(ctx, msg)
+}
+
+// Bound reports whether the handler is built.
+func (l *LazyHandler) Bound() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.handler != nil
+}
+
+// Close tears the handler down, closing it if it implements io.Closer. Later
+// messages fail with ErrHandlerClosed. It does not wait for the messages
+// being handled, and unsubscribing does not close the handler: unsubscribe
+// with UnsubscribeAndWait first.
+func (l *LazyHandler) Close() error {
+	l.mu.Lock()
+	l.closed = true
+	if l.timer != nil {
+		l.timer.Stop()
+	}
+	handler := l.handler
+	l.handler = nil
+	l.mu.Unlock()
+
+	return closeHandler(handler)
+}
+
+// acquire returns the handler, building it if needed, and counts the
+// caller as in flight.
+func (l *LazyHandler) acquire(ctx context.Context) (Handler, error) {
+	l.mu.Lock()
+	for {
+		if l.closed {
+			l.mu.Unlock()
+			return nil, ErrHandlerClosed
+		}
+		if l.handler != nil {
+			l.inFlight++
+			handler := l.handler
+			l.mu.Unlock()
+			return handler, nil
+		}
+		if l.building == nil {
+			break
+		}
+
+		// Another message is building the handler
+		building := l.building
+		l.mu.Unlock()
+		select {
+		case <-building:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		l.mu.Lock()
+	}
+
+	building := make(chan struct{})
+	l.building = building
+	l.mu.Unlock()
+
+	handler, err := l.factory(ctx)
+	if err == nil && handler == nil {
+		err = ErrNilHandler
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.building = nil
+	close(building)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build handler: %w", err)
+	}
+	if l.closed {
+		_ = closeHandler(handler)
+		return nil, ErrHandlerClosed
+	}
+	l.handler = handler
+	l.inFlight++
+	return handler, nil
+}
+
+// release counts the end of a message and arms the idle timer once the
+// handler is no longer in use.
+func (l *LazyHandler) release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.inFlight--
+	l.lastUsed = time.Now()
+	if l.idleTimeout == 0 || l.inFlight > 0 || l.closed {
+		return
+	}
+	if l.timer == nil {
+		l.timer = time.AfterFunc(l.idleTimeout, l.teardownIfIdle)
+	} else {
+		l.timer.Reset(l.idleTimeout)
+	}
+}
+
+// teardownIfIdle tears the handler down if it has been idle for the idle
+// timeout.
+func (l *LazyHandler) teardownIfIdle() {
+	l.mu.Lock()
+	if l.handler == nil || l.inFlight > 0 || time.Since(l.lastUsed) < l.idleTimeout {
+		l.mu.Unlock()
+		return
+	}
+	handler := l.handler
+	l.handler = nil
+	l.mu.Unlock()
+
+	_ = closeHandler(handler)
+}
+
+// closeHandler closes handler if it implements io.Closer.
+func closeHandler(handler Handler) error {
+	if c, ok := handler.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package scela
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// closingHandler counts the messages it handles and whether it was closed.
+type closingHandler struct {
+	handled atomic.Int32
+	closed  atomic.Bool
+}
+
+func (h *closingHandler) Handle(ctx context.Context, msg Message) error {
+	h.handled.Add(1)
+	return nil
+}
+
+func (h *closingHandler) Close() error {
+	h.closed.Store(true)
+	return nil
+}
+
+func TestLazy_BuildsOnFirstMessage(t *testing.T) {
+	var builds atomic.Int32
+	inner := &closingHandler{}
+	lazy := Lazy(func(ctx context.Context) (Handler, error) {
+		builds.Add(1)
+		time.Sleep(10 * time.Millisecond)
+		return inner, nil
+	})
+	if lazy.Bound() {
+		t.Fatal("expected the handler not to be built before a message")
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_ = lazy.Handle(context.Background(), NewMessage("model.score", i))
+		}()
+	}
+	wg.Wait()
+
+	if builds.Load() != 1 || inner.handled.Load() != 10 {
+		t.Errorf("expected 1 build and 10 messages, got %d builds and %d messages", builds.Load(), inner.handled.Load())
+	}
+
+	if err := lazy.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if !inner.closed.Load() {
+		t.Error("expected the handler to be closed")
+	}
+	if err := lazy.Handle(context.Background(), NewMessage("model.score", 0)); !errors.Is(err, ErrHandlerClosed) {
+		t.Errorf("expected ErrHandlerClosed after Close, got %v", err)
+	}
+}
+
+func TestLazy_FactoryFailureIsRetried(t *testing.T) {
+	loadErr := errors.New("model not found")
+	var calls atomic.Int32
+	lazy := Lazy(func(ctx context.Context) (Handler, error) {
+		if calls.Add(1) == 1 {
+			return nil, loadErr
+		}
+		return &closingHandler{}, nil
+	})
+
+	if err := lazy.Handle(context.Background(), NewMessage("model.score", 1)); !errors.Is(err, loadErr) {
+		t.Fatalf("expected the factory error, got %v", err)
+	}
+	if err := lazy.Handle(context.Background(), NewMessage("model.score", 2)); err != nil {
+		t.Fatalf("expected the second message to build the handler, got %v", err)
+	}
+}
+
+func TestLazy_IdleTeardown(t *testing.T) {
+	var built []*closingHandler
+	var mu sync.Mutex
+	lazy := Lazy(func(ctx context.Context) (Handler, error) {
+		h := &closingHandler{}
+		mu.Lock()
+		built = append(built, h)
+		mu.Unlock()
+		return h, nil
+	}, WithIdleTimeout(20*time.Millisecond))
+	defer lazy.Close()
+
+	bus := New()
+	defer bus.Close()
+	_, _ = bus.Subscribe("model.score", lazy)
+
+	_ = bus.PublishSync(context.Background(), "model.score", 1)
+	if !lazy.Bound() {
+		t.Fatal("expected the handler to be built")
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for lazy.Bound() {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the idle handler to be torn down")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	_ = bus.PublishSync(context.Background(), "model.score", 2)
+	mu.Lock()
+	defer mu.Unlock()
+	if len(built) != 2 || !built[0].closed.Load() || built[1].handled.Load() != 1 {
+		t.Errorf("expected the handler to be closed and built again, got %d builds", len(built))
+	}
+}
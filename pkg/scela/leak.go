@@ -0,0 +1,143 @@
+package scela
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// SubscriptionInfo describes a registered subscription.
+type SubscriptionInfo struct {
+	ID      string
+	Pattern string
+	// Owner is the caller identity that made the subscription, see As.
+	Owner string
+	// QueueGroup is the queue group the subscription belongs to, if any.
+	QueueGroup string
+	// CreatedAt is when the subscription was registered.
+	CreatedAt time.Time
+	// Deliveries is the number of messages delivered to the handler.
+	Deliveries int64
+	// LastDelivery is the time of the most recent delivery, zero if none.
+	LastDelivery time.Time
+	// Stack is the call stack that created the subscription. It is only
+	// recorded when the bus was created with WithSubscriptionStacks.
+	Stack string
+}
+
+// String returns a one-line description of the subscription.
+func (si SubscriptionInfo) String() string {
+	last := "never"
+	if !si.LastDelivery.IsZero() {
+		last = si.LastDelivery.Format(time.RFC3339)
+	}
+	return fmt.Sprintf("subscription %s on %q (created %s, %d deliveries, last delivery %s)",
+		si.ID, si.Pattern, si.CreatedAt.Format(time.RFC3339), si.Deliveries, last)
+}
+
+// SubscriptionInspector is implemented by buses that can list their
+// subscriptions.
+type SubscriptionInspector interface {
+	// Subscriptions returns a snapshot of all subscriptions, oldest first.
+	Subscriptions() []SubscriptionInfo
+}
+
+// InspectSubscriptions returns the subscriptions of b, looking through the
+// wrappers provided by this package. It reports false if b cannot be
+// inspected.
+func InspectSubscriptions(b Bus) ([]SubscriptionInfo, bool) {
+	for b != nil {
+		if si, ok := b.(SubscriptionInspector); ok {
+			return si.Subscriptions(), true
+		}
+		b = innerBus(b)
+	}
+	return nil, false
+}
+
+// innerBus returns the bus wrapped by one of the wrappers provided by this
+// package, or nil.
+func innerBus(b Bus) Bus {
+	switch v := b.(type) {
+	case *AuditableBus:
+		return v.Bus
+	case *PersistentBus:
+		return v.Bus
+	case *ReadOnlyBus:
+		return v.bus
+	case *IdentityBus:
+		return v.bus
+	case *ShadowBus:
+		return v.Bus
+	case *QuotaBus:
+		return v.Bus
+	case *moduleBus:
+		return v.Bus
+	case *StandbyBus:
+		return v.Active()
+	default:
+		return nil
+	}
+}
+
+// LeakReport lists subscriptions that look abandoned.
+type LeakReport struct {
+	// NeverDelivered holds subscriptions older than the idle threshold that
+	// have not received a single message.
+	NeverDelivered []SubscriptionInfo
+	// Idle holds subscriptions that received messages in the past but none
+	// within the idle threshold, suggesting their owner is gone.
+	Idle []SubscriptionInfo
+}
+
+// Empty reports whether the report found no suspect subscriptions.
+func (r LeakReport) Empty() bool {
+	return len(r.NeverDelivered) == 0 && len(r.Idle) == 0
+}
+
+// FindLeaks inspects the subscriptions of b and reports those that have been
+// silent for longer than idle. Subscriptions younger than idle are ignored.
+func FindLeaks(b Bus, idle time.Duration) (LeakReport, error) {
+	subs, ok := InspectSubscriptions(b)
+	if !ok {
+		return LeakReport{}, fmt.Errorf("bus does not support subscription inspection")
+	}
+
+	var report LeakReport
+	cutoff := time.Now().Add(-idle)
+	for _, sub := range subs {
+		if sub.CreatedAt.After(cutoff) {
+			continue
+		}
+		switch {
+		case sub.LastDelivery.IsZero():
+			report.NeverDelivered = append(report.NeverDelivered, sub)
+		case sub.LastDelivery.Before(cutoff):
+			report.Idle = append(report.Idle, sub)
+		}
+	}
+	return report, nil
+}
+
+// captureStack returns the stack of the code that called into this package,
+// one "function\n\tfile:line" entry per frame.
+func captureStack() string {
+	pcs := make([]uintptr, 32)
+	n := runtime.Callers(2, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+
+	var sb strings.Builder
+	for {
+		frame, more := frames.Next()
+		internal := strings.Contains(frame.Function, "toutago-scela-bus/pkg/scela.") &&
+			!strings.HasSuffix(frame.File, "_test.go")
+		if !internal {
+			fmt.Fprintf(&sb, "%s\n\t%s:%d\n", frame.Function, frame.File, frame.Line)
+		}
+		if !more {
+			break
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,96 @@
+package scela
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBus_Subscriptions(t *testing.T) {
+	bus := New(WithSubscriptionStacks(true))
+	defer bus.Close()
+
+	handler := HandlerFunc(func(ctx context.Context, msg Message) error { return nil })
+	_, _ = bus.Subscribe("orders.created", handler)
+	_, _ = bus.Subscribe("users.*", handler)
+
+	if err := bus.PublishSync(context.Background(), "orders.created", "order"); err != nil {
+		t.Fatalf("PublishSync() error = %v", err)
+	}
+
+	subs, ok := InspectSubscriptions(bus)
+	if !ok {
+		t.Fatal("Expected bus to support subscription inspection")
+	}
+	if len(subs) != 2 {
+		t.Fatalf("Expected 2 subscriptions, got %d", len(subs))
+	}
+
+	orders := subs[0]
+	if orders.Pattern != "orders.created" || orders.Deliveries != 1 || orders.LastDelivery.IsZero() {
+		t.Errorf("Unexpected orders subscription info: %+v", orders)
+	}
+	if subs[1].Deliveries != 0 || !subs[1].LastDelivery.IsZero() {
+		t.Errorf("Expected users subscription without deliveries, got %+v", subs[1])
+	}
+	if !strings.Contains(orders.Stack, "leak_test.go") {
+		t.Errorf("Expected creation stack to reference the test, got %q", orders.Stack)
+	}
+}
+
+func TestBus_SubscriptionsWithoutStacks(t *testing.T) {
+	bus := New()
+	defer bus.Close()
+
+	_, _ = bus.Subscribe("test", HandlerFunc(func(ctx context.Context, msg Message) error { return nil }))
+
+	subs, _ := InspectSubscriptions(NewReadOnlyBus(NewAuditableBus(bus, NewMessageHistory(10))))
+	if len(subs) != 1 || subs[0].Stack != "" {
+		t.Errorf("Expected 1 subscription without stack through wrappers, got %+v", subs)
+	}
+}
+
+func TestFindLeaks(t *testing.T) {
+	bus := New()
+	defer bus.Close()
+
+	handler := HandlerFunc(func(ctx context.Context, msg Message) error { return nil })
+	_, _ = bus.Subscribe("active", handler)
+	_, _ = bus.Subscribe("silent", handler)
+	_, _ = bus.Subscribe("stale", handler)
+
+	_ = bus.PublishSync(context.Background(), "stale", "once")
+	time.Sleep(30 * time.Millisecond)
+	_ = bus.PublishSync(context.Background(), "active", "recent")
+
+	report, err := FindLeaks(bus, 20*time.Millisecond)
+	if err != nil {
+		t.Fatalf("FindLeaks() error = %v", err)
+	}
+	if len(report.NeverDelivered) != 1 || report.NeverDelivered[0].Pattern != "silent" {
+		t.Errorf("Expected silent subscription never delivered, got %+v", report.NeverDelivered)
+	}
+	if len(report.Idle) != 1 || report.Idle[0].Pattern != "stale" {
+		t.Errorf("Expected stale subscription idle, got %+v", report.Idle)
+	}
+
+	// Young subscriptions are not reported
+	report, _ = FindLeaks(bus, time.Hour)
+	if !report.Empty() {
+		t.Errorf("Expected empty report, got %+v", report)
+	}
+}
+
+type opaqueBus struct {
+	Bus
+}
+
+func TestFindLeaks_Unsupported(t *testing.T) {
+	bus := New()
+	defer bus.Close()
+
+	if _, err := FindLeaks(opaqueBus{bus}, time.Second); err == nil {
+		t.Error("Expected error for bus without subscription inspection")
+	}
+}
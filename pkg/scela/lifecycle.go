@@ -0,0 +1,157 @@
+package scela
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// StoreFlusher is implemented by stores that buffer writes, or leave them
+// to the operating system, and can make them durable on demand.
+type StoreFlusher interface {
+	// Flush writes the pending writes, waiting at most until ctx is done.
+	Flush(ctx context.Context) error
+}
+
+// StoreCloser is implemented by stores whose Close may wait for pending
+// writes, to bound that wait.
+type StoreCloser interface {
+	// CloseContext closes the store like Close, giving up on the writes
+	// still pending when ctx is done. They are reported in the error.
+	CloseContext(ctx context.Context) error
+}
+
+// FlushStore flushes store if it implements StoreFlusher, and does nothing
+// otherwise.
+func FlushStore(ctx context.Context, store MessageStore) error {
+	if f, ok := store.(StoreFlusher); ok {
+		return f.Flush(ctx)
+	}
+	return nil
+}
+
+// CloseStore closes store with CloseContext if it implements StoreCloser.
+// Otherwise it flushes the store, see FlushStore, and closes it; the store
+// is closed even if the flush fails.
+func CloseStore(ctx context.Context, store MessageStore) error {
+	if c, ok := store.(StoreCloser); ok {
+		return c.CloseContext(ctx)
+	}
+	flushErr := FlushStore(ctx, store)
+	return errors.Join(flushErr, store.Close())
+}
+
+// StoreManager owns the stores of an application and closes them in
+// dependency order: a store is closed after every store depending on it,
+// such as a custom store writing through to another it does not own, so
+// that writes still pending in one store find the stores below it open.
+// The wrappers of this package, such as EncryptedStore and TeeStore,
+// close the stores they wrap: add the wrapper only.
+//
+// Pass it to NewPersistentBus with WithStoreManager to close the stores
+// when the bus closes.
+type StoreManager struct {
+	mu     sync.Mutex
+	stores []*managedStore
+	names  map[string]*managedStore
+	closed bool
+}
+
+// managedStore is a store owned by a StoreManager.
+type managedStore struct {
+	name  string
+	store MessageStore
+}
+
+// NewStoreManager creates a manager without stores.
+func NewStoreManager() *StoreManager {
+	return &StoreManager{names: make(map[string]*managedStore)}
+}
+
+// Add adds a store under a unique name, depending on the stores named by
+// dependsOn. Dependencies must be added first, which rules out cycles.
+func (m *StoreManager) Add(name string, store MessageStore, dependsOn ...string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return ErrStoreClosed
+	}
+	if _, exists := m.names[name]; exists {
+		return fmt.Errorf("store %q already managed", name)
+	}
+	for _, dep := range dependsOn {
+		if _, ok := m.names[dep]; !ok {
+			return fmt.Errorf("store %q depends on unknown store %q", name, dep)
+		}
+	}
+
+	ms := &managedStore{name: name, store: store}
+	m.stores = append(m.stores, ms)
+	m.names[name] = ms
+	return nil
+}
+
+// Get returns the store added under name.
+func (m *StoreManager) Get(name string) (MessageStore, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ms, ok := m.names[name]
+	if !ok {
+		return nil, false
+	}
+	return ms.store, true
+}
+
+// order returns the stores in closing order: dependents before their
+// dependencies. Must be called with the lock held.
+func (m *StoreManager) order() []*managedStore {
+	// Dependencies are added first, so the reverse order closes dependents
+	// first
+	ordered := make([]*managedStore, len(m.stores))
+	for i, ms := range m.stores {
+		ordered[len(m.stores)-1-i] = ms
+	}
+	return ordered
+}
+
+// Flush flushes every store, see FlushStore, dependents first, so that
+// what they flush into their dependencies is flushed too. It returns the
+// errors of all stores.
+func (m *StoreManager) Flush(ctx context.Context) error {
+	m.mu.Lock()
+	ordered := m.order()
+	m.mu.Unlock()
+
+	var errs []error
+	for _, ms := range ordered {
+		if err := FlushStore(ctx, ms.store); err != nil {
+			errs = append(errs, fmt.Errorf("%s store: %w", ms.name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Close closes every store, see CloseStore, dependents first. A store that
+// fails to close does not keep the others open; Close returns the errors
+// of all stores. Closing a closed manager returns ErrStoreClosed.
+func (m *StoreManager) Close(ctx context.Context) error {
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return ErrStoreClosed
+	}
+	m.closed = true
+	ordered := m.order()
+	m.mu.Unlock()
+
+	var errs []error
+	for _, ms := range ordered {
+		if err := CloseStore(ctx, ms.store); err != nil {
+			errs = append(errs, fmt.Errorf("%s store: %w", ms.name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
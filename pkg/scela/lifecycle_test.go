@@ -0,0 +1,143 @@
+package scela
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// lifecycleStore records when it is flushed and closed.
+type lifecycleStore struct {
+	*InMemoryStore
+	name     string
+	events   *[]string
+	mu       *sync.Mutex
+	closeErr error
+}
+
+func (s *lifecycleStore) record(event string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	*s.events = append(*s.events, event+" "+s.name)
+}
+
+func (s *lifecycleStore) Flush(ctx context.Context) error {
+	s.record("flush")
+	return nil
+}
+
+func (s *lifecycleStore) Close() error {
+	s.record("close")
+	return s.closeErr
+}
+
+func TestStoreManager_ClosesInDependencyOrder(t *testing.T) {
+	var events []string
+	var mu sync.Mutex
+	newStore := func(name string) *lifecycleStore {
+		return &lifecycleStore{InMemoryStore: NewInMemoryStore(0), name: name, events: &events, mu: &mu}
+	}
+
+	m := NewStoreManager()
+	if err := m.Add("db", newStore("db")); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	failing := newStore("cache")
+	failing.closeErr = errors.New("cache down")
+	if err := m.Add("cache", failing, "db"); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	if err := m.Add("audit", newStore("audit"), "db", "cache"); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	if err := m.Add("orphan", newStore("orphan"), "missing"); err == nil {
+		t.Error("expected an unknown dependency rejected")
+	}
+	if err := m.Add("db", newStore("db")); err == nil {
+		t.Error("expected a duplicate name rejected")
+	}
+	if store, ok := m.Get("cache"); !ok || store != failing {
+		t.Errorf("Get() = %v, %v", store, ok)
+	}
+
+	err := m.Close(context.Background())
+	if err == nil || !errors.Is(err, failing.closeErr) {
+		t.Errorf("expected the cache error reported, got %v", err)
+	}
+	want := []string{"flush audit", "close audit", "flush cache", "close cache", "flush db", "close db"}
+	if len(events) != len(want) {
+		t.Fatalf("events = %v, want %v", events, want)
+	}
+	for i := range want {
+		if events[i] != want[i] {
+			t.Fatalf("events = %v, want %v", events, want)
+		}
+	}
+
+	if err := m.Close(context.Background()); !errors.Is(err, ErrStoreClosed) {
+		t.Errorf("expected ErrStoreClosed closing twice, got %v", err)
+	}
+}
+
+func TestCloseStore_FlushesWriteBehind(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	store, err := NewSQLStore(SQLStoreConfig{DB: db, WriteBehindInterval: time.Hour})
+	if err != nil {
+		t.Fatalf("NewSQLStore() error = %v", err)
+	}
+	ctx := context.Background()
+	if err := store.Store(ctx, NewMessage("topic", 1)); err != nil {
+		t.Fatalf("Store() error = %v", err)
+	}
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := CloseStore(canceled, store); err == nil {
+		t.Fatal("expected the pending write reported when the context is done")
+	}
+	if stats := store.WriteBehindStats(); stats.Buffered != 1 {
+		t.Errorf("expected the write still pending, got %+v", stats)
+	}
+}
+
+func TestPersistentBus_CloseContextFlushesStores(t *testing.T) {
+	dir := t.TempDir()
+	jsonl, err := NewJSONLStore(filepath.Join(dir, "messages.jsonl"))
+	if err != nil {
+		t.Fatalf("NewJSONLStore() error = %v", err)
+	}
+	wal, err := NewWALStore(filepath.Join(dir, "wal"))
+	if err != nil {
+		t.Fatalf("NewWALStore() error = %v", err)
+	}
+
+	m := NewStoreManager()
+	_ = m.Add("wal", wal)
+	_ = m.Add("jsonl", jsonl)
+	pb := NewPersistentBus(New(), jsonl, WithStoreManager(m))
+
+	ctx := context.Background()
+	if err := pb.Publish(ctx, "topic", 1); err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+	if err := pb.CloseContext(ctx); err != nil {
+		t.Fatalf("CloseContext() error = %v", err)
+	}
+
+	if err := jsonl.Store(ctx, NewMessage("topic", 2)); !errors.Is(err, ErrStoreClosed) {
+		t.Errorf("expected the bus store closed, got %v", err)
+	}
+	if err := wal.Store(ctx, NewMessage("topic", 2)); !errors.Is(err, ErrStoreClosed) {
+		t.Errorf("expected the managed store closed, got %v", err)
+	}
+}
@@ -46,6 +46,45 @@ func NewMessageWithPriority(topic string, payload interface{}, priority Priority
 	return msg
 }
 
+// withTopic returns a copy of msg on a different topic. The ID, payload,
+// metadata, timestamp and priority are preserved.
+func withTopic(msg Message, topic string) Message {
+	return &message{
+		id:        msg.ID(),
+		topic:     topic,
+		payload:   msg.Payload(),
+		metadata:  msg.Metadata(),
+		timestamp: msg.Timestamp(),
+		priority:  MessagePriority(msg),
+	}
+}
+
+// withPayload returns a copy of msg carrying a different payload. The ID,
+// topic, metadata, timestamp and priority are preserved.
+func withPayload(msg Message, payload interface{}) Message {
+	return &message{
+		id:        msg.ID(),
+		topic:     msg.Topic(),
+		payload:   payload,
+		metadata:  msg.Metadata(),
+		timestamp: msg.Timestamp(),
+		priority:  MessagePriority(msg),
+	}
+}
+
+// withMetadata returns a copy of msg carrying different metadata. The ID,
+// topic, payload, timestamp and priority are preserved.
+func withMetadata(msg Message, metadata map[string]interface{}) Message {
+	return &message{
+		id:        msg.ID(),
+		topic:     msg.Topic(),
+		payload:   msg.Payload(),
+		metadata:  metadata,
+		timestamp: msg.Timestamp(),
+		priority:  MessagePriority(msg),
+	}
+}
+
 // ID returns the message ID.
 func (m *message) ID() string {
 	return m.id
@@ -75,3 +114,12 @@ func (m *message) Timestamp() time.Time {
 func (m *message) Priority() Priority {
 	return m.priority
 }
+
+// MessagePriority returns the priority a message was published with.
+// Messages that do not carry a priority are reported as PriorityNormal.
+func MessagePriority(msg Message) Priority {
+	if p, ok := msg.(interface{ Priority() Priority }); ok {
+		return p.Priority()
+	}
+	return PriorityNormal
+}
@@ -108,3 +108,13 @@ func BenchmarkGenerateID(b *testing.B) {
 		generateID()
 	}
 }
+
+func TestMessagePriority(t *testing.T) {
+	if got := MessagePriority(NewMessageWithPriority("test", nil, PriorityUrgent)); got != PriorityUrgent {
+		t.Errorf("MessagePriority() = %v, want %v", got, PriorityUrgent)
+	}
+
+	if got := MessagePriority(NewMessage("test", nil)); got != PriorityNormal {
+		t.Errorf("MessagePriority() = %v, want %v", got, PriorityNormal)
+	}
+}
@@ -0,0 +1,105 @@
+package scela
+
+import (
+	"context"
+	"strings"
+)
+
+// MetadataPolicy selects the metadata keys that leave the process through a
+// sink or are written to a store. A key ending in "*" matches every key
+// starting with what precedes it, so "x-internal-*" covers all internal
+// routing hints.
+//
+// An empty Allow keeps every key; Deny is applied after Allow, so a key both
+// allowed and denied is removed.
+type MetadataPolicy struct {
+	Allow []string
+	Deny  []string
+}
+
+// Keep reports whether the policy keeps key.
+func (p MetadataPolicy) Keep(key string) bool {
+	if len(p.Allow) > 0 && !matchMetadataKey(p.Allow, key) {
+		return false
+	}
+	return !matchMetadataKey(p.Deny, key)
+}
+
+// Apply returns a copy of metadata holding the keys the policy keeps.
+// metadata itself is not modified.
+func (p MetadataPolicy) Apply(metadata map[string]interface{}) map[string]interface{} {
+	filtered := make(map[string]interface{}, len(metadata))
+	for key, value := range metadata {
+		if p.Keep(key) {
+			filtered[key] = value
+		}
+	}
+	return filtered
+}
+
+// filter returns msg with the metadata the policy keeps. msg is returned as
+// is when the policy keeps all of its metadata.
+func (p MetadataPolicy) filter(msg Message) Message {
+	for key := range msg.Metadata() {
+		if !p.Keep(key) {
+			return withMetadata(msg, p.Apply(msg.Metadata()))
+		}
+	}
+	return msg
+}
+
+// matchMetadataKey reports whether key matches one of patterns.
+func matchMetadataKey(patterns []string, key string) bool {
+	for _, p := range patterns {
+		if prefix, ok := strings.CutSuffix(p, "*"); ok {
+			if strings.HasPrefix(key, prefix) {
+				return true
+			}
+		} else if p == key {
+			return true
+		}
+	}
+	return false
+}
+
+// FilterMetadata returns a Sink sending messages to sink with the metadata
+// policy keeps, for example to strip trace IDs and internal routing hints
+// before messages leave the process. The messages on the bus keep all of
+// their metadata.
+func FilterMetadata(sink Sink, policy MetadataPolicy) Sink {
+	return &metadataSink{sink: sink, policy: policy}
+}
+
+// metadataSink is the Sink returned by FilterMetadata.
+type metadataSink struct {
+	sink   Sink
+	policy MetadataPolicy
+}
+
+// Send implements Sink.
+func (s *metadataSink) Send(ctx context.Context, msg Message) error {
+	return s.sink.Send(ctx, s.policy.filter(msg))
+}
+
+// Close implements Sink.
+func (s *metadataSink) Close() error {
+	return s.sink.Close()
+}
+
+// WithPersistedMetadata stores messages with the metadata policy keeps,
+// while they are published with all of their metadata. Messages replayed
+// or recovered from the store carry the filtered metadata only. The
+// schedule store (see WithScheduleStore) keeps all metadata.
+func WithPersistedMetadata(policy MetadataPolicy) PersistentBusOption {
+	return func(pb *PersistentBus) {
+		pb.metadataPolicy = &policy
+	}
+}
+
+// persisted returns msg as written to the store, see WithPersistedMetadata.
+func (pb *PersistentBus) persisted(msg Message) Message {
+	if pb.metadataPolicy == nil {
+		return msg
+	}
+	return pb.metadataPolicy.filter(msg)
+}
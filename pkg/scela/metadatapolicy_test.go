@@ -0,0 +1,117 @@
+package scela
+
+import (
+	"context"
+	"testing"
+)
+
+func TestMetadataPolicy(t *testing.T) {
+	metadata := map[string]interface{}{
+		"tenant":          "acme",
+		"traceparent":     "00-abc-def-01",
+		"x-internal-lane": 3,
+		"x-internal-node": "a",
+	}
+
+	tests := []struct {
+		name   string
+		policy MetadataPolicy
+		want   []string
+	}{
+		{"empty", MetadataPolicy{}, []string{"tenant", "traceparent", "x-internal-lane", "x-internal-node"}},
+		{"allow", MetadataPolicy{Allow: []string{"tenant", "x-internal-*"}}, []string{"tenant", "x-internal-lane", "x-internal-node"}},
+		{"deny", MetadataPolicy{Deny: []string{"traceparent", "x-internal-*"}}, []string{"tenant"}},
+		{"deny after allow", MetadataPolicy{Allow: []string{"x-internal-*"}, Deny: []string{"x-internal-node"}}, []string{"x-internal-lane"}},
+	}
+	for _, tt := range tests {
+		got := tt.policy.Apply(metadata)
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: Apply() = %v, want keys %v", tt.name, got, tt.want)
+			continue
+		}
+		for _, key := range tt.want {
+			if _, ok := got[key]; !ok {
+				t.Errorf("%s: expected %s kept, got %v", tt.name, key, got)
+			}
+		}
+	}
+	if len(metadata) != 4 {
+		t.Errorf("expected the metadata unchanged, got %v", metadata)
+	}
+}
+
+func TestFilterMetadata(t *testing.T) {
+	sink := &stubSink{}
+	bus := New()
+	defer bus.Close()
+
+	_, err := ForwardTo(bus, "order.*", FilterMetadata(sink, MetadataPolicy{Deny: []string{"traceparent", MetadataHops}}))
+	if err != nil {
+		t.Fatalf("ForwardTo() error = %v", err)
+	}
+	local := make(chan Message, 1)
+	_, _ = bus.Subscribe("order.*", HandlerFunc(func(ctx context.Context, msg Message) error {
+		local <- msg
+		return nil
+	}))
+
+	msg := NewMessageWithPriority("order.created", "o-1", PriorityHigh)
+	msg.Metadata()["tenant"] = "acme"
+	msg.Metadata()["traceparent"] = "00-abc-def-01"
+	if err := bus.(messagePublisher).publishMessages(context.Background(), []Message{msg}, false); err != nil {
+		t.Fatalf("publishMessages() error = %v", err)
+	}
+	got := <-local
+	_ = bus.Close()
+
+	if len(sink.sent) != 1 {
+		t.Fatalf("expected 1 message sent, got %d", len(sink.sent))
+	}
+	sent := sink.sent[0]
+	if sent.ID() != msg.ID() || sent.Payload() != "o-1" || MessagePriority(sent) != PriorityHigh {
+		t.Errorf("unexpected message sent %s %v %v", sent.ID(), sent.Payload(), MessagePriority(sent))
+	}
+	if md := sent.Metadata(); len(md) != 1 || md["tenant"] != "acme" {
+		t.Errorf("expected only the business metadata sent, got %v", md)
+	}
+	if got.Metadata()["traceparent"] != "00-abc-def-01" {
+		t.Errorf("expected local handlers to keep all metadata, got %v", got.Metadata())
+	}
+}
+
+func TestWithPersistedMetadata(t *testing.T) {
+	store := NewInMemoryStore(10)
+	pb := NewPersistentBus(New(), store, WithPersistedMetadata(MetadataPolicy{Allow: []string{"tenant"}}))
+	defer pb.Close()
+
+	delivered := make(chan Message, 3)
+	_, _ = pb.Subscribe("order.*", HandlerFunc(func(ctx context.Context, msg Message) error {
+		delivered <- msg
+		return nil
+	}))
+
+	msg := NewMessage("order.created", "o-1")
+	msg.Metadata()["tenant"] = "acme"
+	msg.Metadata()["x-route"] = "eu"
+	ctx := context.Background()
+	if err := pb.publishMessages(ctx, []Message{msg}, false); err != nil {
+		t.Fatalf("publishMessages() error = %v", err)
+	}
+	if err := pb.PublishBatch(ctx, []TopicPayload{{Topic: "order.paid", Payload: "o-1"}}); err != nil {
+		t.Fatalf("PublishBatch() error = %v", err)
+	}
+	if got := <-delivered; got.Metadata()["x-route"] != "eu" {
+		t.Errorf("expected the published message to keep all metadata, got %v", got.Metadata())
+	}
+
+	stored, err := store.Load(ctx)
+	if err != nil || len(stored) != 2 {
+		t.Fatalf("Load() = %v, %v", stored, err)
+	}
+	if md := stored[0].Metadata(); stored[0].ID() != msg.ID() || len(md) != 1 || md["tenant"] != "acme" {
+		t.Errorf("expected only the allowed metadata stored, got %s %v", stored[0].ID(), md)
+	}
+	if md := stored[1].Metadata(); len(md) != 0 {
+		t.Errorf("expected the batch stored without metadata, got %v", md)
+	}
+}
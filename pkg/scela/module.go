@@ -0,0 +1,245 @@
+package scela
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// Module is a part of an application built around the bus, such as a
+// bounded context or a plugin, with its own subscriptions and lifecycle.
+type Module interface {
+	// RegisterSubscriptions subscribes the handlers of the module to b.
+	// Subscriptions made through b are removed when the module stops.
+	RegisterSubscriptions(b Bus) error
+
+	// Start starts the module, after every module has registered its
+	// subscriptions.
+	Start(ctx context.Context) error
+
+	// Stop stops the module, after its subscriptions have been removed.
+	Stop(ctx context.Context) error
+}
+
+// errHostOwnsBus is returned when a module closes the bus it was given.
+var errHostOwnsBus = errors.New("modules cannot close the host bus")
+
+// ModuleHost wires modules to a bus and runs them in dependency order: a
+// module starts after the modules it depends on and stops before them.
+// Each module is given a facade over the bus acting as the module name (see
+// As), which groups its subscriptions so that they are removed when the
+// module stops.
+type ModuleHost struct {
+	bus Bus
+
+	mu      sync.Mutex
+	modules []*hostedModule
+	names   map[string]*hostedModule
+	running bool
+	stopped bool
+}
+
+// hostedModule is a module run by a ModuleHost.
+type hostedModule struct {
+	name    string
+	module  Module
+	bus     *moduleBus
+	started bool
+}
+
+// NewModuleHost creates a host without modules for bus.
+func NewModuleHost(bus Bus) *ModuleHost {
+	return &ModuleHost{
+		bus:   bus,
+		names: make(map[string]*hostedModule),
+	}
+}
+
+// Add adds a module under a unique name, depending on the modules named by
+// dependsOn. Dependencies must be added first, which rules out cycles.
+// Modules cannot be added once the host has started.
+func (h *ModuleHost) Add(name string, module Module, dependsOn ...string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.running || h.stopped {
+		return fmt.Errorf("cannot add module %q: %w", name, ErrAlreadyStarted)
+	}
+	if _, exists := h.names[name]; exists {
+		return fmt.Errorf("module %q already added", name)
+	}
+	for _, dep := range dependsOn {
+		if _, ok := h.names[dep]; !ok {
+			return fmt.Errorf("module %q depends on unknown module %q", name, dep)
+		}
+	}
+
+	hm := &hostedModule{
+		name:   name,
+		module: module,
+		bus:    &moduleBus{Bus: As(h.bus, name)},
+	}
+	h.modules = append(h.modules, hm)
+	h.names[name] = hm
+	return nil
+}
+
+// Start registers the subscriptions of every module, then starts the
+// modules in dependency order, so that no module misses a message
+// published by another as it starts. A bus created with WithStartPaused is
+// started between the two steps. If a module fails, the modules already
+// started are stopped, every subscription is removed, and the error is
+// returned.
+func (h *ModuleHost) Start(ctx context.Context) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.stopped {
+		return fmt.Errorf("module host stopped")
+	}
+	if h.running {
+		return ErrAlreadyStarted
+	}
+
+	for _, hm := range h.modules {
+		if err := hm.module.RegisterSubscriptions(hm.bus); err != nil {
+			err = fmt.Errorf("module %q failed to register subscriptions: %w", hm.name, err)
+			return errors.Join(err, h.stop(ctx))
+		}
+	}
+
+	if err := startIfPaused(h.bus); err != nil {
+		return errors.Join(err, h.stop(ctx))
+	}
+
+	for _, hm := range h.modules {
+		if err := hm.module.Start(ctx); err != nil {
+			err = fmt.Errorf("module %q failed to start: %w", hm.name, err)
+			return errors.Join(err, h.stop(ctx))
+		}
+		hm.started = true
+	}
+
+	h.running = true
+	return nil
+}
+
+// startIfPaused starts b if it was created with WithStartPaused and has not
+// been started yet.
+func startIfPaused(b Bus) error {
+	for inner := b; inner != nil; inner = innerBus(inner) {
+		if s, ok := inner.(Starter); ok {
+			if err := s.Start(); err != nil && !errors.Is(err, ErrAlreadyStarted) {
+				return err
+			}
+			return nil
+		}
+	}
+	return nil
+}
+
+// Stop stops the modules in reverse dependency order, removing the
+// subscriptions of each module before stopping it. A module that fails to
+// stop does not keep the others running; Stop returns the errors of all
+// modules. The bus is left open.
+func (h *ModuleHost) Stop(ctx context.Context) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if !h.running {
+		return fmt.Errorf("module host not running")
+	}
+	return h.stop(ctx)
+}
+
+// stop removes the subscriptions of every module and stops the started
+// ones, dependents first. Must be called with the lock held.
+func (h *ModuleHost) stop(ctx context.Context) error {
+	h.running = false
+	h.stopped = true
+
+	var errs []error
+	for i := len(h.modules) - 1; i >= 0; i-- {
+		hm := h.modules[i]
+		if err := hm.bus.unsubscribeAll(); err != nil {
+			errs = append(errs, fmt.Errorf("module %q: %w", hm.name, err))
+		}
+		if !hm.started {
+			continue
+		}
+		hm.started = false
+		if err := hm.module.Stop(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("module %q failed to stop: %w", hm.name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Subscriptions returns the subscriptions registered by the module added
+// under name.
+func (h *ModuleHost) Subscriptions(name string) []Subscription {
+	h.mu.Lock()
+	hm, ok := h.names[name]
+	h.mu.Unlock()
+
+	if !ok {
+		return nil
+	}
+	return hm.bus.subscriptions()
+}
+
+// moduleBus is the bus given to a module. It records the subscriptions of
+// the module and does not let it close the bus.
+type moduleBus struct {
+	Bus
+
+	mu   sync.Mutex
+	subs []Subscription
+}
+
+// Subscribe implements Bus.
+func (mb *moduleBus) Subscribe(pattern string, handler Handler) (Subscription, error) {
+	return mb.SubscribeWithOptions(pattern, handler)
+}
+
+// SubscribeWithOptions implements Bus.
+func (mb *moduleBus) SubscribeWithOptions(pattern string, handler Handler, opts ...SubscriptionOption) (Subscription, error) {
+	sub, err := mb.Bus.SubscribeWithOptions(pattern, handler, opts...)
+	if err != nil {
+		return nil, err
+	}
+	mb.mu.Lock()
+	mb.subs = append(mb.subs, sub)
+	mb.mu.Unlock()
+	return sub, nil
+}
+
+// Close returns an error: the host owns the bus.
+func (mb *moduleBus) Close() error {
+	return errHostOwnsBus
+}
+
+// subscriptions returns the subscriptions of the module.
+func (mb *moduleBus) subscriptions() []Subscription {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	return append([]Subscription(nil), mb.subs...)
+}
+
+// unsubscribeAll removes the subscriptions of the module. Subscriptions the
+// module removed itself are skipped.
+func (mb *moduleBus) unsubscribeAll() error {
+	mb.mu.Lock()
+	subs := mb.subs
+	mb.subs = nil
+	mb.mu.Unlock()
+
+	var errs []error
+	for _, sub := range subs {
+		if err := sub.Unsubscribe(); err != nil && !errors.Is(err, ErrSubscriptionNotFound) {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
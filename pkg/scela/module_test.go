@@ -0,0 +1,149 @@
+package scela
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+)
+
+// recordingModule records its lifecycle and the messages it receives.
+type recordingModule struct {
+	name     string
+	pattern  string
+	events   *[]string
+	mu       *sync.Mutex
+	startErr error
+
+	bus      Bus
+	received []interface{}
+}
+
+func (m *recordingModule) record(event string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	*m.events = append(*m.events, event+" "+m.name)
+}
+
+func (m *recordingModule) RegisterSubscriptions(b Bus) error {
+	m.record("register")
+	m.bus = b
+	_, err := b.Subscribe(m.pattern, HandlerFunc(func(ctx context.Context, msg Message) error {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		m.received = append(m.received, msg.Payload())
+		return nil
+	}))
+	return err
+}
+
+func (m *recordingModule) Start(ctx context.Context) error {
+	m.record("start")
+	if m.startErr != nil {
+		return m.startErr
+	}
+	// Modules may publish as they start
+	return m.bus.Publish(ctx, m.name+".started", m.name)
+}
+
+func (m *recordingModule) Stop(ctx context.Context) error {
+	m.record("stop")
+	return nil
+}
+
+func TestModuleHost(t *testing.T) {
+	b := New(WithStartPaused())
+	defer b.Close()
+
+	var events []string
+	var mu sync.Mutex
+	users := &recordingModule{name: "users", pattern: "billing.started", events: &events, mu: &mu}
+	billing := &recordingModule{name: "billing", pattern: "users.started", events: &events, mu: &mu}
+
+	h := NewModuleHost(b)
+	if err := h.Add("users", users); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	if err := h.Add("billing", billing, "users"); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	if err := h.Add("audit", billing, "missing"); err == nil {
+		t.Error("expected an unknown dependency rejected")
+	}
+
+	ctx := context.Background()
+	if err := h.Start(ctx); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	if err := h.Start(ctx); !errors.Is(err, ErrAlreadyStarted) {
+		t.Errorf("expected ErrAlreadyStarted, got %v", err)
+	}
+	if err := users.bus.Close(); err == nil {
+		t.Error("expected a module not allowed to close the bus")
+	}
+
+	// Both modules hear each other, whichever starts first
+	waitFor(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(users.received) == 1 && len(billing.received) == 1
+	})
+	subs, _ := InspectSubscriptions(b)
+	if len(subs) != 2 || subs[0].Owner != "users" || subs[1].Owner != "billing" {
+		t.Errorf("unexpected subscriptions %v", subs)
+	}
+	if len(h.Subscriptions("billing")) != 1 {
+		t.Errorf("expected 1 billing subscription, got %d", len(h.Subscriptions("billing")))
+	}
+
+	if err := h.Stop(ctx); err != nil {
+		t.Fatalf("Stop() error = %v", err)
+	}
+	want := []string{"register users", "register billing", "start users", "start billing", "stop billing", "stop users"}
+	if len(events) != len(want) {
+		t.Fatalf("events = %v, want %v", events, want)
+	}
+	for i := range want {
+		if events[i] != want[i] {
+			t.Fatalf("events = %v, want %v", events, want)
+		}
+	}
+	if subs, _ := InspectSubscriptions(b); len(subs) != 0 {
+		t.Errorf("expected the module subscriptions removed, got %d", len(subs))
+	}
+	if err := h.Add("late", users); err == nil {
+		t.Error("expected a module added after Start rejected")
+	}
+}
+
+func TestModuleHost_StartFailureStopsStartedModules(t *testing.T) {
+	b := New()
+	defer b.Close()
+
+	var events []string
+	var mu sync.Mutex
+	failure := errors.New("no database")
+	h := NewModuleHost(b)
+	_ = h.Add("users", &recordingModule{name: "users", pattern: "a", events: &events, mu: &mu})
+	_ = h.Add("billing", &recordingModule{name: "billing", pattern: "b", events: &events, mu: &mu, startErr: failure})
+	_ = h.Add("audit", &recordingModule{name: "audit", pattern: "c", events: &events, mu: &mu})
+
+	if err := h.Start(context.Background()); !errors.Is(err, failure) {
+		t.Fatalf("expected the start failure returned, got %v", err)
+	}
+	want := []string{"register users", "register billing", "register audit", "start users", "start billing", "stop users"}
+	if len(events) != len(want) {
+		t.Fatalf("events = %v, want %v", events, want)
+	}
+	for i := range want {
+		if events[i] != want[i] {
+			t.Fatalf("events = %v, want %v", events, want)
+		}
+	}
+	if subs, _ := InspectSubscriptions(b); len(subs) != 0 {
+		t.Errorf("expected every subscription removed, got %d", len(subs))
+	}
+	if err := h.Stop(context.Background()); err == nil {
+		t.Error("expected an error stopping a host that failed to start")
+	}
+}
@@ -3,6 +3,7 @@ package scela
 import (
 	"context"
 	"sync"
+	"time"
 )
 
 // Observer is called when bus events occur.
@@ -14,10 +15,46 @@ type Observer interface {
 	OnClose()
 }
 
+// BatchObserver is an optional extension of Observer. Observers implementing
+// it receive a single notification for a batch publish instead of one
+// OnPublish call per message.
+type BatchObserver interface {
+	OnPublishBatch(ctx context.Context, msgs []Message)
+}
+
+// StoreErrorObserver is an optional extension of Observer. Observers
+// implementing it are notified when a persistence operation performed on
+// behalf of the bus fails, including failures that are not returned to any
+// caller.
+type StoreErrorObserver interface {
+	OnStoreError(ctx context.Context, err *StoreError)
+}
+
+// IdentityObserver is an optional extension of Observer. Observers
+// implementing it are told which caller identity (see As) made a
+// subscription, instead of receiving OnSubscribe and OnUnsubscribe.
+// Subscriptions made without an identity are still reported through
+// OnSubscribe and OnUnsubscribe.
+type IdentityObserver interface {
+	OnSubscribeAs(identity, pattern string)
+	OnUnsubscribeAs(identity, pattern string)
+}
+
+// RetryObserver is an optional extension of Observer. Observers implementing
+// it are notified when a failed message is scheduled for another attempt and
+// when it is given up on and dead-lettered.
+type RetryObserver interface {
+	// OnRetry is called with the number of the attempt that failed.
+	OnRetry(ctx context.Context, msg Message, attempt int, err error)
+	// OnDeadLetter is called when a message exhausted its retries.
+	OnDeadLetter(ctx context.Context, msg Message, err error)
+}
+
 // ObserverFunc is a function adapter for Observer interface.
 type observerRegistry struct {
 	mu        sync.RWMutex
 	observers []Observer
+	events    []EventObserver
 }
 
 func newObserverRegistry() *observerRegistry {
@@ -32,28 +69,70 @@ func (r *observerRegistry) Add(observer Observer) {
 	r.observers = append(r.observers, observer)
 }
 
+// AddEvents adds an event observer.
+func (r *observerRegistry) AddEvents(observer EventObserver) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, observer)
+}
+
+// emit reports event to the event observers. The caller holds r.mu.
+func (r *observerRegistry) emit(ctx context.Context, event BusEvent) {
+	for _, eo := range r.events {
+		eo.OnEvent(ctx, event)
+	}
+}
+
 func (r *observerRegistry) NotifyPublish(ctx context.Context, topic string, msg Message) {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
 	for _, obs := range r.observers {
 		obs.OnPublish(ctx, topic, msg)
 	}
+	r.emit(ctx, PublishEvent{Topic: topic, Message: msg})
 }
 
-func (r *observerRegistry) NotifySubscribe(pattern string) {
+func (r *observerRegistry) NotifyPublishBatch(ctx context.Context, msgs []Message) {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
 	for _, obs := range r.observers {
+		if bo, ok := obs.(BatchObserver); ok {
+			bo.OnPublishBatch(ctx, msgs)
+			continue
+		}
+		for _, msg := range msgs {
+			obs.OnPublish(ctx, msg.Topic(), msg)
+		}
+	}
+	for _, msg := range msgs {
+		r.emit(ctx, PublishEvent{Topic: msg.Topic(), Message: msg, Batch: len(msgs)})
+	}
+}
+
+func (r *observerRegistry) NotifySubscribe(identity, pattern string) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, obs := range r.observers {
+		if io, ok := obs.(IdentityObserver); ok && identity != "" {
+			io.OnSubscribeAs(identity, pattern)
+			continue
+		}
 		obs.OnSubscribe(pattern)
 	}
+	r.emit(context.Background(), LifecycleEvent{Kind: LifecycleSubscribed, Pattern: pattern, Identity: identity})
 }
 
-func (r *observerRegistry) NotifyUnsubscribe(pattern string) {
+func (r *observerRegistry) NotifyUnsubscribe(identity, pattern string) {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
 	for _, obs := range r.observers {
+		if io, ok := obs.(IdentityObserver); ok && identity != "" {
+			io.OnUnsubscribeAs(identity, pattern)
+			continue
+		}
 		obs.OnUnsubscribe(pattern)
 	}
+	r.emit(context.Background(), LifecycleEvent{Kind: LifecycleUnsubscribed, Pattern: pattern, Identity: identity})
 }
 
 func (r *observerRegistry) NotifyMessageProcessed(ctx context.Context, msg Message, err error) {
@@ -62,6 +141,70 @@ func (r *observerRegistry) NotifyMessageProcessed(ctx context.Context, msg Messa
 	for _, obs := range r.observers {
 		obs.OnMessageProcessed(ctx, msg, err)
 	}
+	r.emit(ctx, DeliveryEvent{Message: msg, Err: err})
+}
+
+// NotifyDrop reports a message that will not be delivered, for reason.
+// Observers see it as processed with reason as its error.
+func (r *observerRegistry) NotifyDrop(ctx context.Context, msg Message, reason error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, obs := range r.observers {
+		obs.OnMessageProcessed(ctx, msg, reason)
+	}
+	r.emit(ctx, DropEvent{Message: msg, Reason: reason})
+}
+
+func (r *observerRegistry) NotifyStoreError(ctx context.Context, err *StoreError) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, obs := range r.observers {
+		if so, ok := obs.(StoreErrorObserver); ok {
+			so.OnStoreError(ctx, err)
+		}
+	}
+}
+
+func (r *observerRegistry) NotifyRetry(ctx context.Context, msg Message, attempt int, delay time.Duration, err error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, obs := range r.observers {
+		if ro, ok := obs.(RetryObserver); ok {
+			ro.OnRetry(ctx, msg, attempt, err)
+		}
+	}
+	r.emit(ctx, RetryEvent{Message: msg, Attempt: attempt, Delay: delay, Err: err})
+}
+
+func (r *observerRegistry) NotifyDeadLetter(ctx context.Context, msg Message, attempts int, err error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, obs := range r.observers {
+		if ro, ok := obs.(RetryObserver); ok {
+			ro.OnDeadLetter(ctx, msg, err)
+		}
+	}
+	r.emit(ctx, DLQEvent{Message: msg, Attempts: attempts, Err: err})
+}
+
+func (r *observerRegistry) NotifySequenceGap(ctx context.Context, gap SequenceGap) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, obs := range r.observers {
+		if gobs, ok := obs.(GapObserver); ok {
+			gobs.OnSequenceGap(ctx, gap)
+		}
+	}
+}
+
+func (r *observerRegistry) NotifySLOViolation(ctx context.Context, v SLOViolation) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, obs := range r.observers {
+		if sobs, ok := obs.(SLOObserver); ok {
+			sobs.OnSLOViolation(ctx, v)
+		}
+	}
 }
 
 func (r *observerRegistry) NotifyClose() {
@@ -70,6 +213,7 @@ func (r *observerRegistry) NotifyClose() {
 	for _, obs := range r.observers {
 		obs.OnClose()
 	}
+	r.emit(context.Background(), LifecycleEvent{Kind: LifecycleClosed})
 }
 
 // WithObserver adds an observer to the bus.
@@ -0,0 +1,125 @@
+package scela
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// ErrDependencyCycle is returned by SubscribeWithOptions when the declared
+// ordering dependencies would form a cycle.
+var ErrDependencyCycle = errors.New("subscription dependency cycle")
+
+// WithSubscriptionName names a subscription so other subscriptions can
+// declare ordering dependencies on it. Names are unique per bus.
+func WithSubscriptionName(name string) SubscriptionOption {
+	return func(c *subscriptionConfig) {
+		c.name = name
+	}
+}
+
+// WithRunAfter makes the subscription run after the named subscriptions
+// whenever they receive the same message. Dependencies on subscriptions that
+// do not match a message, or do not exist yet, are ignored for that message.
+func WithRunAfter(names ...string) SubscriptionOption {
+	return func(c *subscriptionConfig) {
+		c.after = append(c.after, names...)
+	}
+}
+
+// checkDependencies validates the name and dependencies of a new
+// subscription. Must be called with the lock held.
+func (sr *subscriptionRegistry) checkDependencies(cfg subscriptionConfig) error {
+	if cfg.name == "" {
+		return nil
+	}
+	if _, exists := sr.names[cfg.name]; exists {
+		return fmt.Errorf("subscription name %q already in use", cfg.name)
+	}
+
+	// Existing subscriptions may already depend on the new name; the new
+	// subscription closes a cycle if one of its dependencies leads back to it.
+	visited := make(map[string]bool)
+	stack := append([]string(nil), cfg.after...)
+	for len(stack) > 0 {
+		name := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		if name == cfg.name {
+			return fmt.Errorf("%w: %q depends on itself", ErrDependencyCycle, cfg.name)
+		}
+		if visited[name] {
+			continue
+		}
+		visited[name] = true
+
+		if id, ok := sr.names[name]; ok {
+			stack = append(stack, sr.subscriptions[id].after...)
+		}
+	}
+	return nil
+}
+
+// orderSubscriptions sorts subscriptions in registration order, then moves
+// subscriptions after the ones they depend on. The result is deterministic.
+func orderSubscriptions(subs []*subscription) []*subscription {
+	sort.Slice(subs, func(i, j int) bool {
+		return subs[i].seq < subs[j].seq
+	})
+
+	byName := make(map[string]int)
+	constrained := false
+	for i, sub := range subs {
+		if sub.name != "" {
+			byName[sub.name] = i
+		}
+		if len(sub.after) > 0 {
+			constrained = true
+		}
+	}
+	if !constrained {
+		return subs
+	}
+
+	// Kahn's algorithm, always picking the earliest registered ready subscription
+	pending := make([]int, len(subs))
+	dependents := make([][]int, len(subs))
+	for i, sub := range subs {
+		for _, name := range sub.after {
+			if j, ok := byName[name]; ok && j != i {
+				pending[i]++
+				dependents[j] = append(dependents[j], i)
+			}
+		}
+	}
+
+	ordered := make([]*subscription, 0, len(subs))
+	done := make([]bool, len(subs))
+	for len(ordered) < len(subs) {
+		next := -1
+		for i := range subs {
+			if !done[i] && pending[i] == 0 {
+				next = i
+				break
+			}
+		}
+		if next < 0 {
+			// Cycles are rejected at subscribe time; keep the remaining
+			// subscriptions in registration order rather than dropping them.
+			for i := range subs {
+				if !done[i] {
+					ordered = append(ordered, subs[i])
+				}
+			}
+			break
+		}
+
+		done[next] = true
+		ordered = append(ordered, subs[next])
+		for _, d := range dependents[next] {
+			pending[d]--
+		}
+	}
+
+	return ordered
+}
@@ -0,0 +1,105 @@
+package scela
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// orderRecorder records the order in which named handlers run.
+type orderRecorder struct {
+	mu    sync.Mutex
+	calls []string
+}
+
+func (r *orderRecorder) handler(name string) Handler {
+	return HandlerFunc(func(ctx context.Context, msg Message) error {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.calls = append(r.calls, name)
+		return nil
+	})
+}
+
+func TestSubscribeWithOptions_DependencyOrder(t *testing.T) {
+	bus := New()
+	defer bus.Close()
+
+	rec := &orderRecorder{}
+
+	// Registered out of order on purpose
+	_, _ = bus.SubscribeWithOptions("orders.created", rec.handler("audit"),
+		WithSubscriptionName("audit"), WithRunAfter("validate", "enrich"))
+	_, _ = bus.SubscribeWithOptions("orders.*", rec.handler("enrich"),
+		WithSubscriptionName("enrich"), WithRunAfter("validate"))
+	_, _ = bus.Subscribe("orders.created", rec.handler("plain"))
+	_, _ = bus.SubscribeWithOptions("orders.created", rec.handler("validate"),
+		WithSubscriptionName("validate"))
+
+	if err := bus.PublishSync(context.Background(), "orders.created", nil); err != nil {
+		t.Fatalf("PublishSync() error = %v", err)
+	}
+
+	want := []string{"plain", "validate", "enrich", "audit"}
+	if !reflect.DeepEqual(rec.calls, want) {
+		t.Errorf("Execution order = %v, want %v", rec.calls, want)
+	}
+}
+
+func TestSubscribeWithOptions_MissingDependencyIgnored(t *testing.T) {
+	bus := New()
+	defer bus.Close()
+
+	rec := &orderRecorder{}
+	_, _ = bus.SubscribeWithOptions("orders.created", rec.handler("audit"),
+		WithSubscriptionName("audit"), WithRunAfter("validate"))
+	_, _ = bus.SubscribeWithOptions("orders.updated", rec.handler("validate"),
+		WithSubscriptionName("validate"))
+
+	_ = bus.PublishSync(context.Background(), "orders.created", nil)
+
+	if !reflect.DeepEqual(rec.calls, []string{"audit"}) {
+		t.Errorf("Expected audit to run alone, got %v", rec.calls)
+	}
+}
+
+func TestSubscribeWithOptions_DependencyCycle(t *testing.T) {
+	bus := New()
+	defer bus.Close()
+
+	noop := HandlerFunc(func(ctx context.Context, msg Message) error { return nil })
+
+	if _, err := bus.SubscribeWithOptions("a", noop, WithSubscriptionName("a"), WithRunAfter("a")); !errors.Is(err, ErrDependencyCycle) {
+		t.Errorf("Expected ErrDependencyCycle for self dependency, got %v", err)
+	}
+
+	_, _ = bus.SubscribeWithOptions("t", noop, WithSubscriptionName("a"), WithRunAfter("c"))
+	_, _ = bus.SubscribeWithOptions("t", noop, WithSubscriptionName("b"), WithRunAfter("a"))
+	if _, err := bus.SubscribeWithOptions("t", noop, WithSubscriptionName("c"), WithRunAfter("b")); !errors.Is(err, ErrDependencyCycle) {
+		t.Errorf("Expected ErrDependencyCycle, got %v", err)
+	}
+
+	// The rejected subscription did not take the name
+	if _, err := bus.SubscribeWithOptions("t", noop, WithSubscriptionName("c")); err != nil {
+		t.Errorf("Expected name to be free after rejection, got %v", err)
+	}
+}
+
+func TestSubscribeWithOptions_DuplicateName(t *testing.T) {
+	bus := New()
+	defer bus.Close()
+
+	noop := HandlerFunc(func(ctx context.Context, msg Message) error { return nil })
+
+	sub, _ := bus.SubscribeWithOptions("a", noop, WithSubscriptionName("worker"))
+	if _, err := bus.SubscribeWithOptions("b", noop, WithSubscriptionName("worker")); err == nil {
+		t.Error("Expected error for duplicate subscription name")
+	}
+
+	_ = sub.Unsubscribe()
+	if _, err := bus.SubscribeWithOptions("b", noop, WithSubscriptionName("worker")); err != nil {
+		t.Errorf("Expected name to be reusable after unsubscribe, got %v", err)
+	}
+}
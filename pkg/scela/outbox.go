@@ -0,0 +1,250 @@
+package scela
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// TransactionalPublisher writes messages to a SQLStore inside the caller's
+// database transaction, following the outbox pattern: the messages are
+// stored if and only if the transaction commits, and an OutboxRelay
+// publishes them once committed. A crash between publishing and recording
+// it leads to a message being published again with the same ID, never to
+// a lost one.
+type TransactionalPublisher struct {
+	store *SQLStore
+	bus   Bus
+}
+
+// NewTransactionalPublisher creates a publisher writing to store. Messages
+// are built like bus builds them, with its default metadata, expiry,
+// sequence numbers and interceptors; bus may be nil.
+func NewTransactionalPublisher(store *SQLStore, bus Bus) *TransactionalPublisher {
+	if pb, ok := bus.(*PersistentBus); ok {
+		bus = pb.Bus
+	}
+	return &TransactionalPublisher{store: store, bus: bus}
+}
+
+// Publish writes a message to the outbox within tx. It is published by the
+// relay after tx commits, and discarded if tx rolls back.
+func (p *TransactionalPublisher) Publish(ctx context.Context, tx *sql.Tx, topic string, payload interface{}) error {
+	return p.PublishBatch(ctx, tx, []TopicPayload{{Topic: topic, Payload: payload}})
+}
+
+// PublishBatch writes several messages to the outbox within tx. They are
+// published by the relay in order.
+func (p *TransactionalPublisher) PublishBatch(ctx context.Context, tx *sql.Tx, batch []TopicPayload) error {
+	for _, entry := range batch {
+		msg := newBusMessage(ctx, p.bus, entry.Topic, entry.Payload, PriorityNormal)
+		// The store lock is not taken: tx may hold the only connection the
+		// store could wait for
+		if err := p.store.insert(ctx, tx, msg, true); err != nil {
+			return fmt.Errorf("failed to write message to outbox: %w", err)
+		}
+	}
+	return nil
+}
+
+// loadOutbox loads at most limit messages waiting in the outbox, oldest
+// first.
+func (s *SQLStore) loadOutbox(ctx context.Context, limit int) ([]Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// #nosec G201 -- tableName is validated in NewSQLStore
+	query := fmt.Sprintf(`
+		SELECT id, topic, payload, metadata, timestamp, priority
+		FROM %s
+		WHERE outbox = 1
+		ORDER BY COALESCE(sequence, 0) ASC, timestamp ASC, priority DESC
+		LIMIT ?
+	`, s.tableName)
+
+	rows, err := s.db.QueryContext(ctx, s.dialect.rebind(query), limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query outbox: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	return s.scanMessages(rows)
+}
+
+// clearOutbox records that the messages with the given IDs were published.
+func (s *SQLStore) clearOutbox(ctx context.Context, ids []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.setFlag(ctx, nil, "outbox", 0, ids)
+}
+
+// OutboxRelay publishes the messages committed by a TransactionalPublisher
+// to a bus, polling the store in the background.
+type OutboxRelay struct {
+	store     *SQLStore
+	bus       Bus
+	interval  time.Duration
+	batchSize int
+	onError   StoreErrorHandler
+
+	// relayMu serializes relays, so a message is never published by two
+	// at once.
+	relayMu sync.Mutex
+	mu      sync.Mutex
+	relayed int
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// OutboxRelayOption is a functional option for configuring an outbox relay.
+type OutboxRelayOption func(*OutboxRelay)
+
+// WithRelayInterval sets how often the outbox is polled. It defaults to
+// 100ms.
+func WithRelayInterval(interval time.Duration) OutboxRelayOption {
+	return func(r *OutboxRelay) {
+		if interval > 0 {
+			r.interval = interval
+		}
+	}
+}
+
+// WithRelayBatchSize sets the maximum number of messages read from the
+// outbox at once. It defaults to 100.
+func WithRelayBatchSize(n int) OutboxRelayOption {
+	return func(r *OutboxRelay) {
+		if n > 0 {
+			r.batchSize = n
+		}
+	}
+}
+
+// WithRelayErrorHandler registers a handler called whenever relaying
+// fails. Failed messages stay in the outbox and are retried at the next poll.
+func WithRelayErrorHandler(handler StoreErrorHandler) OutboxRelayOption {
+	return func(r *OutboxRelay) {
+		r.onError = handler
+	}
+}
+
+// NewOutboxRelay starts publishing the messages waiting in the outbox of
+// store on bus, until Close is called. When bus is a PersistentBus, the
+// messages are published on the bus it wraps, as they are stored already.
+// Published messages keep their ID and are marked delivered in store once
+// handled, see DeliveryStore.
+func NewOutboxRelay(store *SQLStore, bus Bus, opts ...OutboxRelayOption) *OutboxRelay {
+	if pb, ok := bus.(*PersistentBus); ok {
+		bus = pb.Bus
+	}
+
+	r := &OutboxRelay{
+		store:     store,
+		bus:       bus,
+		interval:  100 * time.Millisecond,
+		batchSize: 100,
+		done:      make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+
+	r.wg.Add(1)
+	go r.run()
+
+	return r
+}
+
+// run relays the outbox every interval until the relay is closed.
+func (r *OutboxRelay) run() {
+	defer r.wg.Done()
+
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			_, _ = r.Relay(context.Background())
+		case <-r.done:
+			return
+		}
+	}
+}
+
+// Relay publishes the messages waiting in the outbox now, in order, and
+// returns how many were published. It stops at the first message that
+// cannot be published.
+func (r *OutboxRelay) Relay(ctx context.Context) (int, error) {
+	r.relayMu.Lock()
+	defer r.relayMu.Unlock()
+
+	published := 0
+	for {
+		msgs, err := r.store.loadOutbox(ctx, r.batchSize)
+		if err != nil {
+			return published, r.fail(ctx, "relay", nil, err)
+		}
+
+		for _, msg := range msgs {
+			if err := publishExisting(r.deliveredContext(ctx), r.bus, msg); err != nil {
+				return published, r.fail(ctx, "relay", msg, err)
+			}
+			if err := r.store.clearOutbox(ctx, []string{msg.ID()}); err != nil {
+				return published, r.fail(ctx, "relay", msg, err)
+			}
+			published++
+
+			r.mu.Lock()
+			r.relayed++
+			r.mu.Unlock()
+		}
+
+		if len(msgs) < r.batchSize {
+			return published, nil
+		}
+	}
+}
+
+// deliveredContext returns ctx under which relayed messages are marked
+// delivered in the store once delivered.
+func (r *OutboxRelay) deliveredContext(ctx context.Context) context.Context {
+	return contextWithDeliveredHook(ctx, func(msg Message) {
+		if err := r.store.MarkDelivered(context.Background(), msg.ID()); err != nil {
+			_ = r.fail(context.Background(), "mark_delivered", msg, err)
+		}
+	})
+}
+
+// fail reports a failed operation to the error handler and returns the
+// resulting StoreError.
+func (r *OutboxRelay) fail(ctx context.Context, op string, msg Message, err error) *StoreError {
+	storeErr := &StoreError{Op: op, Message: msg, Err: err}
+	if r.onError != nil {
+		r.onError(ctx, storeErr)
+	}
+	return storeErr
+}
+
+// Relayed returns the number of messages published so far.
+func (r *OutboxRelay) Relayed() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.relayed
+}
+
+// Close stops the relay. Messages still in the outbox are published by the
+// next relay started on the store.
+func (r *OutboxRelay) Close() error {
+	select {
+	case <-r.done:
+		return fmt.Errorf("outbox relay already closed")
+	default:
+	}
+	close(r.done)
+	r.wg.Wait()
+	return nil
+}
@@ -0,0 +1,167 @@
+package scela
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// setupOutbox returns a store and an application table sharing a single
+// connection database.
+func setupOutbox(t *testing.T) (*sql.DB, *SQLStore) {
+	t.Helper()
+	db := setupTestDB(t)
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { _ = db.Close() })
+
+	if _, err := db.Exec("CREATE TABLE orders (id TEXT PRIMARY KEY)"); err != nil {
+		t.Fatalf("create table: %v", err)
+	}
+	store, err := NewSQLStore(SQLStoreConfig{DB: db})
+	if err != nil {
+		t.Fatalf("Failed to create SQL store: %v", err)
+	}
+	return db, store
+}
+
+// placeOrder inserts an order and publishes its event in one transaction,
+// committing it unless rollback is set.
+func placeOrder(t *testing.T, db *sql.DB, tp *TransactionalPublisher, id string, rollback bool) {
+	t.Helper()
+	ctx := context.Background()
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatalf("BeginTx() error = %v", err)
+	}
+	if _, err := tx.Exec("INSERT INTO orders (id) VALUES (?)", id); err != nil {
+		t.Fatalf("insert order: %v", err)
+	}
+	if err := tp.Publish(ctx, tx, "orders.created", id); err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+	if rollback {
+		_ = tx.Rollback()
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Commit() error = %v", err)
+	}
+}
+
+func TestOutbox_RelaysCommittedMessages(t *testing.T) {
+	db, store := setupOutbox(t)
+	bus := New()
+	defer bus.Close()
+
+	received := make(chan Message, 2)
+	_, _ = bus.Subscribe("orders.*", HandlerFunc(func(ctx context.Context, msg Message) error {
+		received <- msg
+		return nil
+	}))
+
+	tp := NewTransactionalPublisher(store, bus)
+	placeOrder(t, db, tp, "o-1", false)
+	placeOrder(t, db, tp, "o-2", true)
+
+	ctx := context.Background()
+	stored, _ := store.Load(ctx)
+	if len(stored) != 1 || stored[0].Payload() != "o-1" {
+		t.Fatalf("expected only the committed message stored, got %d", len(stored))
+	}
+	if pending, _ := store.LoadPending(ctx); len(pending) != 0 {
+		t.Errorf("expected outbox messages to be left to the relay, got %d pending", len(pending))
+	}
+
+	// Rewriting the store keeps the message in the outbox
+	_ = store.Rewrite(ctx, func(msgs []Message) ([]Message, error) { return msgs, nil })
+
+	relay := NewOutboxRelay(store, bus, WithRelayInterval(time.Hour))
+	defer relay.Close()
+	if n, err := relay.Relay(ctx); n != 1 || err != nil {
+		t.Fatalf("Relay() = %d, %v, want 1", n, err)
+	}
+	if n, _ := relay.Relay(ctx); n != 0 {
+		t.Errorf("expected the outbox to be empty, relayed %d", n)
+	}
+
+	select {
+	case msg := <-received:
+		if msg.ID() != stored[0].ID() {
+			t.Errorf("expected the stored message to be published, got %s", msg.ID())
+		}
+	case <-time.After(time.Second):
+		t.Fatal("message not published")
+	}
+	if relay.Relayed() != 1 {
+		t.Errorf("Relayed() = %d, want 1", relay.Relayed())
+	}
+
+	// Handled messages are not replayed after a restart
+	waitFor(t, func() bool {
+		delivered, _ := store.LoadPending(ctx)
+		all, _ := store.Load(ctx)
+		return len(delivered) == 0 && len(all) == 1
+	})
+}
+
+func TestOutbox_RelaysInBackground(t *testing.T) {
+	db, store := setupOutbox(t)
+	pb := NewPersistentBus(New(WithSequenceNumbers()), store)
+	defer pb.Close()
+
+	var handled atomic.Int32
+	_, _ = pb.Subscribe("orders.*", countingHandler(&handled))
+
+	relay := NewOutboxRelay(store, pb, WithRelayInterval(5*time.Millisecond), WithRelayBatchSize(2))
+	defer relay.Close()
+
+	tp := NewTransactionalPublisher(store, pb)
+	for _, id := range []string{"o-1", "o-2", "o-3"} {
+		placeOrder(t, db, tp, id, false)
+	}
+
+	waitFor(t, func() bool { return handled.Load() == 3 })
+	msgs, _ := store.Load(context.Background())
+	if len(msgs) != 3 {
+		t.Fatalf("expected the relay not to store messages again, got %d", len(msgs))
+	}
+	if n, ok := Sequence(msgs[2]); !ok || n != 3 {
+		t.Errorf("expected messages built by the wrapped bus, got sequence %d, %v", n, ok)
+	}
+}
+
+func TestOutbox_RelayFailure(t *testing.T) {
+	db, store := setupOutbox(t)
+	bus := New()
+	_ = bus.Close()
+
+	var reported atomic.Int32
+	relay := NewOutboxRelay(store, bus,
+		WithRelayInterval(time.Hour),
+		WithRelayErrorHandler(func(ctx context.Context, err *StoreError) { reported.Add(1) }),
+	)
+	defer relay.Close()
+
+	placeOrder(t, db, NewTransactionalPublisher(store, nil), "o-1", false)
+
+	n, err := relay.Relay(context.Background())
+	var storeErr *StoreError
+	if n != 0 || !errors.As(err, &storeErr) || storeErr.Op != "relay" {
+		t.Fatalf("Relay() = %d, %v, want a relay StoreError", n, err)
+	}
+	if reported.Load() != 1 {
+		t.Errorf("expected the failure to be reported once, got %d", reported.Load())
+	}
+	if msgs, _ := store.loadOutbox(context.Background(), 10); len(msgs) != 1 {
+		t.Errorf("expected the message to stay in the outbox, got %d", len(msgs))
+	}
+	if err := relay.Close(); err != nil {
+		t.Errorf("Close() error = %v", err)
+	}
+	if err := relay.Close(); err == nil {
+		t.Error("expected an error closing twice")
+	}
+}
@@ -0,0 +1,100 @@
+package scela
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ErrQueueFull matches the QueueFullError returned when a message cannot be
+// queued under OverflowErrorFast.
+var ErrQueueFull = errors.New("queue is full")
+
+// OverflowPolicy controls what publishing does when the queue for a message
+// is full.
+type OverflowPolicy int
+
+const (
+	// OverflowBlock waits for room until the publish context is done
+	// (default).
+	OverflowBlock OverflowPolicy = iota
+	// OverflowDropNewest discards the message being published.
+	OverflowDropNewest
+	// OverflowDropOldest discards the oldest queued message of the same
+	// priority to make room. With a Scheduler, the message it would dispatch
+	// next is discarded.
+	OverflowDropOldest
+	// OverflowErrorFast fails the publish with a QueueFullError.
+	OverflowErrorFast
+)
+
+// QueueFullError reports a message that could not be queued because the
+// queue was full. It matches ErrQueueFull.
+type QueueFullError struct {
+	Topic    string
+	Priority Priority
+}
+
+// Error implements the error interface.
+func (e *QueueFullError) Error() string {
+	return fmt.Sprintf("queue is full for priority %d message on %s", e.Priority, e.Topic)
+}
+
+// Is reports whether target is ErrQueueFull.
+func (e *QueueFullError) Is(target error) bool {
+	return target == ErrQueueFull
+}
+
+// WithQueueSize sets how many messages can wait for a worker at each
+// priority level, or in total on a bus with a Scheduler. It defaults to 1000.
+func WithQueueSize(n int) Option {
+	return func(b *bus) {
+		if n > 0 {
+			b.queueSize = n
+		}
+	}
+}
+
+// WithOverflowPolicy sets what publishing does when the queue is full.
+// Messages dropped by OverflowDropNewest and OverflowDropOldest are reported
+// to observers as processed with a QueueFullError. Retries of failed
+// messages always wait for room.
+func WithOverflowPolicy(policy OverflowPolicy) Option {
+	return func(b *bus) {
+		b.overflow = policy
+	}
+}
+
+// queueFull returns the error reported for env when the queue is full.
+func queueFull(env *Envelope) error {
+	return &QueueFullError{Topic: env.msg.Topic(), Priority: env.priority}
+}
+
+// enqueue queues env for the workers according to the overflow policy, or
+// holds it until its delivery time (see PublishAt).
+func (b *bus) enqueue(ctx context.Context, env *Envelope) error {
+	env.dequeued = dequeuedHook(ctx)
+	if held, err := b.schedule(ctx, env); held {
+		return err
+	}
+	return b.queueEnvelope(ctx, env, dueHook(ctx))
+}
+
+// queueEnvelope queues env according to the overflow policy, calling due
+// with its message once queued if due is not nil.
+func (b *bus) queueEnvelope(ctx context.Context, env *Envelope, due func(Message)) error {
+	dropped, err := b.dispatch(ctx, env, b.overflow)
+	queued := err == nil
+	for _, d := range dropped {
+		b.observers.NotifyDrop(ctx, d.msg, queueFull(d))
+		if d == env {
+			queued = false
+		} else {
+			d.leaveQueue()
+		}
+	}
+	if queued && due != nil {
+		due(env.msg)
+	}
+	return err
+}
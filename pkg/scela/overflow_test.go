@@ -0,0 +1,153 @@
+package scela
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+)
+
+// failureObserver records the messages reported as failed.
+type failureObserver struct {
+	countingObserver
+	failed map[string]error
+}
+
+func (o *failureObserver) OnMessageProcessed(ctx context.Context, msg Message, err error) {
+	if err == nil {
+		return
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.failed == nil {
+		o.failed = make(map[string]error)
+	}
+	o.failed[msg.Payload().(string)] = err
+}
+
+func (o *failureObserver) errorFor(payload string) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.failed[payload]
+}
+
+// collect subscribes to "work" on bus and returns the payloads received.
+func collect(t *testing.T, bus Bus) func() []string {
+	t.Helper()
+	var mu sync.Mutex
+	var got []string
+	_, err := bus.Subscribe("work", HandlerFunc(func(ctx context.Context, msg Message) error {
+		mu.Lock()
+		got = append(got, msg.Payload().(string))
+		mu.Unlock()
+		return nil
+	}))
+	if err != nil {
+		t.Fatalf("Subscribe() error = %v", err)
+	}
+	return func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), got...)
+	}
+}
+
+func TestWithQueueSize(t *testing.T) {
+	bus := New(WithQueueSize(10))
+	defer bus.Close()
+	if stats, _ := StatsOf(bus); stats.QueueCapacity != 10*priorityLevels {
+		t.Errorf("expected capacity %d, got %d", 10*priorityLevels, stats.QueueCapacity)
+	}
+
+	scheduled := New(WithQueueSize(10), WithScheduler(NewFIFOScheduler()))
+	defer scheduled.Close()
+	if stats, _ := StatsOf(scheduled); stats.QueueCapacity != 10 {
+		t.Errorf("expected capacity 10 with a scheduler, got %d", stats.QueueCapacity)
+	}
+}
+
+func TestOverflowErrorFast(t *testing.T) {
+	bus := New(WithWorkers(1), WithQueueSize(1), WithOverflowPolicy(OverflowErrorFast))
+	defer bus.Close()
+	release := blockWorker(t, bus)
+	defer release()
+
+	ctx := context.Background()
+	if err := bus.Publish(ctx, "work", "a"); err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+	err := bus.Publish(ctx, "work", "b")
+	if !errors.Is(err, ErrQueueFull) {
+		t.Fatalf("expected ErrQueueFull, got %v", err)
+	}
+	var full *QueueFullError
+	if !errors.As(err, &full) || full.Topic != "work" || full.Priority != PriorityNormal {
+		t.Errorf("unexpected error %#v", err)
+	}
+
+	// Other priority levels have their own room
+	if err := bus.PublishWithPriority(ctx, "work", "c", PriorityHigh); err != nil {
+		t.Errorf("PublishWithPriority() error = %v", err)
+	}
+
+	err = bus.PublishBatch(ctx, []TopicPayload{{Topic: "work", Payload: "d"}})
+	if !errors.Is(err, ErrQueueFull) {
+		t.Errorf("expected ErrQueueFull from PublishBatch, got %v", err)
+	}
+}
+
+func TestOverflowDropNewest(t *testing.T) {
+	obs := &failureObserver{}
+	bus := New(WithWorkers(1), WithQueueSize(1), WithOverflowPolicy(OverflowDropNewest), WithObserver(obs))
+	received := collect(t, bus)
+	release := blockWorker(t, bus)
+
+	ctx := context.Background()
+	for _, payload := range []string{"a", "b"} {
+		if err := bus.Publish(ctx, "work", payload); err != nil {
+			t.Fatalf("Publish() error = %v", err)
+		}
+	}
+	if err := obs.errorFor("b"); !errors.Is(err, ErrQueueFull) {
+		t.Errorf("expected the newest message reported as dropped, got %v", err)
+	}
+
+	release()
+	_ = bus.Close()
+	if got := received(); len(got) != 1 || got[0] != "a" {
+		t.Errorf("expected only the oldest message delivered, got %v", got)
+	}
+}
+
+func TestOverflowDropOldest(t *testing.T) {
+	for name, opts := range map[string][]Option{
+		"levels":    nil,
+		"scheduler": {WithScheduler(NewFIFOScheduler())},
+	} {
+		t.Run(name, func(t *testing.T) {
+			obs := &failureObserver{}
+			opts := append([]Option{
+				WithWorkers(1), WithQueueSize(2), WithOverflowPolicy(OverflowDropOldest), WithObserver(obs),
+			}, opts...)
+			bus := New(opts...)
+			received := collect(t, bus)
+			release := blockWorker(t, bus)
+
+			ctx := context.Background()
+			for _, payload := range []string{"a", "b", "c"} {
+				if err := bus.Publish(ctx, "work", payload); err != nil {
+					t.Fatalf("Publish() error = %v", err)
+				}
+			}
+			if err := obs.errorFor("a"); !errors.Is(err, ErrQueueFull) {
+				t.Errorf("expected the oldest message reported as dropped, got %v", err)
+			}
+
+			release()
+			_ = bus.Close()
+			if got := received(); len(got) != 2 || got[0] != "b" || got[1] != "c" {
+				t.Errorf("expected the newest messages delivered, got %v", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,88 @@
+package scela
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+)
+
+// ErrHandlerPanic matches, with errors.Is, the errors made of recovered
+// handler panics.
+var ErrHandlerPanic = errors.New("handler panicked")
+
+// PanicError is the error a recovered handler panic is turned into.
+type PanicError struct {
+	// Value is the value passed to panic.
+	Value interface{}
+	// Stack is the stack of the panicking goroutine.
+	Stack []byte
+}
+
+// Error implements the error interface.
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("handler panicked: %v", e.Value)
+}
+
+// Is reports whether target is ErrHandlerPanic.
+func (e *PanicError) Is(target error) bool {
+	return target == ErrHandlerPanic
+}
+
+// Unwrap returns the value passed to panic if it is an error.
+func (e *PanicError) Unwrap() error {
+	err, _ := e.Value.(error)
+	return err
+}
+
+// PanicHandler decides what a recovered handler panic becomes. The error it
+// returns is treated as the handler's: it is retried and dead-lettered like
+// any other. Returning nil treats the message as handled.
+type PanicHandler func(ctx context.Context, msg Message, p *PanicError) error
+
+// WithPanicHandler recovers panics of handlers and middleware, which would
+// otherwise crash the process, turning them into handler errors. handler
+// may log or translate them; nil keeps the PanicError. A panicking
+// subscription fails alone, and only its delivery is retried.
+func WithPanicHandler(handler PanicHandler) Option {
+	return func(b *bus) {
+		b.recoverPanics = true
+		b.panicHandler = handler
+	}
+}
+
+// RecoveryMiddleware turns panics of the next handlers into a PanicError
+// returned as their error. Unlike WithPanicHandler, it applies only where
+// it is used, for example around a single subscription handler.
+func RecoveryMiddleware() Middleware {
+	return func(next Handler) Handler {
+		return HandlerFunc(func(ctx context.Context, msg Message) (err error) {
+			defer func() {
+				if r := recover(); r != nil {
+					err = &PanicError{Value: r, Stack: debug.Stack()}
+				}
+			}()
+			return next.Handle(ctx, msg)
+		})
+	}
+}
+
+// protect runs fn, recovering its panics if the bus was created with
+// WithPanicHandler.
+func (b *bus) protect(ctx context.Context, msg Message, fn func(context.Context, Message) error) (err error) {
+	if !b.recoverPanics {
+		return fn(ctx, msg)
+	}
+	defer func() {
+		r := recover()
+		if r == nil {
+			return
+		}
+		p := &PanicError{Value: r, Stack: debug.Stack()}
+		err = p
+		if b.panicHandler != nil {
+			err = b.panicHandler(ctx, msg, p)
+		}
+	}()
+	return fn(ctx, msg)
+}
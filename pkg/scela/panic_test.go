@@ -0,0 +1,109 @@
+package scela
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWithPanicHandler_RetriesAndDeadLetters(t *testing.T) {
+	dead := make(chan Message, 1)
+	var recovered atomic.Int32
+	b := New(
+		WithMaxRetries(2),
+		WithPanicHandler(func(ctx context.Context, msg Message, p *PanicError) error {
+			recovered.Add(1)
+			return p
+		}),
+		WithDeadLetterHandler(HandlerFunc(func(ctx context.Context, msg Message) error {
+			dead <- msg
+			return nil
+		})),
+	)
+	defer b.Close()
+
+	var calls, healthy atomic.Int32
+	_, _ = b.Subscribe("jobs", HandlerFunc(func(ctx context.Context, msg Message) error {
+		calls.Add(1)
+		panic("boom")
+	}))
+	_, _ = b.Subscribe("jobs", countingHandler(&healthy))
+
+	_ = b.Publish(context.Background(), "jobs", "job")
+
+	select {
+	case msg := <-dead:
+		if !strings.Contains(fmt.Sprint(msg.Metadata()[MetadataDeadLetterReason]), "handler panicked: boom") {
+			t.Errorf("unexpected dead letter reason %v", msg.Metadata()[MetadataDeadLetterReason])
+		}
+	case <-time.After(time.Second):
+		t.Fatal("panicking message not dead-lettered")
+	}
+	if calls.Load() != 2 || recovered.Load() != 2 {
+		t.Errorf("expected 2 recovered calls, got %d calls and %d recoveries", calls.Load(), recovered.Load())
+	}
+	// Only the panicking subscription is retried
+	if healthy.Load() != 1 {
+		t.Errorf("expected the healthy handler to run once, got %d", healthy.Load())
+	}
+}
+
+func TestWithPanicHandler_Nil(t *testing.T) {
+	b := New(WithPanicHandler(nil))
+	defer b.Close()
+
+	errBoom := errors.New("boom")
+	_, _ = b.Subscribe("jobs", HandlerFunc(func(ctx context.Context, msg Message) error {
+		panic(errBoom)
+	}))
+
+	err := b.PublishSync(context.Background(), "jobs", "job")
+	var p *PanicError
+	if !errors.As(err, &p) || !errors.Is(err, ErrHandlerPanic) || !errors.Is(err, errBoom) {
+		t.Fatalf("expected a PanicError wrapping the panic value, got %v", err)
+	}
+	if len(p.Stack) == 0 {
+		t.Error("expected the panic stack")
+	}
+}
+
+func TestWithPanicHandler_Swallow(t *testing.T) {
+	b := New(WithPanicHandler(func(ctx context.Context, msg Message, p *PanicError) error {
+		return nil
+	}))
+	defer b.Close()
+
+	b.Use(func(next Handler) Handler {
+		return HandlerFunc(func(ctx context.Context, msg Message) error {
+			panic("middleware bug")
+		})
+	})
+	_, _ = b.Subscribe("jobs", HandlerFunc(func(ctx context.Context, msg Message) error { return nil }))
+
+	if err := b.PublishSync(context.Background(), "jobs", "job"); err != nil {
+		t.Errorf("expected the panic to be swallowed, got %v", err)
+	}
+}
+
+func TestRecoveryMiddleware(t *testing.T) {
+	h := RecoveryMiddleware()(HandlerFunc(func(ctx context.Context, msg Message) error {
+		panic("boom")
+	}))
+
+	err := h.Handle(context.Background(), NewMessage("jobs", "job"))
+	if !errors.Is(err, ErrHandlerPanic) {
+		t.Fatalf("expected ErrHandlerPanic, got %v", err)
+	}
+	if errors.Unwrap(err) != nil {
+		t.Error("expected nothing to unwrap from a non-error panic value")
+	}
+
+	ok := RecoveryMiddleware()(HandlerFunc(func(ctx context.Context, msg Message) error { return nil }))
+	if err := ok.Handle(context.Background(), NewMessage("jobs", "job")); err != nil {
+		t.Errorf("Handle() error = %v", err)
+	}
+}
@@ -0,0 +1,209 @@
+package scela
+
+import (
+	"context"
+	"hash/fnv"
+	"time"
+)
+
+// MetadataPartitionKey holds the key of a message published with
+// PublishWithKey.
+const MetadataPartitionKey = "partition_key"
+
+// partitionKeyContextKey is the context key under which the partition key
+// for the next published message is stored.
+type partitionKeyContextKey struct{}
+
+// WithPartitions sets the number of partition workers delivering keyed
+// messages (see PublishWithKey). It defaults to the number of workers.
+func WithPartitions(n int) Option {
+	return func(b *bus) {
+		if n > 0 {
+			b.partitions = n
+		}
+	}
+}
+
+// PublishWithKey publishes payload on topic asynchronously with a partition
+// key. Messages with the same key are delivered by the same partition
+// worker, one at a time and in publish order, including across topics. A
+// failing message is retried before the next message with its key is
+// delivered, so order holds until it succeeds or is dead-lettered.
+//
+// The key is carried by the context, so wrappers such as PersistentBus
+// publish through to the bus and keep it on the message.
+func PublishWithKey(ctx context.Context, b Bus, topic, key string, payload interface{}) error {
+	return b.Publish(ContextWithPartitionKey(ctx, key), topic, payload)
+}
+
+// ContextWithPartitionKey returns a context under which messages are
+// published with key as their partition key, as by PublishWithKey.
+func ContextWithPartitionKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, partitionKeyContextKey{}, key)
+}
+
+// PartitionKey returns the partition key of msg, or "" if it has none.
+func PartitionKey(msg Message) string {
+	key, _ := msg.Metadata()[MetadataPartitionKey].(string)
+	return key
+}
+
+// stampPartitionKey records the partition key carried by ctx on msg.
+func stampPartitionKey(ctx context.Context, msg Message) {
+	if key, ok := ctx.Value(partitionKeyContextKey{}).(string); ok && key != "" {
+		msg.Metadata()[MetadataPartitionKey] = key
+	}
+}
+
+// keyIndex hashes key to an index below n.
+func keyIndex(key string, n int) int {
+	hash := fnv.New32a()
+	_, _ = hash.Write([]byte(key))
+	return int(hash.Sum32() % uint32(n))
+}
+
+// partitionLane is the queue of one partition worker.
+type partitionLane struct {
+	queue chan *Envelope
+
+	// urgent hands urgent messages to the partition worker, ahead of
+	// queue, see PublishUrgent.
+	urgent chan urgentTask
+
+	// stop is closed when the bus closes, to abandon retry delays.
+	stop chan struct{}
+
+	// pending holds the retries of the message being delivered, which go
+	// before the next queued message. Only the partition worker uses it.
+	pending []pendingRetry
+}
+
+// pendingRetry is a retry waiting in a partition lane.
+type pendingRetry struct {
+	env   *Envelope
+	delay time.Duration
+}
+
+// newPartitionLane creates a lane holding up to capacity messages.
+func newPartitionLane(capacity int, stop chan struct{}) *partitionLane {
+	return &partitionLane{
+		queue:  make(chan *Envelope, capacity),
+		urgent: make(chan urgentTask),
+		stop:   stop,
+	}
+}
+
+// retry schedules env for another attempt after delay, before the next
+// queued message.
+func (l *partitionLane) retry(env *Envelope, delay time.Duration) {
+	l.pending = append(l.pending, pendingRetry{env: env, delay: delay})
+}
+
+// wait sleeps for delay. It reports false if the bus closed meanwhile.
+func (l *partitionLane) wait(delay time.Duration) bool {
+	if delay <= 0 {
+		return true
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-l.stop:
+		return false
+	}
+}
+
+// laneFor returns the lane delivering msg, or nil if msg has no partition
+// key.
+func (b *bus) laneFor(msg Message) *partitionLane {
+	key := PartitionKey(msg)
+	if key == "" || len(b.lanes) == 0 {
+		return nil
+	}
+	return b.lanes[keyIndex(key, len(b.lanes))]
+}
+
+// dispatch queues env on the lane of its partition key, or on the shared
+// queue if it has none.
+func (b *bus) dispatch(ctx context.Context, env *Envelope, policy OverflowPolicy) ([]*Envelope, error) {
+	if env.lane == nil {
+		env.lane = b.laneFor(env.msg)
+	}
+	if env.lane != nil {
+		return pushChan(ctx, env.lane.queue, env, policy)
+	}
+	return b.queue.push(ctx, env, policy)
+}
+
+// partitionWorker delivers the messages of one lane in order, retrying
+// failed ones before moving on. Urgent messages go ahead of the queued
+// ones, which wait while urgent messages are delivered under
+// WithUrgentPause.
+func (b *bus) partitionWorker(l *partitionLane) {
+	defer b.wg.Done()
+
+	for {
+		var env *Envelope
+		select {
+		case task := <-l.urgent:
+			task.run()
+			continue
+		default:
+		}
+		select {
+		case task := <-l.urgent:
+			task.run()
+			continue
+		case queued, ok := <-l.queue:
+			if !ok {
+				return
+			}
+			env = queued
+		}
+		b.holdForUrgent(l)
+		b.processMessage(env)
+
+		for len(l.pending) > 0 {
+			r := l.pending[0]
+			l.pending = l.pending[1:]
+			// Retries due after the bus closed are abandoned
+			if l.wait(r.delay) {
+				b.processMessage(r.env)
+			} else {
+				b.abandonRetry(r.env)
+			}
+		}
+	}
+}
+
+// holdForUrgent delivers the urgent messages of l until no urgent message
+// is being delivered on the bus, which only happens under WithUrgentPause.
+func (b *bus) holdForUrgent(l *partitionLane) {
+	for {
+		select {
+		case task := <-l.urgent:
+			task.run()
+		case <-b.urgent.idleChan():
+			return
+		}
+	}
+}
+
+// partitionDepth returns the number of messages waiting in the lanes.
+func (b *bus) partitionDepth() int {
+	n := 0
+	for _, l := range b.lanes {
+		n += len(l.queue)
+	}
+	return n
+}
+
+// closeLanes stops the partition workers once their queues are drained.
+func (b *bus) closeLanes() {
+	close(b.laneStop)
+	for _, l := range b.lanes {
+		close(l.queue)
+	}
+}
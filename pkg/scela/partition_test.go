@@ -0,0 +1,162 @@
+package scela
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPublishWithKey_Order(t *testing.T) {
+	b := New(WithWorkers(4), WithPartitions(3))
+
+	var mu sync.Mutex
+	seen := make(map[string][]int)
+	_, _ = b.Subscribe("orders.*", HandlerFunc(func(ctx context.Context, msg Message) error {
+		// Uneven handling times would reorder messages on a shared queue
+		time.Sleep(time.Duration(rand.Intn(200)) * time.Microsecond)
+		mu.Lock()
+		defer mu.Unlock()
+		key := PartitionKey(msg)
+		seen[key] = append(seen[key], msg.Payload().(int))
+		return nil
+	}))
+
+	ctx := context.Background()
+	for i := 0; i < 300; i++ {
+		key := fmt.Sprintf("order-%d", i%10)
+		topic := "orders.created"
+		if i%2 == 1 {
+			topic = "orders.updated"
+		}
+		if err := PublishWithKey(ctx, b, topic, key, i); err != nil {
+			t.Fatalf("PublishWithKey() error = %v", err)
+		}
+	}
+	_ = b.Close()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(seen) != 10 {
+		t.Fatalf("expected 10 keys, got %d", len(seen))
+	}
+	for key, values := range seen {
+		if len(values) != 30 {
+			t.Errorf("expected 30 messages for %s, got %d", key, len(values))
+		}
+		for i := 1; i < len(values); i++ {
+			if values[i] < values[i-1] {
+				t.Errorf("messages for %s out of order: %v", key, values)
+				break
+			}
+		}
+	}
+}
+
+func TestPublishWithKey_RetryKeepsOrder(t *testing.T) {
+	b := New(WithMaxRetries(3), WithRetryBackoff(ConstantBackoff(5*time.Millisecond)))
+
+	var mu sync.Mutex
+	var events []string
+	failed := false
+	_, _ = b.Subscribe("jobs", HandlerFunc(func(ctx context.Context, msg Message) error {
+		mu.Lock()
+		defer mu.Unlock()
+		payload := msg.Payload().(string)
+		if payload == "first" && !failed {
+			failed = true
+			events = append(events, "first failed")
+			return errors.New("handler error")
+		}
+		events = append(events, payload)
+		return nil
+	}))
+
+	ctx := context.Background()
+	_ = PublishWithKey(ctx, b, "jobs", "k", "first")
+	_ = PublishWithKey(ctx, b, "jobs", "k", "second")
+	waitFor(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(events) == 3
+	})
+	_ = b.Close()
+
+	want := []string{"first failed", "first", "second"}
+	for i := range want {
+		if events[i] != want[i] {
+			t.Fatalf("expected %v, got %v", want, events)
+		}
+	}
+}
+
+func TestPublishWithKey_ThroughWrappers(t *testing.T) {
+	store := NewInMemoryStore(0)
+	inner := New()
+	defer inner.Close()
+	b := NewPersistentBus(inner, store)
+
+	delivered := make(chan Message, 1)
+	_, _ = b.Subscribe("orders.created", HandlerFunc(func(ctx context.Context, msg Message) error {
+		delivered <- msg
+		return nil
+	}))
+
+	if err := PublishWithKey(context.Background(), b, "orders.created", "order-1", nil); err != nil {
+		t.Fatalf("PublishWithKey() error = %v", err)
+	}
+
+	select {
+	case msg := <-delivered:
+		if PartitionKey(msg) != "order-1" {
+			t.Errorf("expected the partition key on the delivered message, got %v", msg.Metadata())
+		}
+	case <-time.After(time.Second):
+		t.Fatal("message not delivered")
+	}
+
+	stored, _ := store.Load(context.Background())
+	if len(stored) != 1 || PartitionKey(stored[0]) != "order-1" {
+		t.Errorf("expected the partition key on the stored message, got %v", stored)
+	}
+}
+
+func TestPublishWithKey_Stats(t *testing.T) {
+	b := New(WithPartitions(1))
+	defer b.Close()
+
+	release := make(chan struct{})
+	started := make(chan struct{}, 1)
+	_, _ = b.Subscribe("work", HandlerFunc(func(ctx context.Context, msg Message) error {
+		select {
+		case started <- struct{}{}:
+		default:
+		}
+		<-release
+		return nil
+	}))
+
+	ctx := context.Background()
+	for i := 0; i < 3; i++ {
+		_ = PublishWithKey(ctx, b, "work", "k", i)
+	}
+	<-started
+
+	stats, _ := StatsOf(b)
+	if stats.PartitionDepth != 2 || stats.QueueDepth != 0 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+	close(release)
+}
+
+func TestKeyIndex(t *testing.T) {
+	for _, key := range []string{"", "a", "order-42"} {
+		i := keyIndex(key, 7)
+		if i < 0 || i >= 7 || keyIndex(key, 7) != i {
+			t.Errorf("keyIndex(%q) = %d, want a stable index below 7", key, i)
+		}
+	}
+}
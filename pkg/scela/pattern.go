@@ -17,10 +17,15 @@ func newPatternMatcher() *patternMatcher {
 //   - exact match: "user.created"
 //   - single wildcard: "user.*" matches "user.created", "user.updated"
 //   - suffix wildcard: "*.created" matches "user.created", "order.created"
-//   - all wildcard: "*" or "#" matches everything
+//   - multi-level wildcard: "user.#" matches "user", "user.created" and
+//     "user.profile.updated"; "#" may appear in any segment and matches
+//     zero or more segments
+//   - trailing wildcard: "user.>" matches one or more trailing segments,
+//     "user.created" and "user.profile.updated" but not "user"
+//   - all wildcard: "*", "#" or ">" matches everything
 func (pm *patternMatcher) Match(pattern, topic string) bool {
 	// All wildcard
-	if pattern == "*" || pattern == "#" {
+	if pattern == "*" || pattern == "#" || pattern == ">" {
 		return true
 	}
 
@@ -30,30 +35,55 @@ func (pm *patternMatcher) Match(pattern, topic string) bool {
 	}
 
 	// No wildcards
-	if !strings.Contains(pattern, "*") {
+	if !isWildcardPattern(pattern) {
 		return false
 	}
 
-	// Split pattern and topic by dots
-	patternParts := strings.Split(pattern, ".")
-	topicParts := strings.Split(topic, ".")
+	return matchSegments(pattern, topic)
+}
 
-	// Different segment counts can still match with wildcards
-	if len(patternParts) != len(topicParts) {
-		return false
-	}
+// matchSegments matches a wildcard pattern against a topic segment by
+// segment, without allocating.
+func matchSegments(pattern, topic string) bool {
+	for {
+		pseg, prest, pmore := strings.Cut(pattern, ".")
 
-	// Match each segment
-	for i := range patternParts {
-		if patternParts[i] == "*" {
-			continue // Wildcard matches anything
+		switch {
+		case pseg == "#":
+			if !pmore {
+				return true
+			}
+			// Try consuming zero, one, two... topic segments
+			for {
+				if matchSegments(prest, topic) {
+					return true
+				}
+				_, next, ok := strings.Cut(topic, ".")
+				if !ok {
+					return false
+				}
+				topic = next
+			}
+		case pseg == ">" && !pmore:
+			// topic holds at least one remaining segment
+			return true
 		}
-		if patternParts[i] != topicParts[i] {
+
+		tseg, trest, tmore := strings.Cut(topic, ".")
+		if pseg != "*" && pseg != tseg {
 			return false
 		}
-	}
 
-	return true
+		if !tmore {
+			// The topic is exhausted; only "#" segments, matching zero
+			// segments, may remain
+			return !pmore || onlyMultiLevel(prest)
+		}
+		if !pmore {
+			return false
+		}
+		pattern, topic = prest, trest
+	}
 }
 
 // MatchMultiple returns all patterns that match the topic.
@@ -66,3 +96,32 @@ func (pm *patternMatcher) MatchMultiple(patterns []string, topic string) []strin
 	}
 	return matches
 }
+
+// onlyMultiLevel reports whether every segment of pattern is "#".
+func onlyMultiLevel(pattern string) bool {
+	for {
+		seg, rest, more := strings.Cut(pattern, ".")
+		if seg != "#" {
+			return false
+		}
+		if !more {
+			return true
+		}
+		pattern = rest
+	}
+}
+
+// isWildcardPattern reports whether pattern contains a wildcard segment.
+func isWildcardPattern(pattern string) bool {
+	return strings.ContainsAny(pattern, "*#>")
+}
+
+// topicAncestors returns the parent topics of a dot-separated topic, nearest
+// parent first. "orders.eu.created" yields ["orders.eu", "orders"].
+func topicAncestors(topic string) []string {
+	var ancestors []string
+	for i := strings.LastIndex(topic, "."); i > 0; i = strings.LastIndex(topic[:i], ".") {
+		ancestors = append(ancestors, topic[:i])
+	}
+	return ancestors
+}
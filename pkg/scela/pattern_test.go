@@ -37,6 +37,24 @@ func TestPatternMatcher_Match(t *testing.T) {
 		{"multi segment wildcard", "user.*.updated", "user.profile.updated", true},
 		{"multi segment wildcard mismatch length", "user.*", "user.profile.updated", false},
 
+		// Multi-level wildcards
+		{"multi-level # subtree", "user.#", "user.profile.updated", true},
+		{"multi-level # one segment", "user.#", "user.created", true},
+		{"multi-level # zero segments", "user.#", "user", true},
+		{"multi-level # mismatch", "user.#", "order.created", false},
+		{"multi-level # middle", "user.#.updated", "user.profile.email.updated", true},
+		{"multi-level # middle zero", "user.#.updated", "user.updated", true},
+		{"multi-level # middle mismatch", "user.#.updated", "user.profile.created", false},
+		{"multi-level # leading", "#.updated", "user.profile.updated", true},
+		{"multi-level # repeated zero", "user.#.#", "user", true},
+		{"trailing > subtree", "user.>", "user.profile.updated", true},
+		{"trailing > one segment", "user.>", "user.created", true},
+		{"trailing > requires a segment", "user.>", "user", false},
+		{"trailing > mismatch", "user.>", "order.created", false},
+		{"all wildcard >", ">", "order.updated", true},
+		{"mixed wildcards", "*.profile.>", "user.profile.email.updated", true},
+		{"single wildcard shorter topic", "user.*.updated", "user.updated", false},
+
 		// Edge cases
 		{"empty topic", "user.created", "", false},
 		{"empty pattern", "", "user.created", false},
@@ -67,6 +85,30 @@ func TestPatternMatcher_MatchMultiple(t *testing.T) {
 	}
 }
 
+func TestTopicAncestors(t *testing.T) {
+	tests := []struct {
+		topic string
+		want  []string
+	}{
+		{"orders.eu.created", []string{"orders.eu", "orders"}},
+		{"orders.created", []string{"orders"}},
+		{"orders", nil},
+		{"", nil},
+	}
+
+	for _, tt := range tests {
+		got := topicAncestors(tt.topic)
+		if len(got) != len(tt.want) {
+			t.Fatalf("topicAncestors(%q) = %v, want %v", tt.topic, got, tt.want)
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("topicAncestors(%q) = %v, want %v", tt.topic, got, tt.want)
+			}
+		}
+	}
+}
+
 func BenchmarkPatternMatcher_Match(b *testing.B) {
 	pm := newPatternMatcher()
 	b.ResetTimer()
@@ -75,3 +117,33 @@ func BenchmarkPatternMatcher_Match(b *testing.B) {
 		pm.Match("user.*", "user.created")
 	}
 }
+
+func BenchmarkPatternMatcher_MatchMultiLevel(b *testing.B) {
+	pm := newPatternMatcher()
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		pm.Match("user.#", "user.profile.email.updated")
+	}
+}
+
+func BenchmarkPatternMatcher_MatchMultiLevelMiddle(b *testing.B) {
+	pm := newPatternMatcher()
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		pm.Match("user.#.updated", "user.profile.email.address.updated")
+	}
+}
+
+func BenchmarkPatternMatcher_MatchTrailing(b *testing.B) {
+	pm := newPatternMatcher()
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		pm.Match("user.>", "user.profile.email.updated")
+	}
+}
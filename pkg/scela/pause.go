@@ -0,0 +1,210 @@
+package scela
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// ErrTopicPaused is returned by PublishSync for a paused topic, and reported
+// to observers for held messages dropped when the bus closes.
+var ErrTopicPaused = errors.New("topic is paused")
+
+// TopicPauser is implemented by buses whose delivery can be paused per
+// topic.
+type TopicPauser interface {
+	// PauseTopic stops delivering messages on topics matching pattern.
+	PauseTopic(pattern string) error
+	// ResumeTopic resumes delivery paused by PauseTopic with pattern.
+	ResumeTopic(pattern string) error
+	// PausedTopics returns the paused patterns, sorted.
+	PausedTopics() []string
+}
+
+// PauserOf returns the TopicPauser of b, looking through the wrappers
+// provided by this package. It reports false if b cannot pause topics.
+func PauserOf(b Bus) (TopicPauser, bool) {
+	for b != nil {
+		if p, ok := b.(TopicPauser); ok {
+			return p, true
+		}
+		b = innerBus(b)
+	}
+	return nil, false
+}
+
+// PauseTopic stops delivering messages on topics matching pattern, for
+// example to isolate a misbehaving consumer without losing its
+// subscriptions. Messages keep being accepted: async messages, retries
+// included, are held in memory until the topic is resumed, and PublishSync
+// fails with ErrTopicPaused. Pausing a paused pattern has no effect.
+func (b *bus) PauseTopic(pattern string) error {
+	if pattern == "" {
+		return fmt.Errorf("%w: pattern cannot be empty", ErrInvalidPattern)
+	}
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	if b.closed {
+		return ErrBusClosed
+	}
+	b.pauses.pause(pattern)
+	return nil
+}
+
+// ResumeTopic resumes delivery on topics matching pattern. Held messages
+// that no other paused pattern matches are queued again, in the order they
+// were held. It returns an error if pattern is not paused.
+func (b *bus) ResumeTopic(pattern string) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	if b.closed {
+		return ErrBusClosed
+	}
+
+	released, ok := b.pauses.resume(pattern)
+	if !ok {
+		return fmt.Errorf("topic pattern %q is not paused", pattern)
+	}
+	for _, env := range released {
+		_, _ = b.dispatch(context.Background(), env, OverflowBlock)
+	}
+	return nil
+}
+
+// PausedTopics implements TopicPauser.
+func (b *bus) PausedTopics() []string {
+	return b.pauses.patterns()
+}
+
+// pauseRegistry tracks paused topic patterns and the messages they hold.
+type pauseRegistry struct {
+	matcher *patternMatcher
+
+	// active is the number of paused patterns, checked without locking on
+	// the delivery path.
+	active atomic.Int32
+
+	mu     sync.Mutex
+	paused map[string]struct{}
+	held   []*Envelope
+}
+
+// newPauseRegistry creates a registry with no paused topics.
+func newPauseRegistry() *pauseRegistry {
+	return &pauseRegistry{
+		matcher: newPatternMatcher(),
+		paused:  make(map[string]struct{}),
+	}
+}
+
+// pause adds pattern to the paused patterns.
+func (pr *pauseRegistry) pause(pattern string) {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+
+	pr.paused[pattern] = struct{}{}
+	pr.active.Store(int32(len(pr.paused)))
+}
+
+// resume removes pattern and returns the held messages no longer paused. It
+// reports false if pattern was not paused.
+func (pr *pauseRegistry) resume(pattern string) ([]*Envelope, bool) {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+
+	if _, ok := pr.paused[pattern]; !ok {
+		return nil, false
+	}
+	delete(pr.paused, pattern)
+	pr.active.Store(int32(len(pr.paused)))
+
+	var released []*Envelope
+	held := pr.held[:0]
+	for _, env := range pr.held {
+		if pr.matches(env.msg.Topic()) {
+			held = append(held, env)
+		} else {
+			released = append(released, env)
+		}
+	}
+	for i := len(held); i < len(pr.held); i++ {
+		pr.held[i] = nil
+	}
+	pr.held = held
+	return released, true
+}
+
+// isPaused reports whether topic matches a paused pattern.
+func (pr *pauseRegistry) isPaused(topic string) bool {
+	if pr.active.Load() == 0 {
+		return false
+	}
+
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+	return pr.matches(topic)
+}
+
+// hold keeps env until its topic is resumed. It reports false, leaving env
+// to the caller, if the topic is not paused.
+func (pr *pauseRegistry) hold(env *Envelope) bool {
+	if pr.active.Load() == 0 {
+		return false
+	}
+
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+
+	if !pr.matches(env.msg.Topic()) {
+		return false
+	}
+	pr.held = append(pr.held, env)
+	return true
+}
+
+// matches reports whether topic matches a paused pattern. Must be called
+// with the lock held.
+func (pr *pauseRegistry) matches(topic string) bool {
+	for pattern := range pr.paused {
+		if pr.matcher.Match(pattern, topic) {
+			return true
+		}
+	}
+	return false
+}
+
+// patterns returns the paused patterns, sorted.
+func (pr *pauseRegistry) patterns() []string {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+
+	patterns := make([]string, 0, len(pr.paused))
+	for pattern := range pr.paused {
+		patterns = append(patterns, pattern)
+	}
+	sort.Strings(patterns)
+	return patterns
+}
+
+// heldCount returns the number of held messages.
+func (pr *pauseRegistry) heldCount() int {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+	return len(pr.held)
+}
+
+// drain removes and returns all held messages.
+func (pr *pauseRegistry) drain() []*Envelope {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+
+	held := pr.held
+	pr.held = nil
+	return held
+}
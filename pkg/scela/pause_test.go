@@ -0,0 +1,160 @@
+package scela
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// topicRecorder records the topics of the messages it handles.
+type topicRecorder struct {
+	mu     sync.Mutex
+	topics []string
+}
+
+func (r *topicRecorder) Handle(ctx context.Context, msg Message) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.topics = append(r.topics, msg.Topic())
+	return nil
+}
+
+func (r *topicRecorder) count(topic string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n := 0
+	for _, t := range r.topics {
+		if t == topic {
+			n++
+		}
+	}
+	return n
+}
+
+func TestPauseTopic(t *testing.T) {
+	b := New()
+	defer b.Close()
+	rec := &topicRecorder{}
+	_, _ = b.Subscribe("#", rec)
+
+	pauser, ok := PauserOf(b)
+	if !ok {
+		t.Fatal("expected the bus to pause topics")
+	}
+	if err := pauser.PauseTopic("orders.#"); err != nil {
+		t.Fatalf("PauseTopic() error = %v", err)
+	}
+
+	ctx := context.Background()
+	_ = b.Publish(ctx, "orders.created", nil)
+	_ = b.Publish(ctx, "orders.created", nil)
+	_ = b.Publish(ctx, "users.created", nil)
+
+	waitFor(t, func() bool { return rec.count("users.created") == 1 })
+	waitFor(t, func() bool {
+		stats, _ := StatsOf(b)
+		return stats.Held == 2
+	})
+	if rec.count("orders.created") != 0 {
+		t.Error("expected no delivery on a paused topic")
+	}
+	if err := b.PublishSync(ctx, "orders.created", nil); !errors.Is(err, ErrTopicPaused) {
+		t.Errorf("expected ErrTopicPaused from PublishSync, got %v", err)
+	}
+	if got := pauser.PausedTopics(); len(got) != 1 || got[0] != "orders.#" {
+		t.Errorf("PausedTopics() = %v", got)
+	}
+
+	if err := pauser.ResumeTopic("orders.#"); err != nil {
+		t.Fatalf("ResumeTopic() error = %v", err)
+	}
+	waitFor(t, func() bool { return rec.count("orders.created") == 2 })
+	if stats, _ := StatsOf(b); stats.Held != 0 {
+		t.Errorf("expected no held messages, got %d", stats.Held)
+	}
+
+	if err := pauser.ResumeTopic("orders.#"); err == nil {
+		t.Error("expected an error resuming a topic that is not paused")
+	}
+	if err := pauser.PauseTopic(""); err == nil {
+		t.Error("expected an error pausing an empty pattern")
+	}
+}
+
+func TestPauseTopic_Overlapping(t *testing.T) {
+	b := New()
+	defer b.Close()
+	rec := &topicRecorder{}
+	_, _ = b.Subscribe("a.b", rec)
+
+	pauser, _ := PauserOf(NewReadOnlyBus(b))
+	_ = pauser.PauseTopic("a.*")
+	_ = pauser.PauseTopic("a.b")
+	_ = b.Publish(context.Background(), "a.b", nil)
+	waitFor(t, func() bool {
+		stats, _ := StatsOf(b)
+		return stats.Held == 1
+	})
+
+	// Still held while another paused pattern matches
+	_ = pauser.ResumeTopic("a.*")
+	time.Sleep(20 * time.Millisecond)
+	if rec.count("a.b") != 0 {
+		t.Fatal("expected the message to stay held")
+	}
+
+	_ = pauser.ResumeTopic("a.b")
+	waitFor(t, func() bool { return rec.count("a.b") == 1 })
+}
+
+func TestPauseTopic_RetriesHeld(t *testing.T) {
+	var mu sync.Mutex
+	attempts := 0
+	b := New(WithMaxRetries(3))
+	defer b.Close()
+	_, _ = b.Subscribe("jobs", HandlerFunc(func(ctx context.Context, msg Message) error {
+		mu.Lock()
+		defer mu.Unlock()
+		attempts++
+		if attempts == 1 {
+			// Pause from the handler, so that the retry is held
+			_ = b.(TopicPauser).PauseTopic("jobs")
+			return errors.New("handler error")
+		}
+		return nil
+	}))
+
+	_ = b.Publish(context.Background(), "jobs", nil)
+	waitFor(t, func() bool {
+		stats, _ := StatsOf(b)
+		return stats.Held == 1
+	})
+
+	_ = b.(TopicPauser).ResumeTopic("jobs")
+	waitFor(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return attempts == 2
+	})
+}
+
+func TestPauseTopic_CloseDropsHeld(t *testing.T) {
+	obs := &failureObserver{}
+	b := New(WithObserver(obs))
+	_ = b.(TopicPauser).PauseTopic("work")
+	_ = b.Publish(context.Background(), "work", "held")
+	waitFor(t, func() bool {
+		stats, _ := StatsOf(b)
+		return stats.Held == 1
+	})
+
+	_ = b.Close()
+	if err := obs.errorFor("held"); !errors.Is(err, ErrTopicPaused) {
+		t.Errorf("expected the held message reported with ErrTopicPaused, got %v", err)
+	}
+	if err := b.(TopicPauser).PauseTopic("work"); err == nil {
+		t.Error("expected an error pausing on a closed bus")
+	}
+}
@@ -5,7 +5,9 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"log"
 	"os"
+	"path/filepath"
 	"sync"
 	"time"
 )
@@ -25,11 +27,114 @@ type MessageStore interface {
 	Close() error
 }
 
+// BatchStore is implemented by stores that can persist several messages
+// atomically: either every message of the batch is stored or none is.
+type BatchStore interface {
+	MessageStore
+
+	// StoreBatch persists all messages in a single operation.
+	StoreBatch(ctx context.Context, msgs []Message) error
+}
+
+// RewritableStore is implemented by stores that can atomically replace their
+// entire contents. Rewrite passes the stored messages to fn and replaces them
+// with the result; if fn or the write fails, the store is left unchanged.
+type RewritableStore interface {
+	MessageStore
+
+	// Rewrite atomically replaces all stored messages with fn's result.
+	Rewrite(ctx context.Context, fn func([]Message) ([]Message, error)) error
+}
+
+// QueryableStore is implemented by stores that can select and prune messages
+// without loading everything. Results are in the order the messages were
+// stored, as by Load, whatever their timestamps.
+type QueryableStore interface {
+	MessageStore
+
+	// LoadByTopic loads the messages published on topic.
+	LoadByTopic(ctx context.Context, topic string) ([]Message, error)
+
+	// LoadAfter loads the messages with a timestamp after the given time.
+	LoadAfter(ctx context.Context, after time.Time) ([]Message, error)
+
+	// LoadPage loads at most limit messages, skipping the first offset.
+	LoadPage(ctx context.Context, offset, limit int) ([]Message, error)
+
+	// Count returns the number of stored messages.
+	Count(ctx context.Context) (int, error)
+
+	// ClearBefore removes messages with a timestamp before the given time.
+	ClearBefore(ctx context.Context, before time.Time) error
+}
+
+// StoreStats is implemented by stores that can report their size and
+// contents for capacity planning.
+type StoreStats interface {
+	// Stats returns statistics about the stored messages.
+	Stats(ctx context.Context) (StoreStatistics, error)
+}
+
+// StoreStatistics describes the contents of a message store.
+type StoreStatistics struct {
+	// MessageCount is the number of stored messages.
+	MessageCount int
+	// SizeBytes is the storage used by the messages: the file size for
+	// FileStore and the stored payload and metadata size for SQLStore.
+	// In-memory stores report 0.
+	SizeBytes int64
+	// Oldest and Newest are the timestamps of the oldest and newest messages.
+	// Both are zero when the store is empty.
+	Oldest time.Time
+	Newest time.Time
+	// TopicCounts maps each topic to its number of stored messages.
+	TopicCounts map[string]int
+}
+
+// computeStoreStatistics derives statistics from a list of messages.
+func computeStoreStatistics(messages []Message) StoreStatistics {
+	stats := StoreStatistics{
+		MessageCount: len(messages),
+		TopicCounts:  make(map[string]int),
+	}
+
+	for _, msg := range messages {
+		stats.TopicCounts[msg.Topic()]++
+
+		ts := msg.Timestamp()
+		if stats.Oldest.IsZero() || ts.Before(stats.Oldest) {
+			stats.Oldest = ts
+		}
+		if ts.After(stats.Newest) {
+			stats.Newest = ts
+		}
+	}
+
+	return stats
+}
+
+// statsOf returns statistics for any store, computing them from Load when
+// the store does not implement StoreStats.
+func statsOf(ctx context.Context, store MessageStore) (StoreStatistics, error) {
+	if ss, ok := store.(StoreStats); ok {
+		return ss.Stats(ctx)
+	}
+
+	messages, err := store.Load(ctx)
+	if err != nil {
+		return StoreStatistics{}, err
+	}
+	return computeStoreStatistics(messages), nil
+}
+
 // InMemoryStore is a simple in-memory message store.
 type InMemoryStore struct {
 	messages []Message
 	mu       sync.RWMutex
 	maxSize  int
+
+	// delivered holds the IDs of the stored messages marked delivered.
+	delivered map[string]bool
 }
 
 // NewInMemoryStore creates a new in-memory store.
@@ -38,8 +143,9 @@ func NewInMemoryStore(maxSize int) *InMemoryStore {
 		maxSize = 10000
 	}
 	return &InMemoryStore{
-		messages: make([]Message, 0),
-		maxSize:  maxSize,
+		messages:  make([]Message, 0),
+		maxSize:   maxSize,
+		delivered: make(map[string]bool),
 	}
 }
 
@@ -52,12 +158,47 @@ func (s *InMemoryStore) Store(ctx context.Context, msg Message) error {
 
 	// Trim if exceeded max size
 	if len(s.messages) > s.maxSize {
+		s.forgetDelivered(s.messages[:len(s.messages)-s.maxSize])
+		s.messages = s.messages[len(s.messages)-s.maxSize:]
+	}
+
+	return nil
+}
+
+// StoreBatch implements BatchStore.
+func (s *InMemoryStore) StoreBatch(ctx context.Context, msgs []Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.messages = append(s.messages, msgs...)
+
+	// Trim if exceeded max size
+	if len(s.messages) > s.maxSize {
+		s.forgetDelivered(s.messages[:len(s.messages)-s.maxSize])
 		s.messages = s.messages[len(s.messages)-s.maxSize:]
 	}
 
 	return nil
 }
 
+// Rewrite implements RewritableStore.
+func (s *InMemoryStore) Rewrite(ctx context.Context, fn func([]Message) ([]Message, error)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current := make([]Message, len(s.messages))
+	copy(current, s.messages)
+
+	rewritten, err := fn(current)
+	if err != nil {
+		return err
+	}
+
+	s.messages = rewritten
+	s.pruneDelivered()
+	return nil
+}
+
 // Load implements MessageStore.
 func (s *InMemoryStore) Load(ctx context.Context) ([]Message, error) {
 	s.mu.RLock()
@@ -69,12 +210,147 @@ func (s *InMemoryStore) Load(ctx context.Context) ([]Message, error) {
 	return result, nil
 }
 
+// Stats implements StoreStats.
+func (s *InMemoryStore) Stats(ctx context.Context) (StoreStatistics, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return computeStoreStatistics(s.messages), nil
+}
+
+// LoadByTopic implements QueryableStore.
+func (s *InMemoryStore) LoadByTopic(ctx context.Context, topic string) ([]Message, error) {
+	return s.query(func(msg Message) bool { return msg.Topic() == topic }), nil
+}
+
+// LoadAfter implements QueryableStore.
+func (s *InMemoryStore) LoadAfter(ctx context.Context, after time.Time) ([]Message, error) {
+	return s.query(func(msg Message) bool { return msg.Timestamp().After(after) }), nil
+}
+
+// LoadPage implements QueryableStore.
+func (s *InMemoryStore) LoadPage(ctx context.Context, offset, limit int) ([]Message, error) {
+	if offset < 0 || limit < 0 {
+		return nil, fmt.Errorf("invalid page: offset %d, limit %d", offset, limit)
+	}
+
+	messages := s.query(nil)
+	if offset >= len(messages) {
+		return []Message{}, nil
+	}
+	messages = messages[offset:]
+	if limit < len(messages) {
+		messages = messages[:limit]
+	}
+	return messages, nil
+}
+
+// Count implements QueryableStore.
+func (s *InMemoryStore) Count(ctx context.Context) (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return len(s.messages), nil
+}
+
+// ClearBefore implements QueryableStore.
+func (s *InMemoryStore) ClearBefore(ctx context.Context, before time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	kept := make([]Message, 0, len(s.messages))
+	for _, msg := range s.messages {
+		if !msg.Timestamp().Before(before) {
+			kept = append(kept, msg)
+		}
+	}
+	s.messages = kept
+	s.pruneDelivered()
+	return nil
+}
+
+// PurgeExpired implements ExpiringStore.
+func (s *InMemoryStore) PurgeExpired(ctx context.Context, now time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := len(s.messages)
+	s.messages = purgeExpired(s.messages, now)
+	s.pruneDelivered()
+	return n - len(s.messages), nil
+}
+
+// MarkDelivered implements DeliveryStore.
+func (s *InMemoryStore) MarkDelivered(ctx context.Context, ids ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, id := range ids {
+		s.delivered[id] = true
+	}
+	return nil
+}
+
+// LoadPending implements DeliveryStore.
+func (s *InMemoryStore) LoadPending(ctx context.Context) ([]Message, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	result := make([]Message, 0, len(s.messages))
+	for _, msg := range s.messages {
+		if !s.delivered[msg.ID()] {
+			result = append(result, msg)
+		}
+	}
+	return result, nil
+}
+
+// forgetDelivered drops the delivery state of messages about to be removed.
+func (s *InMemoryStore) forgetDelivered(removed []Message) {
+	for _, msg := range removed {
+		delete(s.delivered, msg.ID())
+	}
+}
+
+// pruneDelivered drops the delivery state of the messages no longer stored.
+func (s *InMemoryStore) pruneDelivered() {
+	if len(s.delivered) == 0 {
+		return
+	}
+	stored := make(map[string]bool, len(s.messages))
+	for _, msg := range s.messages {
+		stored[msg.ID()] = true
+	}
+	for id := range s.delivered {
+		if !stored[id] {
+			delete(s.delivered, id)
+		}
+	}
+}
+
+// query returns the messages accepted by match (all if nil), ordered by
+// timestamp like SQLStore. Messages with equal timestamps keep their
+// insertion order.
+func (s *InMemoryStore) query(match func(Message) bool) []Message {
+	s.mu.RLock()
+	result := make([]Message, 0, len(s.messages))
+	for _, msg := range s.messages {
+		if match == nil || match(msg) {
+			result = append(result, msg)
+		}
+	}
+	s.mu.RUnlock()
+
+	return result
+}
+
 // Clear implements MessageStore.
 func (s *InMemoryStore) Clear(ctx context.Context) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
 	s.messages = make([]Message, 0)
+	s.delivered = make(map[string]bool)
 	return nil
 }
 
@@ -85,17 +361,59 @@ func (s *InMemoryStore) Close() error {
 
 // FileStore persists messages to a file.
 type FileStore struct {
-	filepath   string
-	serializer Serializer
-	mu         sync.Mutex
+	filepath    string
+	serializer  Serializer
+	compression *recordCompressor
+	mu          sync.Mutex
+
+	// delivered holds the IDs of the messages marked delivered, as of the
+	// last read of the file.
+	delivered map[string]bool
+}
+
+// FileStoreOption is a functional option for configuring a file store.
+type FileStoreOption func(*FileStore)
+
+// WithFileCompression compresses each record payload of at least threshold
+// bytes with the given compressor. Records written without compression remain
+// readable.
+func WithFileCompression(compressor Compressor, threshold int) FileStoreOption {
+	return func(s *FileStore) {
+		s.compression = newRecordCompressor(compressor, threshold)
+	}
+}
+
+// WithFileSerializer encodes record payloads with serializer instead of
+// storing them as JSON values of the file. The data of binary serializers
+// (see BinarySerializer) is stored as base64. Records written with the
+// default encoding remain readable.
+func WithFileSerializer(serializer Serializer) FileStoreOption {
+	return func(s *FileStore) {
+		if serializer != nil {
+			s.serializer = serializer
+		}
+	}
 }
 
 // NewFileStore creates a new file-based store.
-func NewFileStore(filepath string) *FileStore {
-	return &FileStore{
-		filepath:   filepath,
-		serializer: NewJSONSerializer(),
+func NewFileStore(filepath string, opts ...FileStoreOption) *FileStore {
+	s := &FileStore{
+		filepath:    filepath,
+		serializer:  NewJSONSerializer(),
+		compression: newRecordCompressor(nil, 0),
 	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s
+}
+
+// CompressionStats returns compression statistics for the records currently
+// held in the file, as of the last write.
+func (s *FileStore) CompressionStats() CompressionStats {
+	return s.compression.stats()
 }
 
 // Store implements MessageStore.
@@ -116,6 +434,38 @@ func (s *FileStore) Store(ctx context.Context, msg Message) error {
 	return s.saveToFile(messages)
 }
 
+// StoreBatch implements BatchStore. The batch is appended with a single file
+// write, so a crash cannot leave part of it on disk.
+func (s *FileStore) StoreBatch(ctx context.Context, msgs []Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	messages, err := s.loadFromFile()
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return s.saveToFile(append(messages, msgs...))
+}
+
+// Rewrite implements RewritableStore.
+func (s *FileStore) Rewrite(ctx context.Context, fn func([]Message) ([]Message, error)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	messages, err := s.loadFromFile()
+	if err != nil {
+		return err
+	}
+
+	rewritten, err := fn(messages)
+	if err != nil {
+		return err
+	}
+
+	return s.saveToFile(rewritten)
+}
+
 // Load implements MessageStore.
 func (s *FileStore) Load(ctx context.Context) ([]Message, error) {
 	s.mu.Lock()
@@ -124,6 +474,29 @@ func (s *FileStore) Load(ctx context.Context) ([]Message, error) {
 	return s.loadFromFile()
 }
 
+// Stats implements StoreStats.
+func (s *FileStore) Stats(ctx context.Context) (StoreStatistics, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	messages, err := s.loadFromFile()
+	if err != nil {
+		return StoreStatistics{}, err
+	}
+
+	stats := computeStoreStatistics(messages)
+
+	info, err := os.Stat(s.filepath)
+	if err != nil && !os.IsNotExist(err) {
+		return StoreStatistics{}, err
+	}
+	if err == nil {
+		stats.SizeBytes = info.Size()
+	}
+
+	return stats, nil
+}
+
 // Clear implements MessageStore.
 func (s *FileStore) Clear(ctx context.Context) error {
 	s.mu.Lock()
@@ -132,13 +505,69 @@ func (s *FileStore) Clear(ctx context.Context) error {
 	return os.Remove(s.filepath)
 }
 
+// PurgeExpired implements ExpiringStore.
+func (s *FileStore) PurgeExpired(ctx context.Context, now time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	messages, err := s.loadFromFile()
+	if err != nil {
+		return 0, err
+	}
+	n := len(messages)
+	messages = purgeExpired(messages, now)
+	if len(messages) == n {
+		return 0, nil
+	}
+	if err := s.saveToFile(messages); err != nil {
+		return 0, err
+	}
+	return n - len(messages), nil
+}
+
+// MarkDelivered implements DeliveryStore.
+func (s *FileStore) MarkDelivered(ctx context.Context, ids ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	messages, err := s.loadFromFile()
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		s.delivered[id] = true
+	}
+	return s.saveToFile(messages)
+}
+
+// LoadPending implements DeliveryStore.
+func (s *FileStore) LoadPending(ctx context.Context) ([]Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	messages, err := s.loadFromFile()
+	if err != nil {
+		return nil, err
+	}
+	pending := messages[:0]
+	for _, msg := range messages {
+		if !s.delivered[msg.ID()] {
+			pending = append(pending, msg)
+		}
+	}
+	return pending, nil
+}
+
 // Close implements MessageStore.
 func (s *FileStore) Close() error {
 	return nil
 }
 
-// loadFromFile loads messages from the file.
+// loadFromFile loads messages from the file, along with the IDs of the
+// ones marked delivered.
 func (s *FileStore) loadFromFile() ([]Message, error) {
+	s.delivered = make(map[string]bool)
+
 	file, err := os.Open(s.filepath)
 	if err != nil {
 		if os.IsNotExist(err) {
@@ -168,25 +597,129 @@ func (s *FileStore) loadFromFile() ([]Message, error) {
 		if !ok {
 			continue
 		}
-		payload := msgData["payload"]
-		msg := NewMessage(topic, payload)
+		payload, err := s.decodePayload(msgData)
+		if err != nil {
+			return nil, err
+		}
+		msg := NewMessage(topic, payload).(*message)
+		// Files written before IDs and metadata were kept get fresh ones
+		if id, ok := msgData["id"].(string); ok && id != "" {
+			msg.id = id
+		}
+		if ts, ok := msgData["timestamp"].(string); ok {
+			if t, err := time.Parse(time.RFC3339Nano, ts); err == nil {
+				msg.timestamp = t
+			}
+		}
+		if metadata, ok := msgData["metadata"].(map[string]interface{}); ok {
+			msg.metadata = metadata
+		}
+		if delivered, _ := msgData["delivered"].(bool); delivered {
+			s.delivered[msg.id] = true
+		}
 		messages = append(messages, msg)
 	}
 
 	return messages, nil
 }
 
+// decodePayload returns the payload of a stored record, decompressing it if
+// the record was written compressed.
+func (s *FileStore) decodePayload(msgData map[string]interface{}) (interface{}, error) {
+	compressed, _ := msgData["compressed"].(bool)
+	serialized, _ := msgData["serialized"].(bool)
+	if !compressed && !serialized {
+		return msgData["payload"], nil
+	}
+
+	stored, ok := msgData["payload"].(string)
+	if !ok {
+		return nil, fmt.Errorf("invalid encoded payload")
+	}
+
+	data, err := s.compression.decode(stored)
+	if err != nil {
+		return nil, err
+	}
+
+	var payload interface{}
+	if !serialized {
+		if err := json.Unmarshal(data, &payload); err != nil {
+			return nil, fmt.Errorf("failed to decode payload: %w", err)
+		}
+		return payload, nil
+	}
+
+	if data, err = deserializeText(s.serializer, data); err != nil {
+		return nil, err
+	}
+	if err := s.serializer.Deserialize(data, &payload); err != nil {
+		return nil, fmt.Errorf("failed to deserialize payload: %w", err)
+	}
+	return payload, nil
+}
+
+// encodePayload returns the record fields holding the payload, compressing it
+// when the store is configured to.
+func (s *FileStore) encodePayload(msgData map[string]interface{}, payload interface{}) error {
+	if _, ok := s.serializer.(*JSONSerializer); !ok {
+		data, err := s.serializer.Serialize(payload)
+		if err != nil {
+			return fmt.Errorf("failed to serialize payload: %w", err)
+		}
+		stored, compressed, err := s.compression.encode(serializeText(s.serializer, data))
+		if err != nil {
+			return err
+		}
+		msgData["payload"] = stored
+		msgData["serialized"] = true
+		if compressed {
+			msgData["compressed"] = true
+		}
+		return nil
+	}
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	stored, compressed, err := s.compression.encode(data)
+	if err != nil {
+		return err
+	}
+
+	if compressed {
+		msgData["payload"] = stored
+		msgData["compressed"] = true
+	} else {
+		msgData["payload"] = json.RawMessage(data)
+	}
+	return nil
+}
+
 // saveToFile saves messages to the file.
 func (s *FileStore) saveToFile(messages []Message) error {
 	messagesData := make([]map[string]interface{}, 0, len(messages))
 
+	// Statistics describe the file as it is about to be written
+	s.compression.reset()
+
 	for _, msg := range messages {
 		msgData := map[string]interface{}{
 			"id":        msg.ID(),
 			"topic":     msg.Topic(),
-			"payload":   msg.Payload(),
 			"timestamp": msg.Timestamp(),
 		}
+		if len(msg.Metadata()) > 0 {
+			msgData["metadata"] = msg.Metadata()
+		}
+		if s.delivered[msg.ID()] {
+			msgData["delivered"] = true
+		}
+		if err := s.encodePayload(msgData, msg.Payload()); err != nil {
+			return err
+		}
 		messagesData = append(messagesData, msgData)
 	}
 
@@ -195,63 +728,345 @@ func (s *FileStore) saveToFile(messages []Message) error {
 		return err
 	}
 
-	return os.WriteFile(s.filepath, data, 0600)
+	return writeFileAtomic(s.filepath, data)
+}
+
+// writeFileAtomic writes data to a temporary file next to path and renames it
+// into place, so readers never observe a partially written file.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	tmpName := tmp.Name()
+
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmpName)
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		_ = os.Remove(tmpName)
+		return err
+	}
+
+	if err := os.Rename(tmpName, path); err != nil {
+		_ = os.Remove(tmpName)
+		return err
+	}
+	return nil
+}
+
+// StoreError describes a failed store operation.
+type StoreError struct {
+	// Op is the failed operation, e.g. "store", "store_batch" or "load".
+	Op string
+	// Message is the message being persisted, if any.
+	Message Message
+	// Err is the error returned by the store.
+	Err error
+}
+
+// Error implements the error interface.
+func (e *StoreError) Error() string {
+	if e.Message != nil {
+		return fmt.Sprintf("%s failed for message %s: %v", e.Op, e.Message.ID(), e.Err)
+	}
+	return fmt.Sprintf("%s failed: %v", e.Op, e.Err)
+}
+
+// Unwrap returns the underlying store error.
+func (e *StoreError) Unwrap() error {
+	return e.Err
+}
+
+// StoreErrorHandler is called when a store operation fails.
+type StoreErrorHandler func(ctx context.Context, err *StoreError)
+
+// LogStoreErrors returns a StoreErrorHandler that writes failures to logger,
+// or to the standard logger when logger is nil.
+func LogStoreErrors(logger *log.Logger) StoreErrorHandler {
+	if logger == nil {
+		logger = log.Default()
+	}
+	return func(ctx context.Context, err *StoreError) {
+		logger.Printf("scela: %v", err)
+	}
+}
+
+// storeErrorNotifier is implemented by buses that forward store failures to
+// their observers.
+type storeErrorNotifier interface {
+	notifyStoreError(ctx context.Context, err *StoreError)
+}
+
+// messagePublisher is implemented by buses that can publish already built
+// messages.
+type messagePublisher interface {
+	publishMessages(ctx context.Context, msgs []Message, batch bool) error
 }
 
 // PersistentBus wraps a bus with message persistence.
 type PersistentBus struct {
 	Bus
-	store MessageStore
+	store         MessageStore
+	errorHandlers []StoreErrorHandler
+
+	// scheduleStore keeps scheduled messages until queued, see
+	// WithScheduleStore.
+	scheduleStore RewritableStore
+
+	// janitor purges expired messages from store, see WithJanitor.
+	janitorInterval time.Duration
+	janitor         *Janitor
+
+	// compactor applies the retention policy to store, see WithRetention.
+	retention         RetentionPolicy
+	retentionInterval time.Duration
+	compactor         *Compactor
+
+	// stores closes the stores in place of store, see WithStoreManager.
+	stores *StoreManager
+
+	// metadataPolicy selects the stored metadata, see WithPersistedMetadata.
+	metadataPolicy *MetadataPolicy
+}
+
+// PersistentBusOption is a functional option for configuring a persistent bus.
+type PersistentBusOption func(*PersistentBus)
+
+// WithStoreErrorHandler registers a handler called whenever a store
+// operation fails. Failures are also reported to observers of the wrapped
+// bus that implement StoreErrorObserver.
+func WithStoreErrorHandler(handler StoreErrorHandler) PersistentBusOption {
+	return func(pb *PersistentBus) {
+		if handler != nil {
+			pb.errorHandlers = append(pb.errorHandlers, handler)
+		}
+	}
+}
+
+// WithStoreManager closes the stores of m when the bus closes, instead of
+// the store of the bus alone. Add the store of the bus to m.
+func WithStoreManager(m *StoreManager) PersistentBusOption {
+	return func(pb *PersistentBus) {
+		pb.stores = m
+	}
 }
 
 // NewPersistentBus creates a new persistent bus.
-func NewPersistentBus(bus Bus, store MessageStore) *PersistentBus {
-	return &PersistentBus{
+func NewPersistentBus(bus Bus, store MessageStore, opts ...PersistentBusOption) *PersistentBus {
+	pb := &PersistentBus{
 		Bus:   bus,
 		store: store,
 	}
+
+	for _, opt := range opts {
+		opt(pb)
+	}
+
+	if es, ok := store.(ExpiringStore); ok && pb.janitorInterval > 0 {
+		pb.janitor = NewJanitor(es, pb.janitorInterval, func(ctx context.Context, err *StoreError) {
+			pb.reportStoreError(ctx, err.Op, err.Message, err.Err)
+		})
+	}
+	if rs, ok := store.(RewritableStore); ok && pb.retention != nil && pb.retentionInterval > 0 {
+		pb.compactor = NewCompactor(rs, pb.retention, pb.retentionInterval, func(ctx context.Context, err *StoreError) {
+			pb.reportStoreError(ctx, err.Op, err.Message, err.Err)
+		})
+	}
+
+	return pb
+}
+
+// reportStoreError notifies the error handlers and bus observers of a failed
+// store operation and returns the resulting StoreError.
+func (pb *PersistentBus) reportStoreError(ctx context.Context, op string, msg Message, err error) *StoreError {
+	storeErr := &StoreError{Op: op, Message: msg, Err: err}
+
+	for _, handler := range pb.errorHandlers {
+		handler(ctx, storeErr)
+	}
+	if n, ok := pb.Bus.(storeErrorNotifier); ok {
+		n.notifyStoreError(ctx, storeErr)
+	}
+
+	return storeErr
 }
 
 // Publish publishes and persists a message.
 func (pb *PersistentBus) Publish(ctx context.Context, topic string, payload interface{}) error {
-	msg := NewMessage(topic, payload)
+	// Payloads the bus would reject are not stored
+	if err := checkBusPayload(pb.Bus, topic, payload); err != nil {
+		return err
+	}
+	msg := newBusMessage(ctx, pb.Bus, topic, payload, PriorityNormal)
 
 	// Persist first
-	if err := pb.store.Store(ctx, msg); err != nil {
-		return fmt.Errorf("failed to persist message: %w", err)
+	if err := pb.store.Store(ctx, pb.persisted(msg)); err != nil {
+		return fmt.Errorf("failed to persist message: %w", pb.reportStoreError(ctx, "store", msg, err))
+	}
+	ctx, err := pb.persistScheduled(ctx, []Message{msg})
+	if err != nil {
+		return err
 	}
 
 	// Then publish
+	if mp, ok := pb.Bus.(messagePublisher); ok {
+		return mp.publishMessages(pb.deliveredContext(ctx), []Message{msg}, false)
+	}
 	return pb.Bus.Publish(ctx, topic, payload)
 }
 
-// Replay replays all stored messages.
-func (pb *PersistentBus) Replay(ctx context.Context) error {
-	messages, err := pb.store.Load(ctx)
+// publishMessages persists and publishes already built messages, so that
+// the messages received by bridges keep their ID on a persistent bus. A
+// batch is persisted like PublishBatch persists it. When the wrapped bus
+// cannot publish existing messages, their topics and payloads are published
+// as new messages.
+func (pb *PersistentBus) publishMessages(ctx context.Context, msgs []Message, batch bool) error {
+	mp, ok := pb.Bus.(messagePublisher)
+	if !ok && batch {
+		entries := make([]TopicPayload, len(msgs))
+		for i, msg := range msgs {
+			entries[i] = TopicPayload{Topic: msg.Topic(), Payload: msg.Payload()}
+		}
+		return pb.PublishBatch(ctx, entries)
+	}
+	if !ok {
+		for _, msg := range msgs {
+			if err := pb.Publish(ctx, msg.Topic(), msg.Payload()); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if batch {
+		stored := make([]Message, len(msgs))
+		for i, msg := range msgs {
+			stored[i] = pb.persisted(msg)
+		}
+		if err := pb.storeBatch(ctx, stored); err != nil {
+			return err
+		}
+	} else {
+		for _, msg := range msgs {
+			if err := pb.store.Store(ctx, pb.persisted(msg)); err != nil {
+				return fmt.Errorf("failed to persist message: %w", pb.reportStoreError(ctx, "store", msg, err))
+			}
+		}
+	}
+	ctx, err := pb.persistScheduled(ctx, msgs)
 	if err != nil {
 		return err
 	}
+	return mp.publishMessages(pb.deliveredContext(ctx), msgs, batch)
+}
 
-	for _, msg := range messages {
-		if err := pb.Bus.Publish(ctx, msg.Topic(), msg.Payload()); err != nil {
+// PublishBatch persists and publishes a batch of messages. When the store
+// implements BatchStore the whole batch is persisted atomically; otherwise
+// messages are stored one by one and the error reports how many were stored.
+// Nothing is published unless the entire batch was persisted.
+func (pb *PersistentBus) PublishBatch(ctx context.Context, batch []TopicPayload) error {
+	if len(batch) == 0 {
+		return nil
+	}
+
+	for _, entry := range batch {
+		if err := checkBusPayload(pb.Bus, entry.Topic, entry.Payload); err != nil {
 			return err
 		}
 	}
 
+	msgs := make([]Message, len(batch))
+	for i, entry := range batch {
+		msgs[i] = newBusMessage(ctx, pb.Bus, entry.Topic, entry.Payload, PriorityNormal)
+	}
+
+	stored := msgs
+	if pb.metadataPolicy != nil {
+		stored = make([]Message, len(msgs))
+		for i, msg := range msgs {
+			stored[i] = pb.persisted(msg)
+		}
+	}
+
+	if err := pb.storeBatch(ctx, stored); err != nil {
+		return err
+	}
+
+	ctx, err := pb.persistScheduled(ctx, msgs)
+	if err != nil {
+		return err
+	}
+
+	if mp, ok := pb.Bus.(messagePublisher); ok {
+		return mp.publishMessages(pb.deliveredContext(ctx), msgs, true)
+	}
+	return pb.Bus.PublishBatch(ctx, batch)
+}
+
+// storeBatch persists a batch of messages, atomically when the store
+// implements BatchStore; otherwise messages are stored one by one and the
+// error reports how many were stored.
+func (pb *PersistentBus) storeBatch(ctx context.Context, msgs []Message) error {
+	if bs, ok := pb.store.(BatchStore); ok {
+		if err := bs.StoreBatch(ctx, msgs); err != nil {
+			return fmt.Errorf("failed to persist batch: %w", pb.reportStoreError(ctx, "store_batch", nil, err))
+		}
+		return nil
+	}
+	for i, msg := range msgs {
+		if err := pb.store.Store(ctx, msg); err != nil {
+			storeErr := pb.reportStoreError(ctx, "store", msg, err)
+			return fmt.Errorf("failed to persist batch (%d of %d messages stored): %w", i, len(msgs), storeErr)
+		}
+	}
 	return nil
 }
 
+// StoreStats returns statistics about the underlying store.
+func (pb *PersistentBus) StoreStats(ctx context.Context) (StoreStatistics, error) {
+	stats, err := statsOf(ctx, pb.store)
+	if err != nil {
+		return StoreStatistics{}, pb.reportStoreError(ctx, "stats", nil, err)
+	}
+	return stats, nil
+}
+
 // GetStore returns the underlying message store.
 func (pb *PersistentBus) GetStore() MessageStore {
 	return pb.store
 }
 
-// Close closes the persistent bus and its store.
+// Close closes the persistent bus and then its store, so that messages the
+// bus delivers while closing, such as dead letters, can still be stored.
 func (pb *PersistentBus) Close() error {
-	if err := pb.store.Close(); err != nil {
-		return err
+	return pb.CloseContext(context.Background())
+}
+
+// CloseContext closes the bus, then flushes and closes its store with
+// CloseStore, or the stores given with WithStoreManager, waiting for
+// pending store writes until ctx is done.
+func (pb *PersistentBus) CloseContext(ctx context.Context) error {
+	if pb.janitor != nil {
+		_ = pb.janitor.Close()
+	}
+	if pb.compactor != nil {
+		_ = pb.compactor.Close()
 	}
-	return pb.Bus.Close()
+	busErr := pb.Bus.Close()
+
+	var err error
+	if pb.stores != nil {
+		err = pb.stores.Close(ctx)
+	} else {
+		err = CloseStore(ctx, pb.store)
+	}
+	if err != nil {
+		return pb.reportStoreError(ctx, "close", nil, err)
+	}
+	return busErr
 }
 
 // ReplayableStore wraps a store with replay capability.
@@ -290,6 +1105,15 @@ func (rs *ReplayableStore) Load(ctx context.Context) ([]Message, error) {
 	return filtered, nil
 }
 
+// Stats implements StoreStats for the messages visible since the start time.
+func (rs *ReplayableStore) Stats(ctx context.Context) (StoreStatistics, error) {
+	messages, err := rs.Load(ctx)
+	if err != nil {
+		return StoreStatistics{}, err
+	}
+	return computeStoreStatistics(messages), nil
+}
+
 // Clear implements MessageStore.
 func (rs *ReplayableStore) Clear(ctx context.Context) error {
 	return rs.store.Clear(ctx)
@@ -2,7 +2,13 @@ package scela
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"log"
 	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -101,6 +107,27 @@ func TestFileStore(t *testing.T) {
 	}
 }
 
+func TestFileStore_KeepsIdentityAndMetadata(t *testing.T) {
+	store := NewFileStore(filepath.Join(t.TempDir(), "messages.json"))
+	ctx := context.Background()
+
+	msg := NewMessage("orders", "data")
+	msg.Metadata()["tenant"] = "acme"
+	if err := store.Store(ctx, msg); err != nil {
+		t.Fatalf("Store() error = %v", err)
+	}
+
+	loaded, err := store.Load(ctx)
+	if err != nil || len(loaded) != 1 {
+		t.Fatalf("Load() = %v, %v", loaded, err)
+	}
+	got := loaded[0]
+	if got.ID() != msg.ID() || !got.Timestamp().Equal(msg.Timestamp()) || got.Metadata()["tenant"] != "acme" {
+		t.Errorf("expected %s at %v with its metadata, got %s at %v with %v",
+			msg.ID(), msg.Timestamp(), got.ID(), got.Timestamp(), got.Metadata())
+	}
+}
+
 func TestPersistentBus(t *testing.T) {
 	bus := New()
 	defer bus.Close()
@@ -156,3 +183,348 @@ func TestReplayableStore(t *testing.T) {
 		t.Errorf("Expected at least 1 message after cutoff, got %d", len(messages))
 	}
 }
+
+// failingStore fails every Store call after the first failAfter successes.
+// It deliberately does not implement BatchStore.
+type failingStore struct {
+	inner     *InMemoryStore
+	failAfter int
+	calls     int
+}
+
+func (s *failingStore) Store(ctx context.Context, msg Message) error {
+	s.calls++
+	if s.calls > s.failAfter {
+		return errors.New("store unavailable")
+	}
+	return s.inner.Store(ctx, msg)
+}
+
+func (s *failingStore) Load(ctx context.Context) ([]Message, error) { return s.inner.Load(ctx) }
+func (s *failingStore) Clear(ctx context.Context) error             { return s.inner.Clear(ctx) }
+func (s *failingStore) Close() error                                { return s.inner.Close() }
+
+func TestFileStore_StoreBatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "batch.json")
+	store := NewFileStore(path)
+	ctx := context.Background()
+
+	if err := store.Store(ctx, NewMessage("single", 0)); err != nil {
+		t.Fatalf("Store() error = %v", err)
+	}
+
+	batch := []Message{NewMessage("a", 1), NewMessage("b", 2), NewMessage("c", 3)}
+	if err := store.StoreBatch(ctx, batch); err != nil {
+		t.Fatalf("StoreBatch() error = %v", err)
+	}
+
+	messages, err := NewFileStore(path).Load(ctx)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if len(messages) != 4 {
+		t.Errorf("Expected 4 messages, got %d", len(messages))
+	}
+
+	entries, _ := os.ReadDir(filepath.Dir(path))
+	if len(entries) != 1 {
+		t.Errorf("Expected no temporary files left behind, got %d entries", len(entries))
+	}
+}
+
+func TestPersistentBus_PublishBatch(t *testing.T) {
+	bus := New()
+	defer bus.Close()
+
+	store := NewInMemoryStore(100)
+	pbus := NewPersistentBus(bus, store)
+	ctx := context.Background()
+
+	batch := []TopicPayload{{Topic: "a", Payload: 1}, {Topic: "b", Payload: 2}}
+	if err := pbus.PublishBatch(ctx, batch); err != nil {
+		t.Fatalf("PublishBatch() error = %v", err)
+	}
+
+	messages, _ := store.Load(ctx)
+	if len(messages) != 2 {
+		t.Errorf("Expected 2 stored messages, got %d", len(messages))
+	}
+}
+
+func TestPersistentBus_PublishBatchStoreFailure(t *testing.T) {
+	bus := New()
+	defer bus.Close()
+
+	var published int32
+	_, _ = bus.Subscribe("*", HandlerFunc(func(ctx context.Context, msg Message) error {
+		atomic.AddInt32(&published, 1)
+		return nil
+	}))
+
+	store := &failingStore{inner: NewInMemoryStore(100), failAfter: 1}
+	pbus := NewPersistentBus(bus, store)
+
+	batch := []TopicPayload{{Topic: "a"}, {Topic: "b"}, {Topic: "c"}}
+	err := pbus.PublishBatch(context.Background(), batch)
+	if err == nil {
+		t.Fatal("Expected error when store fails mid-batch")
+	}
+	if !strings.Contains(err.Error(), "1 of 3") {
+		t.Errorf("Expected error to report stored count, got %v", err)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	if got := atomic.LoadInt32(&published); got != 0 {
+		t.Errorf("Expected nothing published after failed persistence, got %d", got)
+	}
+}
+
+// rejectingBatchStore is a BatchStore whose batches all fail.
+type rejectingBatchStore struct {
+	*InMemoryStore
+}
+
+func (s rejectingBatchStore) StoreBatch(ctx context.Context, msgs []Message) error {
+	return errors.New("transaction aborted")
+}
+
+func TestPersistentBus_PublishMessagesBatch(t *testing.T) {
+	bus := New()
+	defer bus.Close()
+
+	var published int32
+	_, _ = bus.Subscribe("*", HandlerFunc(func(ctx context.Context, msg Message) error {
+		atomic.AddInt32(&published, 1)
+		return nil
+	}))
+	ctx := context.Background()
+	msgs := []Message{NewMessage("a", 1), NewMessage("b", 2), NewMessage("c", 3)}
+
+	// Bridged batches are stored atomically by a BatchStore
+	atomicStore := rejectingBatchStore{NewInMemoryStore(100)}
+	if err := NewPersistentBus(bus, atomicStore).publishMessages(ctx, msgs, true); err == nil {
+		t.Fatal("Expected error when the batch fails")
+	}
+	if stored, _ := atomicStore.Load(ctx); len(stored) != 0 {
+		t.Errorf("Expected nothing stored, got %d messages", len(stored))
+	}
+
+	store := &failingStore{inner: NewInMemoryStore(100), failAfter: 1}
+	err := NewPersistentBus(bus, store).publishMessages(ctx, msgs, true)
+	if err == nil || !strings.Contains(err.Error(), "1 of 3") {
+		t.Errorf("Expected error to report stored count, got %v", err)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	if got := atomic.LoadInt32(&published); got != 0 {
+		t.Errorf("Expected nothing published after failed persistence, got %d", got)
+	}
+}
+
+func TestInMemoryStore_Rewrite(t *testing.T) {
+	store := NewInMemoryStore(100)
+	ctx := context.Background()
+
+	for i := 0; i < 4; i++ {
+		store.Store(ctx, NewMessage("test", i))
+	}
+
+	err := store.Rewrite(ctx, func(messages []Message) ([]Message, error) {
+		return messages[2:], nil
+	})
+	if err != nil {
+		t.Fatalf("Rewrite() error = %v", err)
+	}
+
+	messages, _ := store.Load(ctx)
+	if len(messages) != 2 || messages[0].Payload() != 2 {
+		t.Errorf("Expected last 2 messages to remain, got %d", len(messages))
+	}
+
+	failed := errors.New("rewrite failed")
+	if err := store.Rewrite(ctx, func([]Message) ([]Message, error) { return nil, failed }); !errors.Is(err, failed) {
+		t.Errorf("Expected rewrite error, got %v", err)
+	}
+
+	messages, _ = store.Load(ctx)
+	if len(messages) != 2 {
+		t.Errorf("Expected store unchanged after failed rewrite, got %d messages", len(messages))
+	}
+}
+
+func TestStoreStats(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "stats.json")
+
+	stores := map[string]MessageStore{
+		"memory": NewInMemoryStore(100),
+		"file":   NewFileStore(path),
+	}
+
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			empty, err := store.(StoreStats).Stats(ctx)
+			if err != nil {
+				t.Fatalf("Stats() error = %v", err)
+			}
+			if empty.MessageCount != 0 || !empty.Oldest.IsZero() {
+				t.Errorf("Expected empty statistics, got %+v", empty)
+			}
+
+			for _, topic := range []string{"a", "b", "a"} {
+				if err := store.Store(ctx, NewMessage(topic, "data")); err != nil {
+					t.Fatalf("Store() error = %v", err)
+				}
+				time.Sleep(time.Millisecond)
+			}
+
+			stats, err := NewPersistentBus(nil, store).StoreStats(ctx)
+			if err != nil {
+				t.Fatalf("StoreStats() error = %v", err)
+			}
+			if stats.MessageCount != 3 {
+				t.Errorf("Expected 3 messages, got %d", stats.MessageCount)
+			}
+			if stats.TopicCounts["a"] != 2 || stats.TopicCounts["b"] != 1 {
+				t.Errorf("Unexpected topic counts %v", stats.TopicCounts)
+			}
+			if !stats.Oldest.Before(stats.Newest) {
+				t.Errorf("Expected oldest %v before newest %v", stats.Oldest, stats.Newest)
+			}
+		})
+	}
+
+	fileStats, _ := stores["file"].(StoreStats).Stats(ctx)
+	if fileStats.SizeBytes == 0 {
+		t.Error("Expected file store to report its size on disk")
+	}
+}
+
+type storeErrorObserver struct {
+	countingObserver
+	errs chan *StoreError
+}
+
+func (o *storeErrorObserver) OnStoreError(ctx context.Context, err *StoreError) {
+	o.errs <- err
+}
+
+func TestPersistentBus_StoreErrorHooks(t *testing.T) {
+	observer := &storeErrorObserver{errs: make(chan *StoreError, 1)}
+	bus := New(WithObserver(observer))
+	defer bus.Close()
+
+	var handled []*StoreError
+	store := &failingStore{inner: NewInMemoryStore(10)}
+	pbus := NewPersistentBus(bus, store, WithStoreErrorHandler(func(ctx context.Context, err *StoreError) {
+		handled = append(handled, err)
+	}))
+
+	err := pbus.Publish(context.Background(), "orders", "data")
+
+	var storeErr *StoreError
+	if !errors.As(err, &storeErr) {
+		t.Fatalf("Expected *StoreError, got %v", err)
+	}
+	if storeErr.Op != "store" || storeErr.Message.Topic() != "orders" {
+		t.Errorf("Unexpected store error %+v", storeErr)
+	}
+
+	if len(handled) != 1 || handled[0] != storeErr {
+		t.Errorf("Expected handler to receive the store error, got %v", handled)
+	}
+
+	select {
+	case got := <-observer.errs:
+		if got != storeErr {
+			t.Errorf("Observer received %v, want %v", got, storeErr)
+		}
+	default:
+		t.Error("Expected observer to be notified of the store error")
+	}
+}
+
+func TestLogStoreErrors(t *testing.T) {
+	var buf strings.Builder
+	handler := LogStoreErrors(log.New(&buf, "", 0))
+
+	handler(context.Background(), &StoreError{Op: "load", Err: errors.New("disk full")})
+
+	if got := buf.String(); got != "scela: load failed: disk full\n" {
+		t.Errorf("Unexpected log output %q", got)
+	}
+}
+
+func TestQueryableStore_Parity(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	sqlStore, err := NewSQLStore(SQLStoreConfig{DB: db})
+	if err != nil {
+		t.Fatalf("Failed to create SQL store: %v", err)
+	}
+
+	stores := map[string]QueryableStore{
+		"InMemoryStore": NewInMemoryStore(100),
+		"SQLStore":      sqlStore,
+	}
+
+	base := time.Now().Add(-time.Hour).Truncate(time.Millisecond)
+	at := func(topic, payload string, offset time.Duration) Message {
+		msg := NewMessage(topic, payload).(*message)
+		msg.timestamp = base.Add(offset)
+		return msg
+	}
+
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			// Stored out of timestamp order on purpose: results keep store order
+			for _, msg := range []Message{
+				at("order.created", "o2", 2*time.Minute),
+				at("user.created", "u1", 1*time.Minute),
+				at("order.created", "o1", 0),
+				at("order.shipped", "o3", 3*time.Minute),
+			} {
+				if err := store.Store(ctx, msg); err != nil {
+					t.Fatalf("Store: %v", err)
+				}
+			}
+
+			payloads := func(msgs []Message, err error) []string {
+				t.Helper()
+				if err != nil {
+					t.Fatalf("query failed: %v", err)
+				}
+				var out []string
+				for _, m := range msgs {
+					out = append(out, m.Payload().(string))
+				}
+				return out
+			}
+			expect := func(desc string, got []string, want ...string) {
+				t.Helper()
+				if fmt.Sprint(got) != fmt.Sprint(want) {
+					t.Errorf("%s = %v, want %v", desc, got, want)
+				}
+			}
+
+			expect("LoadByTopic", payloads(store.LoadByTopic(ctx, "order.created")), "o2", "o1")
+			expect("LoadAfter", payloads(store.LoadAfter(ctx, base.Add(time.Minute))), "o2", "o3")
+			expect("LoadPage(1, 2)", payloads(store.LoadPage(ctx, 1, 2)), "u1", "o1")
+			expect("LoadPage past end", payloads(store.LoadPage(ctx, 10, 2)))
+			if _, err := store.LoadPage(ctx, -1, 2); err == nil {
+				t.Error("expected an error for a negative offset")
+			}
+
+			if n, err := store.Count(ctx); err != nil || n != 4 {
+				t.Errorf("Count = %d, %v, want 4", n, err)
+			}
+
+			if err := store.ClearBefore(ctx, base.Add(2*time.Minute)); err != nil {
+				t.Fatalf("ClearBefore: %v", err)
+			}
+			expect("after ClearBefore", payloads(store.LoadPage(ctx, 0, 10)), "o2", "o3")
+		})
+	}
+}
@@ -0,0 +1,141 @@
+package scela
+
+import (
+	"context"
+	"sync"
+)
+
+// urgentContextKey is the context key marking synchronous publishes as
+// urgent.
+type urgentContextKey struct{}
+
+// laneContextKey is the context key under which the partition lane
+// delivering a message is stored in handler contexts.
+type laneContextKey struct{}
+
+// ContextWithUrgency returns a context under which PublishSync delivers
+// messages as PublishUrgent does, so wrappers such as PersistentBus publish
+// through to the bus and keep the urgency.
+func ContextWithUrgency(ctx context.Context) context.Context {
+	return context.WithValue(ctx, urgentContextKey{}, true)
+}
+
+// isUrgent reports whether ctx marks a synchronous publish as urgent.
+func isUrgent(ctx context.Context) bool {
+	urgent, _ := ctx.Value(urgentContextKey{}).(bool)
+	return urgent
+}
+
+// PublishUrgent publishes payload on topic synchronously with
+// PriorityUrgent. A message with a partition key (see
+// ContextWithPartitionKey) is delivered by the partition worker of its key
+// ahead of the messages queued for it, once the message being delivered is
+// done, retries included; a plain PublishSync would be delivered alongside
+// them. Messages without a key are delivered at once, as by PublishSync.
+//
+// An urgent message published by a handler running on a partition worker
+// is delivered at once by that worker, as by PublishSync, so that two
+// lanes publishing to each other cannot wait on one another. It may then
+// run alongside the message being delivered for its key.
+//
+// Handlers of a keyed urgent message must not wait for messages queued
+// for the same key, which are only delivered after it.
+func PublishUrgent(ctx context.Context, b Publisher, topic string, payload interface{}) error {
+	return b.PublishSync(ContextWithUrgency(ctx), topic, payload)
+}
+
+// WithUrgentPause makes partition workers start no queued message while an
+// urgent message (see PublishUrgent) is being delivered, so that background
+// backlogs on other keys do not compete with it. The workers and the
+// messages they deliver already are not interrupted, and unkeyed async
+// messages are not held.
+func WithUrgentPause() Option {
+	return func(b *bus) {
+		b.urgentPause = true
+	}
+}
+
+// urgentTask is the delivery of an urgent message by a partition worker.
+type urgentTask struct {
+	ctx     context.Context
+	deliver func(ctx context.Context) error
+	done    chan error
+}
+
+// run delivers the message and reports the result.
+func (t urgentTask) run() {
+	t.done <- t.deliver(t.ctx)
+}
+
+// deliverUrgent runs deliver, the synchronous delivery of msg, on the lane
+// of its partition key ahead of the messages queued there, or at once if it
+// has no key or is published from a partition worker, which must not block
+// on another lane.
+func (b *bus) deliverUrgent(ctx context.Context, msg Message, deliver func(ctx context.Context) error) error {
+	if b.urgentPause {
+		b.urgent.enter()
+		defer b.urgent.leave()
+	}
+
+	lane := b.laneFor(msg)
+	if lane == nil || ctx.Value(laneContextKey{}) != nil {
+		return deliver(ctx)
+	}
+
+	task := urgentTask{
+		ctx:     context.WithValue(ctx, laneContextKey{}, lane),
+		deliver: deliver,
+		done:    make(chan error, 1),
+	}
+	select {
+	case lane.urgent <- task:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	// The handlers are running, so the result is awaited regardless of ctx
+	return <-task.done
+}
+
+// urgentGate tracks the urgent messages being delivered, for the partition
+// workers held by WithUrgentPause.
+type urgentGate struct {
+	mu     sync.Mutex
+	active int
+	// idle is closed while no urgent message is being delivered.
+	idle chan struct{}
+}
+
+// newUrgentGate creates an idle gate.
+func newUrgentGate() *urgentGate {
+	idle := make(chan struct{})
+	close(idle)
+	return &urgentGate{idle: idle}
+}
+
+// enter records an urgent message being delivered.
+func (g *urgentGate) enter() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.active == 0 {
+		g.idle = make(chan struct{})
+	}
+	g.active++
+}
+
+// leave records an urgent message delivered.
+func (g *urgentGate) leave() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.active--
+	if g.active == 0 {
+		close(g.idle)
+	}
+}
+
+// idleChan returns a channel closed once no urgent message is being
+// delivered.
+func (g *urgentGate) idleChan() <-chan struct{} {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.idle
+}
@@ -0,0 +1,188 @@
+package scela
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPublishUrgent_JumpsLaneQueue(t *testing.T) {
+	b := New(WithPartitions(1))
+	defer b.Close()
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	var mu sync.Mutex
+	var order []string
+	var priority Priority
+	_, _ = b.Subscribe("jobs", HandlerFunc(func(ctx context.Context, msg Message) error {
+		switch msg.Payload() {
+		case "first":
+			close(started)
+			<-release
+		case "urgent":
+			priority = MessagePriority(msg)
+		}
+		mu.Lock()
+		order = append(order, msg.Payload().(string))
+		mu.Unlock()
+		return nil
+	}))
+
+	ctx := context.Background()
+	for _, payload := range []string{"first", "second", "third"} {
+		_ = PublishWithKey(ctx, b, "jobs", "k", payload)
+	}
+	<-started
+
+	done := make(chan error, 1)
+	go func() {
+		done <- PublishUrgent(ContextWithPartitionKey(ctx, "k"), b, "jobs", "urgent")
+	}()
+
+	// Let the urgent message wait for the lane behind the first message
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatalf("PublishUrgent() error = %v", err)
+	}
+	if priority != PriorityUrgent {
+		t.Errorf("expected an urgent message, got priority %v", priority)
+	}
+
+	waitFor(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(order) == 4
+	})
+	want := []string{"first", "urgent", "second", "third"}
+	for i := range want {
+		if order[i] != want[i] {
+			t.Fatalf("expected %v, got %v", want, order)
+		}
+	}
+}
+
+func TestWithUrgentPause(t *testing.T) {
+	b := New(WithPartitions(2), WithUrgentPause())
+	defer b.Close()
+
+	// Keys delivered by different partition workers
+	urgentKey, backgroundKey := "k0", ""
+	for i := 1; backgroundKey == ""; i++ {
+		if key := fmt.Sprintf("k%d", i); keyIndex(key, 2) != keyIndex(urgentKey, 2) {
+			backgroundKey = key
+		}
+	}
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	_, _ = b.Subscribe("checkout", HandlerFunc(func(ctx context.Context, msg Message) error {
+		close(started)
+		<-release
+		return nil
+	}))
+	background := make(chan Message, 1)
+	_, _ = b.Subscribe("reindex", HandlerFunc(func(ctx context.Context, msg Message) error {
+		background <- msg
+		return nil
+	}))
+
+	ctx := context.Background()
+	done := make(chan error, 1)
+	go func() {
+		done <- PublishUrgent(ContextWithPartitionKey(ctx, urgentKey), b, "checkout", nil)
+	}()
+	<-started
+
+	_ = PublishWithKey(ctx, b, "reindex", backgroundKey, nil)
+	select {
+	case <-background:
+		t.Fatal("expected the background lane held during the urgent delivery")
+	case <-time.After(30 * time.Millisecond):
+	}
+
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatalf("PublishUrgent() error = %v", err)
+	}
+	select {
+	case <-background:
+	case <-time.After(time.Second):
+		t.Fatal("expected the background lane resumed")
+	}
+}
+
+func TestPublishUrgent_FromSameLane(t *testing.T) {
+	b := New(WithPartitions(1), WithUrgentPause())
+	defer b.Close()
+
+	done := make(chan error, 1)
+	_, _ = b.Subscribe("order.paid", HandlerFunc(func(ctx context.Context, msg Message) error {
+		done <- PublishUrgent(ContextWithPartitionKey(ctx, "o-1"), b, "order.notify", nil)
+		return nil
+	}))
+	notified := make(chan struct{}, 1)
+	_, _ = b.Subscribe("order.notify", HandlerFunc(func(ctx context.Context, msg Message) error {
+		notified <- struct{}{}
+		return nil
+	}))
+
+	_ = PublishWithKey(context.Background(), b, "order.paid", "o-1", nil)
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("PublishUrgent() error = %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected an urgent publish from a handler of the same lane to be delivered at once")
+	}
+	if len(notified) != 1 {
+		t.Error("expected the urgent message delivered")
+	}
+}
+
+func TestPublishUrgent_AcrossLanes(t *testing.T) {
+	b := New(WithPartitions(2))
+	defer b.Close()
+
+	// Keys delivered by different partition workers
+	keyA, keyB := "k0", ""
+	for i := 1; keyB == ""; i++ {
+		if key := fmt.Sprintf("k%d", i); keyIndex(key, 2) != keyIndex(keyA, 2) {
+			keyB = key
+		}
+	}
+
+	var started sync.WaitGroup
+	started.Add(2)
+	errs := make(chan error, 2)
+	_, _ = b.Subscribe("sync", HandlerFunc(func(ctx context.Context, msg Message) error {
+		other, ok := msg.Payload().(string)
+		if !ok {
+			return nil
+		}
+		// Both lanes are busy before either publishes to the other
+		started.Done()
+		started.Wait()
+		errs <- PublishUrgent(ContextWithPartitionKey(ctx, other), b, "sync", nil)
+		return nil
+	}))
+
+	ctx := context.Background()
+	_ = PublishWithKey(ctx, b, "sync", keyA, keyB)
+	_ = PublishWithKey(ctx, b, "sync", keyB, keyA)
+
+	for i := 0; i < 2; i++ {
+		select {
+		case err := <-errs:
+			if err != nil {
+				t.Fatalf("PublishUrgent() error = %v", err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("expected urgent publishes between two lanes not to deadlock")
+		}
+	}
+}
@@ -2,6 +2,7 @@ package scela
 
 import (
 	"context"
+	"errors"
 	"sync"
 	"testing"
 	"time"
@@ -75,3 +76,76 @@ func TestBusPublishWithPriority_ContextCanceled(t *testing.T) {
 		t.Errorf("Expected context.Canceled error, got %v", err)
 	}
 }
+
+func TestDeadLetter_KeepsPriority(t *testing.T) {
+	dlq := make(chan Message, 1)
+	bus := New(
+		WithMaxRetries(2),
+		WithDeadLetterHandler(HandlerFunc(func(ctx context.Context, msg Message) error {
+			dlq <- msg
+			return nil
+		})),
+	)
+	defer bus.Close()
+
+	_, err := bus.Subscribe("test", HandlerFunc(func(ctx context.Context, msg Message) error {
+		return errors.New("handler error")
+	}))
+	if err != nil {
+		t.Fatalf("Subscribe() error = %v", err)
+	}
+
+	if err := bus.PublishWithPriority(context.Background(), "test", "data", PriorityHigh); err != nil {
+		t.Fatalf("PublishWithPriority() error = %v", err)
+	}
+
+	select {
+	case msg := <-dlq:
+		if got := MessagePriority(msg); got != PriorityHigh {
+			t.Errorf("MessagePriority() = %v, want %v", got, PriorityHigh)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Dead letter handler was not called")
+	}
+}
+
+func TestFinalRetryPriority(t *testing.T) {
+	b := &bus{
+		queue:      newPriorityQueue(3, defaultPriorityWeights),
+		maxRetries: 3,
+		observers:  newObserverRegistry(),
+		retries:    newRetryTracker(),
+	}
+	WithFinalRetryPriority(PriorityUrgent)(b)
+
+	env := &Envelope{msg: NewMessage("test", nil), priority: PriorityLow}
+	r := b.queue.reader(0)
+
+	b.handleError(env)
+	if got, _ := r.next(); got.priority != PriorityLow {
+		t.Errorf("First retry priority = %v, want %v", got.priority, PriorityLow)
+	}
+
+	b.handleError(env)
+	if got, _ := r.next(); got.priority != PriorityUrgent {
+		t.Errorf("Final retry priority = %v, want %v", got.priority, PriorityUrgent)
+	}
+}
+
+func TestFinalRetryPriority_KeepsHigher(t *testing.T) {
+	b := &bus{
+		queue:      newPriorityQueue(1, defaultPriorityWeights),
+		maxRetries: 2,
+		observers:  newObserverRegistry(),
+		retries:    newRetryTracker(),
+	}
+	WithFinalRetryPriority(PriorityHigh)(b)
+
+	env := &Envelope{msg: NewMessage("test", nil), priority: PriorityUrgent}
+	r := b.queue.reader(0)
+
+	b.handleError(env)
+	if got, _ := r.next(); got.priority != PriorityUrgent {
+		t.Errorf("Final retry priority = %v, want %v", got.priority, PriorityUrgent)
+	}
+}
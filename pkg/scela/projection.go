@@ -0,0 +1,314 @@
+package scela
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// Projection builds a read model from messages.
+type Projection interface {
+	// Apply updates the read model with msg. Messages are applied at least
+	// once: a message applied just before a crash, or delivered live during
+	// a rebuild, is applied again, so Apply should be idempotent.
+	Apply(ctx context.Context, msg Message) error
+
+	// Reset clears the read model before it is rebuilt.
+	Reset(ctx context.Context) error
+}
+
+// ProjectorOption is a functional option for configuring a Projector.
+type ProjectorOption func(*Projector)
+
+// WithProjectionPattern restricts a projection to the topics matching
+// pattern. It defaults to "#", all topics.
+func WithProjectionPattern(pattern string) ProjectorOption {
+	return func(p *Projector) {
+		if pattern != "" {
+			p.pattern = pattern
+		}
+	}
+}
+
+// WithCheckpoints sets where a Projector keeps its checkpoint. It defaults
+// to an InMemoryCheckpointStore, which rebuilds the projection at every
+// start.
+func WithCheckpoints(store CheckpointStore) ProjectorOption {
+	return func(p *Projector) {
+		if store != nil {
+			p.checkpoints = store
+		}
+	}
+}
+
+// WithCheckpointEvery saves the checkpoint of a catch-up or rebuild every n
+// applied messages rather than after each one, trading messages applied
+// again after a crash for fewer writes. The checkpoint is always saved at
+// the end.
+func WithCheckpointEvery(n int) ProjectorOption {
+	return func(p *Projector) {
+		if n > 0 {
+			p.every = n
+		}
+	}
+}
+
+// Projector applies the messages of a store to a Projection in store order
+// and records its progress in a checkpoint, so that the read model catches
+// up from where it stopped and can be rebuilt deterministically from the
+// store. With Subscribe, it also applies the messages published live.
+type Projector struct {
+	name        string
+	pattern     string
+	projection  Projection
+	store       MessageStore
+	checkpoints CheckpointStore
+	every       int
+	matcher     *patternMatcher
+
+	// mu serializes applying messages and saving the checkpoint.
+	mu sync.Mutex
+	// next is the expected store position of the next message applied,
+	// recorded in the checkpoints of live messages.
+	next int
+	// live holds live messages during rebuilds, once subscribed.
+	live *backfillHandler
+}
+
+// NewProjector creates a projector named name, the key of its checkpoint,
+// applying the messages of store to projection.
+func NewProjector(name string, projection Projection, store MessageStore, opts ...ProjectorOption) *Projector {
+	p := &Projector{
+		name:        name,
+		pattern:     "#",
+		projection:  projection,
+		store:       store,
+		checkpoints: NewInMemoryCheckpointStore(),
+		every:       1,
+		matcher:     newPatternMatcher(),
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p
+}
+
+// Name returns the name of the projector.
+func (p *Projector) Name() string {
+	return p.name
+}
+
+// Checkpoint returns the checkpoint of the projector. It reports false if
+// no message was applied yet.
+func (p *Projector) Checkpoint(ctx context.Context) (Checkpoint, bool, error) {
+	return p.checkpoints.LoadCheckpoint(ctx, p.name)
+}
+
+// CatchUp applies the messages stored after the checkpoint message, in
+// store order, and returns how many were applied. When the checkpoint
+// message is no longer stored, for example after compaction, it carries on
+// from the first message with a later timestamp. If Apply fails, the
+// checkpoint records the messages applied before and the error is returned.
+func (p *Projector) CatchUp(ctx context.Context) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.catchUp(ctx, nil)
+}
+
+// RebuildProjection resets the projection and its checkpoint, then applies
+// every stored message from the start, returning how many were applied.
+// Live messages received meanwhile are held and applied afterwards,
+// skipping those the rebuild applied; their deliveries complete once
+// applied, so the bus retries or dead-letters those failing.
+func (p *Projector) RebuildProjection(ctx context.Context) (int, error) {
+	p.mu.Lock()
+	live := p.live
+	p.mu.Unlock()
+
+	var seen map[string]bool
+	if live != nil {
+		live.buffer()
+		seen = make(map[string]bool)
+	}
+
+	p.mu.Lock()
+	n, err := p.rebuild(ctx, seen)
+	p.mu.Unlock()
+
+	if live != nil {
+		live.goLive(seen)
+	}
+	return n, err
+}
+
+// rebuild resets the projection and applies every stored message.
+func (p *Projector) rebuild(ctx context.Context, seen map[string]bool) (int, error) {
+	if err := p.projection.Reset(ctx); err != nil {
+		return 0, fmt.Errorf("failed to reset projection %s: %w", p.name, err)
+	}
+	if err := p.checkpoints.DeleteCheckpoint(ctx, p.name); err != nil {
+		return 0, err
+	}
+	return p.catchUp(ctx, seen)
+}
+
+// Subscribe catches up, then applies the messages published on b matching
+// the pattern of the projector as they arrive, checkpointing each one.
+// Messages published during the catch-up are held and applied afterwards,
+// skipping those already applied. A failing Apply fails the delivery, held
+// or not, which the bus retries or dead-letters.
+//
+// Messages are applied one at a time, in delivery order; publish them with
+// a partition key, or on a bus with a single worker, to apply them in
+// publish order.
+func (p *Projector) Subscribe(ctx context.Context, b Subscriber) (Subscription, error) {
+	live := &backfillHandler{handler: HandlerFunc(p.applyLive)}
+
+	// Subscribe before loading so nothing published meanwhile is missed
+	sub, err := b.Subscribe(p.pattern, live)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	p.mu.Lock()
+	p.live = live
+	_, err = p.catchUp(ctx, seen)
+	p.mu.Unlock()
+
+	if err != nil {
+		live.abort(err)
+		_ = sub.Unsubscribe()
+		p.mu.Lock()
+		p.live = nil
+		p.mu.Unlock()
+		return nil, err
+	}
+	live.goLive(seen)
+	return sub, nil
+}
+
+// applyLive applies a message delivered by the bus.
+func (p *Projector) applyLive(ctx context.Context, msg Message) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, err := p.apply(ctx, []Message{msg}, p.next, nil)
+	return err
+}
+
+// catchUp applies the stored messages after the checkpoint, recording
+// their IDs in seen if not nil.
+func (p *Projector) catchUp(ctx context.Context, seen map[string]bool) (int, error) {
+	cp, ok, err := p.checkpoints.LoadCheckpoint(ctx, p.name)
+	if err != nil {
+		return 0, err
+	}
+	msgs, start, err := p.pending(ctx, cp, ok)
+	if err != nil {
+		return 0, err
+	}
+	n, err := p.apply(ctx, msgs, start, seen)
+	if err == nil {
+		p.next = start + len(msgs)
+	}
+	return n, err
+}
+
+// pending loads the stored messages after cp, all of them if ok is false,
+// and returns them with the store position of the first.
+func (p *Projector) pending(ctx context.Context, cp Checkpoint, ok bool) ([]Message, int, error) {
+	if qs, queryable := p.store.(QueryableStore); ok && queryable {
+		msgs, err := p.pageAfter(ctx, qs, cp)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to load messages of projection %s: %w", p.name, err)
+		}
+		if msgs != nil {
+			return msgs, cp.Position + 1, nil
+		}
+	}
+
+	msgs, err := p.store.Load(ctx)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to load messages of projection %s: %w", p.name, err)
+	}
+	if !ok {
+		return msgs, 0, nil
+	}
+
+	for i, msg := range msgs {
+		if msg.ID() == cp.MessageID {
+			return msgs[i+1:], i + 1, nil
+		}
+	}
+	// The checkpoint message is gone, carry on from the first message after
+	// its timestamp
+	for i, msg := range msgs {
+		if msg.Timestamp().After(cp.Timestamp) {
+			return msgs[i:], i, nil
+		}
+	}
+	return nil, len(msgs), nil
+}
+
+// pageAfter loads the messages stored after the position of cp. It returns
+// nil if the checkpoint message is no longer at that position.
+func (p *Projector) pageAfter(ctx context.Context, qs QueryableStore, cp Checkpoint) ([]Message, error) {
+	n, err := qs.Count(ctx)
+	if err != nil || cp.Position >= n {
+		return nil, err
+	}
+	msgs, err := qs.LoadPage(ctx, cp.Position, n-cp.Position)
+	if err != nil || len(msgs) == 0 || msgs[0].ID() != cp.MessageID {
+		return nil, err
+	}
+	return msgs[1:], nil
+}
+
+// apply applies the messages of msgs matching the pattern and checkpoints
+// them, recording their IDs in seen if not nil. The messages are stored
+// from position start on.
+func (p *Projector) apply(ctx context.Context, msgs []Message, start int, seen map[string]bool) (int, error) {
+	applied := 0
+	var last Message
+	var lastPosition int
+	for i, msg := range msgs {
+		if !p.matcher.Match(p.pattern, msg.Topic()) {
+			continue
+		}
+		if err := p.projection.Apply(ContextWithMessage(ctx, msg), msg); err != nil {
+			err = fmt.Errorf("projection %s failed at message %s: %w", p.name, msg.ID(), err)
+			if last != nil && applied%p.every != 0 {
+				if cpErr := p.save(ctx, last, lastPosition); cpErr != nil {
+					return applied, fmt.Errorf("%w (%v)", err, cpErr)
+				}
+			}
+			return applied, err
+		}
+		applied++
+		last, lastPosition = msg, start+i
+		p.next = lastPosition + 1
+		if seen != nil {
+			seen[msg.ID()] = true
+		}
+		if applied%p.every == 0 {
+			if err := p.save(ctx, msg, lastPosition); err != nil {
+				return applied, err
+			}
+		}
+	}
+	if last != nil && applied%p.every != 0 {
+		if err := p.save(ctx, last, lastPosition); err != nil {
+			return applied, err
+		}
+	}
+	return applied, nil
+}
+
+// save checkpoints msg, stored at position, as the last applied message.
+func (p *Projector) save(ctx context.Context, msg Message, position int) error {
+	return p.checkpoints.SaveCheckpoint(ctx, p.name, Checkpoint{
+		MessageID: msg.ID(),
+		Position:  position,
+		Timestamp: msg.Timestamp(),
+	})
+}
@@ -0,0 +1,266 @@
+package scela
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// orderTotals is a projection summing the order amounts per customer.
+type orderTotals struct {
+	mu     sync.Mutex
+	totals map[string]int
+	resets int
+	failOn string
+}
+
+func (p *orderTotals) Apply(ctx context.Context, msg Message) error {
+	if msg.ID() == p.failOn {
+		return errors.New("read model unavailable")
+	}
+	order := msg.Payload().(map[string]interface{})
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.totals[order["customer"].(string)] += order["amount"].(int)
+	return nil
+}
+
+func (p *orderTotals) Reset(ctx context.Context) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.totals = make(map[string]int)
+	p.resets++
+	return nil
+}
+
+func (p *orderTotals) total(customer string) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.totals[customer]
+}
+
+func storeOrder(t *testing.T, store MessageStore, topic, customer string, amount int) Message {
+	t.Helper()
+	msg := NewMessage(topic, map[string]interface{}{"customer": customer, "amount": amount})
+	if err := store.Store(context.Background(), msg); err != nil {
+		t.Fatalf("Store: %v", err)
+	}
+	return msg
+}
+
+func TestProjector_CatchUpAndRebuild(t *testing.T) {
+	store := NewInMemoryStore(100)
+	totals := &orderTotals{totals: make(map[string]int)}
+	projector := NewProjector("order-totals", totals, store, WithProjectionPattern("order.*"), WithCheckpointEvery(2))
+	ctx := context.Background()
+
+	storeOrder(t, store, "order.placed", "acme", 10)
+	storeOrder(t, store, "user.created", "acme", 1000)
+	last := storeOrder(t, store, "order.placed", "acme", 5)
+
+	if n, err := projector.CatchUp(ctx); err != nil || n != 2 {
+		t.Fatalf("CatchUp() = %d, %v", n, err)
+	}
+	if cp, ok, _ := projector.Checkpoint(ctx); !ok || cp.MessageID != last.ID() {
+		t.Errorf("expected the checkpoint at %s, got %+v", last.ID(), cp)
+	}
+
+	storeOrder(t, store, "order.placed", "globex", 7)
+	if n, err := projector.CatchUp(ctx); err != nil || n != 1 {
+		t.Fatalf("CatchUp() = %d, %v", n, err)
+	}
+	if n, _ := projector.CatchUp(ctx); n != 0 {
+		t.Errorf("expected nothing left to apply, got %d", n)
+	}
+
+	if n, err := projector.RebuildProjection(ctx); err != nil || n != 3 {
+		t.Fatalf("RebuildProjection() = %d, %v", n, err)
+	}
+	if totals.resets != 1 || totals.total("acme") != 15 || totals.total("globex") != 7 {
+		t.Errorf("unexpected read model after rebuild: %v, %d resets", totals.totals, totals.resets)
+	}
+}
+
+func TestProjector_ResumesAfterFailure(t *testing.T) {
+	store := NewInMemoryStore(100)
+	totals := &orderTotals{totals: make(map[string]int)}
+	projector := NewProjector("order-totals", totals, store)
+	ctx := context.Background()
+
+	first := storeOrder(t, store, "order.placed", "acme", 10)
+	failing := storeOrder(t, store, "order.placed", "acme", 5)
+	totals.failOn = failing.ID()
+
+	if n, err := projector.CatchUp(ctx); err == nil || n != 1 {
+		t.Fatalf("expected CatchUp to fail after 1 message, got %d, %v", n, err)
+	}
+	if cp, _, _ := projector.Checkpoint(ctx); cp.MessageID != first.ID() {
+		t.Errorf("expected the checkpoint at the last applied message, got %+v", cp)
+	}
+
+	totals.failOn = ""
+	if n, err := projector.CatchUp(ctx); err != nil || n != 1 {
+		t.Fatalf("CatchUp() = %d, %v", n, err)
+	}
+	if totals.total("acme") != 15 {
+		t.Errorf("expected each message applied once, got %d", totals.total("acme"))
+	}
+}
+
+func TestProjector_FollowsStoreOrder(t *testing.T) {
+	store := NewInMemoryStore(100)
+	totals := &orderTotals{totals: make(map[string]int)}
+	projector := NewProjector("order-totals", totals, store)
+	ctx := context.Background()
+
+	storeOrder(t, store, "order.placed", "acme", 1)
+	storeOrder(t, store, "order.placed", "acme", 10)
+	if _, err := projector.CatchUp(ctx); err != nil {
+		t.Fatalf("CatchUp: %v", err)
+	}
+
+	// A producer with a late clock stores a message dated an hour back
+	skewed := NewMessage("order.placed", map[string]interface{}{"customer": "acme", "amount": 5}).(*message)
+	skewed.timestamp = skewed.timestamp.Add(-time.Hour)
+	_ = store.Store(ctx, skewed)
+	if n, err := projector.CatchUp(ctx); err != nil || n != 1 {
+		t.Fatalf("CatchUp() = %d, %v, want the skewed message applied", n, err)
+	}
+
+	// Compaction moved the checkpoint message to another position
+	_ = store.Rewrite(ctx, func(msgs []Message) ([]Message, error) {
+		return msgs[1:], nil
+	})
+	storeOrder(t, store, "order.placed", "acme", 100)
+	if n, err := projector.CatchUp(ctx); err != nil || n != 1 {
+		t.Fatalf("CatchUp() = %d, %v, want only the new message applied", n, err)
+	}
+	if totals.total("acme") != 116 {
+		t.Errorf("expected each message applied once, got %d", totals.total("acme"))
+	}
+}
+
+func TestProjector_CheckpointMessageRemoved(t *testing.T) {
+	store := NewInMemoryStore(100)
+	totals := &orderTotals{totals: make(map[string]int)}
+	checkpoints := NewInMemoryCheckpointStore()
+	projector := NewProjector("order-totals", totals, store, WithCheckpoints(checkpoints))
+	ctx := context.Background()
+
+	applied := storeOrder(t, store, "order.placed", "acme", 10)
+	if _, err := projector.CatchUp(ctx); err != nil {
+		t.Fatalf("CatchUp: %v", err)
+	}
+	later := storeOrder(t, store, "order.placed", "acme", 5)
+
+	// Compaction removed the checkpoint message
+	_ = store.Rewrite(ctx, func(msgs []Message) ([]Message, error) {
+		return []Message{later}, nil
+	})
+	_ = checkpoints.SaveCheckpoint(ctx, "order-totals", Checkpoint{MessageID: applied.ID(), Timestamp: applied.Timestamp()})
+
+	if n, err := projector.CatchUp(ctx); err != nil || n != 1 {
+		t.Fatalf("CatchUp() = %d, %v", n, err)
+	}
+	if totals.total("acme") != 15 {
+		t.Errorf("expected the later message applied, got %d", totals.total("acme"))
+	}
+}
+
+func TestProjector_Subscribe(t *testing.T) {
+	store := NewInMemoryStore(100)
+	pb := NewPersistentBus(New(WithWorkers(1)), store)
+	defer pb.Close()
+
+	ctx := context.Background()
+	order := func(customer string, amount int) map[string]interface{} {
+		return map[string]interface{}{"customer": customer, "amount": amount}
+	}
+	storeOrder(t, store, "order.placed", "acme", 10)
+
+	totals := &orderTotals{totals: make(map[string]int)}
+	projector := NewProjector("order-totals", totals, store, WithProjectionPattern("order.*"))
+	sub, err := projector.Subscribe(ctx, pb)
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	defer sub.Unsubscribe()
+
+	_ = pb.Publish(ctx, "order.placed", order("acme", 5))
+	waitFor(t, func() bool { return totals.total("acme") == 15 })
+
+	if n, err := projector.RebuildProjection(ctx); err != nil || n != 2 {
+		t.Fatalf("RebuildProjection() = %d, %v", n, err)
+	}
+	_ = pb.Publish(ctx, "order.placed", order("acme", 1))
+	waitFor(t, func() bool { return totals.total("acme") == 16 })
+
+	if n, _ := projector.CatchUp(ctx); n != 0 {
+		t.Errorf("expected live messages checkpointed, got %d to catch up", n)
+	}
+}
+
+func TestProjector_RetriesHeldMessages(t *testing.T) {
+	store := NewInMemoryStore(100)
+	pb := NewPersistentBus(New(WithMaxRetries(3)), store)
+	defer pb.Close()
+	ctx := context.Background()
+
+	probe := &rebuildProbe{
+		orderTotals: &orderTotals{totals: make(map[string]int)},
+		rebuilding:  make(chan struct{}),
+	}
+	projector := NewProjector("order-totals", probe, store)
+
+	storeOrder(t, store, "order.placed", "acme", 10)
+	sub, err := projector.Subscribe(ctx, pb)
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	defer sub.Unsubscribe()
+
+	go func() {
+		<-probe.rebuilding
+		_ = pb.Publish(ctx, "order.placed", map[string]interface{}{"customer": "acme", "amount": 5})
+	}()
+	if _, err := projector.RebuildProjection(ctx); err != nil {
+		t.Fatalf("RebuildProjection: %v", err)
+	}
+
+	waitFor(t, func() bool { return probe.total("acme") == 15 })
+	if n := probe.attempts.Load(); n != 2 {
+		t.Errorf("expected the held message retried once, got %d attempts", n)
+	}
+}
+
+// rebuildProbe slows down rebuilds and fails the first order of 5.
+type rebuildProbe struct {
+	*orderTotals
+	rebuilding chan struct{}
+	once       sync.Once
+	reset      atomic.Bool
+	attempts   atomic.Int32
+}
+
+func (p *rebuildProbe) Apply(ctx context.Context, msg Message) error {
+	order := msg.Payload().(map[string]interface{})
+	if order["amount"] == 5 && p.attempts.Add(1) == 1 {
+		return errors.New("read model unavailable")
+	}
+	if p.reset.Load() {
+		p.once.Do(func() {
+			close(p.rebuilding)
+			// Slow rebuild so the live message is held
+			time.Sleep(20 * time.Millisecond)
+		})
+	}
+	return p.orderTotals.Apply(ctx, msg)
+}
+
+func (p *rebuildProbe) Reset(ctx context.Context) error {
+	p.reset.Store(true)
+	return p.orderTotals.Reset(ctx)
+}
@@ -0,0 +1,85 @@
+package scela
+
+import (
+	"context"
+	"time"
+)
+
+// WithContextPropagation carries the publisher's context over to handlers of
+// asynchronously published messages. The values stored under keys and the
+// publisher's deadline are captured at publish time and restored in the
+// handler context. Cancellation is not propagated, so a handler keeps running
+// after the publishing request returns.
+func WithContextPropagation(keys ...interface{}) Option {
+	return func(b *bus) {
+		b.propagate = true
+		b.propagateKeys = append(b.propagateKeys, keys...)
+	}
+}
+
+// deliveryDeadlineKey is the context key under which a per-message delivery
+// deadline is stored.
+type deliveryDeadlineKey struct{}
+
+// ContextWithDeliveryDeadline returns a context that gives messages published
+// with it a delivery deadline, independent of the deadline of ctx itself.
+// Handlers see the deadline on their context, and a message still queued
+// when its deadline passes is dropped instead of delivered. It takes
+// precedence over a deadline captured by WithContextPropagation.
+func ContextWithDeliveryDeadline(ctx context.Context, deadline time.Time) context.Context {
+	return context.WithValue(ctx, deliveryDeadlineKey{}, deadline)
+}
+
+// capturedContext is the part of a publisher's context carried to handlers.
+type capturedContext struct {
+	values   map[interface{}]interface{}
+	deadline time.Time
+}
+
+// captureContext records what the handlers of a message published with ctx
+// should see. It returns nil when there is nothing to carry.
+func (b *bus) captureContext(ctx context.Context) *capturedContext {
+	var c capturedContext
+
+	if deadline, ok := ctx.Value(deliveryDeadlineKey{}).(time.Time); ok {
+		c.deadline = deadline
+	} else if b.propagate {
+		c.deadline, _ = ctx.Deadline()
+	}
+
+	if b.propagate {
+		for _, key := range b.propagateKeys {
+			if v := ctx.Value(key); v != nil {
+				if c.values == nil {
+					c.values = make(map[interface{}]interface{}, len(b.propagateKeys))
+				}
+				c.values[key] = v
+			}
+		}
+	}
+
+	if c.values == nil && c.deadline.IsZero() {
+		return nil
+	}
+	return &c
+}
+
+// expired reports whether the delivery deadline has passed.
+func (c *capturedContext) expired() bool {
+	return c != nil && !c.deadline.IsZero() && !time.Now().Before(c.deadline)
+}
+
+// restore returns a handler context built from ctx with the captured values
+// and deadline.
+func (c *capturedContext) restore(ctx context.Context) (context.Context, context.CancelFunc) {
+	if c == nil {
+		return ctx, func() {}
+	}
+	for key, v := range c.values {
+		ctx = context.WithValue(ctx, key, v)
+	}
+	if !c.deadline.IsZero() {
+		return context.WithDeadline(ctx, c.deadline)
+	}
+	return ctx, func() {}
+}
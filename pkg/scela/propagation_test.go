@@ -0,0 +1,129 @@
+package scela
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+type traceKey struct{}
+
+func TestContextPropagation_Values(t *testing.T) {
+	bus := New(WithContextPropagation(traceKey{}))
+	defer bus.Close()
+
+	got := make(chan interface{}, 1)
+	_, _ = bus.Subscribe("test", HandlerFunc(func(ctx context.Context, msg Message) error {
+		got <- ctx.Value(traceKey{})
+		return nil
+	}))
+
+	ctx, cancel := context.WithCancel(context.WithValue(context.Background(), traceKey{}, "trace-1"))
+	if err := bus.Publish(ctx, "test", nil); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	// Cancelling the publisher must not cancel the handler
+	cancel()
+
+	select {
+	case v := <-got:
+		if v != "trace-1" {
+			t.Errorf("expected propagated value trace-1, got %v", v)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("handler was not called")
+	}
+}
+
+func TestContextPropagation_DisabledByDefault(t *testing.T) {
+	bus := New()
+	defer bus.Close()
+
+	got := make(chan interface{}, 1)
+	_, _ = bus.Subscribe("test", HandlerFunc(func(ctx context.Context, msg Message) error {
+		got <- ctx.Value(traceKey{})
+		return nil
+	}))
+
+	ctx := context.WithValue(context.Background(), traceKey{}, "trace-1")
+	_ = bus.Publish(ctx, "test", nil)
+
+	if v := <-got; v != nil {
+		t.Errorf("expected no propagated value, got %v", v)
+	}
+}
+
+func TestContextPropagation_Deadline(t *testing.T) {
+	bus := New(WithContextPropagation())
+	defer bus.Close()
+
+	got := make(chan time.Time, 1)
+	_, _ = bus.Subscribe("test", HandlerFunc(func(ctx context.Context, msg Message) error {
+		deadline, _ := ctx.Deadline()
+		got <- deadline
+		return nil
+	}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	want, _ := ctx.Deadline()
+	_ = bus.Publish(ctx, "test", nil)
+
+	if deadline := <-got; !deadline.Equal(want) {
+		t.Errorf("expected handler deadline %v, got %v", want, deadline)
+	}
+}
+
+func TestDeliveryDeadline_DropsExpiredMessages(t *testing.T) {
+	bus := New(WithWorkers(1))
+	defer bus.Close()
+
+	release := make(chan struct{})
+	delivered := make(chan string, 2)
+	_, _ = bus.Subscribe("block", HandlerFunc(func(ctx context.Context, msg Message) error {
+		<-release
+		return nil
+	}))
+	_, _ = bus.Subscribe("test", HandlerFunc(func(ctx context.Context, msg Message) error {
+		delivered <- msg.Payload().(string)
+		return nil
+	}))
+
+	// Hold the only worker so the deadline passes while the message is queued
+	_ = bus.Publish(context.Background(), "block", nil)
+	ctx := ContextWithDeliveryDeadline(context.Background(), time.Now().Add(20*time.Millisecond))
+	_ = bus.Publish(ctx, "test", "expired")
+	_ = bus.Publish(context.Background(), "test", "fresh")
+
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+
+	if p := <-delivered; p != "fresh" {
+		t.Errorf("expected the expired message to be dropped, got %q", p)
+	}
+}
+
+func TestDeliveryDeadline_PublishSync(t *testing.T) {
+	bus := New()
+	defer bus.Close()
+
+	var deadline time.Time
+	_, _ = bus.Subscribe("test", HandlerFunc(func(ctx context.Context, msg Message) error {
+		deadline, _ = ctx.Deadline()
+		return nil
+	}))
+
+	want := time.Now().Add(time.Minute)
+	if err := bus.PublishSync(ContextWithDeliveryDeadline(context.Background(), want), "test", nil); err != nil {
+		t.Fatalf("PublishSync: %v", err)
+	}
+	if !deadline.Equal(want) {
+		t.Errorf("expected handler deadline %v, got %v", want, deadline)
+	}
+
+	past := ContextWithDeliveryDeadline(context.Background(), time.Now().Add(-time.Second))
+	if err := bus.PublishSync(past, "test", nil); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected DeadlineExceeded for an expired message, got %v", err)
+	}
+}
@@ -0,0 +1,305 @@
+package scela
+
+import (
+	"context"
+	"time"
+)
+
+// priorityLevels is the number of priority levels, from PriorityLow to
+// PriorityUrgent.
+const priorityLevels = int(PriorityUrgent) + 1
+
+// defaultPriorityWeights is the share of worker turns given to each level.
+var defaultPriorityWeights = map[Priority]int{
+	PriorityLow:    1,
+	PriorityNormal: 2,
+	PriorityHigh:   4,
+	PriorityUrgent: 8,
+}
+
+// WithPriorityWeights sets the share of worker turns given to each priority
+// level when several levels have messages waiting. By default the weights
+// are 1, 2, 4 and 8 from PriorityLow to PriorityUrgent, so urgent messages
+// are taken eight times as often as low-priority ones without starving them.
+// Levels missing from weights, or with a weight below 1, get a weight of 1.
+func WithPriorityWeights(weights map[Priority]int) Option {
+	return func(b *bus) {
+		b.weights = weights
+	}
+}
+
+// defaultQueueSize is the number of messages buffered per priority level.
+const defaultQueueSize = 1000
+
+// dispatchQueue buffers messages between publishers and workers.
+type dispatchQueue interface {
+	// push enqueues env, handling a full queue according to policy. It
+	// returns the messages dropped to apply the policy.
+	push(ctx context.Context, env *Envelope, policy OverflowPolicy) ([]*Envelope, error)
+	// reader returns the source the given worker takes messages from.
+	reader(worker int) envelopeSource
+	len() int
+	cap() int
+	depths() map[Priority]int
+	// close stops the readers once the queued messages are taken.
+	close()
+}
+
+// envelopeSource is the end of a dispatchQueue read by one worker.
+type envelopeSource interface {
+	// next returns the next message, waiting for one if needed. It returns
+	// false once the queue is closed and drained.
+	next() (*Envelope, bool)
+}
+
+// priorityQueue holds one bounded queue per priority level. Workers drain
+// the levels in a weighted round-robin order, so publishers of different
+// priorities do not contend on a single channel.
+type priorityQueue struct {
+	levels [priorityLevels]chan *Envelope
+
+	// schedule is the weighted order in which workers prefer the levels.
+	schedule []Priority
+
+	// aging promotes waiting messages, see WithPriorityAging; nil when
+	// disabled.
+	aging *agingState
+}
+
+// newPriorityQueue creates a queue holding up to capacity messages per level.
+func newPriorityQueue(capacity int, weights map[Priority]int) *priorityQueue {
+	q := &priorityQueue{schedule: weightedSchedule(weights)}
+	for i := range q.levels {
+		q.levels[i] = make(chan *Envelope, capacity)
+	}
+	return q
+}
+
+// weightedSchedule spreads each level over a cycle as many times as its
+// weight, interleaving the levels (smooth weighted round-robin) so that a
+// heavy level does not take all its turns in a row.
+func weightedSchedule(weights map[Priority]int) []Priority {
+	var w [priorityLevels]int
+	total := 0
+	for i := range w {
+		w[i] = weights[Priority(i)]
+		if w[i] < 1 {
+			w[i] = 1
+		}
+		total += w[i]
+	}
+
+	schedule := make([]Priority, 0, total)
+	var current [priorityLevels]int
+	for len(schedule) < total {
+		best := 0
+		for i := range current {
+			current[i] += w[i]
+			// Ties go to the higher priority
+			if current[i] >= current[best] {
+				best = i
+			}
+		}
+		current[best] -= total
+		schedule = append(schedule, Priority(best))
+	}
+	return schedule
+}
+
+// clampPriority maps out-of-range priorities to the nearest level.
+func clampPriority(priority Priority) Priority {
+	if priority < PriorityLow {
+		return PriorityLow
+	}
+	if priority > PriorityUrgent {
+		return PriorityUrgent
+	}
+	return priority
+}
+
+// level returns the queue for priority.
+func (q *priorityQueue) level(priority Priority) chan *Envelope {
+	return q.levels[clampPriority(priority)]
+}
+
+// push enqueues env on the queue of its priority.
+func (q *priorityQueue) push(ctx context.Context, env *Envelope, policy OverflowPolicy) ([]*Envelope, error) {
+	if q.aging != nil {
+		env.queuedAt = time.Now()
+	}
+	return pushChan(ctx, q.level(env.priority), env, policy)
+}
+
+// pushChan sends env on ch, handling a full channel according to policy. It
+// returns the messages dropped to apply the policy.
+func pushChan(ctx context.Context, ch chan *Envelope, env *Envelope, policy OverflowPolicy) ([]*Envelope, error) {
+	if policy == OverflowBlock {
+		select {
+		case ch <- env:
+			return nil, nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	var dropped []*Envelope
+	for {
+		select {
+		case ch <- env:
+			return dropped, nil
+		default:
+		}
+
+		switch policy {
+		case OverflowDropNewest:
+			return []*Envelope{env}, nil
+		case OverflowDropOldest:
+			// Workers may empty the channel meanwhile; then just try again
+			select {
+			case old := <-ch:
+				dropped = append(dropped, old)
+			default:
+			}
+		default:
+			return nil, queueFull(env)
+		}
+	}
+}
+
+// len returns the number of queued messages.
+func (q *priorityQueue) len() int {
+	n := 0
+	for _, ch := range q.levels {
+		n += len(ch)
+	}
+	if q.aging != nil {
+		for _, held := range q.aging.held() {
+			n += held
+		}
+	}
+	return n
+}
+
+// cap returns the total capacity of the queue.
+func (q *priorityQueue) cap() int {
+	n := 0
+	for _, ch := range q.levels {
+		n += cap(ch)
+	}
+	return n
+}
+
+// depths returns the number of queued messages per level.
+func (q *priorityQueue) depths() map[Priority]int {
+	depths := make(map[Priority]int, priorityLevels)
+	for i, ch := range q.levels {
+		depths[Priority(i)] = len(ch)
+	}
+	if q.aging != nil {
+		for i, held := range q.aging.held() {
+			depths[Priority(i)] += held
+		}
+	}
+	return depths
+}
+
+// close stops accepting messages. Readers still receive the queued ones.
+func (q *priorityQueue) close() {
+	for _, ch := range q.levels {
+		close(ch)
+	}
+}
+
+// reader returns the view of the queue used by one worker. Workers start at
+// different points of the schedule so they do not all prefer the same level
+// at the same time.
+func (q *priorityQueue) reader(start int) envelopeSource {
+	if q.aging != nil {
+		return &agingReader{
+			aging:    q.aging,
+			schedule: q.schedule,
+			pos:      start % len(q.schedule),
+			levels:   q.levels,
+		}
+	}
+	return &queueReader{
+		schedule: q.schedule,
+		pos:      start % len(q.schedule),
+		levels:   q.levels,
+	}
+}
+
+// queueReader takes messages from a priorityQueue for one worker. Levels
+// found closed and empty are set to nil.
+type queueReader struct {
+	schedule []Priority
+	pos      int
+	levels   [priorityLevels]chan *Envelope
+}
+
+// next returns the next message, waiting for one if all levels are empty. It
+// returns false once the queue is closed and drained.
+func (r *queueReader) next() (*Envelope, bool) {
+	for {
+		// Take from the scheduled level, or from the highest non-empty one
+		preferred := r.schedule[r.pos]
+		r.pos = (r.pos + 1) % len(r.schedule)
+		if env := r.poll(preferred); env != nil {
+			return env, true
+		}
+		for p := PriorityUrgent; p >= PriorityLow; p-- {
+			if env := r.poll(p); env != nil {
+				return env, true
+			}
+		}
+
+		if r.drained() {
+			return nil, false
+		}
+
+		// All levels are empty: wait for the first message on any of them
+		var (
+			env *Envelope
+			ok  bool
+			p   Priority
+		)
+		select {
+		case env, ok = <-r.levels[PriorityUrgent]:
+			p = PriorityUrgent
+		case env, ok = <-r.levels[PriorityHigh]:
+			p = PriorityHigh
+		case env, ok = <-r.levels[PriorityNormal]:
+			p = PriorityNormal
+		case env, ok = <-r.levels[PriorityLow]:
+			p = PriorityLow
+		}
+		if ok {
+			return env, true
+		}
+		r.levels[p] = nil
+	}
+}
+
+// poll takes a message from a level without waiting, or returns nil.
+func (r *queueReader) poll(p Priority) *Envelope {
+	select {
+	case env, ok := <-r.levels[p]:
+		if !ok {
+			r.levels[p] = nil
+			return nil
+		}
+		return env
+	default:
+		return nil
+	}
+}
+
+// drained reports whether all levels are closed and empty.
+func (r *queueReader) drained() bool {
+	for _, ch := range r.levels {
+		if ch != nil {
+			return false
+		}
+	}
+	return true
+}
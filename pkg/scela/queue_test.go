@@ -0,0 +1,174 @@
+package scela
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWeightedSchedule(t *testing.T) {
+	schedule := weightedSchedule(defaultPriorityWeights)
+	if len(schedule) != 15 {
+		t.Fatalf("expected 15 turns, got %d: %v", len(schedule), schedule)
+	}
+
+	counts := make(map[Priority]int)
+	for _, p := range schedule {
+		counts[p]++
+	}
+	for p, w := range defaultPriorityWeights {
+		if counts[p] != w {
+			t.Errorf("expected %d turns for %v, got %d", w, p, counts[p])
+		}
+	}
+
+	// Urgent turns are interleaved rather than taken in a row
+	if schedule[0] != PriorityUrgent || schedule[1] == PriorityUrgent {
+		t.Errorf("expected interleaved schedule, got %v", schedule)
+	}
+
+	// Missing and invalid weights count as 1
+	if got := weightedSchedule(map[Priority]int{PriorityHigh: 0}); len(got) != priorityLevels {
+		t.Errorf("expected one turn per level, got %v", got)
+	}
+}
+
+func TestPriorityQueue_WeightedDrain(t *testing.T) {
+	q := newPriorityQueue(100, defaultPriorityWeights)
+	for i := 0; i < 30; i++ {
+		for _, p := range []Priority{PriorityLow, PriorityNormal, PriorityHigh, PriorityUrgent} {
+			if _, err := q.push(context.Background(), &Envelope{priority: p}, OverflowBlock); err != nil {
+				t.Fatalf("push() error = %v", err)
+			}
+		}
+	}
+	if q.len() != 120 || q.cap() != 400 {
+		t.Fatalf("unexpected len %d and cap %d", q.len(), q.cap())
+	}
+
+	// One full cycle over a backlog follows the weights
+	r := q.reader(0)
+	counts := make(map[Priority]int)
+	for i := 0; i < 15; i++ {
+		env, _ := r.next()
+		counts[env.priority]++
+	}
+	for p, w := range defaultPriorityWeights {
+		if counts[p] != w {
+			t.Errorf("expected %d messages of %v, got %d", w, p, counts[p])
+		}
+	}
+
+	// Closing keeps the queued messages for the readers
+	q.close()
+	n := 15
+	for {
+		if _, ok := r.next(); !ok {
+			break
+		}
+		n++
+	}
+	if n != 120 {
+		t.Errorf("expected 120 messages drained, got %d", n)
+	}
+}
+
+func TestPriorityQueue_EmptyLevelsFallBack(t *testing.T) {
+	q := newPriorityQueue(10, defaultPriorityWeights)
+	r := q.reader(0)
+
+	// Only low-priority messages are waiting, so every turn takes one
+	for i := 0; i < 3; i++ {
+		_, _ = q.push(context.Background(), &Envelope{priority: PriorityLow}, OverflowBlock)
+	}
+	for i := 0; i < 3; i++ {
+		if env, ok := r.next(); !ok || env.priority != PriorityLow {
+			t.Fatalf("expected a low-priority message, got %v, %v", env, ok)
+		}
+	}
+
+	// Out-of-range priorities are clamped
+	_, _ = q.push(context.Background(), &Envelope{priority: Priority(42)}, OverflowBlock)
+	if depths := q.depths(); depths[PriorityUrgent] != 1 {
+		t.Errorf("expected clamped message on the urgent level, got %v", depths)
+	}
+}
+
+func TestPriorityQueue_PushCanceled(t *testing.T) {
+	q := newPriorityQueue(1, defaultPriorityWeights)
+	_, _ = q.push(context.Background(), &Envelope{priority: PriorityNormal}, OverflowBlock)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := q.push(ctx, &Envelope{priority: PriorityNormal}, OverflowBlock); err != context.DeadlineExceeded {
+		t.Errorf("expected DeadlineExceeded on a full level, got %v", err)
+	}
+
+	// Other levels have their own room
+	if _, err := q.push(context.Background(), &Envelope{priority: PriorityHigh}, OverflowBlock); err != nil {
+		t.Errorf("expected room on another level, got %v", err)
+	}
+}
+
+func TestBus_PriorityOrder(t *testing.T) {
+	bus := New(WithWorkers(1))
+	defer bus.Close()
+
+	release := make(chan struct{})
+	var mu sync.Mutex
+	var order []string
+	done := make(chan struct{}, 3)
+
+	_, _ = bus.Subscribe("block", HandlerFunc(func(ctx context.Context, msg Message) error {
+		<-release
+		return nil
+	}))
+	_, _ = bus.Subscribe("work", HandlerFunc(func(ctx context.Context, msg Message) error {
+		mu.Lock()
+		order = append(order, msg.Payload().(string))
+		mu.Unlock()
+		done <- struct{}{}
+		return nil
+	}))
+
+	ctx := context.Background()
+	_ = bus.Publish(ctx, "block", nil)
+	waitFor(t, func() bool {
+		stats, _ := StatsOf(bus)
+		return stats.QueueDepth == 0
+	})
+
+	// Queue a backlog while the only worker is busy
+	_ = bus.PublishWithPriority(ctx, "work", "low", PriorityLow)
+	_ = bus.PublishWithPriority(ctx, "work", "normal", PriorityNormal)
+	_ = bus.PublishWithPriority(ctx, "work", "urgent", PriorityUrgent)
+
+	stats, _ := StatsOf(bus)
+	if stats.QueueDepth != 3 || stats.QueueDepths[PriorityUrgent] != 1 || stats.QueueDepths[PriorityHigh] != 0 {
+		t.Errorf("unexpected queue depths %+v", stats)
+	}
+
+	close(release)
+	for i := 0; i < 3; i++ {
+		<-done
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if order[0] != "urgent" {
+		t.Errorf("expected the urgent message first, got %v", order)
+	}
+}
+
+// waitFor polls cond until it holds or a second has passed.
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met in time")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
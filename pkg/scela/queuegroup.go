@@ -0,0 +1,97 @@
+package scela
+
+import (
+	"errors"
+	"fmt"
+)
+
+// WithQueueGroup makes the subscription a member of the named queue group.
+// Each message reaches exactly one member of a group among the
+// subscriptions it matches, taking turns, so that handlers can share the
+// load of a topic. Subscriptions outside the group still receive every
+// message. Retries of a failed message go back to the same member.
+//
+// With WithManualAck, the member given a message claims it for the
+// visibility timeout: if it does not acknowledge the message in time, or
+// unsubscribes first, the message is redelivered to another member, so that
+// a consumer dying mid-processing does not hold it. Redeliveries count as
+// retries.
+func WithQueueGroup(group string) SubscriptionOption {
+	return func(c *subscriptionConfig) {
+		c.queueGroup = group
+	}
+}
+
+// QueueSubscribe subscribes handler to pattern as a member of the named
+// queue group, see WithQueueGroup.
+func QueueSubscribe(b Subscriber, pattern, group string, handler Handler, opts ...SubscriptionOption) (Subscription, error) {
+	if group == "" {
+		return nil, fmt.Errorf("queue group cannot be empty")
+	}
+	return b.SubscribeWithOptions(pattern, handler, append(opts, WithQueueGroup(group))...)
+}
+
+// pickQueueMembers keeps one member of each queue group among subs, in
+// turn, along with every subscription outside a group. The order of subs is
+// preserved.
+func (sr *subscriptionRegistry) pickQueueMembers(subs []*subscription) []*subscription {
+	var members map[string][]*subscription
+	for _, sub := range subs {
+		if g := sub.config.queueGroup; g != "" {
+			if members == nil {
+				members = make(map[string][]*subscription)
+			}
+			members[g] = append(members[g], sub)
+		}
+	}
+	if members == nil {
+		return subs
+	}
+
+	chosen := make(map[*subscription]bool, len(members))
+	for g, group := range members {
+		chosen[group[sr.takeTurn(g, len(group))]] = true
+	}
+
+	picked := subs[:0:0]
+	for _, sub := range subs {
+		if sub.config.queueGroup == "" || chosen[sub] {
+			picked = append(picked, sub)
+		}
+	}
+	return picked
+}
+
+// takeTurn returns the index of the member of group, among n, whose turn
+// it is.
+func (sr *subscriptionRegistry) takeTurn(group string, n int) int {
+	sr.queueMu.Lock()
+	defer sr.queueMu.Unlock()
+
+	turn := sr.queueTurns[group] % uint64(n)
+	sr.queueTurns[group]++
+	return int(turn)
+}
+
+// claimant returns the subscription a retry is delivered to. It is the one
+// the message failed on, unless that one is a queue group member which did
+// not acknowledge the message in time or left: another member of the group
+// then claims the message, if there is one.
+func (b *bus) claimant(env *Envelope) *subscription {
+	sub := env.sub
+	group := sub.config.queueGroup
+	if group == "" || (!sub.isRemoved() && !errors.Is(env.err, ErrNotAcknowledged)) {
+		return sub
+	}
+
+	var others []*subscription
+	for _, s := range b.matchingSubscriptions(env.msg.Topic()) {
+		if s != sub && s.config.queueGroup == group {
+			others = append(others, s)
+		}
+	}
+	if len(others) == 0 {
+		return sub
+	}
+	return others[b.registry.takeTurn(group, len(others))]
+}
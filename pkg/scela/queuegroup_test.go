@@ -0,0 +1,196 @@
+package scela
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingHandler counts the messages it handles.
+func countingHandler(n *atomic.Int32) Handler {
+	return HandlerFunc(func(ctx context.Context, msg Message) error {
+		n.Add(1)
+		return nil
+	})
+}
+
+func TestQueueSubscribe_DistributesFairly(t *testing.T) {
+	b := New()
+	defer b.Close()
+
+	var counts [3]atomic.Int32
+	for i := range counts {
+		if _, err := QueueSubscribe(b, "orders.*", "workers", countingHandler(&counts[i])); err != nil {
+			t.Fatalf("QueueSubscribe() error = %v", err)
+		}
+	}
+
+	ctx := context.Background()
+	for i := 0; i < 300; i++ {
+		if err := b.PublishSync(ctx, "orders.created", i); err != nil {
+			t.Fatalf("PublishSync() error = %v", err)
+		}
+	}
+
+	for i := range counts {
+		if n := counts[i].Load(); n != 100 {
+			t.Errorf("member %d handled %d messages, want 100", i, n)
+		}
+	}
+}
+
+func TestQueueSubscribe_DistributesAsync(t *testing.T) {
+	b := New(WithWorkers(4))
+	defer b.Close()
+
+	var counts [4]atomic.Int32
+	for i := range counts {
+		_, _ = QueueSubscribe(b, "jobs", "workers", countingHandler(&counts[i]))
+	}
+
+	ctx := context.Background()
+	for i := 0; i < 400; i++ {
+		_ = b.Publish(ctx, "jobs", i)
+	}
+
+	waitFor(t, func() bool {
+		total := int32(0)
+		for i := range counts {
+			total += counts[i].Load()
+		}
+		return total == 400
+	})
+	for i := range counts {
+		if n := counts[i].Load(); n != 100 {
+			t.Errorf("member %d handled %d messages, want 100", i, n)
+		}
+	}
+}
+
+func TestQueueSubscribe_GroupsAndPlainSubscribers(t *testing.T) {
+	b := New()
+	defer b.Close()
+
+	var plain, audit atomic.Int32
+	var workers [2]atomic.Int32
+	_, _ = b.Subscribe("orders", countingHandler(&plain))
+	_, _ = QueueSubscribe(b, "orders", "audit", countingHandler(&audit))
+	for i := range workers {
+		_, _ = QueueSubscribe(b, "orders", "workers", countingHandler(&workers[i]))
+	}
+
+	for i := 0; i < 10; i++ {
+		_ = b.PublishSync(context.Background(), "orders", i)
+	}
+
+	if plain.Load() != 10 {
+		t.Errorf("plain subscriber handled %d messages, want 10", plain.Load())
+	}
+	if audit.Load() != 10 {
+		t.Errorf("single member group handled %d messages, want 10", audit.Load())
+	}
+	if workers[0].Load() != 5 || workers[1].Load() != 5 {
+		t.Errorf("workers handled %d and %d messages, want 5 each", workers[0].Load(), workers[1].Load())
+	}
+}
+
+func TestQueueSubscribe_MemberLeaves(t *testing.T) {
+	b := New()
+	defer b.Close()
+
+	var stays, leaves atomic.Int32
+	_, _ = QueueSubscribe(b, "jobs", "workers", countingHandler(&stays))
+	sub, _ := QueueSubscribe(b, "jobs", "workers", countingHandler(&leaves))
+	if err := sub.Unsubscribe(); err != nil {
+		t.Fatalf("Unsubscribe() error = %v", err)
+	}
+
+	for i := 0; i < 5; i++ {
+		_ = b.PublishSync(context.Background(), "jobs", i)
+	}
+	if stays.Load() != 5 || leaves.Load() != 0 {
+		t.Errorf("expected the remaining member to handle all 5 messages, got %d and %d", stays.Load(), leaves.Load())
+	}
+}
+
+func TestQueueSubscribe_RetriesSameMember(t *testing.T) {
+	b := New(WithMaxRetries(2))
+	defer b.Close()
+
+	var failing, other atomic.Int32
+	_, _ = QueueSubscribe(b, "jobs", "workers", HandlerFunc(func(ctx context.Context, msg Message) error {
+		if failing.Add(1) == 1 {
+			return errors.New("temporary failure")
+		}
+		return nil
+	}))
+	_, _ = QueueSubscribe(b, "jobs", "workers", countingHandler(&other))
+
+	_ = b.Publish(context.Background(), "jobs", "job")
+
+	waitFor(t, func() bool { return failing.Load() == 2 })
+	if other.Load() != 0 {
+		t.Errorf("expected the retry to reach the failing member, other member handled %d", other.Load())
+	}
+}
+
+func TestQueueSubscribe_EmptyGroup(t *testing.T) {
+	b := New()
+	defer b.Close()
+
+	if _, err := QueueSubscribe(b, "jobs", "", countingHandler(new(atomic.Int32))); err == nil {
+		t.Error("expected an error for an empty group")
+	}
+	sub, _ := QueueSubscribe(b, "jobs", "workers", countingHandler(new(atomic.Int32)))
+	infos := b.(*bus).registry.List()
+	if len(infos) != 1 || infos[0].QueueGroup != "workers" || infos[0].ID != sub.(*subscription).id {
+		t.Errorf("unexpected subscription info %+v", infos)
+	}
+}
+
+func TestQueueSubscribe_RedeliversUnacknowledged(t *testing.T) {
+	b := New(WithMaxRetries(3))
+	defer b.Close()
+
+	var stuck, acked atomic.Int32
+	// The first member takes the message and dies without acknowledging it
+	_, _ = QueueSubscribe(b, "jobs", "workers", HandlerFunc(func(ctx context.Context, msg Message) error {
+		stuck.Add(1)
+		return nil
+	}), WithManualAck(20*time.Millisecond))
+	_, _ = QueueSubscribe(b, "jobs", "workers", HandlerFunc(func(ctx context.Context, msg Message) error {
+		acker, _ := AckerFromContext(ctx)
+		acked.Add(1)
+		return acker.Ack()
+	}), WithManualAck(time.Second))
+
+	_ = b.Publish(context.Background(), "jobs", "job")
+
+	waitFor(t, func() bool { return acked.Load() == 1 })
+	if stuck.Load() != 1 {
+		t.Errorf("expected the first member to see the message once, got %d", stuck.Load())
+	}
+}
+
+func TestQueueSubscribe_RedeliversFromLeftMember(t *testing.T) {
+	b := New(WithMaxRetries(3))
+	defer b.Close()
+
+	var leaving Subscription
+	var left, other atomic.Int32
+	leaving, _ = QueueSubscribe(b, "jobs", "workers", HandlerFunc(func(ctx context.Context, msg Message) error {
+		left.Add(1)
+		_ = leaving.Unsubscribe()
+		return errors.New("shutting down")
+	}))
+	_, _ = QueueSubscribe(b, "jobs", "workers", countingHandler(&other))
+
+	_ = b.Publish(context.Background(), "jobs", "job")
+
+	waitFor(t, func() bool { return other.Load() == 1 })
+	if left.Load() != 1 {
+		t.Errorf("expected the leaving member to see the message once, got %d", left.Load())
+	}
+}
@@ -0,0 +1,485 @@
+package scela
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrQuotaExceeded is matched by the errors returned when a publish would
+// exceed a tenant quota.
+var ErrQuotaExceeded = errors.New("quota exceeded")
+
+// QuotaResource names a resource limited by a Quota.
+type QuotaResource string
+
+const (
+	// QuotaPublishRate limits how fast a tenant publishes.
+	QuotaPublishRate QuotaResource = "publish_rate"
+	// QuotaQueueShare limits how much of the queue a tenant's messages take.
+	QuotaQueueShare QuotaResource = "queue_share"
+	// QuotaStoreBytes limits the size of a tenant's stored messages.
+	QuotaStoreBytes QuotaResource = "store_bytes"
+)
+
+// QuotaError is returned when a publish would exceed the quota of a tenant.
+// Nothing of the publish was sent.
+type QuotaError struct {
+	Tenant   string
+	Resource QuotaResource
+}
+
+// Error implements the error interface.
+func (e *QuotaError) Error() string {
+	return fmt.Sprintf("tenant %q exceeded its %s quota", e.Tenant, e.Resource)
+}
+
+// Is reports whether target is ErrQuotaExceeded.
+func (e *QuotaError) Is(target error) bool {
+	return target == ErrQuotaExceeded
+}
+
+// Quota limits the resources a tenant uses on a shared bus. Zero fields do
+// not limit anything.
+type Quota struct {
+	// PublishRate is the number of messages per second the tenant may
+	// publish on average.
+	PublishRate float64
+	// PublishBurst is the number of messages the tenant may publish at once
+	// above the rate. It defaults to the rate rounded up.
+	PublishBurst int
+	// QueueShare is the fraction of the queue capacity, between 0 and 1,
+	// the tenant's messages may take while waiting for a worker.
+	QueueShare float64
+	// StoreBytes is the size of the payloads, serialized as JSON, the
+	// tenant may have stored.
+	StoreBytes int64
+}
+
+// QuotaUsage reports the quota of a tenant and what it uses of it.
+type QuotaUsage struct {
+	Quota Quota
+	// Published is the number of messages admitted.
+	Published int64
+	// Rejected is the number of messages rejected, per resource.
+	Rejected map[QuotaResource]int64
+	// Queued is the number of messages waiting for a worker.
+	Queued int
+	// StoredBytes is the size of the stored payloads.
+	StoredBytes int64
+}
+
+// TopicNamespace returns the namespace of topic: its first segment, or the
+// whole topic if it has a single one. It is the default tenant of QuotaBus.
+func TopicNamespace(topic string) string {
+	namespace, _, _ := strings.Cut(topic, ".")
+	return namespace
+}
+
+// QuotaBus enforces per-tenant quotas on a bus shared by several tenants,
+// so that one tenant cannot starve the others: a publish exceeding the
+// publish rate, queue share or store bytes of its tenant fails with a
+// QuotaError. Tenants are topic namespaces unless WithTenantFunc says
+// otherwise.
+//
+// Wrap a PersistentBus with the QuotaBus, not the other way around, so
+// that rejected messages are not stored. Stored bytes are counted as
+// messages are published; call RefreshUsage to account for messages
+// removed from the store since.
+type QuotaBus struct {
+	Bus
+	tenantOf     func(ctx context.Context, topic string) string
+	defaultQuota Quota
+	store        MessageStore
+	capacity     int
+
+	mu      sync.Mutex
+	quotas  map[string]Quota
+	tenants map[string]*tenantUsage
+}
+
+// QuotaOption is a functional option for configuring a quota bus.
+type QuotaOption func(*QuotaBus)
+
+// WithTenantQuota sets the quota of a tenant, replacing the default quota.
+func WithTenantQuota(tenant string, quota Quota) QuotaOption {
+	return func(qb *QuotaBus) {
+		qb.quotas[tenant] = quota
+	}
+}
+
+// WithDefaultQuota sets the quota of tenants without their own.
+func WithDefaultQuota(quota Quota) QuotaOption {
+	return func(qb *QuotaBus) {
+		qb.defaultQuota = quota
+	}
+}
+
+// WithTenantFunc sets how the tenant of a published message is found,
+// TopicNamespace of its topic by default.
+func WithTenantFunc(fn func(ctx context.Context, topic string) string) QuotaOption {
+	return func(qb *QuotaBus) {
+		if fn != nil {
+			qb.tenantOf = fn
+		}
+	}
+}
+
+// WithQuotaStore counts the messages already in store against the store
+// quotas, see RefreshUsage. Their tenant is found with a background
+// context.
+func WithQuotaStore(store MessageStore) QuotaOption {
+	return func(qb *QuotaBus) {
+		qb.store = store
+	}
+}
+
+// tenantUsage is the state of a tenant.
+type tenantUsage struct {
+	// tokens is the publish budget, refilled at the publish rate since
+	// refilled.
+	tokens   float64
+	refilled time.Time
+
+	// pending counts the messages being published, not queued yet.
+	pending int
+
+	usage QuotaUsage
+}
+
+// NewQuotaBus wraps bus with per-tenant quotas. Queue shares are relative
+// to the queue capacity reported by StatsOf.
+func NewQuotaBus(bus Bus, opts ...QuotaOption) (*QuotaBus, error) {
+	qb := &QuotaBus{
+		Bus:      bus,
+		tenantOf: func(ctx context.Context, topic string) string { return TopicNamespace(topic) },
+		quotas:   make(map[string]Quota),
+		tenants:  make(map[string]*tenantUsage),
+	}
+	for _, opt := range opts {
+		opt(qb)
+	}
+	if stats, ok := StatsOf(bus); ok {
+		qb.capacity = stats.QueueCapacity
+	}
+
+	if qb.store != nil {
+		if err := qb.RefreshUsage(context.Background()); err != nil {
+			return nil, err
+		}
+	}
+	return qb, nil
+}
+
+// SetQuota sets the quota of a tenant, for tenants added while running.
+func (qb *QuotaBus) SetQuota(tenant string, quota Quota) {
+	qb.mu.Lock()
+	defer qb.mu.Unlock()
+
+	qb.quotas[tenant] = quota
+	if t, ok := qb.tenants[tenant]; ok {
+		t.usage.Quota = quota
+		t.tokens = min(t.tokens, burst(quota))
+	}
+}
+
+// Usage returns the usage of the tenants that published or have stored
+// messages.
+func (qb *QuotaBus) Usage() map[string]QuotaUsage {
+	qb.mu.Lock()
+	defer qb.mu.Unlock()
+
+	usage := make(map[string]QuotaUsage, len(qb.tenants))
+	for tenant, t := range qb.tenants {
+		usage[tenant] = t.snapshot()
+	}
+	return usage
+}
+
+// TenantUsage returns the usage of a tenant.
+func (qb *QuotaBus) TenantUsage(tenant string) QuotaUsage {
+	qb.mu.Lock()
+	defer qb.mu.Unlock()
+
+	return qb.tenant(tenant).snapshot()
+}
+
+// RefreshUsage recounts the stored bytes of every tenant from the store
+// given with WithQuotaStore. It does nothing without a store.
+func (qb *QuotaBus) RefreshUsage(ctx context.Context) error {
+	if qb.store == nil {
+		return nil
+	}
+	msgs, err := qb.store.Load(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to load stored messages: %w", err)
+	}
+
+	stored := make(map[string]int64)
+	for _, msg := range msgs {
+		stored[qb.tenantOf(context.Background(), msg.Topic())] += payloadSize(msg.Payload())
+	}
+
+	qb.mu.Lock()
+	defer qb.mu.Unlock()
+
+	for tenant, t := range qb.tenants {
+		t.usage.StoredBytes = stored[tenant]
+	}
+	for tenant, n := range stored {
+		qb.tenant(tenant).usage.StoredBytes = n
+	}
+	return nil
+}
+
+// Publish publishes a message if its tenant is within quota.
+func (qb *QuotaBus) Publish(ctx context.Context, topic string, payload interface{}) error {
+	return qb.publish(ctx, []TopicPayload{{Topic: topic, Payload: payload}}, func(ctx context.Context) error {
+		return qb.Bus.Publish(ctx, topic, payload)
+	})
+}
+
+// PublishWithPriority publishes a message with a priority if its tenant is
+// within quota.
+func (qb *QuotaBus) PublishWithPriority(
+	ctx context.Context, topic string, payload interface{}, priority Priority,
+) error {
+	return qb.publish(ctx, []TopicPayload{{Topic: topic, Payload: payload}}, func(ctx context.Context) error {
+		return qb.Bus.PublishWithPriority(ctx, topic, payload, priority)
+	})
+}
+
+// PublishSync publishes a message synchronously if its tenant is within
+// quota. The message is never queued, so it only counts against the
+// publish rate and the stored bytes.
+func (qb *QuotaBus) PublishSync(ctx context.Context, topic string, payload interface{}) error {
+	return qb.publish(ctx, []TopicPayload{{Topic: topic, Payload: payload}}, func(ctx context.Context) error {
+		return qb.Bus.PublishSync(ctx, topic, payload)
+	})
+}
+
+// PublishBatch publishes a batch if all its tenants are within quota.
+// Otherwise nothing is published.
+func (qb *QuotaBus) PublishBatch(ctx context.Context, batch []TopicPayload) error {
+	if len(batch) == 0 {
+		return nil
+	}
+	return qb.publish(ctx, batch, func(ctx context.Context) error {
+		return qb.Bus.PublishBatch(ctx, batch)
+	})
+}
+
+// admission is what a publish takes from the quota of a tenant.
+type admission struct {
+	messages int
+	bytes    int64
+}
+
+// publish admits the messages of batch and calls send with a context
+// tracking them in the queue.
+func (qb *QuotaBus) publish(ctx context.Context, batch []TopicPayload, send func(context.Context) error) error {
+	admissions := make(map[string]*admission)
+	tenants := make(map[string]string, len(batch))
+	for _, entry := range batch {
+		tenant := qb.tenantOf(ctx, entry.Topic)
+		tenants[entry.Topic] = tenant
+
+		a, ok := admissions[tenant]
+		if !ok {
+			a = &admission{}
+			admissions[tenant] = a
+		}
+		a.messages++
+		if qb.limitsStore(tenant) {
+			a.bytes += payloadSize(entry.Payload)
+		}
+	}
+
+	if err := qb.admit(admissions); err != nil {
+		return err
+	}
+
+	ctx = contextWithDueHook(ctx, func(msg Message) { qb.queued(tenants[msg.Topic()], 1) })
+	ctx = contextWithDequeuedHook(ctx, func(msg Message) { qb.queued(tenants[msg.Topic()], -1) })
+	err := send(ctx)
+
+	qb.mu.Lock()
+	defer qb.mu.Unlock()
+	for tenant, a := range admissions {
+		t := qb.tenant(tenant)
+		t.pending -= a.messages
+		if err != nil {
+			// Nothing is known to be stored
+			t.usage.StoredBytes -= a.bytes
+		}
+	}
+	return err
+}
+
+// limitsStore reports whether tenant has a store quota, or the stored bytes
+// are tracked for all tenants.
+func (qb *QuotaBus) limitsStore(tenant string) bool {
+	qb.mu.Lock()
+	defer qb.mu.Unlock()
+
+	return qb.store != nil || qb.quotaOf(tenant).StoreBytes > 0
+}
+
+// admit checks the admissions against the quotas, and takes them if all
+// fit. Otherwise the first tenant over quota is charged with a rejection.
+func (qb *QuotaBus) admit(admissions map[string]*admission) error {
+	qb.mu.Lock()
+	defer qb.mu.Unlock()
+
+	now := time.Now()
+	for tenant, a := range admissions {
+		t := qb.tenant(tenant)
+		if resource, ok := qb.fits(t, a, now); !ok {
+			t.usage.Rejected[resource] += int64(a.messages)
+			return &QuotaError{Tenant: tenant, Resource: resource}
+		}
+	}
+
+	for tenant, a := range admissions {
+		t := qb.tenant(tenant)
+		if t.usage.Quota.PublishRate > 0 {
+			t.tokens -= float64(a.messages)
+		}
+		t.pending += a.messages
+		t.usage.Published += int64(a.messages)
+		t.usage.StoredBytes += a.bytes
+	}
+	return nil
+}
+
+// fits reports whether a fits in the quota of t, or the resource it would
+// exceed. Must be called with the lock held.
+func (qb *QuotaBus) fits(t *tenantUsage, a *admission, now time.Time) (QuotaResource, bool) {
+	quota := t.usage.Quota
+
+	if quota.PublishRate > 0 {
+		// The tenant may have been created after now was taken
+		if elapsed := now.Sub(t.refilled).Seconds(); elapsed > 0 {
+			t.tokens = min(t.tokens+elapsed*quota.PublishRate, burst(quota))
+			t.refilled = now
+		}
+		if t.tokens < float64(a.messages) {
+			return QuotaPublishRate, false
+		}
+	}
+
+	if quota.QueueShare > 0 && qb.capacity > 0 {
+		limit := max(1, int(quota.QueueShare*float64(qb.capacity)))
+		if t.usage.Queued+t.pending+a.messages > limit {
+			return QuotaQueueShare, false
+		}
+	}
+
+	if quota.StoreBytes > 0 && t.usage.StoredBytes+a.bytes > quota.StoreBytes {
+		return QuotaStoreBytes, false
+	}
+	return "", true
+}
+
+// queued adds n to the queued messages of tenant.
+func (qb *QuotaBus) queued(tenant string, n int) {
+	qb.mu.Lock()
+	defer qb.mu.Unlock()
+
+	qb.tenant(tenant).usage.Queued += n
+}
+
+// quotaOf returns the quota of tenant. Must be called with the lock held.
+func (qb *QuotaBus) quotaOf(tenant string) Quota {
+	if quota, ok := qb.quotas[tenant]; ok {
+		return quota
+	}
+	return qb.defaultQuota
+}
+
+// tenant returns the state of tenant, creating it with a full publish
+// budget. Must be called with the lock held.
+func (qb *QuotaBus) tenant(tenant string) *tenantUsage {
+	t, ok := qb.tenants[tenant]
+	if !ok {
+		quota := qb.quotaOf(tenant)
+		t = &tenantUsage{
+			tokens:   burst(quota),
+			refilled: time.Now(),
+			usage: QuotaUsage{
+				Quota:    quota,
+				Rejected: make(map[QuotaResource]int64),
+			},
+		}
+		qb.tenants[tenant] = t
+	}
+	return t
+}
+
+// snapshot returns a copy of the usage of t.
+func (t *tenantUsage) snapshot() QuotaUsage {
+	usage := t.usage
+	usage.Rejected = make(map[QuotaResource]int64, len(t.usage.Rejected))
+	for resource, n := range t.usage.Rejected {
+		usage.Rejected[resource] = n
+	}
+	// A worker may take a message before it is counted as queued
+	usage.Queued = max(usage.Queued, 0)
+	return usage
+}
+
+// burst returns the publish burst of quota.
+func burst(quota Quota) float64 {
+	if quota.PublishBurst > 0 {
+		return float64(quota.PublishBurst)
+	}
+	return max(1, math.Ceil(quota.PublishRate))
+}
+
+// payloadSize returns the size of payload serialized as JSON, as stores
+// write it, or zero if it cannot be serialized.
+func payloadSize(payload interface{}) int64 {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return 0
+	}
+	return int64(len(data))
+}
+
+// dequeuedHookContextKey is the context key under which a wrapper stores a
+// function called once a message it published left the queue.
+type dequeuedHookContextKey struct{}
+
+// contextWithDequeuedHook returns a context under which fn is called with
+// each message published asynchronously once it left the queue, taken by
+// a worker or dropped, after any hook already set.
+func contextWithDequeuedHook(ctx context.Context, fn func(Message)) context.Context {
+	if prev := dequeuedHook(ctx); prev != nil {
+		next := fn
+		fn = func(msg Message) {
+			prev(msg)
+			next(msg)
+		}
+	}
+	return context.WithValue(ctx, dequeuedHookContextKey{}, fn)
+}
+
+// dequeuedHook returns the function stored by contextWithDequeuedHook, or
+// nil.
+func dequeuedHook(ctx context.Context) func(Message) {
+	fn, _ := ctx.Value(dequeuedHookContextKey{}).(func(Message))
+	return fn
+}
+
+// leaveQueue calls the dequeued hook of env, once.
+func (env *Envelope) leaveQueue() {
+	if fn := env.dequeued; fn != nil {
+		env.dequeued = nil
+		fn(env.msg)
+	}
+}
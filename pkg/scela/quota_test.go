@@ -0,0 +1,205 @@
+package scela
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestQuotaBus_PublishRate(t *testing.T) {
+	qb, err := NewQuotaBus(New(), WithTenantQuota("noisy", Quota{PublishRate: 1, PublishBurst: 2}))
+	if err != nil {
+		t.Fatalf("NewQuotaBus() error = %v", err)
+	}
+	defer qb.Close()
+
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		if err := qb.PublishSync(ctx, "noisy.event", i); err != nil {
+			t.Fatalf("expected the burst admitted, got %v", err)
+		}
+	}
+	err = qb.PublishSync(ctx, "noisy.event", 3)
+	var qerr *QuotaError
+	if !errors.As(err, &qerr) || qerr.Tenant != "noisy" || qerr.Resource != QuotaPublishRate {
+		t.Fatalf("expected a publish rate QuotaError, got %v", err)
+	}
+	if !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("expected the error to match ErrQuotaExceeded")
+	}
+
+	// Other tenants are not limited
+	for i := 0; i < 10; i++ {
+		if err := qb.PublishSync(ctx, "quiet.event", i); err != nil {
+			t.Fatalf("expected other tenants unaffected, got %v", err)
+		}
+	}
+
+	usage := qb.TenantUsage("noisy")
+	if usage.Published != 2 || usage.Rejected[QuotaPublishRate] != 1 {
+		t.Errorf("unexpected usage %+v", usage)
+	}
+	if got := qb.Usage()["quiet"].Published; got != 10 {
+		t.Errorf("expected 10 messages published by quiet, got %d", got)
+	}
+}
+
+func TestQuotaBus_QueueShare(t *testing.T) {
+	bus := New(WithStartPaused(), WithQueueSize(10))
+	qb, err := NewQuotaBus(bus, WithDefaultQuota(Quota{QueueShare: 0.1}))
+	if err != nil {
+		t.Fatalf("NewQuotaBus() error = %v", err)
+	}
+	defer qb.Close()
+
+	stats, _ := StatsOf(bus)
+	limit := stats.QueueCapacity / 10
+
+	ctx := context.Background()
+	for i := 0; i < limit; i++ {
+		if err := qb.Publish(ctx, "a.event", i); err != nil {
+			t.Fatalf("expected message %d admitted, got %v", i, err)
+		}
+	}
+	if err := qb.Publish(ctx, "a.event", limit); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("expected the queue share exceeded, got %v", err)
+	}
+	if err := qb.Publish(ctx, "b.event", 0); err != nil {
+		t.Fatalf("expected another tenant to have its own share, got %v", err)
+	}
+	if got := qb.TenantUsage("a").Queued; got != limit {
+		t.Errorf("expected %d queued messages, got %d", limit, got)
+	}
+
+	done := make(chan struct{}, limit)
+	if _, err := qb.Subscribe("a.event", HandlerFunc(func(ctx context.Context, msg Message) error {
+		done <- struct{}{}
+		return nil
+	})); err != nil {
+		t.Fatalf("Subscribe() error = %v", err)
+	}
+	if err := Start(qb); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	for i := 0; i < limit; i++ {
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatal("timed out waiting for queued messages")
+		}
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for qb.TenantUsage("a").Queued != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the queue share released, got %+v", qb.TenantUsage("a"))
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if err := qb.Publish(ctx, "a.event", 0); err != nil {
+		t.Errorf("expected a publish admitted once the queue drained, got %v", err)
+	}
+}
+
+func TestQuotaBus_StoreBytes(t *testing.T) {
+	store := NewInMemoryStore(0)
+	pb := NewPersistentBus(New(), store)
+	qb, err := NewQuotaBus(pb,
+		WithQuotaStore(store),
+		WithDefaultQuota(Quota{StoreBytes: 10}),
+	)
+	if err != nil {
+		t.Fatalf("NewQuotaBus() error = %v", err)
+	}
+	defer qb.Close()
+
+	ctx := context.Background()
+	if err := qb.PublishSync(ctx, "a.event", "12345678"); err != nil {
+		t.Fatalf("expected 10 bytes admitted, got %v", err)
+	}
+	var qerr *QuotaError
+	if err := qb.PublishSync(ctx, "a.event", 1); !errors.As(err, &qerr) || qerr.Resource != QuotaStoreBytes {
+		t.Fatalf("expected the store quota exceeded, got %v", err)
+	}
+	if got := qb.TenantUsage("a").StoredBytes; got != 10 {
+		t.Errorf("expected 10 stored bytes, got %d", got)
+	}
+
+	if err := store.Clear(ctx); err != nil {
+		t.Fatalf("Clear() error = %v", err)
+	}
+	if err := qb.RefreshUsage(ctx); err != nil {
+		t.Fatalf("RefreshUsage() error = %v", err)
+	}
+	if err := qb.PublishSync(ctx, "a.event", 1); err != nil {
+		t.Errorf("expected a publish admitted after the store was cleared, got %v", err)
+	}
+}
+
+func TestQuotaBus_BatchAllOrNothing(t *testing.T) {
+	qb, err := NewQuotaBus(New(),
+		WithTenantQuota("a", Quota{PublishRate: 1, PublishBurst: 1}),
+	)
+	if err != nil {
+		t.Fatalf("NewQuotaBus() error = %v", err)
+	}
+	defer qb.Close()
+
+	received := make(chan Message, 4)
+	if _, err := qb.Subscribe("*.event", HandlerFunc(func(ctx context.Context, msg Message) error {
+		received <- msg
+		return nil
+	})); err != nil {
+		t.Fatalf("Subscribe() error = %v", err)
+	}
+
+	batch := []TopicPayload{
+		{Topic: "b.event", Payload: 1},
+		{Topic: "a.event", Payload: 2},
+		{Topic: "a.event", Payload: 3},
+	}
+	if err := qb.PublishBatch(context.Background(), batch); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("expected the batch rejected, got %v", err)
+	}
+	if got := qb.TenantUsage("b").Published; got != 0 {
+		t.Errorf("expected nothing admitted for b, got %d", got)
+	}
+
+	qb.Close()
+	if len(received) != 0 {
+		t.Errorf("expected no message of the rejected batch delivered, got %d", len(received))
+	}
+}
+
+func TestQuotaBus_TenantFunc(t *testing.T) {
+	type tenantKey struct{}
+	qb, err := NewQuotaBus(New(),
+		WithTenantFunc(func(ctx context.Context, topic string) string {
+			tenant, _ := ctx.Value(tenantKey{}).(string)
+			return tenant
+		}),
+		WithDefaultQuota(Quota{PublishRate: 1, PublishBurst: 1}),
+	)
+	if err != nil {
+		t.Fatalf("NewQuotaBus() error = %v", err)
+	}
+	defer qb.Close()
+
+	acme := context.WithValue(context.Background(), tenantKey{}, "acme")
+	globex := context.WithValue(context.Background(), tenantKey{}, "globex")
+	if err := qb.PublishSync(acme, "orders", 1); err != nil {
+		t.Fatalf("PublishSync() error = %v", err)
+	}
+	if err := qb.PublishSync(globex, "orders", 1); err != nil {
+		t.Fatalf("expected tenants found from the context, got %v", err)
+	}
+	if err := qb.PublishSync(acme, "orders", 2); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("expected acme over quota, got %v", err)
+	}
+
+	qb.SetQuota("acme", Quota{})
+	if err := qb.PublishSync(acme, "orders", 3); err != nil {
+		t.Errorf("expected the quota lifted by SetQuota, got %v", err)
+	}
+}
@@ -0,0 +1,63 @@
+package scela
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrReadOnly is returned when publishing through a ReadOnlyBus.
+var ErrReadOnly = errors.New("bus is read-only")
+
+// ReadOnlyBus restricts a bus to consuming messages. Subscribe is forwarded
+// to the wrapped bus, while every publish method returns ErrReadOnly. Hand it
+// to components that should only react to events.
+type ReadOnlyBus struct {
+	bus Bus
+}
+
+// NewReadOnlyBus wraps bus in a read-only view.
+func NewReadOnlyBus(bus Bus) *ReadOnlyBus {
+	return &ReadOnlyBus{bus: bus}
+}
+
+// Publish always returns ErrReadOnly.
+func (rb *ReadOnlyBus) Publish(ctx context.Context, topic string, payload interface{}) error {
+	return ErrReadOnly
+}
+
+// PublishSync always returns ErrReadOnly.
+func (rb *ReadOnlyBus) PublishSync(ctx context.Context, topic string, payload interface{}) error {
+	return ErrReadOnly
+}
+
+// PublishWithPriority always returns ErrReadOnly.
+func (rb *ReadOnlyBus) PublishWithPriority(
+	ctx context.Context, topic string, payload interface{}, priority Priority,
+) error {
+	return ErrReadOnly
+}
+
+// PublishBatch always returns ErrReadOnly.
+func (rb *ReadOnlyBus) PublishBatch(ctx context.Context, batch []TopicPayload) error {
+	return ErrReadOnly
+}
+
+// Subscribe subscribes a handler on the wrapped bus.
+func (rb *ReadOnlyBus) Subscribe(pattern string, handler Handler) (Subscription, error) {
+	return rb.bus.Subscribe(pattern, handler)
+}
+
+// SubscribeWithOptions subscribes a handler on the wrapped bus.
+func (rb *ReadOnlyBus) SubscribeWithOptions(
+	pattern string, handler Handler, opts ...SubscriptionOption,
+) (Subscription, error) {
+	return rb.bus.SubscribeWithOptions(pattern, handler, opts...)
+}
+
+// Use is ignored: a read-only view cannot change the wrapped bus pipeline.
+func (rb *ReadOnlyBus) Use(middleware ...Middleware) {}
+
+// Close returns ErrReadOnly; only the owner of the wrapped bus may close it.
+func (rb *ReadOnlyBus) Close() error {
+	return ErrReadOnly
+}
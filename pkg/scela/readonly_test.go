@@ -0,0 +1,48 @@
+package scela
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+)
+
+func TestReadOnlyBus(t *testing.T) {
+	bus := New()
+	defer bus.Close()
+
+	var ro Bus = NewReadOnlyBus(bus)
+	ctx := context.Background()
+
+	var received int32
+	if _, err := ro.Subscribe("events", HandlerFunc(func(ctx context.Context, msg Message) error {
+		atomic.AddInt32(&received, 1)
+		return nil
+	})); err != nil {
+		t.Fatalf("Subscribe() error = %v", err)
+	}
+
+	if err := bus.PublishSync(ctx, "events", nil); err != nil {
+		t.Fatalf("PublishSync() error = %v", err)
+	}
+	if got := atomic.LoadInt32(&received); got != 1 {
+		t.Errorf("Expected read-only subscriber to receive 1 message, got %d", got)
+	}
+
+	publishers := map[string]func() error{
+		"Publish":             func() error { return ro.Publish(ctx, "events", nil) },
+		"PublishSync":         func() error { return ro.PublishSync(ctx, "events", nil) },
+		"PublishWithPriority": func() error { return ro.PublishWithPriority(ctx, "events", nil, PriorityHigh) },
+		"PublishBatch":        func() error { return ro.PublishBatch(ctx, []TopicPayload{{Topic: "events"}}) },
+		"Close":               ro.Close,
+	}
+	for name, fn := range publishers {
+		if err := fn(); !errors.Is(err, ErrReadOnly) {
+			t.Errorf("%s() error = %v, want ErrReadOnly", name, err)
+		}
+	}
+
+	if got := atomic.LoadInt32(&received); got != 1 {
+		t.Errorf("Expected no deliveries from read-only publishes, got %d", got)
+	}
+}
@@ -0,0 +1,222 @@
+package scela
+
+import (
+	"context"
+	"fmt"
+)
+
+// ReplayProgress reports how far a replay has advanced.
+type ReplayProgress struct {
+	// Replayed is the number of messages republished by this replay call.
+	Replayed int
+	// Dropped is the number of messages dropped by a replay transform.
+	Dropped int
+	// Position is the index of the next message to replay. Pass it to
+	// WithReplayStart to resume an interrupted replay.
+	Position int
+	// Total is the number of messages in the store when the replay started.
+	Total int
+	// Gaps is the number of sequence gaps found so far, see GapObserver.
+	Gaps int
+}
+
+// Remaining returns the number of messages still to be replayed.
+func (p ReplayProgress) Remaining() int {
+	return p.Total - p.Position
+}
+
+// ReplayError is returned when a replay stops before completion. Position
+// is the index of the first message that was not replayed.
+type ReplayError struct {
+	Position int
+	Err      error
+}
+
+// Error implements the error interface.
+func (e *ReplayError) Error() string {
+	return fmt.Sprintf("replay stopped at position %d: %v", e.Position, e.Err)
+}
+
+// Unwrap returns the error that stopped the replay.
+func (e *ReplayError) Unwrap() error {
+	return e.Err
+}
+
+// ReplayTransform adapts a stored message before it is replayed, for
+// example when the schema changed since it was written. Returning a nil
+// message drops it from the replay.
+type ReplayTransform func(msg Message) (Message, error)
+
+// RenameTopic returns a transform replaying messages stored on topic from
+// on topic to instead.
+func RenameTopic(from, to string) ReplayTransform {
+	return func(msg Message) (Message, error) {
+		if msg.Topic() != from {
+			return msg, nil
+		}
+		return withTopic(msg, to), nil
+	}
+}
+
+// DropTopics returns a transform dropping messages whose topic matches any
+// of the patterns, such as obsolete event types.
+func DropTopics(patterns ...string) ReplayTransform {
+	matcher := newPatternMatcher()
+	return func(msg Message) (Message, error) {
+		for _, pattern := range patterns {
+			if matcher.Match(pattern, msg.Topic()) {
+				return nil, nil
+			}
+		}
+		return msg, nil
+	}
+}
+
+// PatchPayload returns a transform replacing the payload of messages whose
+// topic matches pattern with the result of patch.
+func PatchPayload(pattern string, patch func(payload interface{}) (interface{}, error)) ReplayTransform {
+	matcher := newPatternMatcher()
+	return func(msg Message) (Message, error) {
+		if !matcher.Match(pattern, msg.Topic()) {
+			return msg, nil
+		}
+		payload, err := patch(msg.Payload())
+		if err != nil {
+			return nil, fmt.Errorf("failed to patch payload of message %s: %w", msg.ID(), err)
+		}
+		return withPayload(msg, payload), nil
+	}
+}
+
+// replayConfig holds the settings of a single Replay call.
+type replayConfig struct {
+	start      int
+	progress   func(ReplayProgress)
+	transforms []ReplayTransform
+	all        bool
+}
+
+// transform applies the replay transforms in order. It returns nil if the
+// message was dropped.
+func (c *replayConfig) transform(msg Message) (Message, error) {
+	for _, t := range c.transforms {
+		var err error
+		if msg, err = t(msg); err != nil || msg == nil {
+			return nil, err
+		}
+	}
+	return msg, nil
+}
+
+// ReplayOption is a functional option for configuring a replay.
+type ReplayOption func(*replayConfig)
+
+// WithReplayProgress registers a callback invoked after each replayed message.
+func WithReplayProgress(fn func(ReplayProgress)) ReplayOption {
+	return func(c *replayConfig) {
+		c.progress = fn
+	}
+}
+
+// WithReplayTransform adds transforms applied, in order, to each message
+// before it is replayed. The stored messages are not modified.
+func WithReplayTransform(transforms ...ReplayTransform) ReplayOption {
+	return func(c *replayConfig) {
+		c.transforms = append(c.transforms, transforms...)
+	}
+}
+
+// WithReplayStart skips the first position messages, resuming a replay that
+// previously stopped with a ReplayError. It is ignored when only the pending
+// messages of a DeliveryStore are replayed, as those replayed before the
+// stop are no longer pending: the replay then resumes by itself.
+func WithReplayStart(position int) ReplayOption {
+	return func(c *replayConfig) {
+		if position > 0 {
+			c.start = position
+		}
+	}
+}
+
+// WithReplayAll replays the messages already delivered too when the store
+// is a DeliveryStore, for example to rebuild a read model.
+func WithReplayAll() ReplayOption {
+	return func(c *replayConfig) {
+		c.all = true
+	}
+}
+
+// Replay replays all stored messages. It stops as soon as ctx is canceled
+// or publishing fails, returning a *ReplayError carrying the position to
+// resume from. Gaps in the sequence numbers of the stored messages (see
+// WithSequenceNumbers) are reported to the bus observers implementing
+// GapObserver.
+//
+// When the store is a DeliveryStore, only the messages not delivered yet are
+// replayed, keeping their ID and metadata, and they are marked delivered
+// once handled; positions then refer to the pending messages, and a stopped
+// replay resumes by calling Replay again. Otherwise each message is
+// published again as a new message.
+func (pb *PersistentBus) Replay(ctx context.Context, opts ...ReplayOption) error {
+	cfg := &replayConfig{}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+
+	ds, tracked := pb.store.(DeliveryStore)
+	mp, ok := pb.Bus.(messagePublisher)
+	tracked = tracked && ok && !cfg.all
+
+	var messages []Message
+	var err error
+	if tracked {
+		messages, err = ds.LoadPending(ctx)
+	} else {
+		messages, err = pb.store.Load(ctx)
+	}
+	if err != nil {
+		return pb.reportStoreError(ctx, "load", nil, err)
+	}
+	publishCtx := pb.deliveredContext(ctx)
+
+	progress := ReplayProgress{Total: len(messages)}
+	if !tracked {
+		progress.Position = cfg.start
+	}
+	gaps := NewGapDetector()
+
+	for progress.Position < len(messages) {
+		if err := ctx.Err(); err != nil {
+			return &ReplayError{Position: progress.Position, Err: err}
+		}
+
+		if reportGap(ctx, pb.Bus, gaps, messages[progress.Position]) {
+			progress.Gaps++
+		}
+
+		msg, err := cfg.transform(messages[progress.Position])
+		if err != nil {
+			return &ReplayError{Position: progress.Position, Err: err}
+		}
+
+		if msg == nil {
+			progress.Dropped++
+		} else {
+			if tracked {
+				err = mp.publishMessages(publishCtx, []Message{msg}, false)
+			} else {
+				err = pb.Bus.Publish(ctx, msg.Topic(), msg.Payload())
+			}
+			if err != nil {
+				return &ReplayError{Position: progress.Position, Err: err}
+			}
+			progress.Replayed++
+		}
+		progress.Position++
+		if cfg.progress != nil {
+			cfg.progress(progress)
+		}
+	}
+
+	return nil
+}
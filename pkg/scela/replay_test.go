@@ -0,0 +1,167 @@
+package scela
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newReplayBus(t *testing.T, n int) (*PersistentBus, *int32) {
+	t.Helper()
+
+	bus := New()
+	t.Cleanup(func() { bus.Close() })
+
+	store := NewInMemoryStore(100)
+	for i := 0; i < n; i++ {
+		store.Store(context.Background(), NewMessage("replay", i))
+	}
+
+	var received int32
+	_, err := bus.Subscribe("replay", HandlerFunc(func(ctx context.Context, msg Message) error {
+		atomic.AddInt32(&received, 1)
+		return nil
+	}))
+	if err != nil {
+		t.Fatalf("Subscribe() error = %v", err)
+	}
+
+	return NewPersistentBus(bus, store), &received
+}
+
+func TestReplay_Progress(t *testing.T) {
+	pbus, received := newReplayBus(t, 5)
+
+	var reports []ReplayProgress
+	err := pbus.Replay(context.Background(), WithReplayProgress(func(p ReplayProgress) {
+		reports = append(reports, p)
+	}))
+	if err != nil {
+		t.Fatalf("Replay() error = %v", err)
+	}
+
+	if len(reports) != 5 {
+		t.Fatalf("Expected 5 progress reports, got %d", len(reports))
+	}
+	last := reports[len(reports)-1]
+	if last.Replayed != 5 || last.Total != 5 || last.Remaining() != 0 {
+		t.Errorf("Unexpected final progress %+v", last)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	if got := atomic.LoadInt32(received); got != 5 {
+		t.Errorf("Expected 5 replayed messages delivered, got %d", got)
+	}
+}
+
+func TestReplay_CancelAndResume(t *testing.T) {
+	pbus, received := newReplayBus(t, 10)
+
+	// Replaying all messages resumes by position, see
+	// TestReplay_ResumeTracked for the pending messages
+	ctx, cancel := context.WithCancel(context.Background())
+	err := pbus.Replay(ctx, WithReplayAll(), WithReplayProgress(func(p ReplayProgress) {
+		if p.Replayed == 4 {
+			cancel()
+		}
+	}))
+
+	var replayErr *ReplayError
+	if !errors.As(err, &replayErr) {
+		t.Fatalf("Expected *ReplayError, got %v", err)
+	}
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+	if replayErr.Position != 4 {
+		t.Errorf("Expected resume position 4, got %d", replayErr.Position)
+	}
+
+	var final ReplayProgress
+	err = pbus.Replay(context.Background(),
+		WithReplayAll(),
+		WithReplayStart(replayErr.Position),
+		WithReplayProgress(func(p ReplayProgress) { final = p }),
+	)
+	if err != nil {
+		t.Fatalf("Resumed Replay() error = %v", err)
+	}
+	if final.Replayed != 6 || final.Position != 10 {
+		t.Errorf("Unexpected resumed progress %+v", final)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	if got := atomic.LoadInt32(received); got != 10 {
+		t.Errorf("Expected each message replayed exactly once, got %d deliveries", got)
+	}
+}
+
+func TestReplay_Transform(t *testing.T) {
+	bus := New(WithWorkers(1))
+	defer bus.Close()
+
+	store := NewInMemoryStore(100)
+	ctx := context.Background()
+	_ = store.Store(ctx, NewMessage("user.registered", map[string]interface{}{"name": "Ada"}))
+	_ = store.Store(ctx, NewMessage("user.legacy_ping", nil))
+	_ = store.Store(ctx, NewMessage("order.placed", map[string]interface{}{"total": 10}))
+
+	received := make(chan Message, 3)
+	_, _ = bus.Subscribe("*.*", HandlerFunc(func(ctx context.Context, msg Message) error {
+		received <- msg
+		return nil
+	}))
+
+	var final ReplayProgress
+	err := NewPersistentBus(bus, store).Replay(ctx,
+		WithReplayTransform(
+			DropTopics("*.legacy_ping"),
+			RenameTopic("user.registered", "user.created"),
+			PatchPayload("order.*", func(payload interface{}) (interface{}, error) {
+				p := payload.(map[string]interface{})
+				return map[string]interface{}{"total_cents": p["total"].(int) * 100}, nil
+			}),
+		),
+		WithReplayProgress(func(p ReplayProgress) { final = p }),
+	)
+	if err != nil {
+		t.Fatalf("Replay() error = %v", err)
+	}
+
+	if final.Replayed != 2 || final.Dropped != 1 || final.Remaining() != 0 {
+		t.Errorf("Unexpected progress %+v", final)
+	}
+
+	first, second := <-received, <-received
+	if first.Topic() != "user.created" {
+		t.Errorf("Expected renamed topic, got %s", first.Topic())
+	}
+	if second.Payload().(map[string]interface{})["total_cents"] != 1000 {
+		t.Errorf("Expected patched payload, got %v", second.Payload())
+	}
+
+	// Stored history is unchanged
+	stored, _ := store.Load(ctx)
+	if stored[0].Topic() != "user.registered" || len(stored) != 3 {
+		t.Error("Expected replay transforms not to modify the store")
+	}
+}
+
+func TestReplay_TransformError(t *testing.T) {
+	pbus, _ := newReplayBus(t, 3)
+
+	boom := errors.New("boom")
+	err := pbus.Replay(context.Background(), WithReplayTransform(func(msg Message) (Message, error) {
+		if msg.Payload() == 1 {
+			return nil, boom
+		}
+		return msg, nil
+	}))
+
+	var replayErr *ReplayError
+	if !errors.As(err, &replayErr) || replayErr.Position != 1 || !errors.Is(err, boom) {
+		t.Errorf("Expected ReplayError at position 1, got %v", err)
+	}
+}
@@ -0,0 +1,101 @@
+package scela
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// Common reply error codes.
+const (
+	ReplyCodeInternal    = "internal"
+	ReplyCodeInvalid     = "invalid_argument"
+	ReplyCodeNotFound    = "not_found"
+	ReplyCodeUnavailable = "unavailable"
+)
+
+// ReplyError is a structured error carried in a reply payload.
+type ReplyError struct {
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	Retriable bool   `json:"retriable"`
+}
+
+// Error implements the error interface.
+func (e *ReplyError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+// NewReplyError converts err into a ReplyError. A ReplyError in the chain of
+// err is returned as-is; any other error becomes a non-retriable internal
+// error.
+func NewReplyError(err error) *ReplyError {
+	var re *ReplyError
+	if errors.As(err, &re) {
+		return re
+	}
+	return &ReplyError{Code: ReplyCodeInternal, Message: err.Error()}
+}
+
+// Reply is a reply payload carrying either a typed value or a structured
+// error. It survives serialization, so it can cross persistent stores and
+// bridges as well as in-process request/reply.
+type Reply[T any] struct {
+	Value T           `json:"value,omitempty"`
+	Err   *ReplyError `json:"error,omitempty"`
+}
+
+// Ok returns a successful reply carrying value.
+func Ok[T any](value T) Reply[T] {
+	return Reply[T]{Value: value}
+}
+
+// Fail returns a failed reply with the given code and message.
+func Fail[T any](code, message string, retriable bool) Reply[T] {
+	return Reply[T]{Err: &ReplyError{Code: code, Message: message, Retriable: retriable}}
+}
+
+// Failure returns a failed reply for err, see NewReplyError.
+func Failure[T any](err error) Reply[T] {
+	return Reply[T]{Err: NewReplyError(err)}
+}
+
+// IsOK reports whether the reply carries a value.
+func (r Reply[T]) IsOK() bool {
+	return r.Err == nil
+}
+
+// Result returns the reply value, or the reply error if it failed.
+func (r Reply[T]) Result() (T, error) {
+	if r.Err != nil {
+		var zero T
+		return zero, r.Err
+	}
+	return r.Value, nil
+}
+
+// ReplyFrom decodes a reply from a message payload. The payload may be a
+// Reply[T], a pointer to one, or its JSON-decoded form (for example a
+// map[string]interface{} produced by a serializer).
+func ReplyFrom[T any](payload interface{}) (Reply[T], error) {
+	switch p := payload.(type) {
+	case Reply[T]:
+		return p, nil
+	case *Reply[T]:
+		if p == nil {
+			return Reply[T]{}, fmt.Errorf("nil reply payload")
+		}
+		return *p, nil
+	}
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return Reply[T]{}, fmt.Errorf("failed to encode reply payload: %w", err)
+	}
+
+	var reply Reply[T]
+	if err := json.Unmarshal(data, &reply); err != nil {
+		return Reply[T]{}, fmt.Errorf("payload is not a reply: %w", err)
+	}
+	return reply, nil
+}
@@ -0,0 +1,72 @@
+package scela
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+type quote struct {
+	Symbol string  `json:"symbol"`
+	Price  float64 `json:"price"`
+}
+
+func TestReply_Result(t *testing.T) {
+	value, err := Ok(quote{Symbol: "ACME", Price: 12.5}).Result()
+	if err != nil || value.Symbol != "ACME" {
+		t.Errorf("Result() = %v, %v", value, err)
+	}
+
+	_, err = Fail[quote](ReplyCodeUnavailable, "market closed", true).Result()
+	var re *ReplyError
+	if !errors.As(err, &re) {
+		t.Fatalf("Expected ReplyError, got %v", err)
+	}
+	if re.Code != ReplyCodeUnavailable || !re.Retriable {
+		t.Errorf("Unexpected reply error %+v", re)
+	}
+	if err.Error() != "unavailable: market closed" {
+		t.Errorf("Error() = %q", err.Error())
+	}
+}
+
+func TestFailure(t *testing.T) {
+	plain := Failure[int](errors.New("boom"))
+	if plain.IsOK() || plain.Err.Code != ReplyCodeInternal || plain.Err.Retriable {
+		t.Errorf("Expected internal error, got %+v", plain.Err)
+	}
+
+	wrapped := fmt.Errorf("lookup: %w", &ReplyError{Code: ReplyCodeNotFound, Message: "no such user"})
+	if got := Failure[int](wrapped).Err; got.Code != ReplyCodeNotFound {
+		t.Errorf("Expected wrapped ReplyError to be kept, got %+v", got)
+	}
+}
+
+func TestReplyFrom(t *testing.T) {
+	ok := Ok(quote{Symbol: "ACME", Price: 12.5})
+
+	if r, err := ReplyFrom[quote](ok); err != nil || r.Value != ok.Value {
+		t.Errorf("ReplyFrom(value) = %+v, %v", r, err)
+	}
+	if r, err := ReplyFrom[quote](&ok); err != nil || r.Value != ok.Value {
+		t.Errorf("ReplyFrom(pointer) = %+v, %v", r, err)
+	}
+
+	// Round trip through JSON, as a serializer would
+	data, _ := json.Marshal(Fail[quote](ReplyCodeInvalid, "bad symbol", false))
+	var decoded interface{}
+	_ = json.Unmarshal(data, &decoded)
+
+	r, err := ReplyFrom[quote](decoded)
+	if err != nil {
+		t.Fatalf("ReplyFrom(decoded) error = %v", err)
+	}
+	if r.IsOK() || r.Err.Code != ReplyCodeInvalid || r.Err.Message != "bad symbol" {
+		t.Errorf("Unexpected decoded reply %+v", r)
+	}
+
+	if _, err := ReplyFrom[quote]("not a reply"); err == nil {
+		t.Error("Expected error for non-reply payload")
+	}
+}
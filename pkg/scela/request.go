@@ -0,0 +1,214 @@
+package scela
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Metadata keys used for request/reply.
+const (
+	// MetadataReplyTo holds the topic a request expects its reply on.
+	MetadataReplyTo = "reply_to"
+	// MetadataRequestID holds the ID correlating a request and its reply.
+	MetadataRequestID = "request_id"
+)
+
+// replyTopicPrefix prefixes the topics requests receive their replies on.
+const replyTopicPrefix = "_reply."
+
+// ErrNotRequest is returned by Respond when the message being handled was
+// not published by Request.
+var ErrNotRequest = errors.New("message is not a request")
+
+// replyRoute is the request/reply routing stamped on published messages.
+type replyRoute struct {
+	replyTo   string
+	requestID string
+}
+
+// replyRouteContextKey is the context key under which the routing for the
+// next published message is stored.
+type replyRouteContextKey struct{}
+
+// busContextKey is the context key under which the bus delivering a message
+// is stored.
+type busContextKey struct{}
+
+// contextWithBus returns a context carrying the bus delivering a message.
+func contextWithBus(ctx context.Context, b Bus) context.Context {
+	return context.WithValue(ctx, busContextKey{}, b)
+}
+
+// Request publishes payload on topic and waits for a handler to Respond. It
+// returns the reply message, or an error when ctx is done first; callers
+// should give ctx a deadline. The reply topic is ephemeral: its
+// subscription is removed once Request returns, and the bus keeps no state
+// per reply topic.
+func Request(ctx context.Context, b Bus, topic string, payload interface{}) (Message, error) {
+	replies := make(chan Message, 1)
+	inbox, err := openReplyInbox(b, func(msg Message) {
+		// Keep the first reply; later ones are dropped
+		select {
+		case replies <- msg:
+		default:
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer inbox.close()
+
+	if err := inbox.publish(ctx, topic, payload); err != nil {
+		return nil, err
+	}
+
+	select {
+	case reply := <-replies:
+		return reply, nil
+	case <-ctx.Done():
+		return nil, fmt.Errorf("no reply to request on %s: %w", topic, ctx.Err())
+	}
+}
+
+// RequestReply sends a request with Request and decodes the reply payload as
+// a Reply[T], returning its value or its error.
+func RequestReply[T any](ctx context.Context, b Bus, topic string, payload interface{}) (T, error) {
+	var zero T
+
+	msg, err := Request(ctx, b, topic, payload)
+	if err != nil {
+		return zero, err
+	}
+	reply, err := ReplyFrom[T](msg.Payload())
+	if err != nil {
+		return zero, err
+	}
+	return reply.Result()
+}
+
+// Respond replies to the request being handled with payload. It must be
+// called with the context passed to the handler, and returns ErrNotRequest
+// if the message was not published by Request. Replies with a Reply[T]
+// payload can be decoded by RequestReply.
+func Respond(ctx context.Context, payload interface{}) error {
+	msg, ok := MessageFromContext(ctx)
+	if !ok {
+		return ErrNotRequest
+	}
+	replyTo, _ := msg.Metadata()[MetadataReplyTo].(string)
+	requestID, _ := msg.Metadata()[MetadataRequestID].(string)
+	if replyTo == "" || requestID == "" {
+		return ErrNotRequest
+	}
+
+	b, ok := ctx.Value(busContextKey{}).(Bus)
+	if !ok {
+		return fmt.Errorf("no bus in handler context")
+	}
+
+	route := replyRoute{requestID: requestID}
+	if err := b.Publish(context.WithValue(ctx, replyRouteContextKey{}, route), replyTo, payload); err != nil {
+		return fmt.Errorf("failed to publish reply: %w", err)
+	}
+	return nil
+}
+
+// RequestID returns the ID correlating a request and its reply, or "" if msg
+// is neither.
+func RequestID(msg Message) string {
+	id, _ := msg.Metadata()[MetadataRequestID].(string)
+	return id
+}
+
+// IsReplyTopic reports whether topic is a reply topic created by Request or
+// ScatterGather.
+// Reply topics are unique per request, so metrics and logs usually group
+// them.
+func IsReplyTopic(topic string) bool {
+	return strings.HasPrefix(topic, replyTopicPrefix)
+}
+
+// replyTopicOwner is implemented by buses counting the open reply topics.
+type replyTopicOwner interface {
+	openReplyTopic()
+	releaseReplyTopic()
+}
+
+// replyInbox is an ephemeral reply topic, subscribed for a single request.
+type replyInbox struct {
+	b     Bus
+	id    string
+	topic string
+	sub   Subscription
+	owner replyTopicOwner
+	once  sync.Once
+}
+
+// openReplyInbox subscribes handle to a new reply topic on b.
+func openReplyInbox(b Bus, handle func(Message)) (*replyInbox, error) {
+	id := generateID()
+	inbox := &replyInbox{b: b, id: id, topic: replyTopicPrefix + id}
+
+	sub, err := b.Subscribe(inbox.topic, HandlerFunc(func(ctx context.Context, msg Message) error {
+		handle(msg)
+		return nil
+	}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to subscribe to reply topic: %w", err)
+	}
+	inbox.sub = sub
+
+	for inner := b; inner != nil; inner = innerBus(inner) {
+		if owner, ok := inner.(replyTopicOwner); ok {
+			inbox.owner = owner
+			owner.openReplyTopic()
+			break
+		}
+	}
+	return inbox, nil
+}
+
+// publish publishes a request on topic, routing its replies to the inbox.
+func (in *replyInbox) publish(ctx context.Context, topic string, payload interface{}) error {
+	route := replyRoute{replyTo: in.topic, requestID: in.id}
+	if err := in.b.Publish(context.WithValue(ctx, replyRouteContextKey{}, route), topic, payload); err != nil {
+		return fmt.Errorf("failed to publish request: %w", err)
+	}
+	return nil
+}
+
+// close removes the subscription of the inbox. Replies arriving later are
+// dropped, as nothing is subscribed to them.
+func (in *replyInbox) close() {
+	in.once.Do(func() {
+		_ = in.sub.Unsubscribe()
+		if in.owner != nil {
+			in.owner.releaseReplyTopic()
+		}
+	})
+}
+
+// openReplyTopic implements replyTopicOwner.
+func (b *bus) openReplyTopic() {
+	b.replyTopics.Add(1)
+}
+
+// releaseReplyTopic implements replyTopicOwner.
+func (b *bus) releaseReplyTopic() {
+	b.replyTopics.Add(-1)
+}
+
+// stampReplyRoute records the request/reply routing carried by ctx on msg.
+func stampReplyRoute(ctx context.Context, msg Message) {
+	route, ok := ctx.Value(replyRouteContextKey{}).(replyRoute)
+	if !ok {
+		return
+	}
+	if route.replyTo != "" {
+		msg.Metadata()[MetadataReplyTo] = route.replyTo
+	}
+	msg.Metadata()[MetadataRequestID] = route.requestID
+}
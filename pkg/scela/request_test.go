@@ -0,0 +1,197 @@
+package scela
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRequest_Respond(t *testing.T) {
+	bus := New()
+	defer bus.Close()
+
+	_, _ = bus.Subscribe("math.double", HandlerFunc(func(ctx context.Context, msg Message) error {
+		return Respond(ctx, msg.Payload().(int)*2)
+	}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	reply, err := Request(ctx, bus, "math.double", 21)
+	if err != nil {
+		t.Fatalf("Request: %v", err)
+	}
+	if reply.Payload() != 42 {
+		t.Errorf("expected 42, got %v", reply.Payload())
+	}
+	if RequestID(reply) == "" {
+		t.Error("expected the reply to carry the request ID")
+	}
+	if CausationID(reply) == "" {
+		t.Error("expected the reply to record the request as its cause")
+	}
+
+	// The reply subscription is removed once the request completes
+	subs, _ := InspectSubscriptions(bus)
+	if len(subs) != 1 {
+		t.Errorf("expected only the handler subscription to remain, got %d", len(subs))
+	}
+}
+
+func TestRequest_Timeout(t *testing.T) {
+	bus := New()
+	defer bus.Close()
+
+	_, _ = bus.Subscribe("slow", HandlerFunc(func(ctx context.Context, msg Message) error {
+		return nil
+	}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	if _, err := Request(ctx, bus, "slow", nil); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected DeadlineExceeded, got %v", err)
+	}
+}
+
+func TestRequest_ConcurrentRepliesAreRouted(t *testing.T) {
+	bus := New(WithWorkers(4))
+	defer bus.Close()
+
+	_, _ = bus.Subscribe("echo", HandlerFunc(func(ctx context.Context, msg Message) error {
+		return Respond(ctx, msg.Payload())
+	}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	errs := make(chan error, 20)
+	for i := 0; i < 20; i++ {
+		go func(i int) {
+			reply, err := Request(ctx, bus, "echo", i)
+			if err == nil && reply.Payload() != i {
+				err = errors.New("reply routed to the wrong request")
+			}
+			errs <- err
+		}(i)
+	}
+	for i := 0; i < 20; i++ {
+		if err := <-errs; err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestRequestReply_Typed(t *testing.T) {
+	bus := New()
+	defer bus.Close()
+
+	_, _ = bus.Subscribe("user.get", HandlerFunc(func(ctx context.Context, msg Message) error {
+		if msg.Payload() == "missing" {
+			return Respond(ctx, Fail[string](ReplyCodeNotFound, "no such user", false))
+		}
+		return Respond(ctx, Ok("alice"))
+	}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	name, err := RequestReply[string](ctx, bus, "user.get", "u-1")
+	if err != nil || name != "alice" {
+		t.Errorf("expected alice, got %q, %v", name, err)
+	}
+
+	_, err = RequestReply[string](ctx, bus, "user.get", "missing")
+	var re *ReplyError
+	if !errors.As(err, &re) || re.Code != ReplyCodeNotFound {
+		t.Errorf("expected a not_found reply error, got %v", err)
+	}
+}
+
+func TestRespond_NotRequest(t *testing.T) {
+	bus := New()
+	defer bus.Close()
+
+	var respondErr error
+	_, _ = bus.Subscribe("plain", HandlerFunc(func(ctx context.Context, msg Message) error {
+		respondErr = Respond(ctx, "reply")
+		return nil
+	}))
+	_ = bus.PublishSync(context.Background(), "plain", nil)
+
+	if !errors.Is(respondErr, ErrNotRequest) {
+		t.Errorf("expected ErrNotRequest, got %v", respondErr)
+	}
+	if err := Respond(context.Background(), nil); !errors.Is(err, ErrNotRequest) {
+		t.Errorf("expected ErrNotRequest outside a handler, got %v", err)
+	}
+}
+
+func TestRequest_ThroughIdentityBus(t *testing.T) {
+	bus := New()
+	defer bus.Close()
+
+	_, _ = bus.Subscribe("whoami", HandlerFunc(func(ctx context.Context, msg Message) error {
+		return Respond(ctx, Identity(msg))
+	}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	reply, err := Request(ctx, As(bus, "billing"), "whoami", nil)
+	if err != nil {
+		t.Fatalf("Request: %v", err)
+	}
+	if reply.Payload() != "billing" {
+		t.Errorf("expected billing, got %v", reply.Payload())
+	}
+}
+
+func TestRequest_ReplyTopicsLeaveNoState(t *testing.T) {
+	bus := New(WithLatencyTracking(0), WithSequenceNumbers())
+	defer bus.Close()
+
+	_, _ = bus.Subscribe("echo", HandlerFunc(func(ctx context.Context, msg Message) error {
+		return Respond(ctx, msg.Payload())
+	}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	var inFlight int
+	_, _ = bus.Subscribe("probe", HandlerFunc(func(ctx context.Context, msg Message) error {
+		inFlight = bus.(StatsReporter).Stats().ReplyTopics
+		return Respond(ctx, nil)
+	}))
+	if _, err := Request(ctx, bus, "probe", nil); err != nil {
+		t.Fatalf("Request: %v", err)
+	}
+	if inFlight != 1 {
+		t.Errorf("expected 1 reply topic while the request is in flight, got %d", inFlight)
+	}
+
+	for i := 0; i < 10; i++ {
+		reply, err := Request(ctx, bus, "echo", i)
+		if err != nil {
+			t.Fatalf("Request: %v", err)
+		}
+		if _, ok := Sequence(reply); ok {
+			t.Error("expected replies not to be numbered")
+		}
+	}
+
+	waitFor(t, func() bool {
+		_, ok := bus.(StatsReporter).Stats().Topics[AllReplyTopics]
+		return ok
+	})
+	stats := bus.(StatsReporter).Stats()
+	if stats.ReplyTopics != 0 || stats.Subscriptions != 2 {
+		t.Errorf("expected no reply topic left, got %d reply topics and %d subscriptions", stats.ReplyTopics, stats.Subscriptions)
+	}
+	for topic := range stats.Topics {
+		if IsReplyTopic(topic) {
+			t.Errorf("expected reply latencies grouped, got %s", topic)
+		}
+	}
+}
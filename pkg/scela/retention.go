@@ -0,0 +1,225 @@
+package scela
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// RetentionPolicy selects the messages a compaction keeps, so that stores of
+// long-running buses do not grow without bound.
+type RetentionPolicy interface {
+	// Retain returns the messages to keep among msgs, in the order of Load,
+	// which is oldest first for the stores of this package. now is the time
+	// of the compaction.
+	Retain(msgs []Message, now time.Time) []Message
+}
+
+// RetentionFunc adapts a function to the RetentionPolicy interface.
+type RetentionFunc func(msgs []Message, now time.Time) []Message
+
+// Retain implements RetentionPolicy.
+func (f RetentionFunc) Retain(msgs []Message, now time.Time) []Message {
+	return f(msgs, now)
+}
+
+// KeepLastPerTopic keeps the last n messages of each topic.
+func KeepLastPerTopic(n int) RetentionPolicy {
+	return RetentionFunc(func(msgs []Message, now time.Time) []Message {
+		seen := make(map[string]int)
+		keep := make([]bool, len(msgs))
+		for i := len(msgs) - 1; i >= 0; i-- {
+			topic := msgs[i].Topic()
+			if seen[topic] < n {
+				seen[topic]++
+				keep[i] = true
+			}
+		}
+		return retained(msgs, func(i int) bool { return keep[i] })
+	})
+}
+
+// KeepSince keeps the messages published at or after t.
+func KeepSince(t time.Time) RetentionPolicy {
+	return RetentionFunc(func(msgs []Message, now time.Time) []Message {
+		return retained(msgs, func(i int) bool { return !msgs[i].Timestamp().Before(t) })
+	})
+}
+
+// KeepWithin keeps the messages published within d of the compaction, for
+// policies applied periodically.
+func KeepWithin(d time.Duration) RetentionPolicy {
+	return RetentionFunc(func(msgs []Message, now time.Time) []Message {
+		return KeepSince(now.Add(-d)).Retain(msgs, now)
+	})
+}
+
+// KeepLatestPerKey keeps the last message of each key, like a compacted
+// log keeps the latest state of each entity. Messages with an empty key
+// are kept.
+func KeepLatestPerKey(key KeyFunc) RetentionPolicy {
+	return RetentionFunc(func(msgs []Message, now time.Time) []Message {
+		seen := make(map[string]bool)
+		keep := make([]bool, len(msgs))
+		for i := len(msgs) - 1; i >= 0; i-- {
+			k := key(msgs[i])
+			if k == "" || !seen[k] {
+				seen[k] = true
+				keep[i] = true
+			}
+		}
+		return retained(msgs, func(i int) bool { return keep[i] })
+	})
+}
+
+// CombineRetention keeps the messages every policy keeps, applying the
+// policies in order.
+func CombineRetention(policies ...RetentionPolicy) RetentionPolicy {
+	return RetentionFunc(func(msgs []Message, now time.Time) []Message {
+		for _, policy := range policies {
+			msgs = policy.Retain(msgs, now)
+		}
+		return msgs
+	})
+}
+
+// retained returns the messages of msgs whose index keep accepts, in a new
+// slice.
+func retained(msgs []Message, keep func(i int) bool) []Message {
+	kept := make([]Message, 0, len(msgs))
+	for i, msg := range msgs {
+		if keep(i) {
+			kept = append(kept, msg)
+		}
+	}
+	return kept
+}
+
+// CompactStore removes the messages of store that policy does not keep and
+// returns how many were removed. The store must implement RewritableStore,
+// as all the stores of this package do, so that the compaction is applied
+// atomically.
+func CompactStore(ctx context.Context, store MessageStore, policy RetentionPolicy) (int, error) {
+	rs, ok := store.(RewritableStore)
+	if !ok {
+		return 0, fmt.Errorf("store does not support rewriting")
+	}
+
+	removed := 0
+	err := rs.Rewrite(ctx, func(msgs []Message) ([]Message, error) {
+		kept := policy.Retain(msgs, time.Now())
+		removed = len(msgs) - len(kept)
+		return kept, nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return removed, nil
+}
+
+// VacuumableStore is implemented by stores whose storage keeps the space of
+// removed messages until it is reclaimed, such as SQLStore. Compactions and
+// ClearBefore free space for new messages without shrinking the database.
+type VacuumableStore interface {
+	MessageStore
+
+	// Vacuum reclaims the space freed by removed messages.
+	Vacuum(ctx context.Context) error
+}
+
+// Compactor periodically compacts a store with a retention policy.
+type Compactor struct {
+	store    RewritableStore
+	policy   RetentionPolicy
+	interval time.Duration
+	onError  StoreErrorHandler
+
+	mu      sync.Mutex
+	removed int
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewCompactor starts compacting store with policy every interval, until
+// Close is called. Failures are passed to onError, which may be nil.
+func NewCompactor(store RewritableStore, policy RetentionPolicy, interval time.Duration, onError StoreErrorHandler) *Compactor {
+	c := &Compactor{
+		store:    store,
+		policy:   policy,
+		interval: interval,
+		onError:  onError,
+		done:     make(chan struct{}),
+	}
+
+	c.wg.Add(1)
+	go c.run()
+
+	return c
+}
+
+// run compacts the store every interval until the compactor is closed.
+func (c *Compactor) run() {
+	defer c.wg.Done()
+
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			_, _ = c.Compact(context.Background())
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// Compact compacts the store now and returns how many messages were
+// removed.
+func (c *Compactor) Compact(ctx context.Context) (int, error) {
+	n, err := CompactStore(ctx, c.store, c.policy)
+	if err != nil {
+		storeErr := &StoreError{Op: "compact", Err: err}
+		if c.onError != nil {
+			c.onError(ctx, storeErr)
+		}
+		return n, storeErr
+	}
+
+	c.mu.Lock()
+	c.removed += n
+	c.mu.Unlock()
+	return n, nil
+}
+
+// Removed returns the number of messages removed so far.
+func (c *Compactor) Removed() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.removed
+}
+
+// Close stops the compactor.
+func (c *Compactor) Close() error {
+	select {
+	case <-c.done:
+		return fmt.Errorf("compactor already closed")
+	default:
+	}
+	close(c.done)
+	c.wg.Wait()
+	return nil
+}
+
+// WithRetention compacts the store of the persistent bus with policy every
+// interval, while the bus is open. It has no effect unless the store
+// implements RewritableStore. Failures are reported like other store
+// errors.
+func WithRetention(policy RetentionPolicy, interval time.Duration) PersistentBusOption {
+	return func(pb *PersistentBus) {
+		pb.retention = policy
+		pb.retentionInterval = interval
+	}
+}
@@ -0,0 +1,136 @@
+package scela
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// retentionMessages returns messages on two topics published a minute
+// apart, ending at now, keyed by the "entity" metadata.
+func retentionMessages(now time.Time) []Message {
+	specs := []struct {
+		topic, entity string
+	}{
+		{"orders", "o-1"},
+		{"orders", "o-2"},
+		{"users", "u-1"},
+		{"orders", "o-1"},
+		{"users", ""},
+		{"orders", "o-2"},
+	}
+	msgs := make([]Message, len(specs))
+	for i, spec := range specs {
+		msg := NewMessage(spec.topic, i).(*message)
+		msg.timestamp = now.Add(time.Duration(i-len(specs)+1) * time.Minute)
+		if spec.entity != "" {
+			msg.metadata["entity"] = spec.entity
+		}
+		msgs[i] = msg
+	}
+	return msgs
+}
+
+// payloads returns the payloads of msgs as a string.
+func payloads(msgs []Message) string {
+	values := make([]interface{}, len(msgs))
+	for i, msg := range msgs {
+		values[i] = msg.Payload()
+	}
+	return fmt.Sprint(values...)
+}
+
+func TestRetentionPolicies(t *testing.T) {
+	now := time.Now()
+	msgs := retentionMessages(now)
+
+	tests := []struct {
+		name   string
+		policy RetentionPolicy
+		want   string
+	}{
+		{"last per topic", KeepLastPerTopic(1), fmt.Sprint(4, 5)},
+		{"since", KeepSince(now.Add(-90 * time.Second)), fmt.Sprint(4, 5)},
+		{"within", KeepWithin(150 * time.Second), fmt.Sprint(3, 4, 5)},
+		{"latest per key", KeepLatestPerKey(MetadataKey("entity")), fmt.Sprint(2, 3, 4, 5)},
+		{"combined", CombineRetention(KeepLatestPerKey(MetadataKey("entity")), KeepLastPerTopic(1)), fmt.Sprint(4, 5)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := payloads(tt.policy.Retain(msgs, now)); got != tt.want {
+				t.Errorf("kept %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompactStore(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	sqlStore, err := NewSQLStore(SQLStoreConfig{DB: db})
+	if err != nil {
+		t.Fatalf("NewSQLStore() error = %v", err)
+	}
+	dir := t.TempDir()
+	jsonl := openJSONL(t, filepath.Join(dir, "messages.jsonl"))
+	wal := openWAL(t, filepath.Join(dir, "wal"))
+
+	stores := map[string]MessageStore{
+		"memory": NewInMemoryStore(0),
+		"file":   NewFileStore(filepath.Join(dir, "messages.json")),
+		"sql":    sqlStore,
+		"jsonl":  jsonl,
+		"wal":    wal,
+	}
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			for _, msg := range retentionMessages(time.Now()) {
+				if err := store.Store(ctx, msg); err != nil {
+					t.Fatalf("Store() error = %v", err)
+				}
+			}
+
+			n, err := CompactStore(ctx, store, KeepLastPerTopic(2))
+			if err != nil || n != 2 {
+				t.Fatalf("CompactStore() = %d, %v, want 2", n, err)
+			}
+			left, _ := store.Load(ctx)
+			if len(left) != 4 {
+				t.Fatalf("expected 4 messages left, got %d", len(left))
+			}
+		})
+	}
+}
+
+func TestCompactStore_NotRewritable(t *testing.T) {
+	store := NewReplayableStore(NewInMemoryStore(0), time.Now())
+	if _, err := CompactStore(context.Background(), store, KeepLastPerTopic(1)); err == nil {
+		t.Error("expected an error for a store that cannot be rewritten")
+	}
+}
+
+func TestWithRetention(t *testing.T) {
+	store := NewInMemoryStore(0)
+	pb := NewPersistentBus(New(), store, WithRetention(KeepLastPerTopic(1), 5*time.Millisecond))
+
+	for _, msg := range retentionMessages(time.Now()) {
+		_ = store.Store(context.Background(), msg)
+	}
+	waitFor(t, func() bool {
+		n, _ := store.Count(context.Background())
+		return n == 2
+	})
+
+	if err := pb.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if pb.compactor.Removed() != 4 {
+		t.Errorf("expected 4 removed messages, got %d", pb.compactor.Removed())
+	}
+	if err := pb.compactor.Close(); err == nil {
+		t.Error("expected an error closing the compactor twice")
+	}
+}
@@ -0,0 +1,126 @@
+package scela
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// Backoff returns how long to wait before the given retry attempt, starting
+// at 1. A zero or negative delay retries immediately.
+type Backoff func(attempt int) time.Duration
+
+// WithSubscriptionRetries overrides the bus WithMaxRetries setting for the
+// subscription. A failing handler is retried on its own, without redelivering
+// the message to the other handlers.
+func WithSubscriptionRetries(n int) SubscriptionOption {
+	return func(c *subscriptionConfig) {
+		if n >= 0 {
+			c.maxRetries = n
+			c.hasMaxRetries = true
+		}
+	}
+}
+
+// WithSubscriptionBackoff sets the delay between retries of the subscription.
+func WithSubscriptionBackoff(backoff Backoff) SubscriptionOption {
+	return func(c *subscriptionConfig) {
+		c.backoff = backoff
+	}
+}
+
+// WithSubscriptionDeadLetter overrides the bus dead letter handler for
+// messages whose retries are exhausted on the subscription. When several
+// dead letter options are given, the last one wins.
+func WithSubscriptionDeadLetter(handler Handler) SubscriptionOption {
+	return func(c *subscriptionConfig) {
+		c.dlqHandler = handler
+		c.dlqTopic = ""
+	}
+}
+
+// errPermanent is matched by the errors returned by Permanent.
+var errPermanent = errors.New("permanent failure")
+
+// permanentError is an error marked by Permanent.
+type permanentError struct {
+	err error
+}
+
+// Error implements the error interface.
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+// Unwrap returns the marked error and errPermanent.
+func (e *permanentError) Unwrap() []error {
+	return []error{e.err, errPermanent}
+}
+
+// Permanent marks err as a failure retrying cannot fix, such as a malformed
+// message: a handler returning it, even wrapped, has its message
+// dead-lettered without retries. The result matches err with errors.Is and
+// errors.As. Permanent returns nil for a nil error.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
+
+// IsPermanent reports whether err was marked by Permanent.
+func IsPermanent(err error) bool {
+	return errors.Is(err, errPermanent)
+}
+
+// ConstantBackoff waits the same delay before every retry.
+func ConstantBackoff(delay time.Duration) Backoff {
+	return func(int) time.Duration {
+		return delay
+	}
+}
+
+// ExponentialBackoff doubles the delay on each retry, starting at base and
+// capped at max. A zero max means no cap.
+func ExponentialBackoff(base, max time.Duration) Backoff {
+	if max <= 0 {
+		max = time.Duration(math.MaxInt64)
+	}
+	return func(attempt int) time.Duration {
+		delay := base
+		for i := 1; i < attempt; i++ {
+			if delay > max/2 {
+				return max
+			}
+			delay *= 2
+		}
+		if delay > max {
+			return max
+		}
+		return delay
+	}
+}
+
+// WithJitter randomizes the delays of backoff by up to fraction (0 to 1),
+// so retries of many failing messages do not hit a downstream service in
+// lockstep. A delay d becomes a random value between d*(1-fraction) and d.
+func WithJitter(backoff Backoff, fraction float64) Backoff {
+	if fraction < 0 {
+		fraction = 0
+	}
+	if fraction > 1 {
+		fraction = 1
+	}
+	return func(attempt int) time.Duration {
+		delay := backoff(attempt)
+		if delay <= 0 || fraction == 0 {
+			return delay
+		}
+		spread := int64(float64(delay) * fraction)
+		if spread <= 0 {
+			return delay
+		}
+		return delay - time.Duration(rand.Int63n(spread+1)) // #nosec G404 -- jitter does not need a secure source
+	}
+}
@@ -0,0 +1,252 @@
+package scela
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSubscribeWithOptions_RetryPolicy(t *testing.T) {
+	var busDLQ, emailDLQ atomic.Int32
+	bus := New(
+		WithMaxRetries(3),
+		WithDeadLetterHandler(HandlerFunc(func(ctx context.Context, msg Message) error {
+			busDLQ.Add(1)
+			return nil
+		})),
+	)
+	defer bus.Close()
+
+	var emailCalls, metricsCalls, auditCalls atomic.Int32
+	fail := errors.New("fail")
+
+	_, _ = bus.SubscribeWithOptions("user.created", HandlerFunc(func(ctx context.Context, msg Message) error {
+		emailCalls.Add(1)
+		return fail
+	}), WithSubscriptionRetries(10), WithSubscriptionDeadLetter(HandlerFunc(func(ctx context.Context, msg Message) error {
+		emailDLQ.Add(1)
+		return nil
+	})))
+	_, _ = bus.SubscribeWithOptions("user.created", HandlerFunc(func(ctx context.Context, msg Message) error {
+		metricsCalls.Add(1)
+		return fail
+	}), WithSubscriptionRetries(0))
+	_, _ = bus.Subscribe("user.created", HandlerFunc(func(ctx context.Context, msg Message) error {
+		auditCalls.Add(1)
+		return nil
+	}))
+
+	_ = bus.Publish(context.Background(), "user.created", nil)
+
+	deadline := time.Now().Add(time.Second)
+	for emailDLQ.Load() == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	time.Sleep(20 * time.Millisecond)
+
+	if n := emailCalls.Load(); n != 10 {
+		t.Errorf("Email handler called %d times, want 10", n)
+	}
+	if n := metricsCalls.Load(); n != 1 {
+		t.Errorf("Metrics handler called %d times, want 1", n)
+	}
+	if n := auditCalls.Load(); n != 1 {
+		t.Errorf("Succeeding handler called %d times, want 1", n)
+	}
+	if emailDLQ.Load() != 1 || busDLQ.Load() != 1 {
+		t.Errorf("Dead letters: email=%d bus=%d, want 1 each", emailDLQ.Load(), busDLQ.Load())
+	}
+}
+
+func TestSubscribeWithOptions_Backoff(t *testing.T) {
+	bus := New(WithMaxRetries(3))
+	defer bus.Close()
+
+	var attempts []time.Time
+	done := make(chan struct{})
+	var delays []int
+
+	_, _ = bus.SubscribeWithOptions("test", HandlerFunc(func(ctx context.Context, msg Message) error {
+		attempts = append(attempts, time.Now())
+		if len(attempts) == 3 {
+			close(done)
+			return nil
+		}
+		return errors.New("fail")
+	}), WithSubscriptionBackoff(func(attempt int) time.Duration {
+		delays = append(delays, attempt)
+		return 20 * time.Millisecond
+	}))
+
+	_ = bus.Publish(context.Background(), "test", nil)
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Handler did not succeed after retries")
+	}
+
+	if len(delays) != 2 || delays[0] != 1 || delays[1] != 2 {
+		t.Errorf("Backoff attempts = %v, want [1 2]", delays)
+	}
+	for i := 1; i < len(attempts); i++ {
+		if gap := attempts[i].Sub(attempts[i-1]); gap < 20*time.Millisecond {
+			t.Errorf("Retry %d after %v, want at least 20ms", i, gap)
+		}
+	}
+}
+
+func TestBus_DelayedRetryAfterClose(t *testing.T) {
+	bus := New(WithMaxRetries(3))
+
+	called := make(chan struct{}, 1)
+	_, _ = bus.SubscribeWithOptions("test", HandlerFunc(func(ctx context.Context, msg Message) error {
+		called <- struct{}{}
+		return errors.New("fail")
+	}), WithSubscriptionBackoff(func(int) time.Duration { return 20 * time.Millisecond }))
+
+	_ = bus.Publish(context.Background(), "test", nil)
+	<-called
+	_ = bus.Close()
+
+	// The pending retry must not panic on the closed queue
+	time.Sleep(40 * time.Millisecond)
+}
+
+func TestBackoffStrategies(t *testing.T) {
+	constant := ConstantBackoff(50 * time.Millisecond)
+	if constant(1) != 50*time.Millisecond || constant(7) != 50*time.Millisecond {
+		t.Error("ConstantBackoff() should return the same delay for every attempt")
+	}
+
+	exp := ExponentialBackoff(10*time.Millisecond, 100*time.Millisecond)
+	want := []time.Duration{10, 20, 40, 80, 100, 100}
+	for i, w := range want {
+		if got := exp(i + 1); got != w*time.Millisecond {
+			t.Errorf("ExponentialBackoff attempt %d = %v, want %v", i+1, got, w*time.Millisecond)
+		}
+	}
+
+	if got := ExponentialBackoff(time.Second, 0)(50); got <= 0 {
+		t.Errorf("Uncapped ExponentialBackoff overflowed: %v", got)
+	}
+
+	jittered := WithJitter(ConstantBackoff(100*time.Millisecond), 0.5)
+	for i := 0; i < 100; i++ {
+		if d := jittered(1); d < 50*time.Millisecond || d > 100*time.Millisecond {
+			t.Fatalf("Jittered delay %v outside [50ms, 100ms]", d)
+		}
+	}
+	if d := WithJitter(ConstantBackoff(time.Second), 0)(1); d != time.Second {
+		t.Errorf("Zero jitter changed the delay to %v", d)
+	}
+}
+
+func TestBus_WithRetryBackoff(t *testing.T) {
+	bus := New(WithMaxRetries(3), WithRetryBackoff(ConstantBackoff(30*time.Millisecond)))
+	defer bus.Close()
+
+	var calls atomic.Int32
+	_, _ = bus.Subscribe("test", HandlerFunc(func(ctx context.Context, msg Message) error {
+		calls.Add(1)
+		return errors.New("fail")
+	}))
+
+	_ = bus.Publish(context.Background(), "test", nil)
+	time.Sleep(15 * time.Millisecond)
+	if n := calls.Load(); n != 1 {
+		t.Errorf("Expected retry to wait for the backoff, got %d calls", n)
+	}
+
+	time.Sleep(80 * time.Millisecond)
+	if n := calls.Load(); n != 3 {
+		t.Errorf("Expected 3 attempts after backoff, got %d", n)
+	}
+}
+
+type retryObserver struct {
+	countingObserver
+	attempts    chan int
+	deadLetters chan error
+}
+
+func (o *retryObserver) OnRetry(ctx context.Context, msg Message, attempt int, err error) {
+	o.attempts <- attempt
+}
+
+func (o *retryObserver) OnDeadLetter(ctx context.Context, msg Message, err error) {
+	o.deadLetters <- err
+}
+
+func TestRetryObserver(t *testing.T) {
+	obs := &retryObserver{attempts: make(chan int, 10), deadLetters: make(chan error, 1)}
+	bus := New(WithMaxRetries(3), WithObserver(obs))
+	defer bus.Close()
+
+	handlerErr := errors.New("boom")
+	_, _ = bus.Subscribe("test", HandlerFunc(func(ctx context.Context, msg Message) error {
+		return handlerErr
+	}))
+	_ = bus.Publish(context.Background(), "test", nil)
+
+	select {
+	case err := <-obs.deadLetters:
+		if !errors.Is(err, handlerErr) {
+			t.Errorf("expected the handler error, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected a dead letter notification")
+	}
+
+	close(obs.attempts)
+	var attempts []int
+	for a := range obs.attempts {
+		attempts = append(attempts, a)
+	}
+	if len(attempts) != 2 || attempts[0] != 1 || attempts[1] != 2 {
+		t.Errorf("expected retries after attempts 1 and 2, got %v", attempts)
+	}
+}
+
+func TestPermanent_SkipsRetries(t *testing.T) {
+	dead := make(chan Message, 1)
+	bus := New(
+		WithMaxRetries(3),
+		WithDeadLetterHandler(HandlerFunc(func(ctx context.Context, msg Message) error {
+			dead <- msg
+			return nil
+		})),
+	)
+	defer bus.Close()
+
+	malformed := errors.New("malformed order")
+	var calls atomic.Int32
+	_, _ = bus.Subscribe("order.created", HandlerFunc(func(ctx context.Context, msg Message) error {
+		calls.Add(1)
+		return fmt.Errorf("handling order: %w", Permanent(malformed))
+	}))
+
+	_ = bus.Publish(context.Background(), "order.created", 1)
+	select {
+	case msg := <-dead:
+		if msg.Metadata()[MetadataDeadLetterAttempts] != 1 {
+			t.Errorf("expected 1 attempt, got %v", msg.Metadata()[MetadataDeadLetterAttempts])
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the message to be dead-lettered")
+	}
+	if calls.Load() != 1 {
+		t.Errorf("expected a single call, got %d", calls.Load())
+	}
+
+	err := Permanent(malformed)
+	if !errors.Is(err, malformed) || !IsPermanent(err) || IsPermanent(malformed) {
+		t.Errorf("unexpected matching of %v", err)
+	}
+	if Permanent(nil) != nil {
+		t.Error("expected Permanent(nil) to be nil")
+	}
+}
@@ -0,0 +1,261 @@
+package scela
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"unicode"
+)
+
+// RouterOption is a functional option for configuring RegisterHandlers.
+type RouterOption func(*routerConfig)
+
+// routerConfig holds the options of RegisterHandlers.
+type routerConfig struct {
+	topicName func(name string) string
+	subOpts   []SubscriptionOption
+}
+
+// WithTopicNaming sets how the topic of a handler method is derived from
+// its name without the "On" prefix, TopicFromName by default.
+func WithTopicNaming(fn func(name string) string) RouterOption {
+	return func(c *routerConfig) {
+		if fn != nil {
+			c.topicName = fn
+		}
+	}
+}
+
+// WithRouteOptions applies subscription options to every handler
+// registered.
+func WithRouteOptions(opts ...SubscriptionOption) RouterOption {
+	return func(c *routerConfig) {
+		c.subOpts = append(c.subOpts, opts...)
+	}
+}
+
+// Route is a handler found by Routes.
+type Route struct {
+	// Pattern is the topic pattern the handler is subscribed to.
+	Pattern string
+	// Name is the name of the method or field.
+	Name string
+	// PayloadType is the type payloads are converted to, nil for handlers
+	// taking the Message.
+	PayloadType reflect.Type
+
+	fn reflect.Value
+}
+
+var (
+	contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+	errorType   = reflect.TypeOf((*error)(nil)).Elem()
+	messageType = reflect.TypeOf((*Message)(nil)).Elem()
+)
+
+// TopicFromName derives a topic from a CamelCase name, one segment per
+// word: "UserCreated" gives "user.created" and "HTTPRequestFailed"
+// "http.request.failed".
+func TopicFromName(name string) string {
+	runes := []rune(name)
+	var b strings.Builder
+	for i, r := range runes {
+		if i > 0 && unicode.IsUpper(r) {
+			prev := runes[i-1]
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if !unicode.IsUpper(prev) || nextLower {
+				b.WriteByte('.')
+			}
+		}
+		b.WriteRune(unicode.ToLower(r))
+	}
+	return b.String()
+}
+
+// Routes returns the handlers of h that RegisterHandlers subscribes:
+//
+//   - methods named On<Name>, subscribed to the topic derived from Name
+//     (see WithTopicNaming), such as OnUserCreated for "user.created"
+//   - exported fields of func type tagged `scela:"pattern"`, subscribed to
+//     the pattern of the tag, for patterns that names cannot express
+//
+// Handlers take a context.Context and either the Message or a payload of
+// any other type, and return an error. Payloads are converted to the type
+// of the handler like PayloadAs does. A method or tagged field of another
+// shape is an error, so that a typo does not silently drop a handler.
+// Pass a pointer for methods with a pointer receiver to be found.
+func Routes(h interface{}, opts ...RouterOption) ([]Route, error) {
+	cfg := routerConfig{topicName: TopicFromName}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	v := reflect.ValueOf(h)
+	if !v.IsValid() {
+		return nil, ErrNilHandler
+	}
+
+	var routes []Route
+	t := v.Type()
+	for i := 0; i < t.NumMethod(); i++ {
+		m := t.Method(i)
+		name, ok := strings.CutPrefix(m.Name, "On")
+		if !ok || name == "" {
+			continue
+		}
+		route, err := newRoute(cfg.topicName(name), m.Name, v.Method(i))
+		if err != nil {
+			return nil, err
+		}
+		routes = append(routes, route)
+	}
+
+	sv := v
+	for sv.Kind() == reflect.Pointer && !sv.IsNil() {
+		sv = sv.Elem()
+	}
+	if sv.Kind() != reflect.Struct {
+		return routes, nil
+	}
+	st := sv.Type()
+	for i := 0; i < st.NumField(); i++ {
+		f := st.Field(i)
+		pattern, ok := f.Tag.Lookup("scela")
+		if !ok || pattern == "-" {
+			continue
+		}
+		if !f.IsExported() {
+			return nil, fmt.Errorf("handler field %s must be exported", f.Name)
+		}
+		if f.Type.Kind() != reflect.Func || sv.Field(i).IsNil() {
+			return nil, fmt.Errorf("handler field %s must be a non-nil func", f.Name)
+		}
+		route, err := newRoute(pattern, f.Name, sv.Field(i))
+		if err != nil {
+			return nil, err
+		}
+		routes = append(routes, route)
+	}
+
+	return routes, nil
+}
+
+// newRoute checks that fn is a handler and returns its route.
+func newRoute(pattern, name string, fn reflect.Value) (Route, error) {
+	t := fn.Type()
+	if t.NumIn() != 2 || t.In(0) != contextType || t.NumOut() != 1 || t.Out(0) != errorType {
+		return Route{}, fmt.Errorf("handler %s must be func(context.Context, T) error, got %s", name, t)
+	}
+	route := Route{Pattern: pattern, Name: name, fn: fn}
+	if t.In(1) != messageType {
+		route.PayloadType = t.In(1)
+	}
+	return route, nil
+}
+
+// handler returns the handler calling the route, converting payloads to
+// its type. Conversion failures go to the type mismatch handler of cfg,
+// like with SubscribeTyped.
+func (r Route) handler(cfg subscriptionConfig) Handler {
+	return HandlerFunc(func(ctx context.Context, msg Message) error {
+		arg := reflect.ValueOf(msg)
+		if r.PayloadType != nil {
+			payload, err := payloadAsType(msg.Payload(), r.PayloadType)
+			if err != nil {
+				err = fmt.Errorf("message %s on %s: %w", msg.ID(), msg.Topic(), err)
+				if cfg.onTypeMismatch != nil {
+					return cfg.onTypeMismatch(ctx, msg, err)
+				}
+				return err
+			}
+			arg = payload
+		}
+
+		out := r.fn.Call([]reflect.Value{reflect.ValueOf(ctx), arg})
+		err, _ := out[0].Interface().(error)
+		return err
+	})
+}
+
+// RegisterHandlers subscribes the handlers of h found by Routes, with the
+// subscription options given by WithRouteOptions. If a subscription fails,
+// those already made are removed.
+func RegisterHandlers(b Subscriber, h interface{}, opts ...RouterOption) ([]Subscription, error) {
+	routes, err := Routes(h, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	var cfg routerConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	var subCfg subscriptionConfig
+	for _, opt := range cfg.subOpts {
+		opt(&subCfg)
+	}
+
+	subs := make([]Subscription, 0, len(routes))
+	for _, route := range routes {
+		sub, err := b.SubscribeWithOptions(route.Pattern, route.handler(subCfg), cfg.subOpts...)
+		if err != nil {
+			for _, s := range subs {
+				_ = s.Unsubscribe()
+			}
+			return nil, fmt.Errorf("failed to subscribe %s to %s: %w", route.Name, route.Pattern, err)
+		}
+		subs = append(subs, sub)
+	}
+	return subs, nil
+}
+
+// payloadAsType converts a message payload to a value of type t, as
+// PayloadAs does for a type known at compile time.
+func payloadAsType(payload interface{}, t reflect.Type) (reflect.Value, error) {
+	if payload == nil {
+		return reflect.Value{}, fmt.Errorf("%w: got nil, want %s", ErrPayloadType, t)
+	}
+
+	pv := reflect.ValueOf(payload)
+	value := reflect.New(t).Elem()
+	switch {
+	case pv.Type().AssignableTo(t):
+		value.Set(pv)
+	case pv.Kind() == reflect.Pointer && pv.Type().Elem() == t:
+		if pv.IsNil() {
+			return reflect.Value{}, fmt.Errorf("%w: nil %T", ErrPayloadType, payload)
+		}
+		value.Set(pv.Elem())
+	default:
+		var data []byte
+		switch p := payload.(type) {
+		case []byte:
+			data = p
+		case json.RawMessage:
+			data = p
+		case map[string]interface{}, []interface{}, float64, json.Number:
+			var err error
+			if data, err = json.Marshal(p); err != nil {
+				return reflect.Value{}, fmt.Errorf("%w: %v", ErrPayloadType, err)
+			}
+		default:
+			return reflect.Value{}, fmt.Errorf("%w: got %T, want %s", ErrPayloadType, payload, t)
+		}
+		if err := json.Unmarshal(data, value.Addr().Interface()); err != nil {
+			return reflect.Value{}, fmt.Errorf("%w: cannot convert %T to %s: %v", ErrPayloadType, payload, t, err)
+		}
+	}
+
+	v, ok := value.Interface().(Validator)
+	if !ok {
+		v, ok = value.Addr().Interface().(Validator)
+	}
+	if ok {
+		if err := v.Validate(); err != nil {
+			return reflect.Value{}, fmt.Errorf("invalid payload: %w", err)
+		}
+	}
+	return value, nil
+}
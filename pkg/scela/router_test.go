@@ -0,0 +1,157 @@
+package scela
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+)
+
+type userCreated struct {
+	ID    string `json:"id"`
+	Email string `json:"email"`
+}
+
+// userService handles user events through methods and tagged fields.
+type userService struct {
+	mu       sync.Mutex
+	created  []userCreated
+	deleted  []string
+	payments []Message
+
+	OnAnyPayment func(ctx context.Context, msg Message) error `scela:"payment.#"`
+	Ignored      func(ctx context.Context, msg Message) error `scela:"-"`
+}
+
+func (s *userService) OnUserCreated(ctx context.Context, event userCreated) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.created = append(s.created, event)
+	return nil
+}
+
+func (s *userService) OnUserDeleted(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.deleted = append(s.deleted, id)
+	return nil
+}
+
+// Helper is not a handler.
+func (s *userService) Helper() {}
+
+func TestTopicFromName(t *testing.T) {
+	tests := map[string]string{
+		"UserCreated":       "user.created",
+		"HTTPRequestFailed": "http.request.failed",
+		"OrderV2Shipped":    "order.v2.shipped",
+		"Ping":              "ping",
+	}
+	for name, want := range tests {
+		if got := TopicFromName(name); got != want {
+			t.Errorf("TopicFromName(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestRegisterHandlers(t *testing.T) {
+	bus := New()
+	defer bus.Close()
+
+	svc := &userService{}
+	svc.OnAnyPayment = func(ctx context.Context, msg Message) error {
+		svc.mu.Lock()
+		defer svc.mu.Unlock()
+		svc.payments = append(svc.payments, msg)
+		return nil
+	}
+
+	subs, err := RegisterHandlers(bus, svc)
+	if err != nil {
+		t.Fatalf("RegisterHandlers() error = %v", err)
+	}
+	if len(subs) != 3 {
+		t.Fatalf("expected 3 subscriptions, got %d", len(subs))
+	}
+
+	ctx := context.Background()
+	_ = bus.PublishSync(ctx, "user.created", userCreated{ID: "u-1"})
+	// As decoded from a persistent store
+	_ = bus.PublishSync(ctx, "user.created", map[string]interface{}{"id": "u-2", "email": "a@example.com"})
+	_ = bus.PublishSync(ctx, "user.deleted", "u-1")
+	_ = bus.PublishSync(ctx, "payment.card.captured", 42)
+
+	if len(svc.created) != 2 || svc.created[1].Email != "a@example.com" {
+		t.Errorf("unexpected created events %+v", svc.created)
+	}
+	if len(svc.deleted) != 1 || svc.deleted[0] != "u-1" {
+		t.Errorf("unexpected deleted events %v", svc.deleted)
+	}
+	if len(svc.payments) != 1 || svc.payments[0].Payload() != 42 {
+		t.Errorf("unexpected payments %v", svc.payments)
+	}
+
+	if err := bus.PublishSync(ctx, "user.deleted", 7); !errors.Is(err, ErrPayloadType) {
+		t.Errorf("expected ErrPayloadType for a mismatched payload, got %v", err)
+	}
+}
+
+type badSignature struct{}
+
+func (badSignature) OnUserCreated(event userCreated) error { return nil }
+
+type unexportedField struct {
+	onPayment func(ctx context.Context, msg Message) error `scela:"payment"`
+}
+
+func TestRoutes_RejectsMisshapenHandlers(t *testing.T) {
+	if _, err := Routes(badSignature{}); err == nil {
+		t.Error("expected a method without a context rejected")
+	}
+	if _, err := Routes(&unexportedField{}); err == nil {
+		t.Error("expected an unexported field rejected")
+	}
+	if _, err := Routes(&userService{}); err == nil {
+		t.Error("expected a nil tagged field rejected")
+	}
+}
+
+func TestRegisterHandlers_Options(t *testing.T) {
+	bus := New()
+	defer bus.Close()
+
+	svc := &userService{OnAnyPayment: func(ctx context.Context, msg Message) error { return nil }}
+	var mismatched []Message
+	_, err := RegisterHandlers(bus, svc,
+		WithTopicNaming(func(name string) string { return "users." + TopicFromName(name) }),
+		WithRouteOptions(WithTypeMismatchHandler(func(ctx context.Context, msg Message, err error) error {
+			mismatched = append(mismatched, msg)
+			return nil
+		})),
+	)
+	if err != nil {
+		t.Fatalf("RegisterHandlers() error = %v", err)
+	}
+
+	ctx := context.Background()
+	_ = bus.PublishSync(ctx, "users.user.deleted", "u-1")
+	if err := bus.PublishSync(ctx, "users.user.deleted", 7); err != nil {
+		t.Errorf("expected the mismatch handled, got %v", err)
+	}
+	if len(svc.deleted) != 1 || len(mismatched) != 1 {
+		t.Errorf("deleted %v, mismatched %d", svc.deleted, len(mismatched))
+	}
+}
+
+func TestRegisterHandlers_RollsBackOnFailure(t *testing.T) {
+	bus := New(WithMaxSubscriptions(2))
+	defer bus.Close()
+
+	svc := &userService{OnAnyPayment: func(ctx context.Context, msg Message) error { return nil }}
+	if _, err := RegisterHandlers(bus, svc); !errors.Is(err, ErrSubscriptionLimit) {
+		t.Fatalf("expected ErrSubscriptionLimit, got %v", err)
+	}
+	if subs := bus.(SubscriptionInspector).Subscriptions(); len(subs) != 0 {
+		t.Errorf("expected the subscriptions made removed, got %d", len(subs))
+	}
+}
@@ -0,0 +1,347 @@
+// Package scelaadmin serves a JSON API for operating a scela bus from
+// dashboards and scripts: statistics, subscriptions, topics, dead letters,
+// pausing topics and triggering replays. It can also serve a web dashboard
+// built on the API, see WithDashboard.
+//
+// The handler is meant to be mounted on an internal listener, behind an
+// authorization hook:
+//
+//	admin := scelaadmin.New(bus,
+//		scelaadmin.WithAuth(requireToken),
+//		scelaadmin.WithDeadLetterQueue(dlq),
+//		scelaadmin.WithReplayer(persistentBus),
+//		scelaadmin.WithMonitor(monitor),
+//		scelaadmin.WithDashboard("orders service"),
+//	)
+//	mux.Handle("/admin/", http.StripPrefix("/admin", admin))
+//
+// Endpoints:
+//
+//	GET  /stats                        scela.Stats of the bus
+//	GET  /subscriptions                registered subscriptions
+//	GET  /topics                       subscribed, paused and measured topics
+//	GET  /activity                     message counts and recent failures
+//	POST /topics/pause                 pause {"Pattern": "orders.*"}
+//	POST /topics/resume                resume {"Pattern": "orders.*"}
+//	GET  /deadletters                  dead-lettered messages
+//	POST /deadletters/{id}/requeue     publish a dead letter again
+//	POST /replay                       replay {"Start": 0, "All": false}
+//	GET  /                             the dashboard, with WithDashboard
+//
+// Errors are returned as {"Error": "..."}. Endpoints the bus or the options
+// do not support answer 501 Not Implemented.
+package scelaadmin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/toutaio/toutago-scela-bus/pkg/scela"
+)
+
+// AuthFunc authorizes an admin request. Returning an error rejects it with
+// 401 Unauthorized, or 403 Forbidden if the error wraps ErrForbidden.
+type AuthFunc func(r *http.Request) error
+
+// ErrForbidden is wrapped by AuthFunc errors rejecting authenticated callers
+// that may not operate the bus.
+var ErrForbidden = errors.New("forbidden")
+
+// Replayer replays persisted messages, as scela.PersistentBus does.
+type Replayer interface {
+	Replay(ctx context.Context, opts ...scela.ReplayOption) error
+}
+
+// Option configures a Handler.
+type Option func(*Handler)
+
+// WithAuth authorizes every request with fn before it is served.
+func WithAuth(fn AuthFunc) Option {
+	return func(h *Handler) {
+		h.auth = fn
+	}
+}
+
+// WithDeadLetterQueue serves the contents of q and lets them be requeued.
+func WithDeadLetterQueue(q *scela.DeadLetterQueue) Option {
+	return func(h *Handler) {
+		h.dlq = q
+	}
+}
+
+// WithReplayer lets replays be triggered through r.
+func WithReplayer(r Replayer) Option {
+	return func(h *Handler) {
+		h.replayer = r
+	}
+}
+
+// WithMonitor serves the activity recorded by m, which must observe the
+// bus.
+func WithMonitor(m *Monitor) Option {
+	return func(h *Handler) {
+		h.monitor = m
+	}
+}
+
+// Handler is an http.Handler serving the admin API of a bus.
+type Handler struct {
+	bus       scela.Bus
+	auth      AuthFunc
+	dlq       *scela.DeadLetterQueue
+	replayer  Replayer
+	monitor   *Monitor
+	dashboard string
+	mux       *http.ServeMux
+}
+
+// New creates the admin API of b. Without WithAuth every request is served.
+func New(b scela.Bus, opts ...Option) *Handler {
+	h := &Handler{bus: b, mux: http.NewServeMux()}
+	for _, opt := range opts {
+		opt(h)
+	}
+
+	h.mux.HandleFunc("GET /stats", h.stats)
+	h.mux.HandleFunc("GET /subscriptions", h.subscriptions)
+	h.mux.HandleFunc("GET /topics", h.topics)
+	h.mux.HandleFunc("GET /activity", h.activity)
+	h.mux.HandleFunc("POST /topics/pause", h.pause)
+	h.mux.HandleFunc("POST /topics/resume", h.resume)
+	h.mux.HandleFunc("GET /deadletters", h.deadLetters)
+	h.mux.HandleFunc("POST /deadletters/{id}/requeue", h.requeue)
+	h.mux.HandleFunc("POST /replay", h.replay)
+	if h.dashboard != "" {
+		h.routeDashboard()
+	}
+	return h
+}
+
+// ServeHTTP implements http.Handler.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.auth != nil {
+		if err := h.auth(r); err != nil {
+			status := http.StatusUnauthorized
+			if errors.Is(err, ErrForbidden) {
+				status = http.StatusForbidden
+			}
+			writeError(w, status, err)
+			return
+		}
+	}
+	h.mux.ServeHTTP(w, r)
+}
+
+// Topic describes a topic or pattern known to the bus.
+type Topic struct {
+	Name string
+	// Subscriptions is the number of subscriptions made with Name as their
+	// pattern.
+	Subscriptions int
+	// Paused reports whether Name was paused with PauseTopic.
+	Paused bool
+	// Latency is the end-to-end latency measured on Name, if the bus
+	// tracks latency.
+	Latency *scela.LatencySnapshot `json:",omitempty"`
+}
+
+// DeadLetter is a dead-lettered message.
+type DeadLetter struct {
+	ID        string
+	Topic     string
+	Payload   interface{}
+	Metadata  map[string]interface{}
+	Timestamp time.Time
+	Priority  scela.Priority
+}
+
+// PatternRequest is the body of the pause and resume endpoints.
+type PatternRequest struct {
+	Pattern string
+}
+
+// ReplayRequest is the optional body of the replay endpoint.
+type ReplayRequest struct {
+	// Start skips the first Start messages, see scela.WithReplayStart.
+	Start int
+	// All replays delivered messages too, see scela.WithReplayAll.
+	All bool
+}
+
+// errorResponse is the body of failed requests.
+type errorResponse struct {
+	Error string
+}
+
+func (h *Handler) stats(w http.ResponseWriter, r *http.Request) {
+	stats, ok := scela.StatsOf(h.bus)
+	if !ok {
+		writeError(w, http.StatusNotImplemented, fmt.Errorf("bus does not report statistics"))
+		return
+	}
+	writeJSON(w, http.StatusOK, stats)
+}
+
+func (h *Handler) subscriptions(w http.ResponseWriter, r *http.Request) {
+	infos, ok := scela.InspectSubscriptions(h.bus)
+	if !ok {
+		writeError(w, http.StatusNotImplemented, fmt.Errorf("bus cannot list its subscriptions"))
+		return
+	}
+	writeJSON(w, http.StatusOK, infos)
+}
+
+func (h *Handler) topics(w http.ResponseWriter, r *http.Request) {
+	topics := make(map[string]*Topic)
+	topic := func(name string) *Topic {
+		if topics[name] == nil {
+			topics[name] = &Topic{Name: name}
+		}
+		return topics[name]
+	}
+
+	if infos, ok := scela.InspectSubscriptions(h.bus); ok {
+		for _, info := range infos {
+			topic(info.Pattern).Subscriptions++
+		}
+	}
+	if pauser, ok := scela.PauserOf(h.bus); ok {
+		for _, pattern := range pauser.PausedTopics() {
+			topic(pattern).Paused = true
+		}
+	}
+	if stats, ok := scela.StatsOf(h.bus); ok {
+		for name, latency := range stats.Topics {
+			topic(name).Latency = &latency
+		}
+	}
+
+	result := make([]Topic, 0, len(topics))
+	for _, t := range topics {
+		result = append(result, *t)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	writeJSON(w, http.StatusOK, result)
+}
+
+func (h *Handler) activity(w http.ResponseWriter, r *http.Request) {
+	if h.monitor == nil {
+		writeError(w, http.StatusNotImplemented, fmt.Errorf("no monitor configured"))
+		return
+	}
+	writeJSON(w, http.StatusOK, h.monitor.Activity())
+}
+
+func (h *Handler) pause(w http.ResponseWriter, r *http.Request) {
+	h.setPaused(w, r, scela.TopicPauser.PauseTopic)
+}
+
+func (h *Handler) resume(w http.ResponseWriter, r *http.Request) {
+	h.setPaused(w, r, scela.TopicPauser.ResumeTopic)
+}
+
+// setPaused applies change to the pattern of the request and answers with
+// the paused patterns.
+func (h *Handler) setPaused(w http.ResponseWriter, r *http.Request, change func(scela.TopicPauser, string) error) {
+	pauser, ok := scela.PauserOf(h.bus)
+	if !ok {
+		writeError(w, http.StatusNotImplemented, fmt.Errorf("bus cannot pause topics"))
+		return
+	}
+	var req PatternRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+		return
+	}
+	if err := change(pauser, req.Pattern); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, pauser.PausedTopics())
+}
+
+func (h *Handler) deadLetters(w http.ResponseWriter, r *http.Request) {
+	if h.dlq == nil {
+		writeError(w, http.StatusNotImplemented, fmt.Errorf("no dead letter queue configured"))
+		return
+	}
+	msgs, err := h.dlq.List(r.Context())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	letters := make([]DeadLetter, len(msgs))
+	for i, msg := range msgs {
+		letters[i] = DeadLetter{
+			ID:        msg.ID(),
+			Topic:     msg.Topic(),
+			Payload:   msg.Payload(),
+			Metadata:  msg.Metadata(),
+			Timestamp: msg.Timestamp(),
+			Priority:  scela.MessagePriority(msg),
+		}
+	}
+	writeJSON(w, http.StatusOK, letters)
+}
+
+func (h *Handler) requeue(w http.ResponseWriter, r *http.Request) {
+	if h.dlq == nil {
+		writeError(w, http.StatusNotImplemented, fmt.Errorf("no dead letter queue configured"))
+		return
+	}
+	if err := h.dlq.Requeue(r.Context(), r.PathValue("id")); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, scela.ErrDeadLetterNotFound) {
+			status = http.StatusNotFound
+		}
+		writeError(w, status, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) replay(w http.ResponseWriter, r *http.Request) {
+	if h.replayer == nil {
+		writeError(w, http.StatusNotImplemented, fmt.Errorf("no replayer configured"))
+		return
+	}
+	var req ReplayRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+			return
+		}
+	}
+
+	var progress scela.ReplayProgress
+	opts := []scela.ReplayOption{
+		scela.WithReplayStart(req.Start),
+		scela.WithReplayProgress(func(p scela.ReplayProgress) { progress = p }),
+	}
+	if req.All {
+		opts = append(opts, scela.WithReplayAll())
+	}
+	if err := h.replayer.Replay(r.Context(), opts...); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, progress)
+}
+
+// writeJSON writes v as the JSON body of a response with the given status.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// writeError writes err as the JSON body of a failed response.
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, errorResponse{Error: err.Error()})
+}
@@ -0,0 +1,220 @@
+package scelaadmin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/toutaio/toutago-scela-bus/pkg/scela"
+)
+
+func noop(ctx context.Context, msg scela.Message) error { return nil }
+
+// do serves a request with body, if not empty, and decodes the JSON
+// response into out, if not nil. It returns the status code.
+func do(t *testing.T, h http.Handler, method, path, body string, out interface{}) int {
+	t.Helper()
+	var req *http.Request
+	if body == "" {
+		req = httptest.NewRequest(method, path, nil)
+	} else {
+		req = httptest.NewRequest(method, path, strings.NewReader(body))
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	if out != nil {
+		if err := json.Unmarshal(rec.Body.Bytes(), out); err != nil {
+			t.Fatalf("%s %s: invalid response %q: %v", method, path, rec.Body.String(), err)
+		}
+	}
+	return rec.Code
+}
+
+func TestHandler_StatsAndSubscriptions(t *testing.T) {
+	bus := scela.New(scela.WithLatencyTracking(0))
+	defer bus.Close()
+	_, _ = bus.Subscribe("orders.*", scela.HandlerFunc(noop))
+	_, _ = bus.Subscribe("orders.*", scela.HandlerFunc(noop))
+	_, _ = bus.Subscribe("users", scela.HandlerFunc(noop))
+	_ = bus.PublishSync(context.Background(), "orders.created", "o-1")
+
+	h := New(bus)
+
+	var stats scela.Stats
+	if code := do(t, h, "GET", "/stats", "", &stats); code != http.StatusOK {
+		t.Fatalf("GET /stats = %d", code)
+	}
+	if stats.Subscriptions != 3 || stats.Latency.Count != 1 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+
+	var infos []scela.SubscriptionInfo
+	do(t, h, "GET", "/subscriptions", "", &infos)
+	if len(infos) != 3 || infos[0].Pattern != "orders.*" {
+		t.Errorf("unexpected subscriptions %+v", infos)
+	}
+
+	var topics []Topic
+	do(t, h, "GET", "/topics", "", &topics)
+	got := make([]string, len(topics))
+	for i, topic := range topics {
+		got[i] = fmt.Sprintf("%s:%d:%v", topic.Name, topic.Subscriptions, topic.Latency != nil)
+	}
+	if want := "[orders.*:2:false orders.created:0:true users:1:false]"; fmt.Sprint(got) != want {
+		t.Errorf("topics = %v, want %s", got, want)
+	}
+}
+
+func TestHandler_PauseAndResume(t *testing.T) {
+	bus := scela.New()
+	defer bus.Close()
+	h := New(bus)
+
+	var paused []string
+	if code := do(t, h, "POST", "/topics/pause", `{"Pattern": "orders.*"}`, &paused); code != http.StatusOK {
+		t.Fatalf("POST /topics/pause = %d", code)
+	}
+	if fmt.Sprint(paused) != "[orders.*]" {
+		t.Errorf("paused = %v", paused)
+	}
+
+	var topics []Topic
+	do(t, h, "GET", "/topics", "", &topics)
+	if len(topics) != 1 || !topics[0].Paused {
+		t.Errorf("expected the paused pattern listed, got %+v", topics)
+	}
+
+	do(t, h, "POST", "/topics/resume", `{"Pattern": "orders.*"}`, &paused)
+	if len(paused) != 0 {
+		t.Errorf("expected no paused topic, got %v", paused)
+	}
+
+	var resp errorResponse
+	if code := do(t, h, "POST", "/topics/pause", `{"Pattern": ""}`, &resp); code != http.StatusBadRequest || resp.Error == "" {
+		t.Errorf("expected an empty pattern to be rejected, got %d %+v", code, resp)
+	}
+	if code := do(t, h, "POST", "/topics/pause", `not json`, &resp); code != http.StatusBadRequest {
+		t.Errorf("expected an invalid body to be rejected, got %d", code)
+	}
+}
+
+func TestHandler_DeadLetters(t *testing.T) {
+	store := scela.NewInMemoryStore(0)
+	bus := scela.New()
+	defer bus.Close()
+	dlq := scela.NewDeadLetterQueue(store, bus)
+	_ = dlq.Handle(context.Background(), scela.NewMessage("orders", "o-1"))
+
+	requeued := make(chan scela.Message, 1)
+	_, _ = bus.Subscribe("orders", scela.HandlerFunc(func(ctx context.Context, msg scela.Message) error {
+		requeued <- msg
+		return nil
+	}))
+
+	h := New(bus, WithDeadLetterQueue(dlq))
+
+	var letters []DeadLetter
+	do(t, h, "GET", "/deadletters", "", &letters)
+	if len(letters) != 1 || letters[0].Payload != "o-1" {
+		t.Fatalf("unexpected dead letters %+v", letters)
+	}
+
+	if code := do(t, h, "POST", "/deadletters/"+letters[0].ID+"/requeue", "", nil); code != http.StatusNoContent {
+		t.Fatalf("requeue = %d", code)
+	}
+	select {
+	case msg := <-requeued:
+		if msg.Payload() != "o-1" {
+			t.Errorf("unexpected requeued payload %v", msg.Payload())
+		}
+	case <-time.After(time.Second):
+		t.Fatal("dead letter not requeued")
+	}
+
+	if code := do(t, h, "POST", "/deadletters/"+letters[0].ID+"/requeue", "", nil); code != http.StatusNotFound {
+		t.Errorf("expected a requeued dead letter to be gone, got %d", code)
+	}
+}
+
+func TestHandler_Replay(t *testing.T) {
+	store := scela.NewInMemoryStore(0)
+	ctx := context.Background()
+	for i := 0; i < 3; i++ {
+		_ = store.Store(ctx, scela.NewMessage("orders", i))
+	}
+	pb := scela.NewPersistentBus(scela.New(), store)
+	defer pb.Close()
+
+	h := New(pb, WithReplayer(pb))
+
+	var progress scela.ReplayProgress
+	if code := do(t, h, "POST", "/replay", "", &progress); code != http.StatusOK {
+		t.Fatalf("POST /replay = %d", code)
+	}
+	if progress.Replayed != 3 {
+		t.Errorf("unexpected progress %+v", progress)
+	}
+
+	do(t, h, "POST", "/replay", `{"Start": 2, "All": true}`, &progress)
+	if progress.Replayed != 1 || progress.Position != 3 {
+		t.Errorf("unexpected progress resuming at 2 %+v", progress)
+	}
+}
+
+func TestHandler_NotConfigured(t *testing.T) {
+	bus := scela.New()
+	defer bus.Close()
+	h := New(bus)
+
+	for _, path := range []string{"/deadletters", "/deadletters/x/requeue", "/replay"} {
+		method := "POST"
+		if path == "/deadletters" {
+			method = "GET"
+		}
+		if code := do(t, h, method, path, "", nil); code != http.StatusNotImplemented {
+			t.Errorf("%s %s = %d, want %d", method, path, code, http.StatusNotImplemented)
+		}
+	}
+	if code := do(t, h, "DELETE", "/stats", "", nil); code != http.StatusMethodNotAllowed {
+		t.Errorf("DELETE /stats = %d", code)
+	}
+}
+
+func TestHandler_Auth(t *testing.T) {
+	bus := scela.New()
+	defer bus.Close()
+	h := New(bus, WithAuth(func(r *http.Request) error {
+		switch r.Header.Get("Authorization") {
+		case "Bearer admin":
+			return nil
+		case "Bearer viewer":
+			return fmt.Errorf("viewers cannot operate the bus: %w", ErrForbidden)
+		default:
+			return errors.New("missing token")
+		}
+	}))
+
+	for token, want := range map[string]int{
+		"":        http.StatusUnauthorized,
+		"viewer":  http.StatusForbidden,
+		"admin":   http.StatusOK,
+		"unknown": http.StatusUnauthorized,
+	} {
+		req := httptest.NewRequest("GET", "/stats", nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != want {
+			t.Errorf("token %q: status %d, want %d", token, rec.Code, want)
+		}
+	}
+}
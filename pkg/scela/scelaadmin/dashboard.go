@@ -0,0 +1,72 @@
+package scelaadmin
+
+import (
+	"embed"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"time"
+)
+
+// dashboardRefresh is how often the dashboard polls the API.
+const dashboardRefresh = 2 * time.Second
+
+//go:embed dashboard
+var dashboardFiles embed.FS
+
+var dashboardTemplate = template.Must(template.ParseFS(dashboardFiles, "dashboard/index.html"))
+
+// dashboardPage is the data of the dashboard template.
+type dashboardPage struct {
+	Title string
+	// Refresh is the polling interval in milliseconds.
+	Refresh int64
+	// Activity, DeadLetters and Replay tell which optional sections the
+	// handler can serve.
+	Activity    bool
+	DeadLetters bool
+	Replay      bool
+}
+
+// WithDashboard serves a single-page dashboard at the root of the handler,
+// titled title ("scela" if empty). It polls the API for throughput, queue
+// depth, topics, subscriptions, recent failures and dead letters; the
+// throughput and failures need WithMonitor. The dashboard is subject to
+// WithAuth like the API, and its requests carry cookies and HTTP basic
+// credentials but no other header, so authorize it by those.
+//
+// Mount the handler with a trailing slash, e.g. on "/admin/", as the page
+// requests the API relative to its own URL.
+func WithDashboard(title string) Option {
+	return func(h *Handler) {
+		if title == "" {
+			title = "scela"
+		}
+		h.dashboard = title
+	}
+}
+
+// routeDashboard registers the page and its assets.
+func (h *Handler) routeDashboard() {
+	assets, err := fs.Sub(dashboardFiles, "dashboard")
+	if err != nil {
+		panic(fmt.Sprintf("scelaadmin: embedded dashboard: %v", err))
+	}
+	h.mux.Handle("GET /assets/", http.FileServerFS(assets))
+	h.mux.HandleFunc("GET /{$}", h.dashboardPage)
+}
+
+func (h *Handler) dashboardPage(w http.ResponseWriter, r *http.Request) {
+	page := dashboardPage{
+		Title:       h.dashboard,
+		Refresh:     dashboardRefresh.Milliseconds(),
+		Activity:    h.monitor != nil,
+		DeadLetters: h.dlq != nil,
+		Replay:      h.replayer != nil,
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := dashboardTemplate.Execute(w, page); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+	}
+}
@@ -0,0 +1,205 @@
+// Dashboard of the scela admin API. Every endpoint is requested relative
+// to the page, so the handler can be mounted under any prefix.
+(function () {
+  "use strict";
+
+  var refresh = Number(document.body.dataset.refresh) || 2000;
+  var previous = null;
+
+  function $(id) {
+    return document.getElementById(id);
+  }
+
+  function request(method, path, body) {
+    var init = { method: method, credentials: "same-origin" };
+    if (body !== undefined) {
+      init.headers = { "Content-Type": "application/json" };
+      init.body = JSON.stringify(body);
+    }
+    return fetch(path, init).then(function (resp) {
+      if (resp.status === 204) {
+        return null;
+      }
+      return resp.json().then(function (data) {
+        if (!resp.ok) {
+          throw new Error(data.Error || resp.statusText);
+        }
+        return data;
+      });
+    });
+  }
+
+  function setText(id, text) {
+    var el = $(id);
+    if (el) {
+      el.textContent = text;
+    }
+  }
+
+  function duration(ns) {
+    if (!ns) {
+      return "–";
+    }
+    if (ns < 1e6) {
+      return (ns / 1e3).toFixed(0) + "µs";
+    }
+    if (ns < 1e9) {
+      return (ns / 1e6).toFixed(1) + "ms";
+    }
+    return (ns / 1e9).toFixed(2) + "s";
+  }
+
+  function time(value) {
+    if (!value || value.indexOf("0001-") === 0) {
+      return "never";
+    }
+    return new Date(value).toLocaleTimeString();
+  }
+
+  // fill replaces the rows of a table body, built by row from each item.
+  function fill(id, items, columns, row) {
+    var body = $(id);
+    if (!body) {
+      return;
+    }
+    body.textContent = "";
+    if (!items || items.length === 0) {
+      var tr = body.insertRow();
+      var td = tr.insertCell();
+      td.colSpan = columns;
+      td.className = "empty";
+      td.textContent = "none";
+      return;
+    }
+    items.forEach(function (item) {
+      row(body.insertRow(), item);
+    });
+  }
+
+  function cell(tr, text, className) {
+    var td = tr.insertCell();
+    td.textContent = text;
+    if (className) {
+      td.className = className;
+    }
+    return td;
+  }
+
+  function button(td, label, action) {
+    var b = document.createElement("button");
+    b.textContent = label;
+    b.addEventListener("click", function () {
+      b.disabled = true;
+      action().then(update, showError);
+    });
+    td.appendChild(b);
+  }
+
+  function showError(err) {
+    var status = $("status");
+    status.textContent = err.message;
+    status.className = "error";
+  }
+
+  function renderStats(stats) {
+    setText("queue-depth", stats.QueueDepth);
+    setText("queue-capacity", "of " + stats.QueueCapacity + ", " + stats.PartitionDepth + " partitioned");
+    setText("scheduled", stats.Scheduled);
+    setText("held", stats.Held + " held by paused topics");
+    setText("subscription-count", stats.Subscriptions);
+    if (stats.Latency.Count) {
+      setText("latency", "p99 latency " + duration(stats.Latency.P99));
+    }
+  }
+
+  function renderActivity(activity) {
+    var now = Date.now();
+    if (previous) {
+      var rate = (activity.Published - previous.published) / ((now - previous.at) / 1000);
+      setText("throughput", rate.toFixed(1));
+    }
+    previous = { published: activity.Published, at: now };
+
+    setText("failed", activity.Failed);
+    setText("dead-lettered", activity.DeadLettered + " dead-lettered");
+    fill("failures", activity.Failures, 4, function (tr, f) {
+      cell(tr, time(f.Time));
+      cell(tr, f.Topic);
+      cell(tr, f.MessageID);
+      cell(tr, (f.DeadLettered ? "dead-lettered: " : "") + f.Error, "error");
+    });
+  }
+
+  function renderTopics(topics) {
+    fill("topics", topics, 4, function (tr, t) {
+      if (t.Paused) {
+        tr.className = "paused";
+      }
+      cell(tr, t.Name);
+      cell(tr, t.Subscriptions);
+      cell(tr, t.Latency ? duration(t.Latency.P99) : "–");
+      button(tr.insertCell(), t.Paused ? "Resume" : "Pause", function () {
+        return request("POST", t.Paused ? "topics/resume" : "topics/pause", { Pattern: t.Name });
+      });
+    });
+  }
+
+  function renderSubscriptions(infos) {
+    fill("subscriptions", infos, 6, function (tr, s) {
+      cell(tr, s.ID);
+      cell(tr, s.Pattern);
+      cell(tr, s.Owner || "–");
+      cell(tr, s.QueueGroup || "–");
+      cell(tr, s.Deliveries);
+      cell(tr, time(s.LastDelivery));
+    });
+  }
+
+  function renderDeadLetters(letters) {
+    fill("deadletters", letters, 5, function (tr, d) {
+      cell(tr, time(d.Timestamp));
+      cell(tr, d.Topic);
+      cell(tr, d.ID);
+      var payload = document.createElement("code");
+      payload.textContent = JSON.stringify(d.Payload);
+      tr.insertCell().appendChild(payload);
+      button(tr.insertCell(), "Requeue", function () {
+        return request("POST", "deadletters/" + encodeURIComponent(d.ID) + "/requeue");
+      });
+    });
+  }
+
+  function update() {
+    var loads = [
+      request("GET", "stats").then(renderStats),
+      request("GET", "topics").then(renderTopics),
+      request("GET", "subscriptions").then(renderSubscriptions),
+    ];
+    if ($("failures")) {
+      loads.push(request("GET", "activity").then(renderActivity));
+    }
+    if ($("deadletters")) {
+      loads.push(request("GET", "deadletters").then(renderDeadLetters));
+    }
+    return Promise.all(loads).then(function () {
+      var status = $("status");
+      status.textContent = "updated " + new Date().toLocaleTimeString();
+      status.className = "";
+    }, showError);
+  }
+
+  var replay = $("replay");
+  if (replay) {
+    replay.addEventListener("click", function () {
+      replay.disabled = true;
+      request("POST", "replay").then(function (progress) {
+        setText("replay-result", progress.Replayed + " messages replayed");
+      }, showError).then(function () {
+        replay.disabled = false;
+      });
+    });
+  }
+
+  update();
+  setInterval(update, refresh);
+})();
@@ -0,0 +1,66 @@
+package scelaadmin
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/toutaio/toutago-scela-bus/pkg/scela"
+)
+
+// get serves a GET request and returns the recorded response.
+func get(h http.Handler, path string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+	return rec
+}
+
+func TestDashboard_ServesPage(t *testing.T) {
+	bus := scela.New()
+	defer bus.Close()
+	dlq := scela.NewDeadLetterQueue(scela.NewInMemoryStore(0), bus)
+
+	h := New(bus, WithDashboard("<orders>"), WithDeadLetterQueue(dlq))
+
+	rec := get(h, "/")
+	if rec.Code != http.StatusOK || !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/html") {
+		t.Fatalf("GET / = %d %s", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	page := rec.Body.String()
+	if !strings.Contains(page, "<title>&lt;orders&gt;</title>") {
+		t.Error("expected the escaped title in the page")
+	}
+	if !strings.Contains(page, `id="deadletters"`) {
+		t.Error("expected the dead letter browser with a dead letter queue")
+	}
+	for _, section := range []string{`id="failures"`, `id="replay"`} {
+		if strings.Contains(page, section) {
+			t.Errorf("expected no %s section without its option", section)
+		}
+	}
+
+	for _, asset := range []string{"/assets/app.js", "/assets/style.css"} {
+		if rec := get(h, asset); rec.Code != http.StatusOK || rec.Body.Len() == 0 {
+			t.Errorf("GET %s = %d", asset, rec.Code)
+		}
+	}
+	if rec := get(h, "/index.html"); rec.Code != http.StatusNotFound {
+		t.Errorf("expected the template not to be served, got %d", rec.Code)
+	}
+}
+
+func TestDashboard_Disabled(t *testing.T) {
+	bus := scela.New()
+	defer bus.Close()
+	h := New(bus)
+
+	for _, path := range []string{"/", "/assets/app.js"} {
+		if rec := get(h, path); rec.Code != http.StatusNotFound {
+			t.Errorf("GET %s = %d, want %d", path, rec.Code, http.StatusNotFound)
+		}
+	}
+	if rec := get(h, "/activity"); rec.Code != http.StatusNotImplemented {
+		t.Errorf("GET /activity = %d, want %d", rec.Code, http.StatusNotImplemented)
+	}
+}
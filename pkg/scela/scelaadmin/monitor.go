@@ -0,0 +1,135 @@
+package scelaadmin
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/toutaio/toutago-scela-bus/pkg/scela"
+)
+
+// defaultMaxFailures is the number of failures a Monitor remembers when no
+// limit is given.
+const defaultMaxFailures = 50
+
+// Monitor is a scela.Observer counting the messages flowing through a bus
+// and remembering its most recent failures, for the activity endpoint and
+// the dashboard. Register it with scela.WithObserver.
+type Monitor struct {
+	maxFailures int
+
+	mu       sync.Mutex
+	activity Activity
+	// next is the position of the next failure in the ring.
+	next int
+}
+
+// Activity is a snapshot of the messages seen by a Monitor.
+type Activity struct {
+	// Published is the number of messages published.
+	Published int64
+	// Processed is the number of messages processed, successfully or not.
+	Processed int64
+	// Failed is the number of failed processing attempts.
+	Failed int64
+	// DeadLettered is the number of messages that exhausted their retries.
+	DeadLettered int64
+	// Failures lists the most recent failures, newest first.
+	Failures []Failure
+}
+
+// Failure describes a failed processing attempt.
+type Failure struct {
+	Time      time.Time
+	Topic     string
+	MessageID string
+	Error     string
+	// DeadLettered reports whether the message exhausted its retries.
+	DeadLettered bool
+}
+
+// NewMonitor creates a monitor remembering the last maxFailures failures
+// (50 if zero or less).
+func NewMonitor(maxFailures int) *Monitor {
+	if maxFailures <= 0 {
+		maxFailures = defaultMaxFailures
+	}
+	return &Monitor{maxFailures: maxFailures}
+}
+
+// OnPublish implements scela.Observer.
+func (m *Monitor) OnPublish(ctx context.Context, topic string, msg scela.Message) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.activity.Published++
+}
+
+// OnPublishBatch implements scela.BatchObserver.
+func (m *Monitor) OnPublishBatch(ctx context.Context, msgs []scela.Message) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.activity.Published += int64(len(msgs))
+}
+
+// OnSubscribe implements scela.Observer.
+func (m *Monitor) OnSubscribe(pattern string) {}
+
+// OnUnsubscribe implements scela.Observer.
+func (m *Monitor) OnUnsubscribe(pattern string) {}
+
+// OnMessageProcessed implements scela.Observer.
+func (m *Monitor) OnMessageProcessed(ctx context.Context, msg scela.Message, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.activity.Processed++
+	if err != nil {
+		m.activity.Failed++
+		m.record(msg, err, false)
+	}
+}
+
+// OnRetry implements scela.RetryObserver.
+func (m *Monitor) OnRetry(ctx context.Context, msg scela.Message, attempt int, err error) {}
+
+// OnDeadLetter implements scela.RetryObserver.
+func (m *Monitor) OnDeadLetter(ctx context.Context, msg scela.Message, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.activity.DeadLettered++
+	m.record(msg, err, true)
+}
+
+// OnClose implements scela.Observer.
+func (m *Monitor) OnClose() {}
+
+// record remembers a failure, replacing the oldest once full. Must be
+// called with the lock held.
+func (m *Monitor) record(msg scela.Message, err error, deadLettered bool) {
+	f := Failure{
+		Time:         time.Now(),
+		Topic:        msg.Topic(),
+		MessageID:    msg.ID(),
+		Error:        err.Error(),
+		DeadLettered: deadLettered,
+	}
+	if len(m.activity.Failures) < m.maxFailures {
+		m.activity.Failures = append(m.activity.Failures, f)
+	} else {
+		m.activity.Failures[m.next] = f
+	}
+	m.next = (m.next + 1) % m.maxFailures
+}
+
+// Activity returns the messages seen so far.
+func (m *Monitor) Activity() Activity {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	activity := m.activity
+	n := len(m.activity.Failures)
+	activity.Failures = make([]Failure, n)
+	for i := range activity.Failures {
+		activity.Failures[i] = m.activity.Failures[(m.next-1-i+n)%n]
+	}
+	return activity
+}
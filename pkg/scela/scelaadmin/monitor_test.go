@@ -0,0 +1,68 @@
+package scelaadmin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/toutaio/toutago-scela-bus/pkg/scela"
+)
+
+func TestMonitor_CountsActivity(t *testing.T) {
+	monitor := NewMonitor(0)
+	bus := scela.New(scela.WithObserver(monitor))
+	defer bus.Close()
+
+	_, _ = bus.Subscribe("orders", scela.HandlerFunc(noop))
+	_, _ = bus.Subscribe("poison", scela.HandlerFunc(func(ctx context.Context, msg scela.Message) error {
+		return errors.New("cannot process")
+	}))
+
+	ctx := context.Background()
+	_ = bus.PublishSync(ctx, "orders", "o-1")
+	_ = bus.PublishSync(ctx, "poison", "p-1")
+	_ = bus.PublishBatch(ctx, []scela.TopicPayload{{Topic: "orders", Payload: "o-2"}, {Topic: "orders", Payload: "o-3"}})
+
+	deadline := time.Now().Add(time.Second)
+	for monitor.Activity().Processed < 4 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	activity := monitor.Activity()
+	if activity.Published != 4 || activity.Processed != 4 || activity.Failed != 1 {
+		t.Errorf("unexpected activity %+v", activity)
+	}
+	if len(activity.Failures) != 1 || activity.Failures[0].Topic != "poison" || activity.Failures[0].Error != "cannot process" {
+		t.Errorf("unexpected failures %+v", activity.Failures)
+	}
+
+	h := New(bus, WithMonitor(monitor))
+	var served Activity
+	if code := do(t, h, "GET", "/activity", "", &served); code != http.StatusOK || served.Published != 4 {
+		t.Errorf("GET /activity = %d %+v", code, served)
+	}
+}
+
+func TestMonitor_KeepsRecentFailures(t *testing.T) {
+	monitor := NewMonitor(2)
+	ctx := context.Background()
+	for i := 1; i <= 3; i++ {
+		monitor.OnMessageProcessed(ctx, scela.NewMessage("orders", i), fmt.Errorf("failure %d", i))
+	}
+	monitor.OnDeadLetter(ctx, scela.NewMessage("orders", 4), errors.New("failure 4"))
+
+	activity := monitor.Activity()
+	var got []string
+	for _, f := range activity.Failures {
+		got = append(got, fmt.Sprintf("%s:%v", f.Error, f.DeadLettered))
+	}
+	if fmt.Sprint(got) != "[failure 4:true failure 3:false]" {
+		t.Errorf("failures = %v, want the two most recent, newest first", got)
+	}
+	if activity.Failed != 3 || activity.DeadLettered != 1 {
+		t.Errorf("unexpected activity %+v", activity)
+	}
+}
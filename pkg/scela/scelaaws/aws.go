@@ -0,0 +1,536 @@
+// Package scelaaws connects scela buses to AWS messaging, so that Lambda and
+// ECS services can exchange messages with scela-based applications.
+//
+// A Sink publishes bus messages to an SNS topic and a Source drains
+// an SQS queue into bus topics, to be wired with scela.ForwardTo and
+// scela.ConsumeFrom, or together with scela.NewBridge when the queue is
+// subscribed to the topic:
+//
+//	cfg, err := config.LoadDefaultConfig(ctx)
+//	scela.ForwardTo(bus, "orders.#", scelaaws.NewSink(sns.NewFromConfig(cfg), topicARN))
+//
+//	source := scelaaws.NewSource(sqs.NewFromConfig(cfg), queueURL)
+//	go scela.ConsumeFrom(ctx, source, bus)
+//
+// The payload is the body of the SNS and SQS messages, encoded as JSON.
+// String metadata travel as message attributes of the same name, so that
+// consumers outside scela can read them; the message ID, topic, timestamp
+// and priority travel in the scela-* attributes. AWS allows ten attributes
+// per message: metadata beyond them, of other types or with names AWS
+// refuses, travel as a JSON object in the scela-metadata attribute.
+//
+// Sources read SNS notifications delivered to SQS with or without raw
+// message delivery, taking the attributes of the notification.
+package scelaaws
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	snstypes "github.com/aws/aws-sdk-go-v2/service/sns/types"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	sqstypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/toutaio/toutago-scela-bus/pkg/scela"
+)
+
+// Message attributes carrying the parts of a scela message that have no
+// SNS or SQS equivalent.
+const (
+	// AttributeID holds the message ID.
+	AttributeID = "scela-id"
+	// AttributeTopic holds the bus topic.
+	AttributeTopic = "scela-topic"
+	// AttributeTimestamp holds the message timestamp, in RFC 3339 format.
+	AttributeTimestamp = "scela-timestamp"
+	// AttributePriority holds the message priority.
+	AttributePriority = "scela-priority"
+	// AttributeMetadata holds the metadata that do not travel as attributes
+	// of their own, as a JSON object.
+	AttributeMetadata = "scela-metadata"
+)
+
+// maxAttributes is the number of message attributes SNS and SQS accept.
+const maxAttributes = 10
+
+// Defaults of the Source options.
+const (
+	defaultMaxMessages = 10
+	defaultWaitTime    = 20 * time.Second
+)
+
+// attributeName matches the message attribute names AWS accepts.
+var attributeName = regexp.MustCompile(`^[A-Za-z0-9_\-]([A-Za-z0-9_\-]|\.[A-Za-z0-9_\-]){0,255}$`)
+
+// SNSClient is the part of an *sns.Client used by a Sink.
+type SNSClient interface {
+	Publish(ctx context.Context, params *sns.PublishInput, optFns ...func(*sns.Options)) (*sns.PublishOutput, error)
+}
+
+// SQSClient is the part of an *sqs.Client used by a Source.
+type SQSClient interface {
+	ReceiveMessage(ctx context.Context, params *sqs.ReceiveMessageInput, optFns ...func(*sqs.Options)) (*sqs.ReceiveMessageOutput, error)
+	DeleteMessage(ctx context.Context, params *sqs.DeleteMessageInput, optFns ...func(*sqs.Options)) (*sqs.DeleteMessageOutput, error)
+	ChangeMessageVisibility(ctx context.Context, params *sqs.ChangeMessageVisibilityInput, optFns ...func(*sqs.Options)) (*sqs.ChangeMessageVisibilityOutput, error)
+}
+
+// SinkOption configures a Sink.
+type SinkOption func(*Sink)
+
+// WithTopicARN sets the SNS topic of each bus topic. By default every
+// message goes to the topic of NewSink. Returning "" also selects it.
+func WithTopicARN(fn func(topic string) string) SinkOption {
+	return func(s *Sink) {
+		if fn != nil {
+			s.topicARN = fn
+		}
+	}
+}
+
+// Sink is a scela.Sink publishing messages to SNS. On FIFO topics, whose
+// ARN ends in ".fifo", messages published with a partition key (see
+// scela.PublishWithKey) use it as their message group, and others their bus
+// topic, and the message ID deduplicates them.
+type Sink struct {
+	client   SNSClient
+	arn      string
+	topicARN func(topic string) string
+
+	mu     sync.Mutex
+	closed bool
+}
+
+// NewSink creates a sink publishing through client to the SNS topic with
+// ARN topicARN.
+func NewSink(client SNSClient, topicARN string, opts ...SinkOption) *Sink {
+	s := &Sink{
+		client:   client,
+		arn:      topicARN,
+		topicARN: func(string) string { return "" },
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Send implements scela.Sink.
+func (s *Sink) Send(ctx context.Context, msg scela.Message) error {
+	s.mu.Lock()
+	closed := s.closed
+	s.mu.Unlock()
+	if closed {
+		return scela.ErrBridgeClosed
+	}
+
+	arn := s.topicARN(msg.Topic())
+	if arn == "" {
+		arn = s.arn
+	}
+	if arn == "" {
+		return fmt.Errorf("no SNS topic for message %s on %s", msg.ID(), msg.Topic())
+	}
+	input, err := encode(msg)
+	if err != nil {
+		return err
+	}
+	input.TopicArn = aws.String(arn)
+	if strings.HasSuffix(arn, ".fifo") {
+		group := msg.Topic()
+		if key, ok := msg.Metadata()[scela.MetadataPartitionKey].(string); ok && key != "" {
+			group = key
+		}
+		input.MessageGroupId = aws.String(group)
+		input.MessageDeduplicationId = aws.String(msg.ID())
+	}
+
+	if _, err := s.client.Publish(ctx, input); err != nil {
+		return fmt.Errorf("failed to send message %s: %w", msg.ID(), err)
+	}
+	return nil
+}
+
+// Close implements scela.Sink. The client is owned by the caller.
+func (s *Sink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	return nil
+}
+
+// SourceOption configures a Source.
+type SourceOption func(*Source)
+
+// WithTopic sets the bus topic of the messages sent to the queue outside
+// scela, which have no scela-topic attribute. By default it is the name of
+// the queue.
+func WithTopic(topic string) SourceOption {
+	return func(s *Source) {
+		if topic != "" {
+			s.topic = topic
+		}
+	}
+}
+
+// WithMaxMessages sets how many messages, from 1 to 10, a Source receives
+// at once, 10 by default. The messages wait in the source until they are
+// delivered, while their visibility timeout runs: slow consumers should
+// receive fewer at once.
+func WithMaxMessages(n int) SourceOption {
+	return func(s *Source) {
+		if n >= 1 && n <= 10 {
+			s.maxMessages = int32(n)
+		}
+	}
+}
+
+// WithWaitTime sets how long, up to 20s, a receive request waits for
+// messages, 20s by default.
+func WithWaitTime(d time.Duration) SourceOption {
+	return func(s *Source) {
+		if d >= 0 && d <= defaultWaitTime {
+			s.waitTime = d
+		}
+	}
+}
+
+// WithVisibilityTimeout sets the visibility timeout of the received
+// messages, overriding the one of the queue: a message that is not acked
+// within it is received again.
+func WithVisibilityTimeout(d time.Duration) SourceOption {
+	return func(s *Source) {
+		if d > 0 {
+			s.visibilityTimeout = d
+		}
+	}
+}
+
+// Source is a scela.Source receiving messages from an SQS queue. An acked
+// message is deleted from the queue. A nacked message is made visible again
+// at once, and a message that is neither is received again after its
+// visibility timeout; once the queue has received a message more times than
+// its redrive policy allows, SQS moves it to the dead-letter queue.
+//
+// Standard queues do not keep messages in order, and may deliver them more
+// than once; FIFO queues keep the messages of a message group in order.
+type Source struct {
+	client            SQSClient
+	queueURL          string
+	topic             string
+	maxMessages       int32
+	waitTime          time.Duration
+	visibilityTimeout time.Duration
+
+	mu       sync.Mutex
+	received []sqstypes.Message
+
+	ctx       context.Context
+	cancel    context.CancelFunc
+	closeOnce sync.Once
+}
+
+// NewSource creates a source receiving through client from the queue at
+// queueURL.
+func NewSource(client SQSClient, queueURL string, opts ...SourceOption) *Source {
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &Source{
+		client:      client,
+		queueURL:    queueURL,
+		topic:       queueName(queueURL),
+		maxMessages: defaultMaxMessages,
+		waitTime:    defaultWaitTime,
+		ctx:         ctx,
+		cancel:      cancel,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Receive implements scela.Source. A message that cannot be decoded fails
+// Receive and stays in the queue, for its redrive policy to move it to the
+// dead-letter queue.
+func (s *Source) Receive(ctx context.Context) (scela.Delivery, error) {
+	for {
+		if s.ctx.Err() != nil {
+			return nil, scela.ErrBridgeClosed
+		}
+
+		if m, ok := s.next(); ok {
+			msg, err := decode(m, s.topic)
+			if err != nil {
+				return nil, err
+			}
+			return &delivery{source: s, m: m, msg: msg}, nil
+		}
+
+		if err := s.fill(ctx); err != nil {
+			if s.ctx.Err() != nil {
+				return nil, scela.ErrBridgeClosed
+			}
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			return nil, err
+		}
+	}
+}
+
+// next pops the oldest received message.
+func (s *Source) next() (sqstypes.Message, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.received) == 0 {
+		return sqstypes.Message{}, false
+	}
+	m := s.received[0]
+	s.received = s.received[1:]
+	return m, true
+}
+
+// fill receives the next messages of the queue, waiting for them until the
+// wait time passes, ctx is done or the source is closed.
+func (s *Source) fill(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stop := context.AfterFunc(s.ctx, cancel)
+	defer stop()
+
+	input := &sqs.ReceiveMessageInput{
+		QueueUrl:                    aws.String(s.queueURL),
+		MaxNumberOfMessages:         s.maxMessages,
+		WaitTimeSeconds:             int32(s.waitTime / time.Second),
+		MessageAttributeNames:       []string{"All"},
+		MessageSystemAttributeNames: []sqstypes.MessageSystemAttributeName{sqstypes.MessageSystemAttributeNameSentTimestamp},
+	}
+	if s.visibilityTimeout > 0 {
+		input.VisibilityTimeout = int32(s.visibilityTimeout / time.Second)
+	}
+	out, err := s.client.ReceiveMessage(ctx, input)
+	if err != nil {
+		return fmt.Errorf("failed to receive messages from %s: %w", s.queueURL, err)
+	}
+
+	s.mu.Lock()
+	s.received = append(s.received, out.Messages...)
+	s.mu.Unlock()
+	return nil
+}
+
+// Close implements scela.Source. The client is owned by the caller. The
+// messages received but not delivered yet are received again after their
+// visibility timeout.
+func (s *Source) Close() error {
+	s.closeOnce.Do(s.cancel)
+	return nil
+}
+
+// delivery is a message received by a Source.
+type delivery struct {
+	source *Source
+	m      sqstypes.Message
+	msg    scela.Message
+}
+
+// Message implements scela.Delivery.
+func (d *delivery) Message() scela.Message {
+	return d.msg
+}
+
+// Ack implements scela.Delivery. It deletes the message from the queue.
+func (d *delivery) Ack() error {
+	_, err := d.source.client.DeleteMessage(context.Background(), &sqs.DeleteMessageInput{
+		QueueUrl:      aws.String(d.source.queueURL),
+		ReceiptHandle: d.m.ReceiptHandle,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete message %s: %w", d.msg.ID(), err)
+	}
+	return nil
+}
+
+// Nack implements scela.Delivery. It makes the message visible again at
+// once.
+func (d *delivery) Nack() error {
+	_, err := d.source.client.ChangeMessageVisibility(context.Background(), &sqs.ChangeMessageVisibilityInput{
+		QueueUrl:          aws.String(d.source.queueURL),
+		ReceiptHandle:     d.m.ReceiptHandle,
+		VisibilityTimeout: 0,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to release message %s: %w", d.msg.ID(), err)
+	}
+	return nil
+}
+
+// queueName returns the name of the queue at queueURL.
+func queueName(queueURL string) string {
+	if u, err := url.Parse(queueURL); err == nil && u.Path != "" {
+		return path.Base(u.Path)
+	}
+	return queueURL
+}
+
+// encode returns the SNS publication of msg, without a topic.
+func encode(msg scela.Message) (*sns.PublishInput, error) {
+	body, err := json.Marshal(msg.Payload())
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode payload of message %s: %w", msg.ID(), err)
+	}
+
+	attrs := map[string]snstypes.MessageAttributeValue{
+		AttributeID:        stringAttribute(msg.ID()),
+		AttributeTopic:     stringAttribute(msg.Topic()),
+		AttributeTimestamp: stringAttribute(msg.Timestamp().Format(time.RFC3339Nano)),
+		AttributePriority: {
+			DataType:    aws.String("Number"),
+			StringValue: aws.String(strconv.Itoa(int(scela.MessagePriority(msg)))),
+		},
+	}
+
+	// Keep a slot for scela-metadata, unless every entry fits
+	keys := make([]string, 0, len(msg.Metadata()))
+	for k := range msg.Metadata() {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	slots := maxAttributes - len(attrs)
+	if len(keys) > slots {
+		slots--
+	}
+
+	others := make(map[string]interface{})
+	for _, k := range keys {
+		v := msg.Metadata()[k]
+		if s, ok := v.(string); ok && slots > 0 && validAttribute(k) {
+			attrs[k] = stringAttribute(s)
+			slots--
+		} else {
+			others[k] = v
+		}
+	}
+	if len(others) > 0 {
+		data, err := json.Marshal(others)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode metadata of message %s: %w", msg.ID(), err)
+		}
+		attrs[AttributeMetadata] = stringAttribute(string(data))
+	}
+
+	return &sns.PublishInput{Message: aws.String(string(body)), MessageAttributes: attrs}, nil
+}
+
+// stringAttribute returns a String message attribute.
+func stringAttribute(v string) snstypes.MessageAttributeValue {
+	return snstypes.MessageAttributeValue{DataType: aws.String("String"), StringValue: aws.String(v)}
+}
+
+// validAttribute reports whether AWS accepts name as the name of a metadata
+// attribute.
+func validAttribute(name string) bool {
+	lower := strings.ToLower(name)
+	if strings.HasPrefix(lower, "aws.") || strings.HasPrefix(lower, "amazon.") || strings.HasPrefix(lower, "scela-") {
+		return false
+	}
+	return attributeName.MatchString(name)
+}
+
+// notification is an SNS notification delivered to SQS without raw message
+// delivery.
+type notification struct {
+	Type              string
+	MessageId         string
+	TopicArn          string
+	Message           string
+	MessageAttributes map[string]struct {
+		Type  string
+		Value string
+	}
+}
+
+// decode returns the scela message of m. Messages sent outside scela get
+// the SQS or SNS message ID and the time they were sent, and their body is
+// the payload if it is JSON, or a string otherwise.
+func decode(m sqstypes.Message, topic string) (scela.Message, error) {
+	body := aws.ToString(m.Body)
+	id := aws.ToString(m.MessageId)
+	attrs := make(map[string]string, len(m.MessageAttributes))
+	for k, v := range m.MessageAttributes {
+		if v.StringValue != nil {
+			attrs[k] = *v.StringValue
+		}
+	}
+
+	var n notification
+	if err := json.Unmarshal([]byte(body), &n); err == nil && n.Type == "Notification" && n.TopicArn != "" {
+		body, id = n.Message, n.MessageId
+		for k, v := range n.MessageAttributes {
+			if v.Type != "Binary" {
+				attrs[k] = v.Value
+			}
+		}
+	}
+
+	wm := struct {
+		ID        string                 `json:"id"`
+		Topic     string                 `json:"topic"`
+		Payload   json.RawMessage        `json:"payload,omitempty"`
+		Metadata  map[string]interface{} `json:"metadata"`
+		Timestamp time.Time              `json:"timestamp"`
+		Priority  int                    `json:"priority,omitempty"`
+	}{
+		ID:        id,
+		Topic:     topic,
+		Metadata:  make(map[string]interface{}),
+		Timestamp: time.Now(),
+	}
+	if ms, err := strconv.ParseInt(m.Attributes[string(sqstypes.MessageSystemAttributeNameSentTimestamp)], 10, 64); err == nil {
+		wm.Timestamp = time.UnixMilli(ms)
+	}
+
+	for k, v := range attrs {
+		switch k {
+		case AttributeID:
+			wm.ID = v
+		case AttributeTopic:
+			wm.Topic = v
+		case AttributeTimestamp:
+			if ts, err := time.Parse(time.RFC3339Nano, v); err == nil {
+				wm.Timestamp = ts
+			}
+		case AttributePriority:
+			wm.Priority, _ = strconv.Atoi(v)
+		case AttributeMetadata:
+		default:
+			wm.Metadata[k] = v
+		}
+	}
+	if v, ok := attrs[AttributeMetadata]; ok {
+		if err := json.Unmarshal([]byte(v), &wm.Metadata); err != nil {
+			return nil, fmt.Errorf("invalid %s attribute on message %s: %w", AttributeMetadata, wm.ID, err)
+		}
+	}
+
+	if json.Valid([]byte(body)) {
+		wm.Payload = json.RawMessage(body)
+	} else {
+		wm.Payload, _ = json.Marshal(body)
+	}
+	data, err := json.Marshal(wm)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode message %s: %w", wm.ID, err)
+	}
+	return scela.UnmarshalMessage(data)
+}
@@ -0,0 +1,322 @@
+package scelaaws
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	sqstypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/toutaio/toutago-scela-bus/pkg/scela"
+	"github.com/toutaio/toutago-scela-bus/pkg/scela/scelatest"
+)
+
+const queueURL = "https://sqs.eu-west-1.amazonaws.com/123456789012/orders"
+
+// fakeQueue is an in-memory stand-in for an SQS queue, delivering messages
+// in order.
+type fakeQueue struct {
+	mu       sync.Mutex
+	messages []*queued
+	next     int
+	ready    chan struct{}
+}
+
+// queued is a message of a fakeQueue.
+type queued struct {
+	m         sqstypes.Message
+	visibleAt time.Time
+	deleted   bool
+}
+
+func newFakeQueue() *fakeQueue {
+	return &fakeQueue{ready: make(chan struct{})}
+}
+
+// send adds a message to the queue.
+func (q *fakeQueue) send(body string, attrs map[string]sqstypes.MessageAttributeValue) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.next++
+	q.messages = append(q.messages, &queued{m: sqstypes.Message{
+		MessageId:         aws.String(fmt.Sprintf("sqs-%d", q.next)),
+		Body:              aws.String(body),
+		MessageAttributes: attrs,
+		Attributes: map[string]string{
+			string(sqstypes.MessageSystemAttributeNameSentTimestamp): strconv.FormatInt(time.Now().UnixMilli(), 10),
+		},
+	}})
+	close(q.ready)
+	q.ready = make(chan struct{})
+}
+
+func (q *fakeQueue) ReceiveMessage(ctx context.Context, params *sqs.ReceiveMessageInput, _ ...func(*sqs.Options)) (*sqs.ReceiveMessageOutput, error) {
+	for {
+		q.mu.Lock()
+		var out sqs.ReceiveMessageOutput
+		for _, qm := range q.messages {
+			if qm.deleted || time.Now().Before(qm.visibleAt) || len(out.Messages) == int(params.MaxNumberOfMessages) {
+				continue
+			}
+			q.next++
+			qm.m.ReceiptHandle = aws.String(fmt.Sprintf("receipt-%d", q.next))
+			qm.visibleAt = time.Now().Add(time.Minute)
+			out.Messages = append(out.Messages, qm.m)
+		}
+		ready := q.ready
+		q.mu.Unlock()
+		if len(out.Messages) > 0 {
+			return &out, nil
+		}
+
+		select {
+		case <-ready:
+		case <-time.After(10 * time.Millisecond):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// find returns the message with receipt.
+func (q *fakeQueue) find(receipt *string) (*queued, error) {
+	for _, qm := range q.messages {
+		if aws.ToString(qm.m.ReceiptHandle) == aws.ToString(receipt) {
+			return qm, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown receipt handle %s", aws.ToString(receipt))
+}
+
+func (q *fakeQueue) DeleteMessage(ctx context.Context, params *sqs.DeleteMessageInput, _ ...func(*sqs.Options)) (*sqs.DeleteMessageOutput, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	qm, err := q.find(params.ReceiptHandle)
+	if err != nil {
+		return nil, err
+	}
+	qm.deleted = true
+	return &sqs.DeleteMessageOutput{}, nil
+}
+
+func (q *fakeQueue) ChangeMessageVisibility(ctx context.Context, params *sqs.ChangeMessageVisibilityInput, _ ...func(*sqs.Options)) (*sqs.ChangeMessageVisibilityOutput, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	qm, err := q.find(params.ReceiptHandle)
+	if err != nil {
+		return nil, err
+	}
+	qm.visibleAt = time.Now().Add(time.Duration(params.VisibilityTimeout) * time.Second)
+	return &sqs.ChangeMessageVisibilityOutput{}, nil
+}
+
+// fakeTopic is an in-memory stand-in for an SNS topic with a queue
+// subscribed to it.
+type fakeTopic struct {
+	queue *fakeQueue
+	// raw enables raw message delivery.
+	raw bool
+
+	mu        sync.Mutex
+	published []*sns.PublishInput
+}
+
+func (t *fakeTopic) Publish(ctx context.Context, params *sns.PublishInput, _ ...func(*sns.Options)) (*sns.PublishOutput, error) {
+	if len(params.MessageAttributes) > maxAttributes {
+		return nil, fmt.Errorf("%d message attributes, at most %d allowed", len(params.MessageAttributes), maxAttributes)
+	}
+	t.mu.Lock()
+	t.published = append(t.published, params)
+	t.mu.Unlock()
+
+	if t.queue == nil {
+		return &sns.PublishOutput{}, nil
+	}
+	if t.raw {
+		attrs := make(map[string]sqstypes.MessageAttributeValue)
+		for k, v := range params.MessageAttributes {
+			attrs[k] = sqstypes.MessageAttributeValue{DataType: v.DataType, StringValue: v.StringValue}
+		}
+		t.queue.send(aws.ToString(params.Message), attrs)
+		return &sns.PublishOutput{}, nil
+	}
+
+	n := notification{
+		Type:      "Notification",
+		MessageId: "sns-id",
+		TopicArn:  aws.ToString(params.TopicArn),
+		Message:   aws.ToString(params.Message),
+		MessageAttributes: map[string]struct {
+			Type  string
+			Value string
+		}{},
+	}
+	for k, v := range params.MessageAttributes {
+		n.MessageAttributes[k] = struct {
+			Type  string
+			Value string
+		}{aws.ToString(v.DataType), aws.ToString(v.StringValue)}
+	}
+	body, _ := json.Marshal(n)
+	t.queue.send(string(body), nil)
+	return &sns.PublishOutput{}, nil
+}
+
+func TestBridge_Conformance(t *testing.T) {
+	for _, raw := range []bool{false, true} {
+		raw := raw
+		t.Run(fmt.Sprintf("raw=%v", raw), func(t *testing.T) {
+			scelatest.TestBridge(t, func(t *testing.T) scelatest.BridgeFixture {
+				q := newFakeQueue()
+				return scelatest.BridgeFixture{
+					Sink:   NewSink(&fakeTopic{queue: q, raw: raw}, "arn:aws:sns:eu-west-1:123456789012:orders"),
+					Source: NewSource(q, queueURL),
+				}
+			})
+		})
+	}
+}
+
+func TestEncode_AttributeLimit(t *testing.T) {
+	q := newFakeQueue()
+	topic := &fakeTopic{queue: q, raw: true}
+	sink := NewSink(topic, "arn:aws:sns:eu-west-1:123456789012:orders")
+
+	msg := scela.NewMessage("orders.created", map[string]interface{}{"id": "o-1"})
+	for i := 0; i < 8; i++ {
+		msg.Metadata()[fmt.Sprintf("key%d", i)] = fmt.Sprintf("value%d", i)
+	}
+	msg.Metadata()["aws.reserved"] = "refused by AWS"
+	msg.Metadata()["attempts"] = 3
+	if err := sink.Send(context.Background(), msg); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	attrs := topic.published[0].MessageAttributes
+	if _, ok := attrs["key0"]; !ok {
+		t.Errorf("expected string metadata as attributes, got %v", attrs)
+	}
+	if _, ok := attrs["aws.reserved"]; ok {
+		t.Error("expected a reserved name to travel in scela-metadata")
+	}
+
+	got, err := decode(q.messages[0].m, "orders")
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if got.ID() != msg.ID() || got.Topic() != "orders.created" {
+		t.Errorf("got message %s on %s, want %s on orders.created", got.ID(), got.Topic(), msg.ID())
+	}
+	for k, v := range msg.Metadata() {
+		if fmt.Sprint(got.Metadata()[k]) != fmt.Sprint(v) {
+			t.Errorf("metadata %s = %v, want %v", k, got.Metadata()[k], v)
+		}
+	}
+}
+
+func TestDecode_ForeignMessage(t *testing.T) {
+	sent := time.Now().Add(-time.Minute).Truncate(time.Millisecond)
+	m := sqstypes.Message{
+		MessageId: aws.String("sqs-1"),
+		Body:      aws.String("not json"),
+		MessageAttributes: map[string]sqstypes.MessageAttributeValue{
+			"tenant": {DataType: aws.String("String"), StringValue: aws.String("acme")},
+		},
+		Attributes: map[string]string{
+			string(sqstypes.MessageSystemAttributeNameSentTimestamp): strconv.FormatInt(sent.UnixMilli(), 10),
+		},
+	}
+
+	msg, err := decode(m, queueName(queueURL))
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if msg.ID() != "sqs-1" || msg.Topic() != "orders" || msg.Payload() != "not json" {
+		t.Errorf("unexpected message: ID %q topic %q payload %v", msg.ID(), msg.Topic(), msg.Payload())
+	}
+	if msg.Metadata()["tenant"] != "acme" {
+		t.Errorf("expected the attribute as metadata, got %v", msg.Metadata())
+	}
+	if !msg.Timestamp().Equal(sent) {
+		t.Errorf("timestamp = %v, want the sent time %v", msg.Timestamp(), sent)
+	}
+}
+
+func TestSink_FIFO(t *testing.T) {
+	topic := &fakeTopic{}
+	sink := NewSink(topic, "arn:aws:sns:eu-west-1:123456789012:events", WithTopicARN(func(topic string) string {
+		if topic == "orders.created" {
+			return "arn:aws:sns:eu-west-1:123456789012:orders.fifo"
+		}
+		return ""
+	}))
+
+	msg := scela.NewMessage("orders.created", "o-1")
+	msg.Metadata()[scela.MetadataPartitionKey] = "customer-7"
+	if err := sink.Send(context.Background(), msg); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if err := sink.Send(context.Background(), scela.NewMessage("users.created", "u-1")); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	fifo, standard := topic.published[0], topic.published[1]
+	if aws.ToString(fifo.TopicArn) != "arn:aws:sns:eu-west-1:123456789012:orders.fifo" {
+		t.Errorf("topic = %s, want the mapped FIFO topic", aws.ToString(fifo.TopicArn))
+	}
+	if aws.ToString(fifo.MessageGroupId) != "customer-7" || aws.ToString(fifo.MessageDeduplicationId) != msg.ID() {
+		t.Errorf("group %q dedup %q, want customer-7 and the message ID", aws.ToString(fifo.MessageGroupId), aws.ToString(fifo.MessageDeduplicationId))
+	}
+	if aws.ToString(standard.TopicArn) != "arn:aws:sns:eu-west-1:123456789012:events" || standard.MessageGroupId != nil {
+		t.Errorf("unexpected publication of an unmapped topic to %s", aws.ToString(standard.TopicArn))
+	}
+}
+
+func TestSource_DrainsIntoBus(t *testing.T) {
+	q := newFakeQueue()
+	q.send(`{"id":"o-1"}`, nil)
+
+	bus := scela.New()
+	defer bus.Close()
+	received := make(chan scela.Message, 1)
+	_, _ = bus.Subscribe("billing.#", scela.HandlerFunc(func(ctx context.Context, msg scela.Message) error {
+		received <- msg
+		return nil
+	}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() { _ = scela.ConsumeFrom(ctx, NewSource(q, queueURL, WithTopic("billing.orders")), bus) }()
+
+	select {
+	case msg := <-received:
+		if payload, _ := msg.Payload().(map[string]interface{}); payload["id"] != "o-1" {
+			t.Errorf("payload = %v, want id o-1", msg.Payload())
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("message not drained into the bus")
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		q.mu.Lock()
+		deleted := q.messages[0].deleted
+		q.mu.Unlock()
+		if deleted {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("message not deleted from the queue")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,18 @@
+module github.com/toutaio/toutago-scela-bus/pkg/scela/scelaaws
+
+go 1.22.9
+
+require (
+	github.com/aws/aws-sdk-go-v2 v1.32.4
+	github.com/aws/aws-sdk-go-v2/service/sns v1.33.0
+	github.com/aws/aws-sdk-go-v2/service/sqs v1.37.0
+	github.com/toutaio/toutago-scela-bus v0.0.0
+)
+
+require (
+	github.com/aws/aws-sdk-go-v2/internal/configsources v1.3.23 // indirect
+	github.com/aws/aws-sdk-go-v2/internal/endpoints/v2 v2.6.23 // indirect
+	github.com/aws/smithy-go v1.22.0 // indirect
+)
+
+replace github.com/toutaio/toutago-scela-bus => ../../..
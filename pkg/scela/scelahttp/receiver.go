@@ -0,0 +1,134 @@
+package scelahttp
+
+import (
+	"crypto/hmac"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/toutaio/toutago-scela-bus/pkg/scela"
+)
+
+// Receiver is an http.Handler publishing on a bus the messages posted to it
+// as JSON. The body is a message as encoded by scela.MarshalMessage; only
+// the topic is required, so other services can post
+//
+//	{"topic": "orders.created", "payload": {"id": "o-1"}}
+//
+// Messages with an ID keep it, with their metadata, timestamp and
+// priority, and record the scela.HopBridgeIn hop. Others get a new ID.
+//
+// A published message is answered with 202 Accepted and {"ID": "..."}.
+// Rejected requests get a 4xx status, and 503 Service Unavailable when the
+// bus failed to publish, so that the sender retries later. Errors are
+// returned as {"Error": "..."}.
+type Receiver struct {
+	bus  scela.Bus
+	opts options
+}
+
+// NewReceiver creates a receiver publishing on bus.
+func NewReceiver(bus scela.Bus, opts ...Option) *Receiver {
+	return &Receiver{
+		bus:  bus,
+		opts: newOptions(opts),
+	}
+}
+
+// ServeHTTP implements http.Handler.
+func (rc *Receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, rc.opts.maxBodySize))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeError(w, http.StatusRequestEntityTooLarge, err)
+		} else {
+			writeError(w, http.StatusBadRequest, err)
+		}
+		return
+	}
+
+	if rc.opts.secret != nil {
+		if err := rc.verify(r.Header, body); err != nil {
+			writeError(w, http.StatusUnauthorized, err)
+			return
+		}
+	}
+
+	msg, err := decode(body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if rc.opts.filter != nil && !rc.opts.filter(msg) {
+		writeError(w, http.StatusForbidden, errors.New("message not accepted"))
+		return
+	}
+
+	if err := scela.PublishMessage(r.Context(), rc.bus, scela.AddHop(msg, scela.HopBridgeIn)); err != nil {
+		writeError(w, http.StatusServiceUnavailable, err)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, struct{ ID string }{msg.ID()})
+}
+
+// verify checks the signature of a request.
+func (rc *Receiver) verify(header http.Header, body []byte) error {
+	timestamp := header.Get(HeaderTimestamp)
+	signature := header.Get(HeaderSignature)
+	if timestamp == "" || signature == "" {
+		return errors.New("missing signature")
+	}
+
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return errors.New("invalid signature timestamp")
+	}
+	if age := time.Since(time.Unix(unix, 0)); age > rc.opts.tolerance || age < -rc.opts.tolerance {
+		return errors.New("signature timestamp out of tolerance")
+	}
+
+	if !hmac.Equal([]byte(signature), []byte(sign(rc.opts.secret, timestamp, body))) {
+		return errors.New("invalid signature")
+	}
+	return nil
+}
+
+// decode returns the message of a request body, with a new ID if it has
+// none.
+func decode(body []byte) (scela.Message, error) {
+	msg, err := scela.UnmarshalMessage(body)
+	if err != nil {
+		return nil, err
+	}
+	if msg.ID() != "" {
+		return msg, nil
+	}
+
+	fresh := scela.NewMessageWithPriority(msg.Topic(), msg.Payload(), scela.MessagePriority(msg))
+	for k, v := range msg.Metadata() {
+		fresh.Metadata()[k] = v
+	}
+	return fresh, nil
+}
+
+// writeJSON writes v as the JSON response body.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// writeError writes err as a JSON error response.
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, struct{ Error string }{err.Error()})
+}
@@ -0,0 +1,108 @@
+package scelahttp
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/toutaio/toutago-scela-bus/pkg/scela"
+)
+
+// post serves a request with body and headers on h.
+func post(h http.Handler, body string, header http.Header) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/events", strings.NewReader(body))
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestReceiver_PublishesForeignMessages(t *testing.T) {
+	bus := scela.New()
+	defer bus.Close()
+	received := make(chan scela.Message, 1)
+	_, _ = bus.Subscribe("orders.created", scela.HandlerFunc(func(ctx context.Context, msg scela.Message) error {
+		received <- msg
+		return nil
+	}))
+
+	rec := post(NewReceiver(bus), `{"topic": "orders.created", "payload": {"id": "o-1"}}`, nil)
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("status = %d, want 202: %s", rec.Code, rec.Body)
+	}
+
+	select {
+	case msg := <-received:
+		if msg.ID() == "" || !strings.Contains(rec.Body.String(), msg.ID()) {
+			t.Errorf("expected a new ID in the response, got %q for %q", rec.Body, msg.ID())
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("message not published")
+	}
+}
+
+func TestReceiver_Rejections(t *testing.T) {
+	bus := scela.New()
+	defer bus.Close()
+	secret := []byte("s3cret")
+
+	body := `{"topic": "orders.created"}`
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	old := strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10)
+	signed := func(timestamp string) http.Header {
+		return http.Header{
+			HeaderTimestamp: {timestamp},
+			HeaderSignature: {sign(secret, timestamp, []byte(body))},
+		}
+	}
+	onlyPayments := WithFilter(func(msg scela.Message) bool {
+		return strings.HasPrefix(msg.Topic(), "payments.")
+	})
+
+	tests := []struct {
+		name   string
+		opts   []Option
+		body   string
+		header http.Header
+		want   int
+	}{
+		{"valid signature", []Option{WithSecret(secret)}, body, signed(now), http.StatusAccepted},
+		{"missing signature", []Option{WithSecret(secret)}, body, nil, http.StatusUnauthorized},
+		{"wrong secret", []Option{WithSecret([]byte("other"))}, body, signed(now), http.StatusUnauthorized},
+		{"replayed", []Option{WithSecret(secret)}, body, signed(old), http.StatusUnauthorized},
+		{"no topic", nil, `{"payload": 1}`, nil, http.StatusBadRequest},
+		{"malformed", nil, `{`, nil, http.StatusBadRequest},
+		{"too large", []Option{WithMaxBodySize(8)}, body, nil, http.StatusRequestEntityTooLarge},
+		{"filtered", []Option{onlyPayments}, body, nil, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := post(NewReceiver(bus, tt.opts...), tt.body, tt.header)
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d: %s", rec.Code, tt.want, rec.Body)
+			}
+		})
+	}
+
+	rec := httptest.NewRecorder()
+	NewReceiver(bus).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/events", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET status = %d, want 405", rec.Code)
+	}
+}
+
+func TestReceiver_ClosedBusIsUnavailable(t *testing.T) {
+	bus := scela.New()
+	_ = bus.Close()
+
+	rec := post(NewReceiver(bus), `{"topic": "orders.created"}`, nil)
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("status = %d, want 503", rec.Code)
+	}
+}
@@ -0,0 +1,258 @@
+// Package scelahttp connects scela buses to other services over HTTP
+// webhooks: a Webhook posts bus messages to webhook URLs, and a Receiver
+// publishes the messages posted to it on a bus.
+//
+//	webhook := scelahttp.NewWebhook([]string{"https://billing.example.com/events"},
+//		scelahttp.WithSecret(secret))
+//	bus.Subscribe("orders.#", webhook)
+//
+//	mux.Handle("/events", scelahttp.NewReceiver(bus, scelahttp.WithSecret(secret)))
+//
+// Messages travel as the JSON produced by scela.MarshalMessage, so a
+// Receiver publishes what a Webhook posted with its ID, metadata and
+// priority. With a secret, requests carry an HMAC-SHA256 signature of their
+// timestamp and body, which receivers check to reject forged and replayed
+// requests.
+package scelahttp
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/toutaio/toutago-scela-bus/pkg/scela"
+)
+
+// Headers set on the requests of a Webhook.
+const (
+	// HeaderMessageID holds the message ID, for receivers to discard the
+	// messages they already got.
+	HeaderMessageID = "X-Scela-Message-Id"
+	// HeaderTopic holds the message topic.
+	HeaderTopic = "X-Scela-Topic"
+	// HeaderTimestamp holds the time the request was signed, in Unix
+	// seconds.
+	HeaderTimestamp = "X-Scela-Timestamp"
+	// HeaderSignature holds "sha256=" followed by the hex encoded
+	// HMAC-SHA256 of the timestamp, a dot and the body.
+	HeaderSignature = "X-Scela-Signature"
+)
+
+// Defaults of the options.
+const (
+	defaultTimeout     = 10 * time.Second
+	defaultRetries     = 3
+	defaultMaxBodySize = 1 << 20
+	defaultTolerance   = 5 * time.Minute
+)
+
+// Option configures a Webhook or a Receiver.
+type Option func(*options)
+
+// options holds the settings of webhooks and receivers.
+type options struct {
+	secret      []byte
+	client      *http.Client
+	retries     int
+	backoff     scela.Backoff
+	maxBodySize int64
+	tolerance   time.Duration
+	filter      scela.Filter
+}
+
+// WithSecret makes webhooks sign their requests with secret, and receivers
+// reject the requests without a valid signature.
+func WithSecret(secret []byte) Option {
+	return func(o *options) {
+		o.secret = secret
+	}
+}
+
+// WithClient sets the client webhooks post with. It defaults to a client
+// with a 10s timeout.
+func WithClient(client *http.Client) Option {
+	return func(o *options) {
+		if client != nil {
+			o.client = client
+		}
+	}
+}
+
+// WithRetries sets how many times webhooks retry a request that failed to
+// reach the URL or was answered with 429 Too Many Requests or a 5xx status,
+// and the delay before each retry. It defaults to 3 retries with an
+// exponential backoff from 100ms.
+func WithRetries(n int, backoff scela.Backoff) Option {
+	return func(o *options) {
+		if n >= 0 {
+			o.retries = n
+		}
+		if backoff != nil {
+			o.backoff = backoff
+		}
+	}
+}
+
+// WithMaxBodySize limits the size of the requests receivers accept. It
+// defaults to 1MB.
+func WithMaxBodySize(n int64) Option {
+	return func(o *options) {
+		if n > 0 {
+			o.maxBodySize = n
+		}
+	}
+}
+
+// WithTolerance sets how far the signature timestamp of a request may be
+// from the time receivers get it. It defaults to five minutes.
+func WithTolerance(d time.Duration) Option {
+	return func(o *options) {
+		if d > 0 {
+			o.tolerance = d
+		}
+	}
+}
+
+// WithFilter makes receivers reject the messages filter does not match, such
+// as the topics a sender may not publish to.
+func WithFilter(filter scela.Filter) Option {
+	return func(o *options) {
+		o.filter = filter
+	}
+}
+
+// newOptions applies opts to the default options.
+func newOptions(opts []Option) options {
+	o := options{
+		client:      &http.Client{Timeout: defaultTimeout},
+		retries:     defaultRetries,
+		backoff:     scela.ExponentialBackoff(100*time.Millisecond, 5*time.Second),
+		maxBodySize: defaultMaxBodySize,
+		tolerance:   defaultTolerance,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// sign returns the signature of body at timestamp.
+func sign(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Webhook is a scela.Handler and a scela.Sink posting messages to webhook
+// URLs. A message is posted to every URL; if one of them still fails after
+// the retries, the error is returned to the bus, which may deliver the
+// message again to all of them.
+type Webhook struct {
+	urls []string
+	opts options
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewWebhook creates a webhook posting to urls.
+func NewWebhook(urls []string, opts ...Option) *Webhook {
+	return &Webhook{
+		urls: urls,
+		opts: newOptions(opts),
+		done: make(chan struct{}),
+	}
+}
+
+// Handle implements scela.Handler.
+func (w *Webhook) Handle(ctx context.Context, msg scela.Message) error {
+	return w.Send(ctx, msg)
+}
+
+// Send implements scela.Sink.
+func (w *Webhook) Send(ctx context.Context, msg scela.Message) error {
+	select {
+	case <-w.done:
+		return scela.ErrBridgeClosed
+	default:
+	}
+
+	body, err := scela.MarshalMessage(msg)
+	if err != nil {
+		return fmt.Errorf("failed to encode message %s: %w", msg.ID(), err)
+	}
+	var errs []error
+	for _, url := range w.urls {
+		if err := w.post(ctx, url, msg, body); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Close implements scela.Sink.
+func (w *Webhook) Close() error {
+	w.closeOnce.Do(func() { close(w.done) })
+	return nil
+}
+
+// post posts body to url, retrying failures that may be temporary.
+func (w *Webhook) post(ctx context.Context, url string, msg scela.Message, body []byte) error {
+	for attempt := 1; ; attempt++ {
+		retry, err := w.postOnce(ctx, url, msg, body)
+		if err == nil {
+			return nil
+		}
+		if !retry || attempt > w.opts.retries {
+			return fmt.Errorf("failed to post message %s to %s: %w", msg.ID(), url, err)
+		}
+
+		select {
+		case <-time.After(w.opts.backoff(attempt)):
+		case <-w.done:
+			return scela.ErrBridgeClosed
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// postOnce makes a single request and reports whether it may be retried.
+func (w *Webhook) postOnce(ctx context.Context, url string, msg scela.Message, body []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderMessageID, msg.ID())
+	req.Header.Set(HeaderTopic, msg.Topic())
+	if w.opts.secret != nil {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		req.Header.Set(HeaderTimestamp, timestamp)
+		req.Header.Set(HeaderSignature, sign(w.opts.secret, timestamp, body))
+	}
+
+	resp, err := w.opts.client.Do(req)
+	if err != nil {
+		return ctx.Err() == nil, err
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	_ = resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	retry := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+	return retry, fmt.Errorf("unexpected status %s", resp.Status)
+}
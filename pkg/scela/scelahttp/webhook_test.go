@@ -0,0 +1,94 @@
+package scelahttp
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/toutaio/toutago-scela-bus/pkg/scela"
+)
+
+func TestWebhook_DeliversToReceiver(t *testing.T) {
+	secret := []byte("s3cret")
+
+	remote := scela.New()
+	defer remote.Close()
+	received := make(chan scela.Message, 1)
+	_, _ = remote.Subscribe("orders.*", scela.HandlerFunc(func(ctx context.Context, msg scela.Message) error {
+		received <- msg
+		return nil
+	}))
+	server := httptest.NewServer(NewReceiver(remote, WithSecret(secret)))
+	defer server.Close()
+
+	local := scela.New()
+	defer local.Close()
+	_, _ = local.Subscribe("orders.*", NewWebhook([]string{server.URL}, WithSecret(secret)))
+
+	sent := scela.NewMessage("orders.created", map[string]interface{}{"id": "o-1"})
+	sent.Metadata()["tenant"] = "acme"
+	if err := scela.PublishMessage(context.Background(), local, sent); err != nil {
+		t.Fatalf("PublishMessage: %v", err)
+	}
+
+	select {
+	case got := <-received:
+		if got.ID() != sent.ID() || got.Topic() != "orders.created" || got.Metadata()["tenant"] != "acme" {
+			t.Errorf("unexpected message: ID %s topic %s metadata %v", got.ID(), got.Topic(), got.Metadata())
+		}
+		if hops := scela.Hops(got); len(hops) == 0 || hops[len(hops)-1].Component != scela.HopBridgeIn {
+			t.Errorf("expected the bridge.in hop, got %v", hops)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("message not received")
+	}
+}
+
+func TestWebhook_RetriesTemporaryFailures(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		if r.Header.Get(HeaderTopic) != "orders.created" || r.Header.Get(HeaderMessageID) == "" {
+			t.Errorf("missing message headers: %v", r.Header)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	webhook := NewWebhook([]string{server.URL}, WithRetries(2, scela.ConstantBackoff(0)))
+	if err := webhook.Send(context.Background(), scela.NewMessage("orders.created", 1)); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if calls.Load() != 3 {
+		t.Errorf("expected 3 attempts, got %d", calls.Load())
+	}
+}
+
+func TestWebhook_DoesNotRetryRejections(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	webhook := NewWebhook([]string{server.URL}, WithRetries(5, scela.ConstantBackoff(0)))
+	if err := webhook.Send(context.Background(), scela.NewMessage("orders.created", 1)); err == nil {
+		t.Fatal("expected an error for a rejected message")
+	}
+	if calls.Load() != 1 {
+		t.Errorf("expected a single attempt, got %d", calls.Load())
+	}
+
+	_ = webhook.Close()
+	if err := webhook.Send(context.Background(), scela.NewMessage("orders.created", 1)); !errors.Is(err, scela.ErrBridgeClosed) {
+		t.Errorf("Send after Close returned %v, want ErrBridgeClosed", err)
+	}
+}
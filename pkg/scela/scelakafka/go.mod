@@ -0,0 +1,15 @@
+module github.com/toutaio/toutago-scela-bus/pkg/scela/scelakafka
+
+go 1.22.9
+
+require (
+	github.com/segmentio/kafka-go v0.4.47
+	github.com/toutaio/toutago-scela-bus v0.0.0
+)
+
+require (
+	github.com/klauspost/compress v1.15.9 // indirect
+	github.com/pierrec/lz4/v4 v4.1.15 // indirect
+)
+
+replace github.com/toutaio/toutago-scela-bus => ../../..
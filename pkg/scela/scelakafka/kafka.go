@@ -0,0 +1,376 @@
+// Package scelakafka bridges scela buses to Kafka, so that services can
+// move between in-process and distributed messaging without changing their
+// handlers.
+//
+// A Sink writes bus messages to Kafka and a Source reads them
+// back, to be wired with scela.ForwardTo and scela.ConsumeFrom:
+//
+//	writer := &kafka.Writer{Addr: kafka.TCP("localhost:9092")}
+//	scela.ForwardTo(bus, "orders.#", scelakafka.NewSink(writer))
+//
+//	reader := kafka.NewReader(kafka.ReaderConfig{
+//		Brokers: []string{"localhost:9092"},
+//		GroupID: "billing",
+//		Topic:   "orders.created",
+//	})
+//	go scela.ConsumeFrom(ctx, scelakafka.NewSource(reader), bus)
+//
+// The payload is the value of the Kafka message, encoded as JSON. String
+// metadata travel as headers of the same name, so that consumers outside
+// scela can read them; the message ID, topic and priority, and metadata of
+// other types, travel in the scela-* headers.
+package scelakafka
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/toutaio/toutago-scela-bus/pkg/scela"
+)
+
+// Headers carrying the parts of a scela message that have no Kafka
+// equivalent.
+const (
+	// HeaderID holds the message ID.
+	HeaderID = "scela-id"
+	// HeaderTopic holds the bus topic, which may differ from the Kafka
+	// topic, see WithTopicMapping.
+	HeaderTopic = "scela-topic"
+	// HeaderPriority holds the message priority.
+	HeaderPriority = "scela-priority"
+	// HeaderMetadata holds the metadata that are not strings, as a JSON
+	// object.
+	HeaderMetadata = "scela-metadata"
+)
+
+// Writer is the part of a *kafka.Writer used by a Sink.
+type Writer interface {
+	WriteMessages(ctx context.Context, msgs ...kafka.Message) error
+	Close() error
+}
+
+// Reader is the part of a *kafka.Reader used by a Source. The reader must
+// belong to a consumer group, so that acknowledged messages are committed.
+type Reader interface {
+	FetchMessage(ctx context.Context) (kafka.Message, error)
+	CommitMessages(ctx context.Context, msgs ...kafka.Message) error
+	Close() error
+}
+
+// SinkOption configures a Sink.
+type SinkOption func(*Sink)
+
+// WithTopicMapping sets the Kafka topic of each bus topic. By default
+// messages go to the Kafka topic named like their bus topic. Returning ""
+// leaves the topic to the writer, which must then have one.
+func WithTopicMapping(fn func(topic string) string) SinkOption {
+	return func(s *Sink) {
+		if fn != nil {
+			s.topic = fn
+		}
+	}
+}
+
+// Sink is a scela.Sink writing messages to Kafka. Messages published with
+// a partition key (see scela.PublishWithKey) use it as their Kafka key, so
+// that Kafka keeps them in order.
+type Sink struct {
+	writer Writer
+	topic  func(topic string) string
+
+	mu     sync.Mutex
+	closed bool
+}
+
+// NewSink creates a sink writing through writer, which it closes on Close.
+// Unless WithTopicMapping leaves the topic to it, the writer must not set a
+// topic of its own.
+func NewSink(writer Writer, opts ...SinkOption) *Sink {
+	s := &Sink{
+		writer: writer,
+		topic:  func(topic string) string { return topic },
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Send implements scela.Sink.
+func (s *Sink) Send(ctx context.Context, msg scela.Message) error {
+	s.mu.Lock()
+	closed := s.closed
+	s.mu.Unlock()
+	if closed {
+		return scela.ErrBridgeClosed
+	}
+
+	km, err := encode(msg)
+	if err != nil {
+		return err
+	}
+	km.Topic = s.topic(msg.Topic())
+	if err := s.writer.WriteMessages(ctx, km); err != nil {
+		return fmt.Errorf("failed to send message %s: %w", msg.ID(), err)
+	}
+	return nil
+}
+
+// Close implements scela.Sink.
+func (s *Sink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	return s.writer.Close()
+}
+
+// SourceOption configures a Source.
+type SourceOption func(*Source)
+
+// WithBusTopic sets the bus topic of the messages written to a Kafka topic
+// by producers outside scela, which do not set HeaderTopic. By default it
+// is the Kafka topic.
+func WithBusTopic(fn func(kafkaTopic string) string) SourceOption {
+	return func(s *Source) {
+		if fn != nil {
+			s.busTopic = fn
+		}
+	}
+}
+
+// Source is a scela.Source reading messages from Kafka. A message is
+// committed when it is acknowledged; a nacked message is delivered again
+// before the following ones. Messages left uncommitted when the source
+// stops are read again by the next member of the consumer group.
+type Source struct {
+	reader   Reader
+	busTopic func(kafkaTopic string) string
+
+	mu     sync.Mutex
+	nacked []kafka.Message
+
+	ctx       context.Context
+	cancel    context.CancelFunc
+	closeOnce sync.Once
+	closeErr  error
+}
+
+// NewSource creates a source reading through reader, which it closes on
+// Close.
+func NewSource(reader Reader, opts ...SourceOption) *Source {
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &Source{
+		reader:   reader,
+		busTopic: func(kafkaTopic string) string { return kafkaTopic },
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Receive implements scela.Source.
+func (s *Source) Receive(ctx context.Context) (scela.Delivery, error) {
+	if s.ctx.Err() != nil {
+		return nil, scela.ErrBridgeClosed
+	}
+
+	km, ok := s.nextNacked()
+	if !ok {
+		fetchCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		stop := context.AfterFunc(s.ctx, cancel)
+		defer stop()
+
+		var err error
+		km, err = s.reader.FetchMessage(fetchCtx)
+		if err != nil {
+			if s.ctx.Err() != nil {
+				return nil, scela.ErrBridgeClosed
+			}
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			return nil, fmt.Errorf("failed to fetch message: %w", err)
+		}
+	}
+
+	msg, err := decode(km, s.busTopic)
+	if err != nil {
+		// Skip the message rather than block the partition on it
+		_ = s.reader.CommitMessages(ctx, km)
+		return nil, err
+	}
+	return &delivery{source: s, km: km, msg: msg}, nil
+}
+
+// nextNacked pops the oldest nacked message.
+func (s *Source) nextNacked() (kafka.Message, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.nacked) == 0 {
+		return kafka.Message{}, false
+	}
+	km := s.nacked[0]
+	s.nacked = s.nacked[1:]
+	return km, true
+}
+
+// nack keeps km to be delivered again, before the later nacked messages of
+// its partition.
+func (s *Source) nack(km kafka.Message) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := len(s.nacked)
+	for j, n := range s.nacked {
+		if n.Topic == km.Topic && n.Partition == km.Partition && n.Offset > km.Offset {
+			i = j
+			break
+		}
+	}
+	s.nacked = append(s.nacked, kafka.Message{})
+	copy(s.nacked[i+1:], s.nacked[i:])
+	s.nacked[i] = km
+}
+
+// Close implements scela.Source.
+func (s *Source) Close() error {
+	s.closeOnce.Do(func() {
+		s.cancel()
+		s.closeErr = s.reader.Close()
+	})
+	return s.closeErr
+}
+
+// delivery is a message read by a Source.
+type delivery struct {
+	source *Source
+	km     kafka.Message
+	msg    scela.Message
+}
+
+// Message implements scela.Delivery.
+func (d *delivery) Message() scela.Message {
+	return d.msg
+}
+
+// Ack implements scela.Delivery.
+func (d *delivery) Ack() error {
+	if err := d.source.reader.CommitMessages(context.Background(), d.km); err != nil {
+		return fmt.Errorf("failed to commit message %s: %w", d.msg.ID(), err)
+	}
+	return nil
+}
+
+// Nack implements scela.Delivery.
+func (d *delivery) Nack() error {
+	d.source.nack(d.km)
+	return nil
+}
+
+// encode returns the Kafka message of msg, without a topic.
+func encode(msg scela.Message) (kafka.Message, error) {
+	value, err := json.Marshal(msg.Payload())
+	if err != nil {
+		return kafka.Message{}, fmt.Errorf("failed to encode payload of message %s: %w", msg.ID(), err)
+	}
+
+	km := kafka.Message{
+		Value: value,
+		Time:  msg.Timestamp(),
+		Headers: []kafka.Header{
+			{Key: HeaderID, Value: []byte(msg.ID())},
+			{Key: HeaderTopic, Value: []byte(msg.Topic())},
+			{Key: HeaderPriority, Value: []byte(strconv.Itoa(int(scela.MessagePriority(msg))))},
+		},
+	}
+
+	others := make(map[string]interface{})
+	for k, v := range msg.Metadata() {
+		if s, ok := v.(string); ok {
+			km.Headers = append(km.Headers, kafka.Header{Key: k, Value: []byte(s)})
+		} else {
+			others[k] = v
+		}
+	}
+	if len(others) > 0 {
+		data, err := json.Marshal(others)
+		if err != nil {
+			return kafka.Message{}, fmt.Errorf("failed to encode metadata of message %s: %w", msg.ID(), err)
+		}
+		km.Headers = append(km.Headers, kafka.Header{Key: HeaderMetadata, Value: data})
+	}
+
+	if key, ok := msg.Metadata()[scela.MetadataPartitionKey].(string); ok {
+		km.Key = []byte(key)
+	}
+	return km, nil
+}
+
+// decode returns the scela message of km. Messages written by producers
+// outside scela get an ID made of their topic, partition and offset, and
+// their value is the payload if it is JSON, or a string otherwise.
+func decode(km kafka.Message, busTopic func(string) string) (scela.Message, error) {
+	wm := struct {
+		ID        string                 `json:"id"`
+		Topic     string                 `json:"topic"`
+		Payload   json.RawMessage        `json:"payload,omitempty"`
+		Metadata  map[string]interface{} `json:"metadata"`
+		Timestamp time.Time              `json:"timestamp"`
+		Priority  int                    `json:"priority,omitempty"`
+	}{
+		Topic:     busTopic(km.Topic),
+		Metadata:  make(map[string]interface{}),
+		Timestamp: km.Time,
+	}
+
+	for _, h := range km.Headers {
+		switch h.Key {
+		case HeaderID:
+			wm.ID = string(h.Value)
+		case HeaderTopic:
+			wm.Topic = string(h.Value)
+		case HeaderPriority:
+			wm.Priority, _ = strconv.Atoi(string(h.Value))
+		case HeaderMetadata:
+			if err := json.Unmarshal(h.Value, &wm.Metadata); err != nil {
+				return nil, fmt.Errorf("invalid %s header at offset %d: %w", HeaderMetadata, km.Offset, err)
+			}
+		}
+	}
+	for _, h := range km.Headers {
+		switch h.Key {
+		case HeaderID, HeaderTopic, HeaderPriority, HeaderMetadata:
+		default:
+			wm.Metadata[h.Key] = string(h.Value)
+		}
+	}
+
+	if json.Valid(km.Value) {
+		wm.Payload = km.Value
+	} else {
+		wm.Payload, _ = json.Marshal(string(km.Value))
+	}
+	if wm.ID == "" {
+		wm.ID = fmt.Sprintf("%s-%d-%d", km.Topic, km.Partition, km.Offset)
+	}
+
+	data, err := json.Marshal(wm)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode message at offset %d: %w", km.Offset, err)
+	}
+	return scela.UnmarshalMessage(data)
+}
@@ -0,0 +1,211 @@
+package scelakafka
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/toutaio/toutago-scela-bus/pkg/scela"
+	"github.com/toutaio/toutago-scela-bus/pkg/scela/scelatest"
+)
+
+// fakeKafka is an in-memory stand-in for a Kafka cluster with a single
+// partition per topic and a single consumer group.
+type fakeKafka struct {
+	mu        sync.Mutex
+	topics    map[string][]kafka.Message
+	committed map[string]int64
+	position  map[string]int64
+	ready     chan struct{}
+}
+
+func newFakeKafka() *fakeKafka {
+	return &fakeKafka{
+		topics:    make(map[string][]kafka.Message),
+		committed: make(map[string]int64),
+		position:  make(map[string]int64),
+		ready:     make(chan struct{}),
+	}
+}
+
+// rebalance makes the group read again from the committed offsets, as
+// Kafka does when a consumer reconnects.
+func (k *fakeKafka) rebalance() {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	for topic := range k.position {
+		k.position[topic] = k.committed[topic]
+	}
+}
+
+type fakeWriter struct {
+	kafka *fakeKafka
+}
+
+func (w *fakeWriter) WriteMessages(ctx context.Context, msgs ...kafka.Message) error {
+	k := w.kafka
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	for _, m := range msgs {
+		if m.Topic == "" {
+			return errors.New("message has no topic")
+		}
+		m.Offset = int64(len(k.topics[m.Topic]))
+		k.topics[m.Topic] = append(k.topics[m.Topic], m)
+	}
+	close(k.ready)
+	k.ready = make(chan struct{})
+	return nil
+}
+
+func (w *fakeWriter) Close() error { return nil }
+
+type fakeReader struct {
+	kafka *fakeKafka
+	topic string
+}
+
+func (r *fakeReader) FetchMessage(ctx context.Context) (kafka.Message, error) {
+	k := r.kafka
+	for {
+		k.mu.Lock()
+		pos := k.position[r.topic]
+		if pos < int64(len(k.topics[r.topic])) {
+			k.position[r.topic] = pos + 1
+			m := k.topics[r.topic][pos]
+			k.mu.Unlock()
+			return m, nil
+		}
+		ready := k.ready
+		k.mu.Unlock()
+
+		select {
+		case <-ready:
+		case <-ctx.Done():
+			return kafka.Message{}, ctx.Err()
+		}
+	}
+}
+
+func (r *fakeReader) CommitMessages(ctx context.Context, msgs ...kafka.Message) error {
+	k := r.kafka
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	for _, m := range msgs {
+		if m.Offset+1 > k.committed[m.Topic] {
+			k.committed[m.Topic] = m.Offset + 1
+		}
+	}
+	return nil
+}
+
+func (r *fakeReader) Close() error { return nil }
+
+func TestBridge_Conformance(t *testing.T) {
+	scelatest.TestBridge(t, func(t *testing.T) scelatest.BridgeFixture {
+		k := newFakeKafka()
+		return scelatest.BridgeFixture{
+			Sink:      NewSink(&fakeWriter{kafka: k}, WithTopicMapping(func(string) string { return "events" })),
+			Source:    NewSource(&fakeReader{kafka: k, topic: "events"}),
+			Interrupt: k.rebalance,
+		}
+	})
+}
+
+func TestEncodeDecode(t *testing.T) {
+	msg := scela.NewMessageWithPriority("orders.created", map[string]interface{}{"id": "o-1"}, scela.PriorityHigh)
+	msg.Metadata()["tenant"] = "acme"
+	msg.Metadata()[scela.MetadataPartitionKey] = "o-1"
+	msg.Metadata()[scela.MetadataSequence] = uint64(7)
+
+	km, err := encode(msg)
+	if err != nil {
+		t.Fatalf("encode() error = %v", err)
+	}
+	if string(km.Key) != "o-1" {
+		t.Errorf("expected the partition key as Kafka key, got %q", km.Key)
+	}
+	headers := make(map[string]string)
+	for _, h := range km.Headers {
+		headers[h.Key] = string(h.Value)
+	}
+	if headers["tenant"] != "acme" || headers[HeaderID] != msg.ID() {
+		t.Errorf("unexpected headers %v", headers)
+	}
+
+	km.Topic = "orders"
+	got, err := decode(km, func(topic string) string { return topic })
+	if err != nil {
+		t.Fatalf("decode() error = %v", err)
+	}
+	if got.ID() != msg.ID() || got.Topic() != "orders.created" || scela.MessagePriority(got) != scela.PriorityHigh {
+		t.Errorf("got %s on %s with priority %v", got.ID(), got.Topic(), scela.MessagePriority(got))
+	}
+	if !got.Timestamp().Equal(msg.Timestamp()) || got.Metadata()["tenant"] != "acme" {
+		t.Errorf("timestamp %v and metadata %v not restored", got.Timestamp(), got.Metadata())
+	}
+	if seq, ok := scela.Sequence(got); !ok || seq != 7 {
+		t.Errorf("expected sequence 7, got %d, %v", seq, ok)
+	}
+	if order, _ := got.Payload().(map[string]interface{}); order["id"] != "o-1" {
+		t.Errorf("unexpected payload %v", got.Payload())
+	}
+}
+
+func TestDecode_ForeignProducer(t *testing.T) {
+	km := kafka.Message{
+		Topic:   "legacy-orders",
+		Offset:  42,
+		Value:   []byte("plain text"),
+		Headers: []kafka.Header{{Key: "source", Value: []byte("erp")}},
+		Time:    time.Now(),
+	}
+	got, err := decode(km, func(topic string) string { return "orders.legacy" })
+	if err != nil {
+		t.Fatalf("decode() error = %v", err)
+	}
+	if got.Topic() != "orders.legacy" || got.Payload() != "plain text" || got.Metadata()["source"] != "erp" {
+		t.Errorf("got %v on %s with %v", got.Payload(), got.Topic(), got.Metadata())
+	}
+	if got.ID() != "legacy-orders-0-42" {
+		t.Errorf("unexpected ID %s", got.ID())
+	}
+}
+
+func TestBridge_BetweenBuses(t *testing.T) {
+	k := newFakeKafka()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	publisher := scela.New()
+	defer publisher.Close()
+	if _, err := scela.ForwardTo(publisher, "orders.#", NewSink(&fakeWriter{kafka: k})); err != nil {
+		t.Fatalf("ForwardTo() error = %v", err)
+	}
+
+	consumer := scela.New()
+	defer consumer.Close()
+	received := make(chan string, 1)
+	_, _ = scela.SubscribeTyped(consumer, "orders.created", func(ctx context.Context, id string) error {
+		received <- id
+		return nil
+	})
+	source := NewSource(&fakeReader{kafka: k, topic: "orders.created"})
+	defer source.Close()
+	go func() { _ = scela.ConsumeFrom(ctx, source, consumer) }()
+
+	if err := publisher.PublishSync(ctx, "orders.created", "o-1"); err != nil {
+		t.Fatalf("PublishSync() error = %v", err)
+	}
+	select {
+	case id := <-received:
+		if id != "o-1" {
+			t.Errorf("unexpected payload %v", id)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("message not bridged")
+	}
+}
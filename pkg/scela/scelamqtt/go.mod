@@ -0,0 +1,19 @@
+module github.com/toutaio/toutago-scela-bus/pkg/scela/scelamqtt
+
+go 1.22.9
+
+require (
+	github.com/eclipse/paho.mqtt.golang v1.5.0
+	github.com/mochi-mqtt/server/v2 v2.6.6
+	github.com/toutaio/toutago-scela-bus v0.0.0
+)
+
+require (
+	github.com/gorilla/websocket v1.5.3 // indirect
+	github.com/rs/xid v1.4.0 // indirect
+	golang.org/x/net v0.27.0 // indirect
+	golang.org/x/sync v0.7.0 // indirect
+	gopkg.in/yaml.v3 v3.0.1 // indirect
+)
+
+replace github.com/toutaio/toutago-scela-bus => ../../..
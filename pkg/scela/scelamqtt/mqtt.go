@@ -0,0 +1,430 @@
+// Package scelamqtt bridges scela buses and MQTT brokers, so that IoT
+// devices can publish into the bus and receive its messages.
+//
+// NewBridge mirrors topic patterns in both directions:
+//
+//	client, err := scelamqtt.Connect(ctx, scelamqtt.NewClientOptions("tcp://localhost:1883", "orders-service"))
+//	bridge, err := scelamqtt.NewBridge(ctx, client, []string{"devices.#"},
+//		scelamqtt.WithTopicPrefix("factory"))
+//	err = bridge.Attach(ctx, bus)
+//	defer bridge.Close()
+//
+// Bus topics map to MQTT topics under the prefix, with "/" separating the
+// segments: "devices.42.temperature" becomes "factory/devices/42/temperature".
+// Pattern wildcards map to MQTT filters: "*" to "+", a trailing "#" to "#"
+// and a trailing ">" to "+/#". MQTT has no wildcard for a "#" or ">" before
+// the last segment.
+//
+// Messages are sent as the JSON of scela.MarshalMessage, keeping their ID
+// and metadata across buses. Messages published by devices, whose payload
+// is not such an envelope, get a new ID, and their payload is decoded as
+// JSON, or kept as a string if it is not JSON. WithRawPayload sends bare
+// payloads to devices that cannot parse envelopes.
+//
+// The QoS level maps onto the delivery guarantees of the bus. At QoS 0 a
+// Sink does not wait for the broker and the messages published while a
+// Source is disconnected are lost. At QoS 1, the default, Send returns once
+// the broker acknowledged the message, so that the bus retries failed
+// sends, and a Source acknowledges a message to the broker only when its
+// delivery is acked: with the persistent session of NewClientOptions, the
+// broker delivers the unacknowledged messages again after a reconnect. The
+// messages queued by the Source are then received twice, and may be out of
+// order.
+package scelamqtt
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/toutaio/toutago-scela-bus/pkg/scela"
+)
+
+// QoS levels.
+const (
+	// AtMostOnce delivers messages at most once, without acknowledgements.
+	AtMostOnce byte = 0
+	// AtLeastOnce delivers messages until they are acknowledged.
+	AtLeastOnce byte = 1
+)
+
+// defaultMaxReconnectInterval bounds the backoff between reconnection
+// attempts of the clients of NewClientOptions.
+const defaultMaxReconnectInterval = 10 * time.Second
+
+// NewClientOptions returns the options of a client connecting to broker
+// with the settings a long-running bridge needs: the first connection is
+// retried rather than failing, the client reconnects forever and
+// resubscribes, and its session persists across reconnects so the broker
+// keeps the messages it has not acknowledged. Messages are only
+// acknowledged when their delivery is acked. clientID must be unique to
+// the process and stable across restarts for the session to survive them.
+// The options may be changed further before Connect.
+func NewClientOptions(broker, clientID string) *mqtt.ClientOptions {
+	return mqtt.NewClientOptions().
+		AddBroker(broker).
+		SetClientID(clientID).
+		SetCleanSession(false).
+		SetAutoReconnect(true).
+		SetResumeSubs(true).
+		SetConnectRetry(true).
+		SetMaxReconnectInterval(defaultMaxReconnectInterval).
+		SetAutoAckDisabled(true)
+}
+
+// Connect creates a client with opts and waits until it is connected or ctx
+// is done.
+func Connect(ctx context.Context, opts *mqtt.ClientOptions) (mqtt.Client, error) {
+	client := mqtt.NewClient(opts)
+	if err := wait(ctx, client.Connect()); err != nil {
+		client.Disconnect(0)
+		return nil, fmt.Errorf("failed to connect to MQTT broker: %w", err)
+	}
+	return client, nil
+}
+
+// Option configures a Sink, a Source or a bridge.
+type Option func(*options)
+
+// options holds the settings shared by sinks and sources.
+type options struct {
+	prefix string
+	qos    byte
+	raw    bool
+}
+
+// WithTopicPrefix maps the bus topics to the MQTT topics under prefix, so
+// that several applications can share a broker. Sources only receive the
+// topics under the prefix. By default MQTT topics are named like the bus
+// topics.
+func WithTopicPrefix(prefix string) Option {
+	return func(o *options) {
+		o.prefix = strings.TrimSuffix(prefix, "/")
+	}
+}
+
+// WithQoS sets the QoS level of the published messages and subscriptions,
+// AtLeastOnce by default. QoS 2 is accepted, but bridged messages are still
+// delivered at least once: a message may be received again after a failure.
+func WithQoS(qos byte) Option {
+	return func(o *options) {
+		if qos <= 2 {
+			o.qos = qos
+		}
+	}
+}
+
+// WithRawPayload makes sinks publish only the JSON payload of messages, for
+// devices that cannot parse the envelope of scela.MarshalMessage, and
+// sources decode every MQTT payload as a message payload. The message ID,
+// metadata and priority are lost.
+func WithRawPayload() Option {
+	return func(o *options) {
+		o.raw = true
+	}
+}
+
+// newOptions applies opts to the default options.
+func newOptions(opts []Option) options {
+	o := options{qos: AtLeastOnce}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// mqttTopic returns the MQTT topic of a bus topic.
+func (o options) mqttTopic(topic string) string {
+	t := strings.ReplaceAll(topic, ".", "/")
+	if o.prefix == "" {
+		return t
+	}
+	return o.prefix + "/" + t
+}
+
+// topic returns the bus topic of an MQTT topic.
+func (o options) topic(mqttTopic string) string {
+	if o.prefix != "" {
+		mqttTopic = strings.TrimPrefix(mqttTopic, o.prefix+"/")
+	}
+	return strings.ReplaceAll(mqttTopic, "/", ".")
+}
+
+// filter returns the MQTT topic filter matching the topics pattern matches.
+func (o options) filter(pattern string) (string, error) {
+	if pattern == "*" || pattern == "#" || pattern == ">" {
+		return o.mqttTopic("#"), nil
+	}
+
+	segments := strings.Split(pattern, ".")
+	last := len(segments) - 1
+	for i, segment := range segments {
+		switch {
+		case segment == "*":
+			segments[i] = "+"
+		case segment == "#" && i == last:
+		case segment == ">" && i == last:
+			segments[i] = "+/#"
+		case segment == "#" || segment == ">":
+			return "", fmt.Errorf("pattern %q has a %q MQTT cannot express before its last segment", pattern, segment)
+		case strings.ContainsAny(segment, "+/"):
+			return "", fmt.Errorf("pattern %q has a segment %q MQTT cannot express", pattern, segment)
+		}
+	}
+	return o.mqttTopic(strings.Join(segments, ".")), nil
+}
+
+// NewBridge returns a scela.Bridge mirroring patterns between a bus and the
+// broker of client, through a Sink and a Source created with opts, waiting
+// for the subscriptions of the source until ctx is done. The client is owned
+// by the caller: closing the bridge leaves it connected.
+func NewBridge(ctx context.Context, client mqtt.Client, patterns []string, opts ...Option) (scela.Bridge, error) {
+	source, err := NewSource(ctx, client, patterns, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return scela.NewBridge(NewSink(client, opts...), source, patterns...), nil
+}
+
+// Sink is a scela.Sink publishing messages to an MQTT broker.
+//
+// At QoS 1 and 2, Send returns once the broker acknowledged the message, and
+// fails if ctx is done first, leaving the retries to the bus. At QoS 0 it
+// returns once the message is handed to the client.
+type Sink struct {
+	client mqtt.Client
+	opts   options
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewSink creates a sink publishing through client. The client is owned by
+// the caller: Close leaves it connected.
+func NewSink(client mqtt.Client, opts ...Option) *Sink {
+	return &Sink{
+		client: client,
+		opts:   newOptions(opts),
+		done:   make(chan struct{}),
+	}
+}
+
+// Send implements scela.Sink.
+func (s *Sink) Send(ctx context.Context, msg scela.Message) error {
+	select {
+	case <-s.done:
+		return scela.ErrBridgeClosed
+	default:
+	}
+
+	data, err := encode(msg, s.opts)
+	if err != nil {
+		return err
+	}
+	token := s.client.Publish(s.opts.mqttTopic(msg.Topic()), s.opts.qos, false, data)
+	if s.opts.qos == AtMostOnce {
+		return nil
+	}
+	if err := wait(ctx, token); err != nil {
+		return fmt.Errorf("failed to send message %s: %w", msg.ID(), err)
+	}
+	return nil
+}
+
+// Close implements scela.Sink.
+func (s *Sink) Close() error {
+	s.closeOnce.Do(func() { close(s.done) })
+	return nil
+}
+
+// Source is a scela.Source receiving the messages published to an MQTT
+// broker on the topics matching its patterns. Messages are delivered in the
+// order they were received; a nacked message is delivered again before the
+// following ones.
+type Source struct {
+	client  mqtt.Client
+	opts    options
+	filters []string
+
+	mu     sync.Mutex
+	queued []mqtt.Message
+	// ready is signalled when a message is queued.
+	ready chan struct{}
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewSource creates a source subscribing through client to the topics
+// matching the bus topic patterns, waiting for the broker to confirm the
+// subscriptions until ctx is done. The client is owned by the caller: Close
+// leaves it connected.
+func NewSource(ctx context.Context, client mqtt.Client, patterns []string, opts ...Option) (*Source, error) {
+	s := &Source{
+		client: client,
+		opts:   newOptions(opts),
+		ready:  make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}
+
+	for _, pattern := range patterns {
+		filter, err := s.opts.filter(pattern)
+		if err != nil {
+			s.unsubscribe()
+			return nil, err
+		}
+		if err := wait(ctx, client.Subscribe(filter, s.opts.qos, s.enqueue)); err != nil {
+			s.unsubscribe()
+			return nil, fmt.Errorf("failed to subscribe to %s: %w", filter, err)
+		}
+		s.filters = append(s.filters, filter)
+	}
+	return s, nil
+}
+
+// enqueue queues a message received from the broker.
+func (s *Source) enqueue(_ mqtt.Client, m mqtt.Message) {
+	s.mu.Lock()
+	s.queued = append(s.queued, m)
+	s.mu.Unlock()
+
+	select {
+	case s.ready <- struct{}{}:
+	default:
+	}
+}
+
+// Receive implements scela.Source.
+func (s *Source) Receive(ctx context.Context) (scela.Delivery, error) {
+	for {
+		select {
+		case <-s.done:
+			return nil, scela.ErrBridgeClosed
+		default:
+		}
+
+		if m, ok := s.next(); ok {
+			return &delivery{source: s, m: m, msg: decode(m, s.opts)}, nil
+		}
+
+		select {
+		case <-s.ready:
+		case <-s.done:
+			return nil, scela.ErrBridgeClosed
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// next pops the oldest queued message.
+func (s *Source) next() (mqtt.Message, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.queued) == 0 {
+		return nil, false
+	}
+	m := s.queued[0]
+	s.queued = s.queued[1:]
+	return m, true
+}
+
+// Close implements scela.Source.
+func (s *Source) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.done)
+		s.unsubscribe()
+	})
+	return nil
+}
+
+// unsubscribe removes the subscriptions of the source.
+func (s *Source) unsubscribe() {
+	if len(s.filters) > 0 {
+		s.client.Unsubscribe(s.filters...)
+	}
+	s.filters = nil
+}
+
+// delivery is a message received by a Source.
+type delivery struct {
+	source *Source
+	m      mqtt.Message
+	msg    scela.Message
+}
+
+// Message implements scela.Delivery.
+func (d *delivery) Message() scela.Message {
+	return d.msg
+}
+
+// Ack implements scela.Delivery. It acknowledges the message to the broker.
+func (d *delivery) Ack() error {
+	d.m.Ack()
+	return nil
+}
+
+// Nack implements scela.Delivery. MQTT has no negative acknowledgements, so
+// the message is delivered again before the messages queued after it, and
+// by the broker after a reconnect if the process stops first.
+func (d *delivery) Nack() error {
+	s := d.source
+	s.mu.Lock()
+	s.queued = append([]mqtt.Message{d.m}, s.queued...)
+	s.mu.Unlock()
+
+	select {
+	case s.ready <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// encode returns the MQTT payload of msg.
+func encode(msg scela.Message, opts options) ([]byte, error) {
+	var (
+		data []byte
+		err  error
+	)
+	if opts.raw {
+		data, err = json.Marshal(msg.Payload())
+	} else {
+		data, err = scela.MarshalMessage(msg)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode message %s: %w", msg.ID(), err)
+	}
+	return data, nil
+}
+
+// decode returns the scela message of m. Payloads that are not envelopes of
+// scela.MarshalMessage are published outside scela: they get a new message
+// on the topic of m, with a JSON payload, or a string if it is not JSON.
+func decode(m mqtt.Message, opts options) scela.Message {
+	if !opts.raw {
+		if msg, err := scela.UnmarshalMessage(m.Payload()); err == nil && msg.ID() != "" {
+			return msg
+		}
+	}
+
+	var payload interface{}
+	if err := json.Unmarshal(m.Payload(), &payload); err != nil {
+		payload = string(m.Payload())
+	}
+	return scela.NewMessage(opts.topic(m.Topic()), payload)
+}
+
+// wait waits for token to complete or ctx to be done.
+func wait(ctx context.Context, token mqtt.Token) error {
+	select {
+	case <-token.Done():
+		return token.Error()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
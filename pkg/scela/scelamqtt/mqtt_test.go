@@ -0,0 +1,271 @@
+package scelamqtt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	server "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/hooks/auth"
+	"github.com/mochi-mqtt/server/v2/listeners"
+	"github.com/toutaio/toutago-scela-bus/pkg/scela"
+	"github.com/toutaio/toutago-scela-bus/pkg/scela/scelatest"
+)
+
+// broker is an embedded MQTT broker.
+type broker struct {
+	*server.Server
+	url string
+}
+
+// runBroker starts an embedded MQTT broker on a random port.
+func runBroker(t *testing.T) *broker {
+	t.Helper()
+
+	srv := server.New(&server.Options{Logger: slog.New(slog.NewTextHandler(io.Discard, nil))})
+	if err := srv.AddHook(new(auth.AllowHook), nil); err != nil {
+		t.Fatalf("AddHook: %v", err)
+	}
+	tcp := listeners.NewTCP(listeners.Config{ID: "tcp", Address: "127.0.0.1:0"})
+	if err := srv.AddListener(tcp); err != nil {
+		t.Fatalf("AddListener: %v", err)
+	}
+	go func() { _ = srv.Serve() }()
+	t.Cleanup(func() { _ = srv.Close() })
+	return &broker{Server: srv, url: "tcp://" + tcp.Address()}
+}
+
+// kick drops the connection of the client with id.
+func (b *broker) kick(t *testing.T, id string) {
+	t.Helper()
+
+	cl, ok := b.Clients.Get(id)
+	if !ok {
+		t.Fatalf("client %s not connected", id)
+	}
+	cl.Stop(errors.New("kicked"))
+}
+
+// connect connects a client with a unique ID to b, reconnecting quickly.
+func connect(t *testing.T, b *broker) (mqtt.Client, string) {
+	t.Helper()
+
+	id := fmt.Sprintf("client%d", clients.Add(1))
+	opts := NewClientOptions(b.url, id).SetMaxReconnectInterval(50 * time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	client, err := Connect(ctx, opts)
+	if err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	t.Cleanup(func() { client.Disconnect(0) })
+	return client, id
+}
+
+var clients atomic.Int64
+
+// newSource subscribes a source to patterns.
+func newSource(t *testing.T, client mqtt.Client, patterns []string, opts ...Option) *Source {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	source, err := NewSource(ctx, client, patterns, opts...)
+	if err != nil {
+		t.Fatalf("NewSource: %v", err)
+	}
+	return source
+}
+
+// receive returns the next delivery of source.
+func receive(t *testing.T, source *Source) scela.Delivery {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	d, err := source.Receive(ctx)
+	if err != nil {
+		t.Fatalf("Receive: %v", err)
+	}
+	return d
+}
+
+func TestBridgeConformance(t *testing.T) {
+	b := runBroker(t)
+
+	scelatest.TestBridge(t, func(t *testing.T) scelatest.BridgeFixture {
+		client, id := connect(t, b)
+		prefix := id
+		return scelatest.BridgeFixture{
+			Sink:   NewSink(client, WithTopicPrefix(prefix)),
+			Source: newSource(t, client, []string{"conformance.#"}, WithTopicPrefix(prefix)),
+		}
+	})
+}
+
+func TestTopics(t *testing.T) {
+	opts := newOptions([]Option{WithTopicPrefix("factory/")})
+	tests := []struct {
+		pattern string
+		want    string
+	}{
+		{"devices.42.temperature", "factory/devices/42/temperature"},
+		{"devices.*.temperature", "factory/devices/+/temperature"},
+		{"devices.#", "factory/devices/#"},
+		{"devices.>", "factory/devices/+/#"},
+		{"#", "factory/#"},
+		{"*", "factory/#"},
+	}
+	for _, tt := range tests {
+		got, err := opts.filter(tt.pattern)
+		if err != nil {
+			t.Errorf("filter(%q): %v", tt.pattern, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("filter(%q) = %q, want %q", tt.pattern, got, tt.want)
+		}
+	}
+
+	for _, pattern := range []string{"devices.#.temperature", "devices.>.temperature", "devices/42"} {
+		if _, err := opts.filter(pattern); err == nil {
+			t.Errorf("filter(%q) should fail", pattern)
+		}
+	}
+
+	if got := opts.mqttTopic("devices.42"); got != "factory/devices/42" {
+		t.Errorf("mqttTopic = %q, want %q", got, "factory/devices/42")
+	}
+	if got := opts.topic("factory/devices/42"); got != "devices.42" {
+		t.Errorf("topic = %q, want %q", got, "devices.42")
+	}
+}
+
+func TestSource_DeviceMessages(t *testing.T) {
+	b := runBroker(t)
+	client, _ := connect(t, b)
+	source := newSource(t, client, []string{"devices.*.temperature"}, WithTopicPrefix("factory"))
+	defer source.Close()
+
+	client.Publish("factory/devices/42/temperature", AtLeastOnce, false, []byte(`{"celsius":21.5}`)).Wait()
+	client.Publish("factory/devices/43/temperature", AtLeastOnce, false, []byte("not json")).Wait()
+
+	d := receive(t, source)
+	msg := d.Message()
+	payload, _ := msg.Payload().(map[string]interface{})
+	if msg.Topic() != "devices.42.temperature" || payload["celsius"] != 21.5 || msg.ID() == "" {
+		t.Errorf("unexpected message: topic %q payload %v ID %q", msg.Topic(), msg.Payload(), msg.ID())
+	}
+	_ = d.Ack()
+
+	d = receive(t, source)
+	if msg := d.Message(); msg.Topic() != "devices.43.temperature" || msg.Payload() != "not json" {
+		t.Errorf("unexpected message: topic %q payload %v", msg.Topic(), msg.Payload())
+	}
+	_ = d.Ack()
+}
+
+func TestSink_RawPayload(t *testing.T) {
+	b := runBroker(t)
+	client, _ := connect(t, b)
+
+	received := make(chan []byte, 1)
+	client.Subscribe("devices/42/commands", AtLeastOnce, func(_ mqtt.Client, m mqtt.Message) {
+		received <- m.Payload()
+		m.Ack()
+	}).Wait()
+
+	sink := NewSink(client, WithRawPayload())
+	defer sink.Close()
+	if err := sink.Send(context.Background(), scela.NewMessage("devices.42.commands", map[string]string{"led": "on"})); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	select {
+	case data := <-received:
+		if string(data) != `{"led":"on"}` {
+			t.Errorf("payload = %s, want the bare JSON payload", data)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("message not received")
+	}
+}
+
+func TestSource_RedeliversAfterReconnect(t *testing.T) {
+	b := runBroker(t)
+	client, id := connect(t, b)
+	source := newSource(t, client, []string{"orders.*"})
+	defer source.Close()
+	sink := NewSink(client)
+	defer sink.Close()
+
+	if err := sink.Send(context.Background(), scela.NewMessage("orders.created", "unacked")); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	first := receive(t, source)
+
+	// The broker keeps the unacknowledged message in the session and sends
+	// it again once the client reconnects
+	b.kick(t, id)
+	d := receive(t, source)
+	if d.Message().ID() != first.Message().ID() {
+		t.Errorf("received %v, want the unacknowledged message again", d.Message().Payload())
+	}
+	_ = d.Ack()
+}
+
+func TestBridge_MirrorsBuses(t *testing.T) {
+	b := runBroker(t)
+
+	busA, busB := scela.New(), scela.New()
+	defer busA.Close()
+	defer busB.Close()
+
+	receivedA := make(chan scela.Message, 10)
+	receivedB := make(chan scela.Message, 10)
+	_, _ = busA.Subscribe("orders.#", scela.HandlerFunc(func(ctx context.Context, msg scela.Message) error {
+		receivedA <- msg
+		return nil
+	}))
+	_, _ = busB.Subscribe("orders.#", scela.HandlerFunc(func(ctx context.Context, msg scela.Message) error {
+		receivedB <- msg
+		return nil
+	}))
+
+	for _, bus := range []scela.Bus{busA, busB} {
+		client, _ := connect(t, b)
+		bridge, err := NewBridge(context.Background(), client, []string{"orders.#"}, WithTopicPrefix("shop"))
+		if err != nil {
+			t.Fatalf("NewBridge: %v", err)
+		}
+		if err := bridge.Attach(context.Background(), bus); err != nil {
+			t.Fatalf("Attach: %v", err)
+		}
+		t.Cleanup(func() { _ = bridge.Close() })
+	}
+
+	_ = busA.Publish(context.Background(), "orders.created", "o-1")
+
+	for _, received := range []chan scela.Message{receivedA, receivedB} {
+		select {
+		case got := <-received:
+			if got.Payload() != "o-1" {
+				t.Errorf("payload = %v, want o-1", got.Payload())
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("message not received")
+		}
+	}
+
+	// Neither bus may see the message again through the broker
+	time.Sleep(200 * time.Millisecond)
+	if len(receivedA)+len(receivedB) != 0 {
+		t.Errorf("expected no echoes, got %d more messages", len(receivedA)+len(receivedB))
+	}
+}
@@ -0,0 +1,453 @@
+// Package scelanats bridges scela buses through a NATS server, so that
+// services in different processes see each other's messages as if they
+// shared a bus.
+//
+// NewBridge mirrors topic patterns in both directions:
+//
+//	conn, err := scelanats.Connect("nats://localhost:4222")
+//	bridge, err := scelanats.NewBridge(conn, []string{"orders.#"},
+//		scelanats.WithSubjectPrefix("shop"))
+//	err = bridge.Attach(ctx, bus)
+//	defer bridge.Close()
+//
+// Bus topics map to NATS subjects under the prefix, "orders.created"
+// becoming "shop.orders.created". The payload is the data of the NATS
+// message, encoded as JSON. String metadata travel as headers of the same
+// name, so that subscribers outside scela can read them; the message ID,
+// topic, timestamp and priority, and metadata of other types, travel in the
+// Scela-* headers.
+//
+// Core NATS delivers messages at most once: the messages published while a
+// source is disconnected are lost to it. The Source redelivers nacked
+// messages, but an unacknowledged message is not delivered again after a
+// reconnect.
+package scelanats
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nuid"
+	"github.com/toutaio/toutago-scela-bus/pkg/scela"
+)
+
+// Headers carrying the parts of a scela message that have no NATS
+// equivalent.
+const (
+	// HeaderID holds the message ID.
+	HeaderID = "Scela-Id"
+	// HeaderTopic holds the bus topic.
+	HeaderTopic = "Scela-Topic"
+	// HeaderTimestamp holds the message timestamp, in RFC 3339 format.
+	HeaderTimestamp = "Scela-Timestamp"
+	// HeaderPriority holds the message priority.
+	HeaderPriority = "Scela-Priority"
+	// HeaderMetadata holds the metadata that are not strings, as a JSON
+	// object.
+	HeaderMetadata = "Scela-Metadata"
+)
+
+// defaultReconnectWait is how long Connect waits between reconnection
+// attempts by default.
+const defaultReconnectWait = time.Second
+
+// Connect connects to the NATS servers at url with the reconnection settings
+// a long-running bridge needs: the first connection is retried in the
+// background rather than failing, and the client reconnects forever,
+// resubscribing the sources and buffering what sinks send meanwhile. opts
+// are applied after these settings and may override them.
+func Connect(url string, opts ...nats.Option) (*nats.Conn, error) {
+	defaults := []nats.Option{
+		nats.RetryOnFailedConnect(true),
+		nats.MaxReconnects(-1),
+		nats.ReconnectWait(defaultReconnectWait),
+	}
+	conn, err := nats.Connect(url, append(defaults, opts...)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", url, err)
+	}
+	return conn, nil
+}
+
+// Option configures a Sink, a Source or a bridge.
+type Option func(*options)
+
+// options holds the settings shared by sinks and sources.
+type options struct {
+	prefix     string
+	queueGroup string
+}
+
+// WithSubjectPrefix maps the bus topics to the NATS subjects under prefix,
+// so that several applications can share a server. Sources only receive the
+// subjects under the prefix, and remove it to get the bus topic of messages
+// published outside scela. By default subjects are named like the topics.
+func WithSubjectPrefix(prefix string) Option {
+	return func(o *options) {
+		o.prefix = strings.TrimSuffix(prefix, ".")
+	}
+}
+
+// WithQueueGroup makes sources join the queue group named group, so that
+// the replicas of a service share the messages instead of each receiving
+// all of them. It has no effect on sinks.
+func WithQueueGroup(group string) Option {
+	return func(o *options) {
+		o.queueGroup = group
+	}
+}
+
+// newOptions applies opts to the default options.
+func newOptions(opts []Option) options {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// subject returns the NATS subject of topic.
+func (o options) subject(topic string) string {
+	if o.prefix == "" {
+		return topic
+	}
+	return o.prefix + "." + topic
+}
+
+// topic returns the bus topic of subject.
+func (o options) topic(subject string) string {
+	if o.prefix == "" {
+		return subject
+	}
+	return strings.TrimPrefix(subject, o.prefix+".")
+}
+
+// subjects returns the NATS subjects matching the topics pattern matches.
+// A trailing "#" also matches the topic without it, so it maps to both that
+// topic and a ">" wildcard. NATS has no wildcard for a "#" before the last
+// segment.
+func (o options) subjects(pattern string) ([]string, error) {
+	if pattern == "*" || pattern == "#" {
+		pattern = ">"
+	}
+
+	segments := strings.Split(pattern, ".")
+	for _, segment := range segments[:len(segments)-1] {
+		if segment == "#" || segment == ">" {
+			return nil, fmt.Errorf("pattern %q has a %q NATS cannot express before its last segment", pattern, segment)
+		}
+	}
+	if segments[len(segments)-1] != "#" {
+		return []string{o.subject(pattern)}, nil
+	}
+
+	segments[len(segments)-1] = ">"
+	subjects := []string{o.subject(strings.Join(segments, "."))}
+	if len(segments) > 1 {
+		subjects = append(subjects, o.subject(strings.Join(segments[:len(segments)-1], ".")))
+	}
+	return subjects, nil
+}
+
+// NewBridge returns a scela.Bridge mirroring patterns between a bus and the
+// NATS server of conn, through a Sink and a Source created with opts. The
+// connection is owned by the caller: closing the bridge leaves it open.
+func NewBridge(conn *nats.Conn, patterns []string, opts ...Option) (scela.Bridge, error) {
+	source, err := NewSource(conn, patterns, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return scela.NewBridge(NewSink(conn, opts...), source, patterns...), nil
+}
+
+// Sink is a scela.Sink publishing messages to NATS.
+//
+// Send returns once the client has buffered the message. While the client
+// reconnects, it buffers messages up to its reconnect buffer size and Send
+// fails beyond it, leaving the retries to the bus.
+type Sink struct {
+	conn *nats.Conn
+	opts options
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewSink creates a sink publishing through conn. The connection is owned by
+// the caller: Close leaves it open.
+func NewSink(conn *nats.Conn, opts ...Option) *Sink {
+	return &Sink{
+		conn: conn,
+		opts: newOptions(opts),
+		done: make(chan struct{}),
+	}
+}
+
+// Send implements scela.Sink.
+func (s *Sink) Send(ctx context.Context, msg scela.Message) error {
+	select {
+	case <-s.done:
+		return scela.ErrBridgeClosed
+	default:
+	}
+
+	nm, err := encode(msg)
+	if err != nil {
+		return err
+	}
+	nm.Subject = s.opts.subject(msg.Topic())
+	if err := s.conn.PublishMsg(nm); err != nil {
+		return fmt.Errorf("failed to send message %s: %w", msg.ID(), err)
+	}
+	return nil
+}
+
+// Close implements scela.Sink.
+func (s *Sink) Close() error {
+	s.closeOnce.Do(func() { close(s.done) })
+	return nil
+}
+
+// Source is a scela.Source receiving the messages published to NATS on the
+// subjects matching its patterns. Messages of a subject are delivered in the
+// order they were published; a nacked message is delivered again before the
+// following ones.
+type Source struct {
+	opts options
+	subs []*nats.Subscription
+
+	mu     sync.Mutex
+	queued []*nats.Msg
+	// ready is signalled when a message is queued.
+	ready chan struct{}
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewSource creates a source subscribing through conn to the subjects
+// matching the bus topic patterns. The connection is owned by the caller:
+// Close leaves it open.
+func NewSource(conn *nats.Conn, patterns []string, opts ...Option) (*Source, error) {
+	s := &Source{
+		opts:  newOptions(opts),
+		ready: make(chan struct{}, 1),
+		done:  make(chan struct{}),
+	}
+
+	for _, pattern := range patterns {
+		subjects, err := s.opts.subjects(pattern)
+		if err != nil {
+			s.unsubscribe()
+			return nil, err
+		}
+		for _, subject := range subjects {
+			sub, err := conn.QueueSubscribe(subject, s.opts.queueGroup, s.enqueue)
+			if err != nil {
+				s.unsubscribe()
+				return nil, fmt.Errorf("failed to subscribe to %s: %w", subject, err)
+			}
+			s.subs = append(s.subs, sub)
+		}
+	}
+	return s, nil
+}
+
+// enqueue queues a message received from NATS.
+func (s *Source) enqueue(nm *nats.Msg) {
+	s.mu.Lock()
+	s.queued = append(s.queued, nm)
+	s.mu.Unlock()
+
+	select {
+	case s.ready <- struct{}{}:
+	default:
+	}
+}
+
+// Receive implements scela.Source.
+func (s *Source) Receive(ctx context.Context) (scela.Delivery, error) {
+	for {
+		select {
+		case <-s.done:
+			return nil, scela.ErrBridgeClosed
+		default:
+		}
+
+		if nm, ok := s.next(); ok {
+			msg, err := decode(nm, s.opts)
+			if err != nil {
+				return nil, err
+			}
+			return &delivery{source: s, nm: nm, msg: msg}, nil
+		}
+
+		select {
+		case <-s.ready:
+		case <-s.done:
+			return nil, scela.ErrBridgeClosed
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// next pops the oldest queued message.
+func (s *Source) next() (*nats.Msg, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.queued) == 0 {
+		return nil, false
+	}
+	nm := s.queued[0]
+	s.queued = s.queued[1:]
+	return nm, true
+}
+
+// Close implements scela.Source.
+func (s *Source) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.done)
+		s.unsubscribe()
+	})
+	return nil
+}
+
+// unsubscribe removes the subscriptions of the source.
+func (s *Source) unsubscribe() {
+	for _, sub := range s.subs {
+		_ = sub.Unsubscribe()
+	}
+	s.subs = nil
+}
+
+// delivery is a message received by a Source.
+type delivery struct {
+	source *Source
+	nm     *nats.Msg
+	msg    scela.Message
+}
+
+// Message implements scela.Delivery.
+func (d *delivery) Message() scela.Message {
+	return d.msg
+}
+
+// Ack implements scela.Delivery. Core NATS has no acknowledgements, so it
+// only lets the message go.
+func (d *delivery) Ack() error {
+	return nil
+}
+
+// Nack implements scela.Delivery. The message is delivered again before the
+// messages queued after it.
+func (d *delivery) Nack() error {
+	s := d.source
+	s.mu.Lock()
+	s.queued = append([]*nats.Msg{d.nm}, s.queued...)
+	s.mu.Unlock()
+
+	select {
+	case s.ready <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// encode returns the NATS message of msg, without a subject.
+func encode(msg scela.Message) (*nats.Msg, error) {
+	data, err := json.Marshal(msg.Payload())
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode payload of message %s: %w", msg.ID(), err)
+	}
+
+	nm := &nats.Msg{Data: data, Header: nats.Header{}}
+	nm.Header.Set(HeaderID, msg.ID())
+	nm.Header.Set(HeaderTopic, msg.Topic())
+	nm.Header.Set(HeaderTimestamp, msg.Timestamp().Format(time.RFC3339Nano))
+	nm.Header.Set(HeaderPriority, strconv.Itoa(int(scela.MessagePriority(msg))))
+
+	others := make(map[string]interface{})
+	for k, v := range msg.Metadata() {
+		if s, ok := v.(string); ok {
+			nm.Header.Set(k, s)
+		} else {
+			others[k] = v
+		}
+	}
+	if len(others) > 0 {
+		data, err := json.Marshal(others)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode metadata of message %s: %w", msg.ID(), err)
+		}
+		nm.Header.Set(HeaderMetadata, string(data))
+	}
+	return nm, nil
+}
+
+// decode returns the scela message of nm. Messages published outside scela
+// get a new ID and the time they were received, and their data is the
+// payload if it is JSON, or a string otherwise.
+func decode(nm *nats.Msg, opts options) (scela.Message, error) {
+	wm := struct {
+		ID        string                 `json:"id"`
+		Topic     string                 `json:"topic"`
+		Payload   json.RawMessage        `json:"payload,omitempty"`
+		Metadata  map[string]interface{} `json:"metadata"`
+		Timestamp time.Time              `json:"timestamp"`
+		Priority  int                    `json:"priority,omitempty"`
+	}{
+		Topic:     opts.topic(nm.Subject),
+		Metadata:  make(map[string]interface{}),
+		Timestamp: time.Now(),
+	}
+
+	for k, values := range nm.Header {
+		if len(values) == 0 {
+			continue
+		}
+		v := values[0]
+		switch k {
+		case HeaderID:
+			wm.ID = v
+		case HeaderTopic:
+			wm.Topic = v
+		case HeaderTimestamp:
+			if ts, err := time.Parse(time.RFC3339Nano, v); err == nil {
+				wm.Timestamp = ts
+			}
+		case HeaderPriority:
+			wm.Priority, _ = strconv.Atoi(v)
+		case HeaderMetadata:
+		default:
+			wm.Metadata[k] = v
+		}
+	}
+	if v := nm.Header.Get(HeaderMetadata); v != "" {
+		if err := json.Unmarshal([]byte(v), &wm.Metadata); err != nil {
+			return nil, fmt.Errorf("invalid %s header on %s: %w", HeaderMetadata, nm.Subject, err)
+		}
+	}
+
+	if json.Valid(nm.Data) {
+		wm.Payload = nm.Data
+	} else {
+		wm.Payload, _ = json.Marshal(string(nm.Data))
+	}
+	if wm.ID == "" {
+		wm.ID = nuid.Next()
+	}
+
+	data, err := json.Marshal(wm)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode message on %s: %w", nm.Subject, err)
+	}
+	return scela.UnmarshalMessage(data)
+}
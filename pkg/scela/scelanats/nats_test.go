@@ -0,0 +1,228 @@
+package scelanats
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	"github.com/toutaio/toutago-scela-bus/pkg/scela"
+	"github.com/toutaio/toutago-scela-bus/pkg/scela/scelatest"
+)
+
+// runServer starts an embedded NATS server on port, or on a random port if
+// it is -1.
+func runServer(t *testing.T, port int) *server.Server {
+	t.Helper()
+
+	srv, err := server.NewServer(&server.Options{Host: "127.0.0.1", Port: port, NoLog: true, NoSigs: true})
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+	go srv.Start()
+	if !srv.ReadyForConnections(5 * time.Second) {
+		t.Fatal("NATS server did not start")
+	}
+	t.Cleanup(srv.Shutdown)
+	return srv
+}
+
+// connect connects to srv, reconnecting quickly.
+func connect(t *testing.T, srv *server.Server) *nats.Conn {
+	t.Helper()
+
+	conn, err := Connect(srv.ClientURL(), nats.ReconnectWait(10*time.Millisecond))
+	if err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	t.Cleanup(conn.Close)
+	return conn
+}
+
+func TestBridgeConformance(t *testing.T) {
+	srv := runServer(t, -1)
+
+	scelatest.TestBridge(t, func(t *testing.T) scelatest.BridgeFixture {
+		conn := connect(t, srv)
+		prefix := uniquePrefix()
+		source, err := NewSource(conn, []string{"conformance.#"}, WithSubjectPrefix(prefix))
+		if err != nil {
+			t.Fatalf("NewSource: %v", err)
+		}
+		if err := conn.Flush(); err != nil {
+			t.Fatalf("Flush: %v", err)
+		}
+		return scelatest.BridgeFixture{
+			Sink:   NewSink(conn, WithSubjectPrefix(prefix)),
+			Source: source,
+		}
+	})
+}
+
+// uniquePrefix returns a subject prefix unique to a test.
+func uniquePrefix() string {
+	return fmt.Sprintf("test%d", prefixes.Add(1))
+}
+
+var prefixes atomic.Int64
+
+func TestSubjects(t *testing.T) {
+	opts := newOptions([]Option{WithSubjectPrefix("shop.")})
+	tests := []struct {
+		pattern string
+		want    []string
+	}{
+		{"orders.created", []string{"shop.orders.created"}},
+		{"orders.*", []string{"shop.orders.*"}},
+		{"orders.>", []string{"shop.orders.>"}},
+		{"orders.#", []string{"shop.orders.>", "shop.orders"}},
+		{"#", []string{"shop.>"}},
+		{"*", []string{"shop.>"}},
+	}
+	for _, tt := range tests {
+		got, err := opts.subjects(tt.pattern)
+		if err != nil {
+			t.Errorf("subjects(%q): %v", tt.pattern, err)
+			continue
+		}
+		if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("subjects(%q) = %v, want %v", tt.pattern, got, tt.want)
+		}
+	}
+
+	if _, err := opts.subjects("orders.#.created"); err == nil {
+		t.Error("expected an error for a # before the last segment")
+	}
+	if got := opts.topic("shop.orders.created"); got != "orders.created" {
+		t.Errorf("topic = %q, want %q", got, "orders.created")
+	}
+}
+
+func TestSource_ForeignMessages(t *testing.T) {
+	srv := runServer(t, -1)
+	conn := connect(t, srv)
+
+	source, err := NewSource(conn, []string{"orders.*"}, WithSubjectPrefix("shop"))
+	if err != nil {
+		t.Fatalf("NewSource: %v", err)
+	}
+	defer source.Close()
+	_ = conn.Flush()
+
+	nm := nats.NewMsg("shop.orders.created")
+	nm.Data = []byte("not json")
+	nm.Header.Set("tenant", "acme")
+	if err := conn.PublishMsg(nm); err != nil {
+		t.Fatalf("PublishMsg: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	d, err := source.Receive(ctx)
+	if err != nil {
+		t.Fatalf("Receive: %v", err)
+	}
+	msg := d.Message()
+	if msg.Topic() != "orders.created" || msg.Payload() != "not json" || msg.ID() == "" {
+		t.Errorf("unexpected message: topic %q payload %v ID %q", msg.Topic(), msg.Payload(), msg.ID())
+	}
+	if msg.Metadata()["tenant"] != "acme" {
+		t.Errorf("expected the header as metadata, got %v", msg.Metadata())
+	}
+}
+
+func TestBridge_MirrorsBuses(t *testing.T) {
+	srv := runServer(t, -1)
+
+	busA, busB := scela.New(), scela.New()
+	defer busA.Close()
+	defer busB.Close()
+
+	receivedA := make(chan scela.Message, 10)
+	receivedB := make(chan scela.Message, 10)
+	_, _ = busA.Subscribe("orders.#", scela.HandlerFunc(func(ctx context.Context, msg scela.Message) error {
+		receivedA <- msg
+		return nil
+	}))
+	_, _ = busB.Subscribe("orders.#", scela.HandlerFunc(func(ctx context.Context, msg scela.Message) error {
+		receivedB <- msg
+		return nil
+	}))
+
+	for _, b := range []scela.Bus{busA, busB} {
+		conn := connect(t, srv)
+		bridge, err := NewBridge(conn, []string{"orders.#"}, WithSubjectPrefix("shop"))
+		if err != nil {
+			t.Fatalf("NewBridge: %v", err)
+		}
+		if err := bridge.Attach(context.Background(), b); err != nil {
+			t.Fatalf("Attach: %v", err)
+		}
+		t.Cleanup(func() { _ = bridge.Close() })
+		_ = conn.Flush()
+	}
+
+	_ = busA.Publish(context.Background(), "orders.created", "o-1")
+
+	for _, received := range []chan scela.Message{receivedA, receivedB} {
+		select {
+		case got := <-received:
+			if got.Payload() != "o-1" {
+				t.Errorf("payload = %v, want o-1", got.Payload())
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("message not received")
+		}
+	}
+
+	// Neither bus may see the message again through the server
+	time.Sleep(200 * time.Millisecond)
+	if len(receivedA)+len(receivedB) != 0 {
+		t.Errorf("expected no echoes, got %d more messages", len(receivedA)+len(receivedB))
+	}
+}
+
+func TestBridge_Reconnects(t *testing.T) {
+	srv := runServer(t, -1)
+	port := srv.Addr().(*net.TCPAddr).Port
+
+	conn := connect(t, srv)
+	source, err := NewSource(conn, []string{"orders.*"})
+	if err != nil {
+		t.Fatalf("NewSource: %v", err)
+	}
+	defer source.Close()
+	sink := NewSink(conn)
+	defer sink.Close()
+
+	srv.Shutdown()
+	srv.WaitForShutdown()
+	runServer(t, port)
+
+	// The client resubscribes the source once reconnected
+	deadline := time.Now().Add(5 * time.Second)
+	for !conn.IsConnected() {
+		if time.Now().After(deadline) {
+			t.Fatal("client did not reconnect")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	_ = conn.Flush()
+
+	if err := sink.Send(context.Background(), scela.NewMessage("orders.created", "after")); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	d, err := source.Receive(ctx)
+	if err != nil {
+		t.Fatalf("Receive after reconnect: %v", err)
+	}
+	if d.Message().Payload() != "after" {
+		t.Errorf("payload = %v, want after", d.Message().Payload())
+	}
+}
@@ -0,0 +1,262 @@
+package scelaotel
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/toutaio/toutago-scela-bus/pkg/scela"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// WithMeterProvider sets the meter provider of a MetricsObserver. It
+// defaults to the global provider.
+func WithMeterProvider(provider metric.MeterProvider) Option {
+	return func(c *config) {
+		c.meterProvider = provider
+	}
+}
+
+// WithDurationBuckets sets the bucket boundaries of the handler duration
+// histogram, in seconds. They default to those of the SDK.
+func WithDurationBuckets(buckets []float64) Option {
+	return func(c *config) {
+		c.buckets = buckets
+	}
+}
+
+// WithTopicAttribute maps topics to the value of the topic attribute of
+// metrics, for example to group topics carrying IDs and keep the number of
+// series bounded. It defaults to DefaultTopicAttribute.
+func WithTopicAttribute(fn func(topic string) string) Option {
+	return func(c *config) {
+		c.topicAttribute = fn
+	}
+}
+
+// DefaultTopicAttribute groups reply topics created by scela.Request as
+// "_reply" and uses other topics as is.
+func DefaultTopicAttribute(topic string) string {
+	if scela.IsReplyTopic(topic) {
+		return "_reply"
+	}
+	return topic
+}
+
+// topicKey is the attribute carrying the topic of a metric, as on spans.
+const topicKey = attribute.Key("messaging.destination.name")
+
+// MetricsObserver is a scela.Observer recording bus activity with the
+// OpenTelemetry metrics API.
+type MetricsObserver struct {
+	meter          metric.Meter
+	topicAttribute func(string) string
+
+	published       metric.Int64Counter
+	delivered       metric.Int64Counter
+	failures        metric.Int64Counter
+	retries         metric.Int64Counter
+	deadLetters     metric.Int64Counter
+	storeErrors     metric.Int64Counter
+	handlerDuration metric.Float64Histogram
+	queueDepth      metric.Int64Histogram
+
+	// bus is the bus attached with Attach, used to sample the queue depth
+	bus atomic.Value
+}
+
+// NewMetricsObserver creates a MetricsObserver and its instruments.
+func NewMetricsObserver(opts ...Option) (*MetricsObserver, error) {
+	c := newConfig(opts)
+	o := &MetricsObserver{
+		meter:          c.meterProvider.Meter(ScopeName),
+		topicAttribute: c.topicAttribute,
+	}
+
+	counters := []struct {
+		counter     *metric.Int64Counter
+		name        string
+		description string
+		unit        string
+	}{
+		{&o.published, "scela.messages.published", "Messages published on the bus.", "{message}"},
+		{&o.delivered, "scela.messages.delivered", "Message deliveries whose handlers all succeeded.", "{message}"},
+		{&o.failures, "scela.messages.failed", "Message deliveries that failed.", "{message}"},
+		{&o.retries, "scela.messages.retried", "Failed deliveries scheduled for another attempt.", "{message}"},
+		{&o.deadLetters, "scela.messages.dead_lettered", "Messages dead-lettered after exhausting their retries.", "{message}"},
+		{&o.storeErrors, "scela.store.errors", "Failed persistence operations.", "{error}"},
+	}
+	for _, ct := range counters {
+		counter, err := o.meter.Int64Counter(ct.name, metric.WithDescription(ct.description), metric.WithUnit(ct.unit))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create metric %s: %w", ct.name, err)
+		}
+		*ct.counter = counter
+	}
+
+	durationOpts := []metric.Float64HistogramOption{
+		metric.WithDescription("Time spent handling a message, recorded by MetricsObserver.Middleware."),
+		metric.WithUnit("s"),
+	}
+	if c.buckets != nil {
+		durationOpts = append(durationOpts, metric.WithExplicitBucketBoundaries(c.buckets...))
+	}
+	var err error
+	if o.handlerDuration, err = o.meter.Float64Histogram("scela.handler.duration", durationOpts...); err != nil {
+		return nil, fmt.Errorf("failed to create metric scela.handler.duration: %w", err)
+	}
+
+	o.queueDepth, err = o.meter.Int64Histogram("scela.queue.depth",
+		metric.WithDescription("Async queue depth sampled at each publish, once attached to a bus."),
+		metric.WithUnit("{message}"),
+		metric.WithExplicitBucketBoundaries(0, 1, 5, 10, 50, 100, 250, 500, 1000, 5000),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create metric scela.queue.depth: %w", err)
+	}
+	return o, nil
+}
+
+// Attach registers gauges for the state of b (queue length and capacity,
+// subscriptions and, with scela.WithLatencyTracking, end-to-end latency
+// percentiles per topic) and starts sampling the queue depth at each
+// publish. b must report scela.Stats.
+func (o *MetricsObserver) Attach(b scela.Bus) error {
+	if _, ok := scela.StatsOf(b); !ok {
+		return fmt.Errorf("bus %T does not report stats", b)
+	}
+
+	queue, err := o.meter.Int64ObservableGauge("scela.queue.length",
+		metric.WithDescription("Messages waiting in the async queue."), metric.WithUnit("{message}"))
+	if err != nil {
+		return fmt.Errorf("failed to create bus metrics: %w", err)
+	}
+	capacity, err := o.meter.Int64ObservableGauge("scela.queue.capacity",
+		metric.WithDescription("Capacity of the async queue."), metric.WithUnit("{message}"))
+	if err != nil {
+		return fmt.Errorf("failed to create bus metrics: %w", err)
+	}
+	subs, err := o.meter.Int64ObservableGauge("scela.subscriptions",
+		metric.WithDescription("Registered subscriptions."), metric.WithUnit("{subscription}"))
+	if err != nil {
+		return fmt.Errorf("failed to create bus metrics: %w", err)
+	}
+	latency, err := o.meter.Float64ObservableGauge("scela.latency",
+		metric.WithDescription("End-to-end latency percentiles from publish until handlers finish."), metric.WithUnit("s"))
+	if err != nil {
+		return fmt.Errorf("failed to create bus metrics: %w", err)
+	}
+
+	_, err = o.meter.RegisterCallback(func(ctx context.Context, obs metric.Observer) error {
+		stats, ok := scela.StatsOf(b)
+		if !ok {
+			return nil
+		}
+		obs.ObserveInt64(queue, int64(stats.QueueDepth))
+		obs.ObserveInt64(capacity, int64(stats.QueueCapacity))
+		obs.ObserveInt64(subs, int64(stats.Subscriptions))
+
+		// Topics mapped to the same attribute are merged by keeping the
+		// busiest one
+		merged := make(map[string]scela.LatencySnapshot, len(stats.Topics))
+		for topic, snap := range stats.Topics {
+			value := o.topicAttribute(topic)
+			if prev, ok := merged[value]; !ok || snap.Count > prev.Count {
+				merged[value] = snap
+			}
+		}
+		for value, snap := range merged {
+			for _, q := range []struct {
+				quantile string
+				d        time.Duration
+			}{{"0.5", snap.P50}, {"0.9", snap.P90}, {"0.99", snap.P99}, {"0.999", snap.P999}} {
+				obs.ObserveFloat64(latency, q.d.Seconds(), metric.WithAttributes(
+					topicKey.String(value), attribute.String("quantile", q.quantile)))
+			}
+		}
+		return nil
+	}, queue, capacity, subs, latency)
+	if err != nil {
+		return fmt.Errorf("failed to register bus metrics: %w", err)
+	}
+
+	o.bus.Store(&b)
+	return nil
+}
+
+// Middleware returns a middleware recording handler durations.
+func (o *MetricsObserver) Middleware() scela.Middleware {
+	return func(next scela.Handler) scela.Handler {
+		return scela.HandlerFunc(func(ctx context.Context, msg scela.Message) error {
+			start := time.Now()
+			err := next.Handle(ctx, msg)
+			o.handlerDuration.Record(ctx, time.Since(start).Seconds(), o.topic(msg.Topic()))
+			return err
+		})
+	}
+}
+
+// topic returns the option setting the topic attribute of a measurement.
+func (o *MetricsObserver) topic(topic string) metric.MeasurementOption {
+	return metric.WithAttributes(topicKey.String(o.topicAttribute(topic)))
+}
+
+// OnPublish implements scela.Observer.
+func (o *MetricsObserver) OnPublish(ctx context.Context, topic string, msg scela.Message) {
+	o.published.Add(ctx, 1, o.topic(topic))
+	o.sampleQueue(ctx)
+}
+
+// OnPublishBatch implements scela.BatchObserver.
+func (o *MetricsObserver) OnPublishBatch(ctx context.Context, msgs []scela.Message) {
+	for _, msg := range msgs {
+		o.published.Add(ctx, 1, o.topic(msg.Topic()))
+	}
+	o.sampleQueue(ctx)
+}
+
+// OnSubscribe implements scela.Observer.
+func (o *MetricsObserver) OnSubscribe(pattern string) {}
+
+// OnUnsubscribe implements scela.Observer.
+func (o *MetricsObserver) OnUnsubscribe(pattern string) {}
+
+// OnMessageProcessed implements scela.Observer.
+func (o *MetricsObserver) OnMessageProcessed(ctx context.Context, msg scela.Message, err error) {
+	if err != nil {
+		o.failures.Add(ctx, 1, o.topic(msg.Topic()))
+		return
+	}
+	o.delivered.Add(ctx, 1, o.topic(msg.Topic()))
+}
+
+// OnRetry implements scela.RetryObserver.
+func (o *MetricsObserver) OnRetry(ctx context.Context, msg scela.Message, attempt int, err error) {
+	o.retries.Add(ctx, 1, o.topic(msg.Topic()))
+}
+
+// OnDeadLetter implements scela.RetryObserver.
+func (o *MetricsObserver) OnDeadLetter(ctx context.Context, msg scela.Message, err error) {
+	o.deadLetters.Add(ctx, 1, o.topic(msg.Topic()))
+}
+
+// OnStoreError implements scela.StoreErrorObserver.
+func (o *MetricsObserver) OnStoreError(ctx context.Context, err *scela.StoreError) {
+	o.storeErrors.Add(ctx, 1, metric.WithAttributes(attribute.String("operation", err.Op)))
+}
+
+// OnClose implements scela.Observer.
+func (o *MetricsObserver) OnClose() {}
+
+// sampleQueue records the queue depth of the attached bus.
+func (o *MetricsObserver) sampleQueue(ctx context.Context) {
+	b, ok := o.bus.Load().(*scela.Bus)
+	if !ok {
+		return
+	}
+	if stats, ok := scela.StatsOf(*b); ok {
+		o.queueDepth.Record(ctx, int64(stats.QueueDepth))
+	}
+}
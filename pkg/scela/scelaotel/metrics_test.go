@@ -0,0 +1,148 @@
+package scelaotel
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/toutaio/toutago-scela-bus/pkg/scela"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+func newTestMetrics(t *testing.T, opts ...Option) (*MetricsObserver, *sdkmetric.ManualReader) {
+	t.Helper()
+
+	reader := sdkmetric.NewManualReader()
+	provider := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+	obs, err := NewMetricsObserver(append(opts, WithMeterProvider(provider))...)
+	if err != nil {
+		t.Fatalf("NewMetricsObserver: %v", err)
+	}
+	return obs, reader
+}
+
+// collect returns the metrics read by reader, by name.
+func collect(t *testing.T, reader *sdkmetric.ManualReader) map[string]metricdata.Aggregation {
+	t.Helper()
+
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatalf("Collect: %v", err)
+	}
+	metrics := make(map[string]metricdata.Aggregation)
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			metrics[m.Name] = m.Data
+		}
+	}
+	return metrics
+}
+
+// counts returns the values of a counter by topic.
+func counts(data metricdata.Aggregation) map[string]int64 {
+	values := make(map[string]int64)
+	sum, _ := data.(metricdata.Sum[int64])
+	for _, dp := range sum.DataPoints {
+		topic, _ := dp.Attributes.Value(topicKey)
+		values[topic.AsString()] = dp.Value
+	}
+	return values
+}
+
+func TestMetricsObserver_Counters(t *testing.T) {
+	obs, reader := newTestMetrics(t)
+
+	bus := scela.New(scela.WithObserver(obs), scela.WithMaxRetries(2))
+	defer bus.Close()
+	bus.Use(obs.Middleware())
+
+	_, _ = bus.Subscribe("order.created", scela.HandlerFunc(func(ctx context.Context, msg scela.Message) error {
+		return nil
+	}))
+	_, _ = bus.Subscribe("order.failed", scela.HandlerFunc(func(ctx context.Context, msg scela.Message) error {
+		return errors.New("boom")
+	}))
+
+	_ = bus.PublishSync(context.Background(), "order.created", nil)
+	_ = bus.Publish(context.Background(), "order.failed", nil)
+
+	var metrics map[string]metricdata.Aggregation
+	waitFor(t, func() bool {
+		metrics = collect(t, reader)
+		return counts(metrics["scela.messages.dead_lettered"])["order.failed"] == 1
+	})
+
+	checks := map[string]int64{
+		"published created": counts(metrics["scela.messages.published"])["order.created"],
+		"delivered created": counts(metrics["scela.messages.delivered"])["order.created"],
+		"failures failed":   counts(metrics["scela.messages.failed"])["order.failed"],
+		"retries failed":    counts(metrics["scela.messages.retried"])["order.failed"],
+	}
+	want := map[string]int64{
+		"published created": 1,
+		"delivered created": 1,
+		"failures failed":   2,
+		"retries failed":    1,
+	}
+	for name, got := range checks {
+		if got != want[name] {
+			t.Errorf("%s = %v, want %v", name, got, want[name])
+		}
+	}
+
+	durations, _ := metrics["scela.handler.duration"].(metricdata.Histogram[float64])
+	if len(durations.DataPoints) != 2 {
+		t.Errorf("expected handler durations for 2 topics, got %d", len(durations.DataPoints))
+	}
+}
+
+func TestMetricsObserver_Attach(t *testing.T) {
+	obs, reader := newTestMetrics(t, WithTopicAttribute(func(topic string) string { return "orders" }))
+
+	bus := scela.New(scela.WithObserver(obs), scela.WithLatencyTracking(0))
+	defer bus.Close()
+	if err := obs.Attach(bus); err != nil {
+		t.Fatalf("Attach: %v", err)
+	}
+
+	_, _ = bus.Subscribe("order.created", scela.HandlerFunc(func(ctx context.Context, msg scela.Message) error {
+		return nil
+	}))
+	_ = bus.PublishSync(context.Background(), "order.created", nil)
+
+	metrics := collect(t, reader)
+	subs, _ := metrics["scela.subscriptions"].(metricdata.Gauge[int64])
+	if len(subs.DataPoints) != 1 || subs.DataPoints[0].Value != 1 {
+		t.Errorf("expected 1 subscription, got %+v", subs.DataPoints)
+	}
+	for _, name := range []string{"scela.latency", "scela.queue.depth", "scela.queue.length", "scela.queue.capacity"} {
+		if _, ok := metrics[name]; !ok {
+			t.Errorf("expected metric %s", name)
+		}
+	}
+	if got := counts(metrics["scela.messages.published"]); got["orders"] != 1 {
+		t.Errorf("expected the topic attribute mapped, got %v", got)
+	}
+
+	if err := obs.Attach(&statslessBus{bus}); err == nil {
+		t.Error("expected a bus without stats rejected")
+	}
+}
+
+// statslessBus hides the stats of a bus.
+type statslessBus struct {
+	scela.Bus
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met in time")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
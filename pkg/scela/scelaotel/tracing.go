@@ -0,0 +1,153 @@
+// Package scelaotel instruments scela buses with OpenTelemetry.
+//
+// Publishers record a producer span and inject its context
+// into the message metadata; handlers run in a consumer span continuing the
+// publisher's trace:
+//
+//	bus := scela.New(scela.WithPublishInterceptor(scelaotel.PublishInterceptor()))
+//	bus.Use(scelaotel.Middleware())
+//
+// A MetricsObserver exports the activity and state of the bus with the
+// metrics API, so that both signals go through the same collector:
+//
+//	obs, err := scelaotel.NewMetricsObserver()
+//	bus := scela.New(scela.WithObserver(obs), scela.WithLatencyTracking(0))
+//	bus.Use(obs.Middleware())
+//	err = obs.Attach(bus)
+package scelaotel
+
+import (
+	"context"
+
+	"github.com/toutaio/toutago-scela-bus/pkg/scela"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ScopeName is the instrumentation scope name of the tracer and meter.
+const ScopeName = "github.com/toutaio/toutago-scela-bus/pkg/scela/scelaotel"
+
+// Option configures the instrumentation.
+type Option func(*config)
+
+type config struct {
+	provider       trace.TracerProvider
+	propagator     propagation.TextMapPropagator
+	meterProvider  metric.MeterProvider
+	buckets        []float64
+	topicAttribute func(topic string) string
+}
+
+// WithTracerProvider sets the tracer provider. It defaults to the global
+// provider.
+func WithTracerProvider(provider trace.TracerProvider) Option {
+	return func(c *config) {
+		c.provider = provider
+	}
+}
+
+// WithPropagator sets the propagator used to carry trace context in message
+// metadata. It defaults to the global propagator.
+func WithPropagator(propagator propagation.TextMapPropagator) Option {
+	return func(c *config) {
+		c.propagator = propagator
+	}
+}
+
+// newConfig applies opts over the defaults.
+func newConfig(opts []Option) config {
+	c := config{
+		provider:       otel.GetTracerProvider(),
+		propagator:     otel.GetTextMapPropagator(),
+		meterProvider:  otel.GetMeterProvider(),
+		topicAttribute: DefaultTopicAttribute,
+	}
+	for _, opt := range opts {
+		opt(&c)
+	}
+	return c
+}
+
+// PublishInterceptor returns an interceptor, for scela.WithPublishInterceptor,
+// that records a producer span for every published message and injects its
+// trace context into the message metadata.
+func PublishInterceptor(opts ...Option) scela.PublishInterceptor {
+	c := newConfig(opts)
+	tracer := c.provider.Tracer(ScopeName)
+
+	return func(ctx context.Context, msg scela.Message) {
+		ctx, span := tracer.Start(ctx, msg.Topic()+" publish",
+			trace.WithSpanKind(trace.SpanKindProducer),
+			trace.WithAttributes(messageAttributes(msg, "publish")...),
+		)
+		defer span.End()
+
+		c.propagator.Inject(ctx, MetadataCarrier(msg.Metadata()))
+	}
+}
+
+// Middleware returns a middleware running each delivery in a consumer span.
+// The span continues the trace found in the message metadata, if any, and
+// records the error returned by the handlers.
+func Middleware(opts ...Option) scela.Middleware {
+	c := newConfig(opts)
+	tracer := c.provider.Tracer(ScopeName)
+
+	return func(next scela.Handler) scela.Handler {
+		return scela.HandlerFunc(func(ctx context.Context, msg scela.Message) error {
+			ctx = c.propagator.Extract(ctx, MetadataCarrier(msg.Metadata()))
+			ctx, span := tracer.Start(ctx, msg.Topic()+" process",
+				trace.WithSpanKind(trace.SpanKindConsumer),
+				trace.WithAttributes(messageAttributes(msg, "process")...),
+			)
+			defer span.End()
+
+			err := next.Handle(ctx, msg)
+			if err != nil {
+				span.RecordError(err)
+				span.SetStatus(codes.Error, err.Error())
+			}
+			return err
+		})
+	}
+}
+
+// messageAttributes returns the messaging attributes describing msg.
+func messageAttributes(msg scela.Message, operation string) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attribute.String("messaging.system", "scela"),
+		attribute.String("messaging.operation", operation),
+		attribute.String("messaging.destination.name", msg.Topic()),
+		attribute.String("messaging.message.id", msg.ID()),
+	}
+}
+
+// MetadataCarrier adapts message metadata to a propagation.TextMapCarrier.
+// Only string values are read.
+type MetadataCarrier map[string]interface{}
+
+// Get implements propagation.TextMapCarrier.
+func (c MetadataCarrier) Get(key string) string {
+	v, _ := c[key].(string)
+	return v
+}
+
+// Set implements propagation.TextMapCarrier.
+func (c MetadataCarrier) Set(key, value string) {
+	c[key] = value
+}
+
+// Keys implements propagation.TextMapCarrier.
+func (c MetadataCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for k, v := range c {
+		if _, ok := v.(string); ok {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
@@ -0,0 +1,126 @@
+package scelaotel
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/toutaio/toutago-scela-bus/pkg/scela"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func newTestTracing() (*tracetest.SpanRecorder, []Option) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	return recorder, []Option{
+		WithTracerProvider(provider),
+		WithPropagator(propagation.TraceContext{}),
+	}
+}
+
+func TestTracing_ContinuesPublisherTrace(t *testing.T) {
+	recorder, opts := newTestTracing()
+
+	bus := scela.New(scela.WithPublishInterceptor(PublishInterceptor(opts...)))
+	defer bus.Close()
+	bus.Use(Middleware(opts...))
+
+	done := make(chan trace.SpanContext, 1)
+	_, _ = bus.Subscribe("order.created", scela.HandlerFunc(func(ctx context.Context, msg scela.Message) error {
+		done <- trace.SpanContextFromContext(ctx)
+		return nil
+	}))
+
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	ctx, root := provider.Tracer("test").Start(context.Background(), "request")
+	if err := bus.Publish(ctx, "order.created", nil); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	root.End()
+
+	var handlerSpan trace.SpanContext
+	select {
+	case handlerSpan = <-done:
+	case <-time.After(time.Second):
+		t.Fatal("handler was not called")
+	}
+	if handlerSpan.TraceID() != root.SpanContext().TraceID() {
+		t.Error("expected the handler to run in the publisher's trace")
+	}
+
+	// Wait for the consumer span to end
+	deadline := time.Now().Add(time.Second)
+	for len(recorder.Ended()) < 3 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	byName := map[string]sdktrace.ReadOnlySpan{}
+	for _, span := range recorder.Ended() {
+		byName[span.Name()] = span
+	}
+	publish, process := byName["order.created publish"], byName["order.created process"]
+	if publish == nil || process == nil {
+		t.Fatalf("expected publish and process spans, got %v", byName)
+	}
+	if publish.SpanKind() != trace.SpanKindProducer || process.SpanKind() != trace.SpanKindConsumer {
+		t.Error("unexpected span kinds")
+	}
+	if publish.Parent().SpanID() != root.SpanContext().SpanID() {
+		t.Error("expected the publish span to be a child of the request span")
+	}
+	if process.Parent().SpanID() != publish.SpanContext().SpanID() {
+		t.Error("expected the process span to be a child of the publish span")
+	}
+}
+
+func TestTracing_RecordsHandlerErrors(t *testing.T) {
+	recorder, opts := newTestTracing()
+
+	bus := scela.New()
+	defer bus.Close()
+	bus.Use(Middleware(opts...))
+
+	_, _ = bus.Subscribe("order.failed", scela.HandlerFunc(func(ctx context.Context, msg scela.Message) error {
+		return errors.New("boom")
+	}))
+	_ = bus.PublishSync(context.Background(), "order.failed", nil)
+
+	spans := recorder.Ended()
+	if len(spans) != 1 {
+		t.Fatalf("expected 1 span, got %d", len(spans))
+	}
+	if spans[0].Status().Code != codes.Error || len(spans[0].Events()) == 0 {
+		t.Errorf("expected the error to be recorded, got status %v", spans[0].Status())
+	}
+}
+
+func TestTracing_PersistedMessagesCarryTraceContext(t *testing.T) {
+	_, opts := newTestTracing()
+
+	store := scela.NewInMemoryStore(10)
+	bus := scela.NewPersistentBus(scela.New(scela.WithPublishInterceptor(PublishInterceptor(opts...))), store)
+	defer bus.Close()
+
+	_ = bus.Publish(context.Background(), "order.created", nil)
+
+	stored, _ := store.Load(context.Background())
+	if len(stored) != 1 || MetadataCarrier(stored[0].Metadata()).Get("traceparent") == "" {
+		t.Error("expected the stored message to carry a traceparent")
+	}
+}
+
+func TestMetadataCarrier(t *testing.T) {
+	c := MetadataCarrier{"traceparent": "00-abc", "count": 3}
+	if c.Get("traceparent") != "00-abc" || c.Get("count") != "" {
+		t.Error("unexpected Get results")
+	}
+	c.Set("tracestate", "k=v")
+	if keys := c.Keys(); len(keys) != 2 {
+		t.Errorf("expected only string keys, got %v", keys)
+	}
+}
@@ -0,0 +1,295 @@
+// Package scelaprom exports scela bus metrics to Prometheus.
+//
+// An Observer records the activity and queue depth of a bus as Prometheus
+// metrics:
+//
+//	obs, err := scelaprom.New(prometheus.DefaultRegisterer)
+//	bus := scela.New(scela.WithObserver(obs), scela.WithLatencyTracking(0))
+//	bus.Use(obs.Middleware())
+//	err = obs.Attach(bus)
+package scelaprom
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/toutaio/toutago-scela-bus/pkg/scela"
+)
+
+// Option configures an Observer.
+type Option func(*config)
+
+type config struct {
+	namespace  string
+	buckets    []float64
+	topicLabel func(topic string) string
+}
+
+// WithNamespace sets the metric namespace. It defaults to "scela".
+func WithNamespace(namespace string) Option {
+	return func(c *config) {
+		c.namespace = namespace
+	}
+}
+
+// WithDurationBuckets sets the buckets of the handler duration histogram,
+// in seconds. They default to prometheus.DefBuckets.
+func WithDurationBuckets(buckets []float64) Option {
+	return func(c *config) {
+		c.buckets = buckets
+	}
+}
+
+// WithTopicLabel maps topics to the value of the "topic" label, for example
+// to group topics carrying IDs and keep the number of series bounded. By
+// default reply topics created by scela.Request are grouped as "_reply" and
+// other topics are used as is.
+func WithTopicLabel(fn func(topic string) string) Option {
+	return func(c *config) {
+		c.topicLabel = fn
+	}
+}
+
+// DefaultTopicLabel is the default topic label mapping.
+func DefaultTopicLabel(topic string) string {
+	if scela.IsReplyTopic(topic) {
+		return "_reply"
+	}
+	return topic
+}
+
+// Observer is a scela.Observer recording bus activity as Prometheus metrics.
+type Observer struct {
+	reg        prometheus.Registerer
+	namespace  string
+	topicLabel func(string) string
+
+	published       *prometheus.CounterVec
+	delivered       *prometheus.CounterVec
+	failures        *prometheus.CounterVec
+	retries         *prometheus.CounterVec
+	deadLetters     *prometheus.CounterVec
+	storeErrors     *prometheus.CounterVec
+	handlerDuration *prometheus.HistogramVec
+	queueDepth      prometheus.Histogram
+
+	// bus is the bus attached with Attach, used to sample the queue depth
+	bus atomic.Value
+}
+
+// New creates an Observer and registers its metrics on reg.
+func New(reg prometheus.Registerer, opts ...Option) (*Observer, error) {
+	cfg := config{
+		namespace:  "scela",
+		buckets:    prometheus.DefBuckets,
+		topicLabel: DefaultTopicLabel,
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	topic := []string{"topic"}
+	o := &Observer{
+		reg:        reg,
+		namespace:  cfg.namespace,
+		topicLabel: cfg.topicLabel,
+		published: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: cfg.namespace,
+			Name:      "messages_published_total",
+			Help:      "Messages published on the bus.",
+		}, topic),
+		delivered: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: cfg.namespace,
+			Name:      "messages_delivered_total",
+			Help:      "Message deliveries whose handlers all succeeded.",
+		}, topic),
+		failures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: cfg.namespace,
+			Name:      "message_failures_total",
+			Help:      "Message deliveries that failed.",
+		}, topic),
+		retries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: cfg.namespace,
+			Name:      "message_retries_total",
+			Help:      "Failed deliveries scheduled for another attempt.",
+		}, topic),
+		deadLetters: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: cfg.namespace,
+			Name:      "dead_letters_total",
+			Help:      "Messages dead-lettered after exhausting their retries.",
+		}, topic),
+		storeErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: cfg.namespace,
+			Name:      "store_errors_total",
+			Help:      "Failed persistence operations.",
+		}, []string{"operation"}),
+		handlerDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: cfg.namespace,
+			Name:      "handler_duration_seconds",
+			Help:      "Time spent handling a message, recorded by Observer.Middleware.",
+			Buckets:   cfg.buckets,
+		}, topic),
+		queueDepth: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: cfg.namespace,
+			Name:      "queue_depth",
+			Help:      "Async queue depth sampled at each publish, once attached to a bus.",
+			Buckets:   []float64{0, 1, 5, 10, 50, 100, 250, 500, 1000, 5000},
+		}),
+	}
+
+	for _, c := range []prometheus.Collector{
+		o.published, o.delivered, o.failures, o.retries, o.deadLetters,
+		o.storeErrors, o.handlerDuration, o.queueDepth,
+	} {
+		if err := reg.Register(c); err != nil {
+			return nil, fmt.Errorf("failed to register metrics: %w", err)
+		}
+	}
+	return o, nil
+}
+
+// Attach registers gauges for the state of b (queue depth and capacity,
+// subscriptions and, with scela.WithLatencyTracking, end-to-end latency
+// percentiles per topic) and starts sampling the queue depth at each
+// publish. b must report scela.Stats.
+func (o *Observer) Attach(b scela.Bus) error {
+	if _, ok := scela.StatsOf(b); !ok {
+		return fmt.Errorf("bus %T does not report stats", b)
+	}
+	if err := o.reg.Register(&statsCollector{bus: b, observer: o}); err != nil {
+		return fmt.Errorf("failed to register bus metrics: %w", err)
+	}
+	o.bus.Store(&b)
+	return nil
+}
+
+// Middleware returns a middleware recording handler durations.
+func (o *Observer) Middleware() scela.Middleware {
+	return func(next scela.Handler) scela.Handler {
+		return scela.HandlerFunc(func(ctx context.Context, msg scela.Message) error {
+			start := time.Now()
+			err := next.Handle(ctx, msg)
+			o.handlerDuration.WithLabelValues(o.topicLabel(msg.Topic())).Observe(time.Since(start).Seconds())
+			return err
+		})
+	}
+}
+
+// OnPublish implements scela.Observer.
+func (o *Observer) OnPublish(ctx context.Context, topic string, msg scela.Message) {
+	o.published.WithLabelValues(o.topicLabel(topic)).Inc()
+	o.sampleQueue()
+}
+
+// OnPublishBatch implements scela.BatchObserver.
+func (o *Observer) OnPublishBatch(ctx context.Context, msgs []scela.Message) {
+	for _, msg := range msgs {
+		o.published.WithLabelValues(o.topicLabel(msg.Topic())).Inc()
+	}
+	o.sampleQueue()
+}
+
+// OnSubscribe implements scela.Observer.
+func (o *Observer) OnSubscribe(pattern string) {}
+
+// OnUnsubscribe implements scela.Observer.
+func (o *Observer) OnUnsubscribe(pattern string) {}
+
+// OnMessageProcessed implements scela.Observer.
+func (o *Observer) OnMessageProcessed(ctx context.Context, msg scela.Message, err error) {
+	if err != nil {
+		o.failures.WithLabelValues(o.topicLabel(msg.Topic())).Inc()
+		return
+	}
+	o.delivered.WithLabelValues(o.topicLabel(msg.Topic())).Inc()
+}
+
+// OnRetry implements scela.RetryObserver.
+func (o *Observer) OnRetry(ctx context.Context, msg scela.Message, attempt int, err error) {
+	o.retries.WithLabelValues(o.topicLabel(msg.Topic())).Inc()
+}
+
+// OnDeadLetter implements scela.RetryObserver.
+func (o *Observer) OnDeadLetter(ctx context.Context, msg scela.Message, err error) {
+	o.deadLetters.WithLabelValues(o.topicLabel(msg.Topic())).Inc()
+}
+
+// OnStoreError implements scela.StoreErrorObserver.
+func (o *Observer) OnStoreError(ctx context.Context, err *scela.StoreError) {
+	o.storeErrors.WithLabelValues(err.Op).Inc()
+}
+
+// OnClose implements scela.Observer.
+func (o *Observer) OnClose() {}
+
+// sampleQueue records the queue depth of the attached bus.
+func (o *Observer) sampleQueue() {
+	b, ok := o.bus.Load().(*scela.Bus)
+	if !ok {
+		return
+	}
+	if stats, ok := scela.StatsOf(*b); ok {
+		o.queueDepth.Observe(float64(stats.QueueDepth))
+	}
+}
+
+// statsCollector exposes the scela.Stats of a bus at scrape time.
+type statsCollector struct {
+	bus      scela.Bus
+	observer *Observer
+}
+
+func (c *statsCollector) desc(name, help string, labels ...string) *prometheus.Desc {
+	return prometheus.NewDesc(prometheus.BuildFQName(c.observer.namespace, "", name), help, labels, nil)
+}
+
+func (c *statsCollector) descs() (queue, capacity, subs, latency *prometheus.Desc) {
+	return c.desc("queue_length", "Messages waiting in the async queue."),
+		c.desc("queue_capacity", "Capacity of the async queue."),
+		c.desc("subscriptions", "Registered subscriptions."),
+		c.desc("latency_seconds", "End-to-end latency from publish until handlers finish.", "topic")
+}
+
+// Describe implements prometheus.Collector.
+func (c *statsCollector) Describe(ch chan<- *prometheus.Desc) {
+	queue, capacity, subs, latency := c.descs()
+	ch <- queue
+	ch <- capacity
+	ch <- subs
+	ch <- latency
+}
+
+// Collect implements prometheus.Collector.
+func (c *statsCollector) Collect(ch chan<- prometheus.Metric) {
+	stats, ok := scela.StatsOf(c.bus)
+	if !ok {
+		return
+	}
+
+	queue, capacity, subs, latency := c.descs()
+	ch <- prometheus.MustNewConstMetric(queue, prometheus.GaugeValue, float64(stats.QueueDepth))
+	ch <- prometheus.MustNewConstMetric(capacity, prometheus.GaugeValue, float64(stats.QueueCapacity))
+	ch <- prometheus.MustNewConstMetric(subs, prometheus.GaugeValue, float64(stats.Subscriptions))
+
+	// Topics mapped to the same label are merged by keeping the busiest one
+	merged := make(map[string]scela.LatencySnapshot, len(stats.Topics))
+	for topic, snap := range stats.Topics {
+		label := c.observer.topicLabel(topic)
+		if prev, ok := merged[label]; !ok || snap.Count > prev.Count {
+			merged[label] = snap
+		}
+	}
+	for label, snap := range merged {
+		ch <- prometheus.MustNewConstSummary(latency, snap.Count,
+			snap.Mean.Seconds()*float64(snap.Count),
+			map[float64]float64{
+				0.5:   snap.P50.Seconds(),
+				0.9:   snap.P90.Seconds(),
+				0.99:  snap.P99.Seconds(),
+				0.999: snap.P999.Seconds(),
+			}, label)
+	}
+}
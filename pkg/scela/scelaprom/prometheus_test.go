@@ -0,0 +1,151 @@
+package scelaprom
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/toutaio/toutago-scela-bus/pkg/scela"
+)
+
+func TestObserver_Counters(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	obs, err := New(reg)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	bus := scela.New(scela.WithObserver(obs), scela.WithMaxRetries(2))
+	defer bus.Close()
+	bus.Use(obs.Middleware())
+
+	_, _ = bus.Subscribe("order.created", scela.HandlerFunc(func(ctx context.Context, msg scela.Message) error {
+		return nil
+	}))
+	_, _ = bus.Subscribe("order.failed", scela.HandlerFunc(func(ctx context.Context, msg scela.Message) error {
+		return errors.New("boom")
+	}))
+
+	_ = bus.PublishSync(context.Background(), "order.created", nil)
+	_ = bus.Publish(context.Background(), "order.failed", nil)
+
+	waitFor(t, func() bool {
+		return testutil.ToFloat64(obs.deadLetters.WithLabelValues("order.failed")) == 1
+	})
+
+	checks := map[string]float64{
+		"published created": testutil.ToFloat64(obs.published.WithLabelValues("order.created")),
+		"delivered created": testutil.ToFloat64(obs.delivered.WithLabelValues("order.created")),
+		"failures failed":   testutil.ToFloat64(obs.failures.WithLabelValues("order.failed")),
+		"retries failed":    testutil.ToFloat64(obs.retries.WithLabelValues("order.failed")),
+	}
+	want := map[string]float64{
+		"published created": 1,
+		"delivered created": 1,
+		"failures failed":   2,
+		"retries failed":    1,
+	}
+	for name, got := range checks {
+		if got != want[name] {
+			t.Errorf("%s = %v, want %v", name, got, want[name])
+		}
+	}
+
+	if n := testutil.CollectAndCount(obs.handlerDuration); n != 2 {
+		t.Errorf("expected handler durations for 2 topics, got %d", n)
+	}
+}
+
+func TestObserver_Attach(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	obs, err := New(reg, WithNamespace("app"))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	bus := scela.New(scela.WithObserver(obs), scela.WithLatencyTracking(0))
+	defer bus.Close()
+	if err := obs.Attach(bus); err != nil {
+		t.Fatalf("Attach: %v", err)
+	}
+
+	_, _ = bus.Subscribe("order.created", scela.HandlerFunc(func(ctx context.Context, msg scela.Message) error {
+		return nil
+	}))
+	_ = bus.PublishSync(context.Background(), "order.created", nil)
+
+	expected := `
+# HELP app_subscriptions Registered subscriptions.
+# TYPE app_subscriptions gauge
+app_subscriptions 1
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "app_subscriptions"); err != nil {
+		t.Error(err)
+	}
+
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("Gather: %v", err)
+	}
+	found := map[string]bool{}
+	for _, f := range families {
+		found[f.GetName()] = true
+	}
+	for _, name := range []string{"app_latency_seconds", "app_queue_depth", "app_queue_length"} {
+		if !found[name] {
+			t.Errorf("expected metric %s", name)
+		}
+	}
+}
+
+func TestObserver_ReplyTopicsAreGrouped(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	obs, _ := New(reg)
+
+	bus := scela.New(scela.WithObserver(obs))
+	defer bus.Close()
+
+	_, _ = bus.Subscribe("echo", scela.HandlerFunc(func(ctx context.Context, msg scela.Message) error {
+		return scela.Respond(ctx, msg.Payload())
+	}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	for i := 0; i < 3; i++ {
+		if _, err := scela.Request(ctx, bus, "echo", i); err != nil {
+			t.Fatalf("Request: %v", err)
+		}
+	}
+
+	if got := testutil.ToFloat64(obs.published.WithLabelValues("_reply")); got != 3 {
+		t.Errorf("expected 3 replies under one label, got %v", got)
+	}
+	if n := testutil.CollectAndCount(obs.published); n != 2 {
+		t.Errorf("expected 2 topic series, got %d", n)
+	}
+}
+
+func TestNew_DuplicateRegistration(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	if _, err := New(reg); err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	if _, err := New(reg); err == nil {
+		t.Error("expected registering twice on the same registry to fail")
+	}
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met in time")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
@@ -0,0 +1,11 @@
+module github.com/toutaio/toutago-scela-bus/pkg/scela/scelaproto
+
+go 1.22.9
+
+require (
+	github.com/mattn/go-sqlite3 v1.14.33
+	github.com/toutaio/toutago-scela-bus v0.0.0
+	google.golang.org/protobuf v1.36.5
+)
+
+replace github.com/toutaio/toutago-scela-bus => ../../..
@@ -0,0 +1,209 @@
+// Package scelaproto serializes scela payloads and messages as protobuf.
+//
+// A Serializer stores payloads in SQL and file stores
+// as protobuf instead of JSON:
+//
+//	store, err := scela.NewSQLStore(scela.SQLStoreConfig{
+//		DB:         db,
+//		Serializer: scelaproto.NewSerializer(),
+//	})
+//
+//	store := scela.NewFileStore(path, scela.WithFileSerializer(scelaproto.NewSerializer()))
+//
+// Payloads that are protobuf messages are encoded as a google.protobuf.Any,
+// and decoded back into the message type registered for its type URL.
+// Other payloads are encoded as a google.protobuf.Value wrapped in an Any,
+// from their JSON form, and decoded like the stores of the scela package
+// decode them, as maps, slices and float64s.
+//
+// Whole messages, with scela.NewSerializableMessage and
+// scela.DeserializeMessage, are encoded as the Envelope of envelope.proto.
+package scelaproto
+
+//go:generate protoc -I.. --go_out=.. --go_opt=paths=source_relative ../scelaproto/envelope.proto
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/toutaio/toutago-scela-bus/pkg/scela"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Option configures a Serializer.
+type Option func(*Serializer)
+
+// WithTypes sets the registry resolving the message types of decoded
+// payloads, protoregistry.GlobalTypes by default, where generated code
+// registers its messages.
+func WithTypes(types *protoregistry.Types) Option {
+	return func(s *Serializer) {
+		if types != nil {
+			s.types = types
+		}
+	}
+}
+
+// Serializer is a scela.Serializer encoding payloads as protobuf. It is a
+// scela.BinarySerializer and a scela.MessageSerializer.
+type Serializer struct {
+	types *protoregistry.Types
+}
+
+// NewSerializer creates a protobuf serializer.
+func NewSerializer(opts ...Option) *Serializer {
+	s := &Serializer{types: protoregistry.GlobalTypes}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Serialize implements scela.Serializer.
+func (s *Serializer) Serialize(payload interface{}) ([]byte, error) {
+	a, err := s.encodePayload(payload)
+	if err != nil {
+		return nil, err
+	}
+	return proto.Marshal(a)
+}
+
+// Deserialize implements scela.Serializer. The target may be a protobuf
+// message of the type of the payload, a *interface{} receiving the payload
+// as Serializer decodes it, or any other value the JSON form of the payload
+// decodes into.
+func (s *Serializer) Deserialize(data []byte, target interface{}) error {
+	var a anypb.Any
+	if err := proto.Unmarshal(data, &a); err != nil {
+		return fmt.Errorf("invalid protobuf payload: %w", err)
+	}
+	return s.decodePayload(&a, target)
+}
+
+// Binary implements scela.BinarySerializer.
+func (s *Serializer) Binary() bool {
+	return true
+}
+
+// SerializeMessage implements scela.MessageSerializer.
+func (s *Serializer) SerializeMessage(msg scela.Message) ([]byte, error) {
+	payload, err := s.encodePayload(msg.Payload())
+	if err != nil {
+		return nil, err
+	}
+	var metadata *structpb.Struct
+	if len(msg.Metadata()) > 0 {
+		metadata = new(structpb.Struct)
+		if err := fromJSON(msg.Metadata(), metadata); err != nil {
+			return nil, fmt.Errorf("failed to encode metadata of message %s: %w", msg.ID(), err)
+		}
+	}
+	return proto.Marshal(&Envelope{
+		Id:        msg.ID(),
+		Topic:     msg.Topic(),
+		Payload:   payload,
+		Metadata:  metadata,
+		Timestamp: timestamppb.New(msg.Timestamp()),
+		Priority:  int32(scela.MessagePriority(msg)),
+	})
+}
+
+// DeserializeMessage implements scela.MessageSerializer.
+func (s *Serializer) DeserializeMessage(data []byte) (scela.Message, error) {
+	var env Envelope
+	if err := proto.Unmarshal(data, &env); err != nil {
+		return nil, fmt.Errorf("invalid message: %w", err)
+	}
+	if env.Topic == "" {
+		return nil, fmt.Errorf("invalid message format: missing topic")
+	}
+
+	msg := &message{
+		id:        env.Id,
+		topic:     env.Topic,
+		metadata:  env.Metadata.AsMap(),
+		timestamp: env.Timestamp.AsTime(),
+		priority:  scela.Priority(env.Priority),
+	}
+	if env.Payload != nil {
+		if err := s.decodePayload(env.Payload, &msg.payload); err != nil {
+			return nil, fmt.Errorf("failed to decode payload of message %s: %w", env.Id, err)
+		}
+	}
+	return msg, nil
+}
+
+// encodePayload returns payload as an Any.
+func (s *Serializer) encodePayload(payload interface{}) (*anypb.Any, error) {
+	if m, ok := payload.(proto.Message); ok {
+		return anypb.New(m)
+	}
+	v := new(structpb.Value)
+	if err := fromJSON(payload, v); err != nil {
+		return nil, fmt.Errorf("failed to encode payload: %w", err)
+	}
+	return anypb.New(v)
+}
+
+// decodePayload decodes the payload a into target, see Deserialize.
+func (s *Serializer) decodePayload(a *anypb.Any, target interface{}) error {
+	if m, ok := target.(proto.Message); ok {
+		return a.UnmarshalTo(m)
+	}
+
+	m, err := anypb.UnmarshalNew(a, proto.UnmarshalOptions{Resolver: s.types})
+	if err != nil {
+		return fmt.Errorf("failed to decode payload of type %s: %w", a.GetTypeUrl(), err)
+	}
+	if p, ok := target.(*interface{}); ok {
+		if v, ok := m.(*structpb.Value); ok {
+			*p = v.AsInterface()
+		} else {
+			*p = m
+		}
+		return nil
+	}
+
+	var data []byte
+	if v, ok := m.(*structpb.Value); ok {
+		data, err = json.Marshal(v.AsInterface())
+	} else {
+		data, err = protojson.Marshal(m)
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, target)
+}
+
+// fromJSON sets m, a well-known type, to the JSON form of v.
+func fromJSON(v interface{}, m proto.Message) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return protojson.Unmarshal(data, m)
+}
+
+// message is a message decoded from an Envelope.
+type message struct {
+	id        string
+	topic     string
+	payload   interface{}
+	metadata  map[string]interface{}
+	timestamp time.Time
+	priority  scela.Priority
+}
+
+func (m *message) ID() string                       { return m.id }
+func (m *message) Topic() string                    { return m.topic }
+func (m *message) Payload() interface{}             { return m.payload }
+func (m *message) Metadata() map[string]interface{} { return m.metadata }
+func (m *message) Timestamp() time.Time             { return m.timestamp }
+func (m *message) Priority() scela.Priority         { return m.priority }
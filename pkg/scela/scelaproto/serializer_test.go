@@ -0,0 +1,122 @@
+package scelaproto
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/toutaio/toutago-scela-bus/pkg/scela"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func TestSerializer_Payloads(t *testing.T) {
+	s := NewSerializer()
+
+	data, err := s.Serialize(wrapperspb.String("o-1"))
+	if err != nil {
+		t.Fatalf("Serialize: %v", err)
+	}
+	var payload interface{}
+	if err := s.Deserialize(data, &payload); err != nil {
+		t.Fatalf("Deserialize: %v", err)
+	}
+	if m, ok := payload.(proto.Message); !ok || !proto.Equal(m, wrapperspb.String("o-1")) {
+		t.Errorf("expected the protobuf message back, got %v", payload)
+	}
+	var target wrapperspb.StringValue
+	if err := s.Deserialize(data, &target); err != nil || target.GetValue() != "o-1" {
+		t.Errorf("Deserialize into the message type = %v, %v", target.GetValue(), err)
+	}
+
+	data, err = s.Serialize(map[string]interface{}{"id": "o-1", "total": 42})
+	if err != nil {
+		t.Fatalf("Serialize: %v", err)
+	}
+	payload = nil
+	if err := s.Deserialize(data, &payload); err != nil {
+		t.Fatalf("Deserialize: %v", err)
+	}
+	if order, _ := payload.(map[string]interface{}); order["id"] != "o-1" || order["total"] != float64(42) {
+		t.Errorf("unexpected payload %v", payload)
+	}
+	var order struct {
+		ID    string `json:"id"`
+		Total int    `json:"total"`
+	}
+	if err := s.Deserialize(data, &order); err != nil || order.ID != "o-1" || order.Total != 42 {
+		t.Errorf("Deserialize into a struct = %+v, %v", order, err)
+	}
+
+	if err := s.Deserialize([]byte("not protobuf"), &payload); err == nil {
+		t.Error("expected invalid data rejected")
+	}
+}
+
+func TestSerializer_Messages(t *testing.T) {
+	s := NewSerializer()
+	msg := scela.NewMessageWithPriority("orders.created", durationpb.New(time.Second), scela.PriorityHigh)
+	msg.Metadata()["tenant"] = "acme"
+
+	data, err := scela.NewSerializableMessage(msg, s).SerializeMessage()
+	if err != nil {
+		t.Fatalf("SerializeMessage: %v", err)
+	}
+	got, err := scela.DeserializeMessage(data, s)
+	if err != nil {
+		t.Fatalf("DeserializeMessage: %v", err)
+	}
+
+	if got.ID() != msg.ID() || got.Topic() != msg.Topic() || !got.Timestamp().Equal(msg.Timestamp()) {
+		t.Errorf("got %s %s %v, want %s %s %v", got.ID(), got.Topic(), got.Timestamp(), msg.ID(), msg.Topic(), msg.Timestamp())
+	}
+	if scela.MessagePriority(got) != scela.PriorityHigh || got.Metadata()["tenant"] != "acme" {
+		t.Errorf("priority %v and metadata %v not restored", scela.MessagePriority(got), got.Metadata())
+	}
+	if d, ok := got.Payload().(*durationpb.Duration); !ok || d.AsDuration() != time.Second {
+		t.Errorf("unexpected payload %v", got.Payload())
+	}
+}
+
+func TestSerializer_Stores(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer db.Close()
+	sqlStore, err := scela.NewSQLStore(scela.SQLStoreConfig{DB: db, Serializer: NewSerializer()})
+	if err != nil {
+		t.Fatalf("NewSQLStore: %v", err)
+	}
+	stores := map[string]scela.MessageStore{
+		"sql":  sqlStore,
+		"file": scela.NewFileStore(filepath.Join(t.TempDir(), "messages.json"), scela.WithFileSerializer(NewSerializer())),
+	}
+
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			if err := store.Store(ctx, scela.NewMessage("orders", wrapperspb.String("o-1"))); err != nil {
+				t.Fatalf("Store: %v", err)
+			}
+			if err := store.Store(ctx, scela.NewMessage("orders", "o-2")); err != nil {
+				t.Fatalf("Store: %v", err)
+			}
+
+			loaded, err := store.Load(ctx)
+			if err != nil || len(loaded) != 2 {
+				t.Fatalf("Load = %v, %v", loaded, err)
+			}
+			if m, ok := loaded[0].Payload().(proto.Message); !ok || !proto.Equal(m, wrapperspb.String("o-1")) {
+				t.Errorf("unexpected payload %v", loaded[0].Payload())
+			}
+			if loaded[1].Payload() != "o-2" {
+				t.Errorf("unexpected payload %v", loaded[1].Payload())
+			}
+		})
+	}
+}
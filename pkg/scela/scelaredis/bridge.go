@@ -0,0 +1,336 @@
+package scelaredis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/toutaio/toutago-scela-bus/pkg/scela"
+)
+
+// defaultPollInterval is how long Receive blocks on Redis at a time by
+// default.
+const defaultPollInterval = 500 * time.Millisecond
+
+// readCount is the number of stream entries a Source reads at once.
+const readCount = 16
+
+// SinkOption configures a Sink.
+type SinkOption func(*Sink)
+
+// WithMaxLen caps the stream at about n messages, trimming the oldest ones
+// as new ones are sent. Messages trimmed before a consumer group read them
+// are lost to it. By default the stream is not trimmed.
+func WithMaxLen(n int64) SinkOption {
+	return func(s *Sink) {
+		s.maxLen = n
+	}
+}
+
+// Sink is a scela.Sink appending messages to a Redis stream.
+type Sink struct {
+	client redis.UniversalClient
+	stream string
+	maxLen int64
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewSink creates a sink appending to stream. The client is owned by the
+// caller: Close leaves it open.
+func NewSink(client redis.UniversalClient, stream string, opts ...SinkOption) *Sink {
+	s := &Sink{
+		client: client,
+		stream: stream,
+		done:   make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Send implements scela.Sink.
+func (s *Sink) Send(ctx context.Context, msg scela.Message) error {
+	select {
+	case <-s.done:
+		return scela.ErrBridgeClosed
+	default:
+	}
+
+	data, err := scela.MarshalMessage(msg)
+	if err != nil {
+		return fmt.Errorf("failed to encode message %s: %w", msg.ID(), err)
+	}
+	args := &redis.XAddArgs{
+		Stream: s.stream,
+		Values: []interface{}{messageField, data},
+	}
+	if s.maxLen > 0 {
+		args.MaxLen = s.maxLen
+		args.Approx = true
+	}
+	if err := s.client.XAdd(ctx, args).Err(); err != nil {
+		return fmt.Errorf("failed to send message %s: %w", msg.ID(), err)
+	}
+	return nil
+}
+
+// Close implements scela.Sink.
+func (s *Sink) Close() error {
+	s.closeOnce.Do(func() { close(s.done) })
+	return nil
+}
+
+// SourceOption configures a Source.
+type SourceOption func(*Source)
+
+// WithPollInterval sets how long Receive blocks on Redis at a time, which
+// bounds how long a blocked Receive takes to notice Close. It defaults to
+// 500ms.
+func WithPollInterval(d time.Duration) SourceOption {
+	return func(s *Source) {
+		if d > 0 {
+			s.pollInterval = d
+		}
+	}
+}
+
+// Source is a scela.Source reading a Redis stream as a member of a consumer
+// group. Every group receives every message; the members of a group share
+// them. The group is created at the start of the stream if it does not
+// exist.
+//
+// Messages are delivered in stream order. A nacked message is delivered
+// again before newer ones. Messages left unacknowledged when a source
+// stops, including those it had read ahead, stay pending for its consumer
+// name and are delivered first by the next source with that name.
+type Source struct {
+	client       redis.UniversalClient
+	stream       string
+	group        string
+	consumer     string
+	pollInterval time.Duration
+
+	mu        sync.Mutex
+	ready     bool
+	recovered bool
+	queued    []redis.XMessage
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewSource creates a source reading stream in group as consumer, which
+// should be stable across restarts of the process, such as its host name.
+// The client is owned by the caller: Close leaves it open.
+func NewSource(client redis.UniversalClient, stream, group, consumer string, opts ...SourceOption) *Source {
+	s := &Source{
+		client:       client,
+		stream:       stream,
+		group:        group,
+		consumer:     consumer,
+		pollInterval: defaultPollInterval,
+		done:         make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Receive implements scela.Source. Failures to reach Redis are retried
+// until ctx is done.
+func (s *Source) Receive(ctx context.Context) (scela.Delivery, error) {
+	for {
+		select {
+		case <-s.done:
+			return nil, scela.ErrBridgeClosed
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		default:
+		}
+
+		if entry, ok := s.next(); ok {
+			msg, err := decode(entry)
+			if err != nil {
+				// A malformed entry would block the stream forever
+				_ = s.client.XAck(ctx, s.stream, s.group, entry.ID).Err()
+				return nil, err
+			}
+			return &delivery{source: s, entry: entry, msg: msg}, nil
+		}
+
+		if err := s.fill(ctx); err != nil {
+			select {
+			case <-s.done:
+				return nil, scela.ErrBridgeClosed
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(s.pollInterval):
+			}
+		}
+	}
+}
+
+// next pops the oldest entry read but not yet delivered.
+func (s *Source) next() (redis.XMessage, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.queued) == 0 {
+		return redis.XMessage{}, false
+	}
+	entry := s.queued[0]
+	s.queued = s.queued[1:]
+	return entry, true
+}
+
+// fill reads entries from the stream, blocking for at most the poll
+// interval. The first read recovers the entries left pending by an earlier
+// source with the same consumer name.
+func (s *Source) fill(ctx context.Context) error {
+	if err := s.ensureGroup(ctx); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	recovered := s.recovered
+	s.mu.Unlock()
+
+	args := &redis.XReadGroupArgs{
+		Group:    s.group,
+		Consumer: s.consumer,
+		Streams:  []string{s.stream, ">"},
+		Count:    readCount,
+		Block:    s.pollInterval,
+	}
+	if !recovered {
+		args.Streams[1] = "0"
+		args.Count = 0
+		args.Block = -1
+	}
+
+	streams, err := s.client.XReadGroup(ctx, args).Result()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return fmt.Errorf("failed to read from stream %s: %w", s.stream, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.recovered = true
+	for _, stream := range streams {
+		for _, entry := range stream.Messages {
+			s.enqueue(entry)
+		}
+	}
+	return nil
+}
+
+// ensureGroup creates the consumer group if it does not exist yet.
+func (s *Source) ensureGroup(ctx context.Context) error {
+	s.mu.Lock()
+	ready := s.ready
+	s.mu.Unlock()
+	if ready {
+		return nil
+	}
+
+	err := s.client.XGroupCreateMkStream(ctx, s.stream, s.group, "0").Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return fmt.Errorf("failed to create consumer group %s: %w", s.group, err)
+	}
+
+	s.mu.Lock()
+	s.ready = true
+	s.mu.Unlock()
+	return nil
+}
+
+// enqueue inserts entry in stream order. Must be called with the lock held.
+func (s *Source) enqueue(entry redis.XMessage) {
+	i := sort.Search(len(s.queued), func(i int) bool {
+		return compareIDs(s.queued[i].ID, entry.ID) >= 0
+	})
+	if i < len(s.queued) && s.queued[i].ID == entry.ID {
+		return
+	}
+	s.queued = append(s.queued, redis.XMessage{})
+	copy(s.queued[i+1:], s.queued[i:])
+	s.queued[i] = entry
+}
+
+// Close implements scela.Source.
+func (s *Source) Close() error {
+	s.closeOnce.Do(func() { close(s.done) })
+	return nil
+}
+
+// delivery is a message read by a Source.
+type delivery struct {
+	source *Source
+	entry  redis.XMessage
+	msg    scela.Message
+}
+
+// Message implements scela.Delivery.
+func (d *delivery) Message() scela.Message {
+	return d.msg
+}
+
+// Ack implements scela.Delivery.
+func (d *delivery) Ack() error {
+	s := d.source
+	if err := s.client.XAck(context.Background(), s.stream, s.group, d.entry.ID).Err(); err != nil {
+		return fmt.Errorf("failed to ack message %s: %w", d.msg.ID(), err)
+	}
+	return nil
+}
+
+// Nack implements scela.Delivery. The message stays pending in Redis and is
+// delivered again by the source.
+func (d *delivery) Nack() error {
+	s := d.source
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.enqueue(d.entry)
+	return nil
+}
+
+// compareIDs compares two stream entry IDs, "<milliseconds>-<sequence>".
+func compareIDs(a, b string) int {
+	am, as := splitID(a)
+	bm, bs := splitID(b)
+	if am != bm {
+		return compareUint(am, bm)
+	}
+	return compareUint(as, bs)
+}
+
+// splitID returns the two parts of a stream entry ID.
+func splitID(id string) (ms, seq uint64) {
+	left, right, _ := strings.Cut(id, "-")
+	ms, _ = strconv.ParseUint(left, 10, 64)
+	seq, _ = strconv.ParseUint(right, 10, 64)
+	return ms, seq
+}
+
+// compareUint returns -1, 0 or 1 as a is less than, equal to or greater
+// than b.
+func compareUint(a, b uint64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,154 @@
+package scelaredis
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/toutaio/toutago-scela-bus/pkg/scela"
+	"github.com/toutaio/toutago-scela-bus/pkg/scela/scelatest"
+)
+
+// newTestClient returns a client of a fresh in-memory Redis server.
+func newTestClient(t *testing.T) redis.UniversalClient {
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+	return client
+}
+
+func TestStore(t *testing.T) {
+	client := newTestClient(t)
+	store := NewStore(client, "messages")
+	ctx := context.Background()
+
+	first := scela.NewMessageWithPriority("orders.created", "o-1", scela.PriorityHigh)
+	first.Metadata()["tenant"] = "acme"
+	if err := store.Store(ctx, first); err != nil {
+		t.Fatalf("Store() error = %v", err)
+	}
+	batch := []scela.Message{scela.NewMessage("orders.paid", "o-1"), scela.NewMessage("orders.created", "o-2")}
+	if err := store.StoreBatch(ctx, batch); err != nil {
+		t.Fatalf("StoreBatch() error = %v", err)
+	}
+
+	// Another process sees the same messages
+	loaded, err := NewStore(client, "messages").Load(ctx)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if len(loaded) != 3 {
+		t.Fatalf("expected 3 messages, got %d", len(loaded))
+	}
+	got := loaded[0]
+	if got.ID() != first.ID() || got.Payload() != "o-1" || got.Metadata()["tenant"] != "acme" ||
+		scela.MessagePriority(got) != scela.PriorityHigh || !got.Timestamp().Equal(first.Timestamp()) {
+		t.Errorf("message not restored: %s %v %v", got.ID(), got.Payload(), got.Metadata())
+	}
+
+	removed, err := scela.CompactStore(ctx, store, scela.KeepLastPerTopic(1))
+	if err != nil || removed != 1 {
+		t.Fatalf("CompactStore() = %d, %v, want 1", removed, err)
+	}
+	if loaded, _ := store.Load(ctx); len(loaded) != 2 || loaded[1].ID() != batch[1].ID() {
+		t.Errorf("unexpected messages after compaction %v", loaded)
+	}
+
+	if err := store.Clear(ctx); err != nil {
+		t.Fatalf("Clear() error = %v", err)
+	}
+	if loaded, _ := store.Load(ctx); len(loaded) != 0 {
+		t.Errorf("expected no messages after Clear, got %d", len(loaded))
+	}
+
+	_ = store.Close()
+	if err := store.Store(ctx, first); err != scela.ErrStoreClosed {
+		t.Errorf("expected ErrStoreClosed after Close, got %v", err)
+	}
+}
+
+func TestBridge_Conformance(t *testing.T) {
+	scelatest.TestBridge(t, func(t *testing.T) scelatest.BridgeFixture {
+		client := newTestClient(t)
+		return scelatest.BridgeFixture{
+			Sink:   NewSink(client, "conformance"),
+			Source: NewSource(client, "conformance", "group", "consumer", WithPollInterval(20*time.Millisecond)),
+		}
+	})
+}
+
+func TestSource_RecoversPendingMessages(t *testing.T) {
+	client := newTestClient(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	sink := NewSink(client, "events")
+	for i := 0; i < 3; i++ {
+		if err := sink.Send(ctx, scela.NewMessage("events", fmt.Sprint(i))); err != nil {
+			t.Fatalf("Send() error = %v", err)
+		}
+	}
+
+	source := NewSource(client, "events", "group", "worker-1", WithPollInterval(20*time.Millisecond))
+	d, err := source.Receive(ctx)
+	if err != nil {
+		t.Fatalf("Receive() error = %v", err)
+	}
+	_ = d.Ack()
+	if _, err := source.Receive(ctx); err != nil {
+		t.Fatalf("Receive() error = %v", err)
+	}
+	_ = source.Close()
+
+	// The restarted worker gets the unacknowledged messages back
+	restarted := NewSource(client, "events", "group", "worker-1", WithPollInterval(20*time.Millisecond))
+	defer restarted.Close()
+	for _, want := range []string{"1", "2"} {
+		d, err := restarted.Receive(ctx)
+		if err != nil {
+			t.Fatalf("Receive() error = %v", err)
+		}
+		if d.Message().Payload() != want {
+			t.Errorf("received %v, want %s", d.Message().Payload(), want)
+		}
+		_ = d.Ack()
+	}
+}
+
+func TestBridge_BetweenBuses(t *testing.T) {
+	client := newTestClient(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	publisher := scela.New()
+	defer publisher.Close()
+	if _, err := scela.ForwardTo(publisher, "orders.#", NewSink(client, "events")); err != nil {
+		t.Fatalf("ForwardTo() error = %v", err)
+	}
+
+	consumer := scela.New()
+	defer consumer.Close()
+	received := make(chan scela.Message, 1)
+	_, _ = consumer.Subscribe("orders.created", scela.HandlerFunc(func(ctx context.Context, msg scela.Message) error {
+		received <- msg
+		return nil
+	}))
+	source := NewSource(client, "events", "billing", "worker-1", WithPollInterval(20*time.Millisecond))
+	defer source.Close()
+	go func() { _ = scela.ConsumeFrom(ctx, source, consumer) }()
+
+	if err := publisher.PublishSync(ctx, "orders.created", "o-1"); err != nil {
+		t.Fatalf("PublishSync() error = %v", err)
+	}
+	select {
+	case msg := <-received:
+		if msg.Payload() != "o-1" {
+			t.Errorf("unexpected payload %v", msg.Payload())
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("message not bridged")
+	}
+}
@@ -0,0 +1,219 @@
+// Package scelaredis stores scela messages in Redis and bridges buses
+// running in different processes through it.
+//
+// A Store keeps the messages of a PersistentBus in a Redis stream:
+//
+//	client := redis.NewClient(&redis.Options{Addr: "localhost:6379"})
+//	store := scelaredis.NewStore(client, "orders:messages")
+//	pb := scela.NewPersistentBus(scela.New(), store)
+//
+// Processes sharing a Redis instance exchange messages by forwarding them
+// to a stream with a Sink and consuming them with a Source:
+//
+//	scela.ForwardTo(bus, "orders.#", scelaredis.NewSink(client, "events"))
+//
+//	source := scelaredis.NewSource(client, "events", "billing", hostname)
+//	go scela.ConsumeFrom(ctx, source, bus)
+//
+// The bridge uses a stream rather than PUBLISH/SUBSCRIBE channels, which
+// drop the messages sent while a subscriber is disconnected and so cannot
+// provide the at-least-once delivery of scela.Source. Messages are encoded
+// with scela.MarshalMessage, so their ID, metadata, timestamp and priority
+// survive the round trip.
+package scelaredis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/toutaio/toutago-scela-bus/pkg/scela"
+)
+
+// messageField is the stream entry field holding the encoded message.
+const messageField = "message"
+
+// maxRewriteAttempts bounds how many times Rewrite retries when another
+// client changes the store during the rewrite.
+const maxRewriteAttempts = 10
+
+// Store is a scela.MessageStore keeping messages in a Redis stream, in the
+// order they were stored. Several processes may share it. It implements
+// scela.BatchStore and scela.RewritableStore, so retention policies apply
+// to it.
+type Store struct {
+	client redis.UniversalClient
+	key    string
+
+	mu     sync.Mutex
+	closed bool
+}
+
+// NewStore creates a store keeping messages in the stream at key. The
+// client is owned by the caller: Close leaves it open.
+func NewStore(client redis.UniversalClient, key string) *Store {
+	return &Store{client: client, key: key}
+}
+
+// Store implements scela.MessageStore.
+func (s *Store) Store(ctx context.Context, msg scela.Message) error {
+	return s.StoreBatch(ctx, []scela.Message{msg})
+}
+
+// StoreBatch implements scela.BatchStore. The messages are appended in a
+// single transaction.
+func (s *Store) StoreBatch(ctx context.Context, msgs []scela.Message) error {
+	if err := s.checkOpen(); err != nil {
+		return err
+	}
+	values, err := encode(msgs)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		appendAll(ctx, pipe, s.key, values)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to store messages: %w", err)
+	}
+	return nil
+}
+
+// Load implements scela.MessageStore.
+func (s *Store) Load(ctx context.Context) ([]scela.Message, error) {
+	if err := s.checkOpen(); err != nil {
+		return nil, err
+	}
+	return load(ctx, s.client, s.key)
+}
+
+// Clear implements scela.MessageStore.
+func (s *Store) Clear(ctx context.Context) error {
+	if err := s.checkOpen(); err != nil {
+		return err
+	}
+	if err := s.client.Del(ctx, s.key).Err(); err != nil {
+		return fmt.Errorf("failed to clear messages: %w", err)
+	}
+	return nil
+}
+
+// Rewrite implements scela.RewritableStore. The stream is watched while fn
+// runs, and the rewrite is retried if another client changes it meanwhile,
+// so fn may be called more than once.
+func (s *Store) Rewrite(ctx context.Context, fn func([]scela.Message) ([]scela.Message, error)) error {
+	if err := s.checkOpen(); err != nil {
+		return err
+	}
+
+	rewrite := func(tx *redis.Tx) error {
+		msgs, err := load(ctx, tx, s.key)
+		if err != nil {
+			return err
+		}
+		kept, err := fn(msgs)
+		if err != nil {
+			return err
+		}
+		values, err := encode(kept)
+		if err != nil {
+			return err
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Del(ctx, s.key)
+			appendAll(ctx, pipe, s.key, values)
+			return nil
+		})
+		return err
+	}
+
+	for i := 0; i < maxRewriteAttempts; i++ {
+		err := s.client.Watch(ctx, rewrite, s.key)
+		if !errors.Is(err, redis.TxFailedErr) {
+			return err
+		}
+	}
+	return fmt.Errorf("failed to rewrite messages: %w", redis.TxFailedErr)
+}
+
+// Close implements scela.MessageStore. Later calls return
+// scela.ErrStoreClosed.
+func (s *Store) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return scela.ErrStoreClosed
+	}
+	s.closed = true
+	return nil
+}
+
+// checkOpen returns scela.ErrStoreClosed once the store is closed.
+func (s *Store) checkOpen() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return scela.ErrStoreClosed
+	}
+	return nil
+}
+
+// encode encodes msgs with scela.MarshalMessage.
+func encode(msgs []scela.Message) ([][]byte, error) {
+	values := make([][]byte, len(msgs))
+	for i, msg := range msgs {
+		data, err := scela.MarshalMessage(msg)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode message %s: %w", msg.ID(), err)
+		}
+		values[i] = data
+	}
+	return values, nil
+}
+
+// appendAll queues the appends of values to the stream at key on pipe.
+func appendAll(ctx context.Context, pipe redis.Pipeliner, key string, values [][]byte) {
+	for _, data := range values {
+		pipe.XAdd(ctx, &redis.XAddArgs{
+			Stream: key,
+			Values: []interface{}{messageField, data},
+		})
+	}
+}
+
+// load returns the messages of the stream at key, oldest first.
+func load(ctx context.Context, c redis.Cmdable, key string) ([]scela.Message, error) {
+	entries, err := c.XRange(ctx, key, "-", "+").Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load messages: %w", err)
+	}
+
+	msgs := make([]scela.Message, 0, len(entries))
+	for _, entry := range entries {
+		msg, err := decode(entry)
+		if err != nil {
+			return nil, err
+		}
+		msgs = append(msgs, msg)
+	}
+	return msgs, nil
+}
+
+// decode returns the message of a stream entry.
+func decode(entry redis.XMessage) (scela.Message, error) {
+	data, ok := entry.Values[messageField].(string)
+	if !ok {
+		return nil, fmt.Errorf("stream entry %s holds no message", entry.ID)
+	}
+	msg, err := scela.UnmarshalMessage([]byte(data))
+	if err != nil {
+		return nil, fmt.Errorf("stream entry %s: %w", entry.ID, err)
+	}
+	return msg, nil
+}
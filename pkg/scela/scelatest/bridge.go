@@ -0,0 +1,302 @@
+package scelatest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/toutaio/toutago-scela-bus/pkg/scela"
+)
+
+// BridgeFixture is a connected Sink and Source under test: messages sent to
+// Sink must be received from Source.
+type BridgeFixture struct {
+	Sink   scela.Sink
+	Source scela.Source
+
+	// Interrupt breaks the connection between the bridge and the external
+	// system, for example by restarting the broker or dropping its network
+	// connections. The reconnect checks are skipped when it is nil.
+	Interrupt func()
+
+	// Timeout bounds how long a single send or receive may take. It
+	// defaults to five seconds.
+	Timeout time.Duration
+
+	// Quiet is how long to wait to confirm that an acknowledged message is
+	// not delivered again. It defaults to 100ms.
+	Quiet time.Duration
+}
+
+// TestBridge runs the bridge conformance suite. newFixture is called once per
+// check and must return a fixture over a fresh, empty channel; the sink and
+// source are closed when the check finishes.
+//
+// The suite checks that
+//   - messages keep their topic, string payload and string metadata;
+//   - messages sent in order are received in order;
+//   - nacked messages are redelivered and acknowledged ones are not;
+//   - unacknowledged messages are redelivered after a reconnect, and sends
+//     succeed again without intervention;
+//   - Close is idempotent and Send and Receive, including a blocked Receive,
+//     return scela.ErrBridgeClosed afterwards.
+func TestBridge(t *testing.T, newFixture func(t *testing.T) BridgeFixture) {
+	checks := []struct {
+		name string
+		fn   func(t *testing.T, c *bridgeCheck)
+	}{
+		{"Fidelity", checkBridgeFidelity},
+		{"Ordering", checkBridgeOrdering},
+		{"RedeliveryAfterNack", checkBridgeNack},
+		{"RedeliveryAfterReconnect", checkBridgeReconnectRedelivery},
+		{"SendAfterReconnect", checkBridgeReconnectSend},
+		{"SourceShutdown", checkBridgeSourceShutdown},
+		{"SinkShutdown", checkBridgeSinkShutdown},
+	}
+
+	for _, check := range checks {
+		check := check
+		t.Run(check.name, func(t *testing.T) {
+			f := newFixture(t)
+			if f.Timeout <= 0 {
+				f.Timeout = 5 * time.Second
+			}
+			if f.Quiet <= 0 {
+				f.Quiet = 100 * time.Millisecond
+			}
+			t.Cleanup(func() {
+				_ = f.Source.Close()
+				_ = f.Sink.Close()
+			})
+			check.fn(t, &bridgeCheck{BridgeFixture: f})
+		})
+	}
+}
+
+// bridgeCheck wraps a fixture with helpers for the checks.
+type bridgeCheck struct {
+	BridgeFixture
+}
+
+// send sends a message with a "seq" metadata entry and fails t on error.
+func (c *bridgeCheck) send(t *testing.T, topic, payload string) scela.Message {
+	t.Helper()
+
+	msg := scela.NewMessage(topic, payload)
+	msg.Metadata()["seq"] = payload
+	ctx, cancel := context.WithTimeout(context.Background(), c.Timeout)
+	defer cancel()
+	if err := c.Sink.Send(ctx, msg); err != nil {
+		t.Fatalf("Send(%q): %v", payload, err)
+	}
+	return msg
+}
+
+// sendEventually sends a message, retrying failed sends until the timeout so
+// the sink has a chance to reconnect.
+func (c *bridgeCheck) sendEventually(t *testing.T, topic, payload string) {
+	t.Helper()
+
+	msg := scela.NewMessage(topic, payload)
+	deadline := time.Now().Add(c.Timeout)
+	for {
+		ctx, cancel := context.WithDeadline(context.Background(), deadline)
+		err := c.Sink.Send(ctx, msg)
+		cancel()
+		if err == nil {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Send(%q) did not recover within %v: %v", payload, c.Timeout, err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// receive returns the next delivery and fails t if none arrives in time.
+func (c *bridgeCheck) receive(t *testing.T) scela.Delivery {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.Timeout)
+	defer cancel()
+	d, err := c.Source.Receive(ctx)
+	if err != nil {
+		t.Fatalf("Receive: %v", err)
+	}
+	return d
+}
+
+// receivePayload receives the next delivery and checks its payload.
+func (c *bridgeCheck) receivePayload(t *testing.T, want string) scela.Delivery {
+	t.Helper()
+
+	d := c.receive(t)
+	if got := payloadString(d.Message()); got != want {
+		t.Fatalf("received payload %q, want %q", got, want)
+	}
+	return d
+}
+
+// expectQuiet fails t if a message is received within the quiet period.
+func (c *bridgeCheck) expectQuiet(t *testing.T) {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.Quiet)
+	defer cancel()
+	d, err := c.Source.Receive(ctx)
+	if err == nil {
+		t.Fatalf("unexpected delivery of %q", payloadString(d.Message()))
+	}
+}
+
+// ack acknowledges d and fails t on error.
+func ack(t *testing.T, d scela.Delivery) {
+	t.Helper()
+	if err := d.Ack(); err != nil {
+		t.Fatalf("Ack(%q): %v", payloadString(d.Message()), err)
+	}
+}
+
+// payloadString returns the payload as a string, accepting []byte for
+// bridges that do not decode payloads.
+func payloadString(msg scela.Message) string {
+	switch p := msg.Payload().(type) {
+	case string:
+		return p
+	case []byte:
+		return string(p)
+	default:
+		return fmt.Sprint(p)
+	}
+}
+
+func checkBridgeFidelity(t *testing.T, c *bridgeCheck) {
+	sent := scela.NewMessage("conformance.fidelity", "hello")
+	sent.Metadata()["tenant"] = "acme"
+	ctx, cancel := context.WithTimeout(context.Background(), c.Timeout)
+	defer cancel()
+	if err := c.Sink.Send(ctx, sent); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	d := c.receive(t)
+	got := d.Message()
+	if got.Topic() != sent.Topic() {
+		t.Errorf("topic = %q, want %q", got.Topic(), sent.Topic())
+	}
+	if p := payloadString(got); p != "hello" {
+		t.Errorf("payload = %q, want %q", p, "hello")
+	}
+	if v := fmt.Sprint(got.Metadata()["tenant"]); v != "acme" {
+		t.Errorf("metadata tenant = %q, want %q", v, "acme")
+	}
+	ack(t, d)
+}
+
+func checkBridgeOrdering(t *testing.T, c *bridgeCheck) {
+	const n = 20
+	for i := 0; i < n; i++ {
+		c.send(t, "conformance.order", fmt.Sprintf("message-%d", i))
+	}
+	for i := 0; i < n; i++ {
+		ack(t, c.receivePayload(t, fmt.Sprintf("message-%d", i)))
+	}
+}
+
+func checkBridgeNack(t *testing.T, c *bridgeCheck) {
+	c.send(t, "conformance.nack", "retry-me")
+
+	d := c.receivePayload(t, "retry-me")
+	if err := d.Nack(); err != nil {
+		t.Fatalf("Nack: %v", err)
+	}
+
+	ack(t, c.receivePayload(t, "retry-me"))
+	c.expectQuiet(t)
+}
+
+func checkBridgeReconnectRedelivery(t *testing.T, c *bridgeCheck) {
+	if c.Interrupt == nil {
+		t.Skip("fixture does not support interrupting the connection")
+	}
+
+	c.send(t, "conformance.reconnect", "first")
+	c.send(t, "conformance.reconnect", "second")
+	c.receivePayload(t, "first")
+
+	c.Interrupt()
+
+	// The unacknowledged message must come back; "second" may be seen
+	// more than once but must not be lost.
+	seen := map[string]bool{}
+	for !seen["first"] || !seen["second"] {
+		d := c.receive(t)
+		p := payloadString(d.Message())
+		if p != "first" && p != "second" {
+			t.Fatalf("unexpected payload %q", p)
+		}
+		if p == "first" && seen["second"] {
+			t.Fatalf("%q redelivered after %q", "first", "second")
+		}
+		seen[p] = true
+		ack(t, d)
+	}
+}
+
+func checkBridgeReconnectSend(t *testing.T, c *bridgeCheck) {
+	if c.Interrupt == nil {
+		t.Skip("fixture does not support interrupting the connection")
+	}
+
+	c.Interrupt()
+	c.sendEventually(t, "conformance.reconnect", "after")
+	ack(t, c.receivePayload(t, "after"))
+}
+
+func checkBridgeSourceShutdown(t *testing.T, c *bridgeCheck) {
+	errs := make(chan error, 1)
+	go func() {
+		_, err := c.Source.Receive(context.Background())
+		errs <- err
+	}()
+
+	// Give the receiver a chance to block before closing
+	time.Sleep(c.Quiet)
+	if err := c.Source.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	select {
+	case err := <-errs:
+		if !errors.Is(err, scela.ErrBridgeClosed) {
+			t.Errorf("blocked Receive returned %v, want ErrBridgeClosed", err)
+		}
+	case <-time.After(c.Timeout):
+		t.Fatal("blocked Receive did not return after Close")
+	}
+
+	if _, err := c.Source.Receive(context.Background()); !errors.Is(err, scela.ErrBridgeClosed) {
+		t.Errorf("Receive after Close returned %v, want ErrBridgeClosed", err)
+	}
+	if err := c.Source.Close(); err != nil {
+		t.Errorf("second Close: %v", err)
+	}
+}
+
+func checkBridgeSinkShutdown(t *testing.T, c *bridgeCheck) {
+	if err := c.Sink.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.Timeout)
+	defer cancel()
+	err := c.Sink.Send(ctx, scela.NewMessage("conformance.closed", "late"))
+	if !errors.Is(err, scela.ErrBridgeClosed) {
+		t.Errorf("Send after Close returned %v, want ErrBridgeClosed", err)
+	}
+	if err := c.Sink.Close(); err != nil {
+		t.Errorf("second Close: %v", err)
+	}
+}
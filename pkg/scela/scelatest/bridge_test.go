@@ -0,0 +1,104 @@
+package scelatest
+
+import (
+	"context"
+	"testing"
+
+	"github.com/toutaio/toutago-scela-bus/pkg/scela"
+)
+
+func TestMemoryBroker_Conformance(t *testing.T) {
+	TestBridge(t, func(t *testing.T) BridgeFixture {
+		broker := NewMemoryBroker()
+		return BridgeFixture{
+			Sink:      broker.Sink(),
+			Source:    broker.Source(),
+			Interrupt: broker.Interrupt,
+		}
+	})
+}
+
+func TestMemoryBroker_StaleAckAfterInterrupt(t *testing.T) {
+	broker := NewMemoryBroker()
+	sink, source := broker.Sink(), broker.Source()
+	ctx := context.Background()
+
+	if err := sink.Send(ctx, scela.NewMessage("test", "x")); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	d, err := source.Receive(ctx)
+	if err != nil {
+		t.Fatalf("Receive: %v", err)
+	}
+
+	broker.Interrupt()
+	if err := d.Ack(); err == nil {
+		t.Error("expected Ack over an interrupted connection to fail")
+	}
+	if broker.Len() != 1 {
+		t.Errorf("expected the message to be requeued, queue length %d", broker.Len())
+	}
+}
+
+func TestMemoryBroker_CloseRequeuesUnacked(t *testing.T) {
+	broker := NewMemoryBroker()
+	ctx := context.Background()
+
+	if err := broker.Sink().Send(ctx, scela.NewMessage("test", "x")); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	first := broker.Source()
+	if _, err := first.Receive(ctx); err != nil {
+		t.Fatalf("Receive: %v", err)
+	}
+	_ = first.Close()
+
+	d, err := broker.Source().Receive(ctx)
+	if err != nil {
+		t.Fatalf("Receive on new source: %v", err)
+	}
+	if d.Message().Payload() != "x" {
+		t.Errorf("unexpected payload %v", d.Message().Payload())
+	}
+}
+
+func TestBridge_ForwardAndConsume(t *testing.T) {
+	broker := NewMemoryBroker()
+
+	local := scela.New(scela.WithWorkers(1))
+	defer local.Close()
+	remote := scela.New(scela.WithWorkers(1))
+	defer remote.Close()
+
+	sink := broker.Sink()
+	defer sink.Close()
+	if _, err := scela.ForwardTo(local, "order.*", sink); err != nil {
+		t.Fatalf("ForwardTo: %v", err)
+	}
+
+	received := make(chan scela.Message, 1)
+	if _, err := remote.Subscribe("order.created", scela.HandlerFunc(func(ctx context.Context, msg scela.Message) error {
+		received <- msg
+		return nil
+	})); err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+
+	source := broker.Source()
+	done := make(chan error, 1)
+	go func() { done <- scela.ConsumeFrom(context.Background(), source, remote) }()
+
+	if err := local.PublishSync(context.Background(), "order.created", "o-1"); err != nil {
+		t.Fatalf("PublishSync: %v", err)
+	}
+
+	msg := <-received
+	if msg.Payload() != "o-1" {
+		t.Errorf("unexpected payload %v", msg.Payload())
+	}
+
+	_ = source.Close()
+	if err := <-done; err != nil {
+		t.Errorf("ConsumeFrom returned %v after the source closed", err)
+	}
+}
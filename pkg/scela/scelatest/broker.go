@@ -0,0 +1,224 @@
+package scelatest
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+
+	"github.com/toutaio/toutago-scela-bus/pkg/scela"
+)
+
+// errConnectionLost is returned when acknowledging a delivery received over
+// a connection that has since been interrupted.
+var errConnectionLost = errors.New("connection to broker lost")
+
+// MemoryBroker is an in-memory stand-in for an external queue. It hands out
+// Sink and Source connections with the semantics TestBridge expects and can
+// simulate connection loss, which makes it useful for testing code built on
+// bridges without a real broker.
+type MemoryBroker struct {
+	mu       sync.Mutex
+	nextSeq  uint64
+	conn     uint64
+	queue    []brokerEntry
+	inflight map[uint64]*memoryDelivery
+	ready    chan struct{}
+}
+
+// brokerEntry is a queued message with its position in send order.
+type brokerEntry struct {
+	seq uint64
+	msg scela.Message
+}
+
+// NewMemoryBroker creates an empty broker.
+func NewMemoryBroker() *MemoryBroker {
+	return &MemoryBroker{
+		inflight: make(map[uint64]*memoryDelivery),
+		ready:    make(chan struct{}),
+	}
+}
+
+// Sink returns a new connection that sends messages to the broker.
+func (mb *MemoryBroker) Sink() scela.Sink {
+	return &memorySink{broker: mb, done: make(chan struct{})}
+}
+
+// Source returns a new connection that receives messages from the broker.
+func (mb *MemoryBroker) Source() scela.Source {
+	return &memorySource{broker: mb, done: make(chan struct{})}
+}
+
+// Interrupt simulates a dropped connection: unacknowledged deliveries are
+// returned to the queue and can no longer be acknowledged.
+func (mb *MemoryBroker) Interrupt() {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+
+	mb.conn++
+	for seq, d := range mb.inflight {
+		delete(mb.inflight, seq)
+		mb.requeue(d.entry)
+	}
+}
+
+// Len returns the number of messages waiting to be received.
+func (mb *MemoryBroker) Len() int {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	return len(mb.queue)
+}
+
+// push appends msg to the queue.
+func (mb *MemoryBroker) push(msg scela.Message) {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+
+	mb.nextSeq++
+	mb.queue = append(mb.queue, brokerEntry{seq: mb.nextSeq, msg: msg})
+	mb.signal()
+}
+
+// requeue puts an entry back in send order. Must be called with the lock held.
+func (mb *MemoryBroker) requeue(entry brokerEntry) {
+	i := sort.Search(len(mb.queue), func(i int) bool {
+		return mb.queue[i].seq > entry.seq
+	})
+	mb.queue = append(mb.queue, brokerEntry{})
+	copy(mb.queue[i+1:], mb.queue[i:])
+	mb.queue[i] = entry
+	mb.signal()
+}
+
+// signal wakes up blocked receivers. Must be called with the lock held.
+func (mb *MemoryBroker) signal() {
+	close(mb.ready)
+	mb.ready = make(chan struct{})
+}
+
+// memorySink is a Sink connection to a MemoryBroker.
+type memorySink struct {
+	broker    *MemoryBroker
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// Send implements scela.Sink.
+func (s *memorySink) Send(ctx context.Context, msg scela.Message) error {
+	select {
+	case <-s.done:
+		return scela.ErrBridgeClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+	s.broker.push(msg)
+	return nil
+}
+
+// Close implements scela.Sink.
+func (s *memorySink) Close() error {
+	s.closeOnce.Do(func() { close(s.done) })
+	return nil
+}
+
+// memorySource is a Source connection to a MemoryBroker.
+type memorySource struct {
+	broker    *MemoryBroker
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// Receive implements scela.Source.
+func (s *memorySource) Receive(ctx context.Context) (scela.Delivery, error) {
+	mb := s.broker
+	for {
+		select {
+		case <-s.done:
+			return nil, scela.ErrBridgeClosed
+		default:
+		}
+
+		mb.mu.Lock()
+		if len(mb.queue) > 0 {
+			entry := mb.queue[0]
+			mb.queue = mb.queue[1:]
+			d := &memoryDelivery{source: s, entry: entry, conn: mb.conn}
+			mb.inflight[entry.seq] = d
+			mb.mu.Unlock()
+			return d, nil
+		}
+		ready := mb.ready
+		mb.mu.Unlock()
+
+		select {
+		case <-ready:
+		case <-s.done:
+			return nil, scela.ErrBridgeClosed
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// Close implements scela.Source. Deliveries that were not acknowledged are
+// returned to the queue.
+func (s *memorySource) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.done)
+
+		mb := s.broker
+		mb.mu.Lock()
+		defer mb.mu.Unlock()
+		for seq, d := range mb.inflight {
+			if d.source == s {
+				delete(mb.inflight, seq)
+				mb.requeue(d.entry)
+			}
+		}
+	})
+	return nil
+}
+
+// memoryDelivery is a message handed out by a memorySource.
+type memoryDelivery struct {
+	source *memorySource
+	entry  brokerEntry
+	conn   uint64
+}
+
+// Message implements scela.Delivery.
+func (d *memoryDelivery) Message() scela.Message {
+	return d.entry.msg
+}
+
+// Ack implements scela.Delivery.
+func (d *memoryDelivery) Ack() error {
+	return d.settle(false)
+}
+
+// Nack implements scela.Delivery.
+func (d *memoryDelivery) Nack() error {
+	return d.settle(true)
+}
+
+// settle removes the delivery from the in-flight set, requeueing it if asked.
+func (d *memoryDelivery) settle(requeue bool) error {
+	mb := d.source.broker
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+
+	if d.conn != mb.conn {
+		return errConnectionLost
+	}
+	if mb.inflight[d.entry.seq] != d {
+		// Already settled, or returned to the queue when the source closed
+		return nil
+	}
+	delete(mb.inflight, d.entry.seq)
+	if requeue {
+		mb.requeue(d.entry)
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+// Package scelatest provides helpers for testing code built on scela.
+package scelatest
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/toutaio/toutago-scela-bus/pkg/scela"
+)
+
+// CheckSubscriptionLeaks fails t if subscriptions created on bus after the
+// call are still registered when the test finishes. Call it at the start of
+// a test; subscriptions that existed before the call are ignored. Create the
+// bus with scela.WithSubscriptionStacks to include creation stacks in the
+// failure message.
+func CheckSubscriptionLeaks(t testing.TB, bus scela.Bus) {
+	t.Helper()
+
+	before, ok := scela.InspectSubscriptions(bus)
+	if !ok {
+		t.Fatalf("scelatest: bus %T does not support subscription inspection", bus)
+		return
+	}
+
+	existing := make(map[string]bool, len(before))
+	for _, sub := range before {
+		existing[sub.ID] = true
+	}
+
+	t.Cleanup(func() {
+		after, _ := scela.InspectSubscriptions(bus)
+
+		var leaked []string
+		for _, sub := range after {
+			if existing[sub.ID] {
+				continue
+			}
+			entry := sub.String()
+			if sub.Stack != "" {
+				entry += "\ncreated at:\n" + sub.Stack
+			}
+			leaked = append(leaked, entry)
+		}
+
+		if len(leaked) > 0 {
+			t.Errorf("scelatest: %d subscription(s) leaked:\n%s", len(leaked), strings.Join(leaked, "\n"))
+		}
+	})
+}
@@ -0,0 +1,72 @@
+package scelatest
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/toutaio/toutago-scela-bus/pkg/scela"
+)
+
+// recordingTB captures failures and cleanups instead of failing the test.
+type recordingTB struct {
+	testing.TB
+	cleanups []func()
+	errors   []string
+}
+
+func (r *recordingTB) Helper() {}
+
+func (r *recordingTB) Cleanup(fn func()) {
+	r.cleanups = append(r.cleanups, fn)
+}
+
+func (r *recordingTB) Errorf(format string, args ...interface{}) {
+	r.errors = append(r.errors, fmt.Sprintf(format, args...))
+}
+
+func (r *recordingTB) finish() {
+	for i := len(r.cleanups) - 1; i >= 0; i-- {
+		r.cleanups[i]()
+	}
+}
+
+func noop(ctx context.Context, msg scela.Message) error { return nil }
+
+func TestCheckSubscriptionLeaks_Leak(t *testing.T) {
+	bus := scela.New(scela.WithSubscriptionStacks(true))
+	defer bus.Close()
+
+	_, _ = bus.Subscribe("existing", scela.HandlerFunc(noop))
+
+	tb := &recordingTB{TB: t}
+	CheckSubscriptionLeaks(tb, bus)
+	_, _ = bus.Subscribe("leaked", scela.HandlerFunc(noop))
+	tb.finish()
+
+	if len(tb.errors) != 1 {
+		t.Fatalf("Expected 1 failure, got %v", tb.errors)
+	}
+	if !strings.Contains(tb.errors[0], `"leaked"`) || strings.Contains(tb.errors[0], `"existing"`) {
+		t.Errorf("Expected only the leaked subscription reported, got %q", tb.errors[0])
+	}
+	if !strings.Contains(tb.errors[0], "leak_test.go") {
+		t.Errorf("Expected creation stack in failure, got %q", tb.errors[0])
+	}
+}
+
+func TestCheckSubscriptionLeaks_NoLeak(t *testing.T) {
+	bus := scela.New()
+	defer bus.Close()
+
+	tb := &recordingTB{TB: t}
+	CheckSubscriptionLeaks(tb, bus)
+	sub, _ := bus.Subscribe("temporary", scela.HandlerFunc(noop))
+	_ = sub.Unsubscribe()
+	tb.finish()
+
+	if len(tb.errors) != 0 {
+		t.Errorf("Expected no failures, got %v", tb.errors)
+	}
+}
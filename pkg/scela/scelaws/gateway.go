@@ -0,0 +1,371 @@
+// Package scelaws serves a WebSocket gateway letting browsers subscribe to
+// the topics of a scela bus and receive its messages in real time.
+//
+// The gateway is an http.Handler:
+//
+//	gateway := scelaws.New(bus, scelaws.WithAuthorize(func(r *http.Request, pattern string) error {
+//		if !strings.HasPrefix(pattern, "orders.") {
+//			return errors.New("forbidden")
+//		}
+//		return nil
+//	}))
+//	defer gateway.Close()
+//	mux.Handle("/events", gateway)
+//
+// Clients send JSON frames to subscribe and unsubscribe:
+//
+//	{"type": "subscribe", "pattern": "orders.*"}
+//	{"type": "unsubscribe", "pattern": "orders.*"}
+//
+// and receive a "subscribed", "unsubscribed" or "error" frame in reply,
+// then a "message" frame for every message matching their patterns, holding
+// the message as encoded by scela.MarshalMessage:
+//
+//	{"type": "message", "pattern": "orders.*", "message": {"id": "...", "topic": "orders.created", "payload": {...}, ...}}
+//
+// A client that does not keep up with its messages is disconnected rather
+// than slowing the bus down.
+package scelaws
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/toutaio/toutago-scela-bus/pkg/scela"
+)
+
+// Frame types.
+const (
+	// TypeSubscribe is sent by clients to subscribe to a pattern.
+	TypeSubscribe = "subscribe"
+	// TypeUnsubscribe is sent by clients to unsubscribe from a pattern.
+	TypeUnsubscribe = "unsubscribe"
+	// TypeSubscribed confirms a subscription.
+	TypeSubscribed = "subscribed"
+	// TypeUnsubscribed confirms an unsubscription.
+	TypeUnsubscribed = "unsubscribed"
+	// TypeMessage carries a bus message.
+	TypeMessage = "message"
+	// TypeError reports a request the gateway refused.
+	TypeError = "error"
+)
+
+// Defaults of the options.
+const (
+	defaultBufferSize   = 64
+	defaultWriteTimeout = 10 * time.Second
+)
+
+// Frame is a JSON frame exchanged with clients.
+type Frame struct {
+	Type    string `json:"type"`
+	Pattern string `json:"pattern,omitempty"`
+	// Message is the message of a TypeMessage frame.
+	Message json.RawMessage `json:"message,omitempty"`
+	// Error is the reason of a TypeError frame.
+	Error string `json:"error,omitempty"`
+}
+
+// AuthorizeFunc decides whether the client that opened the connection with
+// r may subscribe to pattern. Returning an error refuses the subscription,
+// and the error is sent to the client.
+type AuthorizeFunc func(r *http.Request, pattern string) error
+
+// Option configures a Gateway.
+type Option func(*Gateway)
+
+// WithAuthorize authorizes every subscription with fn. By default clients
+// may subscribe to any pattern.
+func WithAuthorize(fn AuthorizeFunc) Option {
+	return func(g *Gateway) {
+		g.authorize = fn
+	}
+}
+
+// WithBufferSize sets how many frames may wait to be written to a client
+// before it is considered too slow and disconnected. It defaults to 64.
+func WithBufferSize(n int) Option {
+	return func(g *Gateway) {
+		if n > 0 {
+			g.bufferSize = n
+		}
+	}
+}
+
+// WithWriteTimeout sets how long writing a frame to a client may take before
+// the client is disconnected. It defaults to 10s.
+func WithWriteTimeout(d time.Duration) Option {
+	return func(g *Gateway) {
+		if d > 0 {
+			g.writeTimeout = d
+		}
+	}
+}
+
+// WithAcceptOptions sets the options of the WebSocket handshake, such as
+// the origins allowed to connect from other hosts.
+func WithAcceptOptions(opts *websocket.AcceptOptions) Option {
+	return func(g *Gateway) {
+		g.acceptOptions = opts
+	}
+}
+
+// Gateway is an http.Handler accepting WebSocket connections from clients
+// subscribing to the topics of a bus.
+type Gateway struct {
+	bus           scela.Subscriber
+	authorize     AuthorizeFunc
+	bufferSize    int
+	writeTimeout  time.Duration
+	acceptOptions *websocket.AcceptOptions
+
+	mu       sync.Mutex
+	sessions map[*session]struct{}
+	closed   bool
+}
+
+// New creates a gateway subscribing clients on bus.
+func New(bus scela.Subscriber, opts ...Option) *Gateway {
+	g := &Gateway{
+		bus:          bus,
+		bufferSize:   defaultBufferSize,
+		writeTimeout: defaultWriteTimeout,
+		sessions:     make(map[*session]struct{}),
+	}
+	for _, opt := range opts {
+		opt(g)
+	}
+	return g
+}
+
+// ServeHTTP implements http.Handler. It serves the connection until the
+// client or the gateway closes it.
+func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	g.mu.Lock()
+	closed := g.closed
+	g.mu.Unlock()
+	if closed {
+		http.Error(w, "gateway is closed", http.StatusServiceUnavailable)
+		return
+	}
+
+	conn, err := websocket.Accept(w, r, g.acceptOptions)
+	if err != nil {
+		// Accept has answered the request
+		return
+	}
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	s := &session{
+		gateway: g,
+		conn:    conn,
+		req:     r,
+		out:     make(chan Frame, g.bufferSize),
+		cancel:  cancel,
+		subs:    make(map[string]scela.Subscription),
+	}
+	if !g.add(s) {
+		_ = conn.Close(websocket.StatusGoingAway, "gateway is closed")
+		return
+	}
+	defer g.remove(s)
+	s.run(ctx)
+}
+
+// Close disconnects every client and refuses new connections.
+func (g *Gateway) Close() error {
+	g.mu.Lock()
+	g.closed = true
+	sessions := make([]*session, 0, len(g.sessions))
+	for s := range g.sessions {
+		sessions = append(sessions, s)
+	}
+	g.mu.Unlock()
+
+	for _, s := range sessions {
+		s.close(websocket.StatusGoingAway, "gateway is closing")
+	}
+	return nil
+}
+
+// add registers s, unless the gateway is closed.
+func (g *Gateway) add(s *session) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.closed {
+		return false
+	}
+	g.sessions[s] = struct{}{}
+	return true
+}
+
+// remove unregisters s.
+func (g *Gateway) remove(s *session) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	delete(g.sessions, s)
+}
+
+// session is a client connection.
+type session struct {
+	gateway *Gateway
+	conn    *websocket.Conn
+	req     *http.Request
+	out     chan Frame
+	cancel  context.CancelFunc
+
+	mu   sync.Mutex
+	subs map[string]scela.Subscription
+
+	closeOnce sync.Once
+}
+
+// run serves the connection until it is closed or ctx is done.
+func (s *session) run(ctx context.Context) {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.writeLoop(ctx)
+	}()
+
+	for {
+		_, data, err := s.conn.Read(ctx)
+		if err != nil {
+			break
+		}
+		var f Frame
+		if err := json.Unmarshal(data, &f); err != nil {
+			s.send(Frame{Type: TypeError, Error: fmt.Sprintf("invalid frame: %v", err)})
+			continue
+		}
+		s.handle(f)
+	}
+
+	s.unsubscribeAll()
+	s.close(websocket.StatusNormalClosure, "")
+	<-done
+}
+
+// handle serves a frame sent by the client.
+func (s *session) handle(f Frame) {
+	switch f.Type {
+	case TypeSubscribe:
+		if err := s.subscribe(f.Pattern); err != nil {
+			s.send(Frame{Type: TypeError, Pattern: f.Pattern, Error: err.Error()})
+			return
+		}
+		s.send(Frame{Type: TypeSubscribed, Pattern: f.Pattern})
+	case TypeUnsubscribe:
+		s.unsubscribe(f.Pattern)
+		s.send(Frame{Type: TypeUnsubscribed, Pattern: f.Pattern})
+	default:
+		s.send(Frame{Type: TypeError, Pattern: f.Pattern, Error: fmt.Sprintf("unknown frame type %q", f.Type)})
+	}
+}
+
+// subscribe subscribes the client to pattern, if it is authorized to.
+// Subscribing twice to a pattern has no effect.
+func (s *session) subscribe(pattern string) error {
+	if pattern == "" {
+		return errors.New("missing pattern")
+	}
+	if authorize := s.gateway.authorize; authorize != nil {
+		if err := authorize(s.req, pattern); err != nil {
+			return err
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.subs[pattern]; ok {
+		return nil
+	}
+	sub, err := s.gateway.bus.Subscribe(pattern, scela.HandlerFunc(func(ctx context.Context, msg scela.Message) error {
+		data, err := scela.MarshalMessage(msg)
+		if err != nil {
+			s.send(Frame{Type: TypeError, Pattern: pattern, Error: fmt.Sprintf("cannot encode message %s: %v", msg.ID(), err)})
+			return nil
+		}
+		s.send(Frame{Type: TypeMessage, Pattern: pattern, Message: data})
+		return nil
+	}))
+	if err != nil {
+		return err
+	}
+	s.subs[pattern] = sub
+	return nil
+}
+
+// unsubscribe unsubscribes the client from pattern.
+func (s *session) unsubscribe(pattern string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if sub, ok := s.subs[pattern]; ok {
+		_ = sub.Unsubscribe()
+		delete(s.subs, pattern)
+	}
+}
+
+// unsubscribeAll removes the subscriptions of the client.
+func (s *session) unsubscribeAll() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for pattern, sub := range s.subs {
+		_ = sub.Unsubscribe()
+		delete(s.subs, pattern)
+	}
+}
+
+// send queues f for the client, disconnecting the client if its buffer is
+// full rather than blocking the bus.
+func (s *session) send(f Frame) {
+	select {
+	case s.out <- f:
+	default:
+		s.close(websocket.StatusPolicyViolation, "client too slow")
+	}
+}
+
+// writeLoop writes the queued frames until ctx is done.
+func (s *session) writeLoop(ctx context.Context) {
+	for {
+		select {
+		case f := <-s.out:
+			data, err := json.Marshal(f)
+			if err != nil {
+				continue
+			}
+			wctx, cancel := context.WithTimeout(ctx, s.gateway.writeTimeout)
+			err = s.conn.Write(wctx, websocket.MessageText, data)
+			cancel()
+			if err != nil {
+				s.close(websocket.StatusPolicyViolation, "write failed")
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// close closes the connection once, with code and reason. It does not
+// wait for the closing handshake.
+func (s *session) close(code websocket.StatusCode, reason string) {
+	s.closeOnce.Do(func() {
+		go func() {
+			_ = s.conn.Close(code, reason)
+			s.cancel()
+		}()
+	})
+}
@@ -0,0 +1,227 @@
+package scelaws
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/coder/websocket/wsjson"
+	"github.com/toutaio/toutago-scela-bus/pkg/scela"
+)
+
+// dial connects a client to gateway.
+func dial(t *testing.T, gateway *Gateway) *websocket.Conn {
+	t.Helper()
+
+	srv := httptest.NewServer(gateway)
+	t.Cleanup(srv.Close)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, _, err := websocket.Dial(ctx, "ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	t.Cleanup(func() { _ = conn.CloseNow() })
+	return conn
+}
+
+// send writes f to conn.
+func send(t *testing.T, conn *websocket.Conn, f Frame) {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := wsjson.Write(ctx, conn, f); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+}
+
+// receive reads the next frame from conn.
+func receive(t *testing.T, conn *websocket.Conn) Frame {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var f Frame
+	if err := wsjson.Read(ctx, conn, &f); err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	return f
+}
+
+// subscriptions returns the number of subscriptions of bus.
+func subscriptions(t *testing.T, bus scela.Bus) int {
+	t.Helper()
+
+	infos, ok := scela.InspectSubscriptions(bus)
+	if !ok {
+		t.Fatal("bus cannot list its subscriptions")
+	}
+	return len(infos)
+}
+
+func TestGateway_SubscribeAndReceive(t *testing.T) {
+	bus := scela.New()
+	defer bus.Close()
+	conn := dial(t, New(bus))
+
+	send(t, conn, Frame{Type: TypeSubscribe, Pattern: "orders.*"})
+	if f := receive(t, conn); f.Type != TypeSubscribed || f.Pattern != "orders.*" {
+		t.Fatalf("got %+v, want subscribed to orders.*", f)
+	}
+
+	if err := bus.PublishSync(context.Background(), "users.created", "ignored"); err != nil {
+		t.Fatalf("PublishSync: %v", err)
+	}
+	if err := bus.PublishSync(context.Background(), "orders.created", map[string]string{"id": "o-1"}); err != nil {
+		t.Fatalf("PublishSync: %v", err)
+	}
+
+	f := receive(t, conn)
+	if f.Type != TypeMessage || f.Pattern != "orders.*" {
+		t.Fatalf("got %+v, want a message for orders.*", f)
+	}
+	msg, err := scela.UnmarshalMessage(f.Message)
+	if err != nil {
+		t.Fatalf("UnmarshalMessage: %v", err)
+	}
+	if msg.Topic() != "orders.created" {
+		t.Errorf("topic = %q, want orders.created", msg.Topic())
+	}
+	if payload, _ := msg.Payload().(map[string]interface{}); payload["id"] != "o-1" {
+		t.Errorf("payload = %v, want id o-1", msg.Payload())
+	}
+}
+
+func TestGateway_Authorize(t *testing.T) {
+	bus := scela.New()
+	defer bus.Close()
+	gateway := New(bus, WithAuthorize(func(r *http.Request, pattern string) error {
+		if !strings.HasPrefix(pattern, "public.") {
+			return errors.New("forbidden")
+		}
+		return nil
+	}))
+	conn := dial(t, gateway)
+
+	send(t, conn, Frame{Type: TypeSubscribe, Pattern: "private.*"})
+	if f := receive(t, conn); f.Type != TypeError || f.Error != "forbidden" {
+		t.Fatalf("got %+v, want a forbidden error", f)
+	}
+	if n := subscriptions(t, bus); n != 0 {
+		t.Errorf("bus has %d subscriptions, want 0", n)
+	}
+
+	send(t, conn, Frame{Type: TypeSubscribe, Pattern: "public.*"})
+	if f := receive(t, conn); f.Type != TypeSubscribed {
+		t.Fatalf("got %+v, want subscribed", f)
+	}
+}
+
+func TestGateway_Unsubscribe(t *testing.T) {
+	bus := scela.New()
+	defer bus.Close()
+	conn := dial(t, New(bus))
+
+	send(t, conn, Frame{Type: TypeSubscribe, Pattern: "orders.*"})
+	receive(t, conn)
+	send(t, conn, Frame{Type: TypeSubscribe, Pattern: "orders.*"})
+	receive(t, conn)
+	if n := subscriptions(t, bus); n != 1 {
+		t.Fatalf("bus has %d subscriptions, want 1", n)
+	}
+
+	send(t, conn, Frame{Type: TypeUnsubscribe, Pattern: "orders.*"})
+	if f := receive(t, conn); f.Type != TypeUnsubscribed {
+		t.Fatalf("got %+v, want unsubscribed", f)
+	}
+	if n := subscriptions(t, bus); n != 0 {
+		t.Errorf("bus has %d subscriptions, want 0", n)
+	}
+}
+
+func TestGateway_InvalidFrames(t *testing.T) {
+	bus := scela.New()
+	defer bus.Close()
+	conn := dial(t, New(bus))
+
+	send(t, conn, Frame{Type: "publish", Pattern: "orders.*"})
+	if f := receive(t, conn); f.Type != TypeError {
+		t.Errorf("got %+v for an unknown type, want an error", f)
+	}
+	send(t, conn, Frame{Type: TypeSubscribe})
+	if f := receive(t, conn); f.Type != TypeError {
+		t.Errorf("got %+v for a missing pattern, want an error", f)
+	}
+}
+
+func TestGateway_DisconnectUnsubscribes(t *testing.T) {
+	bus := scela.New()
+	defer bus.Close()
+	conn := dial(t, New(bus))
+
+	send(t, conn, Frame{Type: TypeSubscribe, Pattern: "orders.*"})
+	receive(t, conn)
+	_ = conn.Close(websocket.StatusNormalClosure, "")
+
+	deadline := time.Now().Add(5 * time.Second)
+	for subscriptions(t, bus) != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("subscriptions not removed after the client disconnected")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestGateway_Close(t *testing.T) {
+	bus := scela.New()
+	defer bus.Close()
+	gateway := New(bus)
+	conn := dial(t, gateway)
+
+	send(t, conn, Frame{Type: TypeSubscribe, Pattern: "orders.*"})
+	receive(t, conn)
+	if err := gateway.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, _, err := conn.Read(ctx)
+	if status := websocket.CloseStatus(err); status != websocket.StatusGoingAway {
+		t.Errorf("close status = %v (%v), want StatusGoingAway", status, err)
+	}
+}
+
+func TestGateway_DisconnectsSlowClients(t *testing.T) {
+	bus := scela.New()
+	defer bus.Close()
+	conn := dial(t, New(bus, WithBufferSize(1)))
+
+	send(t, conn, Frame{Type: TypeSubscribe, Pattern: "orders.*"})
+	receive(t, conn)
+
+	// The client reads nothing while messages pile up
+	for i := 0; i < 10000; i++ {
+		if err := bus.PublishSync(context.Background(), "orders.created", strings.Repeat("x", 1024)); err != nil {
+			t.Fatalf("PublishSync: %v", err)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for {
+		if _, _, err := conn.Read(ctx); err != nil {
+			if status := websocket.CloseStatus(err); status != websocket.StatusPolicyViolation {
+				t.Errorf("close status = %v (%v), want StatusPolicyViolation", status, err)
+			}
+			return
+		}
+	}
+}
@@ -0,0 +1,9 @@
+module github.com/toutaio/toutago-scela-bus/pkg/scela/scelaws
+
+go 1.22.9
+
+require github.com/toutaio/toutago-scela-bus v0.0.0
+
+require github.com/coder/websocket v1.8.12
+
+replace github.com/toutaio/toutago-scela-bus => ../../..
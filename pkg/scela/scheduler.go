@@ -0,0 +1,224 @@
+package scela
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Scheduler decides the order in which queued messages are handed to
+// workers, replacing the default per-priority queues. It makes it possible
+// to dispatch deadline-first, share workers fairly between tenants or weight
+// topics without changing the bus.
+//
+// The bus serializes calls to a Scheduler, so implementations need no
+// locking, and bounds the number of queued messages itself.
+type Scheduler interface {
+	// Add queues an envelope.
+	Add(env *Envelope)
+	// Next removes and returns the envelope to dispatch next. It is only
+	// called while envelopes are queued and must then return one of them.
+	Next() *Envelope
+}
+
+// WithScheduler dispatches queued messages in the order decided by s.
+// WithPriorityWeights has no effect on a bus with a scheduler.
+func WithScheduler(s Scheduler) Option {
+	return func(b *bus) {
+		b.scheduler = s
+	}
+}
+
+// Message returns the queued message.
+func (e *Envelope) Message() Message {
+	return e.msg
+}
+
+// Priority returns the priority the message is queued with, which may be
+// higher than the message priority for escalated retries.
+func (e *Envelope) Priority() Priority {
+	return e.priority
+}
+
+// Attempt returns the number of failed delivery attempts so far, zero for a
+// message that was not retried.
+func (e *Envelope) Attempt() int {
+	return e.retries
+}
+
+// Deadline returns the delivery deadline of the message, if it has one (see
+// ContextWithDeliveryDeadline and WithContextPropagation). Messages still
+// queued when it passes are dropped.
+func (e *Envelope) Deadline() (time.Time, bool) {
+	if e.ctx == nil || e.ctx.deadline.IsZero() {
+		return time.Time{}, false
+	}
+	return e.ctx.deadline, true
+}
+
+// NewFIFOScheduler returns a Scheduler dispatching messages in the order
+// they were queued, regardless of their priority.
+func NewFIFOScheduler() Scheduler {
+	return &fifoScheduler{}
+}
+
+// fifoScheduler is a first-in, first-out Scheduler.
+type fifoScheduler struct {
+	queue []*Envelope
+}
+
+// Add implements Scheduler.
+func (s *fifoScheduler) Add(env *Envelope) {
+	s.queue = append(s.queue, env)
+}
+
+// Next implements Scheduler.
+func (s *fifoScheduler) Next() *Envelope {
+	if len(s.queue) == 0 {
+		return nil
+	}
+	env := s.queue[0]
+	s.queue[0] = nil
+	s.queue = s.queue[1:]
+	return env
+}
+
+// schedulerQueue is a dispatchQueue ordered by a Scheduler.
+type schedulerQueue struct {
+	sched Scheduler
+
+	// slots bounds the number of queued messages
+	slots chan struct{}
+
+	mu     sync.Mutex
+	ready  *sync.Cond
+	closed bool
+	counts [priorityLevels]int
+	n      int
+}
+
+// newSchedulerQueue creates a queue holding up to capacity messages.
+func newSchedulerQueue(sched Scheduler, capacity int) *schedulerQueue {
+	q := &schedulerQueue{
+		sched: sched,
+		slots: make(chan struct{}, capacity),
+	}
+	q.ready = sync.NewCond(&q.mu)
+	return q
+}
+
+// push implements dispatchQueue.
+func (q *schedulerQueue) push(ctx context.Context, env *Envelope, policy OverflowPolicy) ([]*Envelope, error) {
+	select {
+	case q.slots <- struct{}{}:
+		q.add(env)
+		return nil, nil
+	default:
+	}
+
+	switch policy {
+	case OverflowDropNewest:
+		return []*Envelope{env}, nil
+	case OverflowErrorFast:
+		return nil, queueFull(env)
+	case OverflowDropOldest:
+		// Hand the slot of the next message over to env
+		q.mu.Lock()
+		if q.n > 0 {
+			if old := q.sched.Next(); old != nil {
+				q.counts[clampPriority(old.priority)]--
+				q.sched.Add(env)
+				q.counts[clampPriority(env.priority)]++
+				q.mu.Unlock()
+				return []*Envelope{old}, nil
+			}
+		}
+		q.mu.Unlock()
+	}
+
+	select {
+	case q.slots <- struct{}{}:
+		q.add(env)
+		return nil, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// add hands env to the scheduler once it has a slot.
+func (q *schedulerQueue) add(env *Envelope) {
+	q.mu.Lock()
+	q.sched.Add(env)
+	q.counts[clampPriority(env.priority)]++
+	q.n++
+	q.mu.Unlock()
+
+	q.ready.Signal()
+}
+
+// reader implements dispatchQueue. All workers share the scheduler.
+func (q *schedulerQueue) reader(worker int) envelopeSource {
+	return q
+}
+
+// next implements envelopeSource.
+func (q *schedulerQueue) next() (*Envelope, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for {
+		for q.n == 0 && !q.closed {
+			q.ready.Wait()
+		}
+		if q.n == 0 {
+			return nil, false
+		}
+
+		env := q.sched.Next()
+		if env == nil {
+			// The scheduler lost track of its envelopes; do not wait on them
+			for ; q.n > 0; q.n-- {
+				<-q.slots
+			}
+			q.counts = [priorityLevels]int{}
+			continue
+		}
+		q.counts[clampPriority(env.priority)]--
+		q.n--
+		<-q.slots
+		return env, true
+	}
+}
+
+// len implements dispatchQueue.
+func (q *schedulerQueue) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.n
+}
+
+// cap implements dispatchQueue.
+func (q *schedulerQueue) cap() int {
+	return cap(q.slots)
+}
+
+// depths implements dispatchQueue.
+func (q *schedulerQueue) depths() map[Priority]int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	depths := make(map[Priority]int, priorityLevels)
+	for i, n := range q.counts {
+		depths[Priority(i)] = n
+	}
+	return depths
+}
+
+// close implements dispatchQueue.
+func (q *schedulerQueue) close() {
+	q.mu.Lock()
+	q.closed = true
+	q.mu.Unlock()
+
+	q.ready.Broadcast()
+}
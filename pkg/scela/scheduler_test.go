@@ -0,0 +1,182 @@
+package scela
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+// deadlineScheduler dispatches the message with the earliest delivery
+// deadline first; messages without a deadline go last, in queue order.
+type deadlineScheduler struct {
+	queue []*Envelope
+}
+
+func (s *deadlineScheduler) Add(env *Envelope) {
+	s.queue = append(s.queue, env)
+	sort.SliceStable(s.queue, func(i, j int) bool {
+		di, iok := s.queue[i].Deadline()
+		dj, jok := s.queue[j].Deadline()
+		if iok != jok {
+			return iok
+		}
+		return iok && di.Before(dj)
+	})
+}
+
+func (s *deadlineScheduler) Next() *Envelope {
+	env := s.queue[0]
+	s.queue = s.queue[1:]
+	return env
+}
+
+// blockWorker publishes a message that keeps the only worker of bus busy
+// until the returned function is called.
+func blockWorker(t *testing.T, bus Bus) func() {
+	t.Helper()
+	release := make(chan struct{})
+	started := make(chan struct{})
+	_, _ = bus.Subscribe("block", HandlerFunc(func(ctx context.Context, msg Message) error {
+		close(started)
+		<-release
+		return nil
+	}))
+	_ = bus.Publish(context.Background(), "block", nil)
+	<-started
+	return func() { close(release) }
+}
+
+func TestWithScheduler_DeadlineFirst(t *testing.T) {
+	bus := New(WithWorkers(1), WithScheduler(&deadlineScheduler{}))
+	defer bus.Close()
+
+	var mu sync.Mutex
+	var order []string
+	done := make(chan struct{}, 3)
+	_, _ = bus.Subscribe("work", HandlerFunc(func(ctx context.Context, msg Message) error {
+		mu.Lock()
+		order = append(order, msg.Payload().(string))
+		mu.Unlock()
+		done <- struct{}{}
+		return nil
+	}))
+
+	release := blockWorker(t, bus)
+
+	ctx := context.Background()
+	now := time.Now()
+	_ = bus.PublishWithPriority(ctx, "work", "none", PriorityUrgent)
+	_ = bus.Publish(ContextWithDeliveryDeadline(ctx, now.Add(time.Hour)), "work", "later")
+	_ = bus.Publish(ContextWithDeliveryDeadline(ctx, now.Add(time.Minute)), "work", "sooner")
+
+	stats, _ := StatsOf(bus)
+	if stats.QueueDepth != 3 || stats.QueueDepths[PriorityNormal] != 2 || stats.QueueDepths[PriorityUrgent] != 1 {
+		t.Errorf("unexpected queue depths %+v", stats)
+	}
+
+	release()
+	for i := 0; i < 3; i++ {
+		<-done
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []string{"sooner", "later", "none"}
+	for i := range want {
+		if order[i] != want[i] {
+			t.Fatalf("expected %v, got %v", want, order)
+		}
+	}
+}
+
+func TestFIFOScheduler(t *testing.T) {
+	bus := New(WithWorkers(1), WithScheduler(NewFIFOScheduler()))
+
+	var mu sync.Mutex
+	var order []int
+	_, _ = bus.Subscribe("work", HandlerFunc(func(ctx context.Context, msg Message) error {
+		mu.Lock()
+		order = append(order, msg.Payload().(int))
+		mu.Unlock()
+		return nil
+	}))
+
+	release := blockWorker(t, bus)
+	for i := 0; i < 5; i++ {
+		_ = bus.PublishWithPriority(context.Background(), "work", i, Priority(i%priorityLevels))
+	}
+	release()
+
+	// Close delivers the queued messages before returning
+	_ = bus.Close()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(order) != 5 {
+		t.Fatalf("expected 5 messages, got %v", order)
+	}
+	for i, v := range order {
+		if v != i {
+			t.Fatalf("expected queue order, got %v", order)
+		}
+	}
+}
+
+func TestWithScheduler_Retries(t *testing.T) {
+	s := &recordingScheduler{Scheduler: NewFIFOScheduler()}
+	bus := New(WithScheduler(s), WithMaxRetries(3))
+	defer bus.Close()
+
+	_, _ = bus.Subscribe("fail", HandlerFunc(func(ctx context.Context, msg Message) error {
+		return errors.New("handler error")
+	}))
+	_ = bus.Publish(context.Background(), "fail", nil)
+
+	// Retries go through the scheduler with their attempt count
+	waitFor(t, func() bool { return len(s.attempts()) == 3 })
+	for want, got := range s.attempts() {
+		if got != want {
+			t.Errorf("expected attempt %d, got %d", want, got)
+		}
+	}
+}
+
+// recordingScheduler records the attempt of every envelope it is given.
+type recordingScheduler struct {
+	Scheduler
+	mu   sync.Mutex
+	seen []int
+}
+
+func (s *recordingScheduler) Add(env *Envelope) {
+	s.mu.Lock()
+	s.seen = append(s.seen, env.Attempt())
+	s.mu.Unlock()
+	s.Scheduler.Add(env)
+}
+
+func (s *recordingScheduler) attempts() []int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]int(nil), s.seen...)
+}
+
+func TestEnvelope_Accessors(t *testing.T) {
+	msg := NewMessage("topic", nil)
+	env := &Envelope{msg: msg, priority: PriorityHigh, retries: 2}
+	if env.Message() != msg || env.Priority() != PriorityHigh || env.Attempt() != 2 {
+		t.Errorf("unexpected accessors on %+v", env)
+	}
+	if _, ok := env.Deadline(); ok {
+		t.Error("expected no deadline")
+	}
+
+	deadline := time.Now().Add(time.Minute)
+	env.ctx = &capturedContext{deadline: deadline}
+	if got, ok := env.Deadline(); !ok || !got.Equal(deadline) {
+		t.Errorf("Deadline() = %v, %v", got, ok)
+	}
+}
@@ -0,0 +1,500 @@
+package scela
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// ErrSchemaViolation matches the SchemaError returned for a payload that
+// does not conform to the schema of its topic.
+var ErrSchemaViolation = errors.New("schema violation")
+
+// Schema checks the payloads published on a topic.
+type Schema interface {
+	// Validate returns why payload does not conform, if it does not.
+	Validate(payload interface{}) error
+}
+
+// SchemaFunc adapts a function to the Schema interface.
+type SchemaFunc func(payload interface{}) error
+
+// Validate implements Schema.
+func (f SchemaFunc) Validate(payload interface{}) error {
+	return f(payload)
+}
+
+// StructSchema returns a Schema accepting the payloads PayloadAs converts
+// to T, which includes running Validate on payloads implementing Validator.
+func StructSchema[T any]() Schema {
+	return SchemaFunc(func(payload interface{}) error {
+		_, err := PayloadAs[T](payload)
+		return err
+	})
+}
+
+// SchemaError reports a payload that does not conform to the schema of its
+// topic. It matches ErrSchemaViolation and the error of the schema, and is
+// permanent (see Permanent), so a message failing ValidationMiddleware is
+// dead-lettered without retries.
+type SchemaError struct {
+	Topic string
+	// Pattern is the pattern the schema was registered for.
+	Pattern string
+	Err     error
+}
+
+// Error implements the error interface.
+func (e *SchemaError) Error() string {
+	return fmt.Sprintf("payload on %s does not conform to the schema of %s: %v", e.Topic, e.Pattern, e.Err)
+}
+
+// Unwrap returns the cause, ErrSchemaViolation and the permanent marker.
+func (e *SchemaError) Unwrap() []error {
+	return []error{e.Err, ErrSchemaViolation, errPermanent}
+}
+
+// SchemaRegistry holds the schemas of topics. Payloads are checked against
+// the schema of every pattern matching their topic; topics no pattern
+// matches are not checked.
+type SchemaRegistry struct {
+	mu       sync.RWMutex
+	schemas  map[string]Schema
+	patterns []string
+	matcher  *patternMatcher
+}
+
+// NewSchemaRegistry creates an empty schema registry.
+func NewSchemaRegistry() *SchemaRegistry {
+	return &SchemaRegistry{
+		schemas: make(map[string]Schema),
+		matcher: newPatternMatcher(),
+	}
+}
+
+// Register sets the schema of the topics matching pattern, replacing the
+// one registered before for the same pattern.
+func (r *SchemaRegistry) Register(pattern string, schema Schema) error {
+	if pattern == "" {
+		return fmt.Errorf("%w: schema pattern cannot be empty", ErrInvalidPattern)
+	}
+	if schema == nil {
+		return fmt.Errorf("schema of %s cannot be nil", pattern)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exists := r.schemas[pattern]; !exists {
+		r.patterns = append(r.patterns, pattern)
+		sort.Strings(r.patterns)
+	}
+	r.schemas[pattern] = schema
+	return nil
+}
+
+// RegisterJSONSchema compiles a JSON Schema with CompileJSONSchema and
+// registers it for pattern.
+func (r *SchemaRegistry) RegisterJSONSchema(pattern string, schema []byte) error {
+	compiled, err := CompileJSONSchema(schema)
+	if err != nil {
+		return fmt.Errorf("schema of %s: %w", pattern, err)
+	}
+	return r.Register(pattern, compiled)
+}
+
+// Unregister removes the schema of pattern.
+func (r *SchemaRegistry) Unregister(pattern string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exists := r.schemas[pattern]; !exists {
+		return
+	}
+	delete(r.schemas, pattern)
+	for i, p := range r.patterns {
+		if p == pattern {
+			r.patterns = append(r.patterns[:i], r.patterns[i+1:]...)
+			break
+		}
+	}
+}
+
+// Patterns returns the patterns with a schema, sorted.
+func (r *SchemaRegistry) Patterns() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return append([]string(nil), r.patterns...)
+}
+
+// Validate checks payload against the schemas of topic and returns a
+// SchemaError for the first it does not conform to.
+func (r *SchemaRegistry) Validate(topic string, payload interface{}) error {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, pattern := range r.patterns {
+		if !r.matcher.Match(pattern, topic) {
+			continue
+		}
+		if err := r.schemas[pattern].Validate(payload); err != nil {
+			return &SchemaError{Topic: topic, Pattern: pattern, Err: err}
+		}
+	}
+	return nil
+}
+
+// ValidationMiddleware returns a middleware rejecting the messages whose
+// payload does not conform to the schemas of registry, before they reach
+// the handlers. The SchemaError is permanent, so such messages are
+// dead-lettered if the bus has a dead letter handler, and dropped
+// otherwise.
+func ValidationMiddleware(registry *SchemaRegistry) Middleware {
+	return func(next Handler) Handler {
+		return HandlerFunc(func(ctx context.Context, msg Message) error {
+			if err := registry.Validate(msg.Topic(), msg.Payload()); err != nil {
+				return err
+			}
+			return next.Handle(ctx, msg)
+		})
+	}
+}
+
+// WithSchemaValidation makes the publish methods of the bus check payloads
+// against the schemas of registry, returning a SchemaError without
+// publishing those that do not conform.
+func WithSchemaValidation(registry *SchemaRegistry) Option {
+	return func(b *bus) {
+		b.schemas = registry
+	}
+}
+
+// CompileJSONSchema compiles a JSON Schema. Payloads are validated in their
+// JSON encoding, as stores and bridges write them.
+//
+// The keywords supported are type, enum, const, properties, required,
+// additionalProperties, items, minItems, maxItems, minLength, maxLength,
+// pattern, minimum, maximum, exclusiveMinimum, exclusiveMaximum, allOf,
+// anyOf, oneOf and not. Other keywords, such as $ref and format, are
+// ignored.
+func CompileJSONSchema(schema []byte) (Schema, error) {
+	var s jsonSchema
+	if err := json.Unmarshal(schema, &s); err != nil {
+		return nil, fmt.Errorf("invalid JSON schema: %w", err)
+	}
+	if err := s.compile(); err != nil {
+		return nil, fmt.Errorf("invalid JSON schema: %w", err)
+	}
+	return &s, nil
+}
+
+// jsonSchema is a compiled JSON Schema.
+type jsonSchema struct {
+	// boolean is set for the schemas true and false.
+	boolean *bool
+
+	Type                 schemaTypes            `json:"type"`
+	Enum                 []interface{}          `json:"enum"`
+	Const                json.RawMessage        `json:"const"`
+	Properties           map[string]*jsonSchema `json:"properties"`
+	Required             []string               `json:"required"`
+	AdditionalProperties *jsonSchema            `json:"additionalProperties"`
+	Items                *jsonSchema            `json:"items"`
+	MinItems             *int                   `json:"minItems"`
+	MaxItems             *int                   `json:"maxItems"`
+	MinLength            *int                   `json:"minLength"`
+	MaxLength            *int                   `json:"maxLength"`
+	Pattern              string                 `json:"pattern"`
+	Minimum              *float64               `json:"minimum"`
+	Maximum              *float64               `json:"maximum"`
+	ExclusiveMinimum     *float64               `json:"exclusiveMinimum"`
+	ExclusiveMaximum     *float64               `json:"exclusiveMaximum"`
+	AllOf                []*jsonSchema          `json:"allOf"`
+	AnyOf                []*jsonSchema          `json:"anyOf"`
+	OneOf                []*jsonSchema          `json:"oneOf"`
+	Not                  *jsonSchema            `json:"not"`
+
+	constValue interface{}
+	pattern    *regexp.Regexp
+}
+
+// UnmarshalJSON implements json.Unmarshaler, accepting boolean schemas.
+func (s *jsonSchema) UnmarshalJSON(data []byte) error {
+	var b bool
+	if err := json.Unmarshal(data, &b); err == nil {
+		s.boolean = &b
+		return nil
+	}
+	type plain jsonSchema
+	return json.Unmarshal(data, (*plain)(s))
+}
+
+// compile validates the keywords of s and its subschemas and prepares
+// them.
+func (s *jsonSchema) compile() error {
+	if s == nil || s.boolean != nil {
+		return nil
+	}
+	for _, t := range s.Type {
+		switch t {
+		case "null", "boolean", "object", "array", "number", "integer", "string":
+		default:
+			return fmt.Errorf("unknown type %q", t)
+		}
+	}
+	if s.Const != nil {
+		if err := json.Unmarshal(s.Const, &s.constValue); err != nil {
+			return err
+		}
+	}
+	if s.Pattern != "" {
+		re, err := regexp.Compile(s.Pattern)
+		if err != nil {
+			return fmt.Errorf("invalid pattern: %w", err)
+		}
+		s.pattern = re
+	}
+
+	subschemas := []*jsonSchema{s.AdditionalProperties, s.Items, s.Not}
+	for _, p := range s.Properties {
+		subschemas = append(subschemas, p)
+	}
+	subschemas = append(subschemas, s.AllOf...)
+	subschemas = append(subschemas, s.AnyOf...)
+	subschemas = append(subschemas, s.OneOf...)
+	for _, sub := range subschemas {
+		if err := sub.compile(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Validate implements Schema.
+func (s *jsonSchema) Validate(payload interface{}) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("cannot encode payload: %w", err)
+	}
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return fmt.Errorf("cannot decode payload: %w", err)
+	}
+	return s.validate("$", value)
+}
+
+// validate checks value, found at path, against s.
+func (s *jsonSchema) validate(path string, value interface{}) error {
+	if s.boolean != nil {
+		if !*s.boolean {
+			return fmt.Errorf("%s: no value is allowed", path)
+		}
+		return nil
+	}
+
+	if len(s.Type) > 0 && !s.Type.match(value) {
+		return fmt.Errorf("%s: expected %s, got %s", path, strings.Join(s.Type, " or "), jsonType(value))
+	}
+	if s.Enum != nil && !containsValue(s.Enum, value) {
+		return fmt.Errorf("%s: value is not one of the allowed values", path)
+	}
+	if s.Const != nil && !reflect.DeepEqual(s.constValue, value) {
+		return fmt.Errorf("%s: value is not %s", path, s.Const)
+	}
+
+	var err error
+	switch v := value.(type) {
+	case map[string]interface{}:
+		err = s.validateObject(path, v)
+	case []interface{}:
+		err = s.validateArray(path, v)
+	case string:
+		err = s.validateString(path, v)
+	case float64:
+		err = s.validateNumber(path, v)
+	}
+	if err != nil {
+		return err
+	}
+	return s.validateCombinations(path, value)
+}
+
+func (s *jsonSchema) validateObject(path string, v map[string]interface{}) error {
+	for _, name := range s.Required {
+		if _, ok := v[name]; !ok {
+			return fmt.Errorf("%s: missing required property %q", path, name)
+		}
+	}
+
+	names := make([]string, 0, len(v))
+	for name := range v {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		sub, ok := s.Properties[name]
+		if !ok {
+			sub = s.AdditionalProperties
+		}
+		if sub == nil {
+			continue
+		}
+		if err := sub.validate(path+"."+name, v[name]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *jsonSchema) validateArray(path string, v []interface{}) error {
+	if s.MinItems != nil && len(v) < *s.MinItems {
+		return fmt.Errorf("%s: expected at least %d items, got %d", path, *s.MinItems, len(v))
+	}
+	if s.MaxItems != nil && len(v) > *s.MaxItems {
+		return fmt.Errorf("%s: expected at most %d items, got %d", path, *s.MaxItems, len(v))
+	}
+	if s.Items == nil {
+		return nil
+	}
+	for i, item := range v {
+		if err := s.Items.validate(fmt.Sprintf("%s[%d]", path, i), item); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *jsonSchema) validateString(path string, v string) error {
+	n := utf8.RuneCountInString(v)
+	if s.MinLength != nil && n < *s.MinLength {
+		return fmt.Errorf("%s: expected at least %d characters, got %d", path, *s.MinLength, n)
+	}
+	if s.MaxLength != nil && n > *s.MaxLength {
+		return fmt.Errorf("%s: expected at most %d characters, got %d", path, *s.MaxLength, n)
+	}
+	if s.pattern != nil && !s.pattern.MatchString(v) {
+		return fmt.Errorf("%s: %q does not match %s", path, v, s.Pattern)
+	}
+	return nil
+}
+
+func (s *jsonSchema) validateNumber(path string, v float64) error {
+	switch {
+	case s.Minimum != nil && v < *s.Minimum:
+		return fmt.Errorf("%s: %v is less than %v", path, v, *s.Minimum)
+	case s.Maximum != nil && v > *s.Maximum:
+		return fmt.Errorf("%s: %v is greater than %v", path, v, *s.Maximum)
+	case s.ExclusiveMinimum != nil && v <= *s.ExclusiveMinimum:
+		return fmt.Errorf("%s: %v is not greater than %v", path, v, *s.ExclusiveMinimum)
+	case s.ExclusiveMaximum != nil && v >= *s.ExclusiveMaximum:
+		return fmt.Errorf("%s: %v is not less than %v", path, v, *s.ExclusiveMaximum)
+	}
+	return nil
+}
+
+func (s *jsonSchema) validateCombinations(path string, value interface{}) error {
+	for _, sub := range s.AllOf {
+		if err := sub.validate(path, value); err != nil {
+			return err
+		}
+	}
+
+	if s.AnyOf != nil {
+		matched := false
+		for _, sub := range s.AnyOf {
+			if sub.validate(path, value) == nil {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return fmt.Errorf("%s: value matches none of anyOf", path)
+		}
+	}
+
+	if s.OneOf != nil {
+		matched := 0
+		for _, sub := range s.OneOf {
+			if sub.validate(path, value) == nil {
+				matched++
+			}
+		}
+		if matched != 1 {
+			return fmt.Errorf("%s: value matches %d of oneOf, expected exactly 1", path, matched)
+		}
+	}
+
+	if s.Not != nil && s.Not.validate(path, value) == nil {
+		return fmt.Errorf("%s: value matches not", path)
+	}
+	return nil
+}
+
+// schemaTypes is the type keyword, a type name or a list of them.
+type schemaTypes []string
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (t *schemaTypes) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err == nil {
+		*t = schemaTypes{name}
+		return nil
+	}
+	return json.Unmarshal(data, (*[]string)(t))
+}
+
+// match reports whether value is of one of the types.
+func (t schemaTypes) match(value interface{}) bool {
+	actual := jsonType(value)
+	for _, name := range t {
+		if name == actual {
+			return true
+		}
+		if name == "number" && actual == "integer" {
+			return true
+		}
+	}
+	return false
+}
+
+// jsonType returns the JSON Schema type of a decoded JSON value, integer
+// for numbers without a fractional part.
+func jsonType(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	case string:
+		return "string"
+	case float64:
+		if v == math.Trunc(v) && !math.IsInf(v, 0) {
+			return "integer"
+		}
+		return "number"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+// containsValue reports whether values holds value.
+func containsValue(values []interface{}, value interface{}) bool {
+	for _, v := range values {
+		if reflect.DeepEqual(v, value) {
+			return true
+		}
+	}
+	return false
+}
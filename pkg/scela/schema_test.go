@@ -0,0 +1,200 @@
+package scela
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+const orderSchema = `{
+	"type": "object",
+	"required": ["id", "total"],
+	"properties": {
+		"id": {"type": "string", "pattern": "^o-[0-9]+$"},
+		"total": {"type": "number", "minimum": 0},
+		"status": {"enum": ["new", "paid"]},
+		"lines": {
+			"type": "array",
+			"minItems": 1,
+			"items": {"type": "object", "properties": {"qty": {"type": "integer", "exclusiveMinimum": 0}}}
+		}
+	},
+	"additionalProperties": false
+}`
+
+func TestCompileJSONSchema(t *testing.T) {
+	schema, err := CompileJSONSchema([]byte(orderSchema))
+	if err != nil {
+		t.Fatalf("CompileJSONSchema() error = %v", err)
+	}
+
+	type order struct {
+		ID    string  `json:"id"`
+		Total float64 `json:"total"`
+	}
+	valid := []interface{}{
+		map[string]interface{}{"id": "o-1", "total": 10},
+		map[string]interface{}{"id": "o-2", "total": 0, "status": "paid", "lines": []interface{}{map[string]interface{}{"qty": 2}}},
+		order{ID: "o-3", Total: 1.5},
+	}
+	for _, payload := range valid {
+		if err := schema.Validate(payload); err != nil {
+			t.Errorf("Validate(%v) error = %v", payload, err)
+		}
+	}
+
+	invalid := map[string]interface{}{
+		"not an object":     "o-1",
+		"missing total":     map[string]interface{}{"id": "o-1"},
+		"pattern":           map[string]interface{}{"id": "x", "total": 1},
+		"minimum":           map[string]interface{}{"id": "o-1", "total": -1},
+		"enum":              map[string]interface{}{"id": "o-1", "total": 1, "status": "lost"},
+		"minItems":          map[string]interface{}{"id": "o-1", "total": 1, "lines": []interface{}{}},
+		"integer":           map[string]interface{}{"id": "o-1", "total": 1, "lines": []interface{}{map[string]interface{}{"qty": 1.5}}},
+		"exclusiveMinimum":  map[string]interface{}{"id": "o-1", "total": 1, "lines": []interface{}{map[string]interface{}{"qty": 0}}},
+		"extra property":    map[string]interface{}{"id": "o-1", "total": 1, "note": "x"},
+		"unencodable value": make(chan int),
+	}
+	for name, payload := range invalid {
+		if err := schema.Validate(payload); err == nil {
+			t.Errorf("%s: expected Validate to fail", name)
+		}
+	}
+
+	combined, err := CompileJSONSchema([]byte(`{"oneOf": [{"type": "string"}, {"type": "integer"}], "not": {"const": 0}}`))
+	if err != nil {
+		t.Fatalf("CompileJSONSchema() error = %v", err)
+	}
+	if err := combined.Validate(3); err != nil {
+		t.Errorf("Validate(3) error = %v", err)
+	}
+	for _, payload := range []interface{}{0, 1.5, nil} {
+		if err := combined.Validate(payload); err == nil {
+			t.Errorf("expected Validate(%v) to fail", payload)
+		}
+	}
+
+	for _, bad := range []string{`{"type": "text"}`, `{"pattern": "("}`, `[`} {
+		if _, err := CompileJSONSchema([]byte(bad)); err == nil {
+			t.Errorf("expected CompileJSONSchema(%s) to fail", bad)
+		}
+	}
+}
+
+type schemaOrder struct {
+	ID string `json:"id"`
+}
+
+func (o schemaOrder) Validate() error {
+	if o.ID == "" {
+		return errors.New("missing id")
+	}
+	return nil
+}
+
+func TestSchemaRegistry(t *testing.T) {
+	registry := NewSchemaRegistry()
+	if err := registry.RegisterJSONSchema("order.*", []byte(`{"type": "object", "required": ["id"]}`)); err != nil {
+		t.Fatalf("RegisterJSONSchema() error = %v", err)
+	}
+	if err := registry.Register("order.created", StructSchema[schemaOrder]()); err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+	if err := registry.Register("", StructSchema[schemaOrder]()); !errors.Is(err, ErrInvalidPattern) {
+		t.Errorf("expected an empty pattern rejected, got %v", err)
+	}
+
+	if err := registry.Validate("order.created", map[string]interface{}{"id": "o-1"}); err != nil {
+		t.Errorf("Validate() of a valid payload error = %v", err)
+	}
+	if err := registry.Validate("user.created", "anything"); err != nil {
+		t.Errorf("expected topics without a schema unchecked, got %v", err)
+	}
+
+	// Both matching schemas apply
+	err := registry.Validate("order.created", map[string]interface{}{"id": ""})
+	var serr *SchemaError
+	if !errors.As(err, &serr) || serr.Pattern != "order.created" {
+		t.Fatalf("expected a SchemaError of the struct schema, got %v", err)
+	}
+	if !errors.Is(err, ErrSchemaViolation) || !IsPermanent(err) {
+		t.Errorf("expected a permanent schema violation, got %v", err)
+	}
+
+	registry.Unregister("order.created")
+	if got := registry.Patterns(); len(got) != 1 || got[0] != "order.*" {
+		t.Errorf("Patterns() = %v", got)
+	}
+}
+
+func TestWithSchemaValidation(t *testing.T) {
+	registry := NewSchemaRegistry()
+	_ = registry.Register("order.#", StructSchema[schemaOrder]())
+
+	bus := New(WithSchemaValidation(registry))
+	defer bus.Close()
+
+	delivered := make(chan Message, 1)
+	_, _ = bus.Subscribe("order.created", HandlerFunc(func(ctx context.Context, msg Message) error {
+		delivered <- msg
+		return nil
+	}))
+
+	ctx := context.Background()
+	for name, publish := range map[string]func() error{
+		"Publish":             func() error { return bus.Publish(ctx, "order.created", schemaOrder{}) },
+		"PublishSync":         func() error { return bus.PublishSync(ctx, "order.created", schemaOrder{}) },
+		"PublishWithPriority": func() error { return bus.PublishWithPriority(ctx, "order.created", schemaOrder{}, PriorityHigh) },
+	} {
+		if err := publish(); !errors.Is(err, ErrSchemaViolation) {
+			t.Errorf("%s: expected a schema violation, got %v", name, err)
+		}
+	}
+
+	if err := bus.PublishSync(ctx, "order.created", schemaOrder{ID: "o-1"}); err != nil {
+		t.Fatalf("PublishSync() of a valid payload error = %v", err)
+	}
+	if msg := <-delivered; msg.Payload().(schemaOrder).ID != "o-1" {
+		t.Errorf("unexpected message %v", msg.Payload())
+	}
+}
+
+func TestValidationMiddleware_DeadLetters(t *testing.T) {
+	registry := NewSchemaRegistry()
+	_ = registry.RegisterJSONSchema("order.*", []byte(`{"required": ["id"]}`))
+
+	dead := make(chan Message, 1)
+	bus := New(WithMaxRetries(3), WithDeadLetterHandler(HandlerFunc(func(ctx context.Context, msg Message) error {
+		dead <- msg
+		return nil
+	})))
+	defer bus.Close()
+	bus.Use(ValidationMiddleware(registry))
+
+	handled := make(chan Message, 1)
+	_, _ = bus.Subscribe("order.*", HandlerFunc(func(ctx context.Context, msg Message) error {
+		handled <- msg
+		return nil
+	}))
+
+	_ = bus.Publish(context.Background(), "order.created", map[string]interface{}{"total": 1})
+	select {
+	case msg := <-dead:
+		if msg.Metadata()[MetadataDeadLetterAttempts] != 1 {
+			t.Errorf("expected no retries, got %v attempts", msg.Metadata()[MetadataDeadLetterAttempts])
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the message to be dead-lettered")
+	}
+
+	_ = bus.Publish(context.Background(), "order.created", map[string]interface{}{"id": "o-1"})
+	select {
+	case <-handled:
+	case <-time.After(time.Second):
+		t.Fatal("expected the valid message handled")
+	}
+	if len(handled) != 0 {
+		t.Error("expected the invalid message not handled")
+	}
+}
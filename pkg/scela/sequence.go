@@ -0,0 +1,155 @@
+package scela
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"sync"
+)
+
+// MetadataSequence holds the sequence number of a message within its topic,
+// starting at 1, as stamped by WithSequenceNumbers or by an external system.
+const MetadataSequence = "sequence"
+
+// WithSequenceNumbers numbers the messages published on each topic, 1, 2,
+// 3..., under MetadataSequence, unless they already carry a number or are
+// replies to a request. The numbers let replays and bridges detect lost
+// messages, see GapObserver.
+func WithSequenceNumbers() Option {
+	return func(b *bus) {
+		b.sequences = newTopicSequences()
+	}
+}
+
+// Sequence returns the sequence number of msg within its topic. It reports
+// false if msg has none.
+func Sequence(msg Message) (uint64, bool) {
+	switch v := msg.Metadata()[MetadataSequence].(type) {
+	case uint64:
+		return v, true
+	case int:
+		return uint64(v), v >= 0
+	case int64:
+		return uint64(v), v >= 0
+	case float64:
+		// Numbers decoded from JSON
+		return uint64(v), v >= 0 && v == float64(uint64(v))
+	case json.Number:
+		n, err := strconv.ParseUint(v.String(), 10, 64)
+		return n, err == nil
+	case string:
+		n, err := strconv.ParseUint(v, 10, 64)
+		return n, err == nil
+	default:
+		return 0, false
+	}
+}
+
+// topicSequences hands out the next sequence number of each topic.
+type topicSequences struct {
+	mu   sync.Mutex
+	last map[string]uint64
+}
+
+// newTopicSequences creates sequences starting at 1 for every topic.
+func newTopicSequences() *topicSequences {
+	return &topicSequences{last: make(map[string]uint64)}
+}
+
+// stamp numbers msg after the previous message on its topic, unless it
+// already has a number. Replies are not numbered, as their topics only live
+// for a single request.
+func (s *topicSequences) stamp(msg Message) {
+	if _, ok := msg.Metadata()[MetadataSequence]; ok || IsReplyTopic(msg.Topic()) {
+		return
+	}
+	s.mu.Lock()
+	s.last[msg.Topic()]++
+	n := s.last[msg.Topic()]
+	s.mu.Unlock()
+	msg.Metadata()[MetadataSequence] = n
+}
+
+// SequenceGap describes messages missing from a topic: sequence numbers
+// from Expected up to, but excluding, Received were never seen.
+type SequenceGap struct {
+	Topic    string
+	Expected uint64
+	Received uint64
+	// Message is the message received after the gap.
+	Message Message
+}
+
+// Missing returns the number of messages missing.
+func (g SequenceGap) Missing() uint64 {
+	return g.Received - g.Expected
+}
+
+// GapObserver is an optional extension of Observer. Observers implementing
+// it are notified when a replay or ConsumeFrom finds sequence numbers
+// missing from a topic, revealing lost messages.
+type GapObserver interface {
+	OnSequenceGap(ctx context.Context, gap SequenceGap)
+}
+
+// GapDetector finds the sequence numbers missing from each topic of a
+// stream of messages. The first number seen on a topic is its starting
+// point. Messages without a number, repeated or out of order ones, as
+// redeliveries are, are ignored. It is safe for concurrent use.
+type GapDetector struct {
+	mu   sync.Mutex
+	last map[string]uint64
+}
+
+// NewGapDetector creates a detector that has seen no message yet.
+func NewGapDetector() *GapDetector {
+	return &GapDetector{last: make(map[string]uint64)}
+}
+
+// Check records msg and returns the gap before it, if any.
+func (d *GapDetector) Check(msg Message) (SequenceGap, bool) {
+	n, ok := Sequence(msg)
+	if !ok {
+		return SequenceGap{}, false
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	last, seen := d.last[msg.Topic()]
+	if seen && n <= last {
+		return SequenceGap{}, false
+	}
+	d.last[msg.Topic()] = n
+	if !seen || n == last+1 {
+		return SequenceGap{}, false
+	}
+	return SequenceGap{Topic: msg.Topic(), Expected: last + 1, Received: n, Message: msg}, true
+}
+
+// gapNotifier is implemented by buses that forward sequence gaps to their
+// observers.
+type gapNotifier interface {
+	notifySequenceGap(ctx context.Context, gap SequenceGap)
+}
+
+// notifySequenceGap implements gapNotifier.
+func (b *bus) notifySequenceGap(ctx context.Context, gap SequenceGap) {
+	b.observers.NotifySequenceGap(ctx, gap)
+}
+
+// reportGap checks msg with d and notifies the observers of b of the gap
+// before it, if any. It reports whether there was a gap.
+func reportGap(ctx context.Context, b Bus, d *GapDetector, msg Message) bool {
+	gap, ok := d.Check(msg)
+	if !ok {
+		return false
+	}
+	for inner := b; inner != nil; inner = innerBus(inner) {
+		if n, ok := inner.(gapNotifier); ok {
+			n.notifySequenceGap(ctx, gap)
+			break
+		}
+	}
+	return true
+}
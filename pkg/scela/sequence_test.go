@@ -0,0 +1,149 @@
+package scela
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"testing"
+)
+
+// gapObserver records the sequence gaps it is told about.
+type gapObserver struct {
+	countingObserver
+	gapMu sync.Mutex
+	gaps  []SequenceGap
+}
+
+func (o *gapObserver) OnSequenceGap(ctx context.Context, gap SequenceGap) {
+	o.gapMu.Lock()
+	defer o.gapMu.Unlock()
+	o.gaps = append(o.gaps, gap)
+}
+
+func (o *gapObserver) recorded() []SequenceGap {
+	o.gapMu.Lock()
+	defer o.gapMu.Unlock()
+	return append([]SequenceGap(nil), o.gaps...)
+}
+
+// numbered returns a message on topic with sequence number n.
+func numbered(topic string, n uint64) Message {
+	msg := NewMessage(topic, n)
+	msg.Metadata()[MetadataSequence] = n
+	return msg
+}
+
+func TestWithSequenceNumbers(t *testing.T) {
+	store := NewInMemoryStore(0)
+	pb := NewPersistentBus(New(WithSequenceNumbers()), store)
+	defer pb.Close()
+
+	ctx := context.Background()
+	for _, topic := range []string{"orders", "users", "orders"} {
+		_ = pb.Publish(ctx, topic, "p")
+	}
+	own := NewMessage("orders", "p")
+	own.Metadata()[MetadataSequence] = uint64(42)
+	_ = pb.Bus.(messagePublisher).publishMessages(ctx, []Message{own}, false)
+
+	msgs, _ := store.Load(ctx)
+	want := []uint64{1, 1, 2}
+	for i, msg := range msgs {
+		if n, ok := Sequence(msg); !ok || n != want[i] {
+			t.Errorf("message %d on %s has sequence %d, %v, want %d", i, msg.Topic(), n, ok, want[i])
+		}
+	}
+	if n, _ := Sequence(own); n != 42 {
+		t.Errorf("expected an existing number to be kept, got %d", n)
+	}
+}
+
+func TestSequence_Formats(t *testing.T) {
+	for _, v := range []interface{}{uint64(7), 7, int64(7), float64(7), json.Number("7"), "7"} {
+		msg := NewMessage("t", nil)
+		msg.Metadata()[MetadataSequence] = v
+		if n, ok := Sequence(msg); !ok || n != 7 {
+			t.Errorf("Sequence(%T) = %d, %v", v, n, ok)
+		}
+	}
+	for _, v := range []interface{}{-1, 1.5, "x", nil} {
+		msg := NewMessage("t", nil)
+		msg.Metadata()[MetadataSequence] = v
+		if _, ok := Sequence(msg); ok {
+			t.Errorf("expected no sequence for %#v", v)
+		}
+	}
+}
+
+func TestGapDetector(t *testing.T) {
+	d := NewGapDetector()
+
+	var gaps []SequenceGap
+	for _, msg := range []Message{
+		numbered("orders", 3), // starting point
+		numbered("orders", 4),
+		numbered("users", 1),
+		numbered("orders", 4), // redelivered
+		numbered("orders", 7),
+		NewMessage("orders", "unnumbered"),
+		numbered("users", 2),
+		numbered("orders", 5), // late
+		numbered("orders", 8),
+	} {
+		if gap, ok := d.Check(msg); ok {
+			gaps = append(gaps, gap)
+		}
+	}
+
+	if len(gaps) != 1 {
+		t.Fatalf("expected one gap, got %+v", gaps)
+	}
+	gap := gaps[0]
+	if gap.Topic != "orders" || gap.Expected != 5 || gap.Received != 7 || gap.Missing() != 2 {
+		t.Errorf("unexpected gap %+v", gap)
+	}
+}
+
+func TestReplay_ReportsGaps(t *testing.T) {
+	obs := &gapObserver{}
+	store := NewInMemoryStore(0)
+	ctx := context.Background()
+	for _, n := range []uint64{1, 2, 5, 6} {
+		_ = store.Store(ctx, numbered("orders", n))
+	}
+
+	pb := NewPersistentBus(New(WithObserver(obs)), store)
+	defer pb.Close()
+
+	var last ReplayProgress
+	if err := pb.Replay(ctx, WithReplayProgress(func(p ReplayProgress) { last = p })); err != nil {
+		t.Fatalf("Replay() error = %v", err)
+	}
+
+	gaps := obs.recorded()
+	if len(gaps) != 1 || gaps[0].Expected != 3 || gaps[0].Received != 5 {
+		t.Errorf("unexpected gaps %+v", gaps)
+	}
+	if last.Gaps != 1 {
+		t.Errorf("expected the progress to count 1 gap, got %d", last.Gaps)
+	}
+}
+
+func TestConsumeFrom_ReportsGaps(t *testing.T) {
+	obs := &gapObserver{}
+	b := New(WithObserver(obs))
+	defer b.Close()
+
+	source := &stubSource{msgs: []Message{
+		numbered("orders", 10),
+		numbered("orders", 11),
+		numbered("orders", 13),
+	}}
+	if err := ConsumeFrom(context.Background(), source, b); err != nil {
+		t.Fatalf("ConsumeFrom() error = %v", err)
+	}
+	gaps := obs.recorded()
+	if len(gaps) != 1 || gaps[0].Expected != 12 || gaps[0].Missing() != 1 {
+		t.Errorf("unexpected gaps %+v", gaps)
+	}
+}
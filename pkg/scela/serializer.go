@@ -1,8 +1,10 @@
 package scela
 
 import (
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"time"
 )
 
 // Serializer defines the interface for message serialization.
@@ -32,8 +34,53 @@ func (s *JSONSerializer) Deserialize(data []byte, target interface{}) error {
 	return json.Unmarshal(data, target)
 }
 
-// GOBSerializer would use encoding/gob (not implemented - example)
-// ProtobufSerializer would use protobuf (not implemented - example)
+// BinarySerializer is implemented by serializers producing binary data,
+// such as the protobuf serializer of the scelaproto module. SQLStore and
+// FileStore keep their records as text, and store such data as base64.
+type BinarySerializer interface {
+	Serializer
+
+	// Binary reports whether the serialized data is binary.
+	Binary() bool
+}
+
+// MessageSerializer is implemented by serializers with an encoding of their
+// own for whole messages, which SerializableMessage.SerializeMessage and
+// DeserializeMessage use.
+type MessageSerializer interface {
+	Serializer
+
+	// SerializeMessage encodes msg with its ID, metadata, timestamp and
+	// priority.
+	SerializeMessage(msg Message) ([]byte, error)
+
+	// DeserializeMessage decodes a message encoded by SerializeMessage.
+	DeserializeMessage(data []byte) (Message, error)
+}
+
+// serializeText returns the textual form of the payload encoded as data by
+// serializer.
+func serializeText(serializer Serializer, data []byte) []byte {
+	if bs, ok := serializer.(BinarySerializer); ok && bs.Binary() {
+		text := make([]byte, base64.StdEncoding.EncodedLen(len(data)))
+		base64.StdEncoding.Encode(text, data)
+		return text
+	}
+	return data
+}
+
+// deserializeText reverses serializeText.
+func deserializeText(serializer Serializer, text []byte) ([]byte, error) {
+	if bs, ok := serializer.(BinarySerializer); ok && bs.Binary() {
+		data := make([]byte, base64.StdEncoding.DecodedLen(len(text)))
+		n, err := base64.StdEncoding.Decode(data, text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid binary payload: %w", err)
+		}
+		return data[:n], nil
+	}
+	return text, nil
+}
 
 // SerializableMessage wraps a message with serialization capability.
 type SerializableMessage struct {
@@ -62,8 +109,12 @@ func (sm *SerializableMessage) Serialize() ([]byte, error) {
 	return sm.serializer.Serialize(sm.msg.Payload())
 }
 
-// SerializeMessage serializes an entire message including metadata.
+// SerializeMessage serializes an entire message including metadata, with
+// the encoding of the serializer if it is a MessageSerializer.
 func (sm *SerializableMessage) SerializeMessage() ([]byte, error) {
+	if ms, ok := sm.serializer.(MessageSerializer); ok {
+		return ms.SerializeMessage(sm.msg)
+	}
 	data := map[string]interface{}{
 		"id":        sm.msg.ID(),
 		"topic":     sm.msg.Topic(),
@@ -74,11 +125,15 @@ func (sm *SerializableMessage) SerializeMessage() ([]byte, error) {
 	return sm.serializer.Serialize(data)
 }
 
-// DeserializeMessage deserializes a complete message.
+// DeserializeMessage deserializes a complete message. Only MessageSerializer
+// implementations restore its ID, metadata, timestamp and priority.
 func DeserializeMessage(data []byte, serializer Serializer) (Message, error) {
 	if serializer == nil {
 		serializer = NewJSONSerializer()
 	}
+	if ms, ok := serializer.(MessageSerializer); ok {
+		return ms.DeserializeMessage(data)
+	}
 
 	var msgData map[string]interface{}
 	if err := serializer.Deserialize(data, &msgData); err != nil {
@@ -95,3 +150,62 @@ func DeserializeMessage(data []byte, serializer Serializer) (Message, error) {
 	msg := NewMessage(topic, payload)
 	return msg, nil
 }
+
+// wireMessage is the encoding of MarshalMessage.
+type wireMessage struct {
+	ID        string                 `json:"id"`
+	Topic     string                 `json:"topic"`
+	Payload   json.RawMessage        `json:"payload,omitempty"`
+	Metadata  map[string]interface{} `json:"metadata,omitempty"`
+	Timestamp time.Time              `json:"timestamp"`
+	Priority  Priority               `json:"priority,omitempty"`
+}
+
+// MarshalMessage encodes msg as JSON with its ID, metadata, timestamp and
+// priority, for stores and bridges outside this package that must restore
+// the message as it was published. UnmarshalMessage decodes it.
+func MarshalMessage(msg Message) ([]byte, error) {
+	payload, err := json.Marshal(msg.Payload())
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize payload: %w", err)
+	}
+	return json.Marshal(wireMessage{
+		ID:        msg.ID(),
+		Topic:     msg.Topic(),
+		Payload:   payload,
+		Metadata:  msg.Metadata(),
+		Timestamp: msg.Timestamp(),
+		Priority:  MessagePriority(msg),
+	})
+}
+
+// UnmarshalMessage decodes a message encoded by MarshalMessage. Payloads
+// are decoded like the stores of this package decode them, as maps,
+// slices and float64s; see PayloadAs.
+func UnmarshalMessage(data []byte) (Message, error) {
+	var wm wireMessage
+	if err := json.Unmarshal(data, &wm); err != nil {
+		return nil, fmt.Errorf("invalid message: %w", err)
+	}
+	if wm.Topic == "" {
+		return nil, fmt.Errorf("invalid message format: missing topic")
+	}
+
+	var payload interface{}
+	if len(wm.Payload) > 0 {
+		if err := json.Unmarshal(wm.Payload, &payload); err != nil {
+			return nil, fmt.Errorf("failed to decode payload: %w", err)
+		}
+	}
+	if wm.Metadata == nil {
+		wm.Metadata = make(map[string]interface{})
+	}
+	return &message{
+		id:        wm.ID,
+		topic:     wm.Topic,
+		payload:   payload,
+		metadata:  wm.Metadata,
+		timestamp: wm.Timestamp,
+		priority:  wm.Priority,
+	}, nil
+}
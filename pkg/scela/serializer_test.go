@@ -1,6 +1,10 @@
 package scela
 
 import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -61,3 +65,124 @@ func TestSerializableMessage_Full(t *testing.T) {
 		t.Errorf("Expected topic 'test.topic', got %s", deserializedMsg.Topic())
 	}
 }
+
+func TestMarshalMessage(t *testing.T) {
+	msg := NewMessageWithPriority("orders.created", map[string]interface{}{"id": "o-1"}, PriorityHigh)
+	msg.Metadata()["tenant"] = "acme"
+
+	data, err := MarshalMessage(msg)
+	if err != nil {
+		t.Fatalf("MarshalMessage() error = %v", err)
+	}
+	got, err := UnmarshalMessage(data)
+	if err != nil {
+		t.Fatalf("UnmarshalMessage() error = %v", err)
+	}
+
+	if got.ID() != msg.ID() || got.Topic() != msg.Topic() || !got.Timestamp().Equal(msg.Timestamp()) {
+		t.Errorf("got %s %s %v, want %s %s %v", got.ID(), got.Topic(), got.Timestamp(), msg.ID(), msg.Topic(), msg.Timestamp())
+	}
+	if MessagePriority(got) != PriorityHigh || got.Metadata()["tenant"] != "acme" {
+		t.Errorf("priority %v and metadata %v not restored", MessagePriority(got), got.Metadata())
+	}
+	if order, _ := got.Payload().(map[string]interface{}); order["id"] != "o-1" {
+		t.Errorf("unexpected payload %v", got.Payload())
+	}
+
+	if _, err := UnmarshalMessage([]byte(`{"id":"x"}`)); err == nil {
+		t.Error("expected a message without a topic rejected")
+	}
+}
+
+// binarySerializer is a BinarySerializer prefixing the JSON form of
+// payloads with bytes that are not valid text.
+type binarySerializer struct {
+	JSONSerializer
+}
+
+func (s *binarySerializer) Serialize(payload interface{}) ([]byte, error) {
+	data, err := s.JSONSerializer.Serialize(payload)
+	return append([]byte{0xff, 0x00}, data...), err
+}
+
+func (s *binarySerializer) Deserialize(data []byte, target interface{}) error {
+	if len(data) < 2 || data[0] != 0xff || data[1] != 0x00 {
+		return fmt.Errorf("missing binary prefix")
+	}
+	return s.JSONSerializer.Deserialize(data[2:], target)
+}
+
+func (s *binarySerializer) Binary() bool { return true }
+
+func TestBinarySerializer_Stores(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	sqlStore, err := NewSQLStore(SQLStoreConfig{DB: db, Serializer: &binarySerializer{}})
+	if err != nil {
+		t.Fatalf("NewSQLStore() error = %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "messages.json")
+	stores := map[string]MessageStore{
+		"sql":  sqlStore,
+		"file": NewFileStore(path, WithFileSerializer(&binarySerializer{})),
+		"gzip": NewFileStore(path+".gz", WithFileSerializer(&binarySerializer{}), WithFileCompression(NewGzipCompressor(-1), 0)),
+	}
+
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			if err := store.Store(ctx, NewMessage("orders", map[string]interface{}{"id": "o-1", "note": strings.Repeat("x", 512)})); err != nil {
+				t.Fatalf("Store() error = %v", err)
+			}
+			loaded, err := store.Load(ctx)
+			if err != nil || len(loaded) != 1 {
+				t.Fatalf("Load() = %v, %v", loaded, err)
+			}
+			if order, _ := loaded[0].Payload().(map[string]interface{}); order["id"] != "o-1" {
+				t.Errorf("unexpected payload %v", loaded[0].Payload())
+			}
+		})
+	}
+
+	// Records written before the serializer was configured stay readable
+	legacy := filepath.Join(t.TempDir(), "legacy.json")
+	if err := NewFileStore(legacy).Store(context.Background(), NewMessage("orders", "data")); err != nil {
+		t.Fatalf("Store() error = %v", err)
+	}
+	loaded, err := NewFileStore(legacy, WithFileSerializer(&binarySerializer{})).Load(context.Background())
+	if err != nil || len(loaded) != 1 || loaded[0].Payload() != "data" {
+		t.Errorf("Load() = %v, %v", loaded, err)
+	}
+}
+
+// envelopeSerializer is a MessageSerializer keeping messages in memory.
+type envelopeSerializer struct {
+	JSONSerializer
+	messages []Message
+}
+
+func (s *envelopeSerializer) SerializeMessage(msg Message) ([]byte, error) {
+	s.messages = append(s.messages, msg)
+	return []byte{byte(len(s.messages) - 1)}, nil
+}
+
+func (s *envelopeSerializer) DeserializeMessage(data []byte) (Message, error) {
+	return s.messages[data[0]], nil
+}
+
+func TestSerializableMessage_MessageSerializer(t *testing.T) {
+	serializer := &envelopeSerializer{}
+	msg := NewMessageWithPriority("orders", "data", PriorityHigh)
+
+	data, err := NewSerializableMessage(msg, serializer).SerializeMessage()
+	if err != nil {
+		t.Fatalf("SerializeMessage() error = %v", err)
+	}
+	got, err := DeserializeMessage(data, serializer)
+	if err != nil {
+		t.Fatalf("DeserializeMessage() error = %v", err)
+	}
+	if got != msg {
+		t.Errorf("expected the message of the serializer, got %v", got)
+	}
+}
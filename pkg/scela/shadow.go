@@ -0,0 +1,402 @@
+package scela
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// ErrShadowTimeout is the error of a candidate handler that did not finish
+// within the shadow timeout.
+var ErrShadowTimeout = errors.New("shadow handler timed out")
+
+// ShadowBus runs candidate handlers, such as new versions of a handler
+// under test, against live traffic without letting them affect it: they
+// receive a copy of every message, their errors and panics are swallowed,
+// and messages they publish through the ShadowBus are dropped. The outcome
+// of a candidate is compared with that of the live handler it shadows, and
+// divergences are reported.
+//
+// Outcomes are compared by whether the handler failed, not by its error
+// text, and by the results the handlers record with RecordResult.
+type ShadowBus struct {
+	Bus
+	timeout      time.Duration
+	onDivergence func(Divergence)
+	capacity     int
+
+	mu    sync.Mutex
+	stats ShadowStats
+	// recorded holds the last live deliveries, a ring once full.
+	recorded []shadowRecord
+	next     int
+
+	// wg tracks the comparisons in flight.
+	wg sync.WaitGroup
+}
+
+// ShadowOption is a functional option for configuring a shadow bus.
+type ShadowOption func(*ShadowBus)
+
+// WithShadowTimeout bounds how long a candidate handler may run. It
+// defaults to 5s. Candidates still running then count as failed with
+// ErrShadowTimeout.
+func WithShadowTimeout(timeout time.Duration) ShadowOption {
+	return func(sb *ShadowBus) {
+		if timeout > 0 {
+			sb.timeout = timeout
+		}
+	}
+}
+
+// WithDivergenceHandler registers a function called with every divergence
+// between a live handler and its candidate. It is called from the
+// goroutine comparing them, never from the live delivery.
+func WithDivergenceHandler(fn func(Divergence)) ShadowOption {
+	return func(sb *ShadowBus) {
+		sb.onDivergence = fn
+	}
+}
+
+// WithShadowRecording keeps the last n live deliveries of shadowed
+// subscriptions, with their outcome, so candidates can be replayed
+// against them with ReplayShadow.
+func WithShadowRecording(n int) ShadowOption {
+	return func(sb *ShadowBus) {
+		if n > 0 {
+			sb.capacity = n
+		}
+	}
+}
+
+// Divergence describes a message on which a candidate handler behaved
+// differently from the live handler it shadows.
+type Divergence struct {
+	// Pattern is the pattern of the shadowed subscription.
+	Pattern string
+	Message Message
+	// LiveErr and CandidateErr are the errors the handlers returned.
+	LiveErr      error
+	CandidateErr error
+	// LiveResults and CandidateResults are the results the handlers
+	// recorded with RecordResult.
+	LiveResults      []interface{}
+	CandidateResults []interface{}
+}
+
+// ShadowStats describes the work of a shadow bus.
+type ShadowStats struct {
+	// Compared is the number of deliveries compared with a candidate.
+	Compared int64
+	// Diverged is the number of compared deliveries that diverged.
+	Diverged int64
+	// CandidateFailures is the number of candidate deliveries that failed,
+	// panicked or timed out.
+	CandidateFailures int64
+	// Suppressed is the number of messages candidates tried to publish.
+	Suppressed int64
+}
+
+// shadowRecord is a recorded live delivery.
+type shadowRecord struct {
+	pattern string
+	msg     Message
+	outcome shadowOutcome
+}
+
+// shadowOutcome is what a handler did with a message.
+type shadowOutcome struct {
+	err     error
+	results []interface{}
+}
+
+// diverges reports whether two outcomes differ.
+func (o shadowOutcome) diverges(other shadowOutcome) bool {
+	return (o.err == nil) != (other.err == nil) || !reflect.DeepEqual(o.results, other.results)
+}
+
+// NewShadowBus wraps bus to run shadow handlers on its traffic.
+func NewShadowBus(bus Bus, opts ...ShadowOption) *ShadowBus {
+	sb := &ShadowBus{
+		Bus:     bus,
+		timeout: 5 * time.Second,
+	}
+	for _, opt := range opts {
+		opt(sb)
+	}
+	return sb
+}
+
+// shadowKey is the context key marking candidate deliveries.
+type shadowKey struct{}
+
+// resultsKey is the context key of the results recorded by a handler.
+type resultsKey struct{}
+
+// resultRecorder collects the results recorded by a handler.
+type resultRecorder struct {
+	mu      sync.Mutex
+	results []interface{}
+}
+
+// IsShadow reports whether ctx belongs to a candidate delivery of a
+// ShadowBus. Candidates should skip side effects, such as writes to shared
+// databases or calls to external services, when it returns true.
+func IsShadow(ctx context.Context) bool {
+	shadow, _ := ctx.Value(shadowKey{}).(bool)
+	return shadow
+}
+
+// RecordResult records v as a result of the handler delivered ctx, to be
+// compared between a live handler and its candidate. It does nothing
+// outside subscriptions of a ShadowBus.
+func RecordResult(ctx context.Context, v interface{}) {
+	rec, ok := ctx.Value(resultsKey{}).(*resultRecorder)
+	if !ok {
+		return
+	}
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	rec.results = append(rec.results, v)
+}
+
+// runRecorded runs handler with a recorder for its results.
+func runRecorded(ctx context.Context, handler Handler, msg Message) shadowOutcome {
+	rec := &resultRecorder{}
+	err := handler.Handle(context.WithValue(ctx, resultsKey{}, rec), msg)
+
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	return shadowOutcome{err: err, results: rec.results}
+}
+
+// SubscribeShadowed subscribes live to pattern, like SubscribeWithOptions,
+// and runs candidate on a copy of every message live receives. Only live
+// affects the delivery: its error is the one retried and dead-lettered.
+// Each attempt of live is compared with a run of candidate.
+func (sb *ShadowBus) SubscribeShadowed(
+	pattern string, live, candidate Handler, opts ...SubscriptionOption,
+) (Subscription, error) {
+	return sb.Bus.SubscribeWithOptions(pattern, HandlerFunc(func(ctx context.Context, msg Message) error {
+		shadowed := sb.runCandidate(ctx, candidate, msg)
+
+		outcome := runRecorded(ctx, live, msg)
+		sb.record(pattern, msg, outcome)
+
+		sb.wg.Add(1)
+		go func() {
+			defer sb.wg.Done()
+			_, _ = sb.compare(pattern, msg, outcome, sb.await(shadowed))
+		}()
+		return outcome.err
+	}), opts...)
+}
+
+// Shadow subscribes candidate to pattern without a live handler to compare
+// it with: it receives a copy of every matching message, and its failures
+// are only counted.
+func (sb *ShadowBus) Shadow(pattern string, candidate Handler, opts ...SubscriptionOption) (Subscription, error) {
+	return sb.Bus.SubscribeWithOptions(pattern, HandlerFunc(func(ctx context.Context, msg Message) error {
+		shadowed := sb.runCandidate(ctx, candidate, msg)
+
+		sb.wg.Add(1)
+		go func() {
+			defer sb.wg.Done()
+			sb.await(shadowed)
+		}()
+		return nil
+	}), opts...)
+}
+
+// runCandidate starts candidate on a copy of msg, detached from the live
+// delivery, and returns where its outcome is sent.
+func (sb *ShadowBus) runCandidate(ctx context.Context, candidate Handler, msg Message) <-chan shadowOutcome {
+	// The candidate may change the metadata of its copy
+	shadowMsg := &message{
+		id:        msg.ID(),
+		topic:     msg.Topic(),
+		payload:   msg.Payload(),
+		metadata:  maps.Clone(msg.Metadata()),
+		timestamp: msg.Timestamp(),
+		priority:  MessagePriority(msg),
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), sb.timeout)
+	ctx = context.WithValue(ctx, shadowKey{}, true)
+	protected := RecoveryMiddleware()(candidate)
+
+	// Not tracked by wg: a candidate ignoring ctx must not block Close
+	done := make(chan shadowOutcome, 1)
+	go func() {
+		defer cancel()
+		done <- runRecorded(ctx, protected, shadowMsg)
+	}()
+	return done
+}
+
+// await waits for a candidate outcome, at most the shadow timeout, and
+// counts failures.
+func (sb *ShadowBus) await(done <-chan shadowOutcome) shadowOutcome {
+	timer := time.NewTimer(sb.timeout)
+	defer timer.Stop()
+
+	var outcome shadowOutcome
+	select {
+	case outcome = <-done:
+	case <-timer.C:
+		outcome = shadowOutcome{err: ErrShadowTimeout}
+	}
+
+	if outcome.err != nil {
+		sb.mu.Lock()
+		sb.stats.CandidateFailures++
+		sb.mu.Unlock()
+	}
+	return outcome
+}
+
+// compare checks whether a candidate diverged from the live handler,
+// reporting and returning the divergence if so.
+func (sb *ShadowBus) compare(pattern string, msg Message, live, candidate shadowOutcome) (Divergence, bool) {
+	diverged := live.diverges(candidate)
+
+	sb.mu.Lock()
+	sb.stats.Compared++
+	if diverged {
+		sb.stats.Diverged++
+	}
+	sb.mu.Unlock()
+
+	if !diverged {
+		return Divergence{}, false
+	}
+	d := Divergence{
+		Pattern:          pattern,
+		Message:          msg,
+		LiveErr:          live.err,
+		CandidateErr:     candidate.err,
+		LiveResults:      live.results,
+		CandidateResults: candidate.results,
+	}
+	if sb.onDivergence != nil {
+		sb.onDivergence(d)
+	}
+	return d, true
+}
+
+// record keeps a live delivery if recording is enabled.
+func (sb *ShadowBus) record(pattern string, msg Message, outcome shadowOutcome) {
+	if sb.capacity == 0 {
+		return
+	}
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+
+	rec := shadowRecord{pattern: pattern, msg: msg, outcome: outcome}
+	if len(sb.recorded) < sb.capacity {
+		sb.recorded = append(sb.recorded, rec)
+	} else {
+		sb.recorded[sb.next] = rec
+	}
+	sb.next = (sb.next + 1) % sb.capacity
+}
+
+// ReplayShadow runs candidate on the recorded live deliveries of pattern,
+// oldest first, and returns those on which it diverged. Divergences are
+// also reported to the divergence handler. Recording must be enabled with
+// WithShadowRecording.
+func (sb *ShadowBus) ReplayShadow(ctx context.Context, pattern string, candidate Handler) ([]Divergence, error) {
+	sb.mu.Lock()
+	records := make([]shadowRecord, 0, len(sb.recorded))
+	for i := range sb.recorded {
+		rec := sb.recorded[(sb.next+i)%len(sb.recorded)]
+		if rec.pattern == pattern {
+			records = append(records, rec)
+		}
+	}
+	sb.mu.Unlock()
+
+	var divergences []Divergence
+	for _, rec := range records {
+		if err := ctx.Err(); err != nil {
+			return divergences, err
+		}
+		outcome := sb.await(sb.runCandidate(ctx, candidate, rec.msg))
+		if d, diverged := sb.compare(pattern, rec.msg, rec.outcome, outcome); diverged {
+			divergences = append(divergences, d)
+		}
+	}
+	return divergences, nil
+}
+
+// suppressed reports whether a publish made with ctx comes from a
+// candidate, counting it if so.
+func (sb *ShadowBus) suppressed(ctx context.Context, n int) bool {
+	if !IsShadow(ctx) {
+		return false
+	}
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+	sb.stats.Suppressed += int64(n)
+	return true
+}
+
+// Publish publishes a message, unless called by a candidate handler.
+func (sb *ShadowBus) Publish(ctx context.Context, topic string, payload interface{}) error {
+	if sb.suppressed(ctx, 1) {
+		return nil
+	}
+	return sb.Bus.Publish(ctx, topic, payload)
+}
+
+// PublishSync publishes a message synchronously, unless called by a
+// candidate handler.
+func (sb *ShadowBus) PublishSync(ctx context.Context, topic string, payload interface{}) error {
+	if sb.suppressed(ctx, 1) {
+		return nil
+	}
+	return sb.Bus.PublishSync(ctx, topic, payload)
+}
+
+// PublishWithPriority publishes a message with priority, unless called by
+// a candidate handler.
+func (sb *ShadowBus) PublishWithPriority(
+	ctx context.Context, topic string, payload interface{}, priority Priority,
+) error {
+	if sb.suppressed(ctx, 1) {
+		return nil
+	}
+	return sb.Bus.PublishWithPriority(ctx, topic, payload, priority)
+}
+
+// PublishBatch publishes several messages, unless called by a candidate
+// handler.
+func (sb *ShadowBus) PublishBatch(ctx context.Context, batch []TopicPayload) error {
+	if sb.suppressed(ctx, len(batch)) {
+		return nil
+	}
+	return sb.Bus.PublishBatch(ctx, batch)
+}
+
+// ShadowStats returns the statistics of the shadow bus so far.
+func (sb *ShadowBus) ShadowStats() ShadowStats {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+	return sb.stats
+}
+
+// Wait blocks until the comparisons in flight are done, which waits for
+// each candidate at most the shadow timeout.
+func (sb *ShadowBus) Wait() {
+	sb.wg.Wait()
+}
+
+// Close closes the wrapped bus, then waits for the comparisons in flight.
+func (sb *ShadowBus) Close() error {
+	err := sb.Bus.Close()
+	sb.Wait()
+	return err
+}
@@ -0,0 +1,210 @@
+package scela
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// divergences collects the divergences reported by a shadow bus, which
+// compares deliveries concurrently.
+type divergences struct {
+	mu   sync.Mutex
+	list []Divergence
+}
+
+func (d *divergences) add(div Divergence) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.list = append(d.list, div)
+}
+
+// byPayload returns the divergences indexed by the payload of their
+// message.
+func (d *divergences) byPayload() map[interface{}]Divergence {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	divs := make(map[interface{}]Divergence, len(d.list))
+	for _, div := range d.list {
+		divs[div.Message.Payload()] = div
+	}
+	return divs
+}
+
+func TestShadowBus_ReportsDivergence(t *testing.T) {
+	var reported divergences
+	sb := NewShadowBus(New(), WithDivergenceHandler(reported.add))
+	defer sb.Close()
+
+	live := HandlerFunc(func(ctx context.Context, msg Message) error {
+		RecordResult(ctx, msg.Payload().(int)*2)
+		return nil
+	})
+	candidate := HandlerFunc(func(ctx context.Context, msg Message) error {
+		n := msg.Payload().(int)
+		switch n {
+		case 2:
+			RecordResult(ctx, n*3)
+		case 3:
+			return errors.New("candidate bug")
+		case 4:
+			panic("candidate crash")
+		default:
+			RecordResult(ctx, n*2)
+		}
+		return nil
+	})
+	if _, err := sb.SubscribeShadowed("numbers", live, candidate); err != nil {
+		t.Fatalf("SubscribeShadowed() error = %v", err)
+	}
+
+	ctx := context.Background()
+	for i := 1; i <= 4; i++ {
+		if err := sb.PublishSync(ctx, "numbers", i); err != nil {
+			t.Fatalf("expected the live flow unaffected by the candidate, got %v", err)
+		}
+	}
+	sb.Wait()
+
+	divs := reported.byPayload()
+	if _, ok := divs[1]; ok || len(divs) != 3 {
+		t.Errorf("divergences = %v, want 2, 3 and 4", divs)
+	}
+	stats := sb.ShadowStats()
+	if stats.Compared != 4 || stats.Diverged != 3 || stats.CandidateFailures != 2 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+
+	if d := divs[2]; fmt.Sprint(d.LiveResults, d.CandidateResults) != "[4] [6]" {
+		t.Errorf("unexpected results %v %v", d.LiveResults, d.CandidateResults)
+	}
+	if !errors.Is(divs[4].CandidateErr, ErrHandlerPanic) {
+		t.Errorf("expected the panic reported, got %v", divs[4].CandidateErr)
+	}
+}
+
+func TestShadowBus_SuppressesSideEffects(t *testing.T) {
+	sb := NewShadowBus(New())
+	defer sb.Close()
+
+	var mu sync.Mutex
+	var events []string
+	_, _ = sb.Subscribe("events", HandlerFunc(func(ctx context.Context, msg Message) error {
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, msg.Payload().(string))
+		return nil
+	}))
+
+	shadowed := make(chan bool, 1)
+	_, _ = sb.Shadow("orders", HandlerFunc(func(ctx context.Context, msg Message) error {
+		msg.Metadata()["touched"] = true
+		shadowed <- IsShadow(ctx)
+		_ = sb.PublishSync(ctx, "events", "from candidate")
+		return errors.New("ignored")
+	}))
+	delivered := make(chan Message, 1)
+	_, _ = sb.SubscribeShadowed("orders",
+		HandlerFunc(func(ctx context.Context, msg Message) error {
+			delivered <- msg
+			return sb.PublishSync(ctx, "events", "from live")
+		}),
+		HandlerFunc(func(ctx context.Context, msg Message) error {
+			return sb.PublishBatch(ctx, []TopicPayload{{Topic: "events", Payload: "batched"}})
+		}),
+	)
+
+	if err := sb.PublishSync(context.Background(), "orders", "o-1"); err != nil {
+		t.Fatalf("PublishSync() error = %v", err)
+	}
+	sb.Wait()
+	if !<-shadowed {
+		t.Error("expected the candidate to see a shadow context")
+	}
+	msg := <-delivered
+
+	mu.Lock()
+	defer mu.Unlock()
+	if fmt.Sprint(events) != "[from live]" {
+		t.Errorf("events = %v, want only the live one", events)
+	}
+	if _, ok := msg.Metadata()["touched"]; ok {
+		t.Error("expected the candidate to work on a copy of the message")
+	}
+	if stats := sb.ShadowStats(); stats.Suppressed != 2 || stats.CandidateFailures != 1 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+func TestShadowBus_Timeout(t *testing.T) {
+	var reported divergences
+	sb := NewShadowBus(New(), WithShadowTimeout(10*time.Millisecond), WithDivergenceHandler(reported.add))
+	defer sb.Close()
+
+	release := make(chan struct{})
+	defer close(release)
+	_, _ = sb.SubscribeShadowed("orders",
+		HandlerFunc(func(ctx context.Context, msg Message) error { return nil }),
+		HandlerFunc(func(ctx context.Context, msg Message) error {
+			<-release // ignores ctx
+			return nil
+		}),
+	)
+
+	_ = sb.PublishSync(context.Background(), "orders", "o-1")
+	sb.Wait()
+
+	reported.mu.Lock()
+	defer reported.mu.Unlock()
+	if len(reported.list) != 1 || !errors.Is(reported.list[0].CandidateErr, ErrShadowTimeout) {
+		t.Errorf("expected a timeout divergence, got %+v", reported.list)
+	}
+}
+
+func TestShadowBus_ReplayShadow(t *testing.T) {
+	sb := NewShadowBus(New(), WithShadowRecording(2))
+	defer sb.Close()
+
+	noop := HandlerFunc(func(ctx context.Context, msg Message) error { return nil })
+	_, _ = sb.SubscribeShadowed("orders", HandlerFunc(func(ctx context.Context, msg Message) error {
+		if msg.Payload() == "bad" {
+			return errors.New("rejected")
+		}
+		return nil
+	}), noop)
+	_, _ = sb.SubscribeShadowed("users", noop, noop)
+
+	ctx := context.Background()
+	for _, payload := range []string{"dropped", "ok", "bad"} {
+		_ = sb.PublishSync(ctx, "orders", payload)
+	}
+	_ = sb.PublishSync(ctx, "users", "u-1")
+	sb.Wait()
+
+	// Only the last two deliveries are kept, and one is on another pattern
+	var seen []interface{}
+	divs, err := sb.ReplayShadow(ctx, "orders", HandlerFunc(func(ctx context.Context, msg Message) error {
+		seen = append(seen, msg.Payload())
+		return nil
+	}))
+	if err != nil {
+		t.Fatalf("ReplayShadow() error = %v", err)
+	}
+	if fmt.Sprint(seen) != "[bad]" {
+		t.Errorf("replayed %v, want [bad]", seen)
+	}
+	if len(divs) != 1 || divs[0].LiveErr == nil || divs[0].CandidateErr != nil {
+		t.Errorf("unexpected divergences %+v", divs)
+	}
+}
+
+func TestRecordResult_OutsideShadowBus(t *testing.T) {
+	// Recording outside a shadowed subscription is harmless
+	RecordResult(context.Background(), 1)
+	if IsShadow(context.Background()) {
+		t.Error("expected a plain context not to be a shadow one")
+	}
+}
@@ -0,0 +1,113 @@
+package scela
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrRetryAbandoned is reported to observers for a failed message whose
+// retry was still pending when the bus closed.
+var ErrRetryAbandoned = errors.New("retry abandoned because the bus closed")
+
+// retryTracker keeps the retries waiting to be queued, so that Close can
+// abandon them before closing the queue instead of losing them silently.
+type retryTracker struct {
+	mu     sync.Mutex
+	closed bool
+	timers map[*Envelope]*time.Timer
+
+	// inflight counts the retries being queued.
+	inflight sync.WaitGroup
+
+	// ctx is canceled on close, to unblock retries waiting for room.
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// newRetryTracker creates an empty tracker.
+func newRetryTracker() *retryTracker {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &retryTracker{
+		timers: make(map[*Envelope]*time.Timer),
+		ctx:    ctx,
+		cancel: cancel,
+	}
+}
+
+// scheduleRetry queues env on the shared queue after delay, or right away
+// if delay is zero or less.
+func (b *bus) scheduleRetry(env *Envelope, delay time.Duration) {
+	t := b.retries
+	t.mu.Lock()
+	if t.closed {
+		t.mu.Unlock()
+		b.abandonRetry(env)
+		return
+	}
+	if delay > 0 {
+		t.timers[env] = time.AfterFunc(delay, func() { b.requeue(env) })
+		t.mu.Unlock()
+		return
+	}
+	t.inflight.Add(1)
+	t.mu.Unlock()
+
+	b.pushRetry(env)
+}
+
+// requeue puts a delayed retry back on the queue once it is due.
+func (b *bus) requeue(env *Envelope) {
+	t := b.retries
+	t.mu.Lock()
+	if _, ok := t.timers[env]; !ok {
+		// Abandoned by Close
+		t.mu.Unlock()
+		return
+	}
+	delete(t.timers, env)
+	t.inflight.Add(1)
+	t.mu.Unlock()
+
+	b.pushRetry(env)
+}
+
+// pushRetry queues env, waiting for room until the bus closes.
+func (b *bus) pushRetry(env *Envelope) {
+	defer b.retries.inflight.Done()
+
+	if _, err := b.queue.push(b.retries.ctx, env, OverflowBlock); err != nil {
+		b.abandonRetry(env)
+	}
+}
+
+// abandonRetry reports a retry that will not run.
+func (b *bus) abandonRetry(env *Envelope) {
+	b.observers.NotifyDrop(context.Background(), env.msg, ErrRetryAbandoned)
+}
+
+// closeRetries abandons the retries not queued yet and waits for those
+// being queued, after which no retry touches the queue.
+func (b *bus) closeRetries() {
+	t := b.retries
+	t.mu.Lock()
+	t.closed = true
+	timers := t.timers
+	t.timers = nil
+	t.mu.Unlock()
+
+	t.cancel()
+	for env, timer := range timers {
+		timer.Stop()
+		b.abandonRetry(env)
+	}
+	t.inflight.Wait()
+}
+
+// isClosing reports whether Close was called.
+func (b *bus) isClosing() bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.closed
+}
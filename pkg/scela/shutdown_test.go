@@ -0,0 +1,231 @@
+package scela
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// shutdownRecorder records failures and the close notification in order.
+type shutdownRecorder struct {
+	countingObserver
+	events []string
+}
+
+func (r *shutdownRecorder) OnMessageProcessed(ctx context.Context, msg Message, err error) {
+	if err == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	payload, _ := msg.Payload().(string)
+	r.events = append(r.events, payload+": "+err.Error())
+}
+
+func (r *shutdownRecorder) OnClose() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, "close")
+}
+
+func (r *shutdownRecorder) snapshot() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.events...)
+}
+
+// closeWhileBlocked closes b in the background and releases the blocked
+// worker once Close has started, returning when Close does.
+func closeWhileBlocked(t *testing.T, b Bus, release func()) {
+	t.Helper()
+	done := make(chan struct{})
+	go func() {
+		_ = b.Close()
+		close(done)
+	}()
+	waitFor(t, b.(*bus).isClosing)
+	release()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Close did not return")
+	}
+}
+
+func TestClose_DeadLettersDrainedMessages(t *testing.T) {
+	var dead atomic.Int32
+	b := New(
+		WithWorkers(1),
+		WithMaxRetries(0),
+		WithDeadLetterHandler(HandlerFunc(func(ctx context.Context, msg Message) error {
+			dead.Add(1)
+			return nil
+		})),
+	)
+	release := blockWorker(t, b)
+
+	_, _ = b.Subscribe("work", HandlerFunc(func(ctx context.Context, msg Message) error {
+		return errors.New("handler error")
+	}))
+	for i := 0; i < 5; i++ {
+		_ = b.Publish(context.Background(), "work", "job")
+	}
+
+	closeWhileBlocked(t, b, release)
+	if dead.Load() != 5 {
+		t.Errorf("expected 5 dead letters before Close returned, got %d", dead.Load())
+	}
+}
+
+func TestClose_DeadLetterTopicDuringDrain(t *testing.T) {
+	b := New(WithWorkers(1), WithMaxRetries(0))
+	release := blockWorker(t, b)
+
+	_, _ = b.SubscribeWithOptions("work", HandlerFunc(func(ctx context.Context, msg Message) error {
+		return errors.New("handler error")
+	}), WithSubscriptionDeadLetterTopic("dlq.work"))
+
+	var mu sync.Mutex
+	var dead []Message
+	_, _ = b.Subscribe("dlq.work", HandlerFunc(func(ctx context.Context, msg Message) error {
+		mu.Lock()
+		defer mu.Unlock()
+		dead = append(dead, msg)
+		return nil
+	}))
+	_ = b.Publish(context.Background(), "work", "job")
+
+	closeWhileBlocked(t, b, release)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(dead) != 1 || dead[0].Payload() != "job" {
+		t.Errorf("expected the dead letter delivered before Close returned, got %v", dead)
+	}
+}
+
+func TestClose_AbandonsPendingRetries(t *testing.T) {
+	obs := &shutdownRecorder{}
+	b := New(WithObserver(obs), WithRetryBackoff(ConstantBackoff(time.Minute)))
+
+	attempted := make(chan struct{}, 1)
+	_, _ = b.Subscribe("work", HandlerFunc(func(ctx context.Context, msg Message) error {
+		attempted <- struct{}{}
+		return errors.New("handler error")
+	}))
+	_ = b.Publish(context.Background(), "work", "delayed")
+	<-attempted
+
+	// Let the worker schedule the retry
+	waitFor(t, func() bool {
+		b.(*bus).retries.mu.Lock()
+		defer b.(*bus).retries.mu.Unlock()
+		return len(b.(*bus).retries.timers) == 1
+	})
+	_ = b.Close()
+
+	want := []string{"delayed: handler error", "delayed: " + ErrRetryAbandoned.Error(), "close"}
+	got := obs.snapshot()
+	if len(got) != len(want) {
+		t.Fatalf("expected events %v, got %v", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("expected events %v, got %v", want, got)
+		}
+	}
+}
+
+func TestClose_ImmediateRetryDuringDrain(t *testing.T) {
+	obs := &failureObserver{}
+	b := New(WithWorkers(1), WithMaxRetries(3), WithObserver(obs))
+	release := blockWorker(t, b)
+
+	var calls atomic.Int32
+	_, _ = b.Subscribe("work", HandlerFunc(func(ctx context.Context, msg Message) error {
+		calls.Add(1)
+		return errors.New("handler error")
+	}))
+	_ = b.Publish(context.Background(), "work", "job")
+
+	closeWhileBlocked(t, b, release)
+	if calls.Load() != 1 {
+		t.Errorf("expected the retry not to run after Close, got %d calls", calls.Load())
+	}
+	if err := obs.errorFor("job"); !errors.Is(err, ErrRetryAbandoned) {
+		t.Errorf("expected ErrRetryAbandoned, got %v", err)
+	}
+}
+
+func TestClose_KeyedRetryAbandoned(t *testing.T) {
+	obs := &failureObserver{}
+	b := New(WithObserver(obs), WithPartitions(1), WithRetryBackoff(ConstantBackoff(time.Minute)))
+
+	attempted := make(chan struct{}, 1)
+	_, _ = b.Subscribe("work", HandlerFunc(func(ctx context.Context, msg Message) error {
+		attempted <- struct{}{}
+		return errors.New("handler error")
+	}))
+	_ = PublishWithKey(context.Background(), b, "work", "k", "job")
+	<-attempted
+	_ = b.Close()
+
+	if err := obs.errorFor("job"); !errors.Is(err, ErrRetryAbandoned) {
+		t.Errorf("expected ErrRetryAbandoned, got %v", err)
+	}
+}
+
+// closeOrderStore records when it is closed relative to the writes it gets.
+type closeOrderStore struct {
+	*InMemoryStore
+	mu     sync.Mutex
+	closed bool
+	late   int
+}
+
+func (s *closeOrderStore) Store(ctx context.Context, msg Message) error {
+	s.mu.Lock()
+	if s.closed {
+		s.late++
+	}
+	s.mu.Unlock()
+	return s.InMemoryStore.Store(ctx, msg)
+}
+
+func (s *closeOrderStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	return nil
+}
+
+func TestPersistentBus_ClosesStoreLast(t *testing.T) {
+	store := &closeOrderStore{InMemoryStore: NewInMemoryStore(0)}
+	inner := New(WithWorkers(1), WithMaxRetries(0))
+	release := blockWorker(t, inner)
+	pb := NewPersistentBus(inner, store)
+
+	_, _ = pb.SubscribeWithOptions("work", HandlerFunc(func(ctx context.Context, msg Message) error {
+		return errors.New("handler error")
+	}), WithSubscriptionDeadLetterStore(store))
+	_ = pb.Publish(context.Background(), "work", "job")
+
+	done := make(chan error)
+	go func() { done <- pb.Close() }()
+	waitFor(t, inner.(*bus).isClosing)
+	release()
+	if err := <-done; err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	if store.late != 0 {
+		t.Errorf("expected no writes after the store closed, got %d", store.late)
+	}
+	if n, _ := store.Count(context.Background()); n != 2 {
+		t.Errorf("expected the message and its dead letter stored, got %d", n)
+	}
+}
@@ -0,0 +1,168 @@
+package scela
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TopicSLOViolation is the topic on which SLOMiddleware publishes an
+// SLOViolation when a service level objective is broken.
+const TopicSLOViolation = "$sys.slo.violation"
+
+// defaultSLOWindow is the number of messages an SLO is evaluated over by
+// default.
+const defaultSLOWindow = 100
+
+// SLO is a delivery expectation for a set of topics, such as 95% of the
+// "payments.*" messages handled within 200ms.
+type SLO struct {
+	// Pattern selects the topics the objective applies to.
+	Pattern string
+	// Latency is the time within which messages must be handled, measured
+	// from their publication, so that time spent queued counts.
+	Latency time.Duration
+	// Target is the fraction of messages that must be handled within
+	// Latency, between 0 and 1.
+	Target float64
+	// Window is the number of most recent messages Target applies to. It
+	// defaults to 100. The objective is only evaluated once that many
+	// messages were handled.
+	Window int
+}
+
+// SLOViolation reports that the messages handled within the latency of an
+// SLO fell below its target.
+type SLOViolation struct {
+	// SLO is the broken objective.
+	SLO SLO
+	// Topic is the topic of the message that broke it.
+	Topic string
+	// Compliance is the fraction of the window handled in time.
+	Compliance float64
+	// At is when the objective was broken.
+	At time.Time
+}
+
+// SLOObserver is an optional extension of Observer. Observers implementing
+// it are notified when SLOMiddleware finds an objective broken.
+type SLOObserver interface {
+	OnSLOViolation(ctx context.Context, v SLOViolation)
+}
+
+// sloNotifier is implemented by buses that forward SLO violations to their
+// observers.
+type sloNotifier interface {
+	notifySLOViolation(ctx context.Context, v SLOViolation)
+}
+
+// notifySLOViolation implements sloNotifier.
+func (b *bus) notifySLOViolation(ctx context.Context, v SLOViolation) {
+	b.observers.NotifySLOViolation(ctx, v)
+}
+
+// SLOMiddleware creates a middleware tracking how long the messages matching
+// each of slos take to be handled, counting failed attempts as late. When
+// the share of messages handled in time over the window of an objective
+// falls below its target, the violation is reported to the observers of b
+// implementing SLOObserver and published on b to TopicSLOViolation, with
+// the SLOViolation as payload. It is reported once, and again only after
+// the objective was met in between. Messages on "$sys." topics are not
+// tracked.
+func SLOMiddleware(b Bus, slos ...SLO) Middleware {
+	matcher := newPatternMatcher()
+	trackers := make([]*sloTracker, len(slos))
+	for i, slo := range slos {
+		trackers[i] = newSLOTracker(slo)
+	}
+
+	return func(next Handler) Handler {
+		return HandlerFunc(func(ctx context.Context, msg Message) error {
+			err := next.Handle(ctx, msg)
+			if strings.HasPrefix(msg.Topic(), "$sys.") {
+				return err
+			}
+
+			now := time.Now()
+			for _, t := range trackers {
+				if !matcher.Match(t.slo.Pattern, msg.Topic()) {
+					continue
+				}
+				inTime := err == nil && now.Sub(msg.Timestamp()) <= t.slo.Latency
+				if compliance, broken := t.record(inTime); broken {
+					reportSLOViolation(ctx, b, SLOViolation{
+						SLO:        t.slo,
+						Topic:      msg.Topic(),
+						Compliance: compliance,
+						At:         now,
+					})
+				}
+			}
+			return err
+		})
+	}
+}
+
+// reportSLOViolation notifies the observers of b of v and publishes it.
+func reportSLOViolation(ctx context.Context, b Bus, v SLOViolation) {
+	for inner := b; inner != nil; inner = innerBus(inner) {
+		if n, ok := inner.(sloNotifier); ok {
+			n.notifySLOViolation(ctx, v)
+			break
+		}
+	}
+	_ = b.Publish(ctx, TopicSLOViolation, v)
+}
+
+// sloTracker keeps the outcomes of the latest messages of an SLO.
+type sloTracker struct {
+	slo SLO
+
+	mu        sync.Mutex
+	outcomes  []bool
+	next      int
+	count     int
+	late      int
+	violating bool
+}
+
+// newSLOTracker creates a tracker for slo, applying the default window.
+func newSLOTracker(slo SLO) *sloTracker {
+	if slo.Window <= 0 {
+		slo.Window = defaultSLOWindow
+	}
+	return &sloTracker{
+		slo:      slo,
+		outcomes: make([]bool, slo.Window),
+	}
+}
+
+// record adds the outcome of a message and returns the compliance over the
+// window. It reports whether the objective just became broken.
+func (t *sloTracker) record(inTime bool) (float64, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.count == len(t.outcomes) {
+		if !t.outcomes[t.next] {
+			t.late--
+		}
+	} else {
+		t.count++
+	}
+	t.outcomes[t.next] = inTime
+	if !inTime {
+		t.late++
+	}
+	t.next = (t.next + 1) % len(t.outcomes)
+
+	if t.count < len(t.outcomes) {
+		return 1, false
+	}
+	compliance := float64(t.count-t.late) / float64(t.count)
+	met := compliance >= t.slo.Target
+	broken := !met && !t.violating
+	t.violating = !met
+	return compliance, broken
+}
@@ -0,0 +1,113 @@
+package scela
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// sloObserver records the SLO violations it is told about.
+type sloObserver struct {
+	countingObserver
+	sloMu      sync.Mutex
+	violations []SLOViolation
+}
+
+func (o *sloObserver) OnSLOViolation(ctx context.Context, v SLOViolation) {
+	o.sloMu.Lock()
+	defer o.sloMu.Unlock()
+	o.violations = append(o.violations, v)
+}
+
+func (o *sloObserver) recorded() []SLOViolation {
+	o.sloMu.Lock()
+	defer o.sloMu.Unlock()
+	return append([]SLOViolation(nil), o.violations...)
+}
+
+func TestSLOMiddleware(t *testing.T) {
+	obs := &sloObserver{}
+	bus := New(WithObserver(obs))
+	defer bus.Close()
+
+	published := make(chan SLOViolation, 10)
+	_, _ = bus.Subscribe(TopicSLOViolation, HandlerFunc(func(ctx context.Context, msg Message) error {
+		published <- msg.Payload().(SLOViolation)
+		return nil
+	}))
+
+	bus.Use(SLOMiddleware(bus, SLO{Pattern: "payments.*", Latency: 20 * time.Millisecond, Target: 0.5, Window: 4}))
+
+	var delay time.Duration
+	_, _ = bus.Subscribe("payments.*", HandlerFunc(func(ctx context.Context, msg Message) error {
+		time.Sleep(delay)
+		return nil
+	}))
+	publish := func(n int, d time.Duration) {
+		delay = d
+		for i := 0; i < n; i++ {
+			_ = bus.PublishSync(context.Background(), "payments.captured", i)
+		}
+	}
+
+	// Half of the window in time meets the target
+	publish(2, 0)
+	publish(2, 40*time.Millisecond)
+	if got := obs.recorded(); len(got) != 0 {
+		t.Fatalf("expected no violation at the target, got %v", got)
+	}
+
+	publish(3, 40*time.Millisecond)
+	got := obs.recorded()
+	if len(got) != 1 {
+		t.Fatalf("expected a single violation, got %d", len(got))
+	}
+	if got[0].Topic != "payments.captured" || got[0].Compliance != 0.25 || got[0].SLO.Pattern != "payments.*" {
+		t.Errorf("unexpected violation: %+v", got[0])
+	}
+
+	select {
+	case v := <-published:
+		if v.Topic != "payments.captured" {
+			t.Errorf("published violation for %q", v.Topic)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected a violation on %s", TopicSLOViolation)
+	}
+
+	// The objective is reported again once it was met in between
+	publish(4, 0)
+	publish(3, 40*time.Millisecond)
+	if got := obs.recorded(); len(got) != 2 {
+		t.Errorf("expected a second violation after recovering, got %d", len(got))
+	}
+}
+
+func TestSLOMiddleware_FailuresAreLate(t *testing.T) {
+	obs := &sloObserver{}
+	bus := New(WithObserver(obs), WithMaxRetries(0))
+	defer bus.Close()
+
+	bus.Use(SLOMiddleware(bus, SLO{Pattern: "payments.*", Latency: time.Second, Target: 1, Window: 2}))
+	_, _ = bus.Subscribe("payments.*", HandlerFunc(func(ctx context.Context, msg Message) error {
+		return context.DeadlineExceeded
+	}))
+	_, _ = bus.Subscribe("orders.*", HandlerFunc(func(ctx context.Context, msg Message) error {
+		return context.DeadlineExceeded
+	}))
+
+	for i := 0; i < 2; i++ {
+		_ = bus.PublishSync(context.Background(), "orders.created", i)
+	}
+	if got := obs.recorded(); len(got) != 0 {
+		t.Fatalf("expected topics outside the pattern to be ignored, got %v", got)
+	}
+
+	for i := 0; i < 2; i++ {
+		_ = bus.PublishSync(context.Background(), "payments.captured", i)
+	}
+	if got := obs.recorded(); len(got) != 1 || got[0].Compliance != 0 {
+		t.Errorf("expected failed messages to break the objective, got %v", got)
+	}
+}
@@ -6,24 +6,81 @@ import (
 	"encoding/json"
 	"fmt"
 	"regexp"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 // SQLStore provides database persistence for messages.
 // It works with any database/sql compatible driver.
+//
+// Messages are loaded in the order they were inserted, following a
+// sequence number the store assigns, so that replay follows publish order
+// exactly even if the clock steps back; timestamps are not used to order
+// them. Their priority is stored with them. Rows written by earlier
+// versions, which are not numbered, load first, by timestamp and then
+// priority. The numbering carries on from the stored rows when a store is
+// opened, so the order is exact for one store writing to the table at a
+// time.
+//
+// The SQL is written for SQLite unless another Dialect is configured.
 type SQLStore struct {
-	db         *sql.DB
-	tableName  string
-	serializer Serializer
-	mu         sync.Mutex
+	db          *sql.DB
+	tableName   string
+	dialect     Dialect
+	serializer  Serializer
+	compression *recordCompressor
+	group       *groupCommitter
+	behind      *writeBehind
+	mu          sync.Mutex
+
+	// stmts caches the statements prepared on db, by query.
+	stmtMu sync.Mutex
+	stmts  map[string]*sql.Stmt
+
+	// seq is the sequence number given to the last message inserted.
+	seq atomic.Int64
 }
 
 // SQLStoreConfig configures a SQL store.
 type SQLStoreConfig struct {
-	DB         *sql.DB
-	TableName  string
+	DB        *sql.DB
+	TableName string
+
+	// Serializer encodes the payloads. It defaults to JSON; the data of
+	// binary serializers (see BinarySerializer) is stored as base64.
 	Serializer Serializer
+
+	// Dialect is the database engine the SQL is written for. It defaults
+	// to DialectSQLite.
+	Dialect Dialect
+
+	// Compressor, when set, compresses serialized payloads of at least
+	// CompressionThreshold bytes before they are written.
+	Compressor           Compressor
+	CompressionThreshold int
+
+	// GroupCommitWindow, when positive, enables group commit: concurrent
+	// Store calls made within the window are inserted in one transaction.
+	// Each call still returns only once its message is committed. At most
+	// GroupCommitMaxBatch messages (100 by default) are grouped at once.
+	GroupCommitWindow   time.Duration
+	GroupCommitMaxBatch int
+
+	// WriteBehindInterval, when positive, enables write-behind: Store
+	// buffers the message and returns at once, and buffered messages are
+	// inserted in batches of at most WriteBehindMaxBatch (100 by default)
+	// every interval, or as soon as a batch fills. Messages buffered but not
+	// yet written are lost if the process crashes, and are not returned by
+	// loads until written. Store fails with ErrWriteBehindFull once
+	// WriteBehindMaxBuffer messages (10000 by default) are waiting. Failed
+	// writes are kept for the next flush and reported to
+	// WriteBehindErrorHandler. It cannot be combined with group commit.
+	WriteBehindInterval     time.Duration
+	WriteBehindMaxBatch     int
+	WriteBehindMaxBuffer    int
+	WriteBehindErrorHandler StoreErrorHandler
 }
 
 // validTableName validates that a table name is safe to use in SQL queries.
@@ -47,14 +104,31 @@ func NewSQLStore(config SQLStoreConfig) (*SQLStore, error) {
 		)
 	}
 
+	if config.Dialect == "" {
+		config.Dialect = DialectSQLite
+	}
+	if err := config.Dialect.validate(); err != nil {
+		return nil, err
+	}
+
+	if config.GroupCommitWindow > 0 && config.WriteBehindInterval > 0 {
+		return nil, fmt.Errorf("group commit and write-behind cannot be combined")
+	}
+
 	if config.Serializer == nil {
 		config.Serializer = NewJSONSerializer()
 	}
 
 	store := &SQLStore{
-		db:         config.DB,
-		tableName:  config.TableName,
-		serializer: config.Serializer,
+		db:          config.DB,
+		tableName:   config.TableName,
+		dialect:     config.Dialect,
+		serializer:  config.Serializer,
+		compression: newRecordCompressor(config.Compressor, config.CompressionThreshold),
+		stmts:       make(map[string]*sql.Stmt),
+	}
+	if config.GroupCommitWindow > 0 {
+		store.group = newGroupCommitter(config.GroupCommitWindow, config.GroupCommitMaxBatch, store.StoreBatch, store.storeOne)
 	}
 
 	// Create table if it doesn't exist
@@ -62,62 +136,328 @@ func NewSQLStore(config SQLStoreConfig) (*SQLStore, error) {
 		return nil, fmt.Errorf("failed to create table: %w", err)
 	}
 
+	// Prepare the writes now, so that transactions find them cached
+	for _, query := range []string{store.insertQuery(), store.upsertQuery()} {
+		if _, err := store.statement(context.Background(), nil, query); err != nil {
+			return nil, err
+		}
+	}
+
+	if config.WriteBehindInterval > 0 {
+		store.behind = newWriteBehind(
+			config.WriteBehindInterval,
+			config.WriteBehindMaxBatch,
+			config.WriteBehindMaxBuffer,
+			store.StoreBatch,
+			config.WriteBehindErrorHandler,
+		)
+	}
+
 	return store, nil
 }
 
+// sqlStoreColumns are the columns written for a message, in the order of
+// the values given by writeRow.
+var sqlStoreColumns = []string{
+	"id", "topic", "payload", "metadata", "timestamp", "expires_at", "sequence", "priority", "outbox",
+}
+
+// insertQuery returns the query inserting a message.
+func (s *SQLStore) insertQuery() string {
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(sqlStoreColumns)), ", ")
+	// #nosec G201 -- tableName is validated in NewSQLStore
+	return s.dialect.rebind(fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)",
+		s.tableName, strings.Join(sqlStoreColumns, ", "), placeholders))
+}
+
+// upsertQuery returns the query inserting a message, or replacing the
+// stored message with the same ID. The delivery and outbox state of a
+// replaced message is kept.
+func (s *SQLStore) upsertQuery() string {
+	return s.dialect.upsert(s.tableName, "id", sqlStoreColumns, sqlStoreColumns[1:len(sqlStoreColumns)-1])
+}
+
+// statement returns query prepared for use within tx, or directly if tx is
+// nil. Statements prepared on the database are cached until the store is
+// closed. Within a transaction, a query not cached yet is prepared on the
+// transaction alone: preparing it on the database could wait for the
+// connection the transaction holds.
+func (s *SQLStore) statement(ctx context.Context, tx *sql.Tx, query string) (*sql.Stmt, error) {
+	s.stmtMu.Lock()
+	stmt, ok := s.stmts[query]
+	s.stmtMu.Unlock()
+
+	if !ok {
+		var err error
+		if tx != nil {
+			stmt, err = tx.PrepareContext(ctx, query)
+			if err != nil {
+				return nil, fmt.Errorf("failed to prepare statement: %w", err)
+			}
+			return stmt, nil
+		}
+
+		stmt, err = s.db.PrepareContext(ctx, query)
+		if err != nil {
+			return nil, fmt.Errorf("failed to prepare statement: %w", err)
+		}
+
+		s.stmtMu.Lock()
+		if cached, raced := s.stmts[query]; raced {
+			_ = stmt.Close()
+			stmt = cached
+		} else {
+			s.stmts[query] = stmt
+		}
+		s.stmtMu.Unlock()
+	}
+
+	if tx != nil {
+		return tx.StmtContext(ctx, stmt), nil
+	}
+	return stmt, nil
+}
+
 // createTable creates the messages table if it doesn't exist.
 func (s *SQLStore) createTable() error {
-	// #nosec G201 -- tableName is validated in NewSQLStore
+	d := s.dialect
+	// #nosec G201 -- tableName is validated in NewSQLStore, types are constants
 	query := fmt.Sprintf(`
 		CREATE TABLE IF NOT EXISTS %s (
-			id TEXT PRIMARY KEY,
-			topic TEXT NOT NULL,
-			payload TEXT NOT NULL,
-			metadata TEXT,
-			timestamp TIMESTAMP NOT NULL,
-			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+			id %s PRIMARY KEY,
+			topic %s NOT NULL,
+			payload %s NOT NULL,
+			metadata %s,
+			timestamp %s NOT NULL,
+			created_at %s DEFAULT %s,
+			expires_at %s,
+			sequence %s,
+			priority INTEGER,
+			delivered INTEGER NOT NULL DEFAULT 0,
+			outbox INTEGER NOT NULL DEFAULT 0
 		)
-	`, s.tableName)
+	`, s.tableName, d.keyType(), d.textType(), d.textType(), d.textType(),
+		d.timestampType(), d.timestampType(), d.now(), d.timestampType(), d.integerType())
 
-	_, err := s.db.Exec(query)
-	return err
+	if _, err := s.db.Exec(query); err != nil {
+		return err
+	}
+
+	// Tables created by earlier versions lack the newer columns
+	for _, column := range []string{
+		"expires_at " + d.timestampType(),
+		"sequence " + d.integerType(),
+		"priority INTEGER",
+		"delivered INTEGER NOT NULL DEFAULT 0",
+		"outbox INTEGER NOT NULL DEFAULT 0",
+	} {
+		name, definition, _ := strings.Cut(column, " ")
+		if err := s.addColumnIfMissing(name, definition); err != nil {
+			return err
+		}
+	}
+
+	// Carry on numbering after the messages already stored
+	// #nosec G201 -- tableName is validated in NewSQLStore
+	last := fmt.Sprintf("SELECT COALESCE(MAX(sequence), 0) FROM %s", s.tableName)
+	var seq int64
+	if err := s.db.QueryRow(last).Scan(&seq); err != nil {
+		return fmt.Errorf("failed to read the last sequence number: %w", err)
+	}
+	s.seq.Store(seq)
+	return nil
 }
 
-// Store implements MessageStore.
+// addColumnIfMissing adds a column to a table created by an earlier version.
+func (s *SQLStore) addColumnIfMissing(column, definition string) error {
+	// #nosec G201 -- tableName is validated in NewSQLStore, columns are constants
+	probe := fmt.Sprintf("SELECT %s FROM %s LIMIT 0", column, s.tableName)
+	rows, err := s.db.Query(probe)
+	if err == nil {
+		return rows.Close()
+	}
+
+	// #nosec G201 -- tableName is validated in NewSQLStore, columns are constants
+	alter := fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", s.tableName, column, definition)
+	if _, err := s.db.Exec(alter); err != nil {
+		return fmt.Errorf("failed to add column %s: %w", column, err)
+	}
+	return nil
+}
+
+// Store implements MessageStore. With group commit enabled, the message is
+// inserted together with those stored concurrently. With write-behind
+// enabled, it is buffered and inserted later.
 func (s *SQLStore) Store(ctx context.Context, msg Message) error {
+	if s.group != nil {
+		return s.group.write(ctx, msg)
+	}
+	if s.behind != nil {
+		return s.behind.write(ctx, msg)
+	}
+	return s.storeOne(ctx, msg)
+}
+
+// storeOne inserts a single message.
+func (s *SQLStore) storeOne(ctx context.Context, msg Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.insert(ctx, nil, msg, false)
+}
+
+// StoreBatch implements BatchStore. All messages are inserted in a single
+// transaction which is rolled back if any insert fails. They are written at
+// once, even with write-behind enabled.
+func (s *SQLStore) StoreBatch(ctx context.Context, msgs []Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+
+	for _, msg := range msgs {
+		if err := s.insert(ctx, tx, msg, false); err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit batch: %w", err)
+	}
+
+	return nil
+}
+
+// Rewrite implements RewritableStore. The existing rows are read, replaced
+// and written back inside a single transaction.
+func (s *SQLStore) Rewrite(ctx context.Context, fn func([]Message) ([]Message, error)) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	// #nosec G201 -- tableName is validated in NewSQLStore
+	query := fmt.Sprintf(`
+		SELECT id, topic, payload, metadata, timestamp, priority
+		FROM %s
+		ORDER BY COALESCE(sequence, 0) ASC, timestamp ASC, priority DESC
+	`, s.tableName)
+
+	rows, err := tx.QueryContext(ctx, query)
+	if err != nil {
+		return fmt.Errorf("failed to query messages: %w", err)
+	}
+	messages, err := s.scanMessages(rows)
+	_ = rows.Close()
+	if err != nil {
+		return err
+	}
+
+	rewritten, err := fn(messages)
+	if err != nil {
+		return err
+	}
+
+	// Messages kept by fn are replaced in place, keeping their delivery and
+	// outbox state; the others are deleted
+	kept := make(map[string]bool, len(rewritten))
+	for _, msg := range rewritten {
+		kept[msg.ID()] = true
+	}
+	// #nosec G201 -- tableName is validated in NewSQLStore
+	remove, err := s.statement(ctx, tx, s.dialect.rebind(fmt.Sprintf("DELETE FROM %s WHERE id = ?", s.tableName)))
+	if err != nil {
+		return err
+	}
+	for _, msg := range messages {
+		if kept[msg.ID()] {
+			continue
+		}
+		if _, err := remove.ExecContext(ctx, msg.ID()); err != nil {
+			return fmt.Errorf("failed to delete message %s: %w", msg.ID(), err)
+		}
+	}
+
+	for _, msg := range rewritten {
+		if err := s.writeRow(ctx, tx, s.upsertQuery(), msg, false); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit rewrite: %w", err)
+	}
+
+	return nil
+}
+
+// insert serializes and inserts a single message within tx, or directly if
+// tx is nil. Messages written to the outbox wait for an OutboxRelay to
+// publish them.
+func (s *SQLStore) insert(ctx context.Context, tx *sql.Tx, msg Message, outbox bool) error {
+	return s.writeRow(ctx, tx, s.insertQuery(), msg, outbox)
+}
+
+// writeRow serializes a single message and writes it with query, the
+// insert or upsert query, numbering it after the previous one; numbers of
+// failed writes are not reused.
+func (s *SQLStore) writeRow(ctx context.Context, tx *sql.Tx, query string, msg Message, outbox bool) error {
 	// Serialize payload
 	payloadData, err := s.serializer.Serialize(msg.Payload())
 	if err != nil {
 		return fmt.Errorf("failed to serialize payload: %w", err)
 	}
 
+	storedPayload, _, err := s.compression.encode(serializeText(s.serializer, payloadData))
+	if err != nil {
+		return err
+	}
+
 	// Serialize metadata
 	metadataData, err := json.Marshal(msg.Metadata())
 	if err != nil {
 		return fmt.Errorf("failed to serialize metadata: %w", err)
 	}
 
-	// #nosec G201 -- tableName is validated in NewSQLStore
-	query := fmt.Sprintf(`
-		INSERT INTO %s (id, topic, payload, metadata, timestamp)
-		VALUES (?, ?, ?, ?, ?)
-	`, s.tableName)
+	// Stored in UTC, like the times PurgeExpired compares them with
+	var expiresAt interface{}
+	if at, ok := ExpiresAt(msg); ok {
+		expiresAt = at.UTC()
+	}
 
-	_, err = s.db.ExecContext(ctx, query,
+	stmt, err := s.statement(ctx, tx, query)
+	if err != nil {
+		return err
+	}
+
+	waiting := 0
+	if outbox {
+		waiting = 1
+	}
+
+	_, err = stmt.ExecContext(ctx,
 		msg.ID(),
 		msg.Topic(),
-		string(payloadData),
+		storedPayload,
 		string(metadataData),
 		msg.Timestamp(),
+		expiresAt,
+		s.seq.Add(1),
+		int(MessagePriority(msg)),
+		waiting,
 	)
 
 	if err != nil {
 		return fmt.Errorf("failed to insert message: %w", err)
 	}
-
 	return nil
 }
 
@@ -132,14 +472,24 @@ func (s *SQLStore) scanMessages(rows *sql.Rows) ([]Message, error) {
 			payloadData string
 			metadataStr string
 			timestamp   time.Time
+			priority    sql.NullInt64
 		)
 
-		if err := rows.Scan(&id, &topic, &payloadData, &metadataStr, &timestamp); err != nil {
+		if err := rows.Scan(&id, &topic, &payloadData, &metadataStr, &timestamp, &priority); err != nil {
 			return nil, fmt.Errorf("failed to scan row: %w", err)
 		}
 
+		storedPayload, err := s.compression.decode(payloadData)
+		if err != nil {
+			return nil, err
+		}
+		rawPayload, err := deserializeText(s.serializer, storedPayload)
+		if err != nil {
+			return nil, err
+		}
+
 		var payload interface{}
-		if err := s.serializer.Deserialize([]byte(payloadData), &payload); err != nil {
+		if err := s.serializer.Deserialize(rawPayload, &payload); err != nil {
 			return nil, fmt.Errorf("failed to deserialize payload: %w", err)
 		}
 
@@ -156,6 +506,11 @@ func (s *SQLStore) scanMessages(rows *sql.Rows) ([]Message, error) {
 			payload:   payload,
 			metadata:  metadata,
 			timestamp: timestamp,
+			priority:  PriorityNormal,
+		}
+		// Rows stored by earlier versions have no priority
+		if priority.Valid {
+			msg.priority = Priority(priority.Int64)
 		}
 
 		messages = append(messages, msg)
@@ -175,9 +530,9 @@ func (s *SQLStore) Load(ctx context.Context) ([]Message, error) {
 
 	// #nosec G201 -- tableName is validated in NewSQLStore
 	query := fmt.Sprintf(`
-		SELECT id, topic, payload, metadata, timestamp
+		SELECT id, topic, payload, metadata, timestamp, priority
 		FROM %s
-		ORDER BY timestamp ASC
+		ORDER BY COALESCE(sequence, 0) ASC, timestamp ASC, priority DESC
 	`, s.tableName)
 
 	rows, err := s.db.QueryContext(ctx, query)
@@ -189,20 +544,20 @@ func (s *SQLStore) Load(ctx context.Context) ([]Message, error) {
 	return s.scanMessages(rows)
 }
 
-// LoadByTopic loads messages for a specific topic.
+// LoadByTopic implements QueryableStore.
 func (s *SQLStore) LoadByTopic(ctx context.Context, topic string) ([]Message, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
 	// #nosec G201 -- tableName is validated in NewSQLStore
 	query := fmt.Sprintf(`
-		SELECT id, topic, payload, metadata, timestamp
+		SELECT id, topic, payload, metadata, timestamp, priority
 		FROM %s
 		WHERE topic = ?
-		ORDER BY timestamp ASC
+		ORDER BY COALESCE(sequence, 0) ASC, timestamp ASC, priority DESC
 	`, s.tableName)
 
-	rows, err := s.db.QueryContext(ctx, query, topic)
+	rows, err := s.db.QueryContext(ctx, s.dialect.rebind(query), topic)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query messages: %w", err)
 	}
@@ -211,20 +566,111 @@ func (s *SQLStore) LoadByTopic(ctx context.Context, topic string) ([]Message, er
 	return s.scanMessages(rows)
 }
 
-// LoadAfter loads messages after a specific timestamp.
+// LoadAfter implements QueryableStore.
 func (s *SQLStore) LoadAfter(ctx context.Context, after time.Time) ([]Message, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
 	// #nosec G201 -- tableName is validated in NewSQLStore
 	query := fmt.Sprintf(`
-		SELECT id, topic, payload, metadata, timestamp
+		SELECT id, topic, payload, metadata, timestamp, priority
 		FROM %s
 		WHERE timestamp > ?
-		ORDER BY timestamp ASC
+		ORDER BY COALESCE(sequence, 0) ASC, timestamp ASC, priority DESC
 	`, s.tableName)
 
-	rows, err := s.db.QueryContext(ctx, query, after)
+	rows, err := s.db.QueryContext(ctx, s.dialect.rebind(query), after)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query messages: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	return s.scanMessages(rows)
+}
+
+// LoadPage implements QueryableStore.
+func (s *SQLStore) LoadPage(ctx context.Context, offset, limit int) ([]Message, error) {
+	if offset < 0 || limit < 0 {
+		return nil, fmt.Errorf("invalid page: offset %d, limit %d", offset, limit)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// #nosec G201 -- tableName is validated in NewSQLStore
+	query := fmt.Sprintf(`
+		SELECT id, topic, payload, metadata, timestamp, priority
+		FROM %s
+		ORDER BY COALESCE(sequence, 0) ASC, timestamp ASC, priority DESC
+		LIMIT ? OFFSET ?
+	`, s.tableName)
+
+	rows, err := s.db.QueryContext(ctx, s.dialect.rebind(query), limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query messages: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	return s.scanMessages(rows)
+}
+
+// MarkDelivered implements DeliveryStore.
+func (s *SQLStore) MarkDelivered(ctx context.Context, ids ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(ids) == 1 {
+		return s.setFlag(ctx, nil, "delivered", 1, ids)
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if err := s.setFlag(ctx, tx, "delivered", 1, ids); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit delivery state: %w", err)
+	}
+	return nil
+}
+
+// setFlag sets a state column, delivered or outbox, of the messages with
+// the given IDs within tx, or directly if tx is nil. Must be called with the
+// lock held.
+func (s *SQLStore) setFlag(ctx context.Context, tx *sql.Tx, column string, value int, ids []string) error {
+	// #nosec G201 -- tableName is validated in NewSQLStore, columns are constants
+	query := s.dialect.rebind(fmt.Sprintf("UPDATE %s SET %s = ? WHERE id = ?", s.tableName, column))
+	stmt, err := s.statement(ctx, tx, query)
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if _, err := stmt.ExecContext(ctx, value, id); err != nil {
+			return fmt.Errorf("failed to update %s state of message %s: %w", column, id, err)
+		}
+	}
+	return nil
+}
+
+// LoadPending implements DeliveryStore. Messages still waiting in the
+// outbox are left to the OutboxRelay.
+func (s *SQLStore) LoadPending(ctx context.Context) ([]Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// #nosec G201 -- tableName is validated in NewSQLStore
+	query := fmt.Sprintf(`
+		SELECT id, topic, payload, metadata, timestamp, priority
+		FROM %s
+		WHERE delivered = 0 AND outbox = 0
+		ORDER BY COALESCE(sequence, 0) ASC, timestamp ASC, priority DESC
+	`, s.tableName)
+
+	rows, err := s.db.QueryContext(ctx, query)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query messages: %w", err)
 	}
@@ -248,14 +694,14 @@ func (s *SQLStore) Clear(ctx context.Context) error {
 	return nil
 }
 
-// ClearBefore removes messages older than the specified time.
+// ClearBefore implements QueryableStore.
 func (s *SQLStore) ClearBefore(ctx context.Context, before time.Time) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
 	// #nosec G201 -- tableName is validated in NewSQLStore
 	query := fmt.Sprintf("DELETE FROM %s WHERE timestamp < ?", s.tableName)
-	_, err := s.db.ExecContext(ctx, query, before)
+	_, err := s.db.ExecContext(ctx, s.dialect.rebind(query), before)
 	if err != nil {
 		return fmt.Errorf("failed to clear old messages: %w", err)
 	}
@@ -263,7 +709,26 @@ func (s *SQLStore) ClearBefore(ctx context.Context, before time.Time) error {
 	return nil
 }
 
-// Count returns the number of stored messages.
+// PurgeExpired implements ExpiringStore.
+func (s *SQLStore) PurgeExpired(ctx context.Context, now time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// #nosec G201 -- tableName is validated in NewSQLStore
+	query := fmt.Sprintf("DELETE FROM %s WHERE expires_at IS NOT NULL AND expires_at <= ?", s.tableName)
+	result, err := s.db.ExecContext(ctx, s.dialect.rebind(query), now.UTC())
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge expired messages: %w", err)
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to count purged messages: %w", err)
+	}
+	return int(n), nil
+}
+
+// Count implements QueryableStore.
 func (s *SQLStore) Count(ctx context.Context) (int, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -279,9 +744,130 @@ func (s *SQLStore) Count(ctx context.Context) (int, error) {
 	return count, nil
 }
 
-// Close implements MessageStore.
+// CompressionStats returns compression statistics for records written since
+// the store was opened.
+func (s *SQLStore) CompressionStats() CompressionStats {
+	return s.compression.stats()
+}
+
+// GroupCommitStats returns group commit statistics for the messages stored
+// since the store was opened. It is zero unless group commit is enabled.
+func (s *SQLStore) GroupCommitStats() GroupCommitStats {
+	if s.group == nil {
+		return GroupCommitStats{}
+	}
+	return s.group.snapshot()
+}
+
+// Flush writes the messages buffered by write-behind now. It returns nil
+// unless write-behind is enabled.
+func (s *SQLStore) Flush(ctx context.Context) error {
+	if s.behind == nil {
+		return nil
+	}
+	return s.behind.flush(ctx)
+}
+
+// WriteBehindStats returns write-behind statistics for the messages stored
+// since the store was opened. It is zero unless write-behind is enabled.
+func (s *SQLStore) WriteBehindStats() WriteBehindStats {
+	if s.behind == nil {
+		return WriteBehindStats{}
+	}
+	return s.behind.snapshot()
+}
+
+// Vacuum implements VacuumableStore, with VACUUM on SQLite, which rebuilds
+// the whole database file, and PostgreSQL, and OPTIMIZE TABLE on MySQL.
+func (s *SQLStore) Vacuum(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.db.ExecContext(ctx, s.dialect.vacuum(s.tableName)); err != nil {
+		return fmt.Errorf("failed to vacuum messages: %w", err)
+	}
+	return nil
+}
+
+// Stats implements StoreStats.
+func (s *SQLStore) Stats(ctx context.Context) (StoreStatistics, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats := StoreStatistics{TopicCounts: make(map[string]int)}
+
+	// #nosec G201 -- tableName is validated in NewSQLStore
+	query := fmt.Sprintf(`
+		SELECT COUNT(*), COALESCE(SUM(LENGTH(payload) + COALESCE(LENGTH(metadata), 0)), 0)
+		FROM %s
+	`, s.tableName)
+	if err := s.db.QueryRowContext(ctx, query).Scan(&stats.MessageCount, &stats.SizeBytes); err != nil {
+		return StoreStatistics{}, fmt.Errorf("failed to query store size: %w", err)
+	}
+
+	if stats.MessageCount == 0 {
+		return stats, nil
+	}
+
+	// Select boundary rows directly so drivers can scan the timestamp column type
+	// #nosec G201 -- tableName is validated in NewSQLStore
+	oldest := fmt.Sprintf("SELECT timestamp FROM %s ORDER BY timestamp ASC LIMIT 1", s.tableName)
+	if err := s.db.QueryRowContext(ctx, oldest).Scan(&stats.Oldest); err != nil {
+		return StoreStatistics{}, fmt.Errorf("failed to query oldest message: %w", err)
+	}
+
+	// #nosec G201 -- tableName is validated in NewSQLStore
+	newest := fmt.Sprintf("SELECT timestamp FROM %s ORDER BY timestamp DESC LIMIT 1", s.tableName)
+	if err := s.db.QueryRowContext(ctx, newest).Scan(&stats.Newest); err != nil {
+		return StoreStatistics{}, fmt.Errorf("failed to query newest message: %w", err)
+	}
+
+	// #nosec G201 -- tableName is validated in NewSQLStore
+	topics := fmt.Sprintf("SELECT topic, COUNT(*) FROM %s GROUP BY topic", s.tableName)
+	rows, err := s.db.QueryContext(ctx, topics)
+	if err != nil {
+		return StoreStatistics{}, fmt.Errorf("failed to query topic counts: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	for rows.Next() {
+		var topic string
+		var count int
+		if err := rows.Scan(&topic, &count); err != nil {
+			return StoreStatistics{}, fmt.Errorf("failed to scan topic count: %w", err)
+		}
+		stats.TopicCounts[topic] = count
+	}
+
+	if err := rows.Err(); err != nil {
+		return StoreStatistics{}, fmt.Errorf("error iterating rows: %w", err)
+	}
+
+	return stats, nil
+}
+
+// Close implements MessageStore. Messages buffered by write-behind are
+// written first, and the prepared statements are released.
 func (s *SQLStore) Close() error {
+	return s.CloseContext(context.Background())
+}
+
+// CloseContext implements StoreCloser. Messages buffered by write-behind
+// are written until ctx is done; those left are lost.
+func (s *SQLStore) CloseContext(ctx context.Context) error {
+	var err error
+	if s.behind != nil {
+		err = s.behind.close(ctx)
+	}
+
+	s.stmtMu.Lock()
+	for query, stmt := range s.stmts {
+		_ = stmt.Close()
+		delete(s.stmts, query)
+	}
+	s.stmtMu.Unlock()
+
 	// Note: We don't close the DB here as it might be shared
 	// The caller is responsible for closing the database connection
-	return nil
+	return err
 }
@@ -3,6 +3,10 @@ package scela
 import (
 	"context"
 	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
@@ -360,3 +364,290 @@ func TestSQLStoreWithMetadata(t *testing.T) {
 		t.Errorf("Expected metadata key2=123, got '%v'", loadedMsg.Metadata()["key2"])
 	}
 }
+
+func TestSQLStoreStoreBatch(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	store, err := NewSQLStore(SQLStoreConfig{DB: db})
+	if err != nil {
+		t.Fatalf("Failed to create SQL store: %v", err)
+	}
+
+	ctx := context.Background()
+	batch := []Message{NewMessage("a", 1), NewMessage("b", 2), NewMessage("c", 3)}
+	if err := store.StoreBatch(ctx, batch); err != nil {
+		t.Fatalf("StoreBatch() error = %v", err)
+	}
+
+	count, _ := store.Count(ctx)
+	if count != 3 {
+		t.Errorf("Expected 3 messages, got %d", count)
+	}
+}
+
+func TestSQLStoreStoreBatchRollback(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	store, err := NewSQLStore(SQLStoreConfig{DB: db})
+	if err != nil {
+		t.Fatalf("Failed to create SQL store: %v", err)
+	}
+
+	ctx := context.Background()
+	dup := NewMessage("dup", 1)
+
+	// The duplicate primary key makes the last insert fail
+	err = store.StoreBatch(ctx, []Message{NewMessage("a", 1), dup, dup})
+	if err == nil {
+		t.Fatal("Expected error for duplicate message ID")
+	}
+
+	count, _ := store.Count(ctx)
+	if count != 0 {
+		t.Errorf("Expected batch to be rolled back, got %d messages", count)
+	}
+}
+
+func TestSQLStoreStats(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	store, err := NewSQLStore(SQLStoreConfig{DB: db})
+	if err != nil {
+		t.Fatalf("Failed to create SQL store: %v", err)
+	}
+
+	ctx := context.Background()
+	empty, err := store.Stats(ctx)
+	if err != nil {
+		t.Fatalf("Stats() on empty store error = %v", err)
+	}
+	if empty.MessageCount != 0 {
+		t.Errorf("Expected 0 messages, got %d", empty.MessageCount)
+	}
+
+	first := NewMessage("orders", "first")
+	store.Store(ctx, first)
+	time.Sleep(10 * time.Millisecond)
+	store.Store(ctx, NewMessage("orders", "second"))
+	store.Store(ctx, NewMessage("users", "third"))
+
+	stats, err := store.Stats(ctx)
+	if err != nil {
+		t.Fatalf("Stats() error = %v", err)
+	}
+
+	if stats.MessageCount != 3 {
+		t.Errorf("Expected 3 messages, got %d", stats.MessageCount)
+	}
+	if stats.SizeBytes == 0 {
+		t.Error("Expected non-zero storage size")
+	}
+	if stats.TopicCounts["orders"] != 2 || stats.TopicCounts["users"] != 1 {
+		t.Errorf("Unexpected topic counts %v", stats.TopicCounts)
+	}
+	if !stats.Oldest.Equal(first.Timestamp()) {
+		t.Errorf("Oldest = %v, want %v", stats.Oldest, first.Timestamp())
+	}
+	if !stats.Newest.After(stats.Oldest) {
+		t.Errorf("Expected newest %v after oldest %v", stats.Newest, stats.Oldest)
+	}
+}
+
+func TestSQLStoreKeepsPublishOrderAndPriority(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	store, err := NewSQLStore(SQLStoreConfig{DB: db})
+	if err != nil {
+		t.Fatalf("Failed to create SQL store: %v", err)
+	}
+
+	// Messages with colliding timestamps
+	ts := time.Now()
+	priorities := []Priority{PriorityLow, PriorityNormal, PriorityHigh, PriorityLow}
+	ctx := context.Background()
+	for i, p := range priorities {
+		msg := NewMessageWithPriority("orders", i, p).(*message)
+		msg.timestamp = ts
+		if err := store.Store(ctx, msg); err != nil {
+			t.Fatalf("Store() error = %v", err)
+		}
+	}
+
+	// Reopening carries on the numbering
+	reopened, err := NewSQLStore(SQLStoreConfig{DB: db})
+	if err != nil {
+		t.Fatalf("Failed to reopen SQL store: %v", err)
+	}
+	_ = reopened.Store(ctx, NewMessageWithPriority("orders", 4, PriorityHigh))
+
+	messages, err := reopened.Load(ctx)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if len(messages) != 5 {
+		t.Fatalf("Expected 5 messages, got %d", len(messages))
+	}
+	for i, msg := range messages {
+		if msg.Payload() != float64(i) {
+			t.Errorf("message %d has payload %v", i, msg.Payload())
+		}
+	}
+	for i, p := range priorities {
+		if got := MessagePriority(messages[i]); got != p {
+			t.Errorf("message %d has priority %v, want %v", i, got, p)
+		}
+	}
+}
+
+func TestSQLStoreIgnoresClockSteps(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	store, err := NewSQLStore(SQLStoreConfig{DB: db})
+	if err != nil {
+		t.Fatalf("Failed to create SQL store: %v", err)
+	}
+
+	// The clock steps back an hour after the first message
+	ts := time.Now()
+	ctx := context.Background()
+	for i, offset := range []time.Duration{0, -time.Hour, -time.Hour + time.Second} {
+		msg := NewMessage("orders", i).(*message)
+		msg.timestamp = ts.Add(offset)
+		if err := store.Store(ctx, msg); err != nil {
+			t.Fatalf("Store() error = %v", err)
+		}
+	}
+
+	messages, err := store.Load(ctx)
+	if err != nil || len(messages) != 3 {
+		t.Fatalf("Load() = %d messages, %v, want 3", len(messages), err)
+	}
+	for i, msg := range messages {
+		if msg.Payload() != float64(i) {
+			t.Errorf("message %d has payload %v, want insertion order", i, msg.Payload())
+		}
+	}
+	if page, _ := store.LoadPage(ctx, 1, 1); len(page) != 1 || page[0].Payload() != float64(1) {
+		t.Errorf("LoadPage() = %v, want the second message inserted", page)
+	}
+}
+
+func TestSQLStoreLoadsLegacyRows(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	// A table and row written before messages were numbered
+	_, err := db.Exec(`CREATE TABLE scela_messages (
+		id TEXT PRIMARY KEY,
+		topic TEXT NOT NULL,
+		payload TEXT NOT NULL,
+		metadata TEXT,
+		timestamp TIMESTAMP NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	)`)
+	if err != nil {
+		t.Fatalf("create table: %v", err)
+	}
+	_, err = db.Exec(`INSERT INTO scela_messages (id, topic, payload, metadata, timestamp)
+		VALUES ('legacy', 'orders', '"old"', '{}', ?)`, time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+
+	store, err := NewSQLStore(SQLStoreConfig{DB: db})
+	if err != nil {
+		t.Fatalf("Failed to create SQL store: %v", err)
+	}
+	ctx := context.Background()
+	_ = store.Store(ctx, NewMessage("orders", "new"))
+
+	messages, err := store.Load(ctx)
+	if err != nil || len(messages) != 2 {
+		t.Fatalf("Load() = %d messages, %v, want 2", len(messages), err)
+	}
+	if messages[0].ID() != "legacy" || messages[1].Payload() != "new" {
+		t.Errorf("Expected the legacy row first, got %v then %v", messages[0].Payload(), messages[1].Payload())
+	}
+	if MessagePriority(messages[0]) != PriorityNormal {
+		t.Errorf("Expected legacy rows at normal priority, got %v", MessagePriority(messages[0]))
+	}
+	if pending, err := store.LoadPending(ctx); err != nil || len(pending) != 2 {
+		t.Errorf("Expected legacy rows to be pending, got %d messages, %v", len(pending), err)
+	}
+}
+
+func TestSQLStoreCachesPreparedStatements(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	store, err := NewSQLStore(SQLStoreConfig{DB: db})
+	if err != nil {
+		t.Fatalf("Failed to create SQL store: %v", err)
+	}
+
+	ctx := context.Background()
+	first, second := NewMessage("orders", "a"), NewMessage("orders", "b")
+	_ = store.Store(ctx, first)
+	_ = store.StoreBatch(ctx, []Message{second, NewMessage("orders", "c")})
+	// Preparing within a transaction must not wait for the only connection
+	if err := store.MarkDelivered(ctx, first.ID(), second.ID()); err != nil {
+		t.Fatalf("MarkDelivered() error = %v", err)
+	}
+	_ = store.MarkDelivered(ctx, first.ID())
+
+	if len(store.stmts) != 3 {
+		t.Errorf("expected the insert, upsert and update statements cached, got %d", len(store.stmts))
+	}
+	if got := fmt.Sprint(pendingPayloads(t, store)); got != "[c]" {
+		t.Errorf("pending = %s, want [c]", got)
+	}
+
+	if err := store.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if len(store.stmts) != 0 {
+		t.Errorf("expected the statements released, got %d", len(store.stmts))
+	}
+}
+
+func TestSQLStoreVacuum(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "vacuum.db")
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	defer db.Close()
+
+	store, err := NewSQLStore(SQLStoreConfig{DB: db})
+	if err != nil {
+		t.Fatalf("Failed to create SQL store: %v", err)
+	}
+	var _ VacuumableStore = store
+
+	ctx := context.Background()
+	msgs := make([]Message, 200)
+	for i := range msgs {
+		msgs[i] = NewMessage("orders", strings.Repeat("x", 1024))
+	}
+	if err := store.StoreBatch(ctx, msgs); err != nil {
+		t.Fatalf("StoreBatch() error = %v", err)
+	}
+	if err := store.Clear(ctx); err != nil {
+		t.Fatalf("Clear() error = %v", err)
+	}
+
+	before, _ := os.Stat(path)
+	if err := store.Vacuum(ctx); err != nil {
+		t.Fatalf("Vacuum() error = %v", err)
+	}
+	after, _ := os.Stat(path)
+	if after.Size() >= before.Size() {
+		t.Errorf("expected the database to shrink from %d bytes, got %d", before.Size(), after.Size())
+	}
+}
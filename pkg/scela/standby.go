@@ -0,0 +1,305 @@
+package scela
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+)
+
+// MetadataStandbyMirror marks the copies of messages a StandbyBus mirrors
+// to its standby. The standby never handles them, even once promoted.
+const MetadataStandbyMirror = "standby_mirror"
+
+// StandbyBus pairs a primary bus with a warm standby. Subscriptions are
+// made on both buses and every message published is mirrored to the
+// standby, whose handlers suppress the copies, so its workers, queues and
+// subscriptions are live when it takes over.
+//
+// Promote switches traffic to the standby and closes the primary. A
+// primary closed on its own, for example by a supervisor after a fault,
+// promotes the standby at the next publish or subscribe, which is then
+// made on the standby. Messages queued on a primary that failed are not
+// handed over: use a PersistentBus to replay them.
+//
+// Mirrors are published as built messages, which the buses of this module
+// support; on other buses the copies are published as plain messages and
+// only suppressed until the promotion.
+//
+// Functions looking through bus wrappers, such as InspectSubscriptions and
+// PauserOf, see the active bus.
+type StandbyBus struct {
+	primary   Bus
+	standby   Bus
+	onPromote func(cause error)
+
+	promoted atomic.Bool
+	closed   atomic.Bool
+
+	mu    sync.Mutex
+	stats StandbyStats
+}
+
+// StandbyOption is a functional option for configuring a standby bus.
+type StandbyOption func(*StandbyBus)
+
+// WithPromotionHandler registers a function called once the standby is
+// promoted, with the error of the primary that caused it, or nil when
+// Promote was called.
+func WithPromotionHandler(fn func(cause error)) StandbyOption {
+	return func(sb *StandbyBus) {
+		sb.onPromote = fn
+	}
+}
+
+// StandbyStats describes the work of a standby bus.
+type StandbyStats struct {
+	// Mirrored is the number of messages mirrored to the standby.
+	Mirrored int64
+	// MirrorFailures is the number of messages the standby rejected.
+	MirrorFailures int64
+	// Suppressed is the number of deliveries the standby suppressed.
+	Suppressed int64
+	// Promoted reports whether the standby took over.
+	Promoted bool
+}
+
+// NewStandbyBus pairs primary with standby. Both buses should be
+// configured alike, with the same middleware, retries and dead letters.
+func NewStandbyBus(primary, standby Bus, opts ...StandbyOption) *StandbyBus {
+	sb := &StandbyBus{
+		primary: primary,
+		standby: standby,
+	}
+	for _, opt := range opts {
+		opt(sb)
+	}
+	return sb
+}
+
+// Promoted reports whether the standby took over.
+func (sb *StandbyBus) Promoted() bool {
+	return sb.promoted.Load()
+}
+
+// Active returns the bus handling the traffic: the primary until the
+// standby is promoted.
+func (sb *StandbyBus) Active() Bus {
+	if sb.Promoted() {
+		return sb.standby
+	}
+	return sb.primary
+}
+
+// Promote switches the traffic to the standby, then closes the primary,
+// which delivers the messages it has queued meanwhile. It does nothing if
+// the standby is already promoted. It must not be called from a handler of
+// the primary, whose Close would wait for it.
+func (sb *StandbyBus) Promote() error {
+	return sb.promote(nil)
+}
+
+// promote promotes the standby for cause, closing the primary.
+func (sb *StandbyBus) promote(cause error) error {
+	if !sb.promoted.CompareAndSwap(false, true) {
+		return nil
+	}
+	sb.mu.Lock()
+	sb.stats.Promoted = true
+	sb.mu.Unlock()
+
+	err := sb.primary.Close()
+	if errors.Is(err, ErrBusClosed) {
+		err = nil
+	}
+	if sb.onPromote != nil {
+		sb.onPromote(cause)
+	}
+	return err
+}
+
+// failedOver reports whether err shows the primary was closed without the
+// standby bus, promoting the standby if so.
+func (sb *StandbyBus) failedOver(err error) bool {
+	if !errors.Is(err, ErrBusClosed) || sb.closed.Load() {
+		return false
+	}
+	_ = sb.promote(err)
+	return true
+}
+
+// publish runs fn on the active bus and, while it is the primary, mirrors
+// batch to the standby.
+func (sb *StandbyBus) publish(
+	ctx context.Context, batch []TopicPayload, priority Priority, fn func(b Bus) error,
+) error {
+	if sb.Promoted() {
+		return fn(sb.standby)
+	}
+	err := fn(sb.primary)
+	if sb.failedOver(err) {
+		return fn(sb.standby)
+	}
+	if err == nil {
+		sb.mirror(ctx, batch, priority)
+	}
+	return err
+}
+
+// mirror publishes copies of batch to the standby, marked so its handlers
+// suppress them.
+func (sb *StandbyBus) mirror(ctx context.Context, batch []TopicPayload, priority Priority) {
+	var err error
+	if mp, ok := sb.standby.(messagePublisher); ok {
+		msgs := make([]Message, len(batch))
+		for i, tp := range batch {
+			msgs[i] = newBusMessage(ctx, sb.standby, tp.Topic, tp.Payload, priority)
+			msgs[i].Metadata()[MetadataStandbyMirror] = true
+		}
+		err = mp.publishMessages(ctx, msgs, len(batch) > 1)
+	} else {
+		err = sb.standby.PublishBatch(ctx, batch)
+	}
+
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+	if err != nil {
+		sb.stats.MirrorFailures += int64(len(batch))
+		return
+	}
+	sb.stats.Mirrored += int64(len(batch))
+}
+
+// Publish publishes a message on the active bus.
+func (sb *StandbyBus) Publish(ctx context.Context, topic string, payload interface{}) error {
+	batch := []TopicPayload{{Topic: topic, Payload: payload}}
+	return sb.publish(ctx, batch, PriorityNormal, func(b Bus) error {
+		return b.Publish(ctx, topic, payload)
+	})
+}
+
+// PublishSync publishes a message synchronously on the active bus. The
+// mirror is published asynchronously.
+func (sb *StandbyBus) PublishSync(ctx context.Context, topic string, payload interface{}) error {
+	batch := []TopicPayload{{Topic: topic, Payload: payload}}
+	return sb.publish(ctx, batch, PriorityNormal, func(b Bus) error {
+		return b.PublishSync(ctx, topic, payload)
+	})
+}
+
+// PublishWithPriority publishes a message with priority on the active bus.
+func (sb *StandbyBus) PublishWithPriority(
+	ctx context.Context, topic string, payload interface{}, priority Priority,
+) error {
+	batch := []TopicPayload{{Topic: topic, Payload: payload}}
+	return sb.publish(ctx, batch, priority, func(b Bus) error {
+		return b.PublishWithPriority(ctx, topic, payload, priority)
+	})
+}
+
+// PublishBatch publishes several messages on the active bus.
+func (sb *StandbyBus) PublishBatch(ctx context.Context, batch []TopicPayload) error {
+	return sb.publish(ctx, batch, PriorityNormal, func(b Bus) error {
+		return b.PublishBatch(ctx, batch)
+	})
+}
+
+// Subscribe subscribes handler to pattern on both buses.
+func (sb *StandbyBus) Subscribe(pattern string, handler Handler) (Subscription, error) {
+	return sb.SubscribeWithOptions(pattern, handler)
+}
+
+// SubscribeWithOptions subscribes handler to pattern on both buses. The
+// standby subscription suppresses the mirrored messages, and every message
+// until the standby is promoted.
+func (sb *StandbyBus) SubscribeWithOptions(
+	pattern string, handler Handler, opts ...SubscriptionOption,
+) (Subscription, error) {
+	if sb.Promoted() {
+		return sb.standby.SubscribeWithOptions(pattern, sb.suppress(handler), opts...)
+	}
+	primary, err := sb.primary.SubscribeWithOptions(pattern, handler, opts...)
+	if sb.failedOver(err) {
+		return sb.standby.SubscribeWithOptions(pattern, sb.suppress(handler), opts...)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	standby, err := sb.standby.SubscribeWithOptions(pattern, sb.suppress(handler), opts...)
+	if err != nil {
+		_ = primary.Unsubscribe()
+		return nil, err
+	}
+	return &standbySubscription{sb: sb, primary: primary, standby: standby}, nil
+}
+
+// suppress wraps handler to skip the messages the standby must not handle.
+func (sb *StandbyBus) suppress(handler Handler) Handler {
+	return HandlerFunc(func(ctx context.Context, msg Message) error {
+		if mirrored, _ := msg.Metadata()[MetadataStandbyMirror].(bool); mirrored || !sb.Promoted() {
+			sb.mu.Lock()
+			sb.stats.Suppressed++
+			sb.mu.Unlock()
+			return nil
+		}
+		return handler.Handle(ctx, msg)
+	})
+}
+
+// Use adds middleware to both buses.
+func (sb *StandbyBus) Use(middleware ...Middleware) {
+	sb.primary.Use(middleware...)
+	sb.standby.Use(middleware...)
+}
+
+// StandbyStats returns the statistics of the standby bus so far.
+func (sb *StandbyBus) StandbyStats() StandbyStats {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+	return sb.stats
+}
+
+// Close closes both buses without promoting the standby.
+func (sb *StandbyBus) Close() error {
+	if !sb.closed.CompareAndSwap(false, true) {
+		return ErrBusClosed
+	}
+	primaryErr := sb.primary.Close()
+	if sb.Promoted() && errors.Is(primaryErr, ErrBusClosed) {
+		primaryErr = nil
+	}
+	return errors.Join(primaryErr, sb.standby.Close())
+}
+
+// standbySubscription is a subscription made on both buses of a standby
+// bus.
+type standbySubscription struct {
+	sb      *StandbyBus
+	primary Subscription
+	standby Subscription
+}
+
+// Topic implements Subscription.
+func (s *standbySubscription) Topic() string {
+	return s.primary.Topic()
+}
+
+// Unsubscribe implements Subscription.
+func (s *standbySubscription) Unsubscribe() error {
+	return errors.Join(s.primaryErr(s.primary.Unsubscribe()), s.standby.Unsubscribe())
+}
+
+// UnsubscribeAndWait implements Subscription.
+func (s *standbySubscription) UnsubscribeAndWait(ctx context.Context) error {
+	return errors.Join(s.primaryErr(s.primary.UnsubscribeAndWait(ctx)), s.standby.UnsubscribeAndWait(ctx))
+}
+
+// primaryErr drops the error of the primary once the standby is promoted,
+// as its subscriptions went away with it.
+func (s *standbySubscription) primaryErr(err error) error {
+	if s.sb.Promoted() {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,111 @@
+package scela
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestStandbyBus_MirrorsAndSuppresses(t *testing.T) {
+	sb := NewStandbyBus(New(), New())
+	defer sb.Close()
+
+	var handled atomic.Int32
+	_, _ = sb.Subscribe("order.*", HandlerFunc(func(ctx context.Context, msg Message) error {
+		handled.Add(1)
+		return nil
+	}))
+
+	ctx := context.Background()
+	_ = sb.Publish(ctx, "order.placed", 1)
+	_ = sb.PublishSync(ctx, "order.placed", 2)
+	_ = sb.PublishBatch(ctx, []TopicPayload{{Topic: "order.placed", Payload: 3}, {Topic: "order.paid", Payload: 3}})
+
+	waitFor(t, func() bool { return sb.StandbyStats().Suppressed == 4 })
+	waitFor(t, func() bool { return handled.Load() == 4 })
+	if stats := sb.StandbyStats(); stats.Mirrored != 4 || stats.Promoted {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+func TestStandbyBus_PromotesWhenPrimaryCloses(t *testing.T) {
+	primary := New()
+	var cause error
+	sb := NewStandbyBus(primary, New(), WithPromotionHandler(func(err error) { cause = err }))
+	defer sb.Close()
+
+	handled := make(chan Message, 1)
+	sub, _ := sb.Subscribe("order.*", HandlerFunc(func(ctx context.Context, msg Message) error {
+		handled <- msg
+		return nil
+	}))
+
+	// A supervisor closes the faulty primary
+	_ = primary.Close()
+
+	if err := sb.PublishSync(context.Background(), "order.placed", "after"); err != nil {
+		t.Fatalf("PublishSync() error = %v", err)
+	}
+	if msg := <-handled; msg.Payload() != "after" {
+		t.Errorf("expected the standby to handle the message, got %v", msg.Payload())
+	}
+	if !sb.Promoted() || sb.Active() == primary || !errors.Is(cause, ErrBusClosed) {
+		t.Errorf("expected the standby promoted for the closed primary, cause %v", cause)
+	}
+	if err := sub.Unsubscribe(); err != nil {
+		t.Errorf("Unsubscribe() error = %v", err)
+	}
+}
+
+func TestStandbyBus_Promote(t *testing.T) {
+	primary := New()
+	sb := NewStandbyBus(primary, New())
+
+	var handled atomic.Int32
+	_, _ = sb.Subscribe("jobs", HandlerFunc(func(ctx context.Context, msg Message) error {
+		handled.Add(1)
+		return nil
+	}))
+	ctx := context.Background()
+	_ = sb.PublishSync(ctx, "jobs", nil)
+
+	if err := sb.Promote(); err != nil {
+		t.Fatalf("Promote() error = %v", err)
+	}
+	if err := primary.Publish(ctx, "jobs", nil); !errors.Is(err, ErrBusClosed) {
+		t.Errorf("expected the primary closed, got %v", err)
+	}
+
+	_ = sb.PublishSync(ctx, "jobs", nil)
+	if handled.Load() != 2 {
+		t.Errorf("expected each message handled once, got %d", handled.Load())
+	}
+	if err := sb.Close(); err != nil {
+		t.Errorf("Close() error = %v", err)
+	}
+	if err := sb.Close(); !errors.Is(err, ErrBusClosed) {
+		t.Errorf("expected ErrBusClosed, got %v", err)
+	}
+}
+
+func TestStandbyBus_InspectsActiveBus(t *testing.T) {
+	sb := NewStandbyBus(New(), New())
+	defer sb.Close()
+
+	_, _ = sb.Subscribe("order.*", HandlerFunc(func(ctx context.Context, msg Message) error {
+		return nil
+	}))
+	if subs, ok := InspectSubscriptions(sb); !ok || len(subs) != 1 {
+		t.Fatalf("InspectSubscriptions() = %v, %v, want the primary subscription", subs, ok)
+	}
+	if _, err := FindLeaks(sb, time.Hour); err != nil {
+		t.Errorf("FindLeaks() error = %v", err)
+	}
+
+	_ = sb.Promote()
+	if subs, ok := InspectSubscriptions(sb); !ok || len(subs) != 1 {
+		t.Errorf("InspectSubscriptions() = %v, %v, want the standby subscription", subs, ok)
+	}
+}
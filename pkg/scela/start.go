@@ -0,0 +1,77 @@
+package scela
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ErrNotStarted is returned by PublishSync on a bus created with
+// WithStartPaused until Start is called, and reported to observers for the
+// messages it buffered if it is closed without being started.
+var ErrNotStarted = errors.New("bus not started")
+
+// WithStartPaused creates the bus without starting its workers, so that an
+// application can register all its handlers before any message is
+// delivered. Until Start is called, async publishes are buffered (subject to
+// the queue size and overflow policy) and PublishSync fails with
+// ErrNotStarted.
+func WithStartPaused() Option {
+	return func(b *bus) {
+		b.startPaused = true
+	}
+}
+
+// Starter is implemented by buses created with WithStartPaused.
+type Starter interface {
+	// Start starts delivering messages.
+	Start() error
+}
+
+// Start starts b, looking through the wrappers provided by this package. It
+// fails if b cannot be started, or was already started.
+func Start(b Bus) error {
+	for inner := b; inner != nil; inner = innerBus(inner) {
+		if s, ok := inner.(Starter); ok {
+			return s.Start()
+		}
+	}
+	return fmt.Errorf("bus %T cannot be started", b)
+}
+
+// Start implements Starter. Buses not created with WithStartPaused are
+// already started.
+func (b *bus) Start() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return ErrBusClosed
+	}
+	if b.started {
+		return ErrAlreadyStarted
+	}
+	b.startWorkers()
+	return nil
+}
+
+// dropQueued empties the queues of a bus that was never started, reporting
+// the dropped messages to observers. The queues must be closed.
+func (b *bus) dropQueued() {
+	ctx := context.Background()
+	r := b.queue.reader(0)
+	for {
+		env, ok := r.next()
+		if !ok {
+			break
+		}
+		env.leaveQueue()
+		b.observers.NotifyDrop(ctx, env.msg, ErrNotStarted)
+	}
+	for _, l := range b.lanes {
+		for env := range l.queue {
+			env.leaveQueue()
+			b.observers.NotifyDrop(ctx, env.msg, ErrNotStarted)
+		}
+	}
+}
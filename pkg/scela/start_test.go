@@ -0,0 +1,85 @@
+package scela
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestWithStartPaused(t *testing.T) {
+	b := New(WithStartPaused())
+	defer b.Close()
+
+	ctx := context.Background()
+	// Published before any handler is registered
+	if err := b.Publish(ctx, "app.ready", "early"); err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+	if err := b.PublishSync(ctx, "app.ready", "sync"); !errors.Is(err, ErrNotStarted) {
+		t.Errorf("expected ErrNotStarted from PublishSync, got %v", err)
+	}
+
+	received := make(chan interface{}, 1)
+	_, _ = b.Subscribe("app.ready", HandlerFunc(func(ctx context.Context, msg Message) error {
+		received <- msg.Payload()
+		return nil
+	}))
+
+	select {
+	case <-received:
+		t.Fatal("expected no delivery before Start")
+	case <-time.After(20 * time.Millisecond):
+	}
+	if stats, _ := StatsOf(b); stats.QueueDepth != 1 {
+		t.Errorf("expected the message buffered, got depth %d", stats.QueueDepth)
+	}
+
+	if err := Start(NewReadOnlyBus(b)); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	select {
+	case payload := <-received:
+		if payload != "early" {
+			t.Errorf("unexpected payload %v", payload)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("buffered message not delivered after Start")
+	}
+
+	if err := Start(b); err == nil {
+		t.Error("expected an error starting twice")
+	}
+	if err := b.PublishSync(ctx, "app.ready", "sync"); err != nil {
+		t.Errorf("PublishSync() after Start error = %v", err)
+	}
+}
+
+func TestWithStartPaused_CloseWithoutStart(t *testing.T) {
+	obs := &failureObserver{}
+	b := New(WithStartPaused(), WithObserver(obs))
+
+	ctx := context.Background()
+	_ = b.Publish(ctx, "work", "queued")
+	_ = PublishWithKey(ctx, b, "work", "k", "keyed")
+
+	if err := b.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	for _, payload := range []string{"queued", "keyed"} {
+		if err := obs.errorFor(payload); !errors.Is(err, ErrNotStarted) {
+			t.Errorf("expected %q reported with ErrNotStarted, got %v", payload, err)
+		}
+	}
+	if err := Start(b); err == nil {
+		t.Error("expected an error starting a closed bus")
+	}
+}
+
+func TestStart_AlreadyStarted(t *testing.T) {
+	b := New()
+	defer b.Close()
+	if err := Start(b); err == nil {
+		t.Error("expected an error starting a running bus")
+	}
+}
@@ -0,0 +1,163 @@
+package scela
+
+import (
+	"sync"
+	"time"
+)
+
+// OtherTopics is the Stats.Topics key under which latencies are aggregated
+// once the per-topic limit of WithLatencyTracking is reached.
+const OtherTopics = "(other)"
+
+// AllReplyTopics is the Stats.Topics key under which the latencies of
+// replies are aggregated, as each request gets its own reply topic.
+const AllReplyTopics = "(replies)"
+
+// WithLatencyTracking records the end-to-end latency of messages, from
+// publish until their handlers finish, per topic. Each delivery attempt is
+// recorded, including failed ones. At most maxTopics topics are tracked
+// individually (1000 if maxTopics <= 0); further topics are aggregated under
+// OtherTopics, and reply topics under AllReplyTopics. Each tracked topic uses
+// about 8KB.
+func WithLatencyTracking(maxTopics int) Option {
+	return func(b *bus) {
+		if maxTopics <= 0 {
+			maxTopics = 1000
+		}
+		b.latency = newLatencyTracker(maxTopics)
+	}
+}
+
+// Stats is a point-in-time view of a bus.
+type Stats struct {
+	// QueueDepth is the number of messages waiting for a worker.
+	QueueDepth int
+	// QueueDepths is the number of messages waiting per priority level.
+	QueueDepths map[Priority]int
+	// QueueCapacity is the size of the async queue, all levels included.
+	QueueCapacity int
+	// PartitionDepth is the number of keyed messages waiting for their
+	// partition worker (see PublishWithKey).
+	PartitionDepth int
+	// Held is the number of messages held by paused topics.
+	Held int
+	// Scheduled is the number of messages waiting for their delivery time
+	// (see PublishAt).
+	Scheduled int
+	// Subscriptions is the number of registered subscriptions.
+	Subscriptions int
+	// ReplyTopics is the number of reply topics open for requests in
+	// flight (see Request and ScatterGather). It returns to zero once they
+	// complete; a steady rise means requests are not given a deadline.
+	ReplyTopics int
+	// Latency summarizes end-to-end latency across all topics. It is empty
+	// unless the bus was created with WithLatencyTracking.
+	Latency LatencySnapshot
+	// Topics summarizes end-to-end latency per topic.
+	Topics map[string]LatencySnapshot
+}
+
+// StatsReporter is implemented by buses that report Stats.
+type StatsReporter interface {
+	// Stats returns the current statistics of the bus.
+	Stats() Stats
+}
+
+// StatsOf returns the statistics of b, looking through the wrappers provided
+// by this package. It reports false if b does not report statistics.
+func StatsOf(b Bus) (Stats, bool) {
+	for b != nil {
+		if sr, ok := b.(StatsReporter); ok {
+			return sr.Stats(), true
+		}
+		b = innerBus(b)
+	}
+	return Stats{}, false
+}
+
+// Stats implements StatsReporter.
+func (b *bus) Stats() Stats {
+	stats := Stats{
+		QueueDepth:     b.queue.len(),
+		QueueDepths:    b.queue.depths(),
+		QueueCapacity:  b.queue.cap(),
+		PartitionDepth: b.partitionDepth(),
+		Held:           b.pauses.heldCount(),
+		Scheduled:      b.wheel.len(),
+		Subscriptions:  b.registry.Count(),
+		ReplyTopics:    int(b.replyTopics.Load()),
+	}
+	if b.latency != nil {
+		stats.Latency, stats.Topics = b.latency.snapshot()
+	}
+	return stats
+}
+
+// latencyTracker keeps a latency histogram per topic.
+type latencyTracker struct {
+	all       *LatencyHistogram
+	maxTopics int
+
+	mu     sync.RWMutex
+	topics map[string]*LatencyHistogram
+}
+
+// newLatencyTracker creates a tracker for at most maxTopics topics plus
+// OtherTopics.
+func newLatencyTracker(maxTopics int) *latencyTracker {
+	return &latencyTracker{
+		all:       NewLatencyHistogram(),
+		maxTopics: maxTopics,
+		topics:    make(map[string]*LatencyHistogram),
+	}
+}
+
+// record adds the latency of msg, measured from its timestamp until now.
+func (lt *latencyTracker) record(msg Message) {
+	if lt == nil {
+		return
+	}
+	d := time.Since(msg.Timestamp())
+	lt.all.Record(d)
+	lt.histogram(msg.Topic()).Record(d)
+}
+
+// histogram returns the histogram for topic, creating it if needed.
+func (lt *latencyTracker) histogram(topic string) *LatencyHistogram {
+	if IsReplyTopic(topic) {
+		topic = AllReplyTopics
+	}
+	lt.mu.RLock()
+	h, ok := lt.topics[topic]
+	lt.mu.RUnlock()
+	if ok {
+		return h
+	}
+
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+	if h, ok := lt.topics[topic]; ok {
+		return h
+	}
+	if len(lt.topics) >= lt.maxTopics {
+		topic = OtherTopics
+		if h, ok := lt.topics[topic]; ok {
+			return h
+		}
+	}
+	h = NewLatencyHistogram()
+	lt.topics[topic] = h
+	return h
+}
+
+// snapshot summarizes all histograms.
+func (lt *latencyTracker) snapshot() (LatencySnapshot, map[string]LatencySnapshot) {
+	lt.mu.RLock()
+	defer lt.mu.RUnlock()
+
+	topics := make(map[string]LatencySnapshot, len(lt.topics))
+	for topic, h := range lt.topics {
+		topics[topic] = h.Snapshot()
+	}
+	return lt.all.Snapshot(), topics
+}
@@ -0,0 +1,94 @@
+package scela
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestBusStats_Latency(t *testing.T) {
+	bus := New(WithLatencyTracking(0))
+	defer bus.Close()
+
+	_, _ = bus.Subscribe("slow", HandlerFunc(func(ctx context.Context, msg Message) error {
+		time.Sleep(10 * time.Millisecond)
+		return nil
+	}))
+	_, _ = bus.Subscribe("fast", HandlerFunc(func(ctx context.Context, msg Message) error {
+		return nil
+	}))
+
+	for i := 0; i < 5; i++ {
+		_ = bus.PublishSync(context.Background(), "slow", nil)
+		_ = bus.PublishSync(context.Background(), "fast", nil)
+	}
+
+	stats, ok := StatsOf(bus)
+	if !ok {
+		t.Fatal("expected the bus to report stats")
+	}
+	if stats.Latency.Count != 10 {
+		t.Errorf("expected 10 samples, got %d", stats.Latency.Count)
+	}
+	if p50 := stats.Topics["slow"].P50; p50 < 10*time.Millisecond {
+		t.Errorf("expected slow P50 of at least 10ms, got %v", p50)
+	}
+	if stats.Topics["fast"].P99 >= stats.Topics["slow"].P50 {
+		t.Errorf("expected fast topic to be faster: %+v", stats.Topics)
+	}
+	if stats.Subscriptions != 2 || stats.QueueCapacity == 0 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+func TestBusStats_AsyncAndTopicLimit(t *testing.T) {
+	bus := New(WithLatencyTracking(1))
+	defer bus.Close()
+
+	done := make(chan struct{}, 3)
+	_, _ = bus.Subscribe("*", HandlerFunc(func(ctx context.Context, msg Message) error {
+		done <- struct{}{}
+		return nil
+	}))
+
+	for _, topic := range []string{"a", "b", "c"} {
+		_ = bus.Publish(context.Background(), topic, nil)
+	}
+	for i := 0; i < 3; i++ {
+		<-done
+	}
+
+	// The handler returns before the sample is recorded
+	deadline := time.Now().Add(time.Second)
+	var stats Stats
+	for time.Now().Before(deadline) {
+		stats, _ = StatsOf(bus)
+		if stats.Latency.Count == 3 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if stats.Latency.Count != 3 {
+		t.Fatalf("expected 3 samples, got %d", stats.Latency.Count)
+	}
+	if len(stats.Topics) != 2 || stats.Topics[OtherTopics].Count != 2 {
+		t.Errorf("expected one tracked topic plus %s, got %+v", OtherTopics, stats.Topics)
+	}
+}
+
+func TestBusStats_DisabledByDefault(t *testing.T) {
+	bus := New()
+	defer bus.Close()
+
+	_, _ = bus.Subscribe("test", HandlerFunc(func(ctx context.Context, msg Message) error { return nil }))
+	_ = bus.PublishSync(context.Background(), "test", nil)
+
+	stats, ok := StatsOf(NewPersistentBus(bus, NewInMemoryStore(10)))
+	if !ok {
+		t.Fatal("expected stats through the persistent wrapper")
+	}
+	if stats.Latency.Count != 0 || stats.Topics != nil {
+		t.Errorf("expected no latency data, got %+v", stats)
+	}
+}
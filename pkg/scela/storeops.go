@@ -0,0 +1,157 @@
+package scela
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// The functions below run the optional operations of a store through the
+// interface implementing them, or emulate them with the methods every
+// store has, so that store wrappers can offer the operations of any store
+// they wrap.
+
+// storeBatchOf stores msgs in store atomically if it is a BatchStore, one
+// by one otherwise; the error then reports how many were stored.
+func storeBatchOf(ctx context.Context, store MessageStore, msgs []Message) error {
+	if bs, ok := store.(BatchStore); ok {
+		return bs.StoreBatch(ctx, msgs)
+	}
+	for i, msg := range msgs {
+		if err := store.Store(ctx, msg); err != nil {
+			return fmt.Errorf("%d of %d messages stored: %w", i, len(msgs), err)
+		}
+	}
+	return nil
+}
+
+// rewriteOf rewrites store, which must be a RewritableStore.
+func rewriteOf(ctx context.Context, store MessageStore, fn func([]Message) ([]Message, error)) error {
+	rs, ok := store.(RewritableStore)
+	if !ok {
+		return fmt.Errorf("store does not support rewriting")
+	}
+	return rs.Rewrite(ctx, fn)
+}
+
+// markDeliveredOf marks messages delivered in store if it is a
+// DeliveryStore. Other stores track no delivery, so it does nothing.
+func markDeliveredOf(ctx context.Context, store MessageStore, ids ...string) error {
+	if ds, ok := store.(DeliveryStore); ok {
+		return ds.MarkDelivered(ctx, ids...)
+	}
+	return nil
+}
+
+// loadPendingOf loads the messages of store not delivered yet, all of them
+// if it is not a DeliveryStore.
+func loadPendingOf(ctx context.Context, store MessageStore) ([]Message, error) {
+	if ds, ok := store.(DeliveryStore); ok {
+		return ds.LoadPending(ctx)
+	}
+	return store.Load(ctx)
+}
+
+// loadWhere loads the messages of store matching match.
+func loadWhere(ctx context.Context, store MessageStore, match func(Message) bool) ([]Message, error) {
+	messages, err := store.Load(ctx)
+	if err != nil {
+		return nil, err
+	}
+	matched := messages[:0]
+	for _, msg := range messages {
+		if match(msg) {
+			matched = append(matched, msg)
+		}
+	}
+	return matched, nil
+}
+
+// loadByTopicOf implements QueryableStore.LoadByTopic for store.
+func loadByTopicOf(ctx context.Context, store MessageStore, topic string) ([]Message, error) {
+	if qs, ok := store.(QueryableStore); ok {
+		return qs.LoadByTopic(ctx, topic)
+	}
+	return loadWhere(ctx, store, func(msg Message) bool { return msg.Topic() == topic })
+}
+
+// loadAfterOf implements QueryableStore.LoadAfter for store.
+func loadAfterOf(ctx context.Context, store MessageStore, after time.Time) ([]Message, error) {
+	if qs, ok := store.(QueryableStore); ok {
+		return qs.LoadAfter(ctx, after)
+	}
+	return loadWhere(ctx, store, func(msg Message) bool { return msg.Timestamp().After(after) })
+}
+
+// loadPageOf implements QueryableStore.LoadPage for store.
+func loadPageOf(ctx context.Context, store MessageStore, offset, limit int) ([]Message, error) {
+	if qs, ok := store.(QueryableStore); ok {
+		return qs.LoadPage(ctx, offset, limit)
+	}
+	if offset < 0 || limit < 0 {
+		return nil, fmt.Errorf("invalid page: offset %d, limit %d", offset, limit)
+	}
+
+	messages, err := store.Load(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if offset >= len(messages) {
+		return []Message{}, nil
+	}
+	messages = messages[offset:]
+	if limit < len(messages) {
+		messages = messages[:limit]
+	}
+	return messages, nil
+}
+
+// countOf implements QueryableStore.Count for store.
+func countOf(ctx context.Context, store MessageStore) (int, error) {
+	if qs, ok := store.(QueryableStore); ok {
+		return qs.Count(ctx)
+	}
+	messages, err := store.Load(ctx)
+	return len(messages), err
+}
+
+// clearBeforeOf implements QueryableStore.ClearBefore for store, which
+// must otherwise be a RewritableStore.
+func clearBeforeOf(ctx context.Context, store MessageStore, before time.Time) error {
+	if qs, ok := store.(QueryableStore); ok {
+		return qs.ClearBefore(ctx, before)
+	}
+	return rewriteOf(ctx, store, func(messages []Message) ([]Message, error) {
+		kept := messages[:0]
+		for _, msg := range messages {
+			if !msg.Timestamp().Before(before) {
+				kept = append(kept, msg)
+			}
+		}
+		return kept, nil
+	})
+}
+
+// purgeExpiredOf implements ExpiringStore.PurgeExpired for store, which
+// must otherwise be a RewritableStore.
+func purgeExpiredOf(ctx context.Context, store MessageStore, now time.Time) (int, error) {
+	if es, ok := store.(ExpiringStore); ok {
+		return es.PurgeExpired(ctx, now)
+	}
+	purged := 0
+	err := rewriteOf(ctx, store, func(messages []Message) ([]Message, error) {
+		kept := purgeExpired(messages, now)
+		purged = len(messages) - len(kept)
+		return kept, nil
+	})
+	return purged, err
+}
+
+// vacuumOf vacuums store, which must be a VacuumableStore.
+func vacuumOf(ctx context.Context, store MessageStore) error {
+	vs, ok := store.(VacuumableStore)
+	if !ok {
+		return fmt.Errorf("store does not support vacuuming")
+	}
+	return vs.Vacuum(ctx)
+}
@@ -1,16 +1,144 @@
 package scela
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"reflect"
+	"sort"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
+// ErrDuplicateSubscription is returned by Subscribe when the same handler is
+// already subscribed to the pattern and DuplicateReject is in effect.
+var ErrDuplicateSubscription = errors.New("duplicate subscription")
+
+// ErrSubscriptionLimit is returned by Subscribe when a subscription limit
+// configured with WithMaxSubscriptions or WithMaxWildcardSubscriptions
+// would be exceeded.
+var ErrSubscriptionLimit = errors.New("subscription limit reached")
+
+// DuplicatePolicy controls what happens when the same handler subscribes to
+// the same pattern more than once.
+type DuplicatePolicy int
+
+const (
+	// DuplicateAllow registers every subscription (default).
+	DuplicateAllow DuplicatePolicy = iota
+	// DuplicateReject fails the subscription with ErrDuplicateSubscription.
+	DuplicateReject
+	// DuplicateConsolidate returns the existing subscription instead of
+	// registering a new one. Unsubscribing any of the returned handles
+	// removes the shared registration.
+	DuplicateConsolidate
+)
+
+// KeyedHandler is implemented by handlers that supply their own identity
+// for duplicate detection.
+type KeyedHandler interface {
+	Handler
+
+	// SubscriptionKey returns a key identifying the handler.
+	SubscriptionKey() string
+}
+
+// keyedHandler attaches a key to a handler.
+type keyedHandler struct {
+	Handler
+	key string
+}
+
+// SubscriptionKey implements KeyedHandler.
+func (h keyedHandler) SubscriptionKey() string {
+	return h.key
+}
+
+// WithHandlerKey attaches a key to handler so duplicate subscriptions can be
+// detected. Use it for HandlerFunc values, which have no comparable identity.
+func WithHandlerKey(key string, handler Handler) KeyedHandler {
+	return keyedHandler{Handler: handler, key: key}
+}
+
+// handlerIdentity returns a comparable value identifying a handler: its
+// SubscriptionKey if it has one, otherwise the handler itself when it is a
+// pointer.
+func handlerIdentity(handler Handler) (interface{}, bool) {
+	if kh, ok := handler.(KeyedHandler); ok {
+		return "key:" + kh.SubscriptionKey(), true
+	}
+	if reflect.TypeOf(handler).Kind() == reflect.Ptr {
+		return handler, true
+	}
+	return nil, false
+}
+
 // subscription implements the Subscription interface.
 type subscription struct {
 	id      string
 	pattern string
 	handler Handler
 	bus     *bus
+
+	seq          uint64 // registration order
+	name         string
+	after        []string
+	config       subscriptionConfig
+	createdAt    time.Time
+	stack        string
+	deliveries   atomic.Int64
+	lastDelivery atomic.Int64 // UnixNano of the last delivery, 0 if none
+
+	// removed stops new invocations once the subscription is removed;
+	// inflight tracks the ones still running.
+	mu       sync.RWMutex
+	removed  bool
+	inflight sync.WaitGroup
+}
+
+// handle delivers msg to the subscription handler and records the delivery.
+// Messages arriving after the subscription was removed are dropped.
+func (s *subscription) handle(ctx context.Context, msg Message) error {
+	s.mu.RLock()
+	if s.removed {
+		s.mu.RUnlock()
+		return nil
+	}
+	s.inflight.Add(1)
+	s.mu.RUnlock()
+	defer s.inflight.Done()
+
+	s.deliveries.Add(1)
+	s.lastDelivery.Store(time.Now().UnixNano())
+
+	// Messages published by the handler are attributed to its owner
+	if s.config.owner != "" {
+		ctx = ContextWithIdentity(ctx, s.config.owner)
+	}
+	if s.config.manualAck {
+		a := newAcker()
+		err := s.handler.Handle(context.WithValue(ctx, ackerContextKey{}, a), msg)
+		return a.handlerReturned(err)
+	}
+	return s.handler.Handle(ctx, msg)
+}
+
+// info returns a snapshot of the subscription.
+func (s *subscription) info() SubscriptionInfo {
+	info := SubscriptionInfo{
+		ID:         s.id,
+		Pattern:    s.pattern,
+		CreatedAt:  s.createdAt,
+		Owner:      s.config.owner,
+		QueueGroup: s.config.queueGroup,
+		Deliveries: s.deliveries.Load(),
+		Stack:      s.stack,
+	}
+	if last := s.lastDelivery.Load(); last != 0 {
+		info.LastDelivery = time.Unix(0, last)
+	}
+	return info
 }
 
 // Topic returns the subscription pattern.
@@ -23,12 +151,94 @@ func (s *subscription) Unsubscribe() error {
 	return s.bus.unsubscribe(s.id)
 }
 
+// UnsubscribeAndWait removes the subscription and waits for in-flight
+// invocations of its handler to finish.
+func (s *subscription) UnsubscribeAndWait(ctx context.Context) error {
+	if err := s.Unsubscribe(); err != nil {
+		return err
+	}
+
+	done := make(chan struct{})
+	go func() {
+		s.inflight.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("handler still running after unsubscribe: %w", ctx.Err())
+	}
+}
+
+// isRemoved reports whether the subscription was removed.
+func (s *subscription) isRemoved() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.removed
+}
+
+// markRemoved prevents new handler invocations.
+func (s *subscription) markRemoved() {
+	s.mu.Lock()
+	s.removed = true
+	s.mu.Unlock()
+}
+
+// SubscriptionOption configures a subscription created with
+// SubscribeWithOptions.
+type SubscriptionOption func(*subscriptionConfig)
+
+// subscriptionConfig holds per-subscription settings.
+type subscriptionConfig struct {
+	name  string
+	after []string
+
+	// Retry policy overriding the bus defaults
+	maxRetries    int
+	hasMaxRetries bool
+	backoff       Backoff
+	dlqHandler    Handler
+	dlqTopic      string
+
+	// owner is the caller identity that made the subscription, see As.
+	owner string
+
+	// onTypeMismatch handles payloads SubscribeTyped cannot convert.
+	onTypeMismatch TypeMismatchHandler
+
+	// manualAck makes handlers acknowledge messages, see WithManualAck.
+	manualAck         bool
+	visibilityTimeout time.Duration
+
+	// queueGroup shares messages among the group members, see
+	// WithQueueGroup.
+	queueGroup string
+}
+
 // subscriptionRegistry manages all subscriptions.
 type subscriptionRegistry struct {
 	mu            sync.RWMutex
 	subscriptions map[string]*subscription // id -> subscription
 	patterns      map[string][]string      // pattern -> []subscription IDs
-	matcher       *patternMatcher
+	trie          *subscriptionTrie        // pattern segments -> subscriptions
+	duplicates    DuplicatePolicy
+
+	// Limits on registrations; zero means unlimited.
+	maxSubscriptions int
+	maxWildcards     int
+	wildcards        int
+
+	// captureStacks records the creation stack of each subscription.
+	captureStacks bool
+
+	names   map[string]string // subscription name -> id
+	nextSeq uint64
+
+	// queueTurns counts the messages given to each queue group.
+	queueMu    sync.Mutex
+	queueTurns map[string]uint64
 }
 
 // newSubscriptionRegistry creates a new subscription registry.
@@ -36,47 +246,183 @@ func newSubscriptionRegistry() *subscriptionRegistry {
 	return &subscriptionRegistry{
 		subscriptions: make(map[string]*subscription),
 		patterns:      make(map[string][]string),
-		matcher:       newPatternMatcher(),
+		names:         make(map[string]string),
+		trie:          newSubscriptionTrie(),
+		queueTurns:    make(map[string]uint64),
 	}
 }
 
 // Add adds a new subscription.
 func (sr *subscriptionRegistry) Add(pattern string, handler Handler, bus *bus) (*subscription, error) {
+	return sr.AddWithConfig(pattern, handler, bus, subscriptionConfig{})
+}
+
+// AddWithConfig adds a new subscription with per-subscription settings.
+func (sr *subscriptionRegistry) AddWithConfig(
+	pattern string, handler Handler, bus *bus, cfg subscriptionConfig,
+) (*subscription, error) {
 	if pattern == "" {
-		return nil, fmt.Errorf("subscription pattern cannot be empty")
+		return nil, fmt.Errorf("%w: subscription pattern cannot be empty", ErrInvalidPattern)
 	}
 	if handler == nil {
-		return nil, fmt.Errorf("handler cannot be nil")
+		return nil, ErrNilHandler
 	}
 
-	sub := &subscription{
-		id:      generateID(),
-		pattern: pattern,
-		handler: handler,
-		bus:     bus,
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+
+	sub, _, err := sr.add(pattern, handler, bus, cfg)
+	return sub, err
+}
+
+// addSpec is a subscription to add with AddAll.
+type addSpec struct {
+	pattern string
+	handler Handler
+	config  subscriptionConfig
+}
+
+// AddAll adds several subscriptions at once: either all of them are added,
+// or none is and the error of the first failing one is returned. Since the
+// lock is held throughout, no message is matched against part of them.
+func (sr *subscriptionRegistry) AddAll(specs []addSpec, bus *bus) ([]*subscription, error) {
+	for _, spec := range specs {
+		if spec.pattern == "" {
+			return nil, fmt.Errorf("%w: subscription pattern cannot be empty", ErrInvalidPattern)
+		}
+		if spec.handler == nil {
+			return nil, fmt.Errorf("failed to subscribe to %q: %w", spec.pattern, ErrNilHandler)
+		}
 	}
 
 	sr.mu.Lock()
 	defer sr.mu.Unlock()
 
+	subs := make([]*subscription, 0, len(specs))
+	var created []string
+	for _, spec := range specs {
+		sub, isNew, err := sr.add(spec.pattern, spec.handler, bus, spec.config)
+		if err != nil {
+			for i := len(created) - 1; i >= 0; i-- {
+				_ = sr.remove(created[i])
+			}
+			return nil, fmt.Errorf("failed to subscribe to %q: %w", spec.pattern, err)
+		}
+		if isNew {
+			created = append(created, sub.id)
+		}
+		subs = append(subs, sub)
+	}
+	return subs, nil
+}
+
+// add adds a subscription, or returns the existing duplicate the duplicate
+// policy keeps instead. It reports whether the subscription is new. Must be
+// called with the lock held.
+func (sr *subscriptionRegistry) add(
+	pattern string, handler Handler, bus *bus, cfg subscriptionConfig,
+) (*subscription, bool, error) {
+	if sr.duplicates != DuplicateAllow {
+		if existing := sr.findDuplicate(pattern, handler); existing != nil {
+			if sr.duplicates == DuplicateReject {
+				return nil, false, fmt.Errorf("%w: handler already subscribed to %q", ErrDuplicateSubscription, pattern)
+			}
+			return existing, false, nil
+		}
+	}
+
+	wildcard := isWildcardPattern(pattern)
+	if err := sr.checkLimits(pattern, wildcard); err != nil {
+		return nil, false, err
+	}
+	if err := sr.checkDependencies(cfg); err != nil {
+		return nil, false, err
+	}
+
+	sub := &subscription{
+		id:        generateID(),
+		pattern:   pattern,
+		handler:   handler,
+		bus:       bus,
+		seq:       sr.nextSeq,
+		name:      cfg.name,
+		after:     cfg.after,
+		config:    cfg,
+		createdAt: time.Now(),
+	}
+	sr.nextSeq++
+	if sr.captureStacks {
+		sub.stack = captureStack()
+	}
+
 	sr.subscriptions[sub.id] = sub
 	sr.patterns[pattern] = append(sr.patterns[pattern], sub.id)
+	sr.trie.Insert(sub)
+	if sub.name != "" {
+		sr.names[sub.name] = sub.id
+	}
+	if wildcard {
+		sr.wildcards++
+	}
+
+	return sub, true, nil
+}
+
+// checkLimits returns an error if adding a subscription to pattern would
+// exceed a configured limit. Must be called with the lock held.
+func (sr *subscriptionRegistry) checkLimits(pattern string, wildcard bool) error {
+	if sr.maxSubscriptions > 0 && len(sr.subscriptions) >= sr.maxSubscriptions {
+		return fmt.Errorf("%w: cannot subscribe to %q, %d subscriptions registered (max %d)",
+			ErrSubscriptionLimit, pattern, len(sr.subscriptions), sr.maxSubscriptions)
+	}
+	if wildcard && sr.maxWildcards > 0 && sr.wildcards >= sr.maxWildcards {
+		return fmt.Errorf("%w: cannot subscribe to wildcard pattern %q, %d wildcard subscriptions registered (max %d)",
+			ErrSubscriptionLimit, pattern, sr.wildcards, sr.maxWildcards)
+	}
+	return nil
+}
+
+// findDuplicate returns the subscription of the same handler to pattern, if
+// any. Must be called with the lock held.
+func (sr *subscriptionRegistry) findDuplicate(pattern string, handler Handler) *subscription {
+	identity, ok := handlerIdentity(handler)
+	if !ok {
+		return nil
+	}
 
-	return sub, nil
+	for _, id := range sr.patterns[pattern] {
+		sub := sr.subscriptions[id]
+		if existing, ok := handlerIdentity(sub.handler); ok && existing == identity {
+			return sub
+		}
+	}
+	return nil
 }
 
 // Remove removes a subscription by ID.
 func (sr *subscriptionRegistry) Remove(id string) error {
 	sr.mu.Lock()
 	defer sr.mu.Unlock()
+	return sr.remove(id)
+}
 
+// remove removes a subscription by ID. Must be called with the lock held.
+func (sr *subscriptionRegistry) remove(id string) error {
 	sub, exists := sr.subscriptions[id]
 	if !exists {
-		return fmt.Errorf("subscription not found: %s", id)
+		return fmt.Errorf("%w: %s", ErrSubscriptionNotFound, id)
 	}
 
 	// Remove from subscriptions
 	delete(sr.subscriptions, id)
+	sr.trie.Remove(sub)
+	sub.markRemoved()
+	if sub.name != "" {
+		delete(sr.names, sub.name)
+	}
+	if isWildcardPattern(sub.pattern) {
+		sr.wildcards--
+	}
 
 	// Remove from patterns
 	pattern := sub.pattern
@@ -96,29 +442,53 @@ func (sr *subscriptionRegistry) Remove(id string) error {
 	return nil
 }
 
-// GetHandlers returns all handlers that match the topic.
-func (sr *subscriptionRegistry) GetHandlers(topic string) []Handler {
+// GetSubscriptions returns all subscriptions that match the topic.
+func (sr *subscriptionRegistry) GetSubscriptions(topic string) []*subscription {
+	return sr.collectSubscriptions([]string{topic})
+}
+
+// GetSubscriptionsWithAncestors returns all subscriptions that match the
+// topic or any of its parent topics. Publishing "orders.eu.created" reaches
+// subscribers of "orders.eu.created", "orders.eu" and "orders". Each
+// subscription is returned at most once.
+func (sr *subscriptionRegistry) GetSubscriptionsWithAncestors(topic string) []*subscription {
+	return sr.collectSubscriptions(append([]string{topic}, topicAncestors(topic)...))
+}
+
+// collectSubscriptions returns the deduplicated subscriptions matching any
+// of the topics.
+func (sr *subscriptionRegistry) collectSubscriptions(topics []string) []*subscription {
 	sr.mu.RLock()
 	defer sr.mu.RUnlock()
 
-	var handlers []Handler
-	seen := make(map[string]bool)
-
-	// Check each pattern for matches
-	for pattern, ids := range sr.patterns {
-		if sr.matcher.Match(pattern, topic) {
-			for _, id := range ids {
-				if !seen[id] {
-					if sub, ok := sr.subscriptions[id]; ok {
-						handlers = append(handlers, sub.handler)
-						seen[id] = true
-					}
-				}
+	var subs []*subscription
+	seen := make(map[*subscription]bool)
+
+	for _, topic := range topics {
+		sr.trie.Match(topic, func(sub *subscription) {
+			if !seen[sub] {
+				seen[sub] = true
+				subs = append(subs, sub)
 			}
-		}
+		})
 	}
 
-	return handlers
+	return orderSubscriptions(subs)
+}
+
+// List returns a snapshot of all subscriptions, oldest first.
+func (sr *subscriptionRegistry) List() []SubscriptionInfo {
+	sr.mu.RLock()
+	defer sr.mu.RUnlock()
+
+	infos := make([]SubscriptionInfo, 0, len(sr.subscriptions))
+	for _, sub := range sr.subscriptions {
+		infos = append(infos, sub.info())
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].CreatedAt.Before(infos[j].CreatedAt)
+	})
+	return infos
 }
 
 // Count returns the total number of subscriptions.
@@ -134,4 +504,7 @@ func (sr *subscriptionRegistry) Clear() {
 	defer sr.mu.Unlock()
 	sr.subscriptions = make(map[string]*subscription)
 	sr.patterns = make(map[string][]string)
+	sr.trie = newSubscriptionTrie()
+	sr.names = make(map[string]string)
+	sr.wildcards = 0
 }
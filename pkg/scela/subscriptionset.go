@@ -0,0 +1,73 @@
+package scela
+
+import (
+	"fmt"
+	"sort"
+)
+
+// SubscriptionSpec declares one subscription of a SubscriptionSet.
+type SubscriptionSpec struct {
+	Pattern string
+	Handler Handler
+	Options []SubscriptionOption
+}
+
+// SubscriptionSet declares subscriptions registered together, such as those
+// of a module, so that a failure does not leave part of them behind.
+type SubscriptionSet []SubscriptionSpec
+
+// bulkSubscriber is implemented by buses that can register a whole
+// SubscriptionSet at once.
+type bulkSubscriber interface {
+	subscribeAll(set SubscriptionSet) ([]Subscription, error)
+}
+
+// Add appends a subscription to the set and returns the set, for chaining.
+func (s *SubscriptionSet) Add(pattern string, handler Handler, opts ...SubscriptionOption) *SubscriptionSet {
+	*s = append(*s, SubscriptionSpec{Pattern: pattern, Handler: handler, Options: opts})
+	return s
+}
+
+// Register subscribes every subscription of the set on b, in order, and
+// returns them in the same order. Either all of them are registered, or
+// none is and the error of the first failing one is returned.
+//
+// Buses created by New register the whole set at once, so no message is
+// delivered to part of it. On other buses, such as facades and wrappers,
+// the subscriptions are made one by one, and those made before a failure
+// are removed.
+func (s SubscriptionSet) Register(b Subscriber) ([]Subscription, error) {
+	if bs, ok := b.(bulkSubscriber); ok {
+		return bs.subscribeAll(s)
+	}
+
+	subs := make([]Subscription, 0, len(s))
+	for _, spec := range s {
+		sub, err := b.SubscribeWithOptions(spec.Pattern, spec.Handler, spec.Options...)
+		if err != nil {
+			for i := len(subs) - 1; i >= 0; i-- {
+				_ = subs[i].Unsubscribe()
+			}
+			return nil, fmt.Errorf("failed to subscribe to %q: %w", spec.Pattern, err)
+		}
+		subs = append(subs, sub)
+	}
+	return subs, nil
+}
+
+// SubscribeAll subscribes each handler to its pattern as a SubscriptionSet,
+// in the order of the patterns: either all subscriptions are registered, or
+// none is.
+func SubscribeAll(b Subscriber, handlers map[string]Handler) ([]Subscription, error) {
+	patterns := make([]string, 0, len(handlers))
+	for pattern := range handlers {
+		patterns = append(patterns, pattern)
+	}
+	sort.Strings(patterns)
+
+	set := make(SubscriptionSet, len(patterns))
+	for i, pattern := range patterns {
+		set[i] = SubscriptionSpec{Pattern: pattern, Handler: handlers[pattern]}
+	}
+	return set.Register(b)
+}
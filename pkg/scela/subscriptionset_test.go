@@ -0,0 +1,133 @@
+package scela
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestSubscriptionSet_Register(t *testing.T) {
+	bus := New()
+	defer bus.Close()
+
+	received := make(chan string, 2)
+	record := HandlerFunc(func(ctx context.Context, msg Message) error {
+		received <- msg.Topic()
+		return nil
+	})
+
+	var set SubscriptionSet
+	set.Add("order.created", record).Add("order.shipped", record, WithSubscriptionName("shipping"))
+	subs, err := set.Register(bus)
+	if err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	if len(subs) != 2 || subs[0].Topic() != "order.created" || subs[1].Topic() != "order.shipped" {
+		t.Fatalf("expected the subscriptions in order, got %v", subs)
+	}
+
+	_ = bus.PublishSync(context.Background(), "order.shipped", 1)
+	if got := <-received; got != "order.shipped" {
+		t.Errorf("received %q, want order.shipped", got)
+	}
+}
+
+func TestSubscriptionSet_AllOrNothing(t *testing.T) {
+	noop := HandlerFunc(func(ctx context.Context, msg Message) error { return nil })
+
+	tests := []struct {
+		name string
+		bus  func() Bus
+		set  SubscriptionSet
+		want error
+	}{
+		{
+			name: "limit",
+			bus:  func() Bus { return New(WithMaxWildcardSubscriptions(1)) },
+			set: SubscriptionSet{
+				{Pattern: "order.created", Handler: noop},
+				{Pattern: "order.*", Handler: noop},
+				{Pattern: "user.*", Handler: noop},
+			},
+			want: ErrSubscriptionLimit,
+		},
+		{
+			name: "nil handler",
+			bus:  func() Bus { return New() },
+			set: SubscriptionSet{
+				{Pattern: "order.created", Handler: noop},
+				{Pattern: "order.shipped"},
+			},
+			want: ErrNilHandler,
+		},
+		{
+			name: "facade",
+			bus:  func() Bus { return As(New(WithMaxWildcardSubscriptions(1)), "billing") },
+			set: SubscriptionSet{
+				{Pattern: "order.created", Handler: noop},
+				{Pattern: "order.*", Handler: noop},
+				{Pattern: "user.*", Handler: noop},
+			},
+			want: ErrSubscriptionLimit,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bus := tt.bus()
+			defer bus.Close()
+
+			subs, err := tt.set.Register(bus)
+			if !errors.Is(err, tt.want) || subs != nil {
+				t.Fatalf("expected %v and no subscriptions, got %v, %v", tt.want, subs, err)
+			}
+			for inner := Bus(bus); inner != nil; inner = innerBus(inner) {
+				if si, ok := inner.(SubscriptionInspector); ok {
+					if n := len(si.Subscriptions()); n != 0 {
+						t.Errorf("expected no subscription left, got %d", n)
+					}
+				}
+			}
+		})
+	}
+}
+
+// nopHandler is a handler with an identity, for duplicate detection.
+type nopHandler struct{}
+
+func (h *nopHandler) Handle(ctx context.Context, msg Message) error { return nil }
+
+func TestSubscriptionSet_KeepsExistingDuplicates(t *testing.T) {
+	bus := New(WithDuplicateSubscriptions(DuplicateConsolidate), WithMaxSubscriptions(2))
+	defer bus.Close()
+
+	handler := &nopHandler{}
+	if _, err := bus.Subscribe("order.created", handler); err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+
+	set := SubscriptionSet{
+		{Pattern: "order.created", Handler: handler},
+		{Pattern: "order.shipped", Handler: handler},
+		{Pattern: "order.paid", Handler: handler},
+	}
+	if _, err := set.Register(bus); !errors.Is(err, ErrSubscriptionLimit) {
+		t.Fatalf("expected the limit to be hit, got %v", err)
+	}
+	if n := len(bus.(SubscriptionInspector).Subscriptions()); n != 1 {
+		t.Errorf("expected the existing subscription to stay, got %d subscriptions", n)
+	}
+}
+
+func TestSubscribeAll(t *testing.T) {
+	bus := New()
+	defer bus.Close()
+
+	noop := HandlerFunc(func(ctx context.Context, msg Message) error { return nil })
+	subs, err := SubscribeAll(bus, map[string]Handler{"user.created": noop, "order.created": noop})
+	if err != nil {
+		t.Fatalf("SubscribeAll: %v", err)
+	}
+	if len(subs) != 2 || subs[0].Topic() != "order.created" {
+		t.Errorf("expected the subscriptions sorted by pattern, got %v", subs)
+	}
+}
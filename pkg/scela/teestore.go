@@ -0,0 +1,224 @@
+package scela
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// TeePolicy controls how a TeeStore treats the failures of a store.
+type TeePolicy int
+
+const (
+	// TeeRequired fails the write when the store fails.
+	TeeRequired TeePolicy = iota
+	// TeeBestEffort reports failures of the store to the error handler
+	// without failing the write.
+	TeeBestEffort
+)
+
+// String returns the name of the policy.
+func (p TeePolicy) String() string {
+	switch p {
+	case TeeRequired:
+		return "required"
+	case TeeBestEffort:
+		return "best-effort"
+	default:
+		return fmt.Sprintf("TeePolicy(%d)", int(p))
+	}
+}
+
+// teeTarget is a store written to by a TeeStore.
+type teeTarget struct {
+	name   string
+	store  MessageStore
+	policy TeePolicy
+}
+
+// TeeStore writes every message to several stores, for example SQL for
+// queries and an archive, so that one publish feeds every destination.
+// Reads are served by the primary store, which also keeps the delivery
+// state and is the only one pruned by ClearBefore, PurgeExpired, Rewrite
+// and Vacuum, so that archives keep every message.
+//
+// Stores are written concurrently. A write fails if a required store
+// fails; the message may still have reached the others, since the stores
+// are not written atomically together.
+type TeeStore struct {
+	targets []teeTarget
+	onError StoreErrorHandler
+}
+
+// TeeStoreOption is a functional option for configuring a tee store.
+type TeeStoreOption func(*TeeStore)
+
+// WithTeeTarget adds a store written to after the primary one. The name
+// identifies the store in errors.
+func WithTeeTarget(name string, store MessageStore, policy TeePolicy) TeeStoreOption {
+	return func(t *TeeStore) {
+		t.targets = append(t.targets, teeTarget{name: name, store: store, policy: policy})
+	}
+}
+
+// WithTeeErrorHandler registers a handler called with the failures of
+// best-effort stores, which are not returned to the caller.
+func WithTeeErrorHandler(handler StoreErrorHandler) TeeStoreOption {
+	return func(t *TeeStore) {
+		t.onError = handler
+	}
+}
+
+// NewTeeStore creates a store writing to primary, which is required and
+// serves reads, and to the stores added with WithTeeTarget.
+func NewTeeStore(primary MessageStore, opts ...TeeStoreOption) *TeeStore {
+	t := &TeeStore{
+		targets: []teeTarget{{name: "primary", store: primary, policy: TeeRequired}},
+	}
+	for _, opt := range opts {
+		opt(t)
+	}
+	return t
+}
+
+// Primary returns the store serving reads.
+func (t *TeeStore) Primary() MessageStore {
+	return t.targets[0].store
+}
+
+// each runs fn on every store concurrently. Failures of required stores
+// are returned joined; failures of best-effort stores are reported.
+func (t *TeeStore) each(ctx context.Context, op string, msg Message, fn func(MessageStore) error) error {
+	errs := make([]error, len(t.targets))
+	var wg sync.WaitGroup
+	for i, target := range t.targets {
+		wg.Add(1)
+		go func(i int, store MessageStore) {
+			defer wg.Done()
+			errs[i] = fn(store)
+		}(i, target.store)
+	}
+	wg.Wait()
+
+	var required []error
+	for i, err := range errs {
+		if err == nil {
+			continue
+		}
+		target := t.targets[i]
+		err = fmt.Errorf("%s store: %w", target.name, err)
+		if target.policy == TeeRequired {
+			required = append(required, err)
+		} else if t.onError != nil {
+			t.onError(ctx, &StoreError{Op: op, Message: msg, Err: err})
+		}
+	}
+	return errors.Join(required...)
+}
+
+// Store implements MessageStore.
+func (t *TeeStore) Store(ctx context.Context, msg Message) error {
+	return t.each(ctx, "store", msg, func(s MessageStore) error {
+		return s.Store(ctx, msg)
+	})
+}
+
+// StoreBatch implements BatchStore, storing the batch in every store. It is
+// atomic in the stores implementing BatchStore.
+func (t *TeeStore) StoreBatch(ctx context.Context, msgs []Message) error {
+	return t.each(ctx, "store_batch", nil, func(s MessageStore) error {
+		return storeBatchOf(ctx, s, msgs)
+	})
+}
+
+// Load implements MessageStore, loading from the primary store.
+func (t *TeeStore) Load(ctx context.Context) ([]Message, error) {
+	return t.Primary().Load(ctx)
+}
+
+// Stats implements StoreStats for the primary store.
+func (t *TeeStore) Stats(ctx context.Context) (StoreStatistics, error) {
+	return statsOf(ctx, t.Primary())
+}
+
+// Clear implements MessageStore, clearing every store.
+func (t *TeeStore) Clear(ctx context.Context) error {
+	return t.each(ctx, "clear", nil, func(s MessageStore) error {
+		return s.Clear(ctx)
+	})
+}
+
+// Close implements MessageStore, closing every store.
+func (t *TeeStore) Close() error {
+	return t.each(context.Background(), "close", nil, func(s MessageStore) error {
+		return s.Close()
+	})
+}
+
+// Flush implements StoreFlusher, flushing every store with FlushStore.
+func (t *TeeStore) Flush(ctx context.Context) error {
+	return t.each(ctx, "flush", nil, func(s MessageStore) error {
+		return FlushStore(ctx, s)
+	})
+}
+
+// CloseContext implements StoreCloser, closing every store with
+// CloseStore.
+func (t *TeeStore) CloseContext(ctx context.Context) error {
+	return t.each(ctx, "close", nil, func(s MessageStore) error {
+		return CloseStore(ctx, s)
+	})
+}
+
+// MarkDelivered implements DeliveryStore for the primary store.
+func (t *TeeStore) MarkDelivered(ctx context.Context, ids ...string) error {
+	return markDeliveredOf(ctx, t.Primary(), ids...)
+}
+
+// LoadPending implements DeliveryStore for the primary store.
+func (t *TeeStore) LoadPending(ctx context.Context) ([]Message, error) {
+	return loadPendingOf(ctx, t.Primary())
+}
+
+// LoadByTopic implements QueryableStore for the primary store, as do the
+// other queries.
+func (t *TeeStore) LoadByTopic(ctx context.Context, topic string) ([]Message, error) {
+	return loadByTopicOf(ctx, t.Primary(), topic)
+}
+
+// LoadAfter implements QueryableStore.
+func (t *TeeStore) LoadAfter(ctx context.Context, after time.Time) ([]Message, error) {
+	return loadAfterOf(ctx, t.Primary(), after)
+}
+
+// LoadPage implements QueryableStore.
+func (t *TeeStore) LoadPage(ctx context.Context, offset, limit int) ([]Message, error) {
+	return loadPageOf(ctx, t.Primary(), offset, limit)
+}
+
+// Count implements QueryableStore.
+func (t *TeeStore) Count(ctx context.Context) (int, error) {
+	return countOf(ctx, t.Primary())
+}
+
+// ClearBefore implements QueryableStore for the primary store.
+func (t *TeeStore) ClearBefore(ctx context.Context, before time.Time) error {
+	return clearBeforeOf(ctx, t.Primary(), before)
+}
+
+// PurgeExpired implements ExpiringStore for the primary store.
+func (t *TeeStore) PurgeExpired(ctx context.Context, now time.Time) (int, error) {
+	return purgeExpiredOf(ctx, t.Primary(), now)
+}
+
+// Rewrite implements RewritableStore for the primary store.
+func (t *TeeStore) Rewrite(ctx context.Context, fn func([]Message) ([]Message, error)) error {
+	return rewriteOf(ctx, t.Primary(), fn)
+}
+
+// Vacuum implements VacuumableStore for the primary store.
+func (t *TeeStore) Vacuum(ctx context.Context) error {
+	return vacuumOf(ctx, t.Primary())
+}
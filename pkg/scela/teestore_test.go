@@ -0,0 +1,155 @@
+package scela
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTeeStore_WritesEveryStore(t *testing.T) {
+	primary, archive := NewInMemoryStore(0), NewInMemoryStore(0)
+	store := NewTeeStore(primary, WithTeeTarget("archive", archive, TeeRequired))
+	ctx := context.Background()
+
+	pb := NewPersistentBus(New(), store)
+	defer pb.Close()
+	if err := pb.Publish(ctx, "orders", "o-1"); err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+
+	for name, s := range map[string]MessageStore{"primary": primary, "archive": archive} {
+		msgs, _ := s.Load(ctx)
+		if len(msgs) != 1 || msgs[0].Payload() != "o-1" {
+			t.Errorf("%s store holds %v", name, msgs)
+		}
+	}
+	stats, err := store.Stats(ctx)
+	if err != nil || stats.MessageCount != 1 {
+		t.Errorf("Stats() = %+v, %v", stats, err)
+	}
+}
+
+func TestTeeStore_ForwardsStoreInterfaces(t *testing.T) {
+	primary, archive := NewInMemoryStore(0), NewInMemoryStore(0)
+	store := NewTeeStore(primary, WithTeeTarget("archive", archive, TeeRequired))
+	ctx := context.Background()
+
+	pb := NewPersistentBus(New(), store)
+	defer pb.Close()
+	delivered := make(chan Message, 2)
+	_, _ = pb.Subscribe("orders", HandlerFunc(func(ctx context.Context, msg Message) error {
+		delivered <- msg
+		return nil
+	}))
+	err := pb.PublishBatch(ctx, []TopicPayload{{Topic: "orders", Payload: "o-1"}, {Topic: "users", Payload: "u-1"}})
+	if err != nil {
+		t.Fatalf("PublishBatch() error = %v", err)
+	}
+	<-delivered
+
+	if msgs, _ := archive.Load(ctx); len(msgs) != 2 {
+		t.Errorf("Expected the batch in the archive, got %v", msgs)
+	}
+	waitFor(t, func() bool { return len(pendingPayloads(t, store)) == 1 })
+	if orders, _ := store.LoadByTopic(ctx, "orders"); len(orders) != 1 || orders[0].Payload() != "o-1" {
+		t.Errorf("LoadByTopic() = %v, want the order", orders)
+	}
+	if n, _ := store.Count(ctx); n != 2 {
+		t.Errorf("Count() = %d, want 2", n)
+	}
+
+	if err := store.ClearBefore(ctx, time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("ClearBefore() error = %v", err)
+	}
+	if n, _ := primary.Count(ctx); n != 0 {
+		t.Errorf("Expected the primary store cleared, got %d messages", n)
+	}
+	if n, _ := archive.Count(ctx); n != 2 {
+		t.Errorf("Expected the archive to keep its messages, got %d", n)
+	}
+}
+
+func TestTeeStore_RequiredFailure(t *testing.T) {
+	primary := NewInMemoryStore(0)
+	broken := &failingStore{inner: NewInMemoryStore(0)}
+	store := NewTeeStore(primary, WithTeeTarget("archive", broken, TeeRequired))
+
+	err := store.Store(context.Background(), NewMessage("orders", "o-1"))
+	if err == nil || !strings.Contains(err.Error(), "archive store") {
+		t.Fatalf("expected the archive failure, got %v", err)
+	}
+}
+
+func TestTeeStore_BestEffortFailure(t *testing.T) {
+	primary := NewInMemoryStore(0)
+	broken := &failingStore{inner: NewInMemoryStore(0)}
+
+	var reported []*StoreError
+	store := NewTeeStore(primary,
+		WithTeeTarget("archive", broken, TeeBestEffort),
+		WithTeeErrorHandler(func(ctx context.Context, err *StoreError) {
+			reported = append(reported, err)
+		}),
+	)
+
+	msg := NewMessage("orders", "o-1")
+	if err := store.Store(context.Background(), msg); err != nil {
+		t.Fatalf("Store() error = %v", err)
+	}
+	if len(reported) != 1 || reported[0].Op != "store" || reported[0].Message != msg {
+		t.Fatalf("unexpected reported errors %v", reported)
+	}
+	if !strings.Contains(reported[0].Error(), "archive store") {
+		t.Errorf("expected the store name in %q", reported[0].Error())
+	}
+	if n, _ := primary.Count(context.Background()); n != 1 {
+		t.Errorf("expected the primary store to hold the message, got %d", n)
+	}
+}
+
+func TestTeeStore_PrimaryFailure(t *testing.T) {
+	broken := &failingStore{inner: NewInMemoryStore(0)}
+	archive := NewInMemoryStore(0)
+	store := NewTeeStore(broken, WithTeeTarget("archive", archive, TeeBestEffort))
+
+	err := store.Store(context.Background(), NewMessage("orders", "o-1"))
+	if err == nil || !strings.Contains(err.Error(), "primary store") {
+		t.Fatalf("expected the primary failure, got %v", err)
+	}
+}
+
+func TestTeeStore_ClearAndClose(t *testing.T) {
+	primary, archive := NewInMemoryStore(0), NewInMemoryStore(0)
+	closing := &closeOrderStore{InMemoryStore: NewInMemoryStore(0)}
+	store := NewTeeStore(primary,
+		WithTeeTarget("archive", archive, TeeRequired),
+		WithTeeTarget("audit", closing, TeeBestEffort),
+	)
+	ctx := context.Background()
+
+	_ = store.Store(ctx, NewMessage("orders", "o-1"))
+	if err := store.Clear(ctx); err != nil {
+		t.Fatalf("Clear() error = %v", err)
+	}
+	for _, s := range []*InMemoryStore{primary, archive} {
+		if n, _ := s.Count(ctx); n != 0 {
+			t.Errorf("expected every store cleared, got %d messages", n)
+		}
+	}
+	if err := store.Close(); err != nil {
+		t.Errorf("Close() error = %v", err)
+	}
+	if !closing.closed {
+		t.Error("expected every store closed")
+	}
+}
+
+func TestTeePolicy_String(t *testing.T) {
+	if TeeRequired.String() != "required" || TeeBestEffort.String() != "best-effort" {
+		t.Error("unexpected policy names")
+	}
+	if TeePolicy(7).String() != "TeePolicy(7)" {
+		t.Error("unexpected name for an unknown policy")
+	}
+}
@@ -0,0 +1,176 @@
+package scela
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// wheelTick is the resolution of the timer wheel.
+	wheelTick = 10 * time.Millisecond
+	// wheelSlots is the number of slots of the timer wheel, one turn
+	// covering wheelSlots*wheelTick.
+	wheelSlots = 512
+)
+
+// wheelEntry is a message waiting in the timer wheel.
+type wheelEntry struct {
+	env *Envelope
+	at  time.Time
+
+	// rounds is the number of turns left before the entry is due.
+	rounds int
+
+	// due is called once the message is queued, see dueHookContextKey.
+	due func(Message)
+}
+
+// timerWheel is a hashed timing wheel holding scheduled messages until they
+// are due. Unlike one timer per message, adding and expiring entries costs
+// the same however many are waiting. Its goroutine is started with the
+// first entry.
+type timerWheel struct {
+	tick time.Duration
+
+	// fire is called, outside the lock, for each entry due, with a context
+	// canceled when the wheel is stopped.
+	fire func(ctx context.Context, e *wheelEntry)
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu      sync.Mutex
+	slots   [][]*wheelEntry
+	pos     int
+	count   int
+	running bool
+	stopped bool
+	done    chan struct{}
+}
+
+// newTimerWheel creates a wheel calling fire for due entries.
+func newTimerWheel(tick time.Duration, slots int, fire func(ctx context.Context, e *wheelEntry)) *timerWheel {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &timerWheel{
+		tick:   tick,
+		fire:   fire,
+		ctx:    ctx,
+		cancel: cancel,
+		slots:  make([][]*wheelEntry, slots),
+		done:   make(chan struct{}),
+	}
+}
+
+// add schedules e. It reports false if the wheel was stopped.
+func (w *timerWheel) add(e *wheelEntry) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.stopped {
+		return false
+	}
+	w.insertLocked(e)
+	w.count++
+	if !w.running {
+		w.running = true
+		go w.run()
+	}
+	return true
+}
+
+// insertLocked puts e in the slot it is due in. Must be called with the
+// lock held.
+func (w *timerWheel) insertLocked(e *wheelEntry) {
+	ticks := int((time.Until(e.at) + w.tick - 1) / w.tick)
+	if ticks < 1 {
+		ticks = 1
+	}
+	n := len(w.slots)
+	e.rounds = (ticks - 1) / n
+	slot := (w.pos + ticks) % n
+	w.slots[slot] = append(w.slots[slot], e)
+}
+
+// run advances the wheel every tick until it is stopped.
+func (w *timerWheel) run() {
+	defer close(w.done)
+
+	ticker := time.NewTicker(w.tick)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			for _, e := range w.advance() {
+				w.fire(w.ctx, e)
+			}
+		case <-w.ctx.Done():
+			return
+		}
+	}
+}
+
+// advance moves to the next slot and returns its entries that are due.
+func (w *timerWheel) advance() []*wheelEntry {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.pos = (w.pos + 1) % len(w.slots)
+	entries := w.slots[w.pos]
+	w.slots[w.pos] = nil
+
+	var due []*wheelEntry
+	now := time.Now()
+	for _, e := range entries {
+		switch {
+		case e.rounds > 0:
+			e.rounds--
+			w.slots[w.pos] = append(w.slots[w.pos], e)
+		case now.Before(e.at):
+			// The ticker lags behind or ran early; never fire before time
+			w.insertLocked(e)
+		default:
+			due = append(due, e)
+		}
+	}
+	w.count -= len(due)
+	return due
+}
+
+// len returns the number of entries waiting.
+func (w *timerWheel) len() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.count
+}
+
+// close stops the wheel, waits for entries being fired and returns the
+// entries that were not due yet, earliest first.
+func (w *timerWheel) close() []*wheelEntry {
+	w.mu.Lock()
+	if w.stopped {
+		w.mu.Unlock()
+		return nil
+	}
+	w.stopped = true
+	running := w.running
+	var pending []*wheelEntry
+	for i, entries := range w.slots {
+		pending = append(pending, entries...)
+		w.slots[i] = nil
+	}
+	w.count = 0
+	w.mu.Unlock()
+
+	w.cancel()
+	if running {
+		<-w.done
+	}
+
+	sort.SliceStable(pending, func(i, j int) bool {
+		return pending[i].at.Before(pending[j].at)
+	})
+	return pending
+}
@@ -0,0 +1,62 @@
+package scela
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTimerWheel_FiresInOrder(t *testing.T) {
+	var mu sync.Mutex
+	var fired []string
+	w := newTimerWheel(time.Millisecond, 8, func(ctx context.Context, e *wheelEntry) {
+		mu.Lock()
+		defer mu.Unlock()
+		if time.Now().Before(e.at) {
+			t.Errorf("%v fired early", e.env.msg.Payload())
+		}
+		fired = append(fired, e.env.msg.Payload().(string))
+	})
+	defer w.close()
+
+	now := time.Now()
+	// Beyond one turn of the wheel, and in the same slot as "first"
+	w.add(&wheelEntry{env: &Envelope{msg: NewMessage("t", "third")}, at: now.Add(20 * time.Millisecond)})
+	w.add(&wheelEntry{env: &Envelope{msg: NewMessage("t", "first")}, at: now.Add(4 * time.Millisecond)})
+	w.add(&wheelEntry{env: &Envelope{msg: NewMessage("t", "second")}, at: now.Add(10 * time.Millisecond)})
+
+	waitFor(t, func() bool { return w.len() == 0 })
+	mu.Lock()
+	defer mu.Unlock()
+	want := []string{"first", "second", "third"}
+	if len(fired) != len(want) {
+		t.Fatalf("expected %v, got %v", want, fired)
+	}
+	for i := range want {
+		if fired[i] != want[i] {
+			t.Fatalf("expected %v, got %v", want, fired)
+		}
+	}
+}
+
+func TestTimerWheel_Close(t *testing.T) {
+	w := newTimerWheel(time.Millisecond, 8, func(ctx context.Context, e *wheelEntry) {
+		t.Error("no entry should fire")
+	})
+
+	now := time.Now()
+	w.add(&wheelEntry{env: &Envelope{msg: NewMessage("t", "later")}, at: now.Add(2 * time.Hour)})
+	w.add(&wheelEntry{env: &Envelope{msg: NewMessage("t", "sooner")}, at: now.Add(time.Hour)})
+
+	pending := w.close()
+	if len(pending) != 2 || pending[0].env.msg.Payload() != "sooner" {
+		t.Errorf("expected both entries, earliest first, got %d", len(pending))
+	}
+	if w.add(&wheelEntry{env: &Envelope{msg: NewMessage("t", nil)}, at: now.Add(time.Hour)}) {
+		t.Error("expected add to fail after close")
+	}
+	if w.close() != nil {
+		t.Error("expected a second close to return nothing")
+	}
+}
@@ -0,0 +1,196 @@
+package scela
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+)
+
+// ErrTopicRegistered is returned when registering a topic already bound to
+// another payload type.
+var ErrTopicRegistered = errors.New("topic already registered")
+
+// TopicTypeError reports a payload published on a registered topic that is
+// not of the type the topic is bound to. It matches ErrPayloadType and is
+// permanent (see Permanent).
+type TopicTypeError struct {
+	Topic string
+	Want  reflect.Type
+	// Got is the type of the payload, nil for a nil payload.
+	Got reflect.Type
+}
+
+// Error implements the error interface.
+func (e *TopicTypeError) Error() string {
+	return fmt.Sprintf("payload on %s: got %v, want %v", e.Topic, e.Got, e.Want)
+}
+
+// Unwrap returns ErrPayloadType and the permanent marker.
+func (e *TopicTypeError) Unwrap() []error {
+	return []error{ErrPayloadType, errPermanent}
+}
+
+// TopicInfo describes a registered topic.
+type TopicInfo struct {
+	Name string
+	// Type is the payload type the topic is bound to.
+	Type        reflect.Type
+	Description string
+}
+
+// TopicOption configures a topic registered with RegisterTopic.
+type TopicOption func(*TopicInfo)
+
+// WithTopicDescription documents what the messages of a topic mean.
+func WithTopicDescription(description string) TopicOption {
+	return func(info *TopicInfo) {
+		info.Description = description
+	}
+}
+
+// TopicRegistry binds topic names to payload types. Topics are matched
+// exactly; topics that are not registered are not checked.
+type TopicRegistry struct {
+	mu     sync.RWMutex
+	topics map[string]registeredTopic
+}
+
+// registeredTopic is a topic with the check of its payloads.
+type registeredTopic struct {
+	info  TopicInfo
+	check func(payload interface{}) bool
+}
+
+// NewTopicRegistry creates an empty topic registry.
+func NewTopicRegistry() *TopicRegistry {
+	return &TopicRegistry{topics: make(map[string]registeredTopic)}
+}
+
+// Topic is a topic bound to the payload type T, through which payloads are
+// published and handlers subscribed with T in their signature. Declaring
+// topics as package variables gives generated or hand-written code a single
+// place naming both the topic and its type:
+//
+//	var UserCreated = scela.MustRegisterTopic[UserCreatedEvent](topics, "user.created")
+type Topic[T any] struct {
+	name string
+}
+
+// RegisterTopic binds topic to the payload type T in r. Registering a topic
+// again with the same type applies opts to it; registering it with another
+// type returns ErrTopicRegistered. Wildcard patterns are rejected with
+// ErrInvalidPattern, as a topic binds a single name.
+func RegisterTopic[T any](r *TopicRegistry, topic string, opts ...TopicOption) (Topic[T], error) {
+	if topic == "" || isWildcardPattern(topic) {
+		return Topic[T]{}, fmt.Errorf("%w: cannot register topic %q", ErrInvalidPattern, topic)
+	}
+
+	info := TopicInfo{Name: topic, Type: reflect.TypeOf((*T)(nil)).Elem()}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if prev, exists := r.topics[topic]; exists {
+		if prev.info.Type != info.Type {
+			return Topic[T]{}, fmt.Errorf("%w: %s is bound to %v", ErrTopicRegistered, topic, prev.info.Type)
+		}
+		info = prev.info
+	}
+	for _, opt := range opts {
+		opt(&info)
+	}
+	r.topics[topic] = registeredTopic{
+		info: info,
+		check: func(payload interface{}) bool {
+			switch p := payload.(type) {
+			case T:
+				return true
+			case *T:
+				return p != nil
+			}
+			return false
+		},
+	}
+	return Topic[T]{name: topic}, nil
+}
+
+// MustRegisterTopic is like RegisterTopic but panics on error, for topics
+// declared as package variables.
+func MustRegisterTopic[T any](r *TopicRegistry, topic string, opts ...TopicOption) Topic[T] {
+	t, err := RegisterTopic[T](r, topic, opts...)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+// Lookup returns the description of topic, if registered.
+func (r *TopicRegistry) Lookup(topic string) (TopicInfo, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	t, ok := r.topics[topic]
+	return t.info, ok
+}
+
+// Topics returns the registered topics, sorted by name.
+func (r *TopicRegistry) Topics() []TopicInfo {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	topics := make([]TopicInfo, 0, len(r.topics))
+	for _, t := range r.topics {
+		topics = append(topics, t.info)
+	}
+	sort.Slice(topics, func(i, j int) bool { return topics[i].Name < topics[j].Name })
+	return topics
+}
+
+// Check returns a TopicTypeError if topic is registered and payload is
+// neither of its type nor a non-nil pointer to it.
+func (r *TopicRegistry) Check(topic string, payload interface{}) error {
+	r.mu.RLock()
+	t, ok := r.topics[topic]
+	r.mu.RUnlock()
+
+	if !ok || t.check(payload) {
+		return nil
+	}
+	return &TopicTypeError{Topic: topic, Want: t.info.Type, Got: reflect.TypeOf(payload)}
+}
+
+// WithTopicRegistry makes the publish methods of the bus check payloads
+// against the types of the topics of registry, returning a TopicTypeError
+// without publishing those of another type.
+func WithTopicRegistry(registry *TopicRegistry) Option {
+	return func(b *bus) {
+		b.topics = registry
+	}
+}
+
+// Name returns the name of the topic.
+func (t Topic[T]) Name() string {
+	return t.name
+}
+
+// Publish validates payload and publishes it asynchronously, like
+// PublishTyped.
+func (t Topic[T]) Publish(ctx context.Context, b Publisher, payload T) error {
+	return PublishTyped(ctx, b, t.name, payload)
+}
+
+// PublishSync validates payload and publishes it synchronously.
+func (t Topic[T]) PublishSync(ctx context.Context, b Publisher, payload T) error {
+	if err := validatePayload(&payload); err != nil {
+		return err
+	}
+	return b.PublishSync(ctx, t.name, payload)
+}
+
+// Subscribe subscribes fn to the topic, like SubscribeTyped.
+func (t Topic[T]) Subscribe(b Subscriber, fn func(ctx context.Context, payload T) error, opts ...SubscriptionOption) (Subscription, error) {
+	return SubscribeTyped(b, t.name, fn, opts...)
+}
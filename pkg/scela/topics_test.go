@@ -0,0 +1,98 @@
+package scela
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+type topicUser struct {
+	ID string `json:"id"`
+}
+
+func TestTopicRegistry(t *testing.T) {
+	registry := NewTopicRegistry()
+	users := MustRegisterTopic[topicUser](registry, "user.created", WithTopicDescription("A user signed up."))
+	_ = MustRegisterTopic[string](registry, "audit.line")
+
+	if users.Name() != "user.created" {
+		t.Errorf("Name() = %s", users.Name())
+	}
+	if _, err := RegisterTopic[topicUser](registry, "user.created"); err != nil {
+		t.Errorf("expected registering the same type again to succeed, got %v", err)
+	}
+	if _, err := RegisterTopic[int](registry, "user.created"); !errors.Is(err, ErrTopicRegistered) {
+		t.Errorf("expected a conflicting type rejected, got %v", err)
+	}
+	for _, topic := range []string{"", "user.*", "user.#"} {
+		if _, err := RegisterTopic[int](registry, topic); !errors.Is(err, ErrInvalidPattern) {
+			t.Errorf("expected %q rejected, got %v", topic, err)
+		}
+	}
+
+	topics := registry.Topics()
+	if len(topics) != 2 || topics[0].Name != "audit.line" || topics[1].Name != "user.created" {
+		t.Fatalf("Topics() = %v", topics)
+	}
+	info, ok := registry.Lookup("user.created")
+	if !ok || info.Type != reflect.TypeOf(topicUser{}) || info.Description != "A user signed up." {
+		t.Errorf("Lookup() = %+v, %v", info, ok)
+	}
+
+	for _, payload := range []interface{}{topicUser{ID: "u-1"}, &topicUser{ID: "u-1"}} {
+		if err := registry.Check("user.created", payload); err != nil {
+			t.Errorf("Check(%T) error = %v", payload, err)
+		}
+	}
+	if err := registry.Check("order.created", 42); err != nil {
+		t.Errorf("expected unregistered topics unchecked, got %v", err)
+	}
+	for _, payload := range []interface{}{"u-1", nil, (*topicUser)(nil), map[string]interface{}{"id": "u-1"}} {
+		err := registry.Check("user.created", payload)
+		var terr *TopicTypeError
+		if !errors.As(err, &terr) || terr.Want != reflect.TypeOf(topicUser{}) {
+			t.Errorf("expected Check(%T) to fail with a TopicTypeError, got %v", payload, err)
+		}
+		if !errors.Is(err, ErrPayloadType) || !IsPermanent(err) {
+			t.Errorf("expected a permanent payload type error, got %v", err)
+		}
+	}
+}
+
+func TestWithTopicRegistry(t *testing.T) {
+	registry := NewTopicRegistry()
+	users := MustRegisterTopic[topicUser](registry, "user.created")
+
+	bus := New(WithTopicRegistry(registry))
+	defer bus.Close()
+
+	delivered := make(chan topicUser, 2)
+	if _, err := users.Subscribe(bus, func(ctx context.Context, u topicUser) error {
+		delivered <- u
+		return nil
+	}); err != nil {
+		t.Fatalf("Subscribe() error = %v", err)
+	}
+
+	ctx := context.Background()
+	if err := bus.Publish(ctx, "user.created", "u-1"); !errors.Is(err, ErrPayloadType) {
+		t.Errorf("expected a wrong payload type rejected, got %v", err)
+	}
+	if err := bus.PublishBatch(ctx, []TopicPayload{{Topic: "user.created", Payload: 1}}); !errors.Is(err, ErrPayloadType) {
+		t.Errorf("expected a wrong payload type rejected in a batch, got %v", err)
+	}
+
+	if err := users.PublishSync(ctx, bus, topicUser{ID: "u-1"}); err != nil {
+		t.Fatalf("PublishSync() error = %v", err)
+	}
+	if err := users.Publish(ctx, bus, topicUser{ID: "u-2"}); err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+	if u := <-delivered; u.ID != "u-1" {
+		t.Errorf("unexpected payload %v", u)
+	}
+	if u := <-delivered; u.ID != "u-2" {
+		t.Errorf("unexpected payload %v", u)
+	}
+}
@@ -0,0 +1,115 @@
+package scela
+
+import "strings"
+
+// subscriptionTrie indexes subscriptions by pattern segment so that finding
+// the subscriptions matching a topic costs time proportional to the topic
+// depth (and the wildcards along the way) rather than to the number of
+// registered patterns. It follows the semantics of patternMatcher.Match.
+type subscriptionTrie struct {
+	root *trieNode
+}
+
+// trieNode is a pattern segment. Wildcard segments are stored as children
+// keyed "*", "#" and ">".
+type trieNode struct {
+	children map[string]*trieNode
+	subs     []*subscription
+}
+
+// newSubscriptionTrie creates an empty trie.
+func newSubscriptionTrie() *subscriptionTrie {
+	return &subscriptionTrie{root: &trieNode{}}
+}
+
+// patternSegments splits a pattern into trie segments. The lone "*" pattern
+// matches every topic, like "#".
+func patternSegments(pattern string) []string {
+	if pattern == "*" {
+		return []string{"#"}
+	}
+	return strings.Split(pattern, ".")
+}
+
+// Insert adds sub under its pattern.
+func (t *subscriptionTrie) Insert(sub *subscription) {
+	node := t.root
+	for _, seg := range patternSegments(sub.pattern) {
+		if node.children == nil {
+			node.children = make(map[string]*trieNode)
+		}
+		child, ok := node.children[seg]
+		if !ok {
+			child = &trieNode{}
+			node.children[seg] = child
+		}
+		node = child
+	}
+	node.subs = append(node.subs, sub)
+}
+
+// Remove deletes sub and prunes nodes left empty.
+func (t *subscriptionTrie) Remove(sub *subscription) {
+	t.remove(t.root, patternSegments(sub.pattern), sub)
+}
+
+// remove deletes sub below node and reports whether node became empty.
+func (t *subscriptionTrie) remove(node *trieNode, segs []string, sub *subscription) bool {
+	if len(segs) == 0 {
+		for i, s := range node.subs {
+			if s == sub {
+				node.subs = append(node.subs[:i], node.subs[i+1:]...)
+				break
+			}
+		}
+	} else if child, ok := node.children[segs[0]]; ok {
+		if t.remove(child, segs[1:], sub) {
+			delete(node.children, segs[0])
+		}
+	}
+	return len(node.subs) == 0 && len(node.children) == 0
+}
+
+// Match calls fn for every subscription whose pattern matches topic. A
+// subscription may be reported more than once when several paths match.
+func (t *subscriptionTrie) Match(topic string, fn func(*subscription)) {
+	t.match(t.root, strings.Split(topic, "."), fn)
+}
+
+// match walks the trie for the remaining topic segments.
+func (t *subscriptionTrie) match(node *trieNode, segs []string, fn func(*subscription)) {
+	// "#" matches zero or more segments, so its subtree is tried at every
+	// remaining position, including after the last segment.
+	if hash, ok := node.children["#"]; ok {
+		for i := 0; i <= len(segs); i++ {
+			t.match(hash, segs[i:], fn)
+		}
+	}
+
+	if len(segs) == 0 {
+		for _, sub := range node.subs {
+			fn(sub)
+		}
+		return
+	}
+
+	// ">" as the last pattern segment matches one or more segments
+	if gt, ok := node.children[">"]; ok {
+		for _, sub := range gt.subs {
+			fn(sub)
+		}
+		// A literal ">" segment followed by more pattern segments
+		if segs[0] == ">" {
+			t.match(gt, segs[1:], fn)
+		}
+	}
+
+	if segs[0] != ">" {
+		if child, ok := node.children[segs[0]]; ok {
+			t.match(child, segs[1:], fn)
+		}
+	}
+	if star, ok := node.children["*"]; ok && segs[0] != "*" {
+		t.match(star, segs[1:], fn)
+	}
+}
@@ -0,0 +1,89 @@
+package scela
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"testing"
+)
+
+func TestSubscriptionTrie_MatchesPatternMatcher(t *testing.T) {
+	patterns := []string{
+		"user.created", "user.*", "*.created", "*", "#", ">",
+		"user.#", "user.>", "user.#.updated", "#.updated", "*.profile.>",
+		"user.*.updated", "user.profile.updated", "a.>.b", "order.#.#",
+	}
+	topics := []string{
+		"user", "user.created", "user.updated", "order.created",
+		"user.profile.updated", "user.profile.email.updated", "order",
+		"a.>.b", "a.x.b", "order.a.b.c", "", "user.*",
+	}
+
+	trie := newSubscriptionTrie()
+	subs := make(map[*subscription]string)
+	for _, p := range patterns {
+		sub := &subscription{pattern: p}
+		trie.Insert(sub)
+		subs[sub] = p
+	}
+
+	pm := newPatternMatcher()
+	for _, topic := range topics {
+		var want []string
+		for _, p := range patterns {
+			if pm.Match(p, topic) {
+				want = append(want, p)
+			}
+		}
+
+		seen := make(map[string]bool)
+		trie.Match(topic, func(sub *subscription) { seen[subs[sub]] = true })
+		var got []string
+		for p := range seen {
+			got = append(got, p)
+		}
+
+		sort.Strings(want)
+		sort.Strings(got)
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("Topic %q matched %v, want %v", topic, got, want)
+		}
+	}
+}
+
+func TestSubscriptionTrie_RemovePrunes(t *testing.T) {
+	trie := newSubscriptionTrie()
+	a := &subscription{pattern: "user.profile.updated"}
+	b := &subscription{pattern: "user.#"}
+	trie.Insert(a)
+	trie.Insert(b)
+
+	trie.Remove(a)
+	if _, ok := trie.root.children["user"].children["profile"]; ok {
+		t.Error("Expected empty branch to be pruned")
+	}
+
+	trie.Remove(b)
+	if len(trie.root.children) != 0 {
+		t.Errorf("Expected empty trie, got %d root children", len(trie.root.children))
+	}
+}
+
+func BenchmarkRegistry_GetSubscriptions(b *testing.B) {
+	for _, n := range []int{10, 1000, 10000} {
+		b.Run(fmt.Sprintf("subscriptions=%d", n), func(b *testing.B) {
+			registry := newSubscriptionRegistry()
+			handler := HandlerFunc(func(ctx context.Context, msg Message) error { return nil })
+			for i := 0; i < n; i++ {
+				_, _ = registry.Add(fmt.Sprintf("service%d.entity.*", i), handler, nil)
+			}
+			_, _ = registry.Add("service7.#", handler, nil)
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				registry.GetSubscriptions("service7.entity.created")
+			}
+		})
+	}
+}
@@ -0,0 +1,210 @@
+package scela
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// MetadataExpiresAt holds the time a message expires at, formatted as
+// RFC 3339 with nanoseconds.
+const MetadataExpiresAt = "expires_at"
+
+// ErrMessageExpired is reported to observers for messages skipped because
+// they expired before delivery, and returned by PublishSync for a message
+// published already expired.
+var ErrMessageExpired = errors.New("message expired")
+
+// ttlContextKey is the context key under which the time to live for the
+// next published message is stored.
+type ttlContextKey struct{}
+
+// WithTTL gives every message published on the bus a time to live, unless
+// published with its own (see ContextWithTTL). Expired messages are skipped
+// by the workers, retries included. Zero or less disables the default.
+func WithTTL(ttl time.Duration) Option {
+	return func(b *bus) {
+		b.ttl = ttl
+	}
+}
+
+// WithExpirationHandler sets a handler called with the messages skipped
+// because they expired, for example to store or count them.
+func WithExpirationHandler(handler Handler) Option {
+	return func(b *bus) {
+		b.expirationHandler = handler
+	}
+}
+
+// ContextWithTTL returns a context under which messages are published with
+// the given time to live, counted from their timestamp. Unlike
+// ContextWithDeliveryDeadline, the expiry is recorded on the message, so it
+// is kept by stores and purged by a Janitor.
+func ContextWithTTL(ctx context.Context, ttl time.Duration) context.Context {
+	return context.WithValue(ctx, ttlContextKey{}, ttl)
+}
+
+// PublishWithTTL publishes payload on topic asynchronously, expiring it
+// after ttl.
+func PublishWithTTL(ctx context.Context, b Bus, topic string, payload interface{}, ttl time.Duration) error {
+	return b.Publish(ContextWithTTL(ctx, ttl), topic, payload)
+}
+
+// ExpiresAt returns the time msg expires at. It reports false if msg does
+// not expire.
+func ExpiresAt(msg Message) (time.Time, bool) {
+	s, ok := msg.Metadata()[MetadataExpiresAt].(string)
+	if !ok {
+		return time.Time{}, false
+	}
+	at, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return at, true
+}
+
+// IsExpired reports whether msg has expired at now.
+func IsExpired(msg Message, now time.Time) bool {
+	at, ok := ExpiresAt(msg)
+	return ok && !now.Before(at)
+}
+
+// stampExpiry records the expiry given by ctx on msg, or by ttl if ctx
+// carries none and ttl is positive.
+func stampExpiry(ctx context.Context, msg Message, ttl time.Duration) {
+	if _, ok := msg.Metadata()[MetadataExpiresAt]; ok {
+		return
+	}
+	if d, ok := ctx.Value(ttlContextKey{}).(time.Duration); ok {
+		ttl = d
+	}
+	if ttl > 0 {
+		msg.Metadata()[MetadataExpiresAt] = msg.Timestamp().Add(ttl).UTC().Format(time.RFC3339Nano)
+	}
+}
+
+// expire reports a message skipped because it expired.
+func (b *bus) expire(msg Message) {
+	ctx := context.Background()
+	b.observers.NotifyDrop(ctx, msg, ErrMessageExpired)
+	if b.expirationHandler != nil {
+		_ = b.expirationHandler.Handle(ctx, msg)
+	}
+}
+
+// ExpiringStore is implemented by stores that can remove expired messages.
+type ExpiringStore interface {
+	MessageStore
+
+	// PurgeExpired removes the messages expired at now and returns how many
+	// were removed.
+	PurgeExpired(ctx context.Context, now time.Time) (int, error)
+}
+
+// purgeExpired removes the expired messages of messages in place.
+func purgeExpired(messages []Message, now time.Time) []Message {
+	kept := messages[:0]
+	for _, msg := range messages {
+		if !IsExpired(msg, now) {
+			kept = append(kept, msg)
+		}
+	}
+	for i := len(kept); i < len(messages); i++ {
+		messages[i] = nil
+	}
+	return kept
+}
+
+// Janitor periodically purges expired messages from a store.
+type Janitor struct {
+	store    ExpiringStore
+	interval time.Duration
+	onError  StoreErrorHandler
+
+	mu     sync.Mutex
+	purged int
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewJanitor starts purging expired messages from store every interval,
+// until Close is called. Failures are passed to onError, which may be nil.
+func NewJanitor(store ExpiringStore, interval time.Duration, onError StoreErrorHandler) *Janitor {
+	j := &Janitor{
+		store:    store,
+		interval: interval,
+		onError:  onError,
+		done:     make(chan struct{}),
+	}
+
+	j.wg.Add(1)
+	go j.run()
+
+	return j
+}
+
+// run purges the store every interval until the janitor is closed.
+func (j *Janitor) run() {
+	defer j.wg.Done()
+
+	ticker := time.NewTicker(j.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			_, _ = j.Purge(context.Background())
+		case <-j.done:
+			return
+		}
+	}
+}
+
+// Purge removes the expired messages now and returns how many were removed.
+func (j *Janitor) Purge(ctx context.Context) (int, error) {
+	n, err := j.store.PurgeExpired(ctx, time.Now())
+	if err != nil {
+		storeErr := &StoreError{Op: "purge", Err: err}
+		if j.onError != nil {
+			j.onError(ctx, storeErr)
+		}
+		return n, storeErr
+	}
+
+	j.mu.Lock()
+	j.purged += n
+	j.mu.Unlock()
+	return n, nil
+}
+
+// Purged returns the number of messages purged so far.
+func (j *Janitor) Purged() int {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.purged
+}
+
+// Close stops the janitor.
+func (j *Janitor) Close() error {
+	select {
+	case <-j.done:
+		return fmt.Errorf("janitor already closed")
+	default:
+	}
+	close(j.done)
+	j.wg.Wait()
+	return nil
+}
+
+// WithJanitor purges expired messages from the store of the persistent bus
+// every interval, while the bus is open. It has no effect unless the store
+// implements ExpiringStore. Failures are reported like other store errors.
+func WithJanitor(interval time.Duration) PersistentBusOption {
+	return func(pb *PersistentBus) {
+		pb.janitorInterval = interval
+	}
+}
@@ -0,0 +1,194 @@
+package scela
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPublishWithTTL_SkipsExpired(t *testing.T) {
+	expired := make(chan Message, 1)
+	obs := &failureObserver{}
+	b := New(
+		WithWorkers(1),
+		WithObserver(obs),
+		WithExpirationHandler(HandlerFunc(func(ctx context.Context, msg Message) error {
+			expired <- msg
+			return nil
+		})),
+	)
+	defer b.Close()
+	release := blockWorker(t, b)
+
+	var delivered atomic.Int32
+	_, _ = b.Subscribe("quotes", HandlerFunc(func(ctx context.Context, msg Message) error {
+		delivered.Add(1)
+		return nil
+	}))
+
+	ctx := context.Background()
+	_ = PublishWithTTL(ctx, b, "quotes", "stale", time.Millisecond)
+	_ = PublishWithTTL(ctx, b, "quotes", "fresh", time.Hour)
+	time.Sleep(5 * time.Millisecond)
+	release()
+
+	select {
+	case msg := <-expired:
+		if msg.Payload() != "stale" {
+			t.Errorf("unexpected expired message %v", msg.Payload())
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expiration handler not called")
+	}
+	waitFor(t, func() bool { return delivered.Load() == 1 })
+	if err := obs.errorFor("stale"); !errors.Is(err, ErrMessageExpired) {
+		t.Errorf("expected ErrMessageExpired, got %v", err)
+	}
+}
+
+func TestWithTTL_Default(t *testing.T) {
+	b := New(WithTTL(time.Minute))
+	defer b.Close()
+
+	got := make(chan Message, 2)
+	_, _ = b.Subscribe("quotes", HandlerFunc(func(ctx context.Context, msg Message) error {
+		got <- msg
+		return nil
+	}))
+
+	ctx := context.Background()
+	_ = b.PublishSync(ctx, "quotes", "default")
+	_ = b.PublishSync(ContextWithTTL(ctx, time.Hour), "quotes", "own")
+
+	first, second := <-got, <-got
+	at, ok := ExpiresAt(first)
+	if !ok || !at.Equal(first.Timestamp().Add(time.Minute).UTC()) {
+		t.Errorf("expected the default TTL, got %v, %v", at, ok)
+	}
+	at, ok = ExpiresAt(second)
+	if !ok || !at.Equal(second.Timestamp().Add(time.Hour).UTC()) {
+		t.Errorf("expected the message TTL, got %v, %v", at, ok)
+	}
+}
+
+func TestDeadLetter_DropsExpiry(t *testing.T) {
+	dead := make(chan Message, 1)
+	b := New(WithMaxRetries(0), WithDeadLetterHandler(HandlerFunc(func(ctx context.Context, msg Message) error {
+		dead <- msg
+		return nil
+	})))
+	defer b.Close()
+
+	_, _ = b.Subscribe("quotes", HandlerFunc(func(ctx context.Context, msg Message) error {
+		return errors.New("handler error")
+	}))
+	_ = PublishWithTTL(context.Background(), b, "quotes", "q", time.Hour)
+
+	select {
+	case msg := <-dead:
+		if _, ok := ExpiresAt(msg); ok {
+			t.Error("expected the dead letter not to expire")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("message not dead-lettered")
+	}
+}
+
+// expiringMessages returns an expired and a live message.
+func expiringMessages(now time.Time) []Message {
+	stale := NewMessage("quotes", "stale")
+	stale.Metadata()[MetadataExpiresAt] = now.Add(-time.Minute).UTC().Format(time.RFC3339Nano)
+	live := NewMessage("quotes", "live")
+	live.Metadata()[MetadataExpiresAt] = now.Add(time.Hour).UTC().Format(time.RFC3339Nano)
+	return []Message{stale, live, NewMessage("quotes", "forever")}
+}
+
+func TestExpiringStores_PurgeExpired(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	sqlStore, err := NewSQLStore(SQLStoreConfig{DB: db})
+	if err != nil {
+		t.Fatalf("NewSQLStore() error = %v", err)
+	}
+
+	stores := map[string]ExpiringStore{
+		"memory": NewInMemoryStore(0),
+		"file":   NewFileStore(filepath.Join(t.TempDir(), "messages.json")),
+		"sql":    sqlStore,
+	}
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			now := time.Now()
+			for _, msg := range expiringMessages(now) {
+				_ = store.Store(ctx, msg)
+			}
+
+			n, err := store.PurgeExpired(ctx, now)
+			if err != nil || n != 1 {
+				t.Fatalf("PurgeExpired() = %d, %v, want 1", n, err)
+			}
+			left, _ := store.Load(ctx)
+			if len(left) != 2 {
+				t.Fatalf("expected 2 messages left, got %d", len(left))
+			}
+			for _, msg := range left {
+				if msg.Payload() == "stale" {
+					t.Error("expired message not purged")
+				}
+			}
+		})
+	}
+}
+
+func TestSQLStore_AddsExpiryColumn(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	// A table created before messages could expire
+	_, err := db.Exec(`CREATE TABLE scela_messages (
+		id TEXT PRIMARY KEY,
+		topic TEXT NOT NULL,
+		payload TEXT NOT NULL,
+		metadata TEXT,
+		timestamp TIMESTAMP NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	)`)
+	if err != nil {
+		t.Fatalf("create table: %v", err)
+	}
+
+	store, err := NewSQLStore(SQLStoreConfig{DB: db})
+	if err != nil {
+		t.Fatalf("NewSQLStore() error = %v", err)
+	}
+	if _, err := store.PurgeExpired(context.Background(), time.Now()); err != nil {
+		t.Errorf("PurgeExpired() error = %v", err)
+	}
+}
+
+func TestWithJanitor(t *testing.T) {
+	store := NewInMemoryStore(0)
+	pb := NewPersistentBus(New(), store, WithJanitor(5*time.Millisecond))
+
+	for _, msg := range expiringMessages(time.Now()) {
+		_ = store.Store(context.Background(), msg)
+	}
+	waitFor(t, func() bool {
+		n, _ := store.Count(context.Background())
+		return n == 2
+	})
+
+	if err := pb.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if pb.janitor.Purged() != 1 {
+		t.Errorf("expected 1 purged message, got %d", pb.janitor.Purged())
+	}
+	if err := pb.janitor.Close(); err == nil {
+		t.Error("expected an error closing the janitor twice")
+	}
+}
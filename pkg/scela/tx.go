@@ -0,0 +1,62 @@
+package scela
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// txContextKey is the context key under which TxMiddleware stores the
+// handler transaction.
+type txContextKey struct{}
+
+// ContextWithTx returns a context carrying tx as the handler transaction.
+func ContextWithTx(ctx context.Context, tx *sql.Tx) context.Context {
+	return context.WithValue(ctx, txContextKey{}, tx)
+}
+
+// TxFromContext returns the transaction opened by TxMiddleware, if any.
+func TxFromContext(ctx context.Context) (*sql.Tx, bool) {
+	tx, ok := ctx.Value(txContextKey{}).(*sql.Tx)
+	return tx, ok
+}
+
+// TxMiddleware runs handlers inside a database transaction. The transaction
+// is available to handlers through TxFromContext; it is committed when the
+// handlers succeed and rolled back when they return an error or panic (the
+// panic is re-raised after the rollback). If the context already carries a
+// transaction, it is reused and left to its owner.
+func TxMiddleware(db *sql.DB) Middleware {
+	return func(next Handler) Handler {
+		return HandlerFunc(func(ctx context.Context, msg Message) (err error) {
+			if _, ok := TxFromContext(ctx); ok {
+				return next.Handle(ctx, msg)
+			}
+
+			tx, err := db.BeginTx(ctx, nil)
+			if err != nil {
+				return fmt.Errorf("failed to begin transaction: %w", err)
+			}
+
+			defer func() {
+				if r := recover(); r != nil {
+					_ = tx.Rollback()
+					panic(r)
+				}
+			}()
+
+			if err := next.Handle(ContextWithTx(ctx, tx), msg); err != nil {
+				if rbErr := tx.Rollback(); rbErr != nil {
+					return errors.Join(err, fmt.Errorf("failed to roll back transaction: %w", rbErr))
+				}
+				return err
+			}
+
+			if err := tx.Commit(); err != nil {
+				return fmt.Errorf("failed to commit transaction: %w", err)
+			}
+			return nil
+		})
+	}
+}
@@ -0,0 +1,118 @@
+package scela
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+)
+
+func setupTxDB(t *testing.T) *sql.DB {
+	db := setupTestDB(t)
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec("CREATE TABLE orders (id TEXT PRIMARY KEY)"); err != nil {
+		t.Fatalf("Failed to create table: %v", err)
+	}
+	return db
+}
+
+func countOrders(t *testing.T, db *sql.DB) int {
+	var n int
+	if err := db.QueryRow("SELECT COUNT(*) FROM orders").Scan(&n); err != nil {
+		t.Fatalf("Failed to count orders: %v", err)
+	}
+	return n
+}
+
+func insertOrder(ctx context.Context, msg Message) error {
+	tx, ok := TxFromContext(ctx)
+	if !ok {
+		return errors.New("no transaction in context")
+	}
+	_, err := tx.ExecContext(ctx, "INSERT INTO orders (id) VALUES (?)", msg.ID())
+	return err
+}
+
+func TestTxMiddleware_Commit(t *testing.T) {
+	db := setupTxDB(t)
+	defer db.Close()
+
+	bus := New()
+	defer bus.Close()
+	bus.Use(TxMiddleware(db))
+	_, _ = bus.Subscribe("orders.created", HandlerFunc(insertOrder))
+
+	if err := bus.PublishSync(context.Background(), "orders.created", nil); err != nil {
+		t.Fatalf("PublishSync() error = %v", err)
+	}
+	if n := countOrders(t, db); n != 1 {
+		t.Errorf("Expected 1 committed order, got %d", n)
+	}
+}
+
+func TestTxMiddleware_RollbackOnError(t *testing.T) {
+	db := setupTxDB(t)
+	defer db.Close()
+
+	boom := errors.New("boom")
+	handler := TxMiddleware(db)(HandlerFunc(func(ctx context.Context, msg Message) error {
+		if err := insertOrder(ctx, msg); err != nil {
+			return err
+		}
+		return boom
+	}))
+
+	if err := handler.Handle(context.Background(), NewMessage("orders.created", nil)); !errors.Is(err, boom) {
+		t.Errorf("Expected handler error, got %v", err)
+	}
+	if n := countOrders(t, db); n != 0 {
+		t.Errorf("Expected rollback, got %d orders", n)
+	}
+}
+
+func TestTxMiddleware_RollbackOnPanic(t *testing.T) {
+	db := setupTxDB(t)
+	defer db.Close()
+
+	handler := TxMiddleware(db)(HandlerFunc(func(ctx context.Context, msg Message) error {
+		_ = insertOrder(ctx, msg)
+		panic("handler crashed")
+	}))
+
+	func() {
+		defer func() {
+			if r := recover(); r == nil {
+				t.Error("Expected panic to be re-raised")
+			}
+		}()
+		_ = handler.Handle(context.Background(), NewMessage("orders.created", nil))
+	}()
+
+	if n := countOrders(t, db); n != 0 {
+		t.Errorf("Expected rollback, got %d orders", n)
+	}
+}
+
+func TestTxMiddleware_ReusesExistingTx(t *testing.T) {
+	db := setupTxDB(t)
+	defer db.Close()
+
+	tx, _ := db.Begin()
+	ctx := ContextWithTx(context.Background(), tx)
+
+	handler := TxMiddleware(db)(HandlerFunc(func(ctx context.Context, msg Message) error {
+		if got, _ := TxFromContext(ctx); got != tx {
+			t.Error("Expected the outer transaction to be reused")
+		}
+		return insertOrder(ctx, msg)
+	}))
+	if err := handler.Handle(ctx, NewMessage("orders.created", nil)); err != nil {
+		t.Fatalf("Handle() error = %v", err)
+	}
+
+	// Still owned by the caller
+	_ = tx.Rollback()
+	if n := countOrders(t, db); n != 0 {
+		t.Errorf("Expected caller rollback to discard the insert, got %d orders", n)
+	}
+}
@@ -0,0 +1,119 @@
+package scela
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// ErrPayloadType is returned when a payload cannot be converted to the type
+// a typed handler expects.
+var ErrPayloadType = errors.New("unexpected payload type")
+
+// Validator is implemented by payloads that can check their own contents.
+// Typed publishing and subscribing reject payloads whose Validate fails.
+type Validator interface {
+	Validate() error
+}
+
+// TypeMismatchHandler handles a message whose payload a typed subscription
+// could not convert or validate. Its result is returned to the bus in place
+// of the handler's.
+type TypeMismatchHandler func(ctx context.Context, msg Message, err error) error
+
+// WithTypeMismatchHandler routes the messages a SubscribeTyped handler
+// cannot accept to fn. Without it the conversion error is returned to the
+// bus and follows the usual retry and dead-letter handling.
+func WithTypeMismatchHandler(fn TypeMismatchHandler) SubscriptionOption {
+	return func(c *subscriptionConfig) {
+		c.onTypeMismatch = fn
+	}
+}
+
+// SubscribeTyped subscribes fn to pattern, converting each payload to T with
+// PayloadAs. The message itself is available through MessageFromContext.
+func SubscribeTyped[T any](b Subscriber, pattern string, fn func(ctx context.Context, payload T) error, opts ...SubscriptionOption) (Subscription, error) {
+	var cfg subscriptionConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	return b.SubscribeWithOptions(pattern, HandlerFunc(func(ctx context.Context, msg Message) error {
+		payload, err := PayloadAs[T](msg.Payload())
+		if err != nil {
+			err = fmt.Errorf("message %s on %s: %w", msg.ID(), msg.Topic(), err)
+			if cfg.onTypeMismatch != nil {
+				return cfg.onTypeMismatch(ctx, msg, err)
+			}
+			return err
+		}
+		return fn(ctx, payload)
+	}), opts...)
+}
+
+// PublishTyped validates payload and publishes it asynchronously on topic.
+func PublishTyped[T any](ctx context.Context, b Publisher, topic string, payload T) error {
+	if err := validatePayload(&payload); err != nil {
+		return err
+	}
+	return b.Publish(ctx, topic, payload)
+}
+
+// PayloadAs converts a message payload to T. The payload may be a T, a
+// non-nil *T, or its JSON-decoded form (as produced by serializers and
+// persistent stores), which is converted through JSON. Payloads implementing
+// Validator are validated. Errors wrap ErrPayloadType or the validation
+// error.
+func PayloadAs[T any](payload interface{}) (T, error) {
+	var value T
+
+	switch p := payload.(type) {
+	case T:
+		value = p
+	case *T:
+		if p == nil {
+			return value, fmt.Errorf("%w: nil %T", ErrPayloadType, payload)
+		}
+		value = *p
+	case []byte:
+		if err := json.Unmarshal(p, &value); err != nil {
+			return value, fmt.Errorf("%w: cannot decode %T: %v", ErrPayloadType, value, err)
+		}
+	case json.RawMessage:
+		if err := json.Unmarshal(p, &value); err != nil {
+			return value, fmt.Errorf("%w: cannot decode %T: %v", ErrPayloadType, value, err)
+		}
+	case map[string]interface{}, []interface{}, float64, json.Number:
+		data, err := json.Marshal(p)
+		if err != nil {
+			return value, fmt.Errorf("%w: %v", ErrPayloadType, err)
+		}
+		if err := json.Unmarshal(data, &value); err != nil {
+			return value, fmt.Errorf("%w: cannot convert %T to %T: %v", ErrPayloadType, payload, value, err)
+		}
+	default:
+		return value, fmt.Errorf("%w: got %T, want %T", ErrPayloadType, payload, value)
+	}
+
+	if err := validatePayload(&value); err != nil {
+		return value, err
+	}
+	return value, nil
+}
+
+// validatePayload runs Validate on *payload, or on payload itself for
+// pointer receivers, if implemented.
+func validatePayload[T any](payload *T) error {
+	v, ok := interface{}(*payload).(Validator)
+	if !ok {
+		v, ok = interface{}(payload).(Validator)
+	}
+	if !ok {
+		return nil
+	}
+	if err := v.Validate(); err != nil {
+		return fmt.Errorf("invalid payload: %w", err)
+	}
+	return nil
+}
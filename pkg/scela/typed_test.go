@@ -0,0 +1,124 @@
+package scela
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+type orderCreated struct {
+	ID     string  `json:"id"`
+	Amount float64 `json:"amount"`
+}
+
+func (o orderCreated) Validate() error {
+	if o.ID == "" {
+		return errors.New("missing order ID")
+	}
+	return nil
+}
+
+func TestSubscribeTyped(t *testing.T) {
+	bus := New()
+	defer bus.Close()
+
+	var got orderCreated
+	_, err := SubscribeTyped(bus, "order.created", func(ctx context.Context, o orderCreated) error {
+		got = o
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("SubscribeTyped: %v", err)
+	}
+
+	if err := bus.PublishSync(context.Background(), "order.created", orderCreated{ID: "o-1", Amount: 9.5}); err != nil {
+		t.Fatalf("PublishSync: %v", err)
+	}
+	if got.ID != "o-1" || got.Amount != 9.5 {
+		t.Errorf("unexpected payload %+v", got)
+	}
+
+	// Pointers and decoded payloads (as loaded from a store) are converted
+	_ = bus.PublishSync(context.Background(), "order.created", &orderCreated{ID: "o-2"})
+	if got.ID != "o-2" {
+		t.Errorf("expected pointer payload to be converted, got %+v", got)
+	}
+	_ = bus.PublishSync(context.Background(), "order.created", map[string]interface{}{"id": "o-3", "amount": 1.0})
+	if got.ID != "o-3" {
+		t.Errorf("expected decoded payload to be converted, got %+v", got)
+	}
+}
+
+func TestSubscribeTyped_Mismatch(t *testing.T) {
+	bus := New()
+	defer bus.Close()
+
+	called := false
+	_, _ = SubscribeTyped(bus, "order.created", func(ctx context.Context, o orderCreated) error {
+		called = true
+		return nil
+	})
+
+	err := bus.PublishSync(context.Background(), "order.created", "not an order")
+	if !errors.Is(err, ErrPayloadType) {
+		t.Errorf("expected ErrPayloadType, got %v", err)
+	}
+	err = bus.PublishSync(context.Background(), "order.created", orderCreated{})
+	if err == nil || errors.Is(err, ErrPayloadType) {
+		t.Errorf("expected a validation error, got %v", err)
+	}
+	if called {
+		t.Error("handler should not run for rejected payloads")
+	}
+}
+
+func TestSubscribeTyped_MismatchHandler(t *testing.T) {
+	bus := New()
+	defer bus.Close()
+
+	var rejected []string
+	_, _ = SubscribeTyped(bus, "order.*", func(ctx context.Context, o orderCreated) error {
+		return nil
+	}, WithTypeMismatchHandler(func(ctx context.Context, msg Message, err error) error {
+		rejected = append(rejected, msg.Topic())
+		return nil
+	}))
+
+	if err := bus.PublishSync(context.Background(), "order.created", 42); err != nil {
+		t.Errorf("expected the mismatch handler's result, got %v", err)
+	}
+	if len(rejected) != 1 || rejected[0] != "order.created" {
+		t.Errorf("expected the mismatch to be routed to the hook, got %v", rejected)
+	}
+}
+
+func TestPublishTyped_Validates(t *testing.T) {
+	bus := New()
+	defer bus.Close()
+
+	if err := PublishTyped(context.Background(), bus, "order.created", orderCreated{}); err == nil {
+		t.Error("expected invalid payload to be rejected")
+	}
+	if err := PublishTyped(context.Background(), bus, "order.created", orderCreated{ID: "o-1"}); err != nil {
+		t.Errorf("PublishTyped: %v", err)
+	}
+}
+
+func TestPayloadAs(t *testing.T) {
+	if n, err := PayloadAs[int](float64(3)); err != nil || n != 3 {
+		t.Errorf("expected 3, got %d, %v", n, err)
+	}
+	if _, err := PayloadAs[int](3.5); !errors.Is(err, ErrPayloadType) {
+		t.Errorf("expected ErrPayloadType for a fractional number, got %v", err)
+	}
+	if o, err := PayloadAs[orderCreated]([]byte(`{"id":"o-9"}`)); err != nil || o.ID != "o-9" {
+		t.Errorf("expected JSON bytes to decode, got %+v, %v", o, err)
+	}
+	if b, err := PayloadAs[[]byte]([]byte("raw")); err != nil || string(b) != "raw" {
+		t.Errorf("expected []byte payload to pass through, got %q, %v", b, err)
+	}
+	var nilOrder *orderCreated
+	if _, err := PayloadAs[orderCreated](nilOrder); !errors.Is(err, ErrPayloadType) {
+		t.Errorf("expected ErrPayloadType for a nil pointer, got %v", err)
+	}
+}
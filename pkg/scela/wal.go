@@ -0,0 +1,845 @@
+package scela
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrWALCorrupt is returned when a write-ahead log holds a damaged record
+// that is not the last one written, and so cannot be a torn write.
+var ErrWALCorrupt = errors.New("write-ahead log is corrupt")
+
+// WALStore persists messages to a write-ahead log: a directory of
+// append-only segment files holding checksummed binary records. Storing a
+// message appends one record to the active segment, and so do marking
+// messages delivered and removing them with ClearBefore or PurgeExpired.
+// A new segment is started once the active one reaches the segment size.
+//
+// Checkpoint writes the live messages to a new segment and deletes the
+// segments before it, so that the log does not grow without bound. On
+// opening, the log is replayed from the last checkpoint into an in-memory
+// index of the messages; a record left incomplete or damaged by a crash at
+// the end of the log is discarded, while damage anywhere else fails with
+// ErrWALCorrupt. Payloads are stored as JSON and come back as generic JSON
+// values, like with FileStore.
+type WALStore struct {
+	dir                string
+	segmentSize        int64
+	sync               bool
+	checkpointInterval time.Duration
+	onError            StoreErrorHandler
+
+	mu sync.Mutex
+	// segments are the IDs of the segment files, in order; the last one is
+	// active and open for appending as file.
+	segments    []uint64
+	file        *os.File
+	activeBytes int64
+	size        int64
+	// records counts the records written since the last checkpoint, the
+	// checkpoint record aside.
+	records   int
+	messages  []Message
+	index     map[string]int
+	delivered map[string]bool
+	closed    bool
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// WALOption is a functional option for configuring a write-ahead log
+// store.
+type WALOption func(*WALStore)
+
+// WithWALSegmentSize sets the size in bytes after which a new segment is
+// started. It defaults to 16 MiB.
+func WithWALSegmentSize(n int64) WALOption {
+	return func(s *WALStore) {
+		if n > 0 {
+			s.segmentSize = n
+		}
+	}
+}
+
+// WithWALSync makes every write wait for the segment to be synced to disk,
+// so that stored messages survive a power loss, at the cost of latency.
+func WithWALSync() WALOption {
+	return func(s *WALStore) {
+		s.sync = true
+	}
+}
+
+// WithWALCheckpoint checkpoints the log every interval, if messages were
+// removed or delivered since the last checkpoint.
+func WithWALCheckpoint(interval time.Duration) WALOption {
+	return func(s *WALStore) {
+		s.checkpointInterval = interval
+	}
+}
+
+// WithWALErrorHandler registers a handler called when a background
+// checkpoint fails.
+func WithWALErrorHandler(handler StoreErrorHandler) WALOption {
+	return func(s *WALStore) {
+		s.onError = handler
+	}
+}
+
+// defaultWALSegmentSize is the segment size of a WALStore.
+const defaultWALSegmentSize = 16 << 20
+
+// walRecordKind is the kind of a write-ahead log record.
+type walRecordKind byte
+
+const (
+	// walStore records a stored message.
+	walStore walRecordKind = iota + 1
+	// walDelivered records messages marked delivered, by ID.
+	walDelivered
+	// walRemove records removed messages, by ID.
+	walRemove
+	// walCheckpoint starts a segment holding all live messages, making the
+	// segments before it obsolete.
+	walCheckpoint
+)
+
+// walHeaderSize is the size of a record header: the body length and the
+// CRC-32C of the kind and body, both big endian, then the kind.
+const walHeaderSize = 9
+
+// walTable is the CRC table of the record checksums.
+var walTable = crc32.MakeTable(crc32.Castagnoli)
+
+// walMessage is the body of a walStore record.
+type walMessage struct {
+	ID        string                 `json:"id"`
+	Topic     string                 `json:"topic"`
+	Payload   json.RawMessage        `json:"payload,omitempty"`
+	Metadata  map[string]interface{} `json:"metadata,omitempty"`
+	Timestamp time.Time              `json:"timestamp"`
+	Priority  Priority               `json:"priority,omitempty"`
+	Delivered bool                   `json:"delivered,omitempty"`
+}
+
+// NewWALStore opens the write-ahead log in dir, creating the directory if
+// needed, and replays it.
+func NewWALStore(dir string, opts ...WALOption) (*WALStore, error) {
+	s := &WALStore{
+		dir:         dir,
+		segmentSize: defaultWALSegmentSize,
+		index:       make(map[string]int),
+		delivered:   make(map[string]bool),
+		done:        make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create %s: %w", dir, err)
+	}
+	if err := s.recover(); err != nil {
+		return nil, err
+	}
+
+	if s.checkpointInterval > 0 {
+		s.wg.Add(1)
+		go s.run()
+	}
+
+	return s, nil
+}
+
+// segmentPath returns the path of the segment with the given ID.
+func (s *WALStore) segmentPath(id uint64) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%016x.wal", id))
+}
+
+// recover replays the segments from the last checkpoint and opens the
+// last one for appending. Segments made obsolete by a checkpoint, and
+// checkpoints interrupted before they were complete, are deleted.
+func (s *WALStore) recover() error {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", s.dir, err)
+	}
+	var ids []uint64
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasSuffix(name, ".tmp") {
+			_ = os.Remove(filepath.Join(s.dir, name))
+			continue
+		}
+		if id, err := strconv.ParseUint(strings.TrimSuffix(name, ".wal"), 16, 64); err == nil && strings.HasSuffix(name, ".wal") {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	// Replay from the last checkpoint
+	start := 0
+	for i := len(ids) - 1; i > 0; i-- {
+		if s.isCheckpoint(ids[i]) {
+			start = i
+			break
+		}
+	}
+	for _, id := range ids[:start] {
+		if err := os.Remove(s.segmentPath(id)); err != nil {
+			return fmt.Errorf("failed to remove obsolete segment: %w", err)
+		}
+	}
+	ids = ids[start:]
+
+	for i, id := range ids {
+		if err := s.replay(id, i == len(ids)-1); err != nil {
+			return err
+		}
+	}
+	if len(ids) == 0 {
+		ids = []uint64{1}
+	}
+	s.segments = ids
+
+	active := s.segmentPath(ids[len(ids)-1])
+	file, err := os.OpenFile(active, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", active, err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return fmt.Errorf("failed to stat %s: %w", active, err)
+	}
+	s.file = file
+	s.activeBytes = info.Size()
+	return nil
+}
+
+// isCheckpoint reports whether the segment with the given ID starts with a
+// checkpoint record.
+func (s *WALStore) isCheckpoint(id uint64) bool {
+	file, err := os.Open(s.segmentPath(id))
+	if err != nil {
+		return false
+	}
+	defer func() { _ = file.Close() }()
+
+	kind, _, err := readWALRecord(bufio.NewReader(file))
+	return err == nil && kind == walCheckpoint
+}
+
+// replay applies the records of a segment. A damaged record at the end of
+// the last segment is a torn write and is cut off.
+func (s *WALStore) replay(id uint64, last bool) error {
+	path := s.segmentPath(id)
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer func() { _ = file.Close() }()
+
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat %s: %w", path, err)
+	}
+
+	reader := bufio.NewReader(file)
+	var offset int64
+	for {
+		kind, body, err := readWALRecord(reader)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		end := offset + int64(walHeaderSize+len(body))
+		if err != nil {
+			// Only the last record written can be torn
+			torn := errors.Is(err, io.ErrUnexpectedEOF) || end == info.Size()
+			if !last || !torn {
+				return fmt.Errorf("%w: %s at offset %d: %v", ErrWALCorrupt, path, offset, err)
+			}
+			if err := file.Truncate(offset); err != nil {
+				return fmt.Errorf("failed to discard incomplete record: %w", err)
+			}
+			break
+		}
+		if err := s.apply(kind, body); err != nil {
+			return fmt.Errorf("%w: %s at offset %d: %v", ErrWALCorrupt, path, offset, err)
+		}
+		offset = end
+		if kind != walCheckpoint {
+			s.records++
+		}
+	}
+
+	s.size += offset
+	return nil
+}
+
+// errWALChecksum is returned for a record whose checksum does not match.
+var errWALChecksum = errors.New("checksum mismatch")
+
+// readWALRecord reads the next record. It returns io.EOF at the end of the
+// segment, an error matching io.ErrUnexpectedEOF if the record is
+// incomplete, and errWALChecksum with the body read if it is damaged.
+func readWALRecord(r io.Reader) (walRecordKind, []byte, error) {
+	var header [walHeaderSize]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return 0, nil, fmt.Errorf("incomplete record header: %w", err)
+		}
+		return 0, nil, err
+	}
+	length := binary.BigEndian.Uint32(header[0:4])
+	sum := binary.BigEndian.Uint32(header[4:8])
+	kind := walRecordKind(header[8])
+
+	// A torn header may claim any length: grow the body as it is read
+	var buf bytes.Buffer
+	if _, err := io.CopyN(&buf, r, int64(length)); err != nil {
+		return 0, nil, fmt.Errorf("incomplete record: %w", io.ErrUnexpectedEOF)
+	}
+	body := buf.Bytes()
+	crc := crc32.Update(crc32.Checksum(header[8:9], walTable), walTable, body)
+	if crc != sum {
+		return 0, body, errWALChecksum
+	}
+	return kind, body, nil
+}
+
+// appendWALRecord appends a record to buf.
+func appendWALRecord(buf *bytes.Buffer, kind walRecordKind, body []byte) {
+	var header [walHeaderSize]byte
+	header[8] = byte(kind)
+	binary.BigEndian.PutUint32(header[0:4], uint32(len(body)))
+	binary.BigEndian.PutUint32(header[4:8], crc32.Update(crc32.Checksum(header[8:9], walTable), walTable, body))
+	buf.Write(header[:])
+	buf.Write(body)
+}
+
+// apply applies a record read from the log to the state in memory.
+func (s *WALStore) apply(kind walRecordKind, body []byte) error {
+	switch kind {
+	case walStore:
+		var rec walMessage
+		if err := json.Unmarshal(body, &rec); err != nil {
+			return err
+		}
+		var payload interface{}
+		if len(rec.Payload) > 0 {
+			if err := json.Unmarshal(rec.Payload, &payload); err != nil {
+				return fmt.Errorf("failed to decode payload: %w", err)
+			}
+		}
+		msg := &message{
+			id:        rec.ID,
+			topic:     rec.Topic,
+			payload:   payload,
+			metadata:  rec.Metadata,
+			timestamp: rec.Timestamp,
+			priority:  rec.Priority,
+		}
+		if msg.metadata == nil {
+			msg.metadata = make(map[string]interface{})
+		}
+		s.put(msg)
+		if rec.Delivered {
+			s.delivered[msg.id] = true
+		}
+	case walDelivered, walRemove:
+		var ids []string
+		if err := json.Unmarshal(body, &ids); err != nil {
+			return err
+		}
+		if kind == walDelivered {
+			for _, id := range ids {
+				s.delivered[id] = true
+			}
+		} else {
+			s.remove(ids)
+		}
+	case walCheckpoint:
+		// The messages of the checkpoint follow
+	default:
+		return fmt.Errorf("unknown record kind %d", kind)
+	}
+	return nil
+}
+
+// put adds msg to the messages, replacing a message with the same ID.
+func (s *WALStore) put(msg Message) {
+	if i, ok := s.index[msg.ID()]; ok {
+		s.messages[i] = msg
+		return
+	}
+	s.index[msg.ID()] = len(s.messages)
+	s.messages = append(s.messages, msg)
+}
+
+// remove removes the messages with the given IDs.
+func (s *WALStore) remove(ids []string) {
+	removed := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		removed[id] = true
+		delete(s.delivered, id)
+	}
+
+	kept := s.messages[:0]
+	for _, msg := range s.messages {
+		if !removed[msg.ID()] {
+			kept = append(kept, msg)
+		}
+	}
+	s.messages = kept
+	s.reindex()
+}
+
+// reindex rebuilds the index of the messages by ID.
+func (s *WALStore) reindex() {
+	s.index = make(map[string]int, len(s.messages))
+	for i, msg := range s.messages {
+		s.index[msg.ID()] = i
+	}
+}
+
+// encodeMessage appends the record of msg to buf.
+func (s *WALStore) encodeMessage(buf *bytes.Buffer, msg Message) error {
+	payload, err := json.Marshal(msg.Payload())
+	if err != nil {
+		return fmt.Errorf("failed to serialize payload: %w", err)
+	}
+	body, err := json.Marshal(walMessage{
+		ID:        msg.ID(),
+		Topic:     msg.Topic(),
+		Payload:   payload,
+		Metadata:  msg.Metadata(),
+		Timestamp: msg.Timestamp(),
+		Priority:  MessagePriority(msg),
+		Delivered: s.delivered[msg.ID()],
+	})
+	if err != nil {
+		return fmt.Errorf("failed to serialize record: %w", err)
+	}
+	appendWALRecord(buf, walStore, body)
+	return nil
+}
+
+// encodeIDs appends a record of the given kind listing ids to buf.
+func encodeIDs(buf *bytes.Buffer, kind walRecordKind, ids []string) error {
+	body, err := json.Marshal(ids)
+	if err != nil {
+		return fmt.Errorf("failed to serialize record: %w", err)
+	}
+	appendWALRecord(buf, kind, body)
+	return nil
+}
+
+// appendRecords writes n encoded records at the end of the active segment,
+// starting a new segment first if the active one is full. A failed write
+// is cut off, so that later records are not read as part of it. Must be
+// called with the lock held.
+func (s *WALStore) appendRecords(buf *bytes.Buffer, n int) error {
+	if s.closed {
+		return ErrStoreClosed
+	}
+	if s.activeBytes >= s.segmentSize {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+
+	written, err := s.file.Write(buf.Bytes())
+	if err == nil && s.sync {
+		err = s.file.Sync()
+	}
+	if err != nil {
+		if written > 0 {
+			_ = s.file.Truncate(s.activeBytes)
+		}
+		return fmt.Errorf("failed to append to %s: %w", s.file.Name(), err)
+	}
+
+	s.activeBytes += int64(written)
+	s.size += int64(written)
+	s.records += n
+	return nil
+}
+
+// rotate starts a new active segment. Must be called with the lock held.
+func (s *WALStore) rotate() error {
+	id := s.segments[len(s.segments)-1] + 1
+	file, err := os.OpenFile(s.segmentPath(id), os.O_WRONLY|os.O_APPEND|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to start segment: %w", err)
+	}
+	if err := s.file.Sync(); err != nil {
+		_ = file.Close()
+		_ = os.Remove(file.Name())
+		return fmt.Errorf("failed to sync segment: %w", err)
+	}
+	_ = s.file.Close()
+	s.file = file
+	s.activeBytes = 0
+	s.segments = append(s.segments, id)
+	return nil
+}
+
+// Store implements MessageStore. The message is appended as a single
+// record. Storing a message with the ID of a stored one replaces it.
+func (s *WALStore) Store(ctx context.Context, msg Message) error {
+	return s.StoreBatch(ctx, []Message{msg})
+}
+
+// StoreBatch implements BatchStore. The batch is appended with a single
+// write.
+func (s *WALStore) StoreBatch(ctx context.Context, msgs []Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var buf bytes.Buffer
+	for _, msg := range msgs {
+		if err := s.encodeMessage(&buf, msg); err != nil {
+			return err
+		}
+	}
+	if err := s.appendRecords(&buf, len(msgs)); err != nil {
+		return err
+	}
+	for _, msg := range msgs {
+		s.put(msg)
+	}
+	return nil
+}
+
+// MarkDelivered implements DeliveryStore. The IDs are appended as a single
+// record.
+func (s *WALStore) MarkDelivered(ctx context.Context, ids ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Unknown and already delivered messages need no record
+	var marked []string
+	for _, id := range ids {
+		if _, stored := s.index[id]; stored && !s.delivered[id] {
+			marked = append(marked, id)
+		}
+	}
+	if len(marked) == 0 {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	if err := encodeIDs(&buf, walDelivered, marked); err != nil {
+		return err
+	}
+	if err := s.appendRecords(&buf, 1); err != nil {
+		return err
+	}
+	for _, id := range marked {
+		s.delivered[id] = true
+	}
+	return nil
+}
+
+// removeMatching appends a record removing the messages matching match,
+// and returns how many there were. Must be called with the lock held.
+func (s *WALStore) removeMatching(match func(Message) bool) (int, error) {
+	var ids []string
+	for _, msg := range s.messages {
+		if match(msg) {
+			ids = append(ids, msg.ID())
+		}
+	}
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	var buf bytes.Buffer
+	if err := encodeIDs(&buf, walRemove, ids); err != nil {
+		return 0, err
+	}
+	if err := s.appendRecords(&buf, 1); err != nil {
+		return 0, err
+	}
+	s.remove(ids)
+	return len(ids), nil
+}
+
+// LoadPending implements DeliveryStore.
+func (s *WALStore) LoadPending(ctx context.Context) ([]Message, error) {
+	return s.query(func(msg Message) bool { return !s.delivered[msg.ID()] }), nil
+}
+
+// Load implements MessageStore.
+func (s *WALStore) Load(ctx context.Context) ([]Message, error) {
+	return s.query(nil), nil
+}
+
+// LoadByTopic implements QueryableStore.
+func (s *WALStore) LoadByTopic(ctx context.Context, topic string) ([]Message, error) {
+	return s.query(func(msg Message) bool { return msg.Topic() == topic }), nil
+}
+
+// LoadAfter implements QueryableStore.
+func (s *WALStore) LoadAfter(ctx context.Context, after time.Time) ([]Message, error) {
+	return s.query(func(msg Message) bool { return msg.Timestamp().After(after) }), nil
+}
+
+// LoadPage implements QueryableStore.
+func (s *WALStore) LoadPage(ctx context.Context, offset, limit int) ([]Message, error) {
+	if offset < 0 || limit < 0 {
+		return nil, fmt.Errorf("invalid page: offset %d, limit %d", offset, limit)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if offset >= len(s.messages) {
+		return []Message{}, nil
+	}
+	end := len(s.messages)
+	if limit < end-offset {
+		end = offset + limit
+	}
+	return append([]Message(nil), s.messages[offset:end]...), nil
+}
+
+// Count implements QueryableStore.
+func (s *WALStore) Count(ctx context.Context) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.messages), nil
+}
+
+// query returns the stored messages matching match, all if nil.
+func (s *WALStore) query(match func(Message) bool) []Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	result := make([]Message, 0, len(s.messages))
+	for _, msg := range s.messages {
+		if match == nil || match(msg) {
+			result = append(result, msg)
+		}
+	}
+	return result
+}
+
+// ClearBefore implements QueryableStore. The removal is appended as a
+// single record.
+func (s *WALStore) ClearBefore(ctx context.Context, before time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, err := s.removeMatching(func(msg Message) bool { return msg.Timestamp().Before(before) })
+	return err
+}
+
+// PurgeExpired implements ExpiringStore. The removal is appended as a
+// single record.
+func (s *WALStore) PurgeExpired(ctx context.Context, now time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.removeMatching(func(msg Message) bool { return IsExpired(msg, now) })
+}
+
+// Rewrite implements RewritableStore. The rewritten messages are written
+// as a checkpoint.
+func (s *WALStore) Rewrite(ctx context.Context, fn func([]Message) ([]Message, error)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rewritten, err := fn(append([]Message(nil), s.messages...))
+	if err != nil {
+		return err
+	}
+	return s.checkpointTo(rewritten)
+}
+
+// Clear implements MessageStore. It writes an empty checkpoint.
+func (s *WALStore) Clear(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.checkpointTo(nil)
+}
+
+// Checkpoint writes the live messages to a new segment and deletes the
+// segments before it. It does nothing if the log holds nothing else.
+func (s *WALStore) Checkpoint(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.segments) == 1 && s.records == len(s.messages) {
+		return nil
+	}
+	return s.checkpointTo(s.messages)
+}
+
+// checkpointTo writes messages as a checkpoint segment, makes it the active
+// segment and deletes the segments before it. The checkpoint is written to
+// a temporary file and renamed into place once synced, so a crash leaves
+// either the old segments or the complete checkpoint. Must be called with
+// the lock held.
+func (s *WALStore) checkpointTo(messages []Message) error {
+	if s.closed {
+		return ErrStoreClosed
+	}
+
+	kept := make(map[string]bool, len(messages))
+	for _, msg := range messages {
+		kept[msg.ID()] = true
+	}
+	for id := range s.delivered {
+		if !kept[id] {
+			delete(s.delivered, id)
+		}
+	}
+
+	var buf bytes.Buffer
+	appendWALRecord(&buf, walCheckpoint, nil)
+	for _, msg := range messages {
+		if err := s.encodeMessage(&buf, msg); err != nil {
+			return err
+		}
+	}
+
+	id := s.segments[len(s.segments)-1] + 1
+	path := s.segmentPath(id)
+	if err := writeSyncedFile(s.dir, path, buf.Bytes()); err != nil {
+		return fmt.Errorf("failed to write checkpoint: %w", err)
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", path, err)
+	}
+
+	// The checkpoint is durable: the previous segments are obsolete
+	_ = s.file.Close()
+	for _, old := range s.segments {
+		_ = os.Remove(s.segmentPath(old))
+	}
+	s.file = file
+	s.segments = []uint64{id}
+	s.activeBytes = int64(buf.Len())
+	s.size = s.activeBytes
+	s.records = len(messages)
+	s.messages = append([]Message(nil), messages...)
+	s.reindex()
+	return nil
+}
+
+// writeSyncedFile writes data to path through a temporary file in dir,
+// synced to disk before it is renamed into place.
+func writeSyncedFile(dir, path string, data []byte) error {
+	tmp, err := os.CreateTemp(dir, "checkpoint-*.tmp")
+	if err != nil {
+		return err
+	}
+	tmpName := tmp.Name()
+
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpName, path)
+	}
+	if err != nil {
+		_ = os.Remove(tmpName)
+		return err
+	}
+
+	// Make the rename itself durable, where directories can be synced
+	if d, err := os.Open(dir); err == nil {
+		_ = d.Sync()
+		_ = d.Close()
+	}
+	return nil
+}
+
+// run checkpoints the log every interval until the store is closed.
+func (s *WALStore) run() {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(s.checkpointInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			ctx := context.Background()
+			if err := s.Checkpoint(ctx); err != nil && s.onError != nil {
+				s.onError(ctx, &StoreError{Op: "checkpoint", Err: err})
+			}
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// Stats implements StoreStats. SizeBytes is the size of the segments.
+func (s *WALStore) Stats(ctx context.Context) (StoreStatistics, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats := computeStoreStatistics(s.messages)
+	stats.SizeBytes = s.size
+	return stats, nil
+}
+
+// Segments returns the number of segment files of the log.
+func (s *WALStore) Segments() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.segments)
+}
+
+// Flush implements StoreFlusher. It syncs the active segment to disk,
+// which WithWALSync does on every write.
+func (s *WALStore) Flush(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return ErrStoreClosed
+	}
+	return s.file.Sync()
+}
+
+// Close implements MessageStore. It stops the background checkpoints and
+// closes the active segment.
+func (s *WALStore) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	s.mu.Unlock()
+
+	close(s.done)
+	s.wg.Wait()
+	return s.file.Close()
+}
@@ -0,0 +1,259 @@
+package scela
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+)
+
+// openWAL opens the write-ahead log in dir, closing it at the end of the
+// test.
+func openWAL(t *testing.T, dir string, opts ...WALOption) *WALStore {
+	t.Helper()
+	store, err := NewWALStore(dir, opts...)
+	if err != nil {
+		t.Fatalf("NewWALStore() error = %v", err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+	return store
+}
+
+// segmentFiles returns the paths of the segment files in dir, in order.
+func segmentFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	paths, err := filepath.Glob(filepath.Join(dir, "*.wal"))
+	if err != nil {
+		t.Fatalf("Glob() error = %v", err)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// storeNumbers stores messages with the payloads from to to-1 on topic.
+func storeNumbers(t *testing.T, store MessageStore, topic string, from, to int) {
+	t.Helper()
+	for i := from; i < to; i++ {
+		if err := store.Store(context.Background(), NewMessage(topic, i)); err != nil {
+			t.Fatalf("Store() error = %v", err)
+		}
+	}
+}
+
+func TestWALStore_PreservesMessages(t *testing.T) {
+	dir := t.TempDir()
+	store := openWAL(t, dir)
+	ctx := context.Background()
+
+	msg := NewMessageWithPriority("orders", map[string]interface{}{"id": "o-1"}, PriorityHigh).(*message)
+	msg.metadata["tenant"] = "acme"
+	msg.timestamp = time.Date(2024, 5, 1, 12, 30, 0, 123456789, time.UTC)
+	other := NewMessage("orders", "o-2")
+	if err := store.StoreBatch(ctx, []Message{msg, other}); err != nil {
+		t.Fatalf("StoreBatch() error = %v", err)
+	}
+	if err := store.MarkDelivered(ctx, other.ID()); err != nil {
+		t.Fatalf("MarkDelivered() error = %v", err)
+	}
+	_ = store.Close()
+
+	reopened := openWAL(t, dir)
+	msgs, _ := reopened.Load(ctx)
+	if len(msgs) != 2 {
+		t.Fatalf("expected 2 messages after reopening, got %d", len(msgs))
+	}
+	got := msgs[0]
+	if got.ID() != msg.ID() || got.Topic() != "orders" || MessagePriority(got) != PriorityHigh {
+		t.Errorf("unexpected message %s on %s with priority %v", got.ID(), got.Topic(), MessagePriority(got))
+	}
+	if !got.Timestamp().Equal(msg.Timestamp()) {
+		t.Errorf("timestamp = %v, want %v", got.Timestamp(), msg.Timestamp())
+	}
+	if got.Metadata()["tenant"] != "acme" {
+		t.Errorf("expected metadata preserved, got %v", got.Metadata())
+	}
+
+	pending, _ := reopened.LoadPending(ctx)
+	if len(pending) != 1 || pending[0].ID() != msg.ID() {
+		t.Errorf("expected only %s pending, got %v", msg.ID(), pending)
+	}
+}
+
+func TestWALStore_SegmentsAndCheckpoint(t *testing.T) {
+	dir := t.TempDir()
+	store := openWAL(t, dir, WithWALSegmentSize(256))
+	ctx := context.Background()
+
+	storeNumbers(t, store, "numbers", 0, 20)
+	if n := len(segmentFiles(t, dir)); n < 3 {
+		t.Fatalf("expected the log split in segments, got %d", n)
+	}
+
+	cutoff := time.Now().Add(time.Hour)
+	if err := store.ClearBefore(ctx, cutoff); err != nil {
+		t.Fatalf("ClearBefore() error = %v", err)
+	}
+	storeNumbers(t, store, "numbers", 20, 22)
+
+	if err := store.Checkpoint(ctx); err != nil {
+		t.Fatalf("Checkpoint() error = %v", err)
+	}
+	if files := segmentFiles(t, dir); len(files) != 1 || store.Segments() != 1 {
+		t.Fatalf("expected the old segments truncated, got %v", files)
+	}
+	stats, _ := store.Stats(ctx)
+	if stats.MessageCount != 2 {
+		t.Errorf("expected 2 messages, got %d", stats.MessageCount)
+	}
+	_ = store.Close()
+
+	msgs, _ := openWAL(t, dir).Load(ctx)
+	if len(msgs) != 2 || msgs[0].Payload() != float64(20) || msgs[1].Payload() != float64(21) {
+		t.Errorf("unexpected messages after checkpoint %v", msgs)
+	}
+}
+
+func TestWALStore_RecoversTornWrite(t *testing.T) {
+	dir := t.TempDir()
+	store := openWAL(t, dir)
+	storeNumbers(t, store, "numbers", 0, 3)
+	_ = store.Close()
+
+	// A crash in the middle of the last write
+	path := segmentFiles(t, dir)[0]
+	info, _ := os.Stat(path)
+	if err := os.Truncate(path, info.Size()-5); err != nil {
+		t.Fatalf("Truncate() error = %v", err)
+	}
+
+	reopened := openWAL(t, dir)
+	ctx := context.Background()
+	msgs, _ := reopened.Load(ctx)
+	if len(msgs) != 2 {
+		t.Fatalf("expected the torn message discarded, got %d messages", len(msgs))
+	}
+
+	// Records written after recovery are readable
+	storeNumbers(t, reopened, "numbers", 3, 4)
+	_ = reopened.Close()
+	msgs, _ = openWAL(t, dir).Load(ctx)
+	if len(msgs) != 3 || msgs[2].Payload() != float64(3) {
+		t.Errorf("unexpected messages after recovery %v", msgs)
+	}
+}
+
+func TestWALStore_DetectsCorruption(t *testing.T) {
+	dir := t.TempDir()
+	store := openWAL(t, dir)
+	storeNumbers(t, store, "numbers", 0, 3)
+	_ = store.Close()
+
+	path := segmentFiles(t, dir)[0]
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+
+	// A damaged last record is a torn write
+	torn := append([]byte(nil), data...)
+	torn[len(torn)-2] ^= 0xff
+	if err := os.WriteFile(path, torn, 0o600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	recovered := openWAL(t, dir)
+	if n, _ := recovered.Count(context.Background()); n != 2 {
+		t.Errorf("expected the damaged last record discarded, got %d messages", n)
+	}
+	_ = recovered.Close()
+
+	// A damaged record followed by others is corruption
+	corrupt := append([]byte(nil), data...)
+	corrupt[walHeaderSize+2] ^= 0xff
+	if err := os.WriteFile(path, corrupt, 0o600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	if _, err := NewWALStore(dir); !errors.Is(err, ErrWALCorrupt) {
+		t.Errorf("expected ErrWALCorrupt, got %v", err)
+	}
+}
+
+func TestWALStore_InterruptedCheckpoint(t *testing.T) {
+	dir := t.TempDir()
+	store := openWAL(t, dir, WithWALSegmentSize(128))
+	ctx := context.Background()
+	storeNumbers(t, store, "numbers", 0, 10)
+	if err := store.Checkpoint(ctx); err != nil {
+		t.Fatalf("Checkpoint() error = %v", err)
+	}
+	storeNumbers(t, store, "numbers", 10, 15)
+	_ = store.Close()
+
+	// A crash before the checkpoint was renamed into place leaves a
+	// temporary file; a crash after it, the obsolete segments
+	if err := os.WriteFile(filepath.Join(dir, "checkpoint-1.tmp"), []byte("partial"), 0o600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	obsolete := filepath.Join(dir, fmt.Sprintf("%016x.wal", 0))
+	if err := os.WriteFile(obsolete, []byte("obsolete"), 0o600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	reopened := openWAL(t, dir)
+	msgs, _ := reopened.Load(ctx)
+	if len(msgs) != 15 {
+		t.Fatalf("expected 15 messages, got %d", len(msgs))
+	}
+	for i, msg := range msgs {
+		if msg.Payload() != float64(i) {
+			t.Fatalf("message %d has payload %v", i, msg.Payload())
+		}
+	}
+	if _, err := os.Stat(obsolete); !os.IsNotExist(err) {
+		t.Errorf("expected the obsolete segment removed, got %v", err)
+	}
+	if tmps, _ := filepath.Glob(filepath.Join(dir, "*.tmp")); len(tmps) != 0 {
+		t.Errorf("expected the partial checkpoint removed, got %v", tmps)
+	}
+}
+
+func TestWALStore_PurgeAndRewrite(t *testing.T) {
+	dir := t.TempDir()
+	store := openWAL(t, dir)
+	ctx := context.Background()
+
+	expired := NewMessage("numbers", 0)
+	expired.Metadata()[MetadataExpiresAt] = time.Now().Add(-time.Minute).Format(time.RFC3339Nano)
+	if err := store.Store(ctx, expired); err != nil {
+		t.Fatalf("Store() error = %v", err)
+	}
+	storeNumbers(t, store, "numbers", 1, 4)
+
+	if n, err := store.PurgeExpired(ctx, time.Now()); err != nil || n != 1 {
+		t.Fatalf("PurgeExpired() = %d, %v, want 1", n, err)
+	}
+	err := store.Rewrite(ctx, func(msgs []Message) ([]Message, error) {
+		return msgs[1:], nil
+	})
+	if err != nil {
+		t.Fatalf("Rewrite() error = %v", err)
+	}
+	_ = store.Close()
+
+	msgs, _ := openWAL(t, dir).Load(ctx)
+	if len(msgs) != 2 || msgs[0].Payload() != float64(2) {
+		t.Errorf("unexpected messages %v", msgs)
+	}
+}
+
+func TestWALStore_Closed(t *testing.T) {
+	store := openWAL(t, t.TempDir())
+	_ = store.Close()
+
+	if err := store.Store(context.Background(), NewMessage("topic", 1)); !errors.Is(err, ErrStoreClosed) {
+		t.Errorf("expected ErrStoreClosed, got %v", err)
+	}
+}
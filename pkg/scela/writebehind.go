@@ -0,0 +1,196 @@
+package scela
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrWriteBehindFull is returned when a store using write-behind has too
+// many messages waiting to be written to accept another.
+var ErrWriteBehindFull = errors.New("write-behind buffer is full")
+
+const (
+	// defaultWriteBehindBatch is the largest batch written at once when no
+	// limit is configured.
+	defaultWriteBehindBatch = 100
+	// defaultWriteBehindBuffer is the most messages waiting to be written
+	// when no limit is configured.
+	defaultWriteBehindBuffer = 10000
+)
+
+// WriteBehindStats describes the work of a store using write-behind.
+type WriteBehindStats struct {
+	// Flushes is the number of batches written.
+	Flushes int64
+	// Messages is the number of messages written in batches.
+	Messages int64
+	// Failures is the number of batches that failed and were kept for the
+	// next flush.
+	Failures int64
+	// Buffered is the number of messages waiting to be written.
+	Buffered int
+}
+
+// writeBehind buffers writes and commits them in batches in the
+// background, so that publishers never wait for the database. Unlike
+// groupCommitter, it trades durability for latency: buffered messages are
+// lost if the process crashes before they are flushed.
+type writeBehind struct {
+	interval  time.Duration
+	maxBatch  int
+	maxBuffer int
+
+	// commit writes a batch in one operation.
+	commit  func(ctx context.Context, msgs []Message) error
+	onError StoreErrorHandler
+
+	mu     sync.Mutex
+	buffer []Message
+	closed bool
+	stats  WriteBehindStats
+
+	// flushMu serializes flushes, so that batches are written in order.
+	flushMu sync.Mutex
+	kick    chan struct{}
+	done    chan struct{}
+	wg      sync.WaitGroup
+}
+
+// newWriteBehind starts flushing the writes every interval, at most
+// maxBatch at a time (defaultWriteBehindBatch if zero or less), holding at
+// most maxBuffer waiting writes (defaultWriteBehindBuffer if zero or less).
+func newWriteBehind(
+	interval time.Duration,
+	maxBatch, maxBuffer int,
+	commit func(ctx context.Context, msgs []Message) error,
+	onError StoreErrorHandler,
+) *writeBehind {
+	if maxBatch <= 0 {
+		maxBatch = defaultWriteBehindBatch
+	}
+	if maxBuffer <= 0 {
+		maxBuffer = defaultWriteBehindBuffer
+	}
+	w := &writeBehind{
+		interval:  interval,
+		maxBatch:  maxBatch,
+		maxBuffer: maxBuffer,
+		commit:    commit,
+		onError:   onError,
+		kick:      make(chan struct{}, 1),
+		done:      make(chan struct{}),
+	}
+
+	w.wg.Add(1)
+	go w.run()
+
+	return w
+}
+
+// write buffers msg. Once closed, msg is written at once instead.
+func (w *writeBehind) write(ctx context.Context, msg Message) error {
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return w.commit(ctx, []Message{msg})
+	}
+	if len(w.buffer) >= w.maxBuffer {
+		w.mu.Unlock()
+		return ErrWriteBehindFull
+	}
+	w.buffer = append(w.buffer, msg)
+	full := len(w.buffer) >= w.maxBatch
+	w.mu.Unlock()
+
+	if full {
+		select {
+		case w.kick <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// run flushes the buffer every interval, or as soon as a batch fills,
+// until closed.
+func (w *writeBehind) run() {
+	defer w.wg.Done()
+
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-w.kick:
+		case <-w.done:
+			return
+		}
+		_ = w.flush(context.Background())
+	}
+}
+
+// flush writes the buffered messages in batches, oldest first. A failed
+// batch stays buffered, ahead of later messages, and is reported.
+func (w *writeBehind) flush(ctx context.Context) error {
+	w.flushMu.Lock()
+	defer w.flushMu.Unlock()
+
+	for {
+		// Only flushes remove messages, so the front of the buffer is stable
+		w.mu.Lock()
+		n := min(len(w.buffer), w.maxBatch)
+		batch := w.buffer[:n:n]
+		w.mu.Unlock()
+
+		if n == 0 {
+			return nil
+		}
+
+		if err := w.commit(ctx, batch); err != nil {
+			w.mu.Lock()
+			w.stats.Failures++
+			w.mu.Unlock()
+
+			storeErr := &StoreError{Op: "write_behind", Err: err}
+			if w.onError != nil {
+				w.onError(ctx, storeErr)
+			}
+			return storeErr
+		}
+
+		w.mu.Lock()
+		w.buffer = w.buffer[n:]
+		w.stats.Flushes++
+		w.stats.Messages += int64(n)
+		w.mu.Unlock()
+	}
+}
+
+// close stops the background flushes and writes the buffered messages,
+// until ctx is done. Later writes are written at once.
+func (w *writeBehind) close(ctx context.Context) error {
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return nil
+	}
+	w.closed = true
+	w.mu.Unlock()
+
+	close(w.done)
+	w.wg.Wait()
+	return w.flush(ctx)
+}
+
+// snapshot returns the statistics so far.
+func (w *writeBehind) snapshot() WriteBehindStats {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	stats := w.stats
+	stats.Buffered = len(w.buffer)
+	return stats
+}
@@ -0,0 +1,169 @@
+package scela
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// setupWriteBehind returns a store using write-behind on a single
+// connection database, so that background flushes share its tables.
+func setupWriteBehind(t *testing.T, config SQLStoreConfig) *SQLStore {
+	t.Helper()
+	db := setupTestDB(t)
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { _ = db.Close() })
+
+	config.DB = db
+	store, err := NewSQLStore(config)
+	if err != nil {
+		t.Fatalf("Failed to create SQL store: %v", err)
+	}
+	return store
+}
+
+func TestWriteBehind_FlushesBufferedMessages(t *testing.T) {
+	store := setupWriteBehind(t, SQLStoreConfig{WriteBehindInterval: time.Hour})
+	ctx := context.Background()
+
+	for i := 0; i < 5; i++ {
+		if err := store.Store(ctx, NewMessage("orders", i)); err != nil {
+			t.Fatalf("Store() error = %v", err)
+		}
+	}
+	if n, _ := store.Count(ctx); n != 0 {
+		t.Fatalf("expected messages buffered until flushed, got %d stored", n)
+	}
+	if stats := store.WriteBehindStats(); stats.Buffered != 5 {
+		t.Errorf("Buffered = %d, want 5", stats.Buffered)
+	}
+
+	if err := store.Flush(ctx); err != nil {
+		t.Fatalf("Flush() error = %v", err)
+	}
+	msgs, _ := store.Load(ctx)
+	got := make([]interface{}, len(msgs))
+	for i, msg := range msgs {
+		got[i] = msg.Payload()
+	}
+	if fmt.Sprint(got) != "[0 1 2 3 4]" {
+		t.Errorf("stored = %v, want the messages in order", got)
+	}
+	if stats := store.WriteBehindStats(); stats.Flushes != 1 || stats.Messages != 5 || stats.Buffered != 0 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+func TestWriteBehind_FlushesFullBatches(t *testing.T) {
+	store := setupWriteBehind(t, SQLStoreConfig{
+		WriteBehindInterval: time.Hour,
+		WriteBehindMaxBatch: 3,
+	})
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		_ = store.Store(ctx, NewMessage("orders", i))
+	}
+	waitFor(t, func() bool { return store.WriteBehindStats().Messages == 3 })
+}
+
+func TestWriteBehind_FlushesEveryInterval(t *testing.T) {
+	store := setupWriteBehind(t, SQLStoreConfig{WriteBehindInterval: 5 * time.Millisecond})
+	_ = store.Store(context.Background(), NewMessage("orders", "o-1"))
+	waitFor(t, func() bool { return store.WriteBehindStats().Messages == 1 })
+}
+
+func TestWriteBehind_BufferLimit(t *testing.T) {
+	store := setupWriteBehind(t, SQLStoreConfig{
+		WriteBehindInterval:  time.Hour,
+		WriteBehindMaxBuffer: 2,
+	})
+	ctx := context.Background()
+
+	_ = store.Store(ctx, NewMessage("orders", 1))
+	_ = store.Store(ctx, NewMessage("orders", 2))
+	if err := store.Store(ctx, NewMessage("orders", 3)); !errors.Is(err, ErrWriteBehindFull) {
+		t.Errorf("Store() error = %v, want ErrWriteBehindFull", err)
+	}
+}
+
+func TestWriteBehind_KeepsFailedBatches(t *testing.T) {
+	var failing atomic.Bool
+	failing.Store(true)
+	var written []interface{}
+	commit := func(ctx context.Context, msgs []Message) error {
+		if failing.Load() {
+			return errors.New("database unavailable")
+		}
+		for _, msg := range msgs {
+			written = append(written, msg.Payload())
+		}
+		return nil
+	}
+
+	var reported atomic.Int32
+	w := newWriteBehind(time.Hour, 2, 0, commit, func(ctx context.Context, err *StoreError) {
+		reported.Add(1)
+	})
+	ctx := context.Background()
+	for i := 0; i < 3; i++ {
+		_ = w.write(ctx, NewMessage("orders", i))
+	}
+	// The first batch filled and was flushed in the background
+	waitFor(t, func() bool { return reported.Load() == 1 })
+
+	var storeErr *StoreError
+	if err := w.flush(ctx); !errors.As(err, &storeErr) || storeErr.Op != "write_behind" {
+		t.Fatalf("flush() error = %v, want a write_behind StoreError", err)
+	}
+	if stats := w.snapshot(); stats.Failures != 2 || stats.Buffered != 3 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+
+	failing.Store(false)
+	if err := w.close(context.Background()); err != nil {
+		t.Fatalf("close() error = %v", err)
+	}
+	if fmt.Sprint(written) != "[0 1 2]" {
+		t.Errorf("written = %v, want the messages in order", written)
+	}
+
+	// Once closed, messages are written at once
+	_ = w.write(ctx, NewMessage("orders", 3))
+	if len(written) != 4 {
+		t.Errorf("expected the message written at once, got %v", written)
+	}
+}
+
+func TestWriteBehind_CloseFlushes(t *testing.T) {
+	store := setupWriteBehind(t, SQLStoreConfig{WriteBehindInterval: time.Hour})
+	ctx := context.Background()
+	_ = store.Store(ctx, NewMessage("orders", "o-1"))
+
+	if err := store.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if n, _ := store.Count(ctx); n != 1 {
+		t.Errorf("expected the buffered message written on close, got %d", n)
+	}
+	if err := store.Close(); err != nil {
+		t.Errorf("expected closing twice to succeed, got %v", err)
+	}
+}
+
+func TestWriteBehind_ExcludesGroupCommit(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	_, err := NewSQLStore(SQLStoreConfig{
+		DB:                  db,
+		GroupCommitWindow:   time.Millisecond,
+		WriteBehindInterval: time.Millisecond,
+	})
+	if err == nil {
+		t.Error("expected group commit and write-behind to be rejected together")
+	}
+}